@@ -0,0 +1,95 @@
+package image
+
+import (
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	// Enables ("enabled") or disables ("disabled") the generation of image targets
+	// in the directory of the BUILD file and below.
+	imagesDirective = "img_images"
+	// Comma-separated kinds of binary rules that get image targets.
+	binaryKindsDirective = "img_binary_kinds"
+	// Label of the base image of generated image_manifest targets.
+	baseDirective = "img_base"
+	// Directory in the image that binaries are stored in.
+	binaryDirDirective = "img_binary_dir"
+	// Registry of generated image_push targets. No image_push targets are generated without a registry.
+	registryDirective = "img_registry"
+	// Prefix of the repository of generated image_push targets. The name of the binary is appended.
+	repositoryPrefixDirective = "img_repository_prefix"
+	// Tag of generated image_push targets.
+	tagDirective = "img_tag"
+)
+
+// imageConfig is the configuration of a directory, inherited by subdirectories.
+type imageConfig struct {
+	enabled          bool
+	binaryKinds      []string
+	base             string
+	binaryDir        string
+	registry         string
+	repositoryPrefix string
+	tag              string
+}
+
+func defaultConfig() *imageConfig {
+	return &imageConfig{
+		binaryKinds: []string{"go_binary", "py_binary"},
+		binaryDir:   "/app/bin",
+	}
+}
+
+func getConfig(c *config.Config) *imageConfig {
+	if cfg, ok := c.Exts[languageName].(*imageConfig); ok {
+		return cfg
+	}
+	return defaultConfig()
+}
+
+func (cfg *imageConfig) clone() *imageConfig {
+	clone := *cfg
+	clone.binaryKinds = slices.Clone(cfg.binaryKinds)
+	return &clone
+}
+
+// apply updates the configuration with the directives of a BUILD file.
+func (cfg *imageConfig) apply(f *rule.File) {
+	for _, d := range f.Directives {
+		value := strings.TrimSpace(d.Value)
+		switch d.Key {
+		case imagesDirective:
+			switch value {
+			case "enabled":
+				cfg.enabled = true
+			case "disabled":
+				cfg.enabled = false
+			default:
+				log.Printf("%s: invalid value for gazelle:%s: %q (expected \"enabled\" or \"disabled\")", f.Path, d.Key, value)
+			}
+		case binaryKindsDirective:
+			cfg.binaryKinds = nil
+			for _, kind := range strings.Split(value, ",") {
+				if kind = strings.TrimSpace(kind); kind != "" {
+					cfg.binaryKinds = append(cfg.binaryKinds, kind)
+				}
+			}
+		case baseDirective:
+			cfg.base = value
+		case binaryDirDirective:
+			cfg.binaryDir = path.Clean("/" + value)
+		case registryDirective:
+			cfg.registry = value
+		case repositoryPrefixDirective:
+			cfg.repositoryPrefix = strings.TrimSuffix(value, "/")
+		case tagDirective:
+			cfg.tag = value
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package image
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   string
+		want    imageConfig
+		wantLog string
+	}{
+		{
+			name:  "defaults",
+			build: "",
+			want: imageConfig{
+				binaryKinds: []string{"go_binary", "py_binary"},
+				binaryDir:   "/app/bin",
+			},
+		},
+		{
+			name: "all directives",
+			build: `# gazelle:img_images enabled
+# gazelle:img_binary_kinds go_binary, ,rust_binary
+# gazelle:img_base @distroless_static
+# gazelle:img_binary_dir opt/app/
+# gazelle:img_registry ghcr.io
+# gazelle:img_repository_prefix my-org/
+# gazelle:img_tag latest
+`,
+			want: imageConfig{
+				enabled:          true,
+				binaryKinds:      []string{"go_binary", "rust_binary"},
+				base:             "@distroless_static",
+				binaryDir:        "/opt/app",
+				registry:         "ghcr.io",
+				repositoryPrefix: "my-org",
+				tag:              "latest",
+			},
+		},
+		{
+			name:  "disabled again",
+			build: "# gazelle:img_images enabled\n# gazelle:img_images disabled\n",
+			want: imageConfig{
+				binaryKinds: []string{"go_binary", "py_binary"},
+				binaryDir:   "/app/bin",
+			},
+		},
+		{
+			name:  "binary dir does not escape the root",
+			build: "# gazelle:img_binary_dir ../../bin\n",
+			want: imageConfig{
+				binaryKinds: []string{"go_binary", "py_binary"},
+				binaryDir:   "/bin",
+			},
+		},
+		{
+			name:  "no binary kinds",
+			build: "# gazelle:img_binary_kinds\n",
+			want: imageConfig{
+				binaryDir: "/app/bin",
+			},
+		},
+		{
+			name:  "invalid value of img_images",
+			build: "# gazelle:img_images yes\n",
+			want: imageConfig{
+				binaryKinds: []string{"go_binary", "py_binary"},
+				binaryDir:   "/app/bin",
+			},
+			wantLog: `BUILD.bazel: invalid value for gazelle:img_images: "yes" (expected "enabled" or "disabled")`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			cfg := defaultConfig()
+			cfg.apply(loadBuildFile(t, tt.build))
+			assertConfig(t, cfg, tt.want)
+			if tt.wantLog == "" && logs.Len() > 0 {
+				t.Errorf("apply() logged %q, want nothing", logs.String())
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("apply() logged %q, want %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	lang := NewLanguage()
+	c := config.New()
+	lang.RegisterFlags(nil, "update", c)
+
+	lang.Configure(c, "", loadBuildFile(t, "# gazelle:img_images enabled\n# gazelle:img_registry ghcr.io\n"))
+	root := getConfig(c)
+
+	// subdirectories inherit the configuration and can override it without changing the parent
+	child := config.New()
+	child.Exts[languageName] = root
+	lang.Configure(child, "sub", loadBuildFile(t, "# gazelle:img_registry registry.example\n# gazelle:img_binary_kinds cc_binary\n"))
+	assertConfig(t, getConfig(child), imageConfig{
+		enabled:     true,
+		binaryKinds: []string{"cc_binary"},
+		binaryDir:   "/app/bin",
+		registry:    "registry.example",
+	})
+	assertConfig(t, root, imageConfig{
+		enabled:     true,
+		binaryKinds: []string{"go_binary", "py_binary"},
+		binaryDir:   "/app/bin",
+		registry:    "ghcr.io",
+	})
+
+	// directories without a BUILD file keep the configuration of the parent
+	lang.Configure(child, "sub/empty", nil)
+	if got := getConfig(child).registry; got != "registry.example" {
+		t.Errorf("registry without a BUILD file = %q, want %q", got, "registry.example")
+	}
+}
+
+func assertConfig(t *testing.T, got *imageConfig, want imageConfig) {
+	t.Helper()
+	if got.enabled != want.enabled ||
+		!slices.Equal(got.binaryKinds, want.binaryKinds) ||
+		got.base != want.base ||
+		got.binaryDir != want.binaryDir ||
+		got.registry != want.registry ||
+		got.repositoryPrefix != want.repositoryPrefix ||
+		got.tag != want.tag {
+		t.Errorf("config = %+v, want %+v", *got, want)
+	}
+}
+
+func loadBuildFile(t *testing.T, content string) *rule.File {
+	t.Helper()
+	f, err := rule.LoadData("BUILD.bazel", "", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
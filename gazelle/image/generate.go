@@ -0,0 +1,128 @@
+package image
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Suffixes of the names of generated targets, appended to the name of the binary.
+const (
+	layerSuffix    = "_layer"
+	manifestSuffix = "_image"
+	pushSuffix     = "_push"
+)
+
+// GenerateRules generates an image_layer, image_manifest and (if a registry is configured)
+// an image_push target for every binary rule of the package.
+func (l *imageLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	cfg := getConfig(args.Config)
+	if !cfg.enabled {
+		return language.GenerateResult{}
+	}
+
+	binaries := binaryNames(cfg, args)
+	var rules []*rule.Rule
+	for _, name := range binaries {
+		binaryPath := path.Join(cfg.binaryDir, name)
+
+		layer := rule.NewRule("image_layer", name+layerSuffix)
+		layer.SetAttr("srcs", map[string]string{binaryPath: ":" + name})
+		rules = append(rules, layer)
+
+		manifest := rule.NewRule("image_manifest", name+manifestSuffix)
+		if cfg.base != "" {
+			manifest.SetAttr("base", cfg.base)
+		}
+		manifest.SetAttr("layers", []string{":" + layer.Name()})
+		manifest.SetAttr("entrypoint", []string{binaryPath})
+		rules = append(rules, manifest)
+
+		if cfg.registry == "" {
+			continue
+		}
+		push := rule.NewRule("image_push", name+pushSuffix)
+		push.SetAttr("image", ":"+manifest.Name())
+		push.SetAttr("registry", cfg.registry)
+		repository := name
+		if cfg.repositoryPrefix != "" {
+			repository = cfg.repositoryPrefix + "/" + name
+		}
+		push.SetAttr("repository", repository)
+		if cfg.tag != "" {
+			push.SetAttr("tag", cfg.tag)
+		}
+		rules = append(rules, push)
+	}
+
+	// Return empty imports array matching the number of generated rules
+	// This satisfies Gazelle's validation that expects imports for each generated rule
+	imports := make([]interface{}, len(rules))
+
+	return language.GenerateResult{
+		Gen:     rules,
+		Empty:   staleRules(args.File, binaries),
+		Imports: imports,
+	}
+}
+
+// binaryNames returns the names of the binary rules of the package,
+// both existing ones and the ones generated by other languages in this run.
+func binaryNames(cfg *imageConfig, args language.GenerateArgs) []string {
+	var names []string
+	add := func(r *rule.Rule) {
+		if slices.Contains(cfg.binaryKinds, r.Kind()) && !slices.Contains(names, r.Name()) {
+			names = append(names, r.Name())
+		}
+	}
+	if args.File != nil {
+		for _, r := range args.File.Rules {
+			add(r)
+		}
+	}
+	for _, r := range args.OtherGen {
+		add(r)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// staleRules returns empty rules for generated targets of binaries that no longer exist,
+// so that Gazelle removes them. Only targets that still form the generated chain
+// (push -> manifest -> layer) are removed, hand-written targets with the same names are kept.
+func staleRules(f *rule.File, binaries []string) []*rule.Rule {
+	if f == nil {
+		return nil
+	}
+	manifestLayers := make(map[string][]string)
+	for _, r := range f.Rules {
+		if r.Kind() == "image_manifest" {
+			manifestLayers[r.Name()] = r.AttrStrings("layers")
+		}
+	}
+
+	var empty []*rule.Rule
+	for _, r := range f.Rules {
+		var binary string
+		var generated bool
+		switch r.Kind() {
+		case "image_layer":
+			binary, generated = strings.CutSuffix(r.Name(), layerSuffix)
+			generated = generated && slices.Equal(manifestLayers[binary+manifestSuffix], []string{":" + r.Name()})
+		case "image_manifest":
+			binary, generated = strings.CutSuffix(r.Name(), manifestSuffix)
+			generated = generated && slices.Equal(manifestLayers[r.Name()], []string{":" + binary + layerSuffix})
+		case "image_push":
+			binary, generated = strings.CutSuffix(r.Name(), pushSuffix)
+			generated = generated && r.AttrString("image") == ":"+binary+manifestSuffix &&
+				slices.Equal(manifestLayers[binary+manifestSuffix], []string{":" + binary + layerSuffix})
+		}
+		if generated && !slices.Contains(binaries, binary) {
+			empty = append(empty, rule.NewRule(r.Kind(), r.Name()))
+		}
+	}
+	return empty
+}
@@ -0,0 +1,201 @@
+package image
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestGenerateRules(t *testing.T) {
+	build := `go_binary(name = "server")
+
+py_binary(name = "worker")
+
+cc_binary(name = "tool")
+`
+	tests := []struct {
+		name       string
+		directives string
+		otherGen   []*rule.Rule
+		want       []string
+		wantAttrs  []string
+	}{
+		{
+			name: "disabled by default",
+		},
+		{
+			name:       "layer and manifest of every binary",
+			directives: "# gazelle:img_images enabled\n",
+			want: []string{
+				"image_layer server_layer",
+				"image_manifest server_image",
+				"image_layer worker_layer",
+				"image_manifest worker_image",
+			},
+			wantAttrs: []string{
+				`"/app/bin/server": ":server"`,
+				`entrypoint = ["/app/bin/server"]`,
+				`layers = [":server_layer"]`,
+			},
+		},
+		{
+			name: "push targets with a registry",
+			directives: `# gazelle:img_images enabled
+# gazelle:img_binary_kinds go_binary
+# gazelle:img_base @distroless_static
+# gazelle:img_binary_dir /usr/local/bin
+# gazelle:img_registry ghcr.io
+# gazelle:img_repository_prefix my-org
+# gazelle:img_tag latest
+`,
+			want: []string{
+				"image_layer server_layer",
+				"image_manifest server_image",
+				"image_push server_push",
+			},
+			wantAttrs: []string{
+				`"/usr/local/bin/server": ":server"`,
+				`base = "@distroless_static"`,
+				`image = ":server_image"`,
+				`registry = "ghcr.io"`,
+				`repository = "my-org/server"`,
+				`tag = "latest"`,
+			},
+		},
+		{
+			name:       "binaries generated by other languages",
+			directives: "# gazelle:img_images enabled\n# gazelle:img_binary_kinds go_binary\n",
+			otherGen: []*rule.Rule{
+				rule.NewRule("go_binary", "server"),
+				rule.NewRule("go_binary", "api"),
+				rule.NewRule("go_library", "lib"),
+			},
+			want: []string{
+				"image_layer api_layer",
+				"image_manifest api_image",
+				"image_layer server_layer",
+				"image_manifest server_image",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := loadBuildFile(t, tt.directives+build)
+			res := generate(t, f, tt.otherGen)
+			if got := ruleKeys(res.Gen); !slices.Equal(got, tt.want) {
+				t.Errorf("GenerateRules() = %q, want %q", got, tt.want)
+			}
+			if len(res.Imports) != len(res.Gen) {
+				t.Errorf("GenerateRules() returned %d imports for %d rules", len(res.Imports), len(res.Gen))
+			}
+			if len(res.Empty) != 0 {
+				t.Errorf("GenerateRules() removes %q, want nothing", ruleKeys(res.Empty))
+			}
+			formatted := formatRules(res.Gen)
+			for _, attr := range tt.wantAttrs {
+				if !strings.Contains(formatted, attr) {
+					t.Errorf("generated rules don't contain %s:\n%s", attr, formatted)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateRulesRemovesStaleTargets(t *testing.T) {
+	f := loadBuildFile(t, `# gazelle:img_images enabled
+# gazelle:img_registry ghcr.io
+
+go_binary(name = "server")
+
+image_layer(
+    name = "server_layer",
+    srcs = {"/app/bin/server": ":server"},
+)
+
+image_manifest(
+    name = "server_image",
+    layers = [":server_layer"],
+)
+
+image_layer(
+    name = "old_layer",
+    srcs = {"/app/bin/old": ":old"},
+)
+
+image_manifest(
+    name = "old_image",
+    layers = [":old_layer"],
+)
+
+image_push(
+    name = "old_push",
+    image = ":old_image",
+)
+
+image_layer(
+    name = "custom_layer",
+    srcs = {"/app/bin/custom": ":custom"},
+)
+
+image_manifest(
+    name = "custom_image",
+    layers = [
+        ":base_layer",
+        ":custom_layer",
+    ],
+)
+
+image_push(
+    name = "custom_push",
+    image = ":custom_image",
+)
+
+image_push(
+    name = "hand_written_push",
+    image = ":server_image",
+)
+`)
+	res := generate(t, f, nil)
+	want := []string{
+		"image_layer old_layer",
+		"image_manifest old_image",
+		"image_push old_push",
+	}
+	if got := ruleKeys(res.Empty); !slices.Equal(got, want) {
+		t.Errorf("GenerateRules() removes %q, want %q", got, want)
+	}
+}
+
+func generate(t *testing.T, f *rule.File, otherGen []*rule.Rule) language.GenerateResult {
+	t.Helper()
+	lang := NewLanguage()
+	c := config.New()
+	lang.RegisterFlags(nil, "update", c)
+	lang.Configure(c, "", f)
+	return lang.GenerateRules(language.GenerateArgs{
+		Config:   c,
+		Dir:      t.TempDir(),
+		File:     f,
+		OtherGen: otherGen,
+	})
+}
+
+func ruleKeys(rules []*rule.Rule) []string {
+	var keys []string
+	for _, r := range rules {
+		keys = append(keys, r.Kind()+" "+r.Name())
+	}
+	return keys
+}
+
+func formatRules(rules []*rule.Rule) string {
+	f := rule.EmptyFile("BUILD.bazel", "")
+	for _, r := range rules {
+		r.Insert(f)
+	}
+	return string(f.Format())
+}
@@ -0,0 +1,139 @@
+// Package image is a Gazelle language extension that generates container image targets
+// (image_layer, image_manifest and image_push) for binary rules like go_binary and py_binary.
+//
+// Generation is opt-in per directory tree with the "# gazelle:img_images enabled" directive.
+package image
+
+import (
+	"flag"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	// Language name
+	languageName = "rules_img"
+
+	// Name of the rules_img module, used for load statements.
+	moduleName = "rules_img"
+)
+
+// imageLang implements language.Language for generating container image targets
+type imageLang struct{}
+
+// NewLanguage returns a new instance of the image language extension
+func NewLanguage() language.Language {
+	return &imageLang{}
+}
+
+// Kinds returns the kinds of rules that this extension generates.
+func (l *imageLang) Kinds() map[string]rule.KindInfo {
+	return map[string]rule.KindInfo{
+		"image_layer": {
+			NonEmptyAttrs:  map[string]bool{"srcs": true},
+			MergeableAttrs: map[string]bool{"srcs": true},
+		},
+		"image_manifest": {
+			NonEmptyAttrs: map[string]bool{"layers": true},
+			MergeableAttrs: map[string]bool{
+				"base":       true,
+				"entrypoint": true,
+				"layers":     true,
+			},
+		},
+		"image_push": {
+			NonEmptyAttrs: map[string]bool{"image": true},
+			MergeableAttrs: map[string]bool{
+				"image":      true,
+				"registry":   true,
+				"repository": true,
+				"tag":        true,
+			},
+		},
+	}
+}
+
+// Loads returns load statements that are required for the rules this extension generates
+func (l *imageLang) Loads() []rule.LoadInfo {
+	return loads(moduleName)
+}
+
+// ApparentLoads returns load statements that use the apparent name of rules_img in the main module.
+func (l *imageLang) ApparentLoads(moduleToApparentName func(string) string) []rule.LoadInfo {
+	repoName := moduleToApparentName(moduleName)
+	if repoName == "" {
+		repoName = moduleName
+	}
+	return loads(repoName)
+}
+
+func loads(repoName string) []rule.LoadInfo {
+	return []rule.LoadInfo{
+		{Name: "@" + repoName + "//img:layer.bzl", Symbols: []string{"image_layer"}},
+		{Name: "@" + repoName + "//img:image.bzl", Symbols: []string{"image_manifest"}},
+		{Name: "@" + repoName + "//img:push.bzl", Symbols: []string{"image_push"}},
+	}
+}
+
+// Name returns the name of the language
+func (l *imageLang) Name() string {
+	return languageName
+}
+
+// RegisterFlags registers command-line flags for the extension
+func (l *imageLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
+	c.Exts[languageName] = defaultConfig()
+}
+
+// CheckFlags validates the flags
+func (l *imageLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	return nil
+}
+
+// KnownDirectives returns a list of directive keys that this extension uses
+func (l *imageLang) KnownDirectives() []string {
+	return []string{
+		imagesDirective,
+		binaryKindsDirective,
+		baseDirective,
+		binaryDirDirective,
+		registryDirective,
+		repositoryPrefixDirective,
+		tagDirective,
+	}
+}
+
+// Configure modifies the configuration using directives and other information
+func (l *imageLang) Configure(c *config.Config, rel string, f *rule.File) {
+	// the config of the parent directory is shared, so it must be copied before modifying it
+	cfg := getConfig(c).clone()
+	if f != nil {
+		cfg.apply(f)
+	}
+	c.Exts[languageName] = cfg
+}
+
+// Fix repairs deprecated usage of language-specific rules
+func (l *imageLang) Fix(c *config.Config, f *rule.File) {
+	// No deprecated usage to fix
+}
+
+// Imports returns a list of imports in the given rule
+func (l *imageLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	return nil
+}
+
+// Embeds returns a list of labels of rules that the given rule embeds
+func (l *imageLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
+	return nil
+}
+
+// Resolve translates import paths into Bazel labels
+func (l *imageLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	// Generated targets only refer to targets of the same package, which need no resolution
+}
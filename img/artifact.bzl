@@ -0,0 +1,5 @@
+"""Public API for OCI artifact rules."""
+
+load("//img/private:artifact.bzl", _oci_artifact = "oci_artifact")
+
+oci_artifact = _oci_artifact
@@ -0,0 +1,6 @@
+"""Rules to define the optional external compressor toolchain."""
+
+load("//img/private:compressor_toolchain.bzl", _TOOLCHAIN_TYPE = "TOOLCHAIN_TYPE", _compressor_toolchain = "compressor_toolchain")
+
+compressor_toolchain = _compressor_toolchain
+TOOLCHAIN_TYPE = _TOOLCHAIN_TYPE
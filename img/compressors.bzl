@@ -0,0 +1,16 @@
+"""Module extension to download external compressors for rules_img.
+
+The img tool compresses layers with pure Go compressors by default.
+For huge layers, the multi-threaded [pigz](https://zlib.net/pigz/) and [zstd](https://facebook.github.io/zstd/)
+executables are a lot faster. If a compressor toolchain is registered, `image_layer`, `layer_from_tar`
+and `image_manifest` (when squashing layers) compress layers with its executables instead.
+The `compression_jobs` and `compression_level` settings are passed on to the executables.
+
+The output of pigz and zstd differs from the output of the built-in compressors,
+so layer digests change when a compressor toolchain is registered.
+eStargz layers are always compressed with the built-in compressors.
+"""
+
+load("//img/private/prebuilt:compressors.bzl", _compressors = "compressors")
+
+compressors = _compressors
@@ -0,0 +1,41 @@
+"""Public API for comparing container images.
+
+The `base_image_diff` rule writes a human-readable report of the differences between two
+versions of an image. It is meant to be attached to pull requests that update a pinned base image.
+
+The `pull_size_estimate` rule reports how many bytes a node that runs the previous release
+needs to pull to deploy a new build.
+
+To review changes of your own images, `img diff` compares two manifests written by `image_manifest`
+(e.g. of the main branch and of a pull request) and writes a Markdown report of the changed layers,
+config fields and, if the changed layer blobs are passed with `--layer metadata=blob`, the files
+that differ inside the changed layers:
+
+```bash
+img diff --old main/app_manifest.json --new pr/app_manifest.json --output report.md
+```
+
+## Example
+
+```python
+load("@rules_img//img:diff.bzl", "base_image_diff", "pull_size_estimate")
+
+base_image_diff(
+    name = "debian_update",
+    old = "@debian_old",
+    new = "@debian",
+)
+
+pull_size_estimate(
+    name = "pull_size",
+    image = ":push",
+    previous = "@previous_release//file",
+)
+```
+"""
+
+load("//img/private:base_image_diff.bzl", _base_image_diff = "base_image_diff")
+load("//img/private:pull_size.bzl", _pull_size_estimate = "pull_size_estimate")
+
+base_image_diff = _base_image_diff
+pull_size_estimate = _pull_size_estimate
@@ -2,10 +2,13 @@
 
 Use `image_manifest` to create a single-platform container image,
 and `image_index` to compose a multi-platform container image index.
+`image_empty_base` is an empty base image ("scratch") for the target platform.
 """
 
+load("//img/private:empty_base.bzl", _image_empty_base = "image_empty_base")
 load("//img/private:index.bzl", _image_index = "image_index")
 load("//img/private:manifest.bzl", _image_manifest = "image_manifest")
 
 image_manifest = _image_manifest
 image_index = _image_index
+image_empty_base = _image_empty_base
@@ -0,0 +1,5 @@
+"""Public API for layering Java binaries."""
+
+load("//img/private:java.bzl", _java_image_layer = "java_image_layer")
+
+java_image_layer = _java_image_layer
@@ -0,0 +1,34 @@
+"""Public API for pinning base images to digests with a lock file.
+
+The `image_lock` rule resolves floating tags (like `ubuntu:24.04`) to digests and writes them
+to a checked-in lock file. The `pull` repository rule reads the lock file via its `lock_file` attribute.
+
+## Example
+
+```python
+load("@rules_img//img:lock.bzl", "image_lock")
+
+image_lock(
+    name = "lock",
+    images = ["index.docker.io/library/ubuntu:24.04"],
+    lock_file = "img.lock.json",
+)
+```
+
+```starlark
+# MODULE.bazel
+pull = use_repo_rule("@rules_img//img:pull.bzl", "pull")
+
+pull(
+    name = "ubuntu",
+    lock_file = "//:img.lock.json",
+    registry = "index.docker.io",
+    repository = "library/ubuntu",
+    tag = "24.04",
+)
+```
+"""
+
+load("//img/private:lock.bzl", _image_lock = "image_lock")
+
+image_lock = _image_lock
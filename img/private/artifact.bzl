@@ -0,0 +1,164 @@
+"""Rule for packaging arbitrary files as OCI artifacts."""
+
+load("//img/private:manifest.bzl", "subject_file")
+load("//img/private/common:build.bzl", "TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "diagnostics_env", "get_toolchain_info")
+load("//img/private/common:layer_helper.bzl", "layer_output_groups")
+load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
+load("//img/private/providers:layer_info.bzl", "LayerInfo")
+load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
+
+def _oci_artifact_impl(ctx):
+    if (ctx.attr.config == None) != (ctx.attr.config_media_type == ""):
+        fail("config and config_media_type must be set together")
+    args = ctx.actions.args()
+    args.add("artifact")
+    args.add("--artifact-type", ctx.attr.artifact_type)
+    inputs = []
+    layers = []
+    for (target, media_type) in ctx.attr.files.items():
+        for file in target.files.to_list():
+            metadata = ctx.actions.declare_file("{}_metadata_blob_{}.json".format(ctx.attr.name, len(layers)))
+            args.add("--blob", file.path)
+            args.add("--blob-media-type", media_type)
+            args.add("--blob-metadata", metadata.path)
+            inputs.append(file)
+            layers.append(LayerInfo(
+                blob = file,
+                metadata = metadata,
+                media_type = media_type,
+                estargz = False,
+            ))
+    if len(layers) == 0:
+        fail("oci_artifact needs at least one file")
+    if ctx.attr.config != None:
+        inputs.append(ctx.file.config)
+        args.add("--config-file", ctx.file.config.path)
+        args.add("--config-media-type", ctx.attr.config_media_type)
+    for key, value in ctx.attr.annotations.items():
+        args.add("--annotation", "%s=%s" % (key, value))
+    subject = subject_file(ctx.attr.subject)
+    if subject != None:
+        inputs.append(subject)
+        args.add("--subject", subject.path)
+
+    manifest_out = ctx.actions.declare_file(ctx.label.name + "_manifest.json")
+    config_out = ctx.actions.declare_file(ctx.label.name + "_config.json")
+    descriptor_out = ctx.actions.declare_file(ctx.label.name + "_descriptor.json")
+    digest_out = ctx.actions.declare_file(ctx.label.name + "_digest")
+    args.add("--manifest", manifest_out.path)
+    args.add("--config", config_out.path)
+    args.add("--descriptor", descriptor_out.path)
+    args.add("--digest", digest_out.path)
+
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = inputs,
+        outputs = [manifest_out, config_out, descriptor_out, digest_out] + [layer.metadata for layer in layers],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        env = diagnostics_env(ctx),
+        mnemonic = "OCIArtifact",
+    )
+
+    return [
+        DefaultInfo(
+            files = depset([manifest_out, config_out]),
+        ),
+        OutputGroupInfo(
+            descriptor = depset([descriptor_out]),
+            digest = depset([digest_out]),
+            **layer_output_groups(layers)
+        ),
+        ImageManifestInfo(
+            base_image = None,
+            descriptor = descriptor_out,
+            manifest = manifest_out,
+            config = config_out,
+            structured_config = {},
+            architecture = "",
+            os = "",
+            platform = {},
+            layers = layers,
+            missing_blobs = [],
+        ),
+    ]
+
+oci_artifact = rule(
+    implementation = _oci_artifact_impl,
+    doc = """Packages arbitrary files as an OCI artifact (a manifest that is not a container image).
+
+OCI artifacts use registries to distribute content like WASM modules, Helm charts, SBOMs, or signatures.
+Every file becomes a layer of the manifest with the media type given in `files`, and the manifest
+is tagged with the `artifactType`. The artifact provides `ImageManifestInfo`,
+so it can be pushed with `image_push` (or `multi_deploy`) like an image.
+
+Example:
+
+```python
+load("@rules_img//img:artifact.bzl", "oci_artifact")
+load("@rules_img//img:push.bzl", "image_push")
+
+oci_artifact(
+    name = "wasm",
+    artifact_type = "application/vnd.wasm.config.v0+json",
+    files = {
+        ":module.wasm": "application/wasm",
+    },
+)
+
+image_push(
+    name = "push_wasm",
+    image = ":wasm",
+    registry = "ghcr.io",
+    repository = "my-org/my-module",
+    tag = "latest",
+)
+```
+
+Without `config`, the manifest uses the empty config (`{}` with the media type `application/vnd.oci.empty.v1+json`),
+as recommended by the OCI image spec for artifacts. Each layer has an `org.opencontainers.image.title`
+annotation with the file name, which tools like oras use to restore the files.
+
+Output groups:
+- `descriptor`: OCI descriptor JSON file
+- `digest`: Digest of the artifact (sha256:...)
+- `layer_metadata`: Metadata (name, digest, and size) of every file
+- `layer_blobs`: The files of the artifact
+""",
+    attrs = {
+        "artifact_type": attr.string(
+            mandatory = True,
+            doc = "The `artifactType` of the manifest, like `application/vnd.wasm.config.v0+json` or `application/vnd.cncf.helm.config.v1+json`.",
+        ),
+        "files": attr.label_keyed_string_dict(
+            mandatory = True,
+            allow_files = True,
+            doc = """Files of the artifact mapped to their media type (like `application/wasm`).
+
+Every file becomes a layer, in the order of the dict. Targets with multiple files use the media type for each file.""",
+        ),
+        "config": attr.label(
+            allow_single_file = True,
+            doc = "Optional config blob of the artifact (like the `Chart.yaml` of a Helm chart as JSON). Requires `config_media_type`.",
+        ),
+        "config_media_type": attr.string(
+            doc = "Media type of `config`, like `application/vnd.cncf.helm.config.v1+json`.",
+        ),
+        "annotations": attr.string_dict(
+            default = {},
+            doc = "Annotations of the manifest.",
+        ),
+        "subject": attr.label(
+            doc = """Optional image or image index to reference as the `subject` of the artifact.
+
+This attaches the artifact (like an SBOM or a signature) to the subject, so that registries supporting
+the referrers API list it as a referrer of the subject.
+
+Should provide ImageManifestInfo or ImageIndexInfo.
+""",
+            providers = [[ImageManifestInfo], [ImageIndexInfo]],
+        ),
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    provides = [ImageManifestInfo],
+    toolchains = TOOLCHAINS,
+)
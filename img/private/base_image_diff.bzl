@@ -0,0 +1,98 @@
+"""Rule for reporting the differences between two versions of a base image."""
+
+load("//img/private/common:build.bzl", "TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "get_toolchain_info")
+load("//img/private/common:transitions.bzl", "reset_platform_transition")
+load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
+load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
+
+def _manifests(target):
+    if ImageManifestInfo in target:
+        return [target[ImageManifestInfo]]
+    if ImageIndexInfo in target:
+        return target[ImageIndexInfo].manifests
+    fail("{} must provide ImageManifestInfo or ImageIndexInfo".format(target.label))
+
+def _add_image(args, inputs, prefix, manifests):
+    for manifest_info in manifests:
+        args.add("--{}-manifest".format(prefix), manifest_info.manifest)
+        args.add("--{}-config".format(prefix), manifest_info.config)
+        inputs.extend([manifest_info.manifest, manifest_info.config])
+
+        # Layers of shallow pulled images have no blob.
+        # Without them, installed packages cannot be compared.
+        for layer in manifest_info.layers:
+            if layer.blob != None:
+                args.add("--layer", "{}={}".format(layer.metadata.path, layer.blob.path))
+                inputs.extend([layer.metadata, layer.blob])
+
+def _base_image_diff_impl(ctx):
+    report = ctx.actions.declare_file(ctx.label.name + ".md")
+
+    args = ctx.actions.args()
+    args.add("base-diff")
+    inputs = []
+    _add_image(args, inputs, "old", _manifests(ctx.attr.old))
+    _add_image(args, inputs, "new", _manifests(ctx.attr.new))
+    args.add("--output", report)
+
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = inputs,
+        outputs = [report],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        env = {"RULES_IMG": "1"},
+        mnemonic = "BaseImageDiff",
+    )
+
+    return [
+        DefaultInfo(files = depset([report])),
+        OutputGroupInfo(report = depset([report])),
+    ]
+
+base_image_diff = rule(
+    implementation = _base_image_diff_impl,
+    doc = """Writes a Markdown report of the differences between two versions of a base image.
+
+Use this rule to review updates of a pinned base image: point `old` at the previously pinned
+digest and `new` at the updated one, and attach the report (`<name>.md`) to the pull request.
+
+The report contains, per platform:
+- the old and new manifest digests,
+- the size delta and the layers that were added or removed,
+- changes of the image config (user, entrypoint, cmd, environment variables, labels, ...),
+- packages that were added, removed, or changed, if the image contains a dpkg or apk package database.
+
+Comparing packages requires the layer blobs of both images. Use `layer_handling = "eager"`
+when pulling images that should be compared, otherwise the package section is omitted.
+
+Example:
+
+```python
+load("@rules_img//img:diff.bzl", "base_image_diff")
+
+base_image_diff(
+    name = "debian_update",
+    old = "@debian_old",
+    new = "@debian",
+)
+```
+
+```bash
+bazel build //path/to:debian_update
+cat bazel-bin/path/to/debian_update.md
+```
+""",
+    attrs = {
+        "old": attr.label(
+            doc = "Previous version of the image. Should provide ImageManifestInfo or ImageIndexInfo.",
+            mandatory = True,
+        ),
+        "new": attr.label(
+            doc = "Updated version of the image. Should provide ImageManifestInfo or ImageIndexInfo.",
+            mandatory = True,
+        ),
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    cfg = reset_platform_transition,
+    toolchains = TOOLCHAINS,
+)
@@ -1,4 +1,110 @@
 """Common build utilities for container image rules."""
 
+load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
+load("//img/private/common:transitions.bzl", "host_platform_transition")
+load("//img/private/providers:image_toolchain_info.bzl", "ImageToolchainInfo")
+
 TOOLCHAIN = str(Label("//img:toolchain_type"))
 TOOLCHAINS = [TOOLCHAIN]
+
+# Optional toolchain of external compressors (pigz and zstd).
+COMPRESSOR_TOOLCHAIN = str(Label("//img:compressor_toolchain_type"))
+
+# Toolchains of rules that compress layers.
+LAYER_TOOLCHAINS = TOOLCHAINS + [config_common.toolchain_type(COMPRESSOR_TOOLCHAIN, mandatory = False)]
+
+_TOOLCHAIN_OVERRIDE_DOC = """Optional image toolchain that replaces the resolved img toolchain for this target.
+
+Accepts an `image_toolchain` target (or any target providing `ImageToolchainInfo`).
+This allows individual targets to use an alternate img binary (for example, an experimental
+build with additional features) while the rest of the repository stays on the registered toolchain.
+"""
+
+# Attributes for rules that only run the img tool in build actions.
+TOOLCHAIN_OVERRIDE_ATTRS = {
+    "toolchain": attr.label(
+        doc = _TOOLCHAIN_OVERRIDE_DOC,
+        cfg = "exec",
+        providers = [ImageToolchainInfo],
+    ),
+}
+
+# Attributes for executable rules that also run the img tool via `bazel run`.
+# The tool is built for the host platform, so it is used both at build and run time.
+RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS = {
+    "toolchain": attr.label(
+        doc = _TOOLCHAIN_OVERRIDE_DOC,
+        cfg = host_platform_transition,
+        providers = [ImageToolchainInfo],
+    ),
+}
+
+def _toolchain_override(ctx):
+    override = getattr(ctx.attr, "toolchain", None)
+    if override == None:
+        return None
+    if type(override) == "list":
+        # attributes with a transition are lists
+        override = override[0]
+    return override[ImageToolchainInfo]
+
+def get_toolchain_info(ctx):
+    """Returns the ImageToolchainInfo used in build actions of the target.
+
+    Args:
+        ctx: The rule context.
+
+    Returns:
+        The toolchain info from the `toolchain` attribute if set, otherwise the resolved toolchain.
+    """
+    override = _toolchain_override(ctx)
+    if override != None:
+        return override
+    return ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+
+def get_runtime_toolchain_info(ctx):
+    """Returns the ImageToolchainInfo of the img tool that is executed via `bazel run`.
+
+    Args:
+        ctx: The rule context.
+
+    Returns:
+        The toolchain info from the `toolchain` attribute if set, otherwise the host toolchain.
+    """
+    override = _toolchain_override(ctx)
+    if override != None:
+        return override
+    return ctx.attr._tool[0][ImageToolchainInfo]
+
+# Build settings that are described when an action of the target fails (if the rule has them).
+_DIAGNOSTICS_SETTINGS = [
+    "_default_compression",
+    "_default_estargz",
+    "_compression_jobs",
+    "_compression_level",
+    "_max_layers_warning",
+    "_max_layers",
+]
+
+def diagnostics_env(ctx):
+    """Returns the environment that describes a build action of the target if the img tool fails.
+
+    The img tool prints these values together with its own version and the selected settings
+    (like the compression) when a layer or manifest action fails, so that bug reports contain them.
+
+    Args:
+        ctx: The rule context.
+
+    Returns:
+        A dict to use as (part of) the env of the action.
+    """
+    values = {
+        "compilation_mode": ctx.var.get("COMPILATION_MODE", "fastbuild"),
+        "target": str(ctx.label),
+        "toolchain": str(get_toolchain_info(ctx).tool_exe.owner),
+    }
+    for name in _DIAGNOSTICS_SETTINGS:
+        setting = getattr(ctx.attr, name, None)
+        if setting != None and BuildSettingInfo in setting:
+            values[str(setting.label)] = str(setting[BuildSettingInfo].value)
+    return {"IMG_DIAGNOSTICS": json.encode(values)}
@@ -1,7 +1,7 @@
 """Helper functions for working with tar files."""
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
-load("//img/private/common:build.bzl", "TOOLCHAIN")
+load("//img/private/common:build.bzl", "COMPRESSOR_TOOLCHAIN", "diagnostics_env", "get_toolchain_info")
 load("//img/private/providers:layer_info.bzl", "LayerInfo")
 
 allow_tar_files = [".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst"]
@@ -23,7 +23,8 @@ def compression_tuning_args(ctx, compression, estargz):
     according to Bazel's compilation mode. This
     function prefers faster, parallel compression in fastbuild, and
     smaller, single-threaded high-compression in opt. Other compression
-    algorithms are left unchanged.
+    algorithms are left unchanged. The `compression_level` attribute of
+    the target (if any) overrides the global compression level setting.
 
     Args:
         ctx: Rule context used to read `COMPILATION_MODE`.
@@ -62,6 +63,10 @@ def compression_tuning_args(ctx, compression, estargz):
         if lvl and lvl != "auto":
             level = lvl
 
+    # The attribute of the target takes precedence over the global setting
+    if getattr(ctx.attr, "compression_level", "auto") != "auto":
+        level = ctx.attr.compression_level
+
     if level == "fastest":
         level = "1"
     elif level == "best":
@@ -69,7 +74,8 @@ def compression_tuning_args(ctx, compression, estargz):
 
     tuned_args = []
     if compression == "gzip" and not estargz:
-        # For gzip, we can tune the number of compression threads (pgzip)
+        # For gzip, we can tune the number of compression threads (pgzip).
+        # The output is the same for any number of threads.
         tuned_args.extend(["--compressor-jobs", jobs])
     if level != "-1":
         if level not in valid_levels[compression]:
@@ -77,6 +83,95 @@ def compression_tuning_args(ctx, compression, estargz):
         tuned_args.extend(["--compression-level", level])
     return tuned_args
 
+def external_compressor(ctx, compression, estargz):
+    """Returns the external compressor executable for a layer.
+
+    External compressors come from the optional compressor toolchain.
+    The rule needs `LAYER_TOOLCHAINS` in its toolchains.
+
+    Args:
+        ctx: Rule context.
+        compression: String name of the target compression algorithm
+            (e.g., "gzip", "zstd", "none").
+        estargz: Boolean indicating whether the layer is an estargz layer.
+
+    Returns:
+        File: The pigz or zstd executable, or None if the built-in compressor is used.
+    """
+    if estargz:
+        # eStargz layers are always compressed with the built-in gzip compressor
+        return None
+    toolchain = ctx.toolchains[COMPRESSOR_TOOLCHAIN]
+    if toolchain == None:
+        return None
+    info = toolchain.compressortoolchaininfo
+    if compression == "gzip":
+        return info.pigz
+    if compression == "zstd":
+        return info.zstd
+    return None
+
+def layer_output_groups(layers):
+    """Output groups exposing the individual layers of an image.
+
+    Requesting these output groups (e.g. `--output_groups=+layer_metadata`) lists every
+    layer in the Build Event Protocol, so that tools consuming the BEP can attribute
+    transfers and cache misses to individual layers (and the targets that produced them).
+
+    Args:
+        layers: List of LayerInfo providers.
+
+    Returns:
+        A dict of output group names to depsets, to be passed to OutputGroupInfo.
+    """
+    return dict(
+        # metadata files contain the label, digest, and size of each layer
+        layer_metadata = depset([layer.metadata for layer in layers]),
+        # blobs of shallow pulled base layers are not available
+        layer_blobs = depset([layer.blob for layer in layers if layer.blob != None]),
+    )
+
+_SOCI_MEDIA_TYPES = [
+    "application/vnd.oci.image.layer.v1.tar+gzip",
+    "application/vnd.docker.image.rootfs.diff.tar.gzip",
+]
+
+def layer_supports_soci(layer):
+    """Whether a SOCI ztoc can be built for a layer.
+
+    Ztocs are only built for gzip layers that are available locally.
+    eStargz layers are already seekable and don't need a ztoc.
+
+    Args:
+        layer: LayerInfo provider.
+
+    Returns:
+        bool: True if the layer supports a ztoc.
+    """
+    return layer.blob != None and not layer.estargz and layer.media_type in _SOCI_MEDIA_TYPES
+
+def build_ztoc(*, ctx, tar_file, output):
+    """Builds the SOCI ztoc of a gzip layer.
+
+    Args:
+        ctx: Rule context.
+        tar_file: The gzip compressed layer.
+        output: Output ztoc file.
+    """
+    args = ctx.actions.args()
+    args.add("soci-ztoc")
+    args.add(tar_file.path)
+    args.add(output.path)
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [tar_file],
+        outputs = [output],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        env = diagnostics_env(ctx),
+        mnemonic = "SociZtoc",
+    )
+
 def calculate_layer_info(*, ctx, media_type, tar_file, metadata_file, estargz, annotations = {}):
     """Calculates the layer info for a tar file.
 
@@ -98,7 +193,7 @@ def calculate_layer_info(*, ctx, media_type, tar_file, metadata_file, estargz, a
         args.add("--annotation", "{}={}".format(key, value))
     args.add(tar_file.path)
     args.add(metadata_file.path)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = [tar_file],
         outputs = [metadata_file],
@@ -139,14 +234,19 @@ def recompress_layer(*, ctx, media_type, tar_file, metadata_file, output, target
         args.add("--annotation", "{}={}".format(key, value))
     args.add("--metadata", metadata_file.path)
     args.add_all(compression_tuning_args(ctx, target_compression, estargz))
+    compressor = external_compressor(ctx, target_compression, estargz)
+    if compressor != None:
+        args.add("--external-compressor", compressor)
     args.add(tar_file.path)
     args.add(output)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = [tar_file],
         outputs = [output, metadata_file],
         executable = img_toolchain_info.tool_exe,
+        tools = [compressor] if compressor != None else [],
         arguments = [args],
+        env = diagnostics_env(ctx),
         mnemonic = "LayerCompress",
     )
     return LayerInfo(
@@ -156,7 +256,54 @@ def recompress_layer(*, ctx, media_type, tar_file, metadata_file, output, target
         estargz = estargz,
     )
 
-def optimize_layer(*, ctx, media_type, tar_file, metadata_file, output, target_compression, estargz, annotations):
+def squash_layers(*, ctx, layers, metadata_file, output, target_compression, estargz):
+    """Squashes consecutive layers into a single layer.
+
+    Args:
+        ctx: Rule context.
+        layers: List of LayerInfo providers to squash, from lowest to highest. All layers need a blob.
+        metadata_file: Output metadata file.
+        output: Output squashed file.
+        target_compression: Target compression format.
+        estargz: Boolean indicating whether the layer is an estargz layer.
+
+    Returns:
+        LayerInfo provider with squashed blob and metadata.
+    """
+    media_type = "application/vnd.oci.image.layer.v1.tar"
+    if target_compression != "none":
+        media_type += "+{}".format(target_compression)
+    args = ctx.actions.args()
+    args.add("squash")
+    args.add("--name", ctx.label)
+    args.add("--format", target_compression)
+    if estargz:
+        args.add("--estargz")
+    args.add("--metadata", metadata_file.path)
+    args.add("--output", output.path)
+    args.add_all(compression_tuning_args(ctx, target_compression, estargz))
+    compressor = external_compressor(ctx, target_compression, estargz)
+    if compressor != None:
+        args.add("--external-compressor", compressor)
+    args.add_all([layer.blob for layer in layers])
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [layer.blob for layer in layers],
+        outputs = [output, metadata_file],
+        executable = img_toolchain_info.tool_exe,
+        tools = [compressor] if compressor != None else [],
+        arguments = [args],
+        env = diagnostics_env(ctx),
+        mnemonic = "LayerSquash",
+    )
+    return LayerInfo(
+        blob = output,
+        metadata = metadata_file,
+        media_type = media_type,
+        estargz = estargz,
+    )
+
+def optimize_layer(*, ctx, media_type, tar_file, metadata_file, output, target_compression, estargz, annotations, preserve_hardlinks = False):
     """Optimizes a tar file.
 
     Args:
@@ -168,6 +315,7 @@ def optimize_layer(*, ctx, media_type, tar_file, metadata_file, output, target_c
         target_compression: Target compression format.
         estargz: Boolean indicating whether the layer is an estargz layer.
         annotations: Dict of string annotations to add to the layer metadata.
+        preserve_hardlinks: Boolean indicating whether hardlinks of the tar file keep pointing to their original targets.
 
     Returns:
         LayerInfo provider with optimized blob and metadata.
@@ -182,15 +330,22 @@ def optimize_layer(*, ctx, media_type, tar_file, metadata_file, output, target_c
     for key, value in annotations.items():
         args.add("--annotation", "{}={}".format(key, value))
     args.add("--metadata", metadata_file.path)
+    if preserve_hardlinks:
+        args.add("--preserve-hardlinks")
     args.add("--import-tar", tar_file.path)
     args.add_all(compression_tuning_args(ctx, target_compression, estargz))
+    compressor = external_compressor(ctx, target_compression, estargz)
+    if compressor != None:
+        args.add("--external-compressor", compressor)
     args.add(output)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = depset(inputs),
         outputs = [output, metadata_file],
         executable = img_toolchain_info.tool_exe,
+        tools = [compressor] if compressor != None else [],
         arguments = [args],
+        env = diagnostics_env(ctx),
         mnemonic = "LayerOptimize",
     )
     return LayerInfo(
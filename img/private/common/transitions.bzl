@@ -72,6 +72,39 @@ normalize_layer_transition = transition(
     outputs = [_original_platforms_setting],
 )
 
+_platform_independent_platforms = {
+    "enabled": str(Label("//img/private/platforms:windows_amd64")),
+    "disabled": str(Label("//img/private/platforms:linux_amd64")),
+    "auto": str(Label("//img/private/platforms:linux_amd64")),
+}
+
+def _platform_independent_layer_transition_impl(settings, attr):
+    if not attr.platform_independent:
+        return {
+            _platforms_setting: settings[_platforms_setting],
+            _original_platforms_setting: settings[_original_platforms_setting],
+        }
+
+    # Build the layer for a fixed platform, so that every
+    # platform of a multi-platform index shares the same
+    # configuration (and therefore the same layer artifact).
+    return {
+        _platforms_setting: _platform_independent_platforms[getattr(attr, "windows", "auto")],
+        _original_platforms_setting: "",
+    }
+
+platform_independent_layer_transition = transition(
+    implementation = _platform_independent_layer_transition_impl,
+    inputs = [
+        _platforms_setting,
+        _original_platforms_setting,
+    ],
+    outputs = [
+        _platforms_setting,
+        _original_platforms_setting,
+    ],
+)
+
 def _host_platform_transition_impl(_settings, _attr):
     return {
         "//command_line_option:platforms": [Label("@platforms//host")],
@@ -1,11 +1,17 @@
 """Utilities for writing index.json files."""
 
-load("//img/private/common:build.bzl", "TOOLCHAIN")
+load("//img/private/common:build.bzl", "get_toolchain_info")
 
 def _annotation_arg(tup):
     return "{}={}".format(tup[0], tup[1])
 
-def write_index_json(ctx, *, output, digest, manifests, config_json = None):
+def _platform_value_arg(tup):
+    return "{}={}".format(tup[0], tup[1])
+
+def _platform_values_args(tup):
+    return ["{}={}".format(tup[0], value) for value in tup[1]]
+
+def write_index_json(ctx, *, output, digest, manifests, config_json = None, subject = None):
     """Write an index.json file for a multi-platform image.
 
     Args:
@@ -14,6 +20,7 @@ def write_index_json(ctx, *, output, digest, manifests, config_json = None):
         digest: Digest file to write.
         manifests: List of manifests to include in the index.
         config_json: Optional config JSON file with template expansions.
+        subject: Optional raw manifest or index to reference as the subject of the index.
     """
     manifest_descriptors = [manifest.descriptor for manifest in manifests]
     args = ctx.actions.args()
@@ -29,8 +36,18 @@ def write_index_json(ctx, *, output, digest, manifests, config_json = None):
     else:
         args.add_all(ctx.attr.annotations.items(), map_each = _annotation_arg, format_each = "--annotation=%s")
 
+    args.add_all(ctx.attr.variants.items(), map_each = _platform_value_arg, format_each = "--variant=%s")
+    args.add_all(ctx.attr.os_versions.items(), map_each = _platform_value_arg, format_each = "--os-version=%s")
+    args.add_all(ctx.attr.os_features.items(), map_each = _platform_values_args, format_each = "--os-feature=%s")
+    args.add_all(ctx.attr.manifest_annotations.items(), map_each = _platform_values_args, format_each = "--manifest-annotation=%s")
+    args.add("--descriptor-validation", ctx.attr.descriptor_validation)
+
+    if subject != None:
+        args.add("--subject", subject.path)
+        inputs.append(subject)
+
     args.add(output.path)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         outputs = [output, digest],
         inputs = inputs,
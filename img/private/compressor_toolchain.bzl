@@ -0,0 +1,49 @@
+"""Implementation of the compressor_toolchain rule."""
+
+load("//img/private/common:transitions.bzl", "reset_platform_transition")
+load("//img/private/providers:compressor_toolchain_info.bzl", "CompressorToolchainInfo")
+
+DOC = """\
+Defines an external compressor toolchain.
+
+The img tool compresses layers with pure Go compressors by default.
+For huge layers, the multi-threaded pigz and zstd executables are a lot faster.
+If a toolchain of this type is registered, layers are compressed with its executables instead.
+
+The compressed output of pigz and zstd differs from the output of the built-in compressors,
+so layer digests change when the toolchain is registered.
+eStargz layers are always compressed with the built-in compressors.
+
+See https://bazel.build/extending/toolchains#defining-toolchains.
+"""
+
+ATTRS = dict(
+    pigz = attr.label(
+        doc = "A pigz executable used for gzip layers.",
+        allow_single_file = True,
+    ),
+    zstd = attr.label(
+        doc = "A zstd executable used for zstd layers.",
+        allow_single_file = True,
+    ),
+)
+
+TOOLCHAIN_TYPE = str(Label("//img:compressor_toolchain_type"))
+
+def _compressor_toolchain_impl(ctx):
+    compressor_toolchain_info = CompressorToolchainInfo(
+        pigz = ctx.file.pigz,
+        zstd = ctx.file.zstd,
+    )
+    toolchain_info = platform_common.ToolchainInfo(
+        compressortoolchaininfo = compressor_toolchain_info,
+    )
+
+    return [toolchain_info, compressor_toolchain_info]
+
+compressor_toolchain = rule(
+    implementation = _compressor_toolchain_impl,
+    attrs = ATTRS,
+    doc = DOC,
+    cfg = reset_platform_transition,
+)
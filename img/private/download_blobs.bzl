@@ -6,7 +6,7 @@ layer data needs to be available during builds but you want to avoid downloading
 upfront during repository fetching.
 """
 
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
+load("//img/private/common:build.bzl", "TOOLCHAINS", "get_toolchain_info")
 load("//img/private/common:transitions.bzl", "reset_platform_transition")
 
 def _download_blob(ctx, output):
@@ -15,7 +15,7 @@ def _download_blob(ctx, output):
         fail("invalid digest: {}".format(output.basename))
     digest = output.basename.replace("sha256_", "sha256:")
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         outputs = [output],
         executable = img_toolchain_info.tool_exe,
@@ -30,6 +30,9 @@ def _download_blob(ctx, output):
         ] + [
             "--registry={}".format(r)
             for r in ctx.attr.registries
+        ] + [
+            "--registry-plain-http={}".format(r)
+            for r in (ctx.attr.registries if ctx.attr.plain_http else [])
         ],
         mnemonic = "DownloadBlob",
     )
@@ -58,6 +61,9 @@ download_blobs = rule(
             doc = "List of digests to download.",
             mandatory = True,
         ),
+        "plain_http": attr.bool(
+            doc = "Whether to contact the registries over plain HTTP without TLS.",
+        ),
         "registries": attr.string_list(
             doc = "List of registry mirrors used to pull the image.",
         ),
@@ -0,0 +1,104 @@
+"""Rule for an empty base image ("scratch") of the target platform."""
+
+load("//img/private/common:build.bzl", "TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "get_toolchain_info")
+load("//img/private/config:defs.bzl", "TargetPlatformInfo")
+load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
+
+# Variants that container runtimes assume for images of these architectures without a variant.
+# Keep in sync with defaultVariant of "img manifest".
+_DEFAULT_VARIANTS = {
+    "arm": "v7",
+    "arm64": "v8",
+}
+
+def _image_empty_base_impl(ctx):
+    os = ctx.attr._os_cpu[TargetPlatformInfo].os
+    arch = ctx.attr._os_cpu[TargetPlatformInfo].cpu
+    variant = ctx.attr.variant or _DEFAULT_VARIANTS.get(arch, "")
+
+    manifest_out = ctx.actions.declare_file(ctx.label.name + "_manifest.json")
+    config_out = ctx.actions.declare_file(ctx.label.name + "_config.json")
+    descriptor_out = ctx.actions.declare_file(ctx.label.name + "_descriptor.json")
+    args = ctx.actions.args()
+    args.add("manifest")
+    args.add("--empty-base")
+    args.add("--os", os)
+    args.add("--architecture", arch)
+    if variant:
+        args.add("--variant", variant)
+    args.add("--manifest", manifest_out.path)
+    args.add("--config", config_out.path)
+    args.add("--descriptor", descriptor_out.path)
+
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        outputs = [manifest_out, config_out, descriptor_out],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        mnemonic = "ImageEmptyBase",
+    )
+
+    structured_config = dict(
+        architecture = arch,
+        os = os,
+        history = [],
+    )
+    if variant:
+        structured_config["variant"] = variant
+    return [
+        DefaultInfo(files = depset([manifest_out, config_out])),
+        OutputGroupInfo(descriptor = depset([descriptor_out])),
+        ImageManifestInfo(
+            base_image = None,
+            descriptor = descriptor_out,
+            manifest = manifest_out,
+            config = config_out,
+            structured_config = structured_config,
+            architecture = arch,
+            os = os,
+            platform = {},
+            layers = [],
+            missing_blobs = [],
+        ),
+    ]
+
+image_empty_base = rule(
+    implementation = _image_empty_base_impl,
+    doc = """Creates an empty base image ("scratch") for the target platform.
+
+The image has no layers and a minimal config with the os, architecture and variant of the target platform.
+It is generated by the img tool, so nothing needs to be pulled. Use it as the `base` of `image_manifest`
+where other rule sets expect a `scratch` base image. `@rules_img//img:empty_base` is a ready-made target.
+
+The variant defaults to `v8` for `arm64` and `v7` for `arm`, the variants that container runtimes assume
+for images without one. Images built on top of the empty base inherit the variant.
+
+Example:
+
+```python
+load("@rules_img//img:image.bzl", "image_empty_base", "image_manifest")
+
+image_empty_base(
+    name = "scratch_armv6",
+    variant = "v6",
+)
+
+image_manifest(
+    name = "app",
+    base = "@rules_img//img:empty_base",
+    layers = [":app_layer"],
+)
+```
+""",
+    attrs = {
+        "variant": attr.string(
+            doc = "Variant of the architecture (like `v6` for `arm`). Defaults to `v8` for `arm64` and `v7` for `arm`.",
+        ),
+        "_os_cpu": attr.label(
+            default = Label("//img/private/config:target_os_cpu"),
+            providers = [TargetPlatformInfo],
+        ),
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    provides = [ImageManifestInfo],
+    toolchains = TOOLCHAINS,
+)
@@ -8,7 +8,8 @@ def file_metadata(
         uname = None,
         gname = None,
         mtime = None,
-        pax_records = None):
+        pax_records = None,
+        xattrs = None):
     """Creates a JSON-encoded file metadata string for use with image_layer rules.
 
     This function generates JSON metadata that can be used to customize file attributes
@@ -22,6 +23,9 @@ def file_metadata(
         gname: Group name of the file owner. String.
         mtime: Modification time in RFC3339 format (e.g., "2023-01-01T00:00:00Z"). String.
         pax_records: Dict of extended attributes to set via PAX records.
+        xattrs: Dict of extended attributes (xattrs) like `security.capability` or `security.selinux`, stored as `SCHILY.xattr.*` PAX records.
+            Values starting with "0x" are hex encoded and values starting with "0s" are base64 encoded (like for setfattr).
+            Values of `security.capability` can also be given like for setcap (e.g., "cap_net_bind_service+ep").
 
     Returns:
         JSON-encoded string containing the file metadata.
@@ -42,5 +46,7 @@ def file_metadata(
         metadata["mtime"] = mtime
     if pax_records != None:
         metadata["pax_records"] = pax_records
+    if xattrs != None:
+        metadata["xattrs"] = xattrs
 
     return json.encode(metadata)
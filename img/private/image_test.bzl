@@ -0,0 +1,157 @@
+"""Rule for testing the filesystem and config of container images without a container runtime."""
+
+load("//img/private/common:build.bzl", "RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS", "TOOLCHAINS", "get_runtime_toolchain_info")
+load("//img/private/common:transitions.bzl", "host_platform_transition", "reset_platform_transition")
+load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
+load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
+
+def _manifests(target):
+    if ImageManifestInfo in target:
+        return [target[ImageManifestInfo]]
+    if ImageIndexInfo in target:
+        return target[ImageIndexInfo].manifests
+    fail("{} must provide ImageManifestInfo or ImageIndexInfo".format(target.label))
+
+def _spec(ctx):
+    # Only set assertions are written, so that the img tool can
+    # tell an unchecked entrypoint or cmd from an empty one.
+    spec = {}
+    for key in ["file_exists", "file_not_exists", "file_mode", "file_owner", "file_contains", "env", "entrypoint", "cmd", "labels"]:
+        value = getattr(ctx.attr, key)
+        if value:
+            spec[key] = value
+    if ctx.attr.user:
+        spec["user"] = ctx.attr.user
+    if ctx.attr.working_dir:
+        spec["working_dir"] = ctx.attr.working_dir
+    return spec
+
+def _image_test_impl(ctx):
+    tester = ctx.actions.declare_file(ctx.label.name + ".exe")
+    img_toolchain_info = get_runtime_toolchain_info(ctx)
+    ctx.actions.symlink(
+        output = tester,
+        target_file = img_toolchain_info.tool_exe,
+        is_executable = True,
+    )
+
+    root_symlinks = {}
+    images = []
+    for (manifest_index, manifest_info) in enumerate(_manifests(ctx.attr.image)):
+        base_path = "image_test/{}/".format(manifest_index)
+        root_symlinks[base_path + "manifest.json"] = manifest_info.manifest
+        root_symlinks[base_path + "config.json"] = manifest_info.config
+        layers = []
+        for (layer_index, layer) in enumerate(manifest_info.layers):
+            # Layers of shallow pulled images have no blob.
+            # The img tool reports them if the test has file assertions.
+            if layer.blob == None:
+                continue
+            metadata_path = "{}metadata/{}".format(base_path, layer_index)
+            blob_path = "{}layer/{}".format(base_path, layer_index)
+            root_symlinks[metadata_path] = layer.metadata
+            root_symlinks[blob_path] = layer.blob
+            layers.append(dict(metadata = metadata_path, blob = blob_path))
+        images.append(dict(
+            manifest = base_path + "manifest.json",
+            config = base_path + "config.json",
+            layers = layers,
+        ))
+
+    request = ctx.actions.declare_file(ctx.label.name + ".image_test.json")
+    ctx.actions.write(
+        output = request,
+        content = json.encode(dict(spec = _spec(ctx), images = images)),
+    )
+    root_symlinks["image_test.json"] = request
+
+    return [
+        DefaultInfo(
+            executable = tester,
+            runfiles = ctx.runfiles(root_symlinks = root_symlinks),
+        ),
+    ]
+
+image_test = rule(
+    implementation = _image_test_impl,
+    doc = """Tests the filesystem and config of an image without a container runtime.
+
+This is a build-time alternative to `container_structure_test`: the test reads the manifest,
+config, and layer blobs of the image and evaluates the assertions directly, so it runs on
+any platform and without Docker. Paths are absolute paths in the image filesystem.
+Layers are applied in order (including whiteouts); symlinks are not followed.
+
+For images built on a pulled base image, file assertions need the blobs of all layers.
+Use `layer_handling = "eager"` on the pull rule of the base image. Config assertions work
+with any base image.
+
+If `image` is an image index, the assertions are evaluated against every image of the index.
+
+Example:
+
+```python
+load("@rules_img//img:test.bzl", "image_test")
+
+image_test(
+    name = "app_image_test",
+    image = ":app_image",
+    file_exists = ["/app/bin/server"],
+    file_not_exists = ["/app/bin/debug_tool"],
+    file_mode = {"/app/bin/server": "0755"},
+    file_owner = {"/app/bin/server": "1000:1000"},
+    file_contains = {"/etc/os-release": "debian"},
+    env = {"LANG": "C.UTF-8"},
+    entrypoint = ["/app/bin/server"],
+    user = "1000",
+)
+```
+
+The same assertions can be evaluated outside of Bazel with `img test --spec`.
+""",
+    attrs = {
+        "image": attr.label(
+            doc = "Image to test. Should provide ImageManifestInfo or ImageIndexInfo.",
+            mandatory = True,
+        ),
+        "file_exists": attr.string_list(
+            doc = "Paths that must exist in the image filesystem. Directories count as existing if they contain a file.",
+        ),
+        "file_not_exists": attr.string_list(
+            doc = "Paths that must not exist in the image filesystem.",
+        ),
+        "file_mode": attr.string_dict(
+            doc = "Mapping of paths to their expected permission bits in octal (e.g. `\"0755\"`).",
+        ),
+        "file_owner": attr.string_dict(
+            doc = "Mapping of paths to their expected owner as `uid:gid` (e.g. `\"1000:1000\"`).",
+        ),
+        "file_contains": attr.string_dict(
+            doc = "Mapping of paths of regular files to a string their contents must contain. Hardlinks (like the files of `image_layer`) are resolved to their target, symlinks are not followed.",
+        ),
+        "env": attr.string_dict(
+            doc = "Mapping of environment variables to their expected value.",
+        ),
+        "entrypoint": attr.string_list(
+            doc = "Expected entrypoint. Not checked if empty.",
+        ),
+        "cmd": attr.string_list(
+            doc = "Expected cmd. Not checked if empty.",
+        ),
+        "user": attr.string(
+            doc = "Expected user. Not checked if empty.",
+        ),
+        "working_dir": attr.string(
+            doc = "Expected working directory. Not checked if empty.",
+        ),
+        "labels": attr.string_dict(
+            doc = "Mapping of labels to their expected value.",
+        ),
+        "_tool": attr.label(
+            cfg = host_platform_transition,
+            default = Label("//img:resolved_toolchain"),
+        ),
+    } | RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS,
+    test = True,
+    cfg = reset_platform_transition,
+    toolchains = TOOLCHAINS,
+)
@@ -29,7 +29,7 @@ def _image_toolchain_impl(ctx):
         imgtoolchaininfo = image_toolchain_info,
     )
 
-    return [toolchain_info]
+    return [toolchain_info, image_toolchain_info]
 
 image_toolchain = rule(
     implementation = _image_toolchain_impl,
@@ -57,6 +57,9 @@ def _write_layer_info(ctx, manifest, config, layer_index, index_position = None)
         size = size,
         annotations = layer.get("annotations", {}),
     )
+    if "urls" in layer:
+        # foreign layers (like Windows base layers) may only be available from these URLs.
+        metadata["urls"] = layer["urls"]
     index_position_str = "" if index_position == None else str(index_position) + "_"
     layer_metadata = ctx.actions.declare_file(ctx.attr.name + "_{}{}_layer_metadata.json".format(index_position_str, layer_index))
     ctx.actions.write(layer_metadata, json.encode(metadata))
@@ -124,21 +127,25 @@ def _image_import_impl(ctx):
 
     providers = [
         DefaultInfo(files = depset([_digest_to_file(ctx, ctx.attr.digest)])),
-        PullInfo(
+    ]
+    if ctx.attr.repository:
+        # images imported from archives (see image_import) were not pulled from a registry.
+        providers.append(PullInfo(
             registries = ctx.attr.registries,
             repository = ctx.attr.repository,
             tag = ctx.attr.tag,
             digest = ctx.attr.digest,
-        ),
-    ]
+        ))
     if root_blob.get("mediaType") in [MEDIA_TYPE_MANIFEST, DOCKER_MANIFEST_V2]:
         # this is a single-platform manifest
         providers.append(_build_manifest_info(ctx, ctx.attr.digest))
     elif root_blob.get("mediaType") in [MEDIA_TYPE_INDEX, DOCKER_MANIFEST_LIST_V2]:
         # this is a multi-platform index
+        # manifests that were not pulled (see the platforms attribute of pull) are skipped.
         manifests = [
             _build_manifest_info(ctx, manifest["digest"], descriptor = manifest, index_position = position, platform = manifest.get("platform"))
             for (position, manifest) in enumerate(root_blob.get("manifests", []))
+            if manifest["digest"] in ctx.attr.data
         ]
         providers.append(ImageIndexInfo(
             index = _digest_to_file(ctx, ctx.attr.digest),
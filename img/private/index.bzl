@@ -1,8 +1,10 @@
 """Image index rule for composing multi-layer OCI images."""
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
+load("//img/private:manifest.bzl", "subject_file")
 load("//img/private:stamp.bzl", "expand_or_write")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
+load("//img/private/common:build.bzl", "TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "diagnostics_env", "get_toolchain_info")
+load("//img/private/common:layer_helper.bzl", "layer_output_groups")
 load("//img/private/common:transitions.bzl", "multi_platform_image_transition", "reset_platform_transition")
 load("//img/private/common:write_index_json.bzl", "write_index_json")
 load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
@@ -55,7 +57,7 @@ def _build_oci_layout(ctx, format, index_out, manifests):
                 inputs.append(layer.metadata)
                 inputs.append(layer.blob)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [oci_layout_output],
@@ -67,6 +69,76 @@ def _build_oci_layout(ctx, format, index_out, manifests):
 
     return oci_layout_output
 
+def _manifest_infos(target):
+    if ImageManifestInfo in target:
+        return [target[ImageManifestInfo]]
+    return target[ImageIndexInfo].manifests
+
+def _platform_overrides(ctx, manifest):
+    key = "{}/{}".format(manifest.os, manifest.architecture)
+    return (
+        ctx.attr.entrypoints.get(key),
+        ctx.attr.cmds.get(key),
+        ctx.attr.envs.get(key),
+    )
+
+def _apply_platform_overrides(ctx, index, manifest):
+    """Derive a variant of a manifest with the entrypoint, cmd, and env overrides of its platform.
+
+    Args:
+        ctx: Rule context.
+        index: Position of the manifest in the index (used to name the outputs).
+        manifest: ImageManifestInfo of the manifest.
+
+    Returns:
+        The ImageManifestInfo of the derived manifest, or the manifest itself if there are no overrides for its platform.
+    """
+    entrypoint, cmd, env = _platform_overrides(ctx, manifest)
+    if entrypoint == None and cmd == None and env == None:
+        return manifest
+
+    args = ctx.actions.args()
+    args.add("manifest")
+    args.add("--inherit-manifest", manifest.manifest.path)
+    args.add("--inherit-config", manifest.config.path)
+    args.add("--os", manifest.os)
+    args.add("--architecture", manifest.architecture)
+    args.add_all(entrypoint or [], format_each = "--entrypoint=%s")
+    args.add_all(cmd or [], format_each = "--cmd=%s")
+    args.add_all(env or [], format_each = "--env=%s")
+
+    prefix = "{}_platform_{}".format(ctx.label.name, index)
+    manifest_out = ctx.actions.declare_file(prefix + "_manifest.json")
+    config_out = ctx.actions.declare_file(prefix + "_config.json")
+    descriptor_out = ctx.actions.declare_file(prefix + "_descriptor.json")
+    digest_out = ctx.actions.declare_file(prefix + "_digest")
+    args.add("--manifest", manifest_out.path)
+    args.add("--config", config_out.path)
+    args.add("--descriptor", descriptor_out.path)
+    args.add("--digest", digest_out.path)
+
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [manifest.manifest, manifest.config],
+        outputs = [manifest_out, config_out, descriptor_out, digest_out],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        env = diagnostics_env(ctx),
+        mnemonic = "ImageManifest",
+    )
+    return ImageManifestInfo(
+        base_image = manifest.base_image,
+        descriptor = descriptor_out,
+        manifest = manifest_out,
+        config = config_out,
+        structured_config = manifest.structured_config,
+        architecture = manifest.architecture,
+        os = manifest.os,
+        platform = manifest.platform,
+        layers = manifest.layers,
+        missing_blobs = manifest.missing_blobs,
+    )
+
 def _image_index_impl(ctx):
     pull_infos = [manifest[PullInfo] for manifest in ctx.attr.manifests if PullInfo in manifest]
     pull_info = pull_infos[0] if len(pull_infos) > 0 else None
@@ -91,13 +163,15 @@ def _image_index_impl(ctx):
 
     index_out = ctx.actions.declare_file(ctx.attr.name + "_index.json")
     digest_out = ctx.actions.declare_file(ctx.label.name + "_digest")
-    manifests = [manifest[ImageManifestInfo] for manifest in ctx.attr.manifests]
+    manifests = [manifest for target in ctx.attr.manifests for manifest in _manifest_infos(target)]
+    manifests = [_apply_platform_overrides(ctx, index, manifest) for (index, manifest) in enumerate(manifests)]
     write_index_json(
         ctx,
         output = index_out,
         digest = digest_out,
         manifests = manifests,
         config_json = config_json,
+        subject = subject_file(ctx.attr.subject),
     )
     providers = [
         DefaultInfo(files = depset([index_out])),
@@ -105,6 +179,7 @@ def _image_index_impl(ctx):
             digest = depset([digest_out]),
             oci_layout = depset([_build_oci_layout(ctx, "directory", index_out, manifests)]),
             oci_tarball = depset([_build_oci_layout(ctx, "tar", index_out, manifests)]),
+            **layer_output_groups([layer for manifest in manifests for layer in manifest.layers])
         ),
         ImageIndexInfo(
             index = index_out,
@@ -157,15 +232,41 @@ image_index(
 )
 ```
 
+Example (platform-specific entrypoint):
+```python
+image_index(
+    name = "multiarch_app",
+    manifests = [":app"],
+    platforms = [
+        "//platform:linux-x86_64",
+        "//platform:windows-x86_64",
+    ],
+    # the same image definition is used for every platform,
+    # only the windows image starts the app through a wrapper script
+    entrypoints = {
+        "windows/amd64": ["cmd.exe", "/c", "run.bat"],
+    },
+    envs = {
+        "windows/amd64": ["APP_LAUNCHER=run.bat"],
+    },
+)
+```
+
 Output groups:
 - `digest`: Digest of the image (sha256:...)
 - `oci_layout`: Complete OCI layout directory with all platform blobs
 - `oci_tarball`: OCI layout packaged as a tar file for downstream use
+- `layer_metadata`: Metadata (label, digest, and size) of every layer of all platforms
+- `layer_blobs`: Blobs of every layer that is available locally
 """,
     attrs = {
         "manifests": attr.label_list(
-            providers = [ImageManifestInfo],
-            doc = "List of manifests for specific platforms.",
+            providers = [[ImageManifestInfo], [ImageIndexInfo]],
+            doc = """List of manifests for specific platforms.
+
+Image indexes (like a multi-platform image pulled from a registry) contribute all of their manifests.
+The descriptors of external manifests (including the platform `variant`, `os.version`, `os.features`, and annotations)
+are passed through unchanged. See `descriptor_validation` for indexes that don't follow the conventions of rules_img.""",
             cfg = multi_platform_image_transition,
         ),
         "platforms": attr.label_list(
@@ -177,6 +278,82 @@ Output groups:
 
 Subject to [template expansion](/docs/templating.md).""",
         ),
+        "variants": attr.string_dict(
+            doc = """Platform variant of the manifests in the index, keyed by `os/architecture`.
+
+Example: `{"linux/arm": "v7"}`.
+
+The value overrides the variant taken from the image config of all manifests with a matching platform.
+To put several variants of the same architecture into one index (like `linux/arm/v6` and `linux/arm/v7`),
+set the `variant` of each `image_manifest` instead.""",
+        ),
+        "os_versions": attr.string_dict(
+            doc = """Platform `os.version` of the manifests in the index, keyed by `os/architecture`.
+
+Example: `{"windows/amd64": "10.0.17763.5329"}`.
+
+Container runtimes on Windows use this to select an image matching the version of the host.""",
+        ),
+        "manifest_annotations": attr.string_list_dict(
+            doc = """Annotations of the manifest descriptors in the index, keyed by `os/architecture`, as a list of `key=value` pairs.
+
+Example: `{"linux/amd64": ["org.opencontainers.image.ref.name=v1-amd64"]}`.
+
+The entries of an index list the annotations of their manifests (the `annotations` of `image_manifest`
+or the descriptors of pulled indexes). The annotations given here take precedence over the annotations
+of the manifests, and an empty value (`"key="`) removes an annotation of the manifests.
+The `annotations` of `image_index` only apply to the index itself, never to its entries.""",
+        ),
+        "os_features": attr.string_list_dict(
+            doc = """Platform `os.features` of the manifests in the index, keyed by `os/architecture`.
+
+Example: `{"windows/amd64": ["win32k"]}`.""",
+        ),
+        "entrypoints": attr.string_list_dict(
+            doc = """Entrypoint of the manifests in the index, keyed by `os/architecture`.
+
+Example: `{"windows/amd64": ["cmd.exe", "/c", "run.bat"]}`.
+
+Replaces the entrypoint of all manifests with a matching platform, without having to define a separate image per platform.
+The layers and all other values of the image config are kept.""",
+        ),
+        "cmds": attr.string_list_dict(
+            doc = """Default arguments to the entrypoint of the manifests in the index, keyed by `os/architecture`.
+
+Replaces the cmd of all manifests with a matching platform (see `entrypoints`).""",
+        ),
+        "envs": attr.string_list_dict(
+            doc = """Environment variables of the manifests in the index, keyed by `os/architecture`.
+
+Example: `{"windows/amd64": ["APP_LAUNCHER=run.bat"]}`.
+
+Each value is a `KEY=value` pair. Variables are added to (or replace variables of) the environment of
+all manifests with a matching platform (see `entrypoints`).""",
+        ),
+        "descriptor_validation": attr.string(
+            default = "strict",
+            values = ["strict", "warn"],
+            doc = """How problems with the descriptors of the manifests are handled.
+
+Indexes produced by other tools may contain descriptors that rules_img wouldn't create itself,
+like attestation manifests with an `unknown/unknown` platform, manifests without a platform,
+or several manifests for the same platform.
+
+- **`strict`** (default): Fail the build.
+- **`warn`**: Print a warning and pass the descriptors through unchanged. Use this to re-push such indexes as they are.""",
+        ),
+        "subject": attr.label(
+            doc = """Optional image or image index to reference as the `subject` of this index.
+
+This sets the OCI `subject` descriptor, which allows registries supporting the referrers API
+to list all artifacts that refer to the subject. For example, a family of per-service images can
+reference a common "release" artifact, so that everything belonging to a release can be queried
+from the registry.
+
+Should provide ImageManifestInfo or ImageIndexInfo.
+""",
+            providers = [[ImageManifestInfo], [ImageIndexInfo]],
+        ),
         "build_settings": attr.string_keyed_label_dict(
             providers = [BuildSettingInfo],
             doc = """Build settings for template expansion.
@@ -216,7 +393,7 @@ See [template expansion](/docs/templating.md) for available stamp variables.
             default = Label("//img/private/settings:stamp"),
             providers = [StampSettingInfo],
         ),
-    },
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
     toolchains = TOOLCHAINS,
     cfg = reset_platform_transition,
 )
@@ -0,0 +1,98 @@
+"""Macro that splits a java_binary into layers for the JDK, dependency jars and the application jar."""
+
+load(":layer.bzl", "image_layer")
+
+# Canonical names of the remote JDK repositories of rules_java
+# (rules_java++toolchains+remotejdk21_linux, or with "~" as separator before Bazel 8).
+DEFAULT_JDK_REPOS = ["rules_java[+~]+toolchains[+~]remotejdk[^/]*"]
+
+def _regex_escape(s):
+    return "".join(["\\" + c if c in "\\.+*?()|[]{}^$" else c for c in s.elems()])
+
+def java_image_layer(
+        *,
+        name,
+        binary,
+        path,
+        deploy_jar = False,
+        jdk_repos = DEFAULT_JDK_REPOS,
+        **kwargs):
+    """Creates an `image_layer` with a `java_binary` that is split into a JDK, a dependency and an application layer.
+
+    The runfiles of the binary are assigned to layers:
+    - the JDK layer (lowest) contains the Java runtime of the toolchain,
+    - the deps layer contains the jars of all (runtime) dependencies,
+    - the main layer (highest) contains the launcher script of the binary and its own jar.
+
+    The JDK and the dependencies rarely change, so only the thin layer with the application jar
+    needs to be rebuilt and pushed when the code changes. All three layers are built by a single action
+    (using the `layer_groups` and `layer_group_excludes` of `image_layer`) and added to `image_manifest` in order.
+
+    With `deploy_jar = True`, the self-contained deploy jar of the binary (`<binary>_deploy.jar`) is stored at `path`
+    in a single layer instead. The base image needs to provide a Java runtime that runs it (`java -jar <path>`).
+
+    Example:
+
+    ```python
+    load("@rules_img//img:java.bzl", "java_image_layer")
+
+    java_image_layer(
+        name = "app_layers",
+        binary = "//src/main/java/com/example:app",
+        path = "/app/bin/app",
+    )
+
+    image_manifest(
+        name = "app_image",
+        base = "@distroless_base",
+        entrypoint = ["/app/bin/app"],
+        layers = [":app_layers"],
+    )
+    ```
+
+    Args:
+        name: Name of the `image_layer` target. The outputs of the lower layers are named `<name>_jdk` and `<name>_deps`.
+        binary: Label of the `java_binary`.
+        path: Path of the binary in the image. Its runfiles are stored at `<path>.runfiles`.
+            With `deploy_jar = True`, this is the path of the deploy jar.
+        deploy_jar: Stores the deploy jar of the binary in a single layer instead of splitting the runfiles into layers.
+        jdk_repos: Regular expressions (RE2 syntax) of the names of repositories that belong to the JDK layer.
+            The default matches the remote JDKs of rules_java.
+        **kwargs: Other attributes of `image_layer` (like `compress` or `file_metadata`).
+            `srcs` may add more files to the main layer, `layer_groups` and `layer_group_excludes` are not supported.
+    """
+    for attr in ["layer_groups", "layer_group_excludes"]:
+        if attr in kwargs:
+            fail("java_image_layer doesn't support {}".format(attr))
+    if not path.startswith("/"):
+        path = "/" + path
+    binary = native.package_relative_label(binary)
+    srcs = dict(kwargs.pop("srcs", {}))
+
+    if deploy_jar:
+        srcs[path] = binary.same_package_label(binary.name + "_deploy.jar")
+        image_layer(
+            name = name,
+            srcs = srcs,
+            **kwargs
+        )
+        return
+
+    srcs[path] = binary
+    runfiles_prefix = "^" + _regex_escape(path + ".runfiles/")
+    layer_groups = {}
+    for repo in jdk_repos:
+        layer_groups["{}({})/".format(runfiles_prefix, repo)] = "jdk"
+    layer_groups[runfiles_prefix + ".*\\.jar$"] = "deps"
+
+    # The jar with the classes of the binary itself changes with every change of the application.
+    repo = binary.repo_name or "_main"
+    app_jar = "/".join([part for part in [repo, binary.package, binary.name + ".jar"] if part])
+
+    image_layer(
+        name = name,
+        srcs = srcs,
+        layer_groups = layer_groups,
+        layer_group_excludes = {runfiles_prefix + _regex_escape(app_jar) + "$": "deps"},
+        **kwargs
+    )
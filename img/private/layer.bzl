@@ -1,9 +1,12 @@
 """Layer rule for building layers in a container image."""
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
-load("//img/private/common:layer_helper.bzl", "compression_tuning_args")
+load("//img/private/common:build.bzl", "LAYER_TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "diagnostics_env", "get_toolchain_info")
+load("//img/private/common:layer_helper.bzl", "build_ztoc", "compression_tuning_args", "external_compressor")
+load("//img/private/common:transitions.bzl", "platform_independent_layer_transition")
+load("//img/private/config:defs.bzl", "TargetPlatformInfo")
 load("//img/private/providers:layer_info.bzl", "LayerInfo")
+load("//img/private/providers:layers_info.bzl", "LayersInfo")
 
 def _file_type(f):
     type = "f"  # regular file
@@ -28,7 +31,7 @@ def _root_symlinks_arg(x):
 
 def _symlinks_arg(x):
     type = _file_type(x.target_file)
-    return "{}\0{}{}_main/{}".format(x.path, type, x.target_file.path)
+    return "_main/{}\0{}{}".format(x.path, type, x.target_file.path)
 
 def _symlink_tuple_to_arg(pair):
     source = pair[0]
@@ -37,6 +40,8 @@ def _symlink_tuple_to_arg(pair):
         source = source[1:]
     return "{}\0{}".format(source, dest)
 
+_RUNFILES_LAYOUTS = ["classic", "merged", "symlinks"]
+
 def _image_layer_impl(ctx):
     compression = ctx.attr.compress
     if compression == "auto":
@@ -62,6 +67,9 @@ def _image_layer_impl(ctx):
 
     # Set compressor defaults based on compilation mode for gzip
     args.extend(compression_tuning_args(ctx, compression, estargz_enabled))
+    compressor = external_compressor(ctx, compression, estargz_enabled)
+    if compressor != None:
+        args.extend(["--external-compressor", compressor.path])
     if estargz_enabled:
         args.append("--estargz")
     for key, value in ctx.attr.annotations.items():
@@ -71,14 +79,40 @@ def _image_layer_impl(ctx):
     for path, metadata in ctx.attr.file_metadata.items():
         path = path.removeprefix("/")  # the "/" is not included in the tar file.
         args.extend(["--file-metadata", "{}={}".format(path, metadata)])
+    for content_filter in ctx.attr.filters:
+        args.extend(["--filter", content_filter])
+    windows = ctx.attr.windows
+    if windows == "auto":
+        windows = "enabled" if ctx.attr._os_cpu[TargetPlatformInfo].os == "windows" else "disabled"
+    if windows == "enabled":
+        args.append("--windows")
+    args.extend(["--duplicate-paths", ctx.attr.duplicate_paths])
+    args.extend(["--sort", ctx.attr.sort])
+    if ctx.attr.fail_on_dangling_symlink:
+        args.append("--fail-on-dangling-symlink")
+    if ctx.attr.fail_on_duplicate_path:
+        args.append("--fail-on-duplicate-path")
+    if not ctx.attr.allow_absolute_symlinks:
+        args.append("--allow-absolute-symlinks=false")
+    if ctx.attr.max_size:
+        args.extend(["--max-size", ctx.attr.max_size])
+    if ctx.attr.max_entries > 0:
+        args.extend(["--max-entries", str(ctx.attr.max_entries)])
     files_args = ctx.actions.args()
     files_args.set_param_file_format("multiline")
     files_args.use_param_file("--add-from-file=%s", use_always = True)
 
     inputs = []
 
+    runfiles_layouts = {}
+    for (path_in_image, layout) in ctx.attr.runfiles_layout.items():
+        if layout not in _RUNFILES_LAYOUTS:
+            fail("invalid runfiles_layout {} for {}, must be one of {}".format(repr(layout), path_in_image, ", ".join(_RUNFILES_LAYOUTS)))
+        runfiles_layouts[path_in_image.removeprefix("/")] = layout
+
     for (path_in_image, files) in ctx.attr.srcs.items():
         path_in_image = path_in_image.removeprefix("/")  # the "/" is not included in the tar file.
+        args.append("--src-label={}={}".format(path_in_image, files.label))
         default_info = files[DefaultInfo]
         files_to_run = default_info.files_to_run
         executable = None
@@ -90,6 +124,8 @@ def _image_layer_impl(ctx):
             executable = files_to_run.executable
             runfiles = default_info.default_runfiles
             args.append("--executable={}={}".format(path_in_image, executable.path))
+            if path_in_image in runfiles_layouts:
+                args.append("--runfiles-layout={}={}".format(path_in_image, runfiles_layouts.pop(path_in_image)))
             executable_runfiles_args = ctx.actions.args()
             executable_runfiles_args.set_param_file_format("multiline")
             executable_runfiles_args.use_param_file("--runfiles={}=%s".format(executable.path), use_always = True)
@@ -108,37 +144,107 @@ def _image_layer_impl(ctx):
             fail("Expected {} ({}) to contain an executable or files, got None".format(path_in_image, files))
         files_args.add_all(default_info.files, map_each = _files_arg, format_each = "{}\0%s".format(path_in_image), expand_directories = False)
 
+    if runfiles_layouts:
+        fail("runfiles_layout is set for {}, which are not executables in srcs".format(", ".join(runfiles_layouts.keys())))
+
     if len(ctx.attr.symlinks) > 0:
         symlink_args = ctx.actions.args()
         symlink_args.set_param_file_format("multiline")
         symlink_args.use_param_file("--symlinks-from-file=%s", use_always = True)
         symlink_args.add_all(ctx.attr.symlinks.items(), map_each = _symlink_tuple_to_arg)
         args.append(symlink_args)
+    # Layers below the main layer that are written by the same action, from the lowest to the highest.
+    # Each is a struct of the suffix of its outputs, the blob, and the metadata.
+    lower_layers = []
+    if ctx.attr.split_runfiles:
+        split = _lower_layer(ctx, "_third_party", out_ext)
+        args.extend(["--split-runfiles-output", split.blob.path, "--split-runfiles-metadata", split.metadata.path])
+        for repo in ctx.attr.third_party_repos:
+            args.extend(["--third-party-repo", repo])
+        lower_layers.append(split)
+    elif ctx.attr.third_party_repos:
+        fail("third_party_repos requires split_runfiles = True")
+    groups = []
+    for (pattern, group) in ctx.attr.layer_groups.items():
+        _check_group_name(group)
+        if ctx.attr.split_runfiles and group == "third_party":
+            fail("the layer group third_party is reserved for split_runfiles")
+        if group not in groups:
+            groups.append(group)
+        args.extend(["--layer-group", "{}={}".format(group, pattern)])
+    for (pattern, group) in ctx.attr.layer_group_excludes.items():
+        if group not in groups:
+            fail("layer_group_excludes refers to {}, which is not a group of layer_groups".format(repr(group)))
+        args.extend(["--layer-group-exclude", "{}={}".format(group, pattern)])
+    for group in groups:
+        group_layer = _lower_layer(ctx, "_" + group, out_ext)
+        args.extend(["--layer-group-output", "{}={}".format(group, group_layer.blob.path)])
+        args.extend(["--layer-group-metadata", "{}={}".format(group, group_layer.metadata.path)])
+        lower_layers.append(group_layer)
     args.append(files_args)
     args.append(out.path)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    outputs = [out, metadata_out]
+    for lower_layer in lower_layers:
+        outputs.extend([lower_layer.blob, lower_layer.metadata])
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
-        outputs = [out, metadata_out],
+        outputs = outputs,
         inputs = depset(transitive = inputs),
         executable = img_toolchain_info.tool_exe,
+        tools = [compressor] if compressor != None else [],
         arguments = args,
+        env = diagnostics_env(ctx),
         mnemonic = "LayerTar",
     )
-    return [
-        DefaultInfo(files = depset([out])),
-        OutputGroupInfo(
-            layer = depset([out]),
-            metadata = depset([metadata_out]),
-        ),
-        LayerInfo(
-            blob = out,
-            metadata = metadata_out,
+
+    soci_ztoc = ctx.attr.soci_ztoc
+    if soci_ztoc == "auto":
+        soci_ztoc = ctx.attr._default_soci_ztoc[BuildSettingInfo].value
+    build_ztocs = soci_ztoc == "enabled" and compression == "gzip" and not estargz_enabled
+    if ctx.attr.soci_ztoc == "enabled" and not build_ztocs:
+        fail("soci_ztoc requires a gzip layer without estargz")
+
+    layer_infos = []
+    for layer in lower_layers + [struct(suffix = "", blob = out, metadata = metadata_out)]:
+        ztoc_out = None
+        if build_ztocs:
+            ztoc_out = ctx.actions.declare_file(ctx.attr.name + layer.suffix + ".ztoc")
+            build_ztoc(ctx = ctx, tar_file = layer.blob, output = ztoc_out)
+        layer_infos.append(LayerInfo(
+            blob = layer.blob,
+            metadata = layer.metadata,
             media_type = media_type,
             estargz = estargz_enabled,
+            ztoc = ztoc_out,
+        ))
+
+    blobs = [layer.blob for layer in layer_infos]
+    return [
+        DefaultInfo(files = depset(blobs)),
+        OutputGroupInfo(
+            layer = depset(blobs),
+            metadata = depset([layer.metadata for layer in layer_infos]),
+            ztoc = depset([layer.ztoc for layer in layer_infos if layer.ztoc != None]),
         ),
+        # targets that build more than one layer provide all of them (lowest first)
+        layer_infos[0] if len(layer_infos) == 1 else LayersInfo(layers = layer_infos),
     ]
 
+def _lower_layer(ctx, suffix, out_ext):
+    return struct(
+        suffix = suffix,
+        blob = ctx.actions.declare_file(ctx.attr.name + suffix + out_ext),
+        metadata = ctx.actions.declare_file(ctx.attr.name + suffix + "_metadata.json"),
+    )
+
+def _check_group_name(group):
+    if not group:
+        fail("names of layer_groups must not be empty")
+    for c in group.elems():
+        if not (c.isalnum() or c in "_-."):
+            fail("invalid name of layer group {}: only letters, digits, '_', '-' and '.' are allowed".format(repr(group)))
+
 image_layer = rule(
     implementation = _image_layer_impl,
     doc = """Creates a container image layer from files, executables, and directories.
@@ -181,6 +287,51 @@ image_layer(
     },
 )
 
+# Layer with a binary that may bind to privileged ports as non-root user
+image_layer(
+    name = "server_layer",
+    srcs = {
+        "/app/bin/server": "//cmd/server",
+    },
+    file_metadata = {
+        "/app/bin/server": file_metadata(
+            xattrs = {"security.capability": "cap_net_bind_service+ep"},
+        ),
+    },
+)
+
+# Layer with a binary, whose third-party runfiles are in a separate (rarely changing) layer
+image_layer(
+    name = "python_app_layer",
+    srcs = {
+        "/app/bin/app": "//cmd/app:app_py",
+    },
+    split_runfiles = True,
+)
+
+# Layer whose static assets are in a separate layer below the binary
+image_layer(
+    name = "web_layer",
+    srcs = {
+        "/app/bin/web": "//cmd/web",
+        "/app/static": "//web:assets",
+    },
+    layer_groups = {
+        "^/app/static/": "assets",
+    },
+)
+
+# Layer with a binary whose runfiles tree is a symlink farm, like the runfiles of `bazel run`
+image_layer(
+    name = "symlinked_runfiles_layer",
+    srcs = {
+        "/app/bin/app": "//cmd/app:app_py",
+    },
+    runfiles_layout = {
+        "/app/bin/app": "symlinks",
+    },
+)
+
 # Layer with symlinks
 image_layer(
     name = "bin_layer",
@@ -196,7 +347,9 @@ image_layer(
     attrs = {
         "srcs": attr.string_keyed_label_dict(
             doc = """Files to include in the layer. Keys are paths in the image (e.g., "/app/bin/server"),
-values are labels to files or executables. Executables automatically include their runfiles.""",
+values are labels to files or executables. Executables automatically include their runfiles.
+Shared libraries of dynamically linked executables (like `cc_binary` targets with dynamic deps) are stored in the `_solib_*`
+directories of the runfiles tree and found through the RUNPATH of the executable, even if it has a different name in the image.""",
             allow_files = True,
         ),
         "symlinks": attr.string_dict(
@@ -208,11 +361,52 @@ values are the targets they point to.""",
             values = ["auto", "gzip", "zstd"],
             doc = """Compression algorithm to use. If set to 'auto', uses the global default compression setting.""",
         ),
+        "compression_level": attr.string(
+            default = "auto",
+            values = ["auto", "fastest", "best", "-1", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9"],
+            doc = """Compression level of the layer. If set to 'auto', uses the global compression level setting
+(`--@rules_img//img/settings:compression_level`), which defaults to fast compression in fastbuild and the best compression in opt.
+"fastest" and "best" select the fastest and best level of the compression algorithm.
+gzip supports levels -1 (default) to 9, zstd supports levels 1 to 4.
+Use this for layers that are rebuilt often (like the layer of the binary in the inner development loop),
+or for big, rarely changing layers that are worth compressing better.""",
+        ),
         "estargz": attr.string(
             default = "auto",
             values = ["auto", "enabled", "disabled"],
             doc = """Whether to use estargz format. If set to 'auto', uses the global default estargz setting.
 When enabled, the layer will be optimized for lazy pulling and will be compatible with the estargz format.""",
+        ),
+        "runfiles_layout": attr.string_dict(
+            doc = """Layout of the runfiles trees of executables in srcs. Keys are paths of executables in srcs, values are layouts:
+- `"classic"` (default): runfiles are stored at `<executable>.runfiles/<repo>/<path>`.
+- `"merged"`: the runfiles of all repositories are stored below the main repository, at `<executable>.runfiles/_main/external/<repo>/<path>`
+  (like `--legacy_external_runfiles`), for tools that only look at the main repository.
+- `"symlinks"`: every runfile is a symlink to a single copy of its content, like the runfiles trees that Bazel creates on Linux
+  with `--enable_runfiles`. Per-file metadata doesn't apply to these runfiles.""",
+        ),
+        "split_runfiles": attr.bool(
+            default = False,
+            doc = """If set, the runfiles of third-party repositories (see `third_party_repos`) are written to a separate layer
+below the layer with the executables and all other files. Third-party runfiles rarely change,
+so the lower layer stays the same (and doesn't need to be pushed again) when only first-party code changes.
+Both layers are built by a single action. The target provides `LayersInfo` (instead of `LayerInfo`) with both layers,
+which `image_manifest` adds in order. With `layer_groups`, the layer of the third-party runfiles is the lowest layer
+and only contains the runfiles that don't belong to a group.""",
+        ),
+        "third_party_repos": attr.string_list(
+            doc = """Names (or prefixes of canonical names, like `rules_python++pip+`) of the repositories
+whose runfiles are written to the separate layer of `split_runfiles`.
+If empty, the runfiles of all repositories except the main repository are third-party.""",
+        ),
+        "soci_ztoc": attr.string(
+            default = "auto",
+            values = ["auto", "enabled", "disabled"],
+            doc = """Whether to emit a SOCI ztoc (table of contents and decompression checkpoints) next to the layer.
+If set to 'auto', uses the global default soci_ztoc setting (`--@rules_img//img/settings:soci_ztoc`).
+Ztocs are only built for gzip layers without estargz and are available in the `ztoc` output group.
+`image_push` with `soci_index = True` pushes them as part of a SOCI index, so that the soci-snapshotter
+(used by AWS Fargate and containerd) can lazily load the image.""",
         ),
         "annotations": attr.string_dict(
             default = {},
@@ -221,13 +415,95 @@ When enabled, the layer will be optimized for lazy pulling and will be compatibl
         "default_metadata": attr.string(
             default = "",
             doc = """JSON-encoded default metadata to apply to all files in the layer.
-Can include fields like mode, uid, gid, uname, gname, mtime, and pax_records.""",
+Can include fields like mode, uid, gid, uname, gname, mtime, pax_records, and xattrs.""",
         ),
         "file_metadata": attr.string_dict(
             default = {},
             doc = """Per-file metadata overrides as a dict mapping file paths to JSON-encoded metadata.
 The path should match the path in the image (the key in srcs attribute).
 Metadata specified here overrides any defaults from default_metadata.""",
+        ),
+        "filters": attr.string_list(
+            default = [],
+            doc = """Content filters applied to files as they are stored in the layer, in order.
+Filters fix reproducibility issues of files produced by other rules.
+Available filters:
+- `"pyc=normalize"`: sets the source mtime embedded in timestamp-based Python bytecode (`.pyc`) to the mtime of the file in the layer, so the bytecode is reproducible and stays valid.
+- `"pyc=drop"`: removes `.pyc` files and `__pycache__` directories from the layer.
+- `"jar"`: rewrites `.jar`, `.war` and `.ear` archives so that all entries have a fixed timestamp (2010-01-01T00:00:00Z) and are sorted by name (with `META-INF/MANIFEST.MF` first). Extra fields with timestamps or file owners are removed. Entry data is copied without recompression.
+- `"jar=<timestamp>"`: like `"jar"`, with the given RFC 3339 timestamp (not before 1980).""",
+        ),
+        "windows": attr.string(
+            default = "auto",
+            values = ["auto", "enabled", "disabled"],
+            doc = """Whether to build a Windows container layer. If set to 'auto', this is enabled when the target platform is Windows.
+Windows layers store all files below `Files/` and contain the `Files/` and `Hives/` directories expected by the Windows container runtime.
+Paths in srcs, symlinks and file_metadata are given without the `Files/` prefix.""",
+        ),
+        "duplicate_paths": attr.string(
+            default = "rename",
+            values = ["rename", "error", "last-wins"],
+            doc = """What to do if a path in the image is provided by more than one file.
+This happens if a label in srcs provides several files (like a filegroup) or if two keys of srcs only differ by a leading slash.
+- `"rename"` (default): adds the basename of each file to the path in the image (e.g., `/app/lib/libfoo.so` for `/app/lib`).
+- `"error"`: fails the build and lists the conflicting labels and files.
+- `"last-wins"`: only keeps the file that is added last and prints a warning.""",
+        ),
+        "sort": attr.string(
+            default = "none",
+            values = ["none", "path"],
+            doc = """Order of the entries in the layer.
+- `"none"` (default): entries are written in the order they are added, which depends on the order of srcs and symlinks.
+- `"path"`: all entries are sorted by path (hardlinks stay behind their targets), so that reordering srcs in the BUILD file produces the same layer digest and cache hits.""",
+        ),
+        "allow_absolute_symlinks": attr.bool(
+            default = True,
+            doc = """Whether symlinks may have absolute targets.
+Absolute targets are resolved against the root of the container, which is a common source of surprises when the same files are also used outside of the container.
+Set this to False to fail the build for every absolute symlink in the layer.""",
+        ),
+        "fail_on_dangling_symlink": attr.bool(
+            default = False,
+            doc = """If set, fails the build if a symlink in the layer points to a path that does not exist in the layer.
+Only the layer itself is checked, so symlinks into other layers (like the base image) are reported as dangling.""",
+        ),
+        "fail_on_duplicate_path": attr.bool(
+            default = False,
+            doc = """If set, fails the build if a path is written more than once to the layer and reports the colliding sources.
+Unlike `duplicate_paths`, this covers all entries of the layer (including runfiles and symlinks). Directories may be written more than once.""",
+        ),
+        "max_size": attr.string(
+            doc = """Maximum size of the compressed layer, in bytes with optional unit (e.g. `"500MiB"` or `"2GB"`).
+If the layer is larger, the build fails with a breakdown of the inputs and files that contribute the most.
+This catches accidentally added data (like test fixtures) at build time instead of at push time.""",
+        ),
+        "layer_group_excludes": attr.string_dict(
+            doc = """Keeps files out of groups of `layer_groups`. Keys are regular expressions (RE2 syntax) matched against absolute paths in the image,
+values are names of groups. A file that matches an expression of a group is never part of the group
+(e.g. `{"^/usr/lib/": "deps"}` and `{"^/usr/lib/libapp\\.so$": "deps"}` keep `libapp.so` in the main layer).""",
+        ),
+        "layer_groups": attr.string_dict(
+            doc = """Moves files into separate layers by their paths in the image.
+Keys are regular expressions (RE2 syntax) matched against absolute paths in the image, values are names of groups
+(e.g. `{"^/usr/lib/": "deps", "^/app/static/": "assets"}`). A file belongs to the group of the first matching expression
+and all other files stay in the main layer. Runfiles of executables are assigned one by one, imported tar files stay in the main layer.
+
+Every group is a separate layer below the main layer (in the order the groups first appear), so that files
+that rarely change don't need to be pushed again when others change. All layers are built by a single action.
+The target provides `LayersInfo` (instead of `LayerInfo`) with all layers, which `image_manifest` adds in order.""",
+        ),
+        "max_entries": attr.int(
+            default = 0,
+            doc = """Maximum number of entries (files, directories, and symlinks) in the layer.
+If the layer has more entries, the build fails with a breakdown of the inputs and files that contribute the most. 0 disables the check.""",
+        ),
+        "platform_independent": attr.bool(
+            doc = """If set, the layer is built once for all platforms of a multi-platform image.
+
+Use this for layers whose content doesn't depend on the target platform (like static assets or configuration files),
+so that `image_index` shares a single layer artifact between all platforms instead of building and compressing
+the layer per platform. The srcs are built for a fixed platform (linux/amd64, or windows/amd64 if `windows = "enabled"`),
+so srcs must not contain platform-specific outputs like binaries. Set `windows` explicitly for Windows layers.""",
         ),
         "_default_compression": attr.label(
             default = Label("//img/settings:compress"),
@@ -237,6 +513,10 @@ Metadata specified here overrides any defaults from default_metadata.""",
             default = Label("//img/settings:estargz"),
             providers = [BuildSettingInfo],
         ),
+        "_default_soci_ztoc": attr.label(
+            default = Label("//img/settings:soci_ztoc"),
+            providers = [BuildSettingInfo],
+        ),
         "_compression_jobs": attr.label(
             default = Label("//img/settings:compression_jobs"),
             providers = [BuildSettingInfo],
@@ -245,7 +525,11 @@ Metadata specified here overrides any defaults from default_metadata.""",
             default = Label("//img/settings:compression_level"),
             providers = [BuildSettingInfo],
         ),
-    },
-    toolchains = TOOLCHAINS,
-    provides = [LayerInfo],
+        "_os_cpu": attr.label(
+            default = Label("//img/private/config:target_os_cpu"),
+            providers = [TargetPlatformInfo],
+        ),
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    toolchains = LAYER_TOOLCHAINS,
+    cfg = platform_independent_layer_transition,
 )
@@ -1,8 +1,9 @@
 """Layer rule for converting existing tar files to usable layers."""
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
-load("//img/private/common:build.bzl", "TOOLCHAINS")
+load("//img/private/common:build.bzl", "LAYER_TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS")
 load("//img/private/common:layer_helper.bzl", "allow_tar_files", "calculate_layer_info", "extension_to_compression", "optimize_layer", "recompress_layer")
+load("//img/private/common:transitions.bzl", "platform_independent_layer_transition")
 load("//img/private/providers:layer_info.bzl", "LayerInfo")
 
 def _layer_from_tar_impl(ctx):
@@ -69,6 +70,7 @@ def _layer_from_tar_impl(ctx):
             target_compression = target_compression,
             estargz = estargz_enabled,
             annotations = ctx.attr.annotations,
+            preserve_hardlinks = ctx.attr.preserve_hardlinks,
         )
 
     return [
@@ -135,9 +137,24 @@ layer_from_tar(
             values = ["auto", "gzip", "zstd"],
             doc = """Compression algorithm to use. If set to 'auto', uses the global default compression setting.""",
         ),
+        "compression_level": attr.string(
+            default = "auto",
+            values = ["auto", "fastest", "best", "-1", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9"],
+            doc = """Compression level of the layer. If set to 'auto', uses the global compression level setting
+(`--@rules_img//img/settings:compression_level`), which defaults to fast compression in fastbuild and the best compression in opt.
+"fastest" and "best" select the fastest and best level of the compression algorithm.
+gzip supports levels -1 (default) to 9, zstd supports levels 1 to 4.
+Use this for layers that are rebuilt often (like the layer of the binary in the inner development loop),
+or for big, rarely changing layers that are worth compressing better.""",
+        ),
         "optimize": attr.bool(
             doc = """If set, rewrites the tar file to deduplicate it's contents.
 This is useful for reducing the size of the image, but will take extra time and space to store the optimized layer.""",
+        ),
+        "preserve_hardlinks": attr.bool(
+            doc = """If set together with `optimize`, hardlinks of the tar file keep pointing to their original targets.
+Files that are the target of a hardlink are stored in place instead of being deduplicated, so they never share an inode with unrelated files of the same content.
+All other files are still deduplicated.""",
         ),
         "estargz": attr.string(
             default = "auto",
@@ -149,6 +166,13 @@ When enabled, the layer will be optimized for lazy pulling and will be compatibl
             default = {},
             doc = """Annotations to add to the layer metadata as key-value pairs.""",
         ),
+        "platform_independent": attr.bool(
+            doc = """If set, the layer is built once for all platforms of a multi-platform image.
+
+Use this for layers whose content doesn't depend on the target platform (like static assets or tar files of
+third-party data), so that `image_index` shares a single layer artifact between all platforms instead of recompressing
+the layer per platform. The src is built for a fixed platform (linux/amd64).""",
+        ),
         "_default_compression": attr.label(
             default = Label("//img/settings:compress"),
             providers = [BuildSettingInfo],
@@ -165,7 +189,8 @@ When enabled, the layer will be optimized for lazy pulling and will be compatibl
             default = Label("//img/settings:compression_level"),
             providers = [BuildSettingInfo],
         ),
-    },
-    toolchains = TOOLCHAINS,
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    toolchains = LAYER_TOOLCHAINS,
     provides = [LayerInfo],
+    cfg = platform_independent_layer_transition,
 )
@@ -3,10 +3,9 @@
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
 load("//img/private:root_symlinks.bzl", "calculate_root_symlinks")
 load("//img/private:stamp.bzl", "expand_or_write")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
+load("//img/private/common:build.bzl", "RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS", "TOOLCHAINS", "get_runtime_toolchain_info", "get_toolchain_info")
 load("//img/private/common:transitions.bzl", "host_platform_transition", "reset_platform_transition")
 load("//img/private/providers:deploy_info.bzl", "DeployInfo")
-load("//img/private/providers:image_toolchain_info.bzl", "ImageToolchainInfo")
 load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
 load("//img/private/providers:load_settings_info.bzl", "LoadSettingsInfo")
 load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
@@ -30,6 +29,14 @@ def _daemon(ctx):
         daemon = load_settings.daemon
     return daemon
 
+def _get_tags(ctx):
+    """Get the list of tags from the context, validating mutual exclusivity."""
+    if ctx.attr.tag and ctx.attr.tag_list:
+        fail("Cannot specify both 'tag' and 'tag_list' attributes")
+    if ctx.attr.tag:
+        return [ctx.attr.tag]
+    return ctx.attr.tag_list
+
 def _target_info(ctx):
     pull_info = ctx.attr.image[PullInfo] if PullInfo in ctx.attr.image else None
     if pull_info == None:
@@ -81,7 +88,7 @@ def _compute_load_metadata(*, ctx, configuration_json):
 
     metadata_out = ctx.actions.declare_file(ctx.label.name + ".json")
     args.add(metadata_out.path)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [metadata_out],
@@ -123,7 +130,7 @@ def _build_docker_tarball(ctx, configuration_json, manifest_info):
             inputs.append(layer.metadata)
             inputs.append(layer.blob)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [tarball_output],
@@ -138,7 +145,7 @@ def _build_docker_tarball(ctx, configuration_json, manifest_info):
 def _image_load_impl(ctx):
     """Implementation of the load rule."""
     loader = ctx.actions.declare_file(ctx.label.name + ".exe")
-    img_toolchain_info = ctx.attr._tool[0][ImageToolchainInfo]
+    img_toolchain_info = get_runtime_toolchain_info(ctx)
     ctx.actions.symlink(
         output = loader,
         target_file = img_toolchain_info.tool_exe,
@@ -158,7 +165,7 @@ def _image_load_impl(ctx):
     root_symlinks = calculate_root_symlinks(index_info, manifest_info, include_layers = include_layers)
 
     templates = dict(
-        tag = ctx.attr.tag,
+        tags = _get_tags(ctx),
         daemon = _daemon(ctx),
     )
 
@@ -185,10 +192,15 @@ def _image_load_impl(ctx):
             environment = {
                 "IMG_REAPI_ENDPOINT": ctx.attr._load_settings[LoadSettingsInfo].remote_cache,
                 "IMG_CREDENTIAL_HELPER": ctx.attr._load_settings[LoadSettingsInfo].credential_helper,
+                "IMG_REGISTRY_PLAIN_HTTP": ",".join(ctx.attr._load_settings[LoadSettingsInfo].plain_http_registries),
             },
             inherited_environment = [
                 "IMG_REAPI_ENDPOINT",
                 "IMG_CREDENTIAL_HELPER",
+                "IMG_REGISTRY_CA_BUNDLE",
+                "IMG_REGISTRY_INSECURE_SKIP_VERIFY",
+                "IMG_REGISTRY_PLAIN_HTTP",
+                "IMG_REGISTRIES_CONF",
             ],
         ),
         DeployInfo(
@@ -246,6 +258,13 @@ image_load(
     daemon = "containerd",  # Explicitly use containerd
 )
 
+# Load with multiple tags
+image_load(
+    name = "load_tagged",
+    image = ":my_app",
+    tag_list = ["my-app:latest", "my-app:dev"],
+)
+
 # Load with dynamic tagging
 image_load(
     name = "load_dynamic",
@@ -265,6 +284,9 @@ bazel run //path/to:load_app
 # Load specific platform only
 bazel run //path/to:load_multiarch -- --platform linux/arm64
 
+# Print the loaded images (daemon, digest and tags) as JSON
+bazel run //path/to:load_app -- --output-format=json
+
 # Build Docker save tarball
 bazel build //path/to:load_app --output_groups=tarball
 ```
@@ -275,6 +297,15 @@ Performance notes:
 - For older Docker versions, falls back to `docker load` which requires building
   a tar file (slower and limited to single-platform images)
 - The `--platform` flag filters which platforms are loaded from multi-platform images
+
+Hot-swapping a running dev container (experimental):
+```bash
+# Apply only the changed layers to the rootfs of a running container and send it SIGHUP
+bazel run //path/to:load_app -- --hot-swap-container $(docker inspect -f '{{.Id}}' my-dev) --hot-swap-signal HUP
+```
+This requires loading into containerd (the containerd image store of Docker). The container is
+paused while the layers that differ from its image are unpacked into its writable layer.
+Files removed from the image are kept in the container, so recreate it to get the exact image.
 """,
     attrs = {
         "image": attr.label(
@@ -302,6 +333,18 @@ The best performance is achieved with:
         "tag": attr.string(
             doc = "Tag to apply when loading the image. Subject to [template expansion](/docs/templating.md).",
         ),
+        "tag_list": attr.string_list(
+            doc = """List of tags to apply when loading the image.
+
+Useful for giving the same image multiple local names in a single load:
+
+```python
+tag_list = ["my-app:latest", "my-app:dev"]
+```
+
+Cannot be used together with `tag`. Each tag is subject to [template expansion](/docs/templating.md).
+""",
+        ),
         "strategy": attr.string(
             doc = """Strategy for handling image layers during load.
 
@@ -316,11 +359,33 @@ Available strategies:
             values = ["auto", "eager", "lazy"],
         ),
         "build_settings": attr.string_keyed_label_dict(
-            doc = "Build settings to use for [template expansion](/docs/templating.md). Keys are setting names, values are labels to string_flag targets.",
+            doc = """Build settings for template expansion.
+
+Maps template variable names to string_flag targets. These values can be used in
+the tag attribute using `{{.VARIABLE_NAME}}` syntax (Go template).
+
+Example:
+```python
+build_settings = {
+    "REGISTRY": "//settings:docker_registry",
+    "VERSION": "//settings:app_version",
+}
+```
+
+See [template expansion](/docs/templating.md) for more details.
+""",
             providers = [BuildSettingInfo],
         ),
         "stamp": attr.string(
-            doc = "Whether to use stamping for [template expansion](/docs/templating.md). If 'enabled', uses volatile-status.txt and version.txt if present. 'auto' uses the global default setting.",
+            doc = """Enable build stamping for template expansion.
+
+Controls whether to include volatile build information:
+- **`auto`** (default): Uses the global stamping configuration
+- **`enabled`**: Always include stamp information (BUILD_TIMESTAMP, BUILD_USER, etc.) if Bazel's "--stamp" flag is set
+- **`disabled`**: Never include stamp information
+
+See [template expansion](/docs/templating.md) for available stamp variables.
+""",
             default = "auto",
             values = ["auto", "enabled", "disabled"],
         ),
@@ -340,7 +405,7 @@ Available strategies:
             cfg = host_platform_transition,
             default = Label("//img:resolved_toolchain"),
         ),
-    },
+    } | RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS,
     executable = True,
     cfg = reset_platform_transition,
     toolchains = TOOLCHAINS,
@@ -0,0 +1,88 @@
+"""Rule for updating the lock file of base image digests."""
+
+load("//img/private/common:build.bzl", "RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS", "TOOLCHAINS", "get_runtime_toolchain_info")
+load("//img/private/common:transitions.bzl", "host_platform_transition", "reset_platform_transition")
+
+def _image_lock_impl(ctx):
+    lock_file = ctx.file.lock_file
+    if not lock_file.is_source or lock_file.owner.workspace_name != "":
+        fail("lock_file must be a source file of the main repository, got {}".format(lock_file.owner))
+
+    locker = ctx.actions.declare_file(ctx.label.name + ".exe")
+    img_toolchain_info = get_runtime_toolchain_info(ctx)
+    ctx.actions.symlink(
+        output = locker,
+        target_file = img_toolchain_info.tool_exe,
+        is_executable = True,
+    )
+
+    request = ctx.actions.declare_file(ctx.label.name + ".lock.json")
+    ctx.actions.write(
+        output = request,
+        content = json.encode(dict(
+            lock_file = lock_file.short_path,
+            images = ctx.attr.images,
+        )),
+    )
+
+    return [
+        DefaultInfo(
+            executable = locker,
+            runfiles = ctx.runfiles(root_symlinks = {"lock.json": request}),
+        ),
+    ]
+
+image_lock = rule(
+    implementation = _image_lock_impl,
+    doc = """Resolves floating tags of base images to digests and writes them to a checked-in lock file.
+
+The lock file is consumed by the `lock_file` attribute of the `pull` repository rule,
+so that pulls with a `tag` (and without a `digest`) are pinned to the locked digest.
+
+`bazel run` resolves images that are not locked yet and keeps the digests of all others.
+Pass `--update` to refresh the digests of all images in the lock file,
+or `--check` to fail if an image is missing from the lock file (e.g., in CI).
+
+Example:
+
+```python
+load("@rules_img//img:lock.bzl", "image_lock")
+
+image_lock(
+    name = "lock",
+    images = [
+        "index.docker.io/library/ubuntu:24.04",
+        "gcr.io/distroless/cc-debian12:nonroot",
+    ],
+    lock_file = "img.lock.json",
+)
+```
+
+```bash
+bazel run //:lock             # lock new images
+bazel run //:lock -- --update # refresh all digests
+```
+
+The lock file must exist before the first run (an empty file or `{}` is fine).
+The same operations are available outside of Bazel with `img lock`.
+""",
+    attrs = {
+        "images": attr.string_list(
+            doc = """Images to lock in the form `registry/repository:tag`.
+
+The registry must be spelled exactly like the `registry` attribute of the corresponding `pull` rule.""",
+        ),
+        "lock_file": attr.label(
+            doc = "The lock file (JSON) in the main repository. It is updated in place.",
+            allow_single_file = [".json"],
+            mandatory = True,
+        ),
+        "_tool": attr.label(
+            cfg = host_platform_transition,
+            default = Label("//img:resolved_toolchain"),
+        ),
+    } | RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS,
+    executable = True,
+    cfg = reset_platform_transition,
+    toolchains = TOOLCHAINS,
+)
@@ -2,12 +2,13 @@
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
 load("//img/private:stamp.bzl", "expand_or_write")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
-load("//img/private/common:layer_helper.bzl", "allow_tar_files", "calculate_layer_info", "extension_to_compression")
+load("//img/private/common:build.bzl", "LAYER_TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "diagnostics_env", "get_toolchain_info")
+load("//img/private/common:layer_helper.bzl", "allow_tar_files", "calculate_layer_info", "extension_to_compression", "layer_output_groups", "squash_layers")
 load("//img/private/common:transitions.bzl", "normalize_layer_transition")
 load("//img/private/config:defs.bzl", "TargetPlatformInfo")
 load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
 load("//img/private/providers:layer_info.bzl", "LayerInfo")
+load("//img/private/providers:layers_info.bzl", "LayersInfo")
 load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
 load("//img/private/providers:oci_layout_settings_info.bzl", "OCILayoutSettingsInfo")
 load("//img/private/providers:pull_info.bzl", "PullInfo")
@@ -23,6 +24,8 @@ def _platform_matches(wanted_platform, manifest):
         return False
     if wanted_platform["architecture"] != manifest.architecture:
         return False
+    if wanted_platform["variant"] and wanted_platform["variant"] != manifest.structured_config.get("variant", ""):
+        return False
     for key in wanted_platform["platform"].keys():
         if key not in manifest:
             return False
@@ -51,14 +54,34 @@ def select_base(ctx):
     constraints_wanted = dict(
         os = os_wanted,
         architecture = arch_wanted,
+        variant = ctx.attr.variant,
         platform = ctx.attr.platform,
     )
 
     for manifest in ctx.attr.base[ImageIndexInfo].manifests:
         if _platform_matches(constraints_wanted, manifest):
             return manifest
+    if ctx.attr.variant:
+        fail("no matching base image found for architecture {}, variant {} and os {}".format(constraints_wanted["architecture"], ctx.attr.variant, constraints_wanted["os"]))
     fail("no matching base image found for architecture {} and os {}".format(constraints_wanted["architecture"], constraints_wanted["os"]))
 
+def subject_file(subject):
+    """Returns the raw manifest or index of a subject target.
+
+    Args:
+        subject: Target providing ImageManifestInfo or ImageIndexInfo (or None).
+
+    Returns:
+        The raw manifest or index file, or None if no subject is given.
+    """
+    if subject == None:
+        return None
+    if ImageManifestInfo in subject:
+        return subject[ImageManifestInfo].manifest
+    if ImageIndexInfo in subject:
+        return subject[ImageIndexInfo].index
+    fail("subject must provide ImageManifestInfo or ImageIndexInfo")
+
 def _build_oci_layout(ctx, format, manifest_out, config_out, layers):
     """Build the OCI layout for the image.
 
@@ -98,7 +121,7 @@ def _build_oci_layout(ctx, format, manifest_out, config_out, layers):
             inputs.append(layer.metadata)
             inputs.append(layer.blob)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [oci_layout_output],
@@ -110,6 +133,21 @@ def _build_oci_layout(ctx, format, manifest_out, config_out, layers):
 
     return oci_layout_output
 
+def _squash(ctx, layers):
+    """Squashes the layers of this image (but not the base image) into one."""
+    for layer in layers:
+        if layer.blob == None:
+            fail("squash = True requires the blobs of all layers, but a layer is shallow: {}".format(layer.metadata))
+    compression = ctx.attr._default_compression[BuildSettingInfo].value
+    return squash_layers(
+        ctx = ctx,
+        layers = layers,
+        metadata_file = ctx.actions.declare_file(ctx.label.name + "_squashed_metadata.json"),
+        output = ctx.actions.declare_file(ctx.label.name + ("_squashed.tgz" if compression == "gzip" else "_squashed.tar.zst")),
+        target_compression = compression,
+        estargz = ctx.attr._default_estargz[BuildSettingInfo].value == "enabled",
+    )
+
 def _image_manifest_impl(ctx):
     inputs = []
     providers = []
@@ -129,13 +167,26 @@ def _image_manifest_impl(ctx):
         args.add("--base-config", base.config.path)
     if ctx.attr.base != None and PullInfo in ctx.attr.base:
         providers.append(ctx.attr.base[PullInfo])
+
+    base_layer_count = len(layers)
+
+    # history descriptions by label, since the layers are built in a different configuration
+    descriptions = {target.label: description for (target, description) in ctx.attr.history_created_by.items()}
+    described = {}
     for (layer_idx, layer) in enumerate(ctx.attr.layers):
+        if layer.label in descriptions:
+            # index of the first layer of this target, counting base layers
+            described[layer.label] = len(layers)
+        if LayersInfo in layer:
+            # Targets that build more than one layer
+            layers.extend(layer[LayersInfo].layers)
+            continue
         if LayerInfo in layer:
             # Use pre-built layer metadata
             layers.append(layer[LayerInfo])
             continue
         elif DefaultInfo not in layer:
-            fail("layer {} needs to provide LayerInfo or DefaultInfo: {}".format(layer_idx, layer))
+            fail("layer {} needs to provide LayerInfo, LayersInfo or DefaultInfo: {}".format(layer_idx, layer))
 
         # Calculate layer metadata on the fly
         default_info = layer[DefaultInfo]
@@ -163,8 +214,15 @@ def _image_manifest_impl(ctx):
             )
             layers.append(layer_info)
 
+    if ctx.attr.squash and len(layers) - base_layer_count > 1:
+        layers = layers[:base_layer_count] + [_squash(ctx, layers[base_layer_count:])]
+        if descriptions:
+            fail("history_created_by cannot be combined with squash = True, the layers are squashed into one")
+
     args.add("--os", os)
     args.add("--architecture", arch)
+    if ctx.attr.variant:
+        args.add("--variant", ctx.attr.variant)
 
     # todo: encode platform metadata
     for layer in layers:
@@ -173,6 +231,14 @@ def _image_manifest_impl(ctx):
     if ctx.attr.config_fragment != None:
         inputs.append(ctx.file.config_fragment)
         args.add("--config-fragment", ctx.file.config_fragment.path)
+        if ctx.attr._strict_config[BuildSettingInfo].value == "enabled":
+            args.add("--strict-config")
+    default_labels = ctx.files._default_labels
+    if len(default_labels) > 1:
+        fail("--@rules_img//img/settings:default_labels must be a single JSON file, got {}".format(len(default_labels)))
+    if default_labels:
+        inputs.append(default_labels[0])
+        args.add("--default-labels-file", default_labels[0].path)
 
     # Handle template expansion for labels, env, and annotations
     templates = {
@@ -202,6 +268,11 @@ def _image_manifest_impl(ctx):
         for key, value in ctx.attr.annotations.items():
             args.add("--annotation", "%s=%s" % (key, value))
 
+    for key, value in ctx.attr.env_append.items():
+        args.add("--env-append", "%s=%s" % (key, value))
+    for key, value in ctx.attr.env_prepend.items():
+        args.add("--env-prepend", "%s=%s" % (key, value))
+
     # Add other image config attributes
     if ctx.attr.user:
         args.add("--user", ctx.attr.user)
@@ -213,12 +284,49 @@ def _image_manifest_impl(ctx):
         args.add("--working-dir", ctx.attr.working_dir)
     if ctx.attr.stop_signal:
         args.add("--stop-signal", ctx.attr.stop_signal)
+    for entry in ctx.attr.onbuild:
+        args.add("--onbuild", entry)
+    for entry in ctx.attr.shell:
+        args.add("--shell", entry)
+    if ctx.attr.args_escaped:
+        args.add("--args-escaped")
+    for port in ctx.attr.exposed_ports:
+        args.add("--expose", port)
+    for volume in ctx.attr.volumes:
+        args.add("--volume", volume)
+    for entry in ctx.attr.healthcheck:
+        args.add("--healthcheck", entry)
+    if ctx.attr.healthcheck_interval:
+        args.add("--healthcheck-interval", ctx.attr.healthcheck_interval)
+    if ctx.attr.healthcheck_timeout:
+        args.add("--healthcheck-timeout", ctx.attr.healthcheck_timeout)
+    if ctx.attr.healthcheck_start_period:
+        args.add("--healthcheck-start-period", ctx.attr.healthcheck_start_period)
+    if ctx.attr.healthcheck_retries:
+        args.add("--healthcheck-retries", str(ctx.attr.healthcheck_retries))
+    subject = subject_file(ctx.attr.subject)
+    if subject != None:
+        inputs.append(subject)
+        args.add("--subject", subject.path)
+    args.add("--max-layers-warning", str(ctx.attr._max_layers_warning[BuildSettingInfo].value))
+    args.add("--max-layers", str(ctx.attr._max_layers[BuildSettingInfo].value))
+    if ctx.attr.history:
+        args.add("--history")
+    elif descriptions:
+        fail("history_created_by requires history = True")
+    for (label, description) in descriptions.items():
+        if label not in described:
+            fail("history_created_by contains {}, which is not in layers".format(label))
+        args.add("--layer-created-by", "{}={}".format(described[label], description))
 
     structured_config = dict(
         architecture = arch,
         os = os,
         history = history,
     )
+    variant = ctx.attr.variant or (base.structured_config.get("variant", "") if base != None else "")
+    if variant:
+        structured_config["variant"] = variant
 
     manifest_out = ctx.actions.declare_file(ctx.label.name + "_manifest.json")
     config_out = ctx.actions.declare_file(ctx.label.name + "_config.json")
@@ -229,12 +337,13 @@ def _image_manifest_impl(ctx):
     args.add("--descriptor", descriptor_out.path)
     args.add("--digest", digest_out.path)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [manifest_out, config_out, descriptor_out, digest_out],
         executable = img_toolchain_info.tool_exe,
         arguments = [args],
+        env = diagnostics_env(ctx),
         mnemonic = "ImageManifest",
     )
 
@@ -247,6 +356,7 @@ def _image_manifest_impl(ctx):
             digest = depset([digest_out]),
             oci_layout = depset([_build_oci_layout(ctx, "directory", manifest_out, config_out, layers)]),
             oci_tarball = depset([_build_oci_layout(ctx, "tar", manifest_out, config_out, layers)]),
+            **layer_output_groups(layers)
         ),
         ImageManifestInfo(
             base_image = base,
@@ -299,19 +409,28 @@ Output groups:
 - `digest`: Digest of the image (sha256:...)
 - `oci_layout`: Complete OCI layout directory with blobs
 - `oci_tarball`: OCI layout packaged as a tar file for downstream use
+- `layer_metadata`: Metadata (label, digest, and size) of every layer, including base image layers
+- `layer_blobs`: Blobs of every layer that is available locally
 """,
     attrs = {
         "base": attr.label(
             doc = "Base image to inherit layers from. Should provide ImageManifestInfo or ImageIndexInfo.",
         ),
         "layers": attr.label_list(
-            doc = "Layers to include in the image. Either a LayerInfo provider or a DefaultInfo with tar files.",
+            doc = "Layers to include in the image. Either a LayerInfo provider, a LayersInfo provider (for targets that build more than one layer) or a DefaultInfo with tar files.",
             cfg = normalize_layer_transition,
         ),
         "platform": attr.string_dict(
             default = {},
             doc = "Dict containing additional runtime requirements of the image.",
         ),
+        "variant": attr.string(
+            doc = """Variant of the architecture of the image, like `v8` for `arm64` or `v7` for `arm`.
+
+The variant is written to the image config and the platform of the manifest descriptor, so it is part of
+the platform in image indexes (`linux/arm/v7`). Some registries and Kubernetes schedulers rely on it.
+Defaults to the variant of the base image. If the base is an image index, the manifest with this variant is selected.""",
+        ),
         "user": attr.string(
             doc = """The username or UID which is a platform-specific structure that allows specific control over which user the process run as.
 This acts as a default value to use when the value is not specified when creating a container.""",
@@ -320,6 +439,24 @@ This acts as a default value to use when the value is not specified when creatin
             doc = """Default environment variables to set when starting a container based on this image.
 
 Subject to [template expansion](/docs/templating.md).
+""",
+            default = {},
+        ),
+        "env_append": attr.string_dict(
+            doc = """Environment variables to append to the value of the base image, instead of replacing it.
+
+The values are separated by the path list separator (`:`, or `;` for Windows images).
+Example: `{"PATH": "/app/bin"}` turns the `PATH` of the base image `/usr/bin` into `/usr/bin:/app/bin`.
+Variables that are not set by the base image are set to the value.
+""",
+            default = {},
+        ),
+        "env_prepend": attr.string_dict(
+            doc = """Environment variables to prepend to the value of the base image, instead of replacing it.
+
+The values are separated by the path list separator (`:`, or `;` for Windows images).
+Example: `{"PATH": "/app/bin"}` turns the `PATH` of the base image `/usr/bin` into `/app/bin:/usr/bin`.
+Variables that are not set by the base image are set to the value.
 """,
             default = {},
         ),
@@ -337,6 +474,9 @@ Subject to [template expansion](/docs/templating.md).
         "labels": attr.string_dict(
             doc = """This field contains arbitrary metadata for the container.
 
+Organization-wide labels can be added to every image with `--@rules_img//img/settings:default_labels=//:labels.json`
+(see the [README](/README.md)). Labels of the image replace them.
+
 Subject to [template expansion](/docs/templating.md).
 """,
             default = {},
@@ -344,6 +484,10 @@ Subject to [template expansion](/docs/templating.md).
         "annotations": attr.string_dict(
             doc = """This field contains arbitrary metadata for the manifest.
 
+The annotations are also written to the descriptor of the manifest, so that `image_index` lists them
+in its entries (see `manifest_annotations` of `image_index` to override them).
+Annotations of the image replace the annotations of `--@rules_img//img/settings:default_labels`.
+
 Subject to [template expansion](/docs/templating.md).
 """,
             default = {},
@@ -351,10 +495,101 @@ Subject to [template expansion](/docs/templating.md).
         "stop_signal": attr.string(
             doc = "This field contains the system call signal that will be sent to the container to exit. The signal can be a signal name in the format SIGNAME, for instance SIGKILL or SIGRTMIN+3.",
         ),
+        "onbuild": attr.string_list(
+            doc = """Dockerfile instructions to execute when the image is used as the base image of a Dockerfile build (`OnBuild`).
+
+Example: `["RUN /usr/local/bin/prepare"]`.
+
+This is a legacy Docker field that is not part of the OCI spec. `ONBUILD`, `FROM`, and `MAINTAINER` are not allowed as triggers.""",
+            default = [],
+        ),
+        "shell": attr.string_list(
+            doc = """Shell used for the shell form of Dockerfile instructions (`Shell`), like `["/bin/bash", "-c"]` or `["powershell", "-Command"]`.
+
+This is a legacy Docker field that is not part of the OCI spec.""",
+            default = [],
+        ),
+        "args_escaped": attr.bool(
+            doc = """Marks the entrypoint (or cmd, if there is no entrypoint) as a single, pre-escaped command line (`ArgsEscaped`).
+
+Only valid for Windows images whose entrypoint (or cmd) has exactly one element. This field is deprecated by the OCI spec, but still required by some Windows runtimes.""",
+            default = False,
+        ),
+        "exposed_ports": attr.string_list(
+            doc = """Ports to expose from a container running this image (`ExposedPorts`), as `port` or `port/protocol`.
+
+Example: `["8080", "53/udp"]`. The protocol defaults to `tcp`. Ports are added to the exposed ports of the base image.""",
+            default = [],
+        ),
+        "volumes": attr.string_list(
+            doc = "Paths in the container where volumes are mounted (`Volumes`). Volumes are added to the volumes of the base image.",
+            default = [],
+        ),
+        "healthcheck": attr.string_list(
+            doc = """Test command of the health check of the container (`Healthcheck`).
+
+Either `["NONE"]` (disables the health check of the base image), `["CMD", "executable", "arg", ...]` (exec form), or `["CMD-SHELL", "command line"]` (shell form).
+Commands without one of these prefixes use the exec form, so `["/bin/probe", "--ready"]` is the same as `["CMD", "/bin/probe", "--ready"]`.
+
+This is a legacy Docker field that is not part of the OCI spec. It is respected by Docker and Podman (in Docker format), but not by Kubernetes.""",
+            default = [],
+        ),
+        "healthcheck_interval": attr.string(
+            doc = "Time between two health checks, as a Go duration like `30s`. Defaults to the value of the base image.",
+        ),
+        "healthcheck_timeout": attr.string(
+            doc = "Time after which a health check is considered to have failed, as a Go duration like `10s`. Defaults to the value of the base image.",
+        ),
+        "healthcheck_start_period": attr.string(
+            doc = "Time for the container to start before failed health checks count, as a Go duration like `1m`. Defaults to the value of the base image.",
+        ),
+        "healthcheck_retries": attr.int(
+            doc = "Number of consecutive failed health checks after which the container is considered unhealthy. 0 keeps the value of the base image.",
+            default = 0,
+        ),
         "config_fragment": attr.label(
-            doc = "Optional JSON file containing a partial image config, which will be used as a base for the final image config.",
+            doc = """Optional JSON file containing a partial image config, which will be used as a base for the final image config.
+
+The fragment is checked against the fields of the image config. Values of the wrong type fail the build
+with the line and JSON path of the value. Unknown fields (like typos of field names) are ignored with a warning,
+or fail the build with `--@rules_img//img/settings:strict_config=enabled`.""",
             allow_single_file = True,
         ),
+        "history": attr.bool(
+            doc = """Adds `history` entries to the image config, which are shown by `docker history` and used by some scanners.
+
+Every layer that is not described by the history of the base image gets an entry, whose `created_by` is the label of the layer
+(or the description in `history_created_by`). Config values set by this target (like `env` or `entrypoint`) are recorded
+as `empty_layer` entries in the style of Dockerfile instructions, for example `ENV PATH=/bin`.""",
+            default = False,
+        ),
+        "history_created_by": attr.label_keyed_string_dict(
+            doc = """Custom `created_by` descriptions of the history entries of layers, keyed by targets in `layers`. Requires `history = True`.
+
+Example: `{":app_layer": "COPY app /app"}`.""",
+            default = {},
+        ),
+        "squash": attr.bool(
+            doc = """Squashes the `layers` of this target (but not the layers of the base image) into a single layer.
+
+Files that are replaced or removed by higher layers are dropped, and whiteouts are kept where they remove files of the base image.
+The diff IDs and (with `history = True`) the history of the config describe the squashed layer.
+Use this for consumers that need very few layers, like old registries or appliance images based on scratch.
+The squashed layer uses the default compression (`--@rules_img//img/settings:compress`).""",
+            default = False,
+        ),
+        "subject": attr.label(
+            doc = """Optional image or image index to reference as the `subject` of this manifest.
+
+This sets the OCI `subject` descriptor, which allows registries supporting the referrers API
+to list all artifacts that refer to the subject. For example, a family of per-service images can
+reference a common "release" artifact, so that everything belonging to a release can be queried
+from the registry.
+
+Should provide ImageManifestInfo or ImageIndexInfo.
+""",
+            providers = [[ImageManifestInfo], [ImageIndexInfo]],
+        ),
         "build_settings": attr.string_keyed_label_dict(
             doc = """Build settings for template expansion.
 
@@ -390,6 +625,38 @@ See [template expansion](/docs/templating.md) for available stamp variables.
             default = Label("//img/private/config:target_os_cpu"),
             providers = [TargetPlatformInfo],
         ),
+        "_default_compression": attr.label(
+            default = Label("//img/settings:compress"),
+            providers = [BuildSettingInfo],
+        ),
+        "_default_estargz": attr.label(
+            default = Label("//img/settings:estargz"),
+            providers = [BuildSettingInfo],
+        ),
+        "_compression_jobs": attr.label(
+            default = Label("//img/settings:compression_jobs"),
+            providers = [BuildSettingInfo],
+        ),
+        "_compression_level": attr.label(
+            default = Label("//img/settings:compression_level"),
+            providers = [BuildSettingInfo],
+        ),
+        "_max_layers_warning": attr.label(
+            default = Label("//img/settings:max_layers_warning"),
+            providers = [BuildSettingInfo],
+        ),
+        "_max_layers": attr.label(
+            default = Label("//img/settings:max_layers"),
+            providers = [BuildSettingInfo],
+        ),
+        "_strict_config": attr.label(
+            default = Label("//img/settings:strict_config"),
+            providers = [BuildSettingInfo],
+        ),
+        "_default_labels": attr.label(
+            default = Label("//img/settings:default_labels"),
+            allow_files = [".json"],
+        ),
         "_oci_layout_settings": attr.label(
             default = Label("//img/private/settings:oci_layout"),
             providers = [OCILayoutSettingsInfo],
@@ -398,7 +665,7 @@ See [template expansion](/docs/templating.md) for available stamp variables.
             default = Label("//img/private/settings:stamp"),
             providers = [StampSettingInfo],
         ),
-    },
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
     provides = [ImageManifestInfo],
-    toolchains = TOOLCHAINS,
+    toolchains = LAYER_TOOLCHAINS,
 )
@@ -1,10 +1,9 @@
 """Multi deploy rule for deploying multiple operations as a unified command."""
 
 load("//img/private:root_symlinks.bzl", "calculate_root_symlinks")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
+load("//img/private/common:build.bzl", "RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS", "TOOLCHAINS", "get_runtime_toolchain_info", "get_toolchain_info")
 load("//img/private/common:transitions.bzl", "host_platform_transition", "reset_platform_transition")
 load("//img/private/providers:deploy_info.bzl", "DeployInfo")
-load("//img/private/providers:image_toolchain_info.bzl", "ImageToolchainInfo")
 load("//img/private/providers:load_settings_info.bzl", "LoadSettingsInfo")
 load("//img/private/providers:push_settings_info.bzl", "PushSettingsInfo")
 load("//img/private/providers:stamp_setting_info.bzl", "StampSettingInfo")
@@ -51,7 +50,7 @@ def _compute_multi_deploy_metadata(*, ctx):
     metadata_out = ctx.actions.declare_file(ctx.label.name + ".json")
     args.add(metadata_out.path)
 
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
         outputs = [metadata_out],
@@ -71,12 +70,22 @@ def _collect_all_image_providers(ctx):
             images.append(dict(
                 index_info = deploy_info.image,
                 manifest_info = None,
+                is_referrer = False,
             ))
         else:
             # It's a manifest
             images.append(dict(
                 index_info = None,
                 manifest_info = deploy_info.image,
+                is_referrer = False,
+            ))
+
+        # Referrers (like SOCI indexes) are additional operations of the same deploy manifest
+        for referrer in getattr(deploy_info, "referrers", None) or []:
+            images.append(dict(
+                index_info = None,
+                manifest_info = referrer,
+                is_referrer = True,
             ))
     return images
 
@@ -87,7 +96,7 @@ def _multi_deploy_impl(ctx):
 
     # Create the executable
     deployer = ctx.actions.declare_file(ctx.label.name + ".exe")
-    img_toolchain_info = ctx.attr._tool[0][ImageToolchainInfo]
+    img_toolchain_info = get_runtime_toolchain_info(ctx)
     ctx.actions.symlink(
         output = deployer,
         target_file = img_toolchain_info.tool_exe,
@@ -114,7 +123,7 @@ def _multi_deploy_impl(ctx):
         symlinks = calculate_root_symlinks(
             index_info = image["index_info"],
             manifest_info = image["manifest_info"],
-            include_layers = include_layers,
+            include_layers = include_layers or image["is_referrer"],
             operation_index = i,
         )
         root_symlinks.update(symlinks)
@@ -124,7 +133,7 @@ def _multi_deploy_impl(ctx):
 
     # Merge environment settings from push and load
     environment = {}
-    inherited_environment = []
+    inherited_environment = ["IMG_REGISTRY_CA_BUNDLE", "IMG_REGISTRY_INSECURE_SKIP_VERIFY", "IMG_REGISTRY_PLAIN_HTTP", "IMG_REGISTRIES_CONF"]
 
     push_settings = ctx.attr._push_settings[PushSettingsInfo]
     load_settings = ctx.attr._load_settings[LoadSettingsInfo]
@@ -137,6 +146,18 @@ def _multi_deploy_impl(ctx):
         environment["IMG_CREDENTIAL_HELPER"] = push_settings.credential_helper or load_settings.credential_helper
         inherited_environment.append("IMG_CREDENTIAL_HELPER")
 
+    plain_http_registries = push_settings.plain_http_registries + [
+        registry
+        for registry in load_settings.plain_http_registries
+        if registry not in push_settings.plain_http_registries
+    ]
+    if plain_http_registries:
+        environment["IMG_REGISTRY_PLAIN_HTTP"] = ",".join(plain_http_registries)
+
+    if push_settings.jobs:
+        environment["IMG_PUSH_JOBS"] = str(push_settings.jobs)
+        inherited_environment.append("IMG_PUSH_JOBS")
+
     return [
         DefaultInfo(
             files = depset([dispatch_json]),
@@ -257,7 +278,7 @@ Available strategies:
             cfg = host_platform_transition,
             default = Label("//img:resolved_toolchain"),
         ),
-    },
+    } | RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS,
     executable = True,
     cfg = reset_platform_transition,
     toolchains = TOOLCHAINS,
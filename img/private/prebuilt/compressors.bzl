@@ -0,0 +1,186 @@
+"""Hermetic external compressors for rules_img.
+
+This module extension downloads prebuilt pigz and zstd executables
+and generates a repository with compressor toolchains for them.
+rules_img doesn't pin any compressor binaries itself,
+so the URLs and checksums of the executables are provided by the user.
+"""
+
+load("//img/private/platforms:platforms.bzl", "platform_for_goos_and_goarch")
+
+_TOOLS = ["pigz", "zstd"]
+
+def _compressor_repo_impl(rctx):
+    if rctx.attr.path:
+        rctx.download_and_extract(
+            rctx.attr.urls,
+            output = "archive",
+            sha256 = rctx.attr.sha256,
+            strip_prefix = rctx.attr.strip_prefix,
+        )
+        rctx.symlink("archive/" + rctx.attr.path, rctx.attr.tool)
+    else:
+        rctx.download(
+            rctx.attr.urls,
+            output = rctx.attr.tool,
+            executable = True,
+            sha256 = rctx.attr.sha256,
+        )
+    rctx.file(
+        "BUILD.bazel",
+        content = """exports_files(["{}"])""".format(rctx.attr.tool),
+    )
+
+_download_attrs = {
+    "tool": attr.string(
+        doc = "The compressor: \"pigz\" or \"zstd\".",
+        mandatory = True,
+        values = _TOOLS,
+    ),
+    "urls": attr.string_list(
+        doc = "URLs of the executable (or of an archive containing it).",
+        mandatory = True,
+    ),
+    "sha256": attr.string(
+        doc = "SHA256 checksum of the downloaded file.",
+        mandatory = True,
+    ),
+    "path": attr.string(
+        doc = "Path of the executable in the archive. If empty, the downloaded file is the executable.",
+    ),
+    "strip_prefix": attr.string(
+        doc = "Directory prefix to strip from the extracted archive.",
+    ),
+}
+
+compressor_repo = repository_rule(
+    implementation = _compressor_repo_impl,
+    attrs = _download_attrs,
+)
+
+def _compressor_toolchain_definition(platform_name, tools):
+    platform = platform_for_goos_and_goarch(platform_name)
+    tool_attrs = "".join([
+        """    {tool} = "{target}",\n""".format(tool = tool, target = tools[tool])
+        for tool in _TOOLS
+        if tool in tools
+    ])
+    return """
+compressor_toolchain(
+    name = "compressors_{platform_name}",
+{tool_attrs})
+
+toolchain(
+    name = "compressors_{platform_name}_toolchain",
+    exec_compatible_with = {constraints},
+    toolchain = "compressors_{platform_name}",
+    toolchain_type = "@rules_img//img:compressor_toolchain_type",
+)""".format(
+        platform_name = platform_name,
+        tool_attrs = tool_attrs,
+        constraints = json.encode_indent(platform.constraints, prefix = "    ", indent = "    "),
+    )
+
+def _compressor_hub_repo_impl(rctx):
+    platforms = {}
+    for (key, target) in rctx.attr.tools.items():
+        [platform_name, tool] = key.split("/")
+        platforms.setdefault(platform_name, {})[tool] = target
+    toolchain_defs = "\n".join([
+        _compressor_toolchain_definition(platform_name, tools)
+        for (platform_name, tools) in sorted(platforms.items())
+    ])
+    rctx.file(
+        "BUILD.bazel",
+        """\
+load("@rules_img//img/private:compressor_toolchain.bzl", "compressor_toolchain")
+{}
+""".format(toolchain_defs),
+    )
+
+compressor_hub_repo = repository_rule(
+    implementation = _compressor_hub_repo_impl,
+    attrs = {
+        # maps "<os>_<cpu>/<tool>" to the label of the executable
+        "tools": attr.string_dict(),
+    },
+)
+
+_download = tag_class(
+    doc = "Downloads a compressor executable for an execution platform.",
+    attrs = {
+        "name": attr.string(
+            doc = "Name of the repository containing the toolchains. Register them with `register_toolchains(\"@<name>//:all\")`.",
+            default = "img_compressors",
+        ),
+        "os": attr.string(
+            doc = "Operating system of the execution platform the executable runs on.",
+            mandatory = True,
+            values = ["darwin", "linux", "windows"],
+        ),
+        "cpu": attr.string(
+            doc = "CPU architecture of the execution platform the executable runs on.",
+            mandatory = True,
+            values = ["amd64", "arm64"],
+        ),
+    } | _download_attrs,
+)
+
+def _compressors_impl(ctx):
+    hubs = {}
+    root_module_direct_deps = []
+    for mod in ctx.modules:
+        for download in mod.tags.download:
+            repo_name = "%s_%s_%s_%s" % (download.name, download.tool, download.os, download.cpu)
+            tools = hubs.setdefault(download.name, {})
+            key = "%s_%s/%s" % (download.os, download.cpu, download.tool)
+            if key in tools:
+                # the first definition wins, so the root module can override other modules
+                continue
+            compressor_repo(
+                name = repo_name,
+                tool = download.tool,
+                urls = download.urls,
+                sha256 = download.sha256,
+                path = download.path,
+                strip_prefix = download.strip_prefix,
+            )
+            tools[key] = "@%s//:%s" % (repo_name, download.tool)
+            if mod.is_root and download.name not in root_module_direct_deps:
+                root_module_direct_deps.append(download.name)
+    for (name, tools) in hubs.items():
+        compressor_hub_repo(
+            name = name,
+            tools = tools,
+        )
+
+    return ctx.extension_metadata(
+        root_module_direct_deps = root_module_direct_deps if ctx.root_module_has_non_dev_dependency else [],
+        root_module_direct_dev_deps = [] if ctx.root_module_has_non_dev_dependency else root_module_direct_deps,
+        reproducible = True,
+    )
+
+compressors = module_extension(
+    implementation = _compressors_impl,
+    tag_classes = {
+        "download": _download,
+    },
+    doc = """Downloads pigz and zstd executables and defines compressor toolchains for them.
+
+Example:
+
+```starlark
+compressors = use_extension("@rules_img//img:compressors.bzl", "compressors")
+compressors.download(
+    tool = "zstd",
+    os = "linux",
+    cpu = "amd64",
+    urls = ["https://example.com/zstd-linux-amd64"],
+    sha256 = "<sha256>",
+)
+use_repo(compressors, "img_compressors")
+
+register_toolchains("@img_compressors//:all")
+```
+""",
+)
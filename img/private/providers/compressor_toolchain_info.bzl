@@ -0,0 +1,15 @@
+"""Defines providers for an external compressor toolchain."""
+
+DOC = """\
+Information about external compressor executables that replace the built-in compressors of the img tool.
+"""
+
+FIELDS = dict(
+    pigz = "The pigz executable (File) that compresses gzip layers, or None.",
+    zstd = "The zstd executable (File) that compresses zstd layers, or None.",
+)
+
+CompressorToolchainInfo = provider(
+    doc = DOC,
+    fields = FIELDS,
+)
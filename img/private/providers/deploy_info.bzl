@@ -8,6 +8,8 @@ container runtime.
 FIELDS = dict(
     image = "ImageManifestInfo or ImageIndexInfo of the image or image index to push or load.",
     deploy_manifest = "File containing the deploy manifest (JSON).",
+    referrers = """List of ImageManifestInfo of artifacts that refer to the image (like SOCI indexes).
+Each is deployed as an additional operation of the deploy manifest, directly after the image. Optional.""",
 )
 
 DeployInfo = provider(
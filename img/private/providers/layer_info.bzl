@@ -18,6 +18,7 @@ FIELDS = dict(
     metadata = _metadata_doc,
     media_type = "The media type of the layer as a string. Example: application/vnd.oci.image.layer.v1.tar+gzip.",
     estargz = "Boolean indicating whether the layer is an estargz layer.",
+    ztoc = "File containing the SOCI ztoc of the layer or None. Optional: read it with `getattr(layer, \"ztoc\", None)`.",
 )
 
 LayerInfo = provider(
@@ -0,0 +1,17 @@
+"""Defines providers for rules that build more than one layer."""
+
+DOC = """\
+Information about a sequence of layers that are built by a single target.
+
+Rules like `image_layer` (with `split_runfiles = True`) return this provider instead of `LayerInfo`.
+`image_manifest` adds all layers in order.
+"""
+
+FIELDS = dict(
+    layers = "List of LayerInfo providers, from the lowest to the highest layer.",
+)
+
+LayersInfo = provider(
+    doc = DOC,
+    fields = FIELDS,
+)
@@ -9,6 +9,7 @@ FIELDS = dict(
     daemon = "The daemon to target by default",
     remote_cache = "Bazel remote cache to use for the push rule as part of the lazy push strategy. Uses the same format as Bazel's --remote_cache flag. Uses $IMG_REAPI_ENDPOINT env var if not set.",
     credential_helper = "Credential helper to use for the push rule. This can be the same as Bazel's credential helper. Uses $IMG_CREDENTIAL_HELPER env var or tools/credential-helper if not set.",
+    plain_http_registries = "Registries (host or host:port) that are contacted over plain HTTP without TLS, like local development registries. Passed to the tool as $IMG_REGISTRY_PLAIN_HTTP, which takes precedence if set.",
 )
 
 LoadSettingsInfo = provider(
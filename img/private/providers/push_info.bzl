@@ -0,0 +1,15 @@
+"""Defines providers for pushed images."""
+
+DOC = """\
+Information about an image or image index pushed by `image_push`.
+"""
+
+FIELDS = dict(
+    image = "ImageManifestInfo or ImageIndexInfo of the pushed image or image index.",
+    reference = "File containing the fully qualified reference of the image as built (registry/repository@sha256:...). It is written at build time and does not reflect the runtime flags of the push.",
+)
+
+PushInfo = provider(
+    doc = DOC,
+    fields = FIELDS,
+)
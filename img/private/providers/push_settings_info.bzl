@@ -8,6 +8,8 @@ FIELDS = dict(
     strategy = "The strategy of the push rule. This can be one of the following: 'eager', 'lazy', 'cas_registry', or 'bes'.",
     remote_cache = "Bazel remote cache to use for the push rule as part of the lazy push strategy. Uses the same format as Bazel's --remote_cache flag. Uses $IMG_REAPI_ENDPOINT env var if not set.",
     credential_helper = "Credential helper to use for the push rule. This can be the same as Bazel's credential helper. Uses $IMG_CREDENTIAL_HELPER env var or tools/credential-helper if not set.",
+    plain_http_registries = "Registries (host or host:port) that are contacted over plain HTTP without TLS, like local development registries. Passed to the tool as $IMG_REGISTRY_PLAIN_HTTP, which takes precedence if set.",
+    jobs = "Maximum number of blobs uploaded concurrently (0 uses the default of the tool). Passed to the tool as $IMG_PUSH_JOBS, which takes precedence if set.",
 )
 
 PushSettingsInfo = provider(
@@ -0,0 +1,91 @@
+"""Rule for estimating the pull size of a new release."""
+
+load("//img/private/common:build.bzl", "TOOLCHAINS", "TOOLCHAIN_OVERRIDE_ATTRS", "get_toolchain_info")
+load("//img/private/common:transitions.bzl", "reset_platform_transition")
+load("//img/private/providers:deploy_info.bzl", "DeployInfo")
+
+def _pull_size_estimate_impl(ctx):
+    report = ctx.actions.declare_file(ctx.label.name + ".md")
+    report_json = ctx.actions.declare_file(ctx.label.name + ".json")
+    deploy_manifest = ctx.attr.image[DeployInfo].deploy_manifest
+
+    args = ctx.actions.args()
+    args.add("pull-size")
+    args.add("--previous", ctx.file.previous)
+    args.add("--current", deploy_manifest)
+    args.add("--output", report)
+    args.add("--json-output", report_json)
+    if ctx.attr.max_pull_size > 0:
+        args.add("--max-pull-size", str(ctx.attr.max_pull_size))
+
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [ctx.file.previous, deploy_manifest],
+        outputs = [report, report_json],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        env = {"RULES_IMG": "1"},
+        mnemonic = "PullSizeEstimate",
+    )
+
+    return [
+        DefaultInfo(files = depset([report])),
+        OutputGroupInfo(
+            report = depset([report]),
+            json = depset([report_json]),
+        ),
+    ]
+
+pull_size_estimate = rule(
+    implementation = _pull_size_estimate_impl,
+    doc = """Estimates how many bytes a node needs to pull to deploy a new build.
+
+A node that runs the previous release already has all of its blobs, so only manifests,
+configs and layers whose digest changed need to be pulled. The estimate compares the deploy
+manifest of the previous release with the deploy manifest of `image` and writes a Markdown
+report (`<name>.md`) that can be attached to pull requests, so reviewers see the runtime cost
+of their changes. A JSON version of the report is available in the `json` output group.
+
+The deploy manifest of a release is the default output of its `image_push` or `image_load`
+target (`bazel-bin/<package>/<name>.json`). Keep it with the release, for example as a release asset
+that is fetched with `http_file`.
+
+Blobs are compared by digest across all images and platforms of the previous release.
+
+Example:
+
+```python
+load("@rules_img//img:diff.bzl", "pull_size_estimate")
+
+pull_size_estimate(
+    name = "pull_size",
+    image = ":push",
+    previous = "@previous_release//file",
+    max_pull_size = 100 * 1024 * 1024,
+)
+```
+
+```bash
+bazel build //path/to:pull_size
+cat bazel-bin/path/to/pull_size.md
+```
+""",
+    attrs = {
+        "image": attr.label(
+            doc = "The `image_push` or `image_load` target of the new build.",
+            mandatory = True,
+            providers = [DeployInfo],
+        ),
+        "previous": attr.label(
+            doc = "Deploy manifest (JSON) of the previous release.",
+            mandatory = True,
+            allow_single_file = [".json"],
+        ),
+        "max_pull_size": attr.int(
+            default = 0,
+            doc = """Fail the build if more than this number of bytes needs to be pulled. 0 means no limit.""",
+        ),
+    } | TOOLCHAIN_OVERRIDE_ATTRS,
+    cfg = reset_platform_transition,
+    toolchains = TOOLCHAINS,
+)
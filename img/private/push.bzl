@@ -2,14 +2,16 @@
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
 load("//img/private:root_symlinks.bzl", "calculate_root_symlinks")
-load("//img/private:stamp.bzl", "expand_or_write")
-load("//img/private/common:build.bzl", "TOOLCHAIN", "TOOLCHAINS")
+load("//img/private:stamp.bzl", "expand_or_write", "should_stamp")
+load("//img/private/common:build.bzl", "RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS", "TOOLCHAINS", "get_runtime_toolchain_info", "get_toolchain_info")
+load("//img/private/common:layer_helper.bzl", "build_ztoc", "layer_supports_soci")
 load("//img/private/common:transitions.bzl", "host_platform_transition", "reset_platform_transition")
 load("//img/private/providers:deploy_info.bzl", "DeployInfo")
-load("//img/private/providers:image_toolchain_info.bzl", "ImageToolchainInfo")
 load("//img/private/providers:index_info.bzl", "ImageIndexInfo")
+load("//img/private/providers:layer_info.bzl", "LayerInfo")
 load("//img/private/providers:manifest_info.bzl", "ImageManifestInfo")
 load("//img/private/providers:pull_info.bzl", "PullInfo")
+load("//img/private/providers:push_info.bzl", "PushInfo")
 load("//img/private/providers:push_settings_info.bzl", "PushSettingsInfo")
 load("//img/private/providers:stamp_setting_info.bzl", "StampSettingInfo")
 
@@ -46,7 +48,7 @@ def _get_tags(ctx):
     # Empty list is allowed for digest-only push
     return tags
 
-def _compute_push_metadata(*, ctx, configuration_json):
+def _compute_push_metadata(*, ctx, configuration_json, reference_out):
     inputs = [configuration_json]
     args = ctx.actions.args()
     args.add("deploy-metadata")
@@ -68,6 +70,10 @@ def _compute_push_metadata(*, ctx, configuration_json):
         args.add("--original-tag", target_info["original_tag"])
     if "original_digest" in target_info and target_info["original_digest"] != None:
         args.add("--original-digest", target_info["original_digest"])
+    # The reference holds the digest as built. Runtime flags of the push (like --manifest-format) are not reflected.
+    args.add("--reference-output", reference_out.path)
+    if ctx.attr.tag_strategy == "digest":
+        args.add("--digest-tag-template", ctx.attr.digest_tag_template)
 
     if manifest_info != None:
         args.add("--root-path", manifest_info.manifest.path)
@@ -86,9 +92,96 @@ def _compute_push_metadata(*, ctx, configuration_json):
 
     metadata_out = ctx.actions.declare_file(ctx.label.name + ".json")
     args.add(metadata_out.path)
-    img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+    img_toolchain_info = get_toolchain_info(ctx)
     ctx.actions.run(
         inputs = inputs,
+        outputs = [metadata_out, reference_out],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        mnemonic = "PushMetadata",
+    )
+    return metadata_out
+
+def _soci_index(ctx, manifest_info, manifest_index):
+    """Builds the SOCI index of an image manifest.
+
+    Layers without a ztoc get one built here (for example layers of pulled base images).
+
+    Args:
+        ctx: Rule context.
+        manifest_info: ImageManifestInfo of the image.
+        manifest_index: Index of the manifest in the image index (0 for single-platform images).
+
+    Returns:
+        ImageManifestInfo of the SOCI index, or None if no layer of the image supports SOCI.
+    """
+    prefix = "{}_soci/{}/".format(ctx.label.name, manifest_index)
+    inputs = [manifest_info.manifest]
+    args = ctx.actions.args()
+    args.add("soci-index")
+    args.add("--subject", manifest_info.manifest.path)
+    ztoc_layers = []
+    for (layer_index, layer) in enumerate(manifest_info.layers):
+        if not layer_supports_soci(layer):
+            continue
+        ztoc = getattr(layer, "ztoc", None)
+        if ztoc == None:
+            ztoc = ctx.actions.declare_file("{}layer_{}.ztoc".format(prefix, layer_index))
+            build_ztoc(ctx = ctx, tar_file = layer.blob, output = ztoc)
+        args.add("--ztoc", "{}={}".format(layer.metadata.path, ztoc.path))
+        inputs.extend([layer.metadata, ztoc])
+        ztoc_layers.append(LayerInfo(
+            blob = ztoc,
+            metadata = None,
+            media_type = "application/octet-stream",
+            estargz = False,
+        ))
+    if not ztoc_layers:
+        return None
+
+    manifest = ctx.actions.declare_file(prefix + "manifest.json")
+    config = ctx.actions.declare_file(prefix + "config.json")
+    descriptor = ctx.actions.declare_file(prefix + "descriptor.json")
+    args.add("--manifest", manifest.path)
+    args.add("--config", config.path)
+    args.add("--descriptor", descriptor.path)
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = inputs,
+        outputs = [manifest, config, descriptor],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        mnemonic = "SociIndex",
+    )
+    return ImageManifestInfo(
+        base_image = None,
+        descriptor = descriptor,
+        manifest = manifest,
+        config = config,
+        structured_config = {},
+        architecture = manifest_info.architecture,
+        os = manifest_info.os,
+        platform = manifest_info.platform,
+        layers = ztoc_layers,
+        missing_blobs = [],
+    )
+
+def _referrer_push_metadata(ctx, *, configuration_json, referrer, operation_index):
+    """Computes the deploy manifest that pushes a referrer (like a SOCI index) by digest."""
+    args = ctx.actions.args()
+    args.add("deploy-metadata")
+    args.add("--command", "push")
+    args.add("--strategy", _push_strategy(ctx))
+    args.add("--configuration-file", configuration_json.path)
+    args.add("--root-path", referrer.manifest.path)
+    args.add("--root-kind", "manifest")
+    args.add("--manifest-path", "0=" + referrer.manifest.path)
+    args.add("--missing-blobs-for-manifest", "0=")
+    metadata_out = ctx.actions.declare_file("{}_referrers/{}.json".format(ctx.label.name, operation_index))
+    args.add(metadata_out.path)
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [configuration_json, referrer.manifest],
         outputs = [metadata_out],
         executable = img_toolchain_info.tool_exe,
         arguments = [args],
@@ -96,10 +189,97 @@ def _compute_push_metadata(*, ctx, configuration_json):
     )
     return metadata_out
 
+def _provenance_stamp_files(ctx):
+    """Returns the workspace status files to read the source revision and build time of the provenance from."""
+
+    # The provenance reads well-known stamp variables directly, so stamping is wanted
+    # even if none of the attributes contain templates.
+    if not should_stamp(ctx = ctx, template_strings = ["{{.BUILD_TIMESTAMP}}"]).stamp:
+        return []
+    return [f for f in [ctx.version_file, ctx.info_file] if f != None]
+
+def _provenance(ctx, subject):
+    """Builds the attestation manifest with the SLSA provenance of the image.
+
+    Args:
+        ctx: Rule context.
+        subject: The raw manifest or index of the pushed image.
+
+    Returns:
+        ImageManifestInfo of the attestation manifest.
+    """
+    configuration_json = expand_or_write(
+        ctx = ctx,
+        templates = dict(
+            builder_id = ctx.attr.provenance_builder_id,
+            invocation_id = ctx.attr.provenance_invocation_id,
+            subject_name = ctx.attr.registry + "/" + ctx.attr.repository,
+        ),
+        output_name = ctx.label.name + ".provenance.configuration.json",
+    )
+    prefix = ctx.label.name + "_provenance/"
+    statement = ctx.actions.declare_file(prefix + "statement.json")
+    manifest = ctx.actions.declare_file(prefix + "manifest.json")
+    config = ctx.actions.declare_file(prefix + "config.json")
+    descriptor = ctx.actions.declare_file(prefix + "descriptor.json")
+    stamp_files = _provenance_stamp_files(ctx)
+    args = ctx.actions.args()
+    args.add("attest")
+    args.add("--subject", subject.path)
+    args.add("--configuration-file", configuration_json.path)
+    args.add_all(stamp_files, before_each = "--stamp")
+    args.add("--parameter", "target=" + str(ctx.attr.image.label))
+    args.add("--statement", statement.path)
+    args.add("--manifest", manifest.path)
+    args.add("--config", config.path)
+    args.add("--descriptor", descriptor.path)
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = [subject, configuration_json] + stamp_files,
+        outputs = [statement, manifest, config, descriptor],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        mnemonic = "Provenance",
+    )
+    return ImageManifestInfo(
+        base_image = None,
+        descriptor = descriptor,
+        manifest = manifest,
+        config = config,
+        structured_config = {},
+        architecture = "",
+        os = "",
+        platform = {},
+        layers = [LayerInfo(
+            blob = statement,
+            metadata = None,
+            media_type = "application/vnd.in-toto+json",
+            estargz = False,
+        )],
+        missing_blobs = [],
+    )
+
+def _merge_deploy_manifests(ctx, deploy_manifests):
+    args = ctx.actions.args()
+    args.add("deploy-merge")
+    args.add("--push-strategy", _push_strategy(ctx))
+    args.add_all(deploy_manifests)
+    merged = ctx.actions.declare_file(ctx.label.name + "_dispatch.json")
+    args.add(merged.path)
+    img_toolchain_info = get_toolchain_info(ctx)
+    ctx.actions.run(
+        inputs = deploy_manifests,
+        outputs = [merged],
+        executable = img_toolchain_info.tool_exe,
+        arguments = [args],
+        mnemonic = "PushMetadataMerge",
+    )
+    return merged
+
 def _image_push_impl(ctx):
     """Implementation of the push rule."""
     pusher = ctx.actions.declare_file(ctx.label.name + ".exe")
-    img_toolchain_info = ctx.attr._tool[0][ImageToolchainInfo]
+    img_toolchain_info = get_runtime_toolchain_info(ctx)
     ctx.actions.symlink(
         output = pusher,
         target_file = img_toolchain_info.tool_exe,
@@ -119,6 +299,7 @@ def _image_push_impl(ctx):
         registry = ctx.attr.registry,
         repository = ctx.attr.repository,
         tags = _get_tags(ctx),
+        webhooks = ctx.attr.webhooks,
     )
 
     # Either expand templates or write directly
@@ -128,11 +309,52 @@ def _image_push_impl(ctx):
         output_name = ctx.label.name + ".configuration.json",
     )
 
+    reference_out = ctx.actions.declare_file(ctx.label.name + ".reference")
     dispatch_json = _compute_push_metadata(
         ctx = ctx,
         configuration_json = configuration_json,
+        reference_out = reference_out,
     )
 
+    # SOCI indexes and the provenance are pushed by digest after the image, as separate operations.
+    # Registries find them through the referrers API of the image manifest.
+    referrers = []
+    if ctx.attr.soci_index:
+        if _push_strategy(ctx) == "bes":
+            fail("soci_index is not supported with the bes push strategy")
+        image_manifests = index_info.manifests if index_info != None else [manifest_info]
+        for (i, image_manifest) in enumerate(image_manifests):
+            soci_index = _soci_index(ctx, image_manifest, i)
+            if soci_index != None:
+                referrers.append(soci_index)
+    if ctx.attr.provenance:
+        if _push_strategy(ctx) == "bes":
+            fail("provenance is not supported with the bes push strategy")
+        referrers.append(_provenance(ctx, index_info.index if index_info != None else manifest_info.manifest))
+    if referrers:
+        referrer_configuration_json = expand_or_write(
+            ctx = ctx,
+            templates = dict(
+                registry = ctx.attr.registry,
+                repository = ctx.attr.repository,
+                tags = [],
+                webhooks = [],
+            ),
+            output_name = ctx.label.name + ".referrers.configuration.json",
+        )
+        deploy_manifests = [dispatch_json]
+        for (i, referrer) in enumerate(referrers):
+            deploy_manifests.append(_referrer_push_metadata(
+                ctx,
+                configuration_json = referrer_configuration_json,
+                referrer = referrer,
+                operation_index = i + 1,
+            ))
+
+            # ztocs and statements are small and rarely in the remote cache, so they are always shipped in the runfiles
+            root_symlinks.update(calculate_root_symlinks(None, referrer, include_layers = True, operation_index = i + 1))
+        dispatch_json = _merge_deploy_manifests(ctx, deploy_manifests)
+
     root_symlinks["dispatch.json"] = dispatch_json
     return [
         DefaultInfo(
@@ -144,15 +366,31 @@ def _image_push_impl(ctx):
             environment = {
                 "IMG_REAPI_ENDPOINT": ctx.attr._push_settings[PushSettingsInfo].remote_cache,
                 "IMG_CREDENTIAL_HELPER": ctx.attr._push_settings[PushSettingsInfo].credential_helper,
+                "IMG_REGISTRY_PLAIN_HTTP": ",".join(ctx.attr._push_settings[PushSettingsInfo].plain_http_registries),
+                "IMG_PUSH_JOBS": str(ctx.attr._push_settings[PushSettingsInfo].jobs),
             },
             inherited_environment = [
                 "IMG_REAPI_ENDPOINT",
                 "IMG_CREDENTIAL_HELPER",
+                "IMG_WEBHOOK_SECRET",
+                "IMG_REGISTRY_CA_BUNDLE",
+                "IMG_REGISTRY_INSECURE_SKIP_VERIFY",
+                "IMG_REGISTRY_PLAIN_HTTP",
+                "IMG_REGISTRIES_CONF",
+                "IMG_PUSH_JOBS",
             ],
         ),
         DeployInfo(
             image = image_provider,
             deploy_manifest = dispatch_json,
+            referrers = referrers,
+        ),
+        OutputGroupInfo(
+            reference = depset([reference_out]),
+        ),
+        PushInfo(
+            image = image_provider,
+            reference = reference_out,
         ),
     ]
 
@@ -172,6 +410,14 @@ Key features:
 
 The rule produces an executable that can be run with `bazel run`.
 
+The fully qualified reference of the image as built (`registry/repository@sha256:...`) is
+written at build time to a file that is available in the `reference` output group
+and via the `PushInfo` provider, so that other rules (for example Helm chart or Kubernetes manifest
+templating) can consume the digest of the image within the same build. The file is not
+updated by the push: it only holds the build digest, which is the pushed digest unless the push
+is run with `--registry`, `--repository` or `--manifest-format` (converted manifests have other digests).
+The references that were actually pushed are printed by the push (or written with `--output-file`).
+
 Example:
 
 ```python
@@ -219,6 +465,18 @@ image_push(
     stamp = "enabled",
 )
 
+# Push with a SLSA provenance attestation of the stamped source revision
+image_push(
+    name = "push_with_provenance",
+    image = ":my_app",
+    registry = "ghcr.io",
+    repository = "my-org/my-app",
+    tag = "latest",
+    provenance = True,
+    provenance_builder_id = "https://github.com/my-org/my-app/.github/workflows/release.yaml",
+    stamp = "enabled",
+)
+
 # Digest-only push (no tag)
 image_push(
     name = "push_by_digest",
@@ -245,6 +503,92 @@ Runtime usage:
 bazel run //path/to:push_app
 
 # The push command will output the image digest
+
+# Show the references and blobs that would be pushed without contacting the registry
+bazel run //path/to:push_app -- --dry-run
+
+# Push Docker schema 2 manifests to registries that reject OCI media types
+# (the pushed digest differs from the digest of the built image)
+bazel run //path/to:push_app -- --manifest-format=docker
+
+# Upload layers larger than 100 MiB in chunks of 100 MiB
+# (interrupted uploads of very large layers are resumed instead of restarted)
+bazel run //path/to:push_app -- --chunk-size=104857600
+
+# Upload at most 16 blobs at once, across all images of the push
+# (the default is 4, or --@rules_img//img/settings:push_jobs)
+bazel run //path/to:push_app -- --jobs=16
+
+# Upload every layer without checking up front which layers the registry already has
+# (by default, all layers are checked with parallel HEAD requests and existing layers are skipped)
+bazel run //path/to:push_app -- --check-existing-blobs=false
+
+# Skip querying the registry before pushing
+# (by default, a capability summary is printed and missing push permissions are reported up front)
+bazel run //path/to:push_app -- --check-capabilities=false
+
+# Write a machine-readable result for CI pipelines instead of printing the pushed references
+# (registry, repository, digest, tags, the transfer status of every blob, and timing)
+bazel run //path/to:push_app -- --output-format=json --output-file=$PWD/push_result.json
+```
+
+Pushing from tests:
+The push executable can be used in the `data` of a test (with the `eager` push strategy) to write hermetic
+end-to-end tests of image pipelines. The test starts a registry fixture and passes its address in the
+`IMG_TEST_REGISTRY` environment variable (or `--test-registry`), either as `unix://<socket path>` or as
+`<host>:<port>` of a loopback address (e.g. an ephemeral port). The image is then pushed to the fixture
+over plain HTTP, all other hosts are refused and no credentials are looked up.
+Images pushed to a unix socket are named `test-registry.localhost/<repository>`.
+Base images must be pulled with `layer_handling = "eager"`, so that no layers are fetched from their original registry.
+
+```bash
+IMG_TEST_REGISTRY="unix://${TEST_TMPDIR}/registry.sock" "$(rlocation my_workspace/path/to/push_app)"
+```
+
+Inspecting the image without pushing:
+`img serve-vfs` serves the images of a built push target as a read-only registry over plain HTTP,
+so that tools like trivy, dive or syft can analyze exactly the image that would be pushed.
+Blobs come from the same sources as for the push (runfiles, the registry of a shallow base image, or the remote cache).
+
+```bash
+bazel build //path/to:push_app
+img serve-vfs --runfiles-dir bazel-bin/path/to/push_app.runfiles --addr localhost:5000
+crane manifest --insecure localhost:5000/my-org/my-app:latest
+```
+
+`img explore` browses the layers and the merged filesystem of the same image in the terminal:
+per-directory sizes, the layer that provides each path, and files deduplicated as hardlinks.
+Only tar headers are read, nothing is unpacked. Use `--print` for a non-interactive listing.
+
+```bash
+img explore bazel-bin/path/to/push_app.runfiles
+```
+
+`img push-layout` pushes an OCI layout directory or tarball produced outside of Bazel
+(e.g. by `docker buildx build --output type=oci`) with the same uploader, including existence checks,
+chunked uploads and `--jobs`. Single images and image indexes are supported; select a manifest of
+a layout with multiple manifests with `--ref-name` or `--digest`.
+
+```bash
+img push-layout --repository registry.example.com/my-org/my-app --tag latest image.tar
+```
+
+`img cat` writes a single file of the image to stdout, following symlinks and whiteouts like a container would:
+
+```bash
+img cat bazel-bin/path/to/push_app.runfiles /etc/os-release
+```
+
+`img login` confirms that the credentials work before a long build is started. It exchanges them for a token
+and, with `--repository`, checks the access of `--scope` (`pull` lists the tags, `push` starts and cancels a blob upload,
+so nothing is written). Without `--username`, the credentials that `image_push` and `pull` would use
+(Docker config, credential helpers and default keychains) are checked, and failures explain how they were looked up.
+New credentials are stored in the credential helper of the registry (`credHelpers` or `credsStore`)
+or in the `auths` of `~/.docker/config.json` (`$DOCKER_CONFIG/config.json`), where push and pull find them.
+
+```bash
+img login --repository ghcr.io/my-org/my-app --scope push
+echo "$TOKEN" | img login --username my-user --password-stdin --repository ghcr.io/my-org/my-app --scope push
 ```
 """,
     attrs = {
@@ -286,6 +630,31 @@ tag_list = ["latest", "v1.0.0", "stable"]
 Cannot be used together with `tag`. Each tag is subject to [template expansion](/docs/templating.md).
 """,
         ),
+        "tag_strategy": attr.string(
+            doc = """Strategy for tagging the pushed image.
+
+- **`mutable`** (default): Only apply the tags from `tag` or `tag_list`.
+- **`digest`**: Additionally apply an immutable tag derived from the digest of the pushed manifest or index (see `digest_tag_template`).
+
+Digest-derived tags (like `digest-<hex>`) never move to a different image. This makes it easy for
+garbage collection tooling to tell apart release tags from immutable references.
+""",
+            default = "mutable",
+            values = ["mutable", "digest"],
+        ),
+        "digest_tag_template": attr.string(
+            doc = """Go template for the digest-derived tag used by `tag_strategy = "digest"`.
+
+Available fields are `.Algorithm` (e.g. `sha256`), `.Hex` (the hex-encoded digest) and `.Digest` (the full digest).
+
+Templates that expand to `<algorithm>-<hex>` or `<algorithm>-<hex>.{sig,att,sbom}` are rejected:
+these tags are the fallback of the OCI referrers API and are used by signing tools (like cosign) for
+signatures, attestations and SBOMs of the image.
+
+Expanded when the push metadata is computed, independent of [template expansion](/docs/templating.md).
+""",
+            default = "digest-{{.Hex}}",
+        ),
         "image": attr.label(
             doc = "Image to push. Should provide ImageManifestInfo or ImageIndexInfo.",
             mandatory = True,
@@ -329,6 +698,73 @@ See [template expansion](/docs/templating.md) for available stamp variables.
             default = "auto",
             values = ["auto", "enabled", "disabled"],
         ),
+        "webhooks": attr.string_list(
+            doc = """URLs that are notified after a successful push.
+
+Each URL receives a `POST` request with a JSON payload containing the registry, repository,
+digest, tags and pushed references, as well as information about the invocation (host, user, workspace and time).
+If the `IMG_WEBHOOK_SECRET` environment variable is set, the payload is signed with HMAC-SHA256
+and the signature is sent in the `X-Img-Signature-256` header (formatted as `sha256=<hex>`).
+Failed deliveries are retried with exponential backoff.
+
+Webhooks are not called for the `bes` push strategy. Each URL is subject to [template expansion](/docs/templating.md).
+""",
+        ),
+        "soci_index": attr.bool(
+            default = False,
+            doc = """Whether to push a SOCI (Seekable OCI) index for every image manifest.
+
+The SOCI index references the ztocs of the gzip layers of the image and lists the image manifest as its `subject`.
+It is pushed by digest after the image, so that registries with support for the referrers API (like Amazon ECR)
+return it for the image. The soci-snapshotter (used by AWS Fargate and containerd) then lazily loads the image.
+Registries without the referrers API are not supported.
+
+Ztocs of `image_layer` targets with `soci_ztoc` enabled are reused. Ztocs of other gzip layers (like layers of
+pulled base images) are built by this rule, as long as the layer blob is available locally.
+eStargz, zstd and uncompressed layers are skipped. Not supported with the `bes` push strategy.
+""",
+        ),
+        "provenance": attr.bool(
+            default = False,
+            doc = """Whether to push a SLSA v1 provenance of the image as an attestation.
+
+The provenance is an in-toto statement (`https://slsa.dev/provenance/v1`) about the pushed manifest or index.
+It records the builder id (`provenance_builder_id`), the label of the image as external parameter,
+the Bazel invocation id (`provenance_invocation_id`), and, if the target is stamped, the source revision
+(from the stamp variable `STABLE_GIT_COMMIT`, `STABLE_BUILD_SCM_REVISION` or `BUILD_SCM_REVISION`),
+the source repository (from `STABLE_GIT_URL`, `STABLE_BUILD_SCM_REMOTE` or `BUILD_SCM_REMOTE`) and the build time (from `BUILD_TIMESTAMP`).
+
+The statement is wrapped in a manifest with the `artifactType` `application/vnd.in-toto+json` that lists the image
+as its `subject`. It is pushed by digest after the image, so that registries with support for the referrers API
+return it for the image. The same attestation can be created outside of Bazel with `img attest`.
+Not supported with the `bes` push strategy.
+""",
+        ),
+        "provenance_builder_id": attr.string(
+            default = "https://github.com/bazel-contrib/rules_img/image_push",
+            doc = """The `builder.id` of the provenance (see `provenance`), like the URL of the CI workflow that runs the push.
+
+Subject to [template expansion](/docs/templating.md).
+""",
+        ),
+        "provenance_invocation_id": attr.string(
+            doc = """The Bazel invocation id recorded in the provenance (see `provenance`).
+
+Bazel doesn't expose the invocation id to actions, so pass the id to Bazel and a build setting at the same time:
+
+```bash
+ID=$(uuidgen)
+bazel run --invocation_id=$ID --//settings:invocation_id=$ID //path/to:push_app
+```
+
+```python
+provenance_invocation_id = "{{.invocation_id}}",
+build_settings = {"invocation_id": "//settings:invocation_id"},
+```
+
+Subject to [template expansion](/docs/templating.md).
+""",
+        ),
         "_push_settings": attr.label(
             default = Label("//img/private/settings:push"),
             providers = [PushSettingsInfo],
@@ -341,7 +777,7 @@ See [template expansion](/docs/templating.md) for available stamp variables.
             cfg = host_platform_transition,
             default = Label("//img:resolved_toolchain"),
         ),
-    },
+    } | RUNTIME_TOOLCHAIN_OVERRIDE_ATTRS,
     executable = True,
     cfg = reset_platform_transition,
     toolchains = TOOLCHAINS,
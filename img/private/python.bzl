@@ -0,0 +1,84 @@
+"""Macro that splits a py_binary into layers for the interpreter, third-party packages and the application."""
+
+load(":layer.bzl", "image_layer")
+
+# Canonical names of the interpreter repositories of rules_python
+# (rules_python++python+python_3_11_x86_64-unknown-linux-gnu, or with "~" as separator before Bazel 8).
+DEFAULT_INTERPRETER_REPOS = ["rules_python[+~]+python[+~][^/]*"]
+
+# All repositories except the main repository (and other repositories that start with "_").
+DEFAULT_PACKAGE_REPOS = ["[^_/][^/]*"]
+
+def _regex_escape(s):
+    return "".join(["\\" + c if c in "\\.+*?()|[]{}^$" else c for c in s.elems()])
+
+def py_image_layer(
+        *,
+        name,
+        binary,
+        path,
+        interpreter_repos = DEFAULT_INTERPRETER_REPOS,
+        package_repos = DEFAULT_PACKAGE_REPOS,
+        **kwargs):
+    """Creates an `image_layer` with a `py_binary` that is split into three layers.
+
+    The runfiles of the binary are assigned to layers by the repository they belong to:
+    - the interpreter layer (lowest) contains the Python interpreter of the toolchain,
+    - the packages layer contains third-party packages (like the wheels of `pip.parse`),
+    - the main layer (highest) contains the binary and the application code of the main repository.
+
+    The interpreter and third-party packages rarely change, so only the small layer with the application code
+    needs to be rebuilt and pushed when the code changes. All three layers are built by a single action
+    (using the `layer_groups` of `image_layer`) and added to `image_manifest` in order.
+
+    Example:
+
+    ```python
+    load("@rules_img//img:python.bzl", "py_image_layer")
+
+    py_image_layer(
+        name = "app_layers",
+        binary = "//cmd/app:app_py",
+        path = "/app/bin/app",
+    )
+
+    image_manifest(
+        name = "app_image",
+        base = "@distroless_python",
+        entrypoint = ["/app/bin/app"],
+        layers = [":app_layers"],
+    )
+    ```
+
+    Args:
+        name: Name of the `image_layer` target. The outputs of the lower layers are named `<name>_interpreter` and `<name>_packages`.
+        binary: Label of the `py_binary` (or any other executable with Python runfiles).
+        path: Path of the binary in the image. Its runfiles are stored at `<path>.runfiles`.
+        interpreter_repos: Regular expressions (RE2 syntax) of the names of repositories that belong to the interpreter layer.
+            The default matches the interpreter repositories of the Python toolchains of rules_python.
+        package_repos: Regular expressions (RE2 syntax) of the names of repositories that belong to the packages layer.
+            Repositories that match `interpreter_repos` are never part of the packages layer.
+            The default matches all repositories except the main repository.
+        **kwargs: Other attributes of `image_layer` (like `compress` or `file_metadata`).
+            `srcs` may add more files to the main layer, `layer_groups` is not supported.
+    """
+    if "layer_groups" in kwargs:
+        fail("py_image_layer doesn't support layer_groups")
+    if not path.startswith("/"):
+        path = "/" + path
+    srcs = dict(kwargs.pop("srcs", {}))
+    srcs[path] = binary
+
+    runfiles_prefix = "^" + _regex_escape(path + ".runfiles/")
+    layer_groups = {}
+    for repo in interpreter_repos:
+        layer_groups["{}({})/".format(runfiles_prefix, repo)] = "interpreter"
+    for repo in package_repos:
+        layer_groups["{}({})/".format(runfiles_prefix, repo)] = "packages"
+
+    image_layer(
+        name = name,
+        srcs = srcs,
+        layer_groups = layer_groups,
+        **kwargs
+    )
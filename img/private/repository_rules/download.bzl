@@ -1,5 +1,26 @@
 """Repository rules for downloading container image components."""
 
+def _sources(rctx):
+    """Returns (registry, repository) pairs to download from, in order.
+
+    Mirrors come first. Each mirror has the form host[/prefix].
+    """
+    registries = [r for r in rctx.attr.registries]
+    if rctx.attr.registry:
+        registries.append(rctx.attr.registry)
+    sources = []
+    for mirror in rctx.attr.mirrors:
+        host, _, prefix = mirror.strip("/").partition("/")
+        sources.append((host, prefix + "/" + rctx.attr.repository if prefix else rctx.attr.repository))
+    if rctx.attr.mirror_fallback or len(rctx.attr.mirrors) == 0:
+        sources.extend([(registry, rctx.attr.repository) for registry in registries])
+    if len(sources) == 0:
+        fail("need at least one registry to pull from")
+    return sources
+
+def _protocol(rctx):
+    return "http" if rctx.attr.plain_http else "https"
+
 def download_blob(rctx, *, digest, wait_and_read = True, **kwargs):
     """Download a blob from a container registry using Bazel's downloader.
 
@@ -15,20 +36,15 @@ def download_blob(rctx, *, digest, wait_and_read = True, **kwargs):
     """
     sha256 = digest.removeprefix("sha256:")
     output = "blobs/sha256/" + sha256
-    registries = [r for r in rctx.attr.registries]
-    if rctx.attr.registry:
-        registries.append(rctx.attr.registry)
-    if len(registries) == 0:
-        fail("need at least one registry to pull from")
     result = rctx.download(
         url = [
             "{protocol}://{registry}/v2/{repository}/blobs/{digest}".format(
-                protocol = "https",
+                protocol = _protocol(rctx),
                 registry = registry,
-                repository = rctx.attr.repository,
+                repository = repository,
                 digest = digest,
             )
-            for registry in registries
+            for (registry, repository) in _sources(rctx)
         ],
         sha256 = sha256,
         output = output,
@@ -54,11 +70,7 @@ def download_manifest(rctx, *, reference, **kwargs):
         A struct containing digest, path, and data of the downloaded manifest.
     """
     have_valid_digest = False
-    registries = [r for r in rctx.attr.registries]
-    if rctx.attr.registry:
-        registries.append(rctx.attr.registry)
-    if len(registries) == 0:
-        fail("need at least one registry to pull from")
+    sources = _sources(rctx)
     if reference.startswith("sha256:"):
         have_valid_digest = True
         sha256 = reference.removeprefix("sha256:")
@@ -69,12 +81,12 @@ def download_manifest(rctx, *, reference, **kwargs):
     manifest_result = rctx.download(
         url = [
             "{protocol}://{registry}/v2/{repository}/manifests/{reference}".format(
-                protocol = "https",
+                protocol = _protocol(rctx),
                 registry = registry,
-                repository = rctx.attr.repository,
+                repository = repository,
                 reference = reference,
             )
-            for registry in registries
+            for (registry, repository) in sources
         ],
         **kwargs
     )
@@ -90,6 +102,62 @@ def download_manifest(rctx, *, reference, **kwargs):
         data = rctx.read(kwargs["output"]),
     )
 
+def tag_moved_message(*, tag, expected, current, hint):
+    """Error message for a tag that no longer points to the expected digest.
+
+    Args:
+        tag: The tag reference (registry/repository:tag).
+        expected: The expected digest.
+        current: The digest the tag currently points to.
+        hint: Command (or instruction) that pins the current digest. "{digest}" is replaced by the current digest.
+
+    Returns:
+        The error message.
+    """
+    return """tag {tag} moved:
+  - expected digest: {expected}
+  + current digest:  {current}
+If the new image is expected, pin the current digest:
+  {hint}""".format(
+        tag = tag,
+        expected = expected,
+        current = current,
+        hint = hint.replace("{digest}", current),
+    )
+
+def verify_tag(rctx, *, tag, digest, display_name, hint):
+    """Check that a tag still points to the expected digest using Bazel's downloader.
+
+    Args:
+        rctx: Repository context.
+        tag: The tag to resolve.
+        digest: The expected digest.
+        display_name: The tag reference (registry/repository:tag) shown in the error message.
+        hint: Command (or instruction) that pins the current digest, see tag_moved_message.
+    """
+    output = "tag_manifest.json"
+    result = rctx.download(
+        url = [
+            "{protocol}://{registry}/v2/{repository}/manifests/{tag}".format(
+                protocol = _protocol(rctx),
+                registry = registry,
+                repository = repository,
+                tag = tag,
+            )
+            for (registry, repository) in _sources(rctx)
+        ],
+        output = output,
+    )
+    rctx.delete(output)
+    current = "sha256:" + result.sha256
+    if current != digest:
+        fail(tag_moved_message(
+            tag = display_name,
+            expected = digest,
+            current = current,
+            hint = hint,
+        ))
+
 def download_layers(rctx, digests):
     """Download all layers from a manifest.
 
@@ -181,13 +249,15 @@ def get_layers(rctx, digests):
         for digest in digests
     ]
 
-def download_with_tool(rctx, *, tool_path, reference):
+def download_with_tool(rctx, *, tool_path, reference, verify_tag = None, tag_moved_hint = None):
     """Download an image using the img tool.
 
     Args:
         rctx: Repository context.
         tool_path: The path to the img tool to use for downloading.
         reference: The image reference to download.
+        verify_tag: Optional tag that is expected to point to the digest of the reference.
+        tag_moved_hint: Command printed if the tag moved, see tag_moved_message.
 
     Returns:
         A struct containing manifest and layers of the downloaded image.
@@ -201,7 +271,17 @@ def download_with_tool(rctx, *, tool_path, reference):
         "--reference=" + reference,
         "--repository=" + rctx.attr.repository,
         "--layer-handling=" + rctx.attr.layer_handling,
-    ] + ["--registry=" + r for r in registries]
+    ] + ["--registry=" + r for r in registries] + ["--mirror=" + m for m in rctx.attr.mirrors]
+    if not rctx.attr.mirror_fallback:
+        args.append("--mirror-fallback=false")
+    if rctx.attr.platforms:
+        args.append("--platform=" + ",".join(rctx.attr.platforms))
+    if rctx.attr.plain_http:
+        args.extend(["--registry-plain-http=" + registry for (registry, _) in _sources(rctx)])
+    if verify_tag:
+        args.append("--verify-tag=" + verify_tag)
+        if tag_moved_hint:
+            args.append("--tag-moved-hint=" + tag_moved_hint)
     result = rctx.execute(args, quiet = False)
     if result.return_code != 0:
         fail("img tool failed with exit code {} and message {}".format(result.return_code, result.stderr))
@@ -0,0 +1,166 @@
+"""Repository rule for importing container images from docker save tarballs, OCI archives and OCI layout directories."""
+
+load("@img_toolchain//:defs.bzl", "tool_for_repository_os")
+
+def _blob_path(digest):
+    return "blobs/sha256/" + digest.removeprefix("sha256:")
+
+def _source_path(rctx):
+    if (rctx.attr.src == None) == (rctx.attr.layout == None):
+        fail("image_import requires exactly one of src or layout")
+    if rctx.attr.src != None:
+        return rctx.path(rctx.attr.src)
+
+    # the layout is referenced by a file in its root directory (like index.json).
+    layout = rctx.path(rctx.attr.layout).dirname
+    if hasattr(rctx, "watch_tree"):
+        # refetch the repository when blobs of the layout change.
+        rctx.watch_tree(layout)
+    return layout
+
+def _image_import_impl(rctx):
+    tool = tool_for_repository_os(rctx)
+    args = [
+        rctx.path(tool),
+        "import-archive",
+        "--output",
+        ".",
+    ]
+    if rctx.attr.repo_tag:
+        args.extend(["--repo-tag", rctx.attr.repo_tag])
+    args.append(_source_path(rctx))
+    result = rctx.execute(args, quiet = False)
+    if result.return_code != 0:
+        fail("img tool failed with exit code {} and message {}".format(result.return_code, result.stderr))
+    digest = result.stdout.strip()
+    if not digest.startswith("sha256:"):
+        fail("img tool returned an invalid digest: {}".format(repr(digest)))
+
+    # read all manifests and configs, every blob of the archive is available as a file.
+    data = {digest: rctx.read(_blob_path(digest))}
+    root_blob = json.decode(data[digest])
+    media_type = root_blob.get("mediaType", "unknown")
+    if media_type in [MEDIA_TYPE_INDEX, DOCKER_MANIFEST_LIST_V2]:
+        manifests = root_blob.get("manifests", [])
+    elif media_type in [MEDIA_TYPE_MANIFEST, DOCKER_MANIFEST_V2]:
+        manifests = [{"mediaType": media_type, "digest": digest}]
+    else:
+        fail("invalid mediaType in root blob: {}".format(media_type))
+    for manifest_index in manifests:
+        if manifest_index.get("mediaType") in [MEDIA_TYPE_INDEX, DOCKER_MANIFEST_LIST_V2]:
+            fail("image index referenced another index ({}). Nested indexes are not supported.".format(
+                manifest_index["digest"],
+            ))
+        if not manifest_index.get("mediaType") in [MEDIA_TYPE_MANIFEST, DOCKER_MANIFEST_V2]:
+            continue
+        if not rctx.path(_blob_path(manifest_index["digest"])).exists:
+            # archives of multi-platform images may only contain some platforms.
+            continue
+        data[manifest_index["digest"]] = rctx.read(_blob_path(manifest_index["digest"]))
+        config_digest = json.decode(data[manifest_index["digest"]])["config"]["digest"]
+        data[config_digest] = rctx.read(_blob_path(config_digest))
+
+    files = {
+        "sha256:" + str(blob.basename): "//:blobs/sha256/{}".format(blob.basename)
+        for blob in rctx.path("blobs/sha256").readdir()
+    }
+
+    name = getattr(rctx, "original_name", rctx.attr.name)
+    if not hasattr(rctx, "original_name"):
+        # we are on a Bazel version where `original_name` doesn't exist yet.
+        # we need to unmangle the name (see pull).
+        name = name.split("~")[-1].split("+")[-1]
+    rctx.file(
+        "BUILD.bazel",
+        content = """# This file was generated by the image_import repository rule.
+load("@rules_img//img/private:import.bzl", "image_import")
+
+image_import(
+    name = "image",
+    digest = {digest},
+    data = {data},
+    files = {files},
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = {name},
+    actual = ":image",
+    visibility = ["//visibility:public"],
+)
+""".format(
+            name = repr(name),
+            digest = repr(digest),
+            data = json.encode_indent(
+                data,
+                prefix = "    ",
+                indent = "    ",
+            ),
+            files = json.encode_indent(
+                files,
+                prefix = "    ",
+                indent = "    ",
+            ),
+        ),
+    )
+
+image_import = repository_rule(
+    implementation = _image_import_impl,
+    doc = """Imports a container image from a `docker save` tarball, an OCI archive or an OCI layout directory.
+
+Use this for legacy images that were built elsewhere (e.g. with `docker build`), so that they can be
+re-based, re-tagged and pushed hermetically with rules_img. The archive is converted by `img import-archive`
+when the repository is fetched: the layers and config of `docker save` archives are used as they are and
+described by a new OCI manifest, while OCI archives (including those of `docker save` 25 or newer) keep their
+manifests and digests. All layers are available during the build, like pulled images with `layer_handling = "eager"`.
+
+OCI layout directories (e.g. checked into the repository, or written by another tool like `skopeo copy oci:<dir>`) are
+imported with `layout` instead of `src`. Layouts may be shallow: layers that are missing from the layout are treated
+like the layers of shallow pulls, so they have to exist in the registry that the image is pushed to.
+
+The repository provides the image as `@<name>` (or `@<name>//:image`), which can be used like a pulled image,
+e.g. as the `base` of `image_manifest` or as the `image` of `image_push`.
+
+Example usage in MODULE.bazel:
+```starlark
+image_import = use_repo_rule("@rules_img//img:pull.bzl", "image_import")
+
+image_import(
+    name = "legacy_app",
+    src = "//third_party/images:legacy_app.tar",
+)
+
+image_import(
+    name = "custom_base",
+    layout = "//third_party/images/custom_base:index.json",
+)
+```
+""",
+    attrs = {
+        "layout": attr.label(
+            allow_single_file = True,
+            doc = """A file in the root directory of an OCI layout (like its `index.json` or `oci-layout`) to import the layout.
+
+The directory is imported as it is, the root manifest (or index) is selected from its `index.json` like for OCI archives.
+Manifests of an index that are missing from the layout are skipped. Mutually exclusive with `src`.""",
+        ),
+        "src": attr.label(
+            allow_single_file = True,
+            doc = """The `docker save` tarball or OCI archive (a tar of an OCI layout directory) to import.
+
+The archive may be gzip compressed. Layers of `docker save` archives may be uncompressed, gzip or zstd compressed.
+Mutually exclusive with `layout`.""",
+        ),
+        "repo_tag": attr.string(
+            doc = """The image to import from an archive with multiple images (e.g., "my/image:latest").
+
+Matches the `RepoTags` of `docker save` archives and the `io.containerd.image.name` or
+`org.opencontainers.image.ref.name` annotations of OCI archives. Required if the archive contains more than one image.""",
+        ),
+    },
+)
+
+MEDIA_TYPE_INDEX = "application/vnd.oci.image.index.v1+json"
+DOCKER_MANIFEST_LIST_V2 = "application/vnd.docker.distribution.manifest.list.v2+json"
+MEDIA_TYPE_MANIFEST = "application/vnd.oci.image.manifest.v1+json"
+DOCKER_MANIFEST_V2 = "application/vnd.docker.distribution.manifest.v2+json"
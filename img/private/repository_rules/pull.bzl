@@ -9,6 +9,7 @@ load(
     _get_blob = "get_blob",
     _get_layers = "get_layers",
     _get_manifest = "get_manifest",
+    _verify_tag = "verify_tag",
 )
 
 def _map_os_arch_to_constraints(os_arch_pairs):
@@ -40,16 +41,100 @@ def _map_os_arch_to_constraints(os_arch_pairs):
 
     return "select({{\n{}\n    }})".format("\n".join(select_items))
 
+def _platform_selected(platform, platforms):
+    """Check whether the platform of an index entry is selected by the platforms attribute.
+
+    Args:
+        platform: The platform dict of a manifest descriptor in an index (may be empty).
+        platforms: List of requested platforms in the form os/architecture[/variant].
+
+    Returns:
+        True if the manifest should be pulled.
+    """
+    if len(platforms) == 0:
+        return True
+    if not platform:
+        # manifests without a platform (like attestations) are only pulled if no filter is set.
+        return False
+    for wanted in platforms:
+        parts = wanted.split("/")
+        if len(parts) < 2 or len(parts) > 3:
+            fail("invalid platform {}: expected os/architecture[/variant]".format(repr(wanted)))
+        if platform.get("os", "") != parts[0] or platform.get("architecture", "") != parts[1]:
+            continue
+        if len(parts) == 3 and platform.get("variant", "") != parts[2]:
+            continue
+        return True
+    return False
+
+def _tag_key(rctx):
+    """The tag in the form registry/repository:tag, as used by lock files.
+
+    The registry is the registry attribute (or the first entry of registries, or "index.docker.io").
+
+    Args:
+        rctx: Repository context.
+
+    Returns:
+        The tag reference.
+    """
+    registry = rctx.attr.registry
+    if not registry:
+        registry = rctx.attr.registries[0] if rctx.attr.registries else "index.docker.io"
+    return "{}/{}:{}".format(registry, rctx.attr.repository, rctx.attr.tag)
+
+def _tag_moved_hint(rctx, locked):
+    """Copy-pasteable command (or instruction) that pins the current digest of the tag.
+
+    Args:
+        rctx: Repository context.
+        locked: Whether the digest was read from the lock file.
+
+    Returns:
+        The hint. "{digest}" is replaced by the current digest.
+    """
+    if locked:
+        lock_file = rctx.attr.lock_file
+        path = lock_file.package + "/" + lock_file.name if lock_file.package else lock_file.name
+        return "img lock --update --image {} {}".format(_tag_key(rctx), path)
+    return "digest = \"{digest}\"  # in the pull rule of " + getattr(rctx, "original_name", rctx.attr.name)
+
+def _locked_digest(rctx):
+    """Look up the digest of the tag in the lock file.
+
+    Args:
+        rctx: Repository context.
+
+    Returns:
+        The locked digest.
+    """
+    if not rctx.attr.tag:
+        fail("lock_file requires a tag")
+    key = _tag_key(rctx)
+    content = rctx.read(rctx.attr.lock_file).strip()
+    lock = json.decode(content) if content else {}
+    locked = lock.get("images", {}).get(key, {})
+    if not locked.get("digest"):
+        fail("{} is not locked in {}. Add it to the images of your image_lock target and run it (or use `img lock --image {} <lock_file>`).".format(key, rctx.attr.lock_file, key))
+    return locked["digest"]
+
 def _pull_impl(rctx):
     """Pull an image from a registry and generate a BUILD file."""
+    digest = rctx.attr.digest
+    locked = False
+    if not digest and rctx.attr.lock_file:
+        digest = _locked_digest(rctx)
+        locked = True
     have_valid_digest = True
-    if len(rctx.attr.digest) != 71:
+    if len(digest) != 71:
         have_valid_digest = False
-    elif not rctx.attr.digest.startswith("sha256:"):
+    elif not digest.startswith("sha256:"):
         have_valid_digest = False
-    reference = rctx.attr.digest if have_valid_digest else rctx.attr.tag
+    reference = digest if have_valid_digest else rctx.attr.tag
     if len(reference) == 0:
         fail("either digest or tag must be specified")
+    if rctx.attr.verify_tag and not (rctx.attr.tag and have_valid_digest):
+        fail("verify_tag requires a tag and a digest (or a lock_file)")
 
     if rctx.attr.downloader == "img_tool":
         # pre-download all files using the img tool
@@ -60,10 +145,20 @@ def _pull_impl(rctx):
             rctx,
             tool_path = tool_path,
             reference = reference,
+            verify_tag = rctx.attr.tag if rctx.attr.verify_tag else None,
+            tag_moved_hint = _tag_moved_hint(rctx, locked) if rctx.attr.verify_tag else None,
+        )
+    elif rctx.attr.verify_tag:
+        _verify_tag(
+            rctx,
+            tag = rctx.attr.tag,
+            digest = digest,
+            display_name = _tag_key(rctx),
+            hint = _tag_moved_hint(rctx, locked),
         )
 
     manifest_kwargs = dict(
-        canonical_id = rctx.attr.repository + ((":" + rctx.attr.tag) if rctx.attr.tag else ("@" + rctx.attr.digest)),
+        canonical_id = rctx.attr.repository + ((":" + rctx.attr.tag) if rctx.attr.tag else ("@" + digest)),
     )
     if rctx.attr.registry == "docker.io":
         print("Specified docker.io as registry. Did you mean \"index.docker.io\"?")  # buildifier: disable=print
@@ -78,9 +173,11 @@ def _pull_impl(rctx):
         manifests = root_blob.get("manifests", [])
     elif media_type in [MEDIA_TYPE_MANIFEST, DOCKER_MANIFEST_V2]:
         is_index = False
-        manifests = [{"mediaType": MEDIA_TYPE_MANIFEST, "digest": rctx.attr.digest}]
+        manifests = [{"mediaType": MEDIA_TYPE_MANIFEST, "digest": digest}]
     else:
         fail("invalid mediaType in manifest: {}".format(media_type))
+    if is_index and len(rctx.attr.platforms) > 0 and not [m for m in manifests if _platform_selected(m.get("platform", {}), rctx.attr.platforms)]:
+        fail("no manifest in index {} matches platform(s) {}".format(reference, ", ".join(rctx.attr.platforms)))
 
     # TODO: switch to builtin set (requires Bazel 8+)
     # layer_digests = set()
@@ -96,6 +193,9 @@ def _pull_impl(rctx):
             ))
         if not manifest_index.get("mediaType") in [MEDIA_TYPE_MANIFEST, DOCKER_MANIFEST_V2]:
             continue
+        if is_index and not _platform_selected(manifest_index.get("platform", {}), rctx.attr.platforms):
+            # only pull the manifests (and layers) of the requested platforms
+            continue
         if is_index:
             manifest_info = _get_manifest(rctx, reference = manifest_index["digest"])
             data[manifest_info.digest] = manifest_info.data
@@ -177,6 +277,7 @@ download_blobs(
     digests = {layer_digests},
     registries = {registries},
     repository = {repository},
+    plain_http = {plain_http},
     tags = ["requires-network"],
 )
 """.format(
@@ -191,6 +292,7 @@ download_blobs(
                 indent = "    ",
             ),
             repository = repr(rctx.attr.repository),
+            plain_http = repr(rctx.attr.plain_http),
         )
 
     # Build target_compatible_with based on discovered platforms
@@ -226,7 +328,7 @@ alias(
             ),
             maybe_lazy_layer_download = maybe_lazy_layer_download,
             name = repr(name),
-            digest = repr(rctx.attr.digest),
+            digest = repr(digest),
             data = json.encode_indent(
                 data,
                 prefix = "    ",
@@ -271,6 +373,7 @@ pull(
 
 The `digest` parameter is recommended for reproducible builds. If omitted, the rule
 will resolve the tag to a digest at fetch time and print a warning.
+Alternatively, the digest can be read from a lock file that is maintained by `image_lock` (see `lock_file`).
 """,
     attrs = {
         "registry": attr.string(
@@ -283,6 +386,34 @@ If not specified, defaults to Docker Hub. Can be overridden by entries in regist
 
 These registries will be tried in order before the primary registry. Useful for
 corporate environments with registry mirrors or air-gapped setups.""",
+        ),
+        "mirrors": attr.string_list(
+            doc = """List of registry mirrors or pull-through caches (e.g., an Artifactory proxy) to try in order.
+
+Each entry has the form `host[/prefix]`. The image is pulled from `<host>/<prefix>/<repository>`,
+so mirrors serving upstream repositories below a path prefix are supported.
+Mirrors are tried before the registry and registries. Credentials are looked up for the host of each mirror.
+If the image cannot be pulled from any mirror, the registries are used as a fallback (see `mirror_fallback`).""",
+        ),
+        "mirror_fallback": attr.bool(
+            default = True,
+            doc = """Whether to fall back to the registry and registries if the image cannot be pulled from any mirror.
+
+Set this to False to enforce that images are only pulled through the mirrors (e.g., in air-gapped setups).""",
+        ),
+        "plain_http": attr.bool(
+            default = False,
+            doc = """Whether to contact the registry, registries and mirrors over plain HTTP without TLS.
+
+Only use this for local development registries (e.g., `localhost:5000` or a kind registry).
+Pushing to or loading from such registries is configured with `--@rules_img//img/settings:plain_http_registries`.""",
+        ),
+        "platforms": attr.string_list(
+            doc = """Platforms to pull from an image index, in the form `os/architecture[/variant]` (e.g., `["linux/amd64", "linux/arm64"]`).
+
+Only the manifests, configs and (with eager layer handling) layers of these platforms are downloaded.
+The pulled image still references the original index, but other platforms cannot be used as a base image.
+If empty, all platforms are pulled. Has no effect on single-platform images.""",
         ),
         "repository": attr.string(
             mandatory = True,
@@ -300,6 +431,23 @@ While required, it's recommended to also specify a digest for reproducible build
 
 When specified, the image is pulled by digest instead of tag, ensuring reproducible
 builds. The digest must be a full SHA256 digest starting with "sha256:".""",
+        ),
+        "lock_file": attr.label(
+            allow_single_file = [".json"],
+            doc = """Lock file (as written by `image_lock` or `img lock`) to read the digest of the tag from.
+
+If `digest` is not set, the digest locked for `<registry>/<repository>:<tag>` is used.
+The registry is the `registry` attribute (or the first entry of `registries`, or "index.docker.io").
+Fails if the tag is not locked. The repository is refetched when the lock file changes.""",
+        ),
+        "verify_tag": attr.bool(
+            default = False,
+            doc = """Whether to check that the tag still points to the digest when the repository is fetched.
+
+Requires `tag` and `digest` (or `lock_file`). If the tag moved upstream, fetching fails with the
+expected and current digests and a copy-pasteable command that pins the current digest
+(`img lock --update ...` for locked digests). This catches base images that were updated upstream
+without silently changing the build.""",
         ),
         "layer_handling": attr.string(
             default = "shallow",
@@ -9,6 +9,7 @@ def _load_settings_impl(ctx):
         daemon = ctx.attr._load_daemon[BuildSettingInfo].value,
         remote_cache = ctx.attr._remote_cache[BuildSettingInfo].value,
         credential_helper = ctx.attr._credential_helper[BuildSettingInfo].value,
+        plain_http_registries = ctx.attr._plain_http_registries[BuildSettingInfo].value,
     )]
 
 load_settings = rule(
@@ -30,5 +31,9 @@ load_settings = rule(
             default = Label("//img/settings:credential_helper"),
             providers = [BuildSettingInfo],
         ),
+        "_plain_http_registries": attr.label(
+            default = Label("//img/settings:plain_http_registries"),
+            providers = [BuildSettingInfo],
+        ),
     },
 )
@@ -7,11 +7,15 @@ def _push_settings_impl(ctx):
     strategy = ctx.attr._push_strategy[BuildSettingInfo].value
     remote_cache = ctx.attr._remote_cache[BuildSettingInfo].value
     credential_helper = ctx.attr._credential_helper[BuildSettingInfo].value
+    plain_http_registries = ctx.attr._plain_http_registries[BuildSettingInfo].value
+    jobs = ctx.attr._push_jobs[BuildSettingInfo].value
 
     return [PushSettingsInfo(
         strategy = strategy,
         remote_cache = remote_cache,
         credential_helper = credential_helper,
+        plain_http_registries = plain_http_registries,
+        jobs = jobs,
     )]
 
 push_settings = rule(
@@ -29,5 +33,13 @@ push_settings = rule(
             default = Label("//img/settings:credential_helper"),
             providers = [BuildSettingInfo],
         ),
+        "_plain_http_registries": attr.label(
+            default = Label("//img/settings:plain_http_registries"),
+            providers = [BuildSettingInfo],
+        ),
+        "_push_jobs": attr.label(
+            default = Label("//img/settings:push_jobs"),
+            providers = [BuildSettingInfo],
+        ),
     },
 )
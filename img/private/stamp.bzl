@@ -1,7 +1,7 @@
 """Shared stamping utilities for Bazel rules."""
 
 load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
-load("//img/private/common:build.bzl", "TOOLCHAIN")
+load("//img/private/common:build.bzl", "get_toolchain_info")
 load("//img/private/providers:stamp_setting_info.bzl", "StampSettingInfo")
 
 def get_build_settings(ctx):
@@ -110,7 +110,7 @@ def expand_or_write(*, ctx, templates, output_name, only_if_stamping = False):
 
         args.extend([template_json.path, final_json.path])
 
-        img_toolchain_info = ctx.toolchains[TOOLCHAIN].imgtoolchaininfo
+        img_toolchain_info = get_toolchain_info(ctx)
         ctx.actions.run(
             inputs = inputs,
             outputs = [final_json],
@@ -2,13 +2,19 @@
 
 load("//img/private/providers:index_info.bzl", _ImageIndexInfo = "ImageIndexInfo")
 load("//img/private/providers:layer_info.bzl", _LayerInfo = "LayerInfo")
+load("//img/private/providers:layers_info.bzl", _LayersInfo = "LayersInfo")
 load("//img/private/providers:manifest_info.bzl", _ImageManifestInfo = "ImageManifestInfo")
 load("//img/private/providers:pull_info.bzl", _PullInfo = "PullInfo")
+load("//img/private/providers:push_info.bzl", _PushInfo = "PushInfo")
 
 # providers describing images and their components
 LayerInfo = _LayerInfo
+LayersInfo = _LayersInfo
 ImageManifestInfo = _ImageManifestInfo
 ImageIndexInfo = _ImageIndexInfo
 
 # providers with metadata about pulled base images
 PullInfo = _PullInfo
+
+# providers with metadata about pushed images
+PushInfo = _PushInfo
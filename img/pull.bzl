@@ -1,5 +1,7 @@
 """Public API for pulling base container images."""
 
+load("//img/private/repository_rules:import.bzl", _image_import = "image_import")
 load("//img/private/repository_rules:pull.bzl", _pull = "pull")
 
+image_import = _image_import
 pull = _pull
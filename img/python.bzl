@@ -0,0 +1,5 @@
+"""Public API for layering Python binaries."""
+
+load("//img/private:python.bzl", _py_image_layer = "py_image_layer")
+
+py_image_layer = _py_image_layer
@@ -0,0 +1,22 @@
+"""Public API for testing container images.
+
+The `image_test` rule evaluates assertions against the filesystem and config of an image
+(file exists, mode, owner, contents, environment variables, entrypoint, ...) without a container runtime.
+
+## Example
+
+```python
+load("@rules_img//img:test.bzl", "image_test")
+
+image_test(
+    name = "app_image_test",
+    image = ":app_image",
+    file_exists = ["/app/bin/server"],
+    entrypoint = ["/app/bin/server"],
+)
+```
+"""
+
+load("//img/private:image_test.bzl", _image_test = "image_test")
+
+image_test = _image_test
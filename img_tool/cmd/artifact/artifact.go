@@ -0,0 +1,274 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/subject"
+)
+
+// EmptyConfig is the config blob of artifacts without a config.
+var EmptyConfig = []byte("{}")
+
+// Config holds the options of an artifact invocation.
+type Config struct {
+	// ArtifactType is the artifactType of the manifest.
+	ArtifactType string
+	// Blobs are the files of the artifact, in order.
+	// BlobMediaTypes and BlobMetadataOutputs are parallel to Blobs.
+	// Metadata outputs receive a layer metadata file of the blob (like "img layer --metadata").
+	Blobs               []string
+	BlobMediaTypes      []string
+	BlobMetadataOutputs []string
+	// ConfigFile is the optional config blob with the media type ConfigMediaType.
+	// Without a config file, the empty descriptor of the OCI image spec is used.
+	ConfigFile      string
+	ConfigMediaType string
+	// Annotations of the manifest.
+	Annotations map[string]string
+	// Subject is a raw image manifest or image index referenced as the subject of the manifest.
+	Subject string
+	// Output files. Empty outputs are not written.
+	ManifestOutput   string
+	ConfigOutput     string
+	DescriptorOutput string
+	DigestOutput     string
+}
+
+// Runner creates the manifest of an OCI artifact.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func ArtifactProcess(ctx context.Context, args []string) {
+	cfg := Config{Annotations: make(map[string]string)}
+	flagSet := flag.NewFlagSet("artifact", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Creates the manifest of an OCI artifact (a non-image manifest, like a WASM module or a Helm chart) from arbitrary files.\n")
+		fmt.Fprintf(flagSet.Output(), "Every file becomes a layer of the manifest. The artifact can be pushed like an image.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img artifact --artifact-type=type [--blob=file --blob-media-type=type [--blob-metadata=output]]... [--config-file=file --config-media-type=type] --manifest=output [--config=output]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img artifact --artifact-type application/vnd.wasm.config.v0+json --blob module.wasm --blob-media-type application/wasm --manifest manifest.json --config config.json",
+			"img artifact --artifact-type application/vnd.cncf.helm.config.v1+json --config-file Chart.json --config-media-type application/vnd.cncf.helm.config.v1+json --blob chart.tgz --blob-media-type application/vnd.cncf.helm.chart.content.v1.tar+gzip --manifest manifest.json --config config.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&cfg.ArtifactType, "artifact-type", "", `The artifactType of the manifest (required).`)
+	flagSet.Var((*stringList)(&cfg.Blobs), "blob", `File to add as a layer of the artifact (can be specified multiple times, in order).`)
+	flagSet.Var((*stringList)(&cfg.BlobMediaTypes), "blob-media-type", `Media type of the blob at the same position (can be specified multiple times, once per --blob).`)
+	flagSet.Var((*stringList)(&cfg.BlobMetadataOutputs), "blob-metadata", `Output file for the layer metadata of the blob at the same position (optional, once per --blob if used).`)
+	flagSet.StringVar(&cfg.ConfigFile, "config-file", "", `The config blob of the artifact. Defaults to the empty config ({}) with the media type application/vnd.oci.empty.v1+json.`)
+	flagSet.StringVar(&cfg.ConfigMediaType, "config-media-type", "", `The media type of --config-file (required with --config-file).`)
+	flagSet.Var(annotationsFlag(cfg.Annotations), "annotation", `Annotation of the manifest as key=value (can be specified multiple times).`)
+	flagSet.StringVar(&cfg.Subject, "subject", "", `A raw image manifest or image index to reference as the subject of the manifest (OCI referrers API).`)
+	flagSet.StringVar(&cfg.ManifestOutput, "manifest", "", `The output file for the manifest.`)
+	flagSet.StringVar(&cfg.ConfigOutput, "config", "", `The output file for the config blob.`)
+	flagSet.StringVar(&cfg.DescriptorOutput, "descriptor", "", `The output file for the descriptor of the manifest.`)
+	flagSet.StringVar(&cfg.DigestOutput, "digest", "", `The output file for the digest of the manifest.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 0 || cfg.ArtifactType == "" || cfg.ManifestOutput == "" {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing artifact manifest", logging.ErrKey, err)
+	}
+}
+
+// Run writes the manifest, config, descriptor, digest and blob metadata to the configured outputs.
+func (r *Runner) Run(_ context.Context) error {
+	if len(r.cfg.BlobMediaTypes) != len(r.cfg.Blobs) {
+		return fmt.Errorf("got %d blobs, but %d blob media types", len(r.cfg.Blobs), len(r.cfg.BlobMediaTypes))
+	}
+	if len(r.cfg.BlobMetadataOutputs) != 0 && len(r.cfg.BlobMetadataOutputs) != len(r.cfg.Blobs) {
+		return fmt.Errorf("got %d blobs, but %d blob metadata outputs", len(r.cfg.Blobs), len(r.cfg.BlobMetadataOutputs))
+	}
+	if len(r.cfg.Blobs) == 0 {
+		return fmt.Errorf("an artifact needs at least one blob")
+	}
+	if (r.cfg.ConfigFile == "") != (r.cfg.ConfigMediaType == "") {
+		return fmt.Errorf("--config-file and --config-media-type must be used together")
+	}
+
+	config := EmptyConfig
+	configMediaType := specv1.MediaTypeEmptyJSON
+	if r.cfg.ConfigFile != "" {
+		var err error
+		config, err = os.ReadFile(r.cfg.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("reading config: %w", err)
+		}
+		configMediaType = r.cfg.ConfigMediaType
+	}
+
+	layers := make([]specv1.Descriptor, len(r.cfg.Blobs))
+	for i, blob := range r.cfg.Blobs {
+		if r.cfg.BlobMediaTypes[i] == "" {
+			return fmt.Errorf("empty media type of blob %s", blob)
+		}
+		blobDigest, size, err := sha256File(blob)
+		if err != nil {
+			return fmt.Errorf("hashing blob %s: %w", blob, err)
+		}
+		layer := api.Descriptor{
+			Name:      filepath.Base(blob),
+			DiffID:    blobDigest,
+			MediaType: r.cfg.BlobMediaTypes[i],
+			Digest:    blobDigest,
+			Size:      size,
+			// the title is used as the file name by tools like oras
+			Annotations: map[string]string{specv1.AnnotationTitle: filepath.Base(blob)},
+		}
+		layers[i] = specv1.Descriptor{
+			MediaType:   layer.MediaType,
+			Digest:      digest.Digest(layer.Digest),
+			Size:        layer.Size,
+			Annotations: layer.Annotations,
+		}
+		if len(r.cfg.BlobMetadataOutputs) > 0 {
+			if err := writeJSON(r.cfg.BlobMetadataOutputs[i], layer); err != nil {
+				return fmt.Errorf("writing metadata of blob %s: %w", blob, err)
+			}
+		}
+	}
+
+	manifest := specv1.Manifest{
+		MediaType:    specv1.MediaTypeImageManifest,
+		ArtifactType: r.cfg.ArtifactType,
+		Config: specv1.Descriptor{
+			MediaType: configMediaType,
+			Digest:    digest.Digest(sha256Digest(config)),
+			Size:      int64(len(config)),
+		},
+		Layers: layers,
+	}
+	manifest.SchemaVersion = 2
+	if r.cfg.ConfigFile == "" {
+		// the empty descriptor embeds its data
+		manifest.Config.Data = EmptyConfig
+	}
+	if len(r.cfg.Annotations) > 0 {
+		manifest.Annotations = r.cfg.Annotations
+	}
+	if r.cfg.Subject != "" {
+		subjectDescriptor, err := subject.ReadDescriptor(r.cfg.Subject)
+		if err != nil {
+			return err
+		}
+		manifest.Subject = subjectDescriptor
+	}
+
+	manifestRaw, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+	manifestDigest := sha256Digest(manifestRaw)
+	if err := os.WriteFile(r.cfg.ManifestOutput, manifestRaw, 0o644); err != nil {
+		return fmt.Errorf("writing manifest to %s: %w", r.cfg.ManifestOutput, err)
+	}
+	if r.cfg.ConfigOutput != "" {
+		if err := os.WriteFile(r.cfg.ConfigOutput, config, 0o644); err != nil {
+			return fmt.Errorf("writing config to %s: %w", r.cfg.ConfigOutput, err)
+		}
+	}
+	if r.cfg.DescriptorOutput != "" {
+		descriptor := api.Descriptor{
+			MediaType: specv1.MediaTypeImageManifest,
+			Digest:    manifestDigest,
+			Size:      int64(len(manifestRaw)),
+		}
+		if err := writeJSON(r.cfg.DescriptorOutput, descriptor); err != nil {
+			return fmt.Errorf("writing manifest descriptor to %s: %w", r.cfg.DescriptorOutput, err)
+		}
+	}
+	if r.cfg.DigestOutput != "" {
+		if err := os.WriteFile(r.cfg.DigestOutput, []byte(manifestDigest), 0o644); err != nil {
+			return fmt.Errorf("writing digest to %s: %w", r.cfg.DigestOutput, err)
+		}
+	}
+	return nil
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func sha256File(filePath string) (string, int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), size, nil
+}
+
+func writeJSON(filePath string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, raw, 0o644)
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type annotationsFlag map[string]string
+
+func (a annotationsFlag) String() string {
+	var pairs []string
+	for k, v := range a {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (a annotationsFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("annotation must be in format key=value, got: %s", value)
+	}
+	a[key] = val
+	return nil
+}
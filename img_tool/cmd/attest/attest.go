@@ -0,0 +1,398 @@
+package attest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/templating"
+)
+
+const (
+	// MediaTypeInToto is the media type of in-toto statements (and the artifactType of attestation manifests).
+	MediaTypeInToto = "application/vnd.in-toto+json"
+	// StatementType is the type of in-toto v1 statements.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateTypeSLSAProvenance is the predicate type of SLSA v1 provenance.
+	PredicateTypeSLSAProvenance = "https://slsa.dev/provenance/v1"
+	// AnnotationPredicateType annotates the statement layer with its predicate type,
+	// so that clients can select attestations without downloading them.
+	AnnotationPredicateType = "in-toto.io/predicate-type"
+
+	// DefaultBuilderID identifies rules_img as the builder.
+	DefaultBuilderID = "https://github.com/bazel-contrib/rules_img/image_push"
+	// BuildType describes how the externalParameters of the provenance are interpreted.
+	BuildType = "https://github.com/bazel-contrib/rules_img/provenance/v1"
+)
+
+// sourceRevisionKeys are the stamp variables that hold the source revision, in order of precedence.
+var sourceRevisionKeys = []string{"STABLE_GIT_COMMIT", "STABLE_BUILD_SCM_REVISION", "BUILD_SCM_REVISION"}
+
+// sourceRepositoryKeys are the stamp variables that hold the URL of the source repository, in order of precedence.
+var sourceRepositoryKeys = []string{"STABLE_GIT_URL", "STABLE_BUILD_SCM_REMOTE", "BUILD_SCM_REMOTE"}
+
+// Config holds the options of an attest invocation.
+type Config struct {
+	// Subject is a raw image manifest or image index that the provenance is about.
+	Subject string
+	// SubjectName is the name of the subject in the statement (usually registry/repository).
+	SubjectName string
+	// ConfigurationFile is an (expanded) JSON file with the keys builder_id, invocation_id,
+	// source_revision, source_repository and subject_name. Non-empty values take precedence over flags.
+	ConfigurationFile string
+	// BuilderID is the id of the builder that produced the subject.
+	BuilderID string
+	// InvocationID is the id of the Bazel invocation that produced the subject.
+	InvocationID string
+	// SourceRevision and SourceRepository describe the source the subject was built from.
+	// If unset, they are read from the stamp files.
+	SourceRevision   string
+	SourceRepository string
+	// StampFiles are Bazel workspace status files (stable-status.txt and volatile-status.txt).
+	StampFiles []string
+	// Parameters are the externalParameters of the build (like the label of the target).
+	Parameters map[string]string
+	// Output files. Empty outputs are not written.
+	StatementOutput  string
+	ManifestOutput   string
+	ConfigOutput     string
+	DescriptorOutput string
+}
+
+// Runner writes the SLSA provenance of an image as an attestation manifest.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func AttestProcess(ctx context.Context, args []string) {
+	cfg := Config{Parameters: make(map[string]string)}
+	flagSet := flag.NewFlagSet("attest", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Writes a SLSA v1 provenance of an image as an in-toto statement.\n")
+		fmt.Fprintf(flagSet.Output(), "The statement is wrapped in an attestation manifest that references the image as its subject,\n")
+		fmt.Fprintf(flagSet.Output(), "so that it can be pushed as a referrer of the image.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img attest --subject=manifest.json [--builder-id=id] [--invocation-id=id] [--stamp=file]... --statement=output [--manifest=output --config=output]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img attest --subject manifest.json --invocation-id 5b2f8c1e-9d7a-4c2b-8e1f-3a6d9c0b7e42 --source-revision $(git rev-parse HEAD) --statement provenance.json",
+			"img attest --subject index.json --stamp bazel-out/stable-status.txt --stamp bazel-out/volatile-status.txt --parameter target=//app:image --statement provenance.json --manifest attestation.json --config attestation_config.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&cfg.Subject, "subject", "", `The raw image manifest or image index that the provenance is about (required).`)
+	flagSet.StringVar(&cfg.SubjectName, "subject-name", "", `The name of the subject in the statement, like registry/repository.`)
+	flagSet.StringVar(&cfg.ConfigurationFile, "configuration-file", "", `An (expanded) JSON file with the keys builder_id, invocation_id, source_revision, source_repository and subject_name. Non-empty values take precedence over the flags.`)
+	flagSet.StringVar(&cfg.BuilderID, "builder-id", DefaultBuilderID, `The id of the builder.`)
+	flagSet.StringVar(&cfg.InvocationID, "invocation-id", "", `The id of the Bazel invocation that built the image.`)
+	flagSet.StringVar(&cfg.SourceRevision, "source-revision", "", `The source revision (like a git commit). Defaults to the stamp variable STABLE_GIT_COMMIT, STABLE_BUILD_SCM_REVISION or BUILD_SCM_REVISION.`)
+	flagSet.StringVar(&cfg.SourceRepository, "source-repository", "", `The URL of the source repository. Defaults to the stamp variable STABLE_GIT_URL, STABLE_BUILD_SCM_REMOTE or BUILD_SCM_REMOTE.`)
+	flagSet.Func("stamp", `Path to a Bazel workspace status file (can be specified multiple times).`, func(s string) error {
+		cfg.StampFiles = append(cfg.StampFiles, s)
+		return nil
+	})
+	flagSet.Var(parametersFlag(cfg.Parameters), "parameter", `External parameter of the build as key=value (can be specified multiple times).`)
+	flagSet.StringVar(&cfg.StatementOutput, "statement", "", `The output file for the in-toto statement (required).`)
+	flagSet.StringVar(&cfg.ManifestOutput, "manifest", "", `The output file for the attestation manifest.`)
+	flagSet.StringVar(&cfg.ConfigOutput, "config", "", `The output file for the (empty) config of the attestation manifest.`)
+	flagSet.StringVar(&cfg.DescriptorOutput, "descriptor", "", `The output file for the descriptor of the attestation manifest.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 0 || cfg.Subject == "" || cfg.StatementOutput == "" {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing attestation", logging.ErrKey, err)
+	}
+}
+
+// Run writes the statement and, if requested, the attestation manifest, its config and its descriptor.
+func (r *Runner) Run(_ context.Context) error {
+	if err := r.applyConfigurationFile(); err != nil {
+		return err
+	}
+	stamp, err := readStampFiles(r.cfg.StampFiles)
+	if err != nil {
+		return err
+	}
+	if r.cfg.SourceRevision == "" {
+		r.cfg.SourceRevision = firstStampValue(stamp, sourceRevisionKeys)
+	}
+	if r.cfg.SourceRepository == "" {
+		r.cfg.SourceRepository = firstStampValue(stamp, sourceRepositoryKeys)
+	}
+	if r.cfg.BuilderID == "" {
+		return fmt.Errorf("the builder id must not be empty")
+	}
+
+	subjectRaw, err := os.ReadFile(r.cfg.Subject)
+	if err != nil {
+		return fmt.Errorf("reading subject: %w", err)
+	}
+	var subjectHeader struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(subjectRaw, &subjectHeader); err != nil {
+		return fmt.Errorf("decoding subject: %w", err)
+	}
+	subjectDigest := sha256Digest(subjectRaw)
+
+	statement := r.statement(subjectDigest, stamp["BUILD_TIMESTAMP"])
+	statementRaw, err := json.Marshal(statement)
+	if err != nil {
+		return fmt.Errorf("marshaling statement: %w", err)
+	}
+	if err := os.WriteFile(r.cfg.StatementOutput, statementRaw, 0o644); err != nil {
+		return fmt.Errorf("writing statement to %s: %w", r.cfg.StatementOutput, err)
+	}
+	if r.cfg.ManifestOutput == "" {
+		return nil
+	}
+
+	emptyConfig := []byte("{}")
+	manifest := specv1.Manifest{
+		MediaType:    specv1.MediaTypeImageManifest,
+		ArtifactType: MediaTypeInToto,
+		Config: specv1.Descriptor{
+			MediaType: specv1.MediaTypeEmptyJSON,
+			Digest:    digest.Digest(sha256Digest(emptyConfig)),
+			Size:      int64(len(emptyConfig)),
+			Data:      emptyConfig,
+		},
+		Layers: []specv1.Descriptor{{
+			MediaType:   MediaTypeInToto,
+			Digest:      digest.Digest(sha256Digest(statementRaw)),
+			Size:        int64(len(statementRaw)),
+			Annotations: map[string]string{AnnotationPredicateType: PredicateTypeSLSAProvenance},
+		}},
+		Subject: &specv1.Descriptor{
+			MediaType: subjectHeader.MediaType,
+			Digest:    digest.Digest(subjectDigest),
+			Size:      int64(len(subjectRaw)),
+		},
+	}
+	manifest.SchemaVersion = 2
+	manifestRaw, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshaling attestation manifest: %w", err)
+	}
+	if err := os.WriteFile(r.cfg.ManifestOutput, manifestRaw, 0o644); err != nil {
+		return fmt.Errorf("writing attestation manifest to %s: %w", r.cfg.ManifestOutput, err)
+	}
+	if r.cfg.ConfigOutput != "" {
+		if err := os.WriteFile(r.cfg.ConfigOutput, emptyConfig, 0o644); err != nil {
+			return fmt.Errorf("writing attestation config to %s: %w", r.cfg.ConfigOutput, err)
+		}
+	}
+	if r.cfg.DescriptorOutput != "" {
+		descriptorRaw, err := json.Marshal(api.Descriptor{
+			MediaType: specv1.MediaTypeImageManifest,
+			Digest:    sha256Digest(manifestRaw),
+			Size:      int64(len(manifestRaw)),
+		})
+		if err != nil {
+			return fmt.Errorf("marshaling attestation descriptor: %w", err)
+		}
+		if err := os.WriteFile(r.cfg.DescriptorOutput, descriptorRaw, 0o644); err != nil {
+			return fmt.Errorf("writing attestation descriptor to %s: %w", r.cfg.DescriptorOutput, err)
+		}
+	}
+	return nil
+}
+
+// applyConfigurationFile overrides the flags with the non-empty values of the configuration file.
+func (r *Runner) applyConfigurationFile() error {
+	if r.cfg.ConfigurationFile == "" {
+		return nil
+	}
+	configuration, err := templating.ReadConfiguration(r.cfg.ConfigurationFile)
+	if err != nil {
+		return err
+	}
+	for key, field := range map[string]*string{
+		"builder_id":        &r.cfg.BuilderID,
+		"invocation_id":     &r.cfg.InvocationID,
+		"source_revision":   &r.cfg.SourceRevision,
+		"source_repository": &r.cfg.SourceRepository,
+		"subject_name":      &r.cfg.SubjectName,
+	} {
+		value, err := configuration.String(key)
+		if err != nil {
+			return err
+		}
+		if value != "" {
+			*field = value
+		}
+	}
+	return nil
+}
+
+// statement builds the in-toto statement with the SLSA v1 provenance of the subject.
+func (r *Runner) statement(subjectDigest, buildTimestamp string) statement {
+	algorithm, hex, _ := strings.Cut(subjectDigest, ":")
+	externalParameters := make(map[string]string, len(r.cfg.Parameters))
+	for k, v := range r.cfg.Parameters {
+		externalParameters[k] = v
+	}
+	var dependencies []resourceDescriptor
+	if r.cfg.SourceRevision != "" {
+		source := resourceDescriptor{
+			URI:    r.cfg.SourceRepository,
+			Digest: map[string]string{"gitCommit": r.cfg.SourceRevision},
+		}
+		if source.URI != "" {
+			source.URI = "git+" + strings.TrimPrefix(source.URI, "git+") + "@" + r.cfg.SourceRevision
+			externalParameters["source"] = source.URI
+		}
+		dependencies = append(dependencies, source)
+	}
+	metadata := &buildMetadata{InvocationID: r.cfg.InvocationID}
+	if seconds, err := strconv.ParseInt(buildTimestamp, 10, 64); err == nil {
+		metadata.StartedOn = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	}
+	if metadata.InvocationID == "" && metadata.StartedOn == "" {
+		metadata = nil
+	}
+	return statement{
+		Type: StatementType,
+		Subject: []resourceDescriptor{{
+			Name:   r.cfg.SubjectName,
+			Digest: map[string]string{algorithm: hex},
+		}},
+		PredicateType: PredicateTypeSLSAProvenance,
+		Predicate: provenance{
+			BuildDefinition: buildDefinition{
+				BuildType:            BuildType,
+				ExternalParameters:   externalParameters,
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: runDetails{
+				Builder:  builder{ID: r.cfg.BuilderID},
+				Metadata: metadata,
+			},
+		},
+	}
+}
+
+type statement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     provenance           `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type provenance struct {
+	BuildDefinition buildDefinition `json:"buildDefinition"`
+	RunDetails      runDetails      `json:"runDetails"`
+}
+
+type buildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]string    `json:"externalParameters"`
+	ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type runDetails struct {
+	Builder  builder        `json:"builder"`
+	Metadata *buildMetadata `json:"metadata,omitempty"`
+}
+
+type builder struct {
+	ID string `json:"id"`
+}
+
+type buildMetadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+	StartedOn    string `json:"startedOn,omitempty"`
+}
+
+// readStampFiles reads Bazel workspace status files into a map.
+// Each line holds a key and a value, separated by the first space.
+func readStampFiles(paths []string) (map[string]string, error) {
+	stamp := make(map[string]string)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening stamp file: %w", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, _ := strings.Cut(scanner.Text(), " ")
+			if key != "" {
+				stamp[key] = value
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading stamp file %s: %w", path, err)
+		}
+	}
+	return stamp, nil
+}
+
+func firstStampValue(stamp map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := stamp[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+type parametersFlag map[string]string
+
+func (p parametersFlag) String() string {
+	var pairs []string
+	for k, v := range p {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p parametersFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("parameter must be in format key=value, got: %s", value)
+	}
+	p[key] = val
+	return nil
+}
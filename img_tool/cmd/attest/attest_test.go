@@ -0,0 +1,205 @@
+package attest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const testSubject = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc","size":100},"layers":[]}`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readStatement(t *testing.T, p string) statement {
+	t.Helper()
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s statement
+	if err := json.Unmarshal(raw, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Subject:     writeFile(t, dir, "manifest.json", testSubject),
+		SubjectName: "registry.example.com/app",
+		BuilderID:   DefaultBuilderID,
+		StampFiles: []string{
+			writeFile(t, dir, "stable-status.txt", "STABLE_GIT_COMMIT abc123\nSTABLE_GIT_URL https://github.com/example/app.git\n"),
+			writeFile(t, dir, "volatile-status.txt", "BUILD_SCM_REVISION def456\nBUILD_TIMESTAMP 1700000000\n"),
+		},
+		InvocationID:     "5b2f8c1e",
+		Parameters:       map[string]string{"target": "//app:image"},
+		StatementOutput:  filepath.Join(dir, "statement.json"),
+		ManifestOutput:   filepath.Join(dir, "attestation.json"),
+		ConfigOutput:     filepath.Join(dir, "attestation_config.json"),
+		DescriptorOutput: filepath.Join(dir, "attestation_descriptor.json"),
+	}
+	if err := NewRunner(cfg).Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	subjectDigest := sha256Digest([]byte(testSubject))
+	s := readStatement(t, cfg.StatementOutput)
+	wantSubject := []resourceDescriptor{{
+		Name:   "registry.example.com/app",
+		Digest: map[string]string{"sha256": strings.TrimPrefix(subjectDigest, "sha256:")},
+	}}
+	if !reflect.DeepEqual(s.Subject, wantSubject) {
+		t.Errorf("statement subject = %+v, want %+v", s.Subject, wantSubject)
+	}
+	if s.Type != StatementType || s.PredicateType != PredicateTypeSLSAProvenance {
+		t.Errorf("statement types = %q, %q", s.Type, s.PredicateType)
+	}
+	// STABLE_GIT_COMMIT takes precedence over BUILD_SCM_REVISION
+	wantSource := "git+https://github.com/example/app.git@abc123"
+	wantDependencies := []resourceDescriptor{{URI: wantSource, Digest: map[string]string{"gitCommit": "abc123"}}}
+	if !reflect.DeepEqual(s.Predicate.BuildDefinition.ResolvedDependencies, wantDependencies) {
+		t.Errorf("resolved dependencies = %+v, want %+v", s.Predicate.BuildDefinition.ResolvedDependencies, wantDependencies)
+	}
+	wantParameters := map[string]string{"target": "//app:image", "source": wantSource}
+	if !reflect.DeepEqual(s.Predicate.BuildDefinition.ExternalParameters, wantParameters) {
+		t.Errorf("external parameters = %v, want %v", s.Predicate.BuildDefinition.ExternalParameters, wantParameters)
+	}
+	wantMetadata := &buildMetadata{InvocationID: "5b2f8c1e", StartedOn: "2023-11-14T22:13:20Z"}
+	if !reflect.DeepEqual(s.Predicate.RunDetails.Metadata, wantMetadata) {
+		t.Errorf("run metadata = %+v, want %+v", s.Predicate.RunDetails.Metadata, wantMetadata)
+	}
+
+	manifestRaw, err := os.ReadFile(cfg.ManifestOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest specv1.Manifest
+	if err := json.Unmarshal(manifestRaw, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	statementRaw, err := os.ReadFile(cfg.StatementOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ArtifactType != MediaTypeInToto {
+		t.Errorf("manifest artifactType = %q, want %q", manifest.ArtifactType, MediaTypeInToto)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest.String() != subjectDigest || manifest.Subject.MediaType != specv1.MediaTypeImageManifest {
+		t.Errorf("manifest subject = %+v, want the digest %s of the image manifest", manifest.Subject, subjectDigest)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].Digest.String() != sha256Digest(statementRaw) ||
+		manifest.Layers[0].Annotations[AnnotationPredicateType] != PredicateTypeSLSAProvenance {
+		t.Errorf("manifest layers = %+v, want the statement with its predicate type", manifest.Layers)
+	}
+	if config, _ := os.ReadFile(cfg.ConfigOutput); string(config) != "{}" {
+		t.Errorf("config = %q, want {}", config)
+	}
+	descriptorRaw, err := os.ReadFile(cfg.DescriptorOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(descriptorRaw), sha256Digest(manifestRaw)) {
+		t.Errorf("descriptor = %s, want the digest %s of the manifest", descriptorRaw, sha256Digest(manifestRaw))
+	}
+}
+
+func TestRunConfigurationFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Subject:           writeFile(t, dir, "manifest.json", testSubject),
+		BuilderID:         DefaultBuilderID,
+		SourceRevision:    "from-flag",
+		ConfigurationFile: writeFile(t, dir, "configuration.json", `{"builder_id":"https://ci.example.com","source_revision":"from-file","subject_name":""}`),
+		StatementOutput:   filepath.Join(dir, "statement.json"),
+	}
+	if err := NewRunner(cfg).Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	s := readStatement(t, cfg.StatementOutput)
+	if s.Predicate.RunDetails.Builder.ID != "https://ci.example.com" {
+		t.Errorf("builder id = %q, want the value of the configuration file", s.Predicate.RunDetails.Builder.ID)
+	}
+	// without a repository, the revision is a dependency without uri
+	want := []resourceDescriptor{{Digest: map[string]string{"gitCommit": "from-file"}}}
+	if !reflect.DeepEqual(s.Predicate.BuildDefinition.ResolvedDependencies, want) {
+		t.Errorf("resolved dependencies = %+v, want %+v", s.Predicate.BuildDefinition.ResolvedDependencies, want)
+	}
+	if s.Predicate.RunDetails.Metadata != nil {
+		t.Errorf("run metadata = %+v, want none without invocation id and timestamp", s.Predicate.RunDetails.Metadata)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "attestation.json")); !os.IsNotExist(err) {
+		t.Errorf("attestation manifest was written without --manifest")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := t.TempDir()
+	subject := writeFile(t, dir, "manifest.json", testSubject)
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name:    "empty builder id",
+			cfg:     Config{Subject: subject},
+			wantErr: "the builder id must not be empty",
+		},
+		{
+			name:    "missing subject",
+			cfg:     Config{Subject: filepath.Join(dir, "missing.json"), BuilderID: DefaultBuilderID},
+			wantErr: "reading subject",
+		},
+		{
+			name:    "invalid subject",
+			cfg:     Config{Subject: writeFile(t, dir, "invalid.json", "not json"), BuilderID: DefaultBuilderID},
+			wantErr: "decoding subject",
+		},
+		{
+			name:    "missing stamp file",
+			cfg:     Config{Subject: subject, BuilderID: DefaultBuilderID, StampFiles: []string{filepath.Join(dir, "missing.txt")}},
+			wantErr: "opening stamp file",
+		},
+		{
+			name:    "configuration value is not a string",
+			cfg:     Config{Subject: subject, BuilderID: DefaultBuilderID, ConfigurationFile: writeFile(t, dir, "configuration.json", `{"invocation_id":1}`)},
+			wantErr: `configuration field "invocation_id" is not a string`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.StatementOutput = filepath.Join(t.TempDir(), "statement.json")
+			err := NewRunner(tt.cfg).Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParametersFlag(t *testing.T) {
+	p := parametersFlag{}
+	if err := p.Set("target=//app:image=x"); err != nil || p["target"] != "//app:image=x" {
+		t.Errorf("Set() = %v, %v, want the value after the first =", p, err)
+	}
+	for _, value := range []string{"target", "=value"} {
+		if err := p.Set(value); err == nil {
+			t.Errorf("Set(%q) error = nil, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package basediff
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/imagediff"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+func BaseDiffProcess(_ context.Context, args []string) {
+	var oldManifests, oldConfigs, newManifests, newConfigs stringSliceFlag
+	var layers layerMappingFlag
+	var outputPath string
+
+	flagSet := flag.NewFlagSet("base-diff", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Writes a Markdown report of the differences between an old and a new version of a base image.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img base-diff [OPTIONS]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img base-diff --old-manifest old/manifest.json --old-config old/config.json --new-manifest new/manifest.json --new-config new/config.json --output report.md",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+
+	flagSet.Var(&oldManifests, "old-manifest", "Manifest of the old image (can be specified multiple times, once per platform)")
+	flagSet.Var(&oldConfigs, "old-config", "Config of the old image (can be specified multiple times, in the same order as --old-manifest)")
+	flagSet.Var(&newManifests, "new-manifest", "Manifest of the new image (can be specified multiple times, once per platform)")
+	flagSet.Var(&newConfigs, "new-config", "Config of the new image (can be specified multiple times, in the same order as --new-manifest)")
+	flagSet.Var(&layers, "layer", "Layer mapping in format metadata=blob (can be specified multiple times). Used to compare installed packages.")
+	flagSet.StringVar(&outputPath, "output", "", "Output file path for the Markdown report (required)")
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if outputPath == "" {
+		slog.Error("--output is required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if len(oldManifests) == 0 || len(newManifests) == 0 {
+		slog.Error("--old-manifest and --new-manifest are required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if len(oldManifests) != len(oldConfigs) || len(newManifests) != len(newConfigs) {
+		slog.Error("Each manifest needs exactly one config")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(oldManifests, oldConfigs, newManifests, newConfigs, layers, outputPath); err != nil {
+		logging.Fatal("Comparing base images", logging.ErrKey, err)
+	}
+}
+
+func run(oldManifests, oldConfigs, newManifests, newConfigs []string, layers []layerMapping, outputPath string) error {
+	layerPaths, err := layerPathsByDigest(layers)
+	if err != nil {
+		return err
+	}
+	oldImages, err := loadImages(oldManifests, oldConfigs, layerPaths)
+	if err != nil {
+		return fmt.Errorf("loading old image: %w", err)
+	}
+	newImages, err := loadImages(newManifests, newConfigs, layerPaths)
+	if err != nil {
+		return fmt.Errorf("loading new image: %w", err)
+	}
+
+	var reports []imagediff.Report
+	for _, platform := range slices.Sorted(maps.Keys(newImages)) {
+		oldImage, ok := oldImages[platform]
+		if !ok {
+			slog.Warn("Platform is only present in the new image", "platform", platform)
+			continue
+		}
+		report, err := imagediff.Compare(oldImage, newImages[platform])
+		if err != nil {
+			return fmt.Errorf("comparing %s: %w", platform, err)
+		}
+		reports = append(reports, report)
+	}
+	for platform := range oldImages {
+		if _, ok := newImages[platform]; !ok {
+			slog.Warn("Platform is only present in the old image", "platform", platform)
+		}
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	defer out.Close()
+	return imagediff.WriteMarkdown(out, reports)
+}
+
+func loadImages(manifests, configs []string, layerPaths map[string]string) (map[string]imagediff.Image, error) {
+	images := make(map[string]imagediff.Image, len(manifests))
+	for i := range manifests {
+		img, err := imagediff.LoadImage(manifests[i], configs[i], layerPaths)
+		if err != nil {
+			return nil, err
+		}
+		images[img.Platform()] = img
+	}
+	return images, nil
+}
+
+func layerPathsByDigest(layers []layerMapping) (map[string]string, error) {
+	layerPaths := make(map[string]string, len(layers))
+	for _, layer := range layers {
+		metadataData, err := os.ReadFile(layer.metadata)
+		if err != nil {
+			return nil, fmt.Errorf("reading layer metadata %s: %w", layer.metadata, err)
+		}
+		var metadata struct {
+			Digest string `json:"digest"`
+		}
+		if err := json.Unmarshal(metadataData, &metadata); err != nil {
+			return nil, fmt.Errorf("unmarshaling layer metadata %s: %w", layer.metadata, err)
+		}
+		if !strings.HasPrefix(metadata.Digest, "sha256:") {
+			return nil, fmt.Errorf("layer metadata %s has invalid digest %q", layer.metadata, metadata.Digest)
+		}
+		layerPaths[metadata.Digest] = layer.blob
+	}
+	return layerPaths, nil
+}
@@ -4,31 +4,52 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/credential"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/protohelper"
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	bes_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/build_event_service"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/bes"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/bes/syncer"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/health"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/metrics"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/serverauth"
 )
 
 const usage = `Usage: bes [ARGS...]`
 
 func Run(ctx context.Context, args []string) {
+	logging.Init(logging.WithTimestamps())
+
 	var address string
 	var port int
 	var commitMode string
 	var casEndpoint string
 	var credentialHelperPath string
+	var metricsAddress string
+	var workers int
+	var registryLimits registryConcurrency
+	var bandwidthLimit byteRate
+	var stateFile string
+	var stateTTL time.Duration
+	var stateMaxEntries int
+	var stateReconcile bool
+	var healthAddress string
+	var healthInterval time.Duration
+	var healthGrace time.Duration
+	var healthRepositories stringSliceFlag
 
 	flagSet := flag.NewFlagSet("bes", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -39,6 +60,12 @@ func Run(ctx context.Context, args []string) {
 			"bes --cas-endpoint grpcs://remote.buildbuddy.io",
 			"bes --address 0.0.0.0 --port 9090 --cas-endpoint grpcs://remote.buildbuddy.io",
 			"bes --commit-mode per-stream --credential-helper tweag-credential-helper --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --metrics-address localhost:9091 --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --health-address 0.0.0.0:8081 --health-check-repository ghcr.io/my-org/my-image --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --workers 16 --registry-concurrency 8 --registry-concurrency harbor.example.com=2 --upload-bandwidth-limit 50MiB --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --state-file /var/lib/img-bes/state.jsonl --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --address 0.0.0.0 --tls-cert server.crt --tls-key server.key --client-ca clients.crt --auth-token-file tokens.txt --cas-endpoint grpcs://remote.buildbuddy.io",
+			"bes --state-file /var/lib/img-bes/state.jsonl --state-ttl 72h --state-max-entries 200000 --state-reconcile=false --cas-endpoint grpcs://remote.buildbuddy.io",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -51,15 +78,77 @@ func Run(ctx context.Context, args []string) {
 	flagSet.StringVar(&commitMode, "commit-mode", "background", "Commit mode: 'background' or 'per-stream'")
 	flagSet.StringVar(&casEndpoint, "cas-endpoint", "", "CAS gRPC endpoint (required)")
 	flagSet.StringVar(&credentialHelperPath, "credential-helper", "", "Path to credential helper binary (optional, defaults to no helper)")
+	flagSet.StringVar(&metricsAddress, "metrics-address", "", "Address (host:port) to serve Prometheus metrics on at /metrics (optional, disabled if empty)")
+	flagSet.StringVar(&healthAddress, "health-address", "", "Address (host:port) to serve the /healthz and /readyz probes on (optional, disabled if empty; may equal --metrics-address). The gRPC health service is always served on the BES port")
+	flagSet.DurationVar(&healthInterval, "health-check-interval", 30*time.Second, "Time between two runs of the health checks")
+	flagSet.DurationVar(&healthGrace, "health-failure-grace", 5*time.Minute, "Time a health check may fail before /healthz fails, so that the pod is restarted")
+	flagSet.Var(&healthRepositories, "health-check-repository", "Repository (like ghcr.io/my-org/my-image) whose push credentials are checked for readiness. Can be specified multiple times")
+	flagSet.IntVar(&workers, "workers", 4, "Number of concurrent blob upload workers (env: IMG_SYNCER_WORKERS)")
+	flagSet.Var(&registryLimits, "registry-concurrency", `Maximum number of concurrent blob uploads per registry. Either a number (default for all registries) or "registry=number" (can be specified multiple times, 0 means unlimited, env: IMG_SYNCER_REGISTRY_CONCURRENCY)`)
+	flagSet.Var(&bandwidthLimit, "upload-bandwidth-limit", `Combined upload bandwidth cap in bytes per second, with optional unit (e.g. "50MiB", 0 means unlimited, env: IMG_SYNCER_UPLOAD_BANDWIDTH_LIMIT)`)
+	flagSet.StringVar(&stateFile, "state-file", "", "File that remembers the blobs, manifests, and tags known to exist in registries across restarts, so that they are not uploaded again (optional, in memory if empty, env: IMG_SYNCER_STATE_FILE)")
+	flagSet.DurationVar(&stateTTL, "state-ttl", 7*24*time.Hour, "Time after which remembered blobs, manifests, and tags are checked again (0 means forever, env: IMG_SYNCER_STATE_TTL)")
+	flagSet.IntVar(&stateMaxEntries, "state-max-entries", 1000000, "Maximum number of remembered blobs, manifests, and tags; the oldest are forgotten first (0 means unlimited, env: IMG_SYNCER_STATE_MAX_ENTRIES)")
+	flagSet.BoolVar(&stateReconcile, "state-reconcile", true, "Check the state file against the registries at startup and forget blobs, manifests, and tags that no longer exist (env: IMG_SYNCER_STATE_RECONCILE)")
+
+	var tlsOptions reg.TLSOptions
+	tlsOptions.RegisterFlags(flagSet)
+	var serverOptions serverauth.Options
+	serverOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	// Environment variables provide defaults that can be overridden by flags.
+	if value, ok := os.LookupEnv("IMG_SYNCER_WORKERS"); ok {
+		if err := flagSet.Set("workers", value); err != nil {
+			logging.Fatal("Invalid IMG_SYNCER_WORKERS", logging.ErrKey, err)
+		}
+	}
+	if value, ok := os.LookupEnv("IMG_SYNCER_REGISTRY_CONCURRENCY"); ok {
+		if err := registryLimits.Set(value); err != nil {
+			logging.Fatal("Invalid IMG_SYNCER_REGISTRY_CONCURRENCY", logging.ErrKey, err)
+		}
+	}
+	if value, ok := os.LookupEnv("IMG_SYNCER_UPLOAD_BANDWIDTH_LIMIT"); ok {
+		if err := bandwidthLimit.Set(value); err != nil {
+			logging.Fatal("Invalid IMG_SYNCER_UPLOAD_BANDWIDTH_LIMIT", logging.ErrKey, err)
+		}
+	}
+	if value, ok := os.LookupEnv("IMG_SYNCER_STATE_FILE"); ok {
+		stateFile = value
+	}
+	for env, flagName := range map[string]string{
+		"IMG_SYNCER_STATE_TTL":         "state-ttl",
+		"IMG_SYNCER_STATE_MAX_ENTRIES": "state-max-entries",
+		"IMG_SYNCER_STATE_RECONCILE":   "state-reconcile",
+	} {
+		if value, ok := os.LookupEnv(env); ok {
+			if err := flagSet.Set(flagName, value); err != nil {
+				logging.Fatal("Invalid environment variable", "name", env, logging.ErrKey, err)
+			}
+		}
+	}
 
 	if err := flagSet.Parse(args[1:]); err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		slog.Error("Invalid arguments", logging.ErrKey, err)
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+
+	serverTLS, err := serverOptions.TLSConfig()
+	if err != nil {
+		logging.Fatal("Invalid server TLS options", logging.ErrKey, err)
+	}
+	authorizer, err := serverOptions.Authorizer()
+	if err != nil {
+		logging.Fatal("Invalid server authorization options", logging.ErrKey, err)
+	}
+
 	if casEndpoint == "" {
-		fmt.Fprintln(os.Stderr, "Error: --cas-endpoint is required")
+		slog.Error("--cas-endpoint is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
@@ -71,7 +160,7 @@ func Run(ctx context.Context, args []string) {
 	case "per-stream":
 		mode = bes.CommitModePerStream
 	default:
-		fmt.Fprintf(os.Stderr, "Error: invalid commit mode '%s', must be 'background' or 'per-stream'\n", commitMode)
+		slog.Error("Invalid commit mode, must be 'background' or 'per-stream'", "commit-mode", commitMode)
 		flagSet.Usage()
 		os.Exit(1)
 	}
@@ -79,44 +168,107 @@ func Run(ctx context.Context, args []string) {
 	var credentialHelper credential.Helper
 	if len(credentialHelperPath) > 0 {
 		credentialHelper = credential.New(credentialHelperPath)
-		log.Printf("Using credential helper: %s", credentialHelperPath)
+		slog.Info("Using credential helper", "path", credentialHelperPath)
 	} else {
 		credentialHelper = credential.NopHelper()
-		log.Println("No credential helper configured")
+		slog.Info("No credential helper configured")
 	}
 
 	grpcClientConn, err := protohelper.Client(casEndpoint, credentialHelper)
 	if err != nil {
-		log.Fatalf("Failed to create gRPC client connection to CAS: %v", err)
+		logging.Fatal("Failed to create gRPC client connection to CAS", logging.ErrKey, err)
 	}
 	defer grpcClientConn.Close()
 
 	casClient, err := cas.New(grpcClientConn, cas.WithLearnCapabilities(true))
 	if err != nil {
-		log.Fatalf("Failed to create CAS client: %v", err)
+		logging.Fatal("Failed to create CAS client", logging.ErrKey, err)
+	}
+
+	store, err := syncer.OpenStore(stateFile, syncer.StoreOptions{
+		TTL:        stateTTL,
+		MaxEntries: stateMaxEntries,
+	})
+	if err != nil {
+		logging.Fatal("Failed to open state file", logging.ErrKey, err)
+	}
+
+	s := syncer.New(
+		casClient,
+		syncer.WithWorkers(workers),
+		syncer.WithDefaultRegistryConcurrency(registryLimits.defaultLimit),
+		syncer.WithRegistryConcurrency(registryLimits.overrides),
+		syncer.WithBandwidthLimit(int64(bandwidthLimit)),
+		syncer.WithStore(store),
+	)
+	if stateFile != "" && stateReconcile {
+		go func() {
+			if err := s.Reconcile(ctx); err != nil {
+				slog.Error("Failed to reconcile state with registries", logging.ErrKey, err)
+			}
+		}()
+	}
+
+	checker := health.New(health.WithInterval(healthInterval), health.WithLivenessGrace(healthGrace))
+	checker.Add("cas", health.CASCheck(casClient))
+	for _, repository := range healthRepositories {
+		check, err := health.RegistryPushCheck(repository)
+		if err != nil {
+			logging.Fatal("Invalid --health-check-repository", logging.ErrKey, err)
+		}
+		checker.Add("registry "+repository, check)
 	}
+	go checker.Run(ctx)
 
-	s := syncer.New(casClient)
+	// Metrics and health probes share a server if they are served on the same address.
+	httpMuxes := make(map[string]*http.ServeMux)
+	muxFor := func(address string) *http.ServeMux {
+		if _, ok := httpMuxes[address]; !ok {
+			httpMuxes[address] = http.NewServeMux()
+		}
+		return httpMuxes[address]
+	}
+	if metricsAddress != "" {
+		muxFor(metricsAddress).Handle("/metrics", metrics.Default.Handler())
+		slog.Info("Serving metrics", "url", "http://"+metricsAddress+"/metrics")
+	}
+	if healthAddress != "" {
+		checker.Register(muxFor(healthAddress))
+		slog.Info("Serving health probes", "liveness", "http://"+healthAddress+"/healthz", "readiness", "http://"+healthAddress+"/readyz")
+	}
+	// The first server that fails stops the process.
+	serveErrs := make(chan error, len(httpMuxes)+1)
+	for httpAddress, mux := range httpMuxes {
+		server := &http.Server{
+			Addr:              httpAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		go func() {
+			serveErrs <- fmt.Errorf("serving HTTP on %s: %w", httpAddress, server.ListenAndServe())
+		}()
+	}
 
 	besService := bes.New(s, mode)
 
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", address, port))
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to listen", logging.ErrKey, err)
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(serverauth.GRPCServerOptions(serverTLS, authorizer)...)
 	bes_proto.RegisterPublishBuildEventServer(grpcServer, besService)
+	healthpb.RegisterHealthServer(grpcServer, checker.GRPCServer())
 
 	actualPort := listener.Addr().(*net.TCPAddr).Port
-	log.Printf("BES gRPC server listening on %s:%d (commit-mode: %s)", address, actualPort, commitMode)
+	slog.Info("BES gRPC server listening", "address", fmt.Sprintf("%s:%d", address, actualPort), "commit-mode", commitMode, "tls", serverTLS != nil, "client-certificates", serverOptions.ClientCA != "", "tokens", serverOptions.TokenFile != "")
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		<-sigCh
-		log.Println("Received shutdown signal, gracefully stopping...")
+		slog.Info("Received shutdown signal, gracefully stopping...")
 
 		shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 		defer cancel()
@@ -124,17 +276,24 @@ func Run(ctx context.Context, args []string) {
 		grpcServer.GracefulStop()
 
 		if err := besService.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Shutdown completed with errors: %v", err)
+			slog.Warn("Shutdown completed with errors", logging.ErrKey, err)
 		}
 
 		s.Shutdown()
 
-		log.Println("Server shutdown complete")
+		slog.Info("Server shutdown complete")
 		os.Exit(0)
 	}()
 
-	if err := grpcServer.Serve(listener); err != nil {
-		log.Fatalf("Failed to serve gRPC server: %v", err)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			serveErrs <- fmt.Errorf("serving gRPC: %w", err)
+			return
+		}
+		serveErrs <- nil
+	}()
+	if err := <-serveErrs; err != nil {
+		logging.Fatal("Failed to serve", logging.ErrKey, err)
 	}
 }
 
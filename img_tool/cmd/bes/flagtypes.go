@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// registryConcurrency holds the per-registry upload concurrency limits.
+// A bare number sets the default limit for all registries,
+// while "registry=number" overrides the limit for a single registry.
+type registryConcurrency struct {
+	defaultLimit int
+	overrides    map[string]int
+}
+
+func (r *registryConcurrency) String() string {
+	if r == nil {
+		return ""
+	}
+	parts := []string{strconv.Itoa(r.defaultLimit)}
+	for _, registry := range slices.Sorted(maps.Keys(r.overrides)) {
+		parts = append(parts, fmt.Sprintf("%s=%d", registry, r.overrides[registry]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *registryConcurrency) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		registry, rawLimit, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			rawLimit = registry
+		}
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid registry concurrency %q: expected a non-negative number or registry=number", part)
+		}
+		if !isOverride {
+			r.defaultLimit = limit
+			continue
+		}
+		if r.overrides == nil {
+			r.overrides = make(map[string]int)
+		}
+		r.overrides[registry] = limit
+	}
+	return nil
+}
+
+// byteRate is a bandwidth in bytes per second.
+// It accepts plain numbers as well as numbers with a unit suffix (e.g. "512KiB", "10MB", "1GiB").
+type byteRate int64
+
+var byteRateUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+func (b *byteRate) String() string {
+	if b == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteRate) Set(value string) error {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	multiplier := int64(1)
+	for _, unit := range byteRateUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("invalid bandwidth %q: expected a non-negative number of bytes per second with optional unit (e.g. 50MiB)", value)
+	}
+	*b = byteRate(number * float64(multiplier))
+	return nil
+}
+
+// stringSliceFlag is a custom flag type for string slices
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
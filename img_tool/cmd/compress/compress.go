@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"runtime"
 	"slices"
@@ -14,21 +15,44 @@ import (
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/compress"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/diagnostics"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
-var (
-	layerName          string
-	sourceFormat       string
-	format             string
-	estargzFlag        bool
-	metadataOutputFile string
-)
+// Config holds the options of a compress invocation.
+type Config struct {
+	// Name of the layer. Defaults to the digest.
+	Name string
+	// SourceFormat is the compression of the input. It is detected if empty.
+	SourceFormat string
+	// Format is the format of the output layer ("tar", "gzip" or "zstd").
+	Format           string
+	Estargz          bool
+	CompressorJobs   string
+	CompressionLevel int
+	// ExternalCompressor is the optional path of a pigz or zstd binary.
+	ExternalCompressor string
+	Annotations        map[string]string
+	// Input and Output are the paths of the source and the (re-)compressed layer.
+	Input  string
+	Output string
+	// MetadataOutput optionally receives the layer metadata.
+	MetadataOutput string
+}
+
+// Runner (re-)compresses a layer.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
 
 func CompressProcess(ctx context.Context, args []string) {
-	annotations := make(annotationsFlag)
-	var compressorJobsFlag string
-	var compressionLevelFlag int
+	cfg := Config{Annotations: make(annotationsFlag)}
 	flagSet := flag.NewFlagSet("compress", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "(Re-)compresses a layer to the chosen format.\n\n")
@@ -44,14 +68,16 @@ func CompressProcess(ctx context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.StringVar(&layerName, "name", "", `Optional name of the layer. Defaults to digest.`)
-	flagSet.StringVar(&sourceFormat, "source-format", "", `The format of the source layer. Can be "tar" or "gzip".`)
-	flagSet.StringVar(&format, "format", "", `The format of the output layer. Can be "tar" or "gzip".`)
-	flagSet.BoolVar(&estargzFlag, "estargz", false, `Use estargz format for compression. This creates seekable gzip streams optimized for lazy pulling.`)
-	flagSet.StringVar(&compressorJobsFlag, "compressor-jobs", "1", `Number of compressor jobs. 1 uses single-threaded stdlib gzip. n>1 uses pgzip. "nproc" uses NumCPU.`)
-	flagSet.IntVar(&compressionLevelFlag, "compression-level", -1, `Compression level. For gzip: 0-9. If unset, use library default.`)
-	flagSet.Var(&annotations, "annotation", `Add an annotation as key=value. Can be specified multiple times.`)
-	flagSet.StringVar(&metadataOutputFile, "metadata", "", `Write the metadata to the specified file. The metadata is a JSON file containing info needed to use the layer as part of an OCI image.`)
+	flagSet.StringVar(&cfg.Name, "name", "", `Optional name of the layer. Defaults to digest.`)
+	flagSet.StringVar(&cfg.SourceFormat, "source-format", "", `The format of the source layer. Can be "tar" or "gzip".`)
+	flagSet.StringVar(&cfg.Format, "format", "", `The format of the output layer. Can be "tar" or "gzip".`)
+	flagSet.BoolVar(&cfg.Estargz, "estargz", false, `Use estargz format for compression. This creates seekable gzip streams optimized for lazy pulling.`)
+	flagSet.StringVar(&cfg.CompressorJobs, "compressor-jobs", "1", `Number of parallel gzip compressor jobs. The output is the same for any number of jobs. "nproc" uses NumCPU.`)
+	flagSet.IntVar(&cfg.CompressionLevel, "compression-level", -1, `Compression level. For gzip: 0-9. If unset, use library default.`)
+	flagSet.StringVar(&cfg.ExternalCompressor, "external-compressor", "", `Path of a pigz (for gzip) or zstd binary that compresses the layer with multiple threads. Estargz layers use the built-in compressors; a missing binary is an error.`)
+	flagSet.Var(annotationsFlag(cfg.Annotations), "annotation", `Add an annotation as key=value. Can be specified multiple times.`)
+	flagSet.StringVar(&cfg.MetadataOutput, "metadata", "", `Write the metadata to the specified file. The metadata is a JSON file containing info needed to use the layer as part of an OCI image.`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -62,74 +88,81 @@ func CompressProcess(ctx context.Context, args []string) {
 		flagSet.Usage()
 		os.Exit(1)
 	}
-
-	layerFile := flagSet.Arg(0)
-	outputFile := flagSet.Arg(1)
-
-	inputHandle, err := os.Open(layerFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening input layer: %v\n", err)
+	if cfg.Format == "" {
+		fmt.Println("--format flag is required")
+		flagSet.Usage()
 		os.Exit(1)
 	}
-	defer inputHandle.Close()
 
-	var reader io.Reader
-	var openErr error
-	if sourceFormat == "" {
-		reader, openErr = fileopener.CompressionReader(inputHandle)
-	} else {
-		reader, openErr = fileopener.CompressionReaderWithFormat(inputHandle, api.CompressionAlgorithm(sourceFormat))
-	}
-	if openErr != nil {
-		fmt.Fprintf(os.Stderr, "Error opening output layer: %v\n", openErr)
-		os.Exit(1)
+	cfg.Input = flagSet.Arg(0)
+	cfg.Output = flagSet.Arg(1)
+	diagnostics.Set("source-format", cfg.SourceFormat)
+	diagnostics.Set("format", cfg.Format)
+	diagnostics.Set("estargz", strconv.FormatBool(cfg.Estargz))
+	diagnostics.Set("compressor-jobs", cfg.CompressorJobs)
+	diagnostics.Set("compression-level", strconv.Itoa(cfg.CompressionLevel))
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		slog.Error("Compressing layer", logging.ErrKey, err)
+		diagnostics.Exit(1)
 	}
+}
 
+// Run (re-)compresses the input layer and writes the layer metadata.
+func (r *Runner) Run(_ context.Context) error {
 	var outputFormat api.LayerFormat
-	switch format {
+	switch r.cfg.Format {
 	case "tar", "none", "uncompressed":
 		outputFormat = api.TarLayer
 	case "gzip":
 		outputFormat = api.TarGzipLayer
 	case "zstd":
 		outputFormat = api.TarZstdLayer
-	case "":
-		fmt.Println("--format flag is required")
-		flagSet.Usage()
-		os.Exit(1)
 	default:
-		fmt.Fprintf(os.Stderr, "Unsupported output format: %s\n", format)
-		os.Exit(1)
+		return fmt.Errorf("unsupported output format: %q", r.cfg.Format)
 	}
 
-	outputHandle, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	inputHandle, err := os.Open(r.cfg.Input)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("opening input layer: %w", err)
+	}
+	defer inputHandle.Close()
+
+	var reader io.Reader
+	if r.cfg.SourceFormat == "" {
+		reader, err = fileopener.CompressionReader(inputHandle)
+	} else {
+		reader, err = fileopener.CompressionReaderWithFormat(inputHandle, api.CompressionAlgorithm(r.cfg.SourceFormat))
+	}
+	if err != nil {
+		return fmt.Errorf("opening input layer: %w", err)
+	}
+
+	outputHandle, err := os.OpenFile(r.cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening output file: %w", err)
 	}
 	defer outputHandle.Close()
 
-	compressorState, mediaType, err := recompress(reader, outputHandle, outputFormat, estargzFlag, compressorJobsFlag, compressionLevelFlag)
+	compressorState, mediaType, err := recompress(reader, outputHandle, outputFormat, r.cfg.Estargz, r.cfg.CompressorJobs, r.cfg.CompressionLevel, r.cfg.ExternalCompressor)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Recompressing layer: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("recompressing layer: %w", err)
 	}
 
-	if len(metadataOutputFile) > 0 {
-		metadataOutputHandle, err := os.OpenFile(metadataOutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if len(r.cfg.MetadataOutput) > 0 {
+		metadataOutputHandle, err := os.OpenFile(r.cfg.MetadataOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening metadata output file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("opening metadata output file: %w", err)
 		}
 		defer metadataOutputHandle.Close()
-		if err := writeMetadata(compressorState, annotations, mediaType, metadataOutputHandle); err != nil {
-			fmt.Fprintf(os.Stderr, "Writing metadata: %v\n", err)
-			os.Exit(1)
+		if err := writeMetadata(r.cfg.Name, compressorState, r.cfg.Annotations, mediaType, metadataOutputHandle); err != nil {
+			return fmt.Errorf("writing metadata: %w", err)
 		}
 	}
+	return nil
 }
 
-func recompress(input io.Reader, output io.Writer, format api.LayerFormat, estargz bool, compressorJobsFlag string, compressionLevelFlag int) (compressorState api.AppenderState, mediaType string, err error) {
+func recompress(input io.Reader, output io.Writer, format api.LayerFormat, estargz bool, compressorJobsFlag string, compressionLevelFlag int, externalCompressor string) (compressorState api.AppenderState, mediaType string, err error) {
 	var CompressionAlgorithm api.CompressionAlgorithm
 	switch format {
 	case api.TarLayer:
@@ -153,23 +186,24 @@ func recompress(input io.Reader, output io.Writer, format api.LayerFormat, estar
 			opts = append(opts, compress.CompressorJobs(n))
 		}
 	}
+	if len(externalCompressor) > 0 {
+		opts = append(opts, compress.ExternalCompressor(externalCompressor))
+	}
 	compressor, err := compress.TarAppenderFactory(string(api.SHA256), string(CompressionAlgorithm), estargz, output, append(opts, compress.ContentType("tar"))...)
 	if err != nil {
 		return compressorState, "", fmt.Errorf("creating compressor: %w", err)
 	}
-	defer func() {
-		var compressorCloseErr error
-		compressorState, compressorCloseErr = compressor.Finalize()
-		if compressorCloseErr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing compressor: %v\n", compressorCloseErr)
-			os.Exit(1)
-		}
-	}()
-
-	return compressorState, mediaType, compressor.AppendTar(input)
+	if err := compressor.AppendTar(input); err != nil {
+		return compressorState, "", err
+	}
+	compressorState, err = compressor.Finalize()
+	if err != nil {
+		return compressorState, "", fmt.Errorf("closing compressor: %w", err)
+	}
+	return compressorState, mediaType, nil
 }
 
-func writeMetadata(compressorState api.AppenderState, annotations map[string]string, mediaType string, outputFile io.Writer) error {
+func writeMetadata(layerName string, compressorState api.AppenderState, annotations map[string]string, mediaType string, outputFile io.Writer) error {
 	if len(layerName) == 0 {
 		layerName = fmt.Sprintf("sha256:%x", compressorState.OuterHash)
 	}
@@ -0,0 +1,28 @@
+package contentmanifest
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: img content-manifest [COMMAND] [ARGS...]
+
+Commands:
+  merge  merges multiple content manifests into a single content manifest for deduplication`
+
+func ContentManifestProcess(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	command := args[0]
+	switch command {
+	case "merge":
+		MergeProcess(ctx, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+}
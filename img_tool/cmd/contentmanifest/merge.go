@@ -0,0 +1,69 @@
+package contentmanifest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/contentmanifest"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+type collections []string
+
+func (c *collections) String() string {
+	return fmt.Sprintf("%v", *c)
+}
+
+func (c *collections) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+func MergeProcess(ctx context.Context, args []string) {
+	var outputFlag string
+	var collectionFlags collections
+	flagSet := flag.NewFlagSet("content-manifest merge", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Merges multiple content manifests into a single content manifest.\n")
+		fmt.Fprintf(flagSet.Output(), "The blobs, nodes, and trees of all inputs are deduplicated, so the merged manifest can be computed once (for example for all layers of a base image)\n")
+		fmt.Fprintf(flagSet.Output(), "and passed to \"img layer --deduplicate-collection\" instead of one --deduplicate flag per content manifest.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img content-manifest merge --output [output] [content_manifest...]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img content-manifest merge --output base.cm layer0.cm layer1.cm layer2.cm",
+			"img content-manifest merge --output all.cm --collection base.cm app.cm",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&outputFlag, "output", "", `Path of the merged content manifest (required).`)
+	flagSet.Var(&collectionFlags, "collection", `Path of a content manifest collection file to merge. The collection either lists the paths of content manifests (one per line) or is a merged content manifest. Can be specified multiple times.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if outputFlag == "" {
+		slog.Error("Missing --output flag")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	importer := contentmanifest.NewMultiImporter(flagSet.Args(), api.SHA256)
+	for _, collection := range collectionFlags {
+		if err := importer.AddCollection(collection); err != nil {
+			logging.Fatal("Reading content manifest collection", "collection", collection, logging.ErrKey, err)
+		}
+	}
+	if err := contentmanifest.Merge(outputFlag, importer); err != nil {
+		logging.Fatal("Merging content manifests", logging.ErrKey, err)
+	}
+}
@@ -5,17 +5,35 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
-var (
-	pushStrategy string
-	loadStrategy string
-)
+// MergeConfig holds the options of a deploy-merge invocation.
+type MergeConfig struct {
+	// Inputs are the paths of the deploy manifests to merge.
+	Inputs []string
+	// Output is the path of the merged deploy manifest.
+	Output       string
+	PushStrategy string
+	LoadStrategy string
+}
+
+// MergeRunner merges multiple deploy manifests into a single one.
+type MergeRunner struct {
+	cfg MergeConfig
+}
+
+// NewMergeRunner returns a runner for the given config.
+func NewMergeRunner(cfg MergeConfig) *MergeRunner {
+	return &MergeRunner{cfg: cfg}
+}
 
 func DeployMergeProcess(ctx context.Context, args []string) {
+	var cfg MergeConfig
 	flagSet := flag.NewFlagSet("deploy-merge", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Merges multiple deploy manifests into a single unified deployment.\n\n")
@@ -30,8 +48,9 @@ func DeployMergeProcess(ctx context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.StringVar(&pushStrategy, "push-strategy", "lazy", `Push strategy to use for all push operations. One of "eager", "lazy", "cas_registry", or "bes".`)
-	flagSet.StringVar(&loadStrategy, "load-strategy", "lazy", `Load strategy to use for all load operations. One of "eager", "lazy".`)
+	flagSet.StringVar(&cfg.PushStrategy, "push-strategy", "lazy", `Push strategy to use for all push operations. One of "eager", "lazy", "cas_registry", or "bes".`)
+	flagSet.StringVar(&cfg.LoadStrategy, "load-strategy", "lazy", `Load strategy to use for all load operations. One of "eager", "lazy".`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -39,44 +58,44 @@ func DeployMergeProcess(ctx context.Context, args []string) {
 	}
 
 	if flagSet.NArg() < 2 {
-		fmt.Fprintln(os.Stderr, "Error: at least one input file and one output file are required")
+		slog.Error("At least one input file and one output file are required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	inputPaths := flagSet.Args()[:flagSet.NArg()-1]
-	outputPath := flagSet.Args()[flagSet.NArg()-1]
+	cfg.Inputs = flagSet.Args()[:flagSet.NArg()-1]
+	cfg.Output = flagSet.Args()[flagSet.NArg()-1]
 
 	// Validate strategies
-	switch pushStrategy {
+	switch cfg.PushStrategy {
 	case "eager", "lazy", "cas_registry", "bes":
 		// valid strategies
 	default:
-		fmt.Fprintf(os.Stderr, "Error: invalid push strategy %q\n", pushStrategy)
+		slog.Error("Invalid push strategy", "push_strategy", cfg.PushStrategy)
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	switch loadStrategy {
+	switch cfg.LoadStrategy {
 	case "eager", "lazy":
 		// valid strategies
 	default:
-		fmt.Fprintf(os.Stderr, "Error: invalid load strategy %q\n", loadStrategy)
+		slog.Error("Invalid load strategy", "load_strategy", cfg.LoadStrategy)
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	if err := MergeDeployManifests(ctx, inputPaths, outputPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error merging deploy manifests: %v\n", err)
-		os.Exit(1)
+	if err := NewMergeRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Merging deploy manifests", logging.ErrKey, err)
 	}
 }
 
-func MergeDeployManifests(ctx context.Context, inputPaths []string, outputPath string) error {
+// Run merges the input deploy manifests and writes the result to the output path.
+func (r *MergeRunner) Run(_ context.Context) error {
 	var allOperations []json.RawMessage
 
 	// Read and merge all input deploy manifests
-	for _, inputPath := range inputPaths {
+	for _, inputPath := range r.cfg.Inputs {
 		data, err := os.ReadFile(inputPath)
 		if err != nil {
 			return fmt.Errorf("reading input file %s: %w", inputPath, err)
@@ -95,8 +114,8 @@ func MergeDeployManifests(ctx context.Context, inputPaths []string, outputPath s
 	mergedManifest := api.DeployManifest{
 		Operations: allOperations,
 		Settings: api.DeploySettings{
-			PushStrategy: pushStrategy,
-			LoadStrategy: loadStrategy,
+			PushStrategy: r.cfg.PushStrategy,
+			LoadStrategy: r.cfg.LoadStrategy,
 		},
 	}
 
@@ -106,7 +125,7 @@ func MergeDeployManifests(ctx context.Context, inputPaths []string, outputPath s
 		return fmt.Errorf("marshalling merged deploy manifest: %w", err)
 	}
 
-	if err := os.WriteFile(outputPath, output, 0o644); err != nil {
+	if err := os.WriteFile(r.cfg.Output, output, 0o644); err != nil {
 		return fmt.Errorf("writing output file: %w", err)
 	}
 
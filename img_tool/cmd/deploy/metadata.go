@@ -7,30 +7,62 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
 
 	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/templating"
 )
 
-var (
-	command                 string
-	rootPath                string
-	rootKind                string
-	configurationPath       string
-	strategy                string
-	manifestPaths           []string
-	missingBlobsForManifest [][]string
-	originalRegistries      []string
-	originalRepository      string
-	orginalTag              string
-	originalDigest          string
-)
+// MetadataConfig holds the options of a deploy-metadata invocation.
+type MetadataConfig struct {
+	// Command is the kind of operation ("push" or "load").
+	Command string
+	// RootPath is the path of the root manifest to be deployed (manifest or index).
+	RootPath string
+	// RootKind is the kind of the root manifest ("manifest" or "index").
+	RootKind          string
+	ConfigurationPath string
+	Strategy          string
+	// ManifestPaths are the paths of the manifests of the image, by index.
+	ManifestPaths []string
+	// MissingBlobsForManifest lists the blobs of every manifest that are not available locally, by index.
+	MissingBlobsForManifest [][]string
+	OriginalRegistries      []string
+	OriginalRepository      string
+	OriginalTag             string
+	OriginalDigest          string
+	// DigestTagTemplate optionally adds a digest-derived tag to a push.
+	DigestTagTemplate string
+	// ReferenceOutput optionally receives the fully qualified reference of a push, with the digest as built.
+	ReferenceOutput string
+	// Output is the path of the deploy manifest.
+	Output string
+}
+
+// MetadataRunner writes metadata about a push/load operation.
+type MetadataRunner struct {
+	cfg MetadataConfig
+}
+
+// NewMetadataRunner returns a runner for the given config.
+func NewMetadataRunner(cfg MetadataConfig) *MetadataRunner {
+	return &MetadataRunner{cfg: cfg}
+}
+
+// tagPattern is the grammar of a valid tag as defined by the OCI distribution spec.
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
 
 func DeployMetadataProcess(ctx context.Context, args []string) {
+	var cfg MetadataConfig
 	flagSet := flag.NewFlagSet("deploy-metadata", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Writes metadata about a push/load operation.\n\n")
@@ -46,18 +78,20 @@ func DeployMetadataProcess(ctx context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.StringVar(&command, "command", "", `The kind of operation ("push" or "load")`)
-	flagSet.StringVar(&rootPath, "root-path", "", `Path to the root manifest to be deployed (manifest or index).`)
-	flagSet.StringVar(&rootKind, "root-kind", "", `Kind of the root manifest ("manifest" or "index").`)
-	flagSet.StringVar(&configurationPath, "configuration-file", "", `Path to the configuration file.`)
-	flagSet.StringVar(&strategy, "strategy", "eager", `Push strategy to use. One of "eager", "lazy", "cas_registry", or "bes".`)
+	flagSet.StringVar(&cfg.Command, "command", "", `The kind of operation ("push" or "load")`)
+	flagSet.StringVar(&cfg.RootPath, "root-path", "", `Path to the root manifest to be deployed (manifest or index).`)
+	flagSet.StringVar(&cfg.RootKind, "root-kind", "", `Kind of the root manifest ("manifest" or "index").`)
+	flagSet.StringVar(&cfg.ConfigurationPath, "configuration-file", "", `Path to the configuration file.`)
+	flagSet.StringVar(&cfg.Strategy, "strategy", "eager", `Push strategy to use. One of "eager", "lazy", "cas_registry", or "bes".`)
 	flagSet.Func("original-registry", `(Optional) original registry that the base of this image was pulled from. Can be specified multiple times.`, func(value string) error {
-		originalRegistries = append(originalRegistries, value)
+		cfg.OriginalRegistries = append(cfg.OriginalRegistries, value)
 		return nil
 	})
-	flagSet.StringVar(&originalRepository, "original-repository", "", `(Optional) original repository that the base of this image was pulled from.`)
-	flagSet.StringVar(&orginalTag, "original-tag", "", `(Optional) original tag that the base of this image was pulled from.`)
-	flagSet.StringVar(&originalDigest, "original-digest", "", `(Optional) original digest that the base of this image was pulled from.`)
+	flagSet.StringVar(&cfg.OriginalRepository, "original-repository", "", `(Optional) original repository that the base of this image was pulled from.`)
+	flagSet.StringVar(&cfg.OriginalTag, "original-tag", "", `(Optional) original tag that the base of this image was pulled from.`)
+	flagSet.StringVar(&cfg.OriginalDigest, "original-digest", "", `(Optional) original digest that the base of this image was pulled from.`)
+	flagSet.StringVar(&cfg.DigestTagTemplate, "digest-tag-template", "", `(Optional) Go template for an additional, digest-derived tag of a push (e.g., "digest-{{.Hex}}"). Available fields are .Algorithm, .Hex and .Digest of the root manifest.`)
+	flagSet.StringVar(&cfg.ReferenceOutput, "reference-output", "", `(Optional) Path to write the fully qualified reference (registry/repository@digest) of a push to. The reference is the build digest of the root manifest or index: runtime overrides of the push are not known here.`)
 	flagSet.Func("manifest-path", `Path to a manifest file. Format: index=path (e.g., 0=foo.json). Can be specified multiple times.`, func(value string) error {
 		parts := strings.SplitN(value, "=", 2)
 		if len(parts) != 2 {
@@ -69,10 +103,10 @@ func DeployMetadataProcess(ctx context.Context, args []string) {
 		}
 		path := parts[1]
 		// Expand slice if necessary
-		for len(manifestPaths) <= index {
-			manifestPaths = append(manifestPaths, "")
+		for len(cfg.ManifestPaths) <= index {
+			cfg.ManifestPaths = append(cfg.ManifestPaths, "")
 		}
-		manifestPaths[index] = path
+		cfg.ManifestPaths[index] = path
 		return nil
 	})
 	flagSet.Func("missing-blobs-for-manifest", `Missing blobs for a manifest. Format: index=blob1,blob2,... (e.g., 0=sha256:abc,sha256:def). Can be specified multiple times.`, func(value string) error {
@@ -89,12 +123,13 @@ func DeployMetadataProcess(ctx context.Context, args []string) {
 			blobs = nil // Handle empty case
 		}
 		// Expand slice if necessary
-		for len(missingBlobsForManifest) <= index {
-			missingBlobsForManifest = append(missingBlobsForManifest, nil)
+		for len(cfg.MissingBlobsForManifest) <= index {
+			cfg.MissingBlobsForManifest = append(cfg.MissingBlobsForManifest, nil)
 		}
-		missingBlobsForManifest[index] = blobs
+		cfg.MissingBlobsForManifest[index] = blobs
 		return nil
 	})
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -104,48 +139,44 @@ func DeployMetadataProcess(ctx context.Context, args []string) {
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	outputPath := flagSet.Arg(0)
-	if rootPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: --root-path is required")
+	cfg.Output = flagSet.Arg(0)
+	if cfg.RootPath == "" {
+		slog.Error("--root-path is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	if rootKind != "manifest" && rootKind != "index" {
-		fmt.Fprintln(os.Stderr, "Error: --root-kind must be either 'manifest' or 'index'")
+	if cfg.RootKind != "manifest" && cfg.RootKind != "index" {
+		slog.Error("--root-kind must be either 'manifest' or 'index'", "root_kind", cfg.RootKind)
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	if configurationPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: --configuration-file is required")
+	if cfg.ConfigurationPath == "" {
+		slog.Error("--configuration-file is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	switch strategy {
+	switch cfg.Strategy {
 	case "eager", "lazy", "cas_registry", "bes":
 		// valid strategies
 	default:
-		fmt.Fprintf(os.Stderr, "Error: invalid strategy %q\n", strategy)
+		slog.Error("Invalid strategy", "strategy", cfg.Strategy)
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	if err := WriteMetadata(ctx, outputPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing deploy metadata: %v\n", err)
-		os.Exit(1)
+	if err := NewMetadataRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing deploy metadata", logging.ErrKey, err)
 	}
 }
 
-func WriteMetadata(ctx context.Context, outputPath string) error {
-	rawConfig, err := os.ReadFile(configurationPath)
+// Run writes the deploy manifest describing the operation.
+func (r *MetadataRunner) Run(_ context.Context) error {
+	config, err := templating.ReadConfiguration(r.cfg.ConfigurationPath)
 	if err != nil {
-		return fmt.Errorf("reading request file: %w", err)
-	}
-	var config map[string]any
-	if err := json.Unmarshal(rawConfig, &config); err != nil {
-		return fmt.Errorf("unmarshalling config file: %w", err)
+		return err
 	}
 
 	// Parse root manifest file to determine kind and calculate digest/size
-	rootData, err := os.ReadFile(rootPath)
+	rootData, err := os.ReadFile(r.cfg.RootPath)
 	if err != nil {
 		return fmt.Errorf("reading root manifest file: %w", err)
 	}
@@ -156,13 +187,13 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 	// Try to parse as index first, then as manifest
 	var mediaType string
 
-	if rootKind == "index" {
+	if r.cfg.RootKind == "index" {
 		idx, err := registryv1.ParseIndexManifest(bytes.NewReader(rootData))
 		if err != nil {
 			return fmt.Errorf("parsing root manifest as index: %w", err)
 		}
 		mediaType = string(idx.MediaType)
-	} else if rootKind == "manifest" {
+	} else if r.cfg.RootKind == "manifest" {
 		manifest, err := registryv1.ParseManifest(bytes.NewReader(rootData))
 		if err != nil {
 			return fmt.Errorf("parsing root manifest as manifest: %w", err)
@@ -179,8 +210,8 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 	}
 
 	// Process manifests and missing blobs
-	manifests := make([]api.ManifestDeployInfo, len(manifestPaths))
-	for i, manifestPath := range manifestPaths {
+	manifests := make([]api.ManifestDeployInfo, len(r.cfg.ManifestPaths))
+	for i, manifestPath := range r.cfg.ManifestPaths {
 		if manifestPath == "" {
 			continue // Skip empty manifest paths
 		}
@@ -223,8 +254,8 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 
 		// Get missing blobs for this manifest
 		var missingBlobs []string
-		if i < len(missingBlobsForManifest) && missingBlobsForManifest[i] != nil {
-			missingBlobs = missingBlobsForManifest[i]
+		if i < len(r.cfg.MissingBlobsForManifest) && r.cfg.MissingBlobsForManifest[i] != nil {
+			missingBlobs = r.cfg.MissingBlobsForManifest[i]
 		}
 
 		manifests[i] = api.ManifestDeployInfo{
@@ -236,24 +267,24 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 	}
 
 	baseCommand := api.BaseCommandOperation{
-		Command:   command,
-		RootKind:  rootKind,
+		Command:   r.cfg.Command,
+		RootKind:  r.cfg.RootKind,
 		Root:      rootDescriptor,
 		Manifests: manifests,
 		PullInfo: api.PullInfo{
-			OriginalBaseImageRegistries: originalRegistries,
-			OriginalBaseImageRepository: originalRepository,
-			OriginalBaseImageTag:        orginalTag,
-			OriginalBaseImageDigest:     originalDigest,
+			OriginalBaseImageRegistries: r.cfg.OriginalRegistries,
+			OriginalBaseImageRepository: r.cfg.OriginalRepository,
+			OriginalBaseImageTag:        r.cfg.OriginalTag,
+			OriginalBaseImageDigest:     r.cfg.OriginalDigest,
 		},
 	}
 
 	var operationBytes []byte
 	var deploySettings api.DeploySettings
 
-	if command == "push" {
-		deploySettings.PushStrategy = strategy
-		operation, err := pushOperation(baseCommand, config)
+	if r.cfg.Command == "push" {
+		deploySettings.PushStrategy = r.cfg.Strategy
+		operation, err := r.pushOperation(baseCommand, config)
 		if err != nil {
 			return err
 		}
@@ -261,8 +292,14 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 		if err != nil {
 			return fmt.Errorf("marshalling push operation: %w", err)
 		}
-	} else if command == "load" {
-		deploySettings.LoadStrategy = strategy
+		if r.cfg.ReferenceOutput != "" {
+			reference := fmt.Sprintf("%s/%s@%s", operation.Registry, operation.Repository, operation.Root.Digest)
+			if err := os.WriteFile(r.cfg.ReferenceOutput, []byte(reference), 0o644); err != nil {
+				return fmt.Errorf("writing reference file: %w", err)
+			}
+		}
+	} else if r.cfg.Command == "load" {
+		deploySettings.LoadStrategy = r.cfg.Strategy
 		operation, err := loadOperation(baseCommand, config)
 		if err != nil {
 			return err
@@ -272,7 +309,7 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 			return fmt.Errorf("marshalling load operation: %w", err)
 		}
 	} else {
-		return fmt.Errorf("invalid command " + command)
+		return fmt.Errorf("invalid command %q", r.cfg.Command)
 	}
 
 	deployManifest := api.DeployManifest{
@@ -284,59 +321,115 @@ func WriteMetadata(ctx context.Context, outputPath string) error {
 	if err != nil {
 		return fmt.Errorf("marshalling metadata: %w", err)
 	}
-	if err := os.WriteFile(outputPath, manifestBytes, 0o644); err != nil {
+	if err := os.WriteFile(r.cfg.Output, manifestBytes, 0o644); err != nil {
 		return fmt.Errorf("writing metadata file: %w", err)
 	}
 	return nil
 }
 
-func pushOperation(baseCommand api.BaseCommandOperation, config map[string]any) (api.PushDeployOperation, error) {
-	registry, ok := config["registry"].(string)
-	if !ok || registry == "" {
-		return api.PushDeployOperation{}, fmt.Errorf("configuration file must contain a non-empty 'registry' field")
+func (r *MetadataRunner) pushOperation(baseCommand api.BaseCommandOperation, config templating.Configuration) (api.PushDeployOperation, error) {
+	registry, err := config.RequiredString("registry")
+	if err != nil {
+		return api.PushDeployOperation{}, err
 	}
-	repository, ok := config["repository"].(string)
-	if !ok || repository == "" {
-		return api.PushDeployOperation{}, fmt.Errorf("configuration file must contain a non-empty 'repository' field")
+	repository, err := config.RequiredString("repository")
+	if err != nil {
+		return api.PushDeployOperation{}, err
 	}
-	tagsInterface, ok := config["tags"].([]interface{})
-	if !ok {
-		tagsInterface = []interface{}{}
+	tags, err := config.StringList("tags")
+	if err != nil {
+		return api.PushDeployOperation{}, err
 	}
 
-	// Convert interface{} slice to string slice
-	tags := make([]string, len(tagsInterface))
-	for i, tag := range tagsInterface {
-		if tagStr, ok := tag.(string); ok {
-			tags[i] = tagStr
-		} else {
-			return api.PushDeployOperation{}, fmt.Errorf("tag at index %d is not a string", i)
+	if r.cfg.DigestTagTemplate != "" {
+		digestTag, err := expandDigestTag(r.cfg.DigestTagTemplate, baseCommand.Root.Digest)
+		if err != nil {
+			return api.PushDeployOperation{}, err
+		}
+		if !slices.Contains(tags, digestTag) {
+			tags = append(tags, digestTag)
 		}
 	}
 
+	webhooks, err := config.StringList("webhooks")
+	if err != nil {
+		return api.PushDeployOperation{}, err
+	}
+
 	return api.PushDeployOperation{
 		BaseCommandOperation: baseCommand,
 		PushTarget: api.PushTarget{
 			Registry:   registry,
 			Repository: repository,
 			Tags:       tags,
+			Webhooks:   webhooks,
 		},
 	}, nil
 }
 
-func loadOperation(baseCommand api.BaseCommandOperation, config map[string]any) (api.LoadDeployOperation, error) {
-	tag, ok := config["tag"].(string)
-	if !ok || tag == "" {
-		return api.LoadDeployOperation{}, fmt.Errorf("configuration file must contain a non-empty 'tag' field")
+// expandDigestTag renders the digest tag template for the given digest
+// and validates that the result is a valid tag.
+func expandDigestTag(tmplStr, digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	tmpl, err := template.New("digest-tag").Option("missingkey=error").Parse(tmplStr)
+	if err != nil {
+		return "", fmt.Errorf("parsing digest tag template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct {
+		Algorithm string
+		Hex       string
+		Digest    string
+	}{
+		Algorithm: algorithm,
+		Hex:       hex,
+		Digest:    digest,
+	}); err != nil {
+		return "", fmt.Errorf("expanding digest tag template: %w", err)
+	}
+	tag := buf.String()
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("digest tag template %q produced invalid tag %q", tmplStr, tag)
+	}
+	if isReferrersTag(tag, algorithm, hex) {
+		return "", fmt.Errorf("digest tag template %q produced tag %q, which is reserved for the referrers tag schema (signatures, attestations and SBOMs)", tmplStr, tag)
+	}
+	return tag, nil
+}
+
+// isReferrersTag reports whether tag is one of the tags that registries without the referrers API
+// (and signing tools like cosign) use for artifacts that refer to the digest.
+func isReferrersTag(tag, algorithm, hex string) bool {
+	rest, ok := strings.CutPrefix(tag, algorithm+"-"+hex)
+	if !ok {
+		return false
+	}
+	switch rest {
+	case "", ".sig", ".att", ".sbom":
+		return true
 	}
-	daemon, ok := config["daemon"].(string)
-	if !ok || daemon == "" {
-		return api.LoadDeployOperation{}, fmt.Errorf("configuration file must contain a non-empty 'daemon' field")
+	return false
+}
+
+func loadOperation(baseCommand api.BaseCommandOperation, config templating.Configuration) (api.LoadDeployOperation, error) {
+	tags, err := config.StringList("tags")
+	if err != nil {
+		return api.LoadDeployOperation{}, err
+	}
+	if len(tags) == 0 {
+		return api.LoadDeployOperation{}, fmt.Errorf("configuration file must contain at least one non-empty entry in the 'tags' field")
+	}
+	daemon, err := config.RequiredString("daemon")
+	if err != nil {
+		return api.LoadDeployOperation{}, err
 	}
 
 	return api.LoadDeployOperation{
 		BaseCommandOperation: baseCommand,
-		Tag:                  tag,
+		Tags:                 tags,
 		Daemon:               daemon,
 	}, nil
 }
@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/imagediff"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+func DiffProcess(_ context.Context, args []string) {
+	var oldManifest, oldConfig, newManifest, newConfig string
+	var layers layerMappingFlag
+	var outputPath string
+
+	flagSet := flag.NewFlagSet("diff", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Writes a Markdown report of what changed between two image manifests.\n\n")
+		fmt.Fprintf(flagSet.Output(), "The report lists the changed layers and config fields. If the blobs of the changed layers are provided with --layer, ")
+		fmt.Fprintf(flagSet.Output(), "it also lists the files that differ between the changed layers.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img diff [OPTIONS] --old manifest.json --new manifest.json\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img diff --old main/app_manifest.json --new pr/app_manifest.json",
+			"img diff --old old/manifest.json --old-config old/config.json --new new/manifest.json --new-config new/config.json --output report.md",
+			"img diff --old main/app_manifest.json --new pr/app_manifest.json --layer pr/app_layer_metadata.json=pr/app_layer.tgz --layer main/app_layer_metadata.json=main/app_layer.tgz",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+
+	flagSet.StringVar(&oldManifest, "old", "", "Manifest of the old image (required)")
+	flagSet.StringVar(&oldConfig, "old-config", "", `Config of the old image. Defaults to the "_config.json" file next to a "_manifest.json" file written by image_manifest.`)
+	flagSet.StringVar(&newManifest, "new", "", "Manifest of the new image (required)")
+	flagSet.StringVar(&newConfig, "new-config", "", `Config of the new image. Defaults to the "_config.json" file next to a "_manifest.json" file written by image_manifest.`)
+	flagSet.Var(&layers, "layer", "Layer mapping in format metadata=blob (can be specified multiple times). Used to compare the files of changed layers.")
+	flagSet.StringVar(&outputPath, "output", "-", `Output file path for the Markdown report. "-" writes to stdout.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if oldManifest == "" || newManifest == "" {
+		slog.Error("--old and --new are required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	if oldConfig, err = configPath(oldManifest, oldConfig); err != nil {
+		logging.Fatal("Locating config of old image", logging.ErrKey, err)
+	}
+	if newConfig, err = configPath(newManifest, newConfig); err != nil {
+		logging.Fatal("Locating config of new image", logging.ErrKey, err)
+	}
+	if err := run(oldManifest, oldConfig, newManifest, newConfig, layers, outputPath); err != nil {
+		logging.Fatal("Comparing images", logging.ErrKey, err)
+	}
+}
+
+func run(oldManifest, oldConfig, newManifest, newConfig string, layers []layerMapping, outputPath string) error {
+	layerPaths, err := layerPathsByDigest(layers)
+	if err != nil {
+		return err
+	}
+	oldImage, err := imagediff.LoadImage(oldManifest, oldConfig, layerPaths)
+	if err != nil {
+		return fmt.Errorf("loading old image: %w", err)
+	}
+	newImage, err := imagediff.LoadImage(newManifest, newConfig, layerPaths)
+	if err != nil {
+		return fmt.Errorf("loading new image: %w", err)
+	}
+	if oldImage.Platform() != newImage.Platform() {
+		slog.Warn("Comparing images of different platforms", "old", oldImage.Platform(), "new", newImage.Platform())
+	}
+
+	report, err := imagediff.Compare(oldImage, newImage)
+	if err != nil {
+		return err
+	}
+	if report.Files, err = imagediff.CompareFiles(report); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if outputPath != "-" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	return imagediff.WriteChangesMarkdown(out, []imagediff.Report{report})
+}
+
+// configPath returns the config of a manifest written by image_manifest
+// (<name>_manifest.json and <name>_config.json), unless the config is given explicitly.
+func configPath(manifestPath, configPath string) (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	prefix, ok := strings.CutSuffix(manifestPath, "_manifest.json")
+	if !ok {
+		return "", fmt.Errorf("cannot derive the config of %s, use --old-config and --new-config", manifestPath)
+	}
+	return prefix + "_config.json", nil
+}
+
+func layerPathsByDigest(layers []layerMapping) (map[string]string, error) {
+	layerPaths := make(map[string]string, len(layers))
+	for _, layer := range layers {
+		metadataData, err := os.ReadFile(layer.metadata)
+		if err != nil {
+			return nil, fmt.Errorf("reading layer metadata %s: %w", layer.metadata, err)
+		}
+		var metadata struct {
+			Digest string `json:"digest"`
+		}
+		if err := json.Unmarshal(metadataData, &metadata); err != nil {
+			return nil, fmt.Errorf("unmarshaling layer metadata %s: %w", layer.metadata, err)
+		}
+		if !strings.HasPrefix(metadata.Digest, "sha256:") {
+			return nil, fmt.Errorf("layer metadata %s has invalid digest %q", layer.metadata, metadata.Digest)
+		}
+		layerPaths[metadata.Digest] = layer.blob
+	}
+	return layerPaths, nil
+}
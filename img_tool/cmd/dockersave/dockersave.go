@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
 	v1 "github.com/malt3/go-containerregistry/pkg/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/templating"
 )
 
 type blobMap map[string]string // digest -> source path
@@ -40,28 +44,17 @@ type DockerManifest struct {
 	Layers   []string `json:"Layers"`
 }
 
-// readTagFromConfigFile reads the tag field from a configuration file
-func readTagFromConfigFile(configPath string) (string, error) {
+// readTagsFromConfigFile reads the tags field from a configuration file
+func readTagsFromConfigFile(configPath string) ([]string, error) {
 	if configPath == "" {
-		return "", nil
+		return nil, nil
 	}
 
-	data, err := os.ReadFile(configPath)
+	config, err := templating.ReadConfiguration(configPath)
 	if err != nil {
-		return "", fmt.Errorf("reading configuration file: %w", err)
-	}
-
-	var config map[string]interface{}
-	if err := json.Unmarshal(data, &config); err != nil {
-		return "", fmt.Errorf("parsing configuration file: %w", err)
-	}
-
-	tag, ok := config["tag"].(string)
-	if !ok {
-		return "", nil // tag field not present or not a string
+		return nil, err
 	}
-
-	return tag, nil
+	return config.StringList("tags")
 }
 
 func DockerSaveProcess(ctx context.Context, args []string) {
@@ -100,6 +93,7 @@ func DockerSaveProcess(ctx context.Context, args []string) {
 	flagSet.BoolVar(&useSymlinks, "symlink", false, "Use symlinks instead of copying files")
 	flagSet.BoolVar(&allowMissingBlobs, "allow-missing-blobs", false, "Allow missing blobs instead of failing the build")
 	flagSet.StringVar(&configurationFilePath, "configuration-file", "", "Path to configuration file containing tag information (optional)")
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -108,38 +102,35 @@ func DockerSaveProcess(ctx context.Context, args []string) {
 
 	// Validate required flags
 	if manifestPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: --manifest is required\n")
+		slog.Error("--manifest is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if configPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: --config is required\n")
+		slog.Error("--config is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if outputPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: --output is required\n")
+		slog.Error("--output is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
 	// Validate format parameter
 	if format != "directory" && format != "tar" {
-		fmt.Fprintf(os.Stderr, "Error: --format must be 'directory' or 'tar', got '%s'\n", format)
+		slog.Error(fmt.Sprintf("--format must be 'directory' or 'tar', got '%s'", format))
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	// Read tag from configuration file if provided and no --repo-tag was specified
+	// Read tags from configuration file if provided and no --repo-tag was specified
 	if len(repoTags) == 0 && configurationFilePath != "" {
-		configTag, err := readTagFromConfigFile(configurationFilePath)
+		configTags, err := readTagsFromConfigFile(configurationFilePath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading configuration file: %v\n", err)
-			os.Exit(1)
-		}
-		if configTag != "" {
-			repoTags = []string{configTag}
+			logging.Fatal("Reading configuration file", logging.ErrKey, err)
 		}
+		repoTags = configTags
 	}
 
 	// Default repo tag if none provided from either flags or config
@@ -149,8 +140,7 @@ func DockerSaveProcess(ctx context.Context, args []string) {
 
 	err := assembleDockerSave(manifestPath, configPath, outputPath, format, layerFlags, repoTags, useSymlinks, allowMissingBlobs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		logging.Fatal("Assembling Docker save output", logging.ErrKey, err)
 	}
 }
 
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/malt3/go-containerregistry/pkg/v1/remote"
 
 	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 func DownloadBlobProcess(ctx context.Context, args []string) {
@@ -20,6 +22,7 @@ func DownloadBlobProcess(ctx context.Context, args []string) {
 	var outputPath string
 	var registries stringSliceFlag
 	var executable bool
+	var tlsOptions reg.TLSOptions
 
 	flagSet := flag.NewFlagSet("download-blob", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -41,24 +44,29 @@ func DownloadBlobProcess(ctx context.Context, args []string) {
 	flagSet.StringVar(&outputPath, "output", "", "Output file path (required)")
 	flagSet.Var(&registries, "registry", "Registry to use (can be specified multiple times, defaults to docker.io)")
 	flagSet.BoolVar(&executable, "executable", false, "Mark the output file executable")
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
 		os.Exit(1)
 	}
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
 
 	if digest == "" {
-		fmt.Fprintf(os.Stderr, "Error: --digest is required\n")
+		slog.Error("--digest is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if repository == "" {
-		fmt.Fprintf(os.Stderr, "Error: --repository is required\n")
+		slog.Error("--repository is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if outputPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: --output is required\n")
+		slog.Error("--output is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
@@ -80,23 +88,20 @@ func DownloadBlobProcess(ctx context.Context, args []string) {
 			return
 		}
 		lastErr = err
-		fmt.Fprintf(os.Stderr, "Failed to download from %s: %v\n", registry, err)
+		slog.Warn("Failed to download", "registry", registry, logging.ErrKey, err)
 	}
 
 	if executable {
 		if err := os.Chmod(outputPath, 0o755); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to set executable permission on output file: %v\n", err)
-			os.Exit(1)
+			logging.Fatal("Failed to set executable permission on output file", logging.ErrKey, err)
 		}
 	} else {
 		if err := os.Chmod(outputPath, 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: Failed to remove executable permission on output file: %v\n", err)
-			os.Exit(1)
+			logging.Fatal("Failed to remove executable permission on output file", logging.ErrKey, err)
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "Error: Failed to download blob from all registries: %v\n", lastErr)
-	os.Exit(1)
+	logging.Fatal("Failed to download blob from all registries", logging.ErrKey, reg.Diagnose(lastErr))
 }
 
 func downloadFromRegistry(registry, repository, digest, outputPath string) error {
@@ -0,0 +1,185 @@
+package imagetest
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// result is the outcome of a single assertion.
+type result struct {
+	name string
+	err  error
+}
+
+func checkFiles(spec Spec, fs *filesystem) []result {
+	var results []result
+	check := func(name string, err error) {
+		results = append(results, result{name: name, err: err})
+	}
+
+	for _, p := range spec.FileExists {
+		var err error
+		if _, ok := fs.lookup(p); !ok {
+			err = errors.New("does not exist")
+		}
+		check("file_exists "+p, err)
+	}
+	for _, p := range spec.FileNotExists {
+		var err error
+		if _, ok := fs.lookup(p); ok {
+			err = errors.New("exists but should not")
+		}
+		check("file_not_exists "+p, err)
+	}
+	for _, p := range slices.Sorted(maps.Keys(spec.FileMode)) {
+		check("file_mode "+p, checkMode(fs, p, spec.FileMode[p]))
+	}
+	for _, p := range slices.Sorted(maps.Keys(spec.FileOwner)) {
+		check("file_owner "+p, checkOwner(fs, p, spec.FileOwner[p]))
+	}
+	for _, p := range slices.Sorted(maps.Keys(spec.FileContains)) {
+		check("file_contains "+p, checkContains(fs, p, spec.FileContains[p]))
+	}
+	return results
+}
+
+func lookupEntry(fs *filesystem, p string) (*tar.Header, error) {
+	state, ok := fs.lookup(p)
+	if !ok {
+		return nil, errors.New("does not exist")
+	}
+	if state.header == nil {
+		return nil, errors.New("is an implicit directory without its own tar entry")
+	}
+	return state.header, nil
+}
+
+func checkMode(fs *filesystem, p, mode string) error {
+	expectedMode, err := strconv.ParseInt(mode, 8, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mode format: %s (expected octal)", mode)
+	}
+	header, err := lookupEntry(fs, p)
+	if err != nil {
+		return err
+	}
+	// Compare only the permission bits (lower 12 bits)
+	actualMode := header.Mode & 0o7777
+	expectedMode = expectedMode & 0o7777
+	if actualMode != expectedMode {
+		return fmt.Errorf("expected mode %04o, got %04o", expectedMode, actualMode)
+	}
+	return nil
+}
+
+func checkOwner(fs *filesystem, p, owner string) error {
+	uid, gid, ok := strings.Cut(owner, ":")
+	if !ok {
+		return fmt.Errorf("invalid owner format: %s (expected uid:gid)", owner)
+	}
+	expectedUID, err := strconv.Atoi(uid)
+	if err != nil {
+		return fmt.Errorf("invalid UID in owner: %s", uid)
+	}
+	expectedGID, err := strconv.Atoi(gid)
+	if err != nil {
+		return fmt.Errorf("invalid GID in owner: %s", gid)
+	}
+	header, err := lookupEntry(fs, p)
+	if err != nil {
+		return err
+	}
+	if header.Uid != expectedUID || header.Gid != expectedGID {
+		return fmt.Errorf("expected owner %d:%d, got %d:%d", expectedUID, expectedGID, header.Uid, header.Gid)
+	}
+	return nil
+}
+
+func checkContains(fs *filesystem, p, substring string) error {
+	header, err := lookupEntry(fs, p)
+	if err != nil {
+		return err
+	}
+	state, _ := fs.lookup(p)
+	switch header.Typeflag {
+	case tar.TypeReg:
+	case tar.TypeLink:
+		if !state.resolved {
+			return fmt.Errorf("is a hardlink to %s, which is not a regular file of the same layer", header.Linkname)
+		}
+	case tar.TypeSymlink:
+		return fmt.Errorf("is a symlink to %s (symlinks are not followed, assert on the target instead)", header.Linkname)
+	default:
+		return errors.New("is not a regular file")
+	}
+	if !strings.Contains(string(state.content), substring) {
+		return fmt.Errorf("does not contain %q", substring)
+	}
+	return nil
+}
+
+func checkConfig(spec Spec, config specv1.ImageConfig) []result {
+	var results []result
+	check := func(name string, err error) {
+		results = append(results, result{name: name, err: err})
+	}
+
+	env := make(map[string]string, len(config.Env))
+	for _, entry := range config.Env {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+	for _, key := range slices.Sorted(maps.Keys(spec.Env)) {
+		var err error
+		if actual, ok := env[key]; !ok {
+			err = errors.New("not set")
+		} else if actual != spec.Env[key] {
+			err = fmt.Errorf("expected %q, got %q", spec.Env[key], actual)
+		}
+		check("env "+key, err)
+	}
+	if spec.Entrypoint != nil {
+		check("entrypoint", compareList(spec.Entrypoint, config.Entrypoint))
+	}
+	if spec.Cmd != nil {
+		check("cmd", compareList(spec.Cmd, config.Cmd))
+	}
+	if spec.User != nil {
+		var err error
+		if config.User != *spec.User {
+			err = fmt.Errorf("expected %q, got %q", *spec.User, config.User)
+		}
+		check("user", err)
+	}
+	if spec.WorkingDir != nil {
+		var err error
+		if config.WorkingDir != *spec.WorkingDir {
+			err = fmt.Errorf("expected %q, got %q", *spec.WorkingDir, config.WorkingDir)
+		}
+		check("working_dir", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(spec.Labels)) {
+		var err error
+		if actual, ok := config.Labels[key]; !ok {
+			err = errors.New("not set")
+		} else if actual != spec.Labels[key] {
+			err = fmt.Errorf("expected %q, got %q", spec.Labels[key], actual)
+		}
+		check("label "+key, err)
+	}
+	return results
+}
+
+func compareList(expected, actual []string) error {
+	if slices.Equal(expected, actual) {
+		return nil
+	}
+	return fmt.Errorf("expected %q, got %q", expected, actual)
+}
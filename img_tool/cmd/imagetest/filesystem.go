@@ -0,0 +1,132 @@
+package imagetest
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tarreader"
+)
+
+// fileState is the state of a path after applying a number of layers.
+type fileState struct {
+	// header is nil for directories that only exist implicitly
+	// (as the parent of another entry).
+	header  *tar.Header
+	content []byte
+	// resolved is true if the content of a hardlink was read from its target.
+	resolved bool
+	// layer is the index of the layer that created this state.
+	layer int
+}
+
+// filesystem tracks the paths referenced by assertions
+// while the layers of an image are applied in order.
+// Only referenced paths are kept, so images of any size can be tested.
+type filesystem struct {
+	wanted      map[string]bool
+	withContent map[string]bool
+	files       map[string]*fileState
+}
+
+func newFilesystem(spec Spec) *filesystem {
+	fs := &filesystem{
+		wanted:      make(map[string]bool),
+		withContent: make(map[string]bool),
+		files:       make(map[string]*fileState),
+	}
+	for _, p := range spec.filePaths() {
+		fs.wanted[normalizePath(p)] = true
+	}
+	for p := range spec.FileContains {
+		fs.withContent[normalizePath(p)] = true
+	}
+	return fs
+}
+
+// applyLayer applies the layer blob with the given index on top of the previous layers.
+// Whiteouts only remove files of lower layers.
+// Hardlinks (like the files of rules_img layers, which link into .cas/) get the content of their target.
+func (fs *filesystem) applyLayer(index int, blobPath string) error {
+	// maps hardlink targets to the wanted hardlinks of this layer
+	hardlinks := make(map[string][]string)
+	err := tarreader.Walk(blobPath, func(header *tar.Header, content io.Reader) error {
+		name := normalizePath(header.Name)
+		dir, base := path.Split(name)
+		if whiteout, ok := strings.CutPrefix(base, ".wh."); ok {
+			if whiteout == ".wh..opq" {
+				// opaque directory: remove everything below it from lower layers
+				fs.remove(index, func(p string) bool { return strings.HasPrefix(p, dir) })
+				return nil
+			}
+			removed := dir + whiteout
+			fs.remove(index, func(p string) bool { return p == removed || strings.HasPrefix(p, removed+"/") })
+			return nil
+		}
+
+		for parent := path.Dir(name); parent != "." && parent != "/"; parent = path.Dir(parent) {
+			if fs.wanted[parent] && fs.files[parent] == nil {
+				fs.files[parent] = &fileState{layer: index}
+			}
+		}
+		if !fs.wanted[name] {
+			return nil
+		}
+		state := &fileState{header: header, layer: index}
+		if fs.withContent[name] && header.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(content)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			state.content = data
+		}
+		if fs.withContent[name] && header.Typeflag == tar.TypeLink {
+			target := normalizePath(header.Linkname)
+			hardlinks[target] = append(hardlinks[target], name)
+		}
+		fs.files[name] = state
+		return nil
+	})
+	if err != nil || len(hardlinks) == 0 {
+		return err
+	}
+	return fs.resolveHardlinks(index, blobPath, hardlinks)
+}
+
+// resolveHardlinks reads the content of the hardlink targets from the layer blob
+// with a second pass, since the targets are not known before the links are seen.
+// Hardlinks can only refer to files of the same layer.
+func (fs *filesystem) resolveHardlinks(index int, blobPath string, hardlinks map[string][]string) error {
+	return tarreader.Walk(blobPath, func(header *tar.Header, content io.Reader) error {
+		names, ok := hardlinks[normalizePath(header.Name)]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", header.Name, err)
+		}
+		for _, name := range names {
+			if state := fs.files[name]; state != nil && state.layer == index {
+				state.content = data
+				state.resolved = true
+			}
+		}
+		return nil
+	})
+}
+
+func (fs *filesystem) remove(index int, match func(p string) bool) {
+	for p, state := range fs.files {
+		if state.layer < index && match(p) {
+			delete(fs.files, p)
+		}
+	}
+}
+
+func (fs *filesystem) lookup(p string) (*fileState, bool) {
+	state, ok := fs.files[normalizePath(p)]
+	return state, ok
+}
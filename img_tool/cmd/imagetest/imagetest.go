@@ -0,0 +1,204 @@
+package imagetest
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// image holds the paths of the files of a single-platform image.
+type image struct {
+	manifest string
+	config   string
+	layers   layerMappingFlag
+}
+
+func TestProcess(_ context.Context, args []string) {
+	var manifestPath, configPath, specPath string
+	var layerFlags layerMappingFlag
+
+	flagSet := flag.NewFlagSet("test", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Evaluates structure test assertions against the filesystem and config of an image without a container runtime.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img test [OPTIONS]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img test --manifest manifest.json --config config.json --layer layer1_meta.json=layer1.tar.gz --spec spec.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&manifestPath, "manifest", "", "Path to the image manifest.")
+	flagSet.StringVar(&configPath, "config", "", "Path to the image config.")
+	flagSet.Var(&layerFlags, "layer", "Layer mapping in format metadata=blob (can be specified multiple times). Required for all layers if the spec contains file assertions.")
+	flagSet.StringVar(&specPath, "spec", "", "Path to the JSON file with the assertions.")
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if manifestPath == "" || configPath == "" || specPath == "" {
+		slog.Error("--manifest, --config, and --spec are required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	spec, err := ReadSpec(specPath)
+	if err != nil {
+		logging.Fatal("Reading spec", logging.ErrKey, err)
+	}
+	passed, err := runImage(os.Stdout, spec, image{manifest: manifestPath, config: configPath, layers: layerFlags})
+	if err != nil {
+		logging.Fatal("Testing image", logging.ErrKey, err)
+	}
+	if !passed {
+		os.Exit(1)
+	}
+}
+
+// dispatchRequest is the request written by the image_test rule.
+// All paths are runfiles paths.
+type dispatchRequest struct {
+	Spec   Spec `json:"spec"`
+	Images []struct {
+		Manifest string `json:"manifest"`
+		Config   string `json:"config"`
+		Layers   []struct {
+			Metadata string `json:"metadata"`
+			Blob     string `json:"blob"`
+		} `json:"layers"`
+	} `json:"images"`
+}
+
+// TestDispatch runs the structure test described by the request of the image_test rule
+// against every image of the request.
+func TestDispatch(_ context.Context, rawRequest []byte, rlocation func(string) (string, error)) {
+	var request dispatchRequest
+	if err := json.Unmarshal(rawRequest, &request); err != nil {
+		logging.Fatal("Unmarshaling test request", logging.ErrKey, err)
+	}
+
+	allPassed := true
+	for i, requestImage := range request.Images {
+		var img image
+		var err error
+		if img.manifest, err = rlocation(requestImage.Manifest); err != nil {
+			logging.Fatal("Resolving manifest", logging.ErrKey, err)
+		}
+		if img.config, err = rlocation(requestImage.Config); err != nil {
+			logging.Fatal("Resolving config", logging.ErrKey, err)
+		}
+		for _, layer := range requestImage.Layers {
+			var mapping layerMapping
+			if mapping.metadata, err = rlocation(layer.Metadata); err != nil {
+				logging.Fatal("Resolving layer metadata", logging.ErrKey, err)
+			}
+			if mapping.blob, err = rlocation(layer.Blob); err != nil {
+				logging.Fatal("Resolving layer blob", logging.ErrKey, err)
+			}
+			img.layers = append(img.layers, mapping)
+		}
+
+		if len(request.Images) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("=== image %d of %d ===\n", i+1, len(request.Images))
+		}
+		passed, err := runImage(os.Stdout, request.Spec, img)
+		if err != nil {
+			logging.Fatal("Testing image", logging.ErrKey, err)
+		}
+		allPassed = allPassed && passed
+	}
+	if !allPassed {
+		os.Exit(1)
+	}
+}
+
+// runImage evaluates the spec against a single-platform image and reports the results to w.
+// It returns whether all assertions passed.
+func runImage(w io.Writer, spec Spec, img image) (bool, error) {
+	var manifest specv1.Manifest
+	if err := readJSON(img.manifest, &manifest); err != nil {
+		return false, fmt.Errorf("reading manifest: %w", err)
+	}
+	var config specv1.Image
+	if err := readJSON(img.config, &config); err != nil {
+		return false, fmt.Errorf("reading config: %w", err)
+	}
+	fmt.Fprintf(w, "Testing %s/%s image\n", config.OS, config.Architecture)
+
+	results := checkConfig(spec, config.Config)
+	if len(spec.filePaths()) > 0 {
+		fs, err := applyLayers(spec, manifest, img.layers)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, checkFiles(spec, fs)...)
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", r.name, r.err)
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", r.name)
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed == 0, nil
+}
+
+func applyLayers(spec Spec, manifest specv1.Manifest, layers layerMappingFlag) (*filesystem, error) {
+	layerBlobsByDigest := make(map[string]string, len(layers))
+	for _, layer := range layers {
+		var metadata struct {
+			Digest string `json:"digest"`
+		}
+		if err := readJSON(layer.metadata, &metadata); err != nil {
+			return nil, fmt.Errorf("reading layer metadata %s: %w", layer.metadata, err)
+		}
+		layerBlobsByDigest[metadata.Digest] = layer.blob
+	}
+
+	var missingBlobs []string
+	for _, layer := range manifest.Layers {
+		if _, ok := layerBlobsByDigest[layer.Digest.String()]; !ok {
+			missingBlobs = append(missingBlobs, layer.Digest.String())
+		}
+	}
+	if len(missingBlobs) > 0 {
+		return nil, fmt.Errorf(`missing layer blobs %s
+file assertions need the contents of all layers. You probably want to set the "layer_handling" attribute of the pull rule of your base image to "eager"`, strings.Join(missingBlobs, ", "))
+	}
+
+	fs := newFilesystem(spec)
+	for i, layer := range manifest.Layers {
+		if err := fs.applyLayer(i, layerBlobsByDigest[layer.Digest.String()]); err != nil {
+			return nil, fmt.Errorf("applying layer %s: %w", layer.Digest, err)
+		}
+	}
+	return fs, nil
+}
+
+func readJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,72 @@
+package imagetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Spec describes the assertions of a structure test.
+// Assertions that are not set are not checked.
+type Spec struct {
+	// FileExists lists paths that must exist in the image filesystem.
+	FileExists []string `json:"file_exists,omitempty"`
+	// FileNotExists lists paths that must not exist in the image filesystem.
+	FileNotExists []string `json:"file_not_exists,omitempty"`
+	// FileMode maps paths to their expected permission bits (octal, e.g. "0755").
+	FileMode map[string]string `json:"file_mode,omitempty"`
+	// FileOwner maps paths to their expected owner (uid:gid).
+	FileOwner map[string]string `json:"file_owner,omitempty"`
+	// FileContains maps paths of regular files (or hardlinks to them) to a substring of their contents.
+	FileContains map[string]string `json:"file_contains,omitempty"`
+	// Env maps environment variables to their expected value.
+	Env map[string]string `json:"env,omitempty"`
+	// Entrypoint is the expected entrypoint. An empty (non-nil) list expects no entrypoint.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	// Cmd is the expected cmd. An empty (non-nil) list expects no cmd.
+	Cmd []string `json:"cmd,omitempty"`
+	// User is the expected user.
+	User *string `json:"user,omitempty"`
+	// WorkingDir is the expected working directory.
+	WorkingDir *string `json:"working_dir,omitempty"`
+	// Labels maps labels to their expected value.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ReadSpec reads a structure test spec from a JSON file.
+func ReadSpec(specPath string) (Spec, error) {
+	raw, err := os.ReadFile(specPath)
+	if err != nil {
+		return Spec{}, fmt.Errorf("reading spec: %w", err)
+	}
+	var spec Spec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return Spec{}, fmt.Errorf("unmarshaling spec %s: %w", specPath, err)
+	}
+	return spec, nil
+}
+
+// filePaths returns all paths of the image filesystem that are referenced by assertions.
+func (s Spec) filePaths() []string {
+	var paths []string
+	paths = append(paths, s.FileExists...)
+	paths = append(paths, s.FileNotExists...)
+	for p := range s.FileMode {
+		paths = append(paths, p)
+	}
+	for p := range s.FileOwner {
+		paths = append(paths, p)
+	}
+	for p := range s.FileContains {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// normalizePath turns a path of the image filesystem or a tar entry name
+// into the form used for lookups (relative to the root, without trailing slash).
+func normalizePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
@@ -1,3 +1,8 @@
+// Command img is the tool behind the rules of rules_img.
+//
+// Every subcommand parses its flags into the Config of its package and runs it with a Runner.
+// Runners don't share state, so multiple runners (of the same or of different subcommands)
+// can be used in the same process.
 package main
 
 import (
@@ -7,39 +12,70 @@ import (
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
 
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/artifact"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/attest"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/basediff"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/compress"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/contentmanifest"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/deploy"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/diff"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/dockersave"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/downloadblob"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/expandtemplate"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/imagetest"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/importarchive"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/index"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/layer"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/layermeta"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/lock"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/login"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/manifest"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/ocilayout"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/pull"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/pullsize"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/push"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/soci"
+	"github.com/bazel-contrib/rules_img/img_tool/cmd/squash"
 	"github.com/bazel-contrib/rules_img/img_tool/cmd/validate"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 const usage = `Usage: img [COMMAND] [ARGS...]
 
 Commands:
+  artifact         creates the manifest of an OCI artifact from arbitrary files
+  attest           writes the SLSA provenance of an image as an attestation
+  base-diff        writes a report of the differences between two versions of a base image
+  cat              writes a file of the image of a push or load target (or of layers) to stdout
   compress         (re-)compresses a layer
+  content-manifest merges content manifests of layers for deduplication
+  diff             writes a report of what changed between two image manifests
   docker-save      assembles a Docker save compatible directory or tarball
   download-blob    downloads a single blob from a registry
   expand-template  expands Go templates in push request JSON
+  explore          browses the layers and filesystem of the image of a push or load target
+  import-archive   converts a docker save tarball or OCI archive into the blobs of an OCI layout
   layer            creates a layer from files
   layer-metadata   creates a layer metadata file from a layer
+  lock             resolves tags of base images to digests in a lock file
+  login            checks registry credentials and stores them for push and pull
   manifest         creates an image manifest and config from layers
   oci-layout       assembles an OCI layout directory from manifest and layers
   validate         validates layers and images
   pull             pulls an image from a registry
+  pull-size        estimates the bytes a node needs to pull to deploy a new build
   push             pushes an image to a registry
+  push-layout      pushes an image or index of an OCI layout directory or tar to a registry
+  serve-vfs        serves the images of a push or load target as a read-only registry
+  soci-index       writes the SOCI index manifest of an image from the ztocs of its layers
+  soci-ztoc        builds the SOCI ztoc of a gzip compressed layer
+  squash           squashes consecutive layers into a single layer
+  test             evaluates structure test assertions against an image
   deploy-metadata  calculates metadata for deploying an image (push/load)
   deploy-merge     merges multiple deploy manifests into a single deployment`
 
 func Run(ctx context.Context, args []string) {
+	logging.Init()
 	if runfilesDispatch(ctx, args[1:]) {
 		// Check if we got a special command
 		// via runfiels root symlinks.
@@ -56,34 +92,68 @@ func Run(ctx context.Context, args []string) {
 
 	command := args[1]
 	switch command {
+	case "artifact":
+		artifact.ArtifactProcess(ctx, args[2:])
+	case "attest":
+		attest.AttestProcess(ctx, args[2:])
 	case "layer":
 		layer.LayerProcess(ctx, args[2:])
 	case "layer-metadata":
 		layermeta.LayerMetadataProcess(ctx, args[2:])
+	case "lock":
+		lock.LockProcess(ctx, args[2:])
+	case "login":
+		login.LoginProcess(ctx, args[2:])
 	case "manifest":
 		manifest.ManifestProcess(ctx, args[2:])
 	case "index":
 		index.IndexProcess(ctx, args[2:])
 	case "validate":
 		validate.ValidationProcess(ctx, args[2:])
+	case "import-archive":
+		importarchive.ImportArchiveProcess(ctx, args[2:])
 	case "pull":
 		pull.PullProcess(ctx, args[2:])
 	case "push":
 		push.PushProcess(ctx, args[2:])
+	case "push-layout":
+		push.PushLayoutProcess(ctx, args[2:])
+	case "explore":
+		push.ExploreProcess(ctx, args[2:])
+	case "cat":
+		push.CatProcess(ctx, args[2:])
+	case "serve-vfs":
+		push.ServeVFSProcess(ctx, args[2:])
 	case "deploy-metadata":
 		deploy.DeployMetadataProcess(ctx, args[2:])
 	case "deploy-merge":
 		deploy.DeployMergeProcess(ctx, args[2:])
+	case "squash":
+		squash.SquashProcess(ctx, args[2:])
 	case "compress":
 		compress.CompressProcess(ctx, args[2:])
+	case "content-manifest":
+		contentmanifest.ContentManifestProcess(ctx, args[2:])
 	case "docker-save":
 		dockersave.DockerSaveProcess(ctx, args[2:])
 	case "download-blob":
 		downloadblob.DownloadBlobProcess(ctx, args[2:])
 	case "oci-layout":
 		ocilayout.OCILayoutProcess(ctx, args[2:])
+	case "base-diff":
+		basediff.BaseDiffProcess(ctx, args[2:])
+	case "diff":
+		diff.DiffProcess(ctx, args[2:])
+	case "pull-size":
+		pullsize.PullSizeProcess(ctx, args[2:])
 	case "expand-template":
 		expandtemplate.ExpandTemplateProcess(ctx, args[2:])
+	case "soci-ztoc":
+		soci.SociZtocProcess(ctx, args[2:])
+	case "soci-index":
+		soci.SociIndexProcess(ctx, args[2:])
+	case "test":
+		imagetest.TestProcess(ctx, args[2:])
 	default:
 		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(1)
@@ -93,32 +163,46 @@ func Run(ctx context.Context, args []string) {
 func runfilesDispatch(ctx context.Context, args []string) bool {
 	// Check if the command is run from a Bazel runfiles context
 	// with a special root symlink indicating that this binary is used
-	// to push/load an image.
+	// to push/load an image or to test an image.
 	rf, err := runfiles.New()
 	if err != nil {
 		return false
 	}
-	requestPath, err := rf.Rlocation("dispatch.json")
+
+	if rawRequest, ok := readRootSymlink(rf, "dispatch.json"); ok {
+		// If we got here, we are in a Bazel runfiles context
+		// and we have a special root symlink indicating that this binary
+		// is using a json command.
+		push.DeployDispatch(ctx, rawRequest)
+		return true
+	}
+	if rawRequest, ok := readRootSymlink(rf, "image_test.json"); ok {
+		// This binary is the executable of an image_test.
+		imagetest.TestDispatch(ctx, rawRequest, rf.Rlocation)
+		return true
+	}
+	if rawRequest, ok := readRootSymlink(rf, "lock.json"); ok {
+		// This binary is the executable of an image_lock target.
+		lock.LockDispatch(ctx, rawRequest, args)
+		return true
+	}
+	return false
+}
+
+func readRootSymlink(rf *runfiles.Runfiles, name string) ([]byte, bool) {
+	requestPath, err := rf.Rlocation(name)
 	if err != nil {
-		return false
+		return nil, false
 	}
 	if _, err := os.Stat(requestPath); err != nil {
-		return false
+		return nil, false
 	}
 
 	rawRequest, err := os.ReadFile(requestPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "reading request file: %v\n", err)
-		os.Exit(1)
+		logging.Fatal("Reading request file", logging.ErrKey, err)
 	}
-
-	// If we got here, we are in a Bazel runfiles context
-	// and we have a special root symlink indicating that this binary
-	// is using a json command.
-
-	push.DeployDispatch(ctx, rawRequest)
-
-	return true
+	return rawRequest, true
 }
 
 func main() {
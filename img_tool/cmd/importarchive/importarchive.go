@@ -0,0 +1,392 @@
+package importarchive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// containerdImageNameAnnotation is the full reference of an image in OCI archives written by docker and containerd.
+const containerdImageNameAnnotation = "io.containerd.image.name"
+
+// dockerSaveManifest is an entry of the manifest.json of a "docker save" archive.
+type dockerSaveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ImportArchiveProcess converts a "docker save" tarball, an OCI archive or an OCI layout directory into the blobs of an OCI layout.
+// The blobs are written to <output>/blobs/sha256 and the digest of the root manifest (or index) is written to stdout.
+func ImportArchiveProcess(_ context.Context, args []string) {
+	var outputDir string
+	var repoTag string
+
+	flagSet := flag.NewFlagSet("import-archive", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Converts a docker save tarball, an OCI archive or an OCI layout directory into the blobs of an OCI layout.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img import-archive [OPTIONS] [archive|layout]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img import-archive --output ./outdir image.tar",
+			"img import-archive --output ./outdir --repo-tag my/image:latest images.tar.gz",
+			"img import-archive --output ./outdir ./oci_layout",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&outputDir, "output", ".", "Output directory to write the blobs to (as blobs/sha256/<hex>)")
+	flagSet.StringVar(&repoTag, "repo-tag", "", `Image of an archive with multiple images to import. Matches the "RepoTags" of docker save archives and the io.containerd.image.name or org.opencontainers.image.ref.name annotations of OCI archives.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 1 {
+		slog.Error("Expected exactly one archive")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	root, err := importArchive(flagSet.Arg(0), outputDir, repoTag)
+	if err != nil {
+		logging.Fatal("Importing archive", "archive", flagSet.Arg(0), logging.ErrKey, err)
+	}
+	fmt.Println(root)
+}
+
+// importArchive writes the blobs of the image of the archive to outputDir and returns the digest of its root blob.
+func importArchive(archive, outputDir, repoTag string) (digest.Digest, error) {
+	blobDir := filepath.Join(outputDir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(archive); err == nil && info.IsDir() {
+		// OCI layout directories are used as they are. Blobs are hardlinked or copied, never moved.
+		if _, err := os.Stat(filepath.Join(archive, specv1.ImageLayoutFile)); err != nil {
+			return "", fmt.Errorf("directory is not an OCI layout: %w", err)
+		}
+		return importLayout(archive, blobDir, repoTag)
+	}
+	// extract next to the output, so that blobs can be hardlinked instead of copied
+	extracted, err := os.MkdirTemp(outputDir, ".import-archive-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(extracted)
+	if err := extractArchive(archive, extracted); err != nil {
+		return "", fmt.Errorf("extracting: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(extracted, specv1.ImageLayoutFile)); err == nil {
+		// OCI archives and archives of docker 25 or newer
+		return importLayout(extracted, blobDir, repoTag)
+	}
+	if _, err := os.Stat(filepath.Join(extracted, "manifest.json")); err == nil {
+		return importDockerSave(extracted, blobDir, repoTag)
+	}
+	return "", errors.New("neither an OCI archive (missing oci-layout) nor a docker save archive (missing manifest.json)")
+}
+
+// importLayout moves the blobs of an OCI layout to blobDir and returns the digest of the selected manifest or index of index.json.
+// Layouts may be shallow: blobs that are missing from the layout (except the root blob) are missing from blobDir as well.
+func importLayout(dir, blobDir, repoTag string) (digest.Digest, error) {
+	var index specv1.Index
+	if err := readJSON(filepath.Join(dir, specv1.ImageIndexFile), &index); err != nil {
+		return "", err
+	}
+	root, err := selectLayoutManifest(index.Manifests, repoTag)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, specv1.ImageBlobsDir, "sha256"))
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, _, err := moveBlob(filepath.Join(dir, specv1.ImageBlobsDir, "sha256", entry.Name()), blobDir, digest.NewDigestFromEncoded(digest.SHA256, entry.Name())); err != nil {
+			return "", err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(blobDir, root.Digest.Encoded())); err != nil {
+		return "", fmt.Errorf("root blob %s of index.json is missing from the archive", root.Digest)
+	}
+	return root.Digest, nil
+}
+
+func selectLayoutManifest(manifests []specv1.Descriptor, repoTag string) (specv1.Descriptor, error) {
+	if repoTag == "" {
+		if len(manifests) != 1 {
+			return specv1.Descriptor{}, fmt.Errorf("index.json lists %d manifests, select one with --repo-tag", len(manifests))
+		}
+		return manifests[0], nil
+	}
+	for _, desc := range manifests {
+		if desc.Annotations[containerdImageNameAnnotation] == repoTag || desc.Annotations[specv1.AnnotationRefName] == repoTag {
+			return desc, nil
+		}
+	}
+	return specv1.Descriptor{}, fmt.Errorf("no manifest of index.json is annotated with %q", repoTag)
+}
+
+// importDockerSave converts the selected image of a docker save archive into an OCI manifest.
+// Layers and config are used as they are, so that their digests (and the diff IDs of the config) stay valid.
+func importDockerSave(dir, blobDir, repoTag string) (digest.Digest, error) {
+	var manifests []dockerSaveManifest
+	if err := readJSON(filepath.Join(dir, "manifest.json"), &manifests); err != nil {
+		return "", err
+	}
+	entry, err := selectDockerSaveManifest(manifests, repoTag)
+	if err != nil {
+		return "", err
+	}
+
+	configDigest, configSize, err := moveBlob(filepath.Join(dir, filepath.FromSlash(entry.Config)), blobDir, "")
+	if err != nil {
+		return "", fmt.Errorf("config %s: %w", entry.Config, err)
+	}
+	var config specv1.Image
+	if err := readJSON(filepath.Join(blobDir, configDigest.Encoded()), &config); err != nil {
+		return "", err
+	}
+	if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+		return "", fmt.Errorf("config lists %d diff IDs, but the image has %d layers", len(config.RootFS.DiffIDs), len(entry.Layers))
+	}
+
+	layers := make([]specv1.Descriptor, len(entry.Layers))
+	for i, layer := range entry.Layers {
+		path := filepath.Join(dir, filepath.FromSlash(layer))
+		mediaType, err := layerMediaType(path)
+		if err != nil {
+			return "", fmt.Errorf("layer %s: %w", layer, err)
+		}
+		layerDigest, layerSize, err := moveBlob(path, blobDir, "")
+		if err != nil {
+			return "", fmt.Errorf("layer %s: %w", layer, err)
+		}
+		if mediaType == specv1.MediaTypeImageLayer && layerDigest != config.RootFS.DiffIDs[i] {
+			return "", fmt.Errorf("layer %s has digest %s, but the config expects diff ID %s", layer, layerDigest, config.RootFS.DiffIDs[i])
+		}
+		layers[i] = specv1.Descriptor{
+			MediaType: mediaType,
+			Digest:    layerDigest,
+			Size:      layerSize,
+		}
+	}
+
+	manifest := specv1.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: specv1.MediaTypeImageManifest,
+		Config: specv1.Descriptor{
+			MediaType: specv1.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      configSize,
+		},
+		Layers: layers,
+	}
+	manifestRaw, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest := digest.FromBytes(manifestRaw)
+	if err := os.WriteFile(filepath.Join(blobDir, manifestDigest.Encoded()), manifestRaw, 0o644); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+func selectDockerSaveManifest(manifests []dockerSaveManifest, repoTag string) (dockerSaveManifest, error) {
+	if repoTag == "" {
+		if len(manifests) != 1 {
+			return dockerSaveManifest{}, fmt.Errorf("manifest.json lists %d images, select one with --repo-tag", len(manifests))
+		}
+		return manifests[0], nil
+	}
+	for _, manifest := range manifests {
+		for _, tag := range manifest.RepoTags {
+			if tag == repoTag {
+				return manifest, nil
+			}
+		}
+	}
+	return dockerSaveManifest{}, fmt.Errorf("no image of manifest.json is tagged %q", repoTag)
+}
+
+// layerMediaType detects the compression of a layer by its magic bytes.
+func layerMediaType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return specv1.MediaTypeImageLayerGzip, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return specv1.MediaTypeImageLayerZstd, nil
+	}
+	return specv1.MediaTypeImageLayer, nil
+}
+
+// moveBlob moves the file at path to blobDir, named by the sha256 of its content.
+// If expected is set, the content must match it.
+// Blobs that are referenced more than once (like symlinked layers of docker save archives) are only moved once.
+func moveBlob(path, blobDir string, expected digest.Digest) (digest.Digest, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	actual := digest.NewDigestFromBytes(digest.SHA256, h.Sum(nil))
+	if expected != "" && actual != expected {
+		return "", 0, fmt.Errorf("blob %s has digest %s", expected, actual)
+	}
+	target := filepath.Join(blobDir, actual.Encoded())
+	if _, err := os.Stat(target); err == nil {
+		return actual, size, nil
+	}
+	// hardlink instead of moving, since symlinks of other entries may still refer to the file
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		if err := os.Link(path, target); err == nil {
+			return actual, size, nil
+		}
+	}
+	return actual, size, copyFile(path, target)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func readJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// extractArchive extracts a (gzip compressed) tar to dir.
+// Symlinks are only extracted if they point to a file within dir, like the deduplicated layers of docker save.
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if target == dir {
+			continue
+		}
+		if !withinDir(dir, target) {
+			return fmt.Errorf("tar entry %q is outside of the archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !withinDir(dir, filepath.Join(filepath.Dir(target), filepath.FromSlash(hdr.Linkname))) {
+				return fmt.Errorf("symlink %q points outside of the archive", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func withinDir(dir, path string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
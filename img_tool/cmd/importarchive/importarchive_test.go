@@ -0,0 +1,337 @@
+package importarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tarEntry is a file (or a symlink, if linkname is set) of a test archive.
+type tarEntry struct {
+	name     string
+	data     []byte
+	linkname string
+}
+
+func writeTar(t *testing.T, compress bool, entries ...tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Mode: 0o644, Size: int64(len(entry.data))}
+		if entry.linkname != "" {
+			hdr = &tar.Header{Typeflag: tar.TypeSymlink, Name: entry.name, Linkname: entry.linkname, Mode: 0o777}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if compress {
+		data = gzipBytes(t, data)
+	}
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// layerTar returns an uncompressed layer with a single file.
+func layerTar(t *testing.T, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(name))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func configJSON(t *testing.T, diffIDs ...digest.Digest) []byte {
+	t.Helper()
+	raw, err := json.Marshal(specv1.Image{
+		Platform: specv1.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   specv1.RootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func readManifest(t *testing.T, blobDir string, root digest.Digest) specv1.Manifest {
+	t.Helper()
+	var manifest specv1.Manifest
+	if err := readJSON(filepath.Join(blobDir, root.Encoded()), &manifest); err != nil {
+		t.Fatalf("reading root manifest: %v", err)
+	}
+	return manifest
+}
+
+func TestImportDockerSave(t *testing.T) {
+	layer := layerTar(t, "app")
+	gzipLayer := gzipBytes(t, layerTar(t, "lib"))
+	config := configJSON(t, digest.FromBytes(layer), digest.FromBytes(layerTar(t, "lib")), digest.FromBytes(layer))
+	otherConfig := configJSON(t, digest.FromBytes(layer))
+	archive := writeTar(t, true,
+		tarEntry{name: "manifest.json", data: mustJSON(t, []dockerSaveManifest{
+			{Config: "config.json", RepoTags: []string{"app:latest"}, Layers: []string{"app/layer.tar", "lib/layer.tar", "dedup/layer.tar"}},
+			{Config: "other.json", RepoTags: []string{"other:v1"}, Layers: []string{"app/layer.tar"}},
+		})},
+		tarEntry{name: "config.json", data: config},
+		tarEntry{name: "other.json", data: otherConfig},
+		tarEntry{name: "app/layer.tar", data: layer},
+		tarEntry{name: "lib/layer.tar", data: gzipLayer},
+		// docker save deduplicates layers with symlinks
+		tarEntry{name: "dedup/layer.tar", linkname: "../app/layer.tar"},
+	)
+
+	out := t.TempDir()
+	root, err := importArchive(archive, out, "app:latest")
+	if err != nil {
+		t.Fatalf("importArchive() error = %v", err)
+	}
+	blobDir := filepath.Join(out, "blobs", "sha256")
+	manifest := readManifest(t, blobDir, root)
+	if manifest.MediaType != specv1.MediaTypeImageManifest || manifest.Config.Digest != digest.FromBytes(config) || manifest.Config.MediaType != specv1.MediaTypeImageConfig {
+		t.Errorf("manifest = %+v, want an OCI manifest with the config of the archive", manifest)
+	}
+	wantLayers := []specv1.Descriptor{
+		{MediaType: specv1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))},
+		{MediaType: specv1.MediaTypeImageLayerGzip, Digest: digest.FromBytes(gzipLayer), Size: int64(len(gzipLayer))},
+		{MediaType: specv1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))},
+	}
+	if got, want := mustJSON(t, manifest.Layers), mustJSON(t, wantLayers); !bytes.Equal(got, want) {
+		t.Errorf("layers = %s, want %s", got, want)
+	}
+	for _, blob := range []digest.Digest{manifest.Config.Digest, digest.FromBytes(layer), digest.FromBytes(gzipLayer)} {
+		if _, err := os.Stat(filepath.Join(blobDir, blob.Encoded())); err != nil {
+			t.Errorf("blob %s is missing: %v", blob, err)
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(out, ".import-archive-*")); len(entries) != 0 {
+		t.Errorf("extracted archive was not removed: %v", entries)
+	}
+
+	other, err := importArchive(archive, t.TempDir(), "other:v1")
+	if err != nil {
+		t.Fatalf("importArchive(other:v1) error = %v", err)
+	}
+	if other == root {
+		t.Errorf("importArchive(other:v1) = %s, want the other image", other)
+	}
+}
+
+func TestImportDockerSaveErrors(t *testing.T) {
+	layer := layerTar(t, "app")
+	single := func(config []byte, layers ...string) string {
+		entries := []tarEntry{
+			{name: "manifest.json", data: mustJSON(t, []dockerSaveManifest{{Config: "config.json", RepoTags: []string{"app:latest"}, Layers: layers}})},
+			{name: "config.json", data: config},
+			{name: "layer.tar", data: layer},
+		}
+		return writeTar(t, false, entries...)
+	}
+	two := writeTar(t, false,
+		tarEntry{name: "manifest.json", data: mustJSON(t, []dockerSaveManifest{{Config: "config.json"}, {Config: "config.json"}})},
+	)
+
+	tests := []struct {
+		name    string
+		archive string
+		repoTag string
+		wantErr string
+	}{
+		{name: "ambiguous", archive: two, wantErr: "manifest.json lists 2 images, select one with --repo-tag"},
+		{name: "unknown tag", archive: single(configJSON(t, digest.FromBytes(layer)), "layer.tar"), repoTag: "app:v2", wantErr: `no image of manifest.json is tagged "app:v2"`},
+		{name: "diff ID count", archive: single(configJSON(t), "layer.tar"), wantErr: "config lists 0 diff IDs, but the image has 1 layers"},
+		{name: "diff ID mismatch", archive: single(configJSON(t, digest.FromString("other")), "layer.tar"), wantErr: "layer layer.tar has digest " + digest.FromBytes(layer).String() + ", but the config expects diff ID " + digest.FromString("other").String()},
+		{name: "missing layer", archive: single(configJSON(t, digest.FromBytes(layer)), "missing.tar"), wantErr: "layer missing.tar: open"},
+		{name: "invalid config", archive: single([]byte("{"), "layer.tar"), wantErr: "parsing "},
+		{name: "neither", archive: writeTar(t, false, tarEntry{name: "README", data: []byte("hi")}), wantErr: "neither an OCI archive (missing oci-layout) nor a docker save archive (missing manifest.json)"},
+		{name: "path traversal", archive: writeTar(t, false, tarEntry{name: "../escape", data: []byte("x")}), wantErr: `tar entry "../escape" is outside of the archive`},
+		{name: "escaping symlink", archive: writeTar(t, false, tarEntry{name: "layer.tar", linkname: "../../etc/passwd"}), wantErr: `symlink "layer.tar" points outside of the archive`},
+		{name: "absolute symlink", archive: writeTar(t, false, tarEntry{name: "layer.tar", linkname: "/etc/passwd"}), wantErr: `symlink "layer.tar" points outside of the archive`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := importArchive(tt.archive, t.TempDir(), tt.repoTag)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("importArchive() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// ociLayoutEntries returns the files of an OCI layout with two images, named by the annotations of docker and of the OCI spec.
+func ociLayoutEntries(t *testing.T) ([]tarEntry, digest.Digest, digest.Digest) {
+	t.Helper()
+	layer := layerTar(t, "app")
+	config := configJSON(t, digest.FromBytes(layer))
+	manifestFor := func(layer []byte) []byte {
+		return mustJSON(t, specv1.Manifest{
+			MediaType: specv1.MediaTypeImageManifest,
+			Config:    specv1.Descriptor{MediaType: specv1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+			Layers:    []specv1.Descriptor{{MediaType: specv1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+		})
+	}
+	first := manifestFor(layer)
+	// the second image is shallow: its layer is not part of the layout
+	second := manifestFor(layerTar(t, "missing"))
+	index := mustJSON(t, specv1.Index{Manifests: []specv1.Descriptor{
+		{MediaType: specv1.MediaTypeImageManifest, Digest: digest.FromBytes(first), Size: int64(len(first)), Annotations: map[string]string{containerdImageNameAnnotation: "docker.io/library/app:latest"}},
+		{MediaType: specv1.MediaTypeImageManifest, Digest: digest.FromBytes(second), Size: int64(len(second)), Annotations: map[string]string{specv1.AnnotationRefName: "shallow"}},
+	}})
+	blob := func(data []byte) tarEntry {
+		return tarEntry{name: "blobs/sha256/" + digest.FromBytes(data).Encoded(), data: data}
+	}
+	return []tarEntry{
+		{name: specv1.ImageLayoutFile, data: []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{name: specv1.ImageIndexFile, data: index},
+		blob(config), blob(layer), blob(first), blob(second),
+	}, digest.FromBytes(first), digest.FromBytes(second)
+}
+
+func TestImportOCI(t *testing.T) {
+	entries, first, second := ociLayoutEntries(t)
+	layoutDir := t.TempDir()
+	for _, entry := range entries {
+		path := filepath.Join(layoutDir, filepath.FromSlash(entry.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, entry.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		archive string
+		repoTag string
+		want    digest.Digest
+	}{
+		{name: "archive", archive: writeTar(t, false, entries...), repoTag: "docker.io/library/app:latest", want: first},
+		{name: "compressed archive", archive: writeTar(t, true, entries...), repoTag: "shallow", want: second},
+		{name: "layout directory", archive: layoutDir, repoTag: "shallow", want: second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			root, err := importArchive(tt.archive, out, tt.repoTag)
+			if err != nil {
+				t.Fatalf("importArchive() error = %v", err)
+			}
+			if root != tt.want {
+				t.Errorf("importArchive() = %s, want %s", root, tt.want)
+			}
+			readManifest(t, filepath.Join(out, "blobs", "sha256"), root)
+		})
+	}
+	// blobs of layout directories are linked or copied, never moved
+	if _, err := os.Stat(filepath.Join(layoutDir, "blobs", "sha256", first.Encoded())); err != nil {
+		t.Errorf("importArchive() removed a blob of the layout directory: %v", err)
+	}
+}
+
+func TestImportOCIErrors(t *testing.T) {
+	entries, first, _ := ociLayoutEntries(t)
+	var withoutRoot []tarEntry
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.name, first.Encoded()) {
+			withoutRoot = append(withoutRoot, entry)
+		}
+	}
+	corrupt := append([]tarEntry{}, entries...)
+	corrupt = append(corrupt, tarEntry{name: "blobs/sha256/" + digest.FromString("expected").Encoded(), data: []byte("actual")})
+
+	tests := []struct {
+		name    string
+		archive string
+		repoTag string
+		wantErr string
+	}{
+		{name: "ambiguous", archive: writeTar(t, false, entries...), wantErr: "index.json lists 2 manifests, select one with --repo-tag"},
+		{name: "unknown name", archive: writeTar(t, false, entries...), repoTag: "other", wantErr: `no manifest of index.json is annotated with "other"`},
+		{name: "missing root", archive: writeTar(t, false, withoutRoot...), repoTag: "docker.io/library/app:latest", wantErr: "root blob " + first.String() + " of index.json is missing from the archive"},
+		{name: "corrupt blob", archive: writeTar(t, false, corrupt...), repoTag: "shallow", wantErr: "has digest " + digest.FromString("actual").String()},
+		{name: "directory without layout", archive: t.TempDir(), wantErr: "directory is not an OCI layout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := importArchive(tt.archive, t.TempDir(), tt.repoTag)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("importArchive() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLayerMediaType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "tar", data: layerTar(t, "app"), want: specv1.MediaTypeImageLayer},
+		{name: "gzip", data: gzipBytes(t, layerTar(t, "app")), want: specv1.MediaTypeImageLayerGzip},
+		{name: "zstd", data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, want: specv1.MediaTypeImageLayerZstd},
+		{name: "short", data: []byte{0x1f}, want: specv1.MediaTypeImageLayer},
+		{name: "empty", data: nil, want: specv1.MediaTypeImageLayer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "layer")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := layerMediaType(path)
+			if err != nil || got != tt.want {
+				t.Errorf("layerMediaType() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -71,3 +71,68 @@ func (a *annotations) Set(value string) error {
 	(*a)[kv[0]] = kv[1]
 	return nil
 }
+
+// platformValues maps a platform (os/architecture) to a value,
+// given as os/architecture=value.
+type platformValues map[string]string
+
+func (p *platformValues) String() string {
+	if p == nil {
+		return ""
+	}
+	return (*annotations)(p).String()
+}
+
+func (p *platformValues) Set(value string) error {
+	if *p == nil {
+		*p = make(platformValues)
+	}
+	platform, v, err := splitPlatformValue(value)
+	if err != nil {
+		return err
+	}
+	(*p)[platform] = v
+	return nil
+}
+
+// platformLists maps a platform (os/architecture) to a list of values,
+// given as os/architecture=value (repeatable).
+type platformLists map[string][]string
+
+func (p *platformLists) String() string {
+	if p == nil {
+		return ""
+	}
+	keys := slices.Collect(maps.Keys(*p))
+	slices.Sort(keys)
+	var sb strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(key)
+		sb.WriteString("=")
+		sb.WriteString(strings.Join((*p)[key], ","))
+	}
+	return sb.String()
+}
+
+func (p *platformLists) Set(value string) error {
+	if *p == nil {
+		*p = make(platformLists)
+	}
+	platform, v, err := splitPlatformValue(value)
+	if err != nil {
+		return err
+	}
+	(*p)[platform] = append((*p)[platform], v)
+	return nil
+}
+
+func splitPlatformValue(value string) (platform, v string, err error) {
+	platform, v, ok := strings.Cut(value, "=")
+	if !ok || strings.Count(platform, "/") != 1 {
+		return "", "", fmt.Errorf("expected os/architecture=value, but got %s", value)
+	}
+	return platform, v, nil
+}
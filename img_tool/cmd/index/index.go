@@ -6,20 +6,57 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 
 	specs "github.com/opencontainers/image-spec/specs-go"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
-)
 
-var (
-	manifestDescriptorArgs manifestDescriptors
-	annotationArgs         annotations
-	configTemplates        string
-	digestOutput           string
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/subject"
 )
 
+// Config holds the options of an index invocation.
+type Config struct {
+	// Manifests are the descriptors of the manifests in the index.
+	Manifests   []specsv1.Descriptor
+	Annotations map[string]string
+	// ConfigTemplates is a JSON file with template-expanded annotations.
+	// If set, its annotations replace Annotations.
+	ConfigTemplates string
+	// Variants, OSVersions and OSFeatures override the platform fields
+	// of the manifests, keyed by os/architecture.
+	Variants   map[string]string
+	OSVersions map[string]string
+	OSFeatures map[string][]string
+	// ManifestAnnotations override the annotations of the manifest descriptors,
+	// keyed by os/architecture, given as key=value (an empty value removes the annotation).
+	ManifestAnnotations map[string][]string
+	// DescriptorValidation is ValidationStrict or ValidationWarn.
+	// Descriptors are passed through unchanged (apart from the platform overrides) in both modes.
+	DescriptorValidation string
+	// Subject is a raw image manifest or image index referenced as the subject of the index.
+	Subject string
+	// Output is the path of the image index.
+	Output string
+	// DigestOutput optionally receives the digest of the index.
+	DigestOutput string
+}
+
+// Runner creates an image index.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
 func IndexProcess(ctx context.Context, args []string) {
+	cfg := Config{DescriptorValidation: ValidationStrict}
 	flagSet := flag.NewFlagSet("index", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Creates an image index based on a list of manifests.\n\n")
@@ -27,6 +64,10 @@ func IndexProcess(ctx context.Context, args []string) {
 		flagSet.PrintDefaults()
 		examples := []string{
 			"img index --manifest-descriptor image_linux_amd64.json --manifest-descriptor image_linux_aarch64.json index.json",
+			"img index --manifest-descriptor image_linux_arm.json --variant linux/arm=v7 index.json",
+			"img index --manifest-descriptor image_windows_amd64.json --os-version windows/amd64=10.0.17763.5329 --os-feature windows/amd64=win32k index.json",
+			"img index --manifest-descriptor image_linux_amd64.json --manifest-descriptor attestation.json --descriptor-validation warn index.json",
+			"img index --manifest-descriptor image_linux_amd64.json --manifest-annotation linux/amd64=org.opencontainers.image.ref.name=v1-amd64 index.json",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -34,10 +75,17 @@ func IndexProcess(ctx context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.Var(&manifestDescriptorArgs, "manifest-descriptor", `File containing a descriptor for a manifest.`)
-	flagSet.Var(&annotationArgs, "annotation", `Key-value pair to add as an annotation`)
-	flagSet.StringVar(&configTemplates, "config-templates", "", `A JSON file containing template-expanded annotations values.`)
-	flagSet.StringVar(&digestOutput, "digest", "", `The (optional) output file for the digest of the manifest. This is useful for postprocessing.`)
+	flagSet.Var((*manifestDescriptors)(&cfg.Manifests), "manifest-descriptor", `File containing a descriptor for a manifest.`)
+	flagSet.Var((*annotations)(&cfg.Annotations), "annotation", `Key-value pair to add as an annotation`)
+	flagSet.StringVar(&cfg.ConfigTemplates, "config-templates", "", `A JSON file containing template-expanded annotations values.`)
+	flagSet.StringVar(&cfg.DigestOutput, "digest", "", `The (optional) output file for the digest of the manifest. This is useful for postprocessing.`)
+	flagSet.Var((*platformValues)(&cfg.Variants), "variant", `Set the platform variant of the manifests for a platform, given as os/architecture=variant (e.g. linux/arm=v7). Can be specified multiple times.`)
+	flagSet.Var((*platformValues)(&cfg.OSVersions), "os-version", `Set the platform os.version of the manifests for a platform, given as os/architecture=version (e.g. windows/amd64=10.0.17763.5329). Can be specified multiple times.`)
+	flagSet.Var((*platformLists)(&cfg.OSFeatures), "os-feature", `Add to the platform os.features of the manifests for a platform, given as os/architecture=feature (e.g. windows/amd64=win32k). Can be specified multiple times.`)
+	flagSet.Var((*platformLists)(&cfg.ManifestAnnotations), "manifest-annotation", `Set an annotation of the manifest descriptors for a platform, given as os/architecture=key=value (e.g. linux/amd64=org.opencontainers.image.ref.name=v1). An empty value removes the annotation. Overrides the annotations that the descriptors got from their manifests. Can be specified multiple times.`)
+	flagSet.StringVar(&cfg.DescriptorValidation, "descriptor-validation", ValidationStrict, `How problems with manifest descriptors (like a missing, unknown or duplicate platform) are handled. "strict" fails, "warn" prints a warning and passes the descriptors through unchanged.`)
+	flagSet.StringVar(&cfg.Subject, "subject", "", `A raw image manifest or image index to reference as the subject of the index (OCI referrers API).`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -48,54 +96,171 @@ func IndexProcess(ctx context.Context, args []string) {
 		flagSet.Usage()
 		os.Exit(1)
 	}
+	cfg.Output = flagSet.Arg(0)
 
-	indexPath := flagSet.Arg(0)
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing index", logging.ErrKey, err)
+	}
+}
 
+// Run writes the image index (and its digest) to the configured outputs.
+func (r *Runner) Run(_ context.Context) error {
 	// Read config templates if provided
 	var templatesData *ConfigTemplates
-	if configTemplates != "" {
+	if r.cfg.ConfigTemplates != "" {
 		var err error
-		templatesData, err = readConfigTemplates(configTemplates)
+		templatesData, err = readConfigTemplates(r.cfg.ConfigTemplates)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read config templates: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("reading config templates: %w", err)
 		}
 	}
 
 	// Use template annotations if available, otherwise use command line annotations
-	annotations := map[string]string(annotationArgs)
+	annotations := r.cfg.Annotations
 	if templatesData != nil && templatesData.Annotations != nil {
 		annotations = templatesData.Annotations
 	}
 
+	manifests := slices.Clone(r.cfg.Manifests)
+	if err := applyPlatformOverrides(manifests, r.cfg.Variants, r.cfg.OSVersions, r.cfg.OSFeatures); err != nil {
+		return fmt.Errorf("setting platform fields: %w", err)
+	}
+	if err := applyManifestAnnotations(manifests, r.cfg.ManifestAnnotations); err != nil {
+		return fmt.Errorf("setting manifest annotations: %w", err)
+	}
+	validation := r.cfg.DescriptorValidation
+	if validation == "" {
+		validation = ValidationStrict
+	}
+	if err := validateDescriptors(manifests, validation, os.Stderr); err != nil {
+		return err
+	}
+
 	index := specsv1.Index{
 		Versioned: specs.Versioned{
 			SchemaVersion: 2,
 		},
 		MediaType:   specsv1.MediaTypeImageIndex,
-		Manifests:   []specsv1.Descriptor(manifestDescriptorArgs),
+		Manifests:   manifests,
 		Annotations: annotations,
 	}
 
+	if r.cfg.Subject != "" {
+		subjectDescriptor, err := subject.ReadDescriptor(r.cfg.Subject)
+		if err != nil {
+			return fmt.Errorf("reading subject: %w", err)
+		}
+		index.Subject = subjectDescriptor
+	}
+
 	rawIndex, err := json.Marshal(index)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal image index: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("marshaling image index: %w", err)
 	}
 
-	if err := os.WriteFile(indexPath, rawIndex, 0o644); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to write image index to %s: %v\n", indexPath, err)
-		os.Exit(1)
+	if err := os.WriteFile(r.cfg.Output, rawIndex, 0o644); err != nil {
+		return fmt.Errorf("writing image index to %s: %w", r.cfg.Output, err)
 	}
 
-	if digestOutput != "" {
+	if r.cfg.DigestOutput != "" {
 		digest := sha256.Sum256(rawIndex)
 
-		if err := os.WriteFile(digestOutput, []byte(fmt.Sprintf("sha256:%x", digest[:])), 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write digest to %s: %v\n", digestOutput, err)
-			os.Exit(1)
+		if err := os.WriteFile(r.cfg.DigestOutput, []byte(fmt.Sprintf("sha256:%x", digest[:])), 0o644); err != nil {
+			return fmt.Errorf("writing digest to %s: %w", r.cfg.DigestOutput, err)
 		}
 	}
+	return nil
+}
+
+// applyPlatformOverrides sets the variant, os.version, and os.features of the platform
+// of every manifest matching the os/architecture the values are given for.
+// The values take precedence over the values derived from the image config.
+func applyPlatformOverrides(manifests []specsv1.Descriptor, variants, osVersions map[string]string, osFeatures map[string][]string) error {
+	unused := make(map[string]struct{})
+	for platform := range variants {
+		unused[platform] = struct{}{}
+	}
+	for platform := range osVersions {
+		unused[platform] = struct{}{}
+	}
+	for platform := range osFeatures {
+		unused[platform] = struct{}{}
+	}
+
+	for i := range manifests {
+		if manifests[i].Platform == nil {
+			continue
+		}
+		// the descriptors may be shared with other indexes, so we modify a copy
+		platform := *manifests[i].Platform
+		key := platform.OS + "/" + platform.Architecture
+		if variant, ok := variants[key]; ok {
+			platform.Variant = variant
+		}
+		if osVersion, ok := osVersions[key]; ok {
+			platform.OSVersion = osVersion
+		}
+		if features, ok := osFeatures[key]; ok {
+			platform.OSFeatures = slices.Clone(features)
+		}
+		delete(unused, key)
+		manifests[i].Platform = &platform
+	}
+
+	if len(unused) > 0 {
+		platforms := slices.Sorted(maps.Keys(unused))
+		return fmt.Errorf("no manifest for platform(s) %s", strings.Join(platforms, ", "))
+	}
+	return nil
+}
+
+// applyManifestAnnotations sets the annotations of the descriptors of every manifest
+// matching the os/architecture the annotations are given for.
+// Descriptors carry the annotations of their manifests (see "img manifest --descriptor"),
+// the annotations given here take precedence, and empty values remove annotations.
+func applyManifestAnnotations(manifests []specsv1.Descriptor, annotations map[string][]string) error {
+	unused := make(map[string]struct{})
+	for platform := range annotations {
+		unused[platform] = struct{}{}
+	}
+
+	for i := range manifests {
+		if manifests[i].Platform == nil {
+			continue
+		}
+		key := manifests[i].Platform.OS + "/" + manifests[i].Platform.Architecture
+		values, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		delete(unused, key)
+		// the descriptors may be shared with other indexes, so we modify a copy
+		merged := maps.Clone(manifests[i].Annotations)
+		if merged == nil {
+			merged = make(map[string]string)
+		}
+		for _, value := range values {
+			k, v, ok := strings.Cut(value, "=")
+			if !ok || k == "" {
+				return fmt.Errorf("expected annotation of %s as key=value, but got %s", key, value)
+			}
+			if v == "" {
+				delete(merged, k)
+				continue
+			}
+			merged[k] = v
+		}
+		if len(merged) == 0 {
+			merged = nil
+		}
+		manifests[i].Annotations = merged
+	}
+
+	if len(unused) > 0 {
+		platforms := slices.Sorted(maps.Keys(unused))
+		return fmt.Errorf("no manifest for platform(s) %s", strings.Join(platforms, ", "))
+	}
+	return nil
 }
 
 // ConfigTemplates represents the structure of the config templates JSON file
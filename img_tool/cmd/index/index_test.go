@@ -0,0 +1,162 @@
+package index
+
+import (
+	"strings"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestApplyPlatformOverrides(t *testing.T) {
+	arm := &specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}
+	windows := &specsv1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1", OSFeatures: []string{"old"}}
+	manifests := []specsv1.Descriptor{
+		{Digest: "sha256:arm", Platform: arm},
+		{Digest: "sha256:windows", Platform: windows},
+		{Digest: "sha256:amd64", Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:noplatform"},
+	}
+	err := applyPlatformOverrides(manifests,
+		map[string]string{"linux/arm": "v7"},
+		map[string]string{"windows/amd64": "10.0.17763.5329"},
+		map[string][]string{"windows/amd64": {"win32k", "other"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := manifests[0].Platform; got.Variant != "v7" || got.OSVersion != "" {
+		t.Errorf("linux/arm platform = %+v, want variant v7", got)
+	}
+	if got := manifests[1].Platform; got.OSVersion != "10.0.17763.5329" || strings.Join(got.OSFeatures, ",") != "win32k,other" || got.Variant != "" {
+		t.Errorf("windows/amd64 platform = %+v, want the os.version and os.features of the flags", got)
+	}
+	if got := manifests[2].Platform; got.Variant != "" || got.OSVersion != "" || got.OSFeatures != nil {
+		t.Errorf("linux/amd64 platform = %+v, want it unchanged", got)
+	}
+	if manifests[3].Platform != nil {
+		t.Errorf("descriptor without platform got platform %+v", manifests[3].Platform)
+	}
+	// the platforms of the input descriptors are not modified
+	if arm.Variant != "v6" || windows.OSVersion != "10.0.17763.1" || windows.OSFeatures[0] != "old" {
+		t.Errorf("input platforms were modified: %+v, %+v", arm, windows)
+	}
+}
+
+func TestApplyPlatformOverridesUnknownPlatform(t *testing.T) {
+	manifests := []specsv1.Descriptor{
+		{Digest: "sha256:amd64", Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+	}
+	err := applyPlatformOverrides(manifests,
+		map[string]string{"linux/arm": "v7"},
+		map[string]string{"linux/amd64": "1"},
+		map[string][]string{"windows/amd64": {"win32k"}},
+	)
+	if err == nil || err.Error() != "no manifest for platform(s) linux/arm, windows/amd64" {
+		t.Errorf("applyPlatformOverrides() error = %v, want the platforms without manifest", err)
+	}
+}
+
+func TestApplyManifestAnnotations(t *testing.T) {
+	amd64Annotations := map[string]string{
+		"org.opencontainers.image.ref.name": "latest",
+		"org.opencontainers.image.version":  "1.0",
+	}
+	manifests := []specsv1.Descriptor{
+		{Digest: "sha256:amd64", Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}, Annotations: amd64Annotations},
+		{Digest: "sha256:arm64", Platform: &specsv1.Platform{OS: "linux", Architecture: "arm64"}},
+		{Digest: "sha256:arm", Platform: &specsv1.Platform{OS: "linux", Architecture: "arm"}, Annotations: map[string]string{"removed": "yes"}},
+		{Digest: "sha256:noplatform", Annotations: map[string]string{"kept": "yes"}},
+	}
+	err := applyManifestAnnotations(manifests, map[string][]string{
+		"linux/amd64": {"org.opencontainers.image.ref.name=v1-amd64", "org.opencontainers.image.version=", "custom=a=b"},
+		"linux/arm64": {"org.opencontainers.image.ref.name=v1-arm64"},
+		"linux/arm":   {"removed="},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := manifests[0].Annotations; len(got) != 2 || got["org.opencontainers.image.ref.name"] != "v1-amd64" || got["custom"] != "a=b" {
+		t.Errorf("linux/amd64 annotations = %v, want the overridden ref name and custom=a=b without the version", got)
+	}
+	if got := manifests[1].Annotations; len(got) != 1 || got["org.opencontainers.image.ref.name"] != "v1-arm64" {
+		t.Errorf("linux/arm64 annotations = %v, want the ref name", got)
+	}
+	if got := manifests[2].Annotations; got != nil {
+		t.Errorf("linux/arm annotations = %v, want nil after removing the only annotation", got)
+	}
+	if got := manifests[3].Annotations; len(got) != 1 || got["kept"] != "yes" {
+		t.Errorf("annotations of a descriptor without platform = %v, want them unchanged", got)
+	}
+	// the annotations of the input descriptors are not modified
+	if amd64Annotations["org.opencontainers.image.ref.name"] != "latest" || amd64Annotations["org.opencontainers.image.version"] != "1.0" {
+		t.Errorf("input annotations were modified: %v", amd64Annotations)
+	}
+}
+
+func TestApplyManifestAnnotationsErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string][]string
+		wantErr     string
+	}{
+		{
+			name:        "unknown platform",
+			annotations: map[string][]string{"linux/arm": {"a=b"}, "windows/amd64": {"a=b"}},
+			wantErr:     "no manifest for platform(s) linux/arm, windows/amd64",
+		},
+		{
+			name:        "missing value",
+			annotations: map[string][]string{"linux/amd64": {"org.opencontainers.image.ref.name"}},
+			wantErr:     "expected annotation of linux/amd64 as key=value, but got org.opencontainers.image.ref.name",
+		},
+		{
+			name:        "empty key",
+			annotations: map[string][]string{"linux/amd64": {"=v1"}},
+			wantErr:     "expected annotation of linux/amd64 as key=value, but got =v1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := []specsv1.Descriptor{
+				{Digest: "sha256:amd64", Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+			}
+			err := applyManifestAnnotations(manifests, tt.annotations)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("applyManifestAnnotations() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlatformFlags(t *testing.T) {
+	var values platformValues
+	for _, arg := range []string{"linux/arm=v6", "linux/arm=v7", "windows/amd64=10.0=1"} {
+		if err := values.Set(arg); err != nil {
+			t.Fatalf("Set(%q): %v", arg, err)
+		}
+	}
+	if got := values.String(); got != "linux/arm=v7, windows/amd64=10.0=1" {
+		t.Errorf("platformValues = %q", got)
+	}
+
+	var lists platformLists
+	for _, arg := range []string{"windows/amd64=win32k", "windows/amd64=", "linux/amd64=a"} {
+		if err := lists.Set(arg); err != nil {
+			t.Fatalf("Set(%q): %v", arg, err)
+		}
+	}
+	if got := lists.String(); got != "linux/amd64=a, windows/amd64=win32k," {
+		t.Errorf("platformLists = %q", got)
+	}
+
+	for _, arg := range []string{"linux=v7", "linux/arm/v7=v7", "linux/arm"} {
+		if err := values.Set(arg); err == nil || !strings.Contains(err.Error(), "expected os/architecture=value") {
+			t.Errorf("platformValues.Set(%q) error = %v", arg, err)
+		}
+		if err := lists.Set(arg); err == nil || !strings.Contains(err.Error(), "expected os/architecture=value") {
+			t.Errorf("platformLists.Set(%q) error = %v", arg, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// Descriptor validation modes.
+const (
+	// ValidationStrict fails if a manifest descriptor has problems.
+	ValidationStrict = "strict"
+	// ValidationWarn reports problems of manifest descriptors as warnings
+	// and passes the descriptors through unchanged.
+	ValidationWarn = "warn"
+)
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var (
+	manifestMediaTypes = []string{
+		specsv1.MediaTypeImageManifest,
+		specsv1.MediaTypeImageIndex,
+		dockerManifestMediaType,
+		dockerManifestListMediaType,
+	}
+)
+
+// validateDescriptors checks the manifest descriptors of an index.
+// Indexes produced by other tools often contain descriptors that rules_img wouldn't create itself,
+// like attestation manifests with an "unknown/unknown" platform or several manifests for the same platform.
+// In strict mode, these are errors. In warn mode, they are written to warnings and the descriptors are kept as they are.
+func validateDescriptors(manifests []specsv1.Descriptor, mode string, warnings io.Writer) error {
+	if mode != ValidationStrict && mode != ValidationWarn {
+		return fmt.Errorf("invalid descriptor validation mode %q (expected %q or %q)", mode, ValidationStrict, ValidationWarn)
+	}
+	var problems []string
+	seen := make(map[string]int)
+	for i, desc := range manifests {
+		where := fmt.Sprintf("manifests[%d] (%s)", i, desc.Digest)
+		if !slices.Contains(manifestMediaTypes, desc.MediaType) {
+			problems = append(problems, fmt.Sprintf("%s: mediaType %q is not an image manifest or image index media type", where, desc.MediaType))
+		}
+		if !api.DigestPattern.MatchString(desc.Digest.String()) {
+			problems = append(problems, fmt.Sprintf("%s: digest %q does not match the OCI digest grammar", where, desc.Digest))
+		}
+		if desc.Size <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: size must be positive, got %d", where, desc.Size))
+		}
+		for key := range desc.Annotations {
+			if key == "" {
+				problems = append(problems, fmt.Sprintf("%s: annotation keys must not be empty", where))
+			}
+		}
+		platform := desc.Platform
+		switch {
+		case platform == nil:
+			problems = append(problems, fmt.Sprintf("%s: platform is missing", where))
+			continue
+		case platform.OS == "" || platform.Architecture == "":
+			problems = append(problems, fmt.Sprintf("%s: platform.os and platform.architecture are required", where))
+			continue
+		case platform.OS == "unknown" || platform.Architecture == "unknown":
+			problems = append(problems, fmt.Sprintf("%s: platform %s is unknown (e.g., an attestation manifest)", where, platformString(platform)))
+			continue
+		}
+		key := platformString(platform)
+		if first, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s: platform %s is already used by manifests[%d]", where, key, first))
+			continue
+		}
+		seen[key] = i
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if mode == ValidationWarn {
+		for _, problem := range problems {
+			fmt.Fprintf(warnings, "Warning: %s\n", problem)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid manifest descriptors (use --descriptor-validation=%s to pass them through):\n  %s", ValidationWarn, strings.Join(problems, "\n  "))
+}
+
+// platformString formats all fields of a platform that distinguish manifests of an index.
+func platformString(platform *specsv1.Platform) string {
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	if platform.OSVersion != "" {
+		s += " (os.version " + platform.OSVersion + ")"
+	}
+	if len(platform.OSFeatures) > 0 {
+		s += " (os.features " + strings.Join(platform.OSFeatures, ",") + ")"
+	}
+	return s
+}
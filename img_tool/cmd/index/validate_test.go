@@ -0,0 +1,72 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	digestA = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	digestB = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestValidateDescriptors(t *testing.T) {
+	valid := []specsv1.Descriptor{
+		{MediaType: specsv1.MediaTypeImageManifest, Digest: digestA, Size: 401, Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+		{MediaType: dockerManifestMediaType, Digest: digestB, Size: 402, Platform: &specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		// the same os/architecture with another variant is a different platform
+		{MediaType: specsv1.MediaTypeImageIndex, Digest: digestB, Size: 403, Platform: &specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+	}
+	for _, mode := range []string{ValidationStrict, ValidationWarn} {
+		var warnings bytes.Buffer
+		if err := validateDescriptors(valid, mode, &warnings); err != nil || warnings.Len() > 0 {
+			t.Errorf("validateDescriptors(%s) of valid descriptors = %v, warnings %q", mode, err, warnings.String())
+		}
+	}
+
+	invalid := []specsv1.Descriptor{
+		{MediaType: specsv1.MediaTypeImageManifest, Digest: digestA, Size: 401, Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+		{MediaType: specsv1.MediaTypeImageManifest, Digest: digestB, Size: 402, Platform: &specsv1.Platform{OS: "linux", Architecture: "amd64"}},
+		{MediaType: specsv1.MediaTypeImageManifest, Digest: digestB, Size: 402, Platform: &specsv1.Platform{OS: "unknown", Architecture: "unknown"}},
+		{MediaType: specsv1.MediaTypeImageLayer, Digest: "not-a-digest", Size: 0, Annotations: map[string]string{"": "x"}},
+		{MediaType: specsv1.MediaTypeImageManifest, Digest: digestA, Size: 401, Platform: &specsv1.Platform{OS: "linux"}},
+	}
+	wantProblems := []string{
+		"manifests[1] (" + digestB + "): platform linux/amd64 is already used by manifests[0]",
+		"manifests[2] (" + digestB + "): platform unknown/unknown is unknown (e.g., an attestation manifest)",
+		`manifests[3] (not-a-digest): mediaType "application/vnd.oci.image.layer.v1.tar" is not an image manifest or image index media type`,
+		`manifests[3] (not-a-digest): digest "not-a-digest" does not match the OCI digest grammar`,
+		"manifests[3] (not-a-digest): size must be positive, got 0",
+		"manifests[3] (not-a-digest): annotation keys must not be empty",
+		"manifests[3] (not-a-digest): platform is missing",
+		"manifests[4] (" + digestA + "): platform.os and platform.architecture are required",
+	}
+
+	err := validateDescriptors(invalid, ValidationStrict, nil)
+	want := "invalid manifest descriptors (use --descriptor-validation=warn to pass them through):\n  " + strings.Join(wantProblems, "\n  ")
+	if err == nil || err.Error() != want {
+		t.Errorf("validateDescriptors(strict) error = %v, want %s", err, want)
+	}
+
+	var warnings bytes.Buffer
+	if err := validateDescriptors(invalid, ValidationWarn, &warnings); err != nil {
+		t.Errorf("validateDescriptors(warn) error = %v", err)
+	}
+	if want := "Warning: " + strings.Join(wantProblems, "\nWarning: ") + "\n"; warnings.String() != want {
+		t.Errorf("warnings = %q, want %q", warnings.String(), want)
+	}
+
+	if err := validateDescriptors(valid, "lenient", nil); err == nil || !strings.Contains(err.Error(), `invalid descriptor validation mode "lenient"`) {
+		t.Errorf("validateDescriptors(lenient) error = %v", err)
+	}
+}
+
+func TestPlatformString(t *testing.T) {
+	platform := &specsv1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5329", OSFeatures: []string{"win32k"}}
+	if got, want := platformString(platform), "windows/amd64 (os.version 10.0.17763.5329) (os.features win32k)"; got != want {
+		t.Errorf("platformString() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package layer
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+)
+
+// maxBudgetContributors is the number of inputs and files listed when a layer exceeds its budget.
+const maxBudgetContributors = 10
+
+// checkBudget returns an error listing the biggest contributors
+// if the layer exceeds the configured maximum (compressed) size or number of entries.
+// The compressed size is only known after the layer was written completely.
+func (v *layerValidator) checkBudget(compressedSize int64) error {
+	entries := 0
+	for _, e := range v.entries {
+		entries += len(e)
+	}
+	var exceeded []string
+	if v.maxSize > 0 && compressedSize > int64(v.maxSize) {
+		exceeded = append(exceeded, fmt.Sprintf("compressed size %s is more than the maximum of %s", humansize.Format(compressedSize), humansize.Format(int64(v.maxSize))))
+	}
+	if v.maxEntries > 0 && entries > v.maxEntries {
+		exceeded = append(exceeded, fmt.Sprintf("%d entries are more than the maximum of %d", entries, v.maxEntries))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(exceeded, ", ") + v.contributors())
+}
+
+// contributors describes the inputs and files that contribute the most (uncompressed) bytes to the layer.
+func (v *layerValidator) contributors() string {
+	type contribution struct {
+		name    string
+		size    int64
+		entries int
+	}
+	bySource := make(map[string]*contribution)
+	var files []contribution
+	for name, entries := range v.entries {
+		for _, entry := range entries {
+			c, ok := bySource[entry.source]
+			if !ok {
+				c = &contribution{name: entry.source}
+				bySource[entry.source] = c
+			}
+			c.entries++
+			if entry.typeflag == tar.TypeReg {
+				c.size += entry.size
+				files = append(files, contribution{name: fmt.Sprintf("%s (from %s)", name, entry.source), size: entry.size})
+			}
+		}
+	}
+	sources := make([]contribution, 0, len(bySource))
+	for _, c := range bySource {
+		sources = append(sources, *c)
+	}
+	bySize := func(a, b contribution) int {
+		if a.size != b.size {
+			if a.size > b.size {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.name, b.name)
+	}
+	slices.SortFunc(sources, bySize)
+	slices.SortFunc(files, bySize)
+
+	var sb strings.Builder
+	sb.WriteString("\nBiggest inputs (uncompressed):")
+	for _, c := range sources[:min(len(sources), maxBudgetContributors)] {
+		fmt.Fprintf(&sb, "\n  %10s in %d entries: %s", humansize.Format(c.size), c.entries, c.name)
+	}
+	if len(files) > 0 {
+		sb.WriteString("\nBiggest files (uncompressed):")
+		for _, c := range files[:min(len(files), maxBudgetContributors)] {
+			fmt.Fprintf(&sb, "\n  %10s %s", humansize.Format(c.size), c.name)
+		}
+	}
+	return sb.String()
+}
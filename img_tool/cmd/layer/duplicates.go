@@ -0,0 +1,113 @@
+package layer
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Policies for paths in the image that are provided by more than one file.
+// This happens if a label in srcs provides several files (like a filegroup),
+// or if two keys of srcs only differ by the leading slash.
+const (
+	// duplicatePathsRename adds the basename of each file to the path in the image.
+	duplicatePathsRename = "rename"
+	// duplicatePathsError fails the layer creation.
+	duplicatePathsError = "error"
+	// duplicatePathsLastWins only keeps the file that is added last.
+	duplicatePathsLastWins = "last-wins"
+)
+
+// resolveDuplicatePaths applies the duplicate path policy to the files and executables of a layer.
+// Labels are optional and only used to make diagnostics point to the srcs entries that conflict.
+func resolveDuplicatePaths(policy string, files addFiles, execs executables, labels srcLabelsFlag) (addFiles, executables, error) {
+	if policy != duplicatePathsRename && policy != duplicatePathsError && policy != duplicatePathsLastWins {
+		return nil, nil, fmt.Errorf("invalid duplicate path policy %q (expected %q, %q or %q)", policy, duplicatePathsError, duplicatePathsRename, duplicatePathsLastWins)
+	}
+	// files are written before executables, so this is also the order in which "last" is decided.
+	sources := make(map[string][]string)
+	var duplicates []string
+	for _, op := range files {
+		sources[op.PathInImage] = append(sources[op.PathInImage], op.File)
+	}
+	for _, op := range execs {
+		sources[op.PathInImage] = append(sources[op.PathInImage], op.Executable)
+	}
+	for pathInImage, files := range sources {
+		if len(files) > 1 {
+			duplicates = append(duplicates, pathInImage)
+		}
+	}
+	if len(duplicates) == 0 {
+		return files, execs, nil
+	}
+	slices.Sort(duplicates)
+
+	switch policy {
+	case duplicatePathsRename:
+		for i, op := range files {
+			if len(sources[op.PathInImage]) > 1 {
+				files[i].PathInImage = renamedPath(op.PathInImage, op.File)
+			}
+		}
+		for i, op := range execs {
+			if len(sources[op.PathInImage]) > 1 {
+				execs[i].PathInImage = renamedPath(op.PathInImage, op.Executable)
+			}
+		}
+		return files, execs, nil
+	case duplicatePathsError:
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d path(s) in the image are provided by more than one file:", len(duplicates))
+		for _, pathInImage := range duplicates {
+			fmt.Fprintf(&sb, "\n  /%s (from %s):", pathInImage, describeLabels(labels[pathInImage]))
+			for _, file := range sources[pathInImage] {
+				fmt.Fprintf(&sb, "\n    %s (would be /%s with policy %q)", file, renamedPath(pathInImage, file), duplicatePathsRename)
+			}
+		}
+		fmt.Fprintf(&sb, "\nUse a separate path in the image for each file or set the duplicate path policy to %q or %q.", duplicatePathsRename, duplicatePathsLastWins)
+		return nil, nil, fmt.Errorf("%s", sb.String())
+	default: // duplicatePathsLastWins
+		for _, pathInImage := range duplicates {
+			winner := sources[pathInImage][len(sources[pathInImage])-1]
+			slog.Warn("Path in the image is provided by more than one file",
+				"path", "/"+pathInImage, "from", describeLabels(labels[pathInImage]), "using", winner, "dropping", strings.Join(sources[pathInImage][:len(sources[pathInImage])-1], ", "))
+		}
+		// keep the last operation for each path
+		remaining := make(map[string]int, len(sources))
+		for pathInImage, files := range sources {
+			remaining[pathInImage] = len(files)
+		}
+		keep := func(pathInImage string) bool {
+			remaining[pathInImage]--
+			return remaining[pathInImage] == 0
+		}
+		var keptFiles addFiles
+		for _, op := range files {
+			if keep(op.PathInImage) {
+				keptFiles = append(keptFiles, op)
+			}
+		}
+		var keptExecs executables
+		for _, op := range execs {
+			if keep(op.PathInImage) {
+				keptExecs = append(keptExecs, op)
+			}
+		}
+		return keptFiles, keptExecs, nil
+	}
+}
+
+// renamedPath is the path in the image a file gets with the rename policy.
+func renamedPath(pathInImage, file string) string {
+	return fmt.Sprintf("%s/%s", pathInImage, filepath.Base(file))
+}
+
+func describeLabels(labels []string) string {
+	if len(labels) == 0 {
+		return "unknown label"
+	}
+	return strings.Join(labels, ", ")
+}
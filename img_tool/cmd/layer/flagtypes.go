@@ -5,9 +5,13 @@ import (
 	"io/fs"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/filter"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/observer"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/treeartifact"
 )
 
@@ -121,6 +125,12 @@ type executable struct {
 	PathInImage           string
 	Executable            string
 	RunfilesParameterFile string
+	// includeRunfile selects the runfiles (by path in the runfiles tree) that are written. nil writes all runfiles.
+	includeRunfile func(runfilesPath string) bool
+	// runfilesOnly writes the runfiles tree without the executable itself.
+	runfilesOnly bool
+	// runfilesLayout is the layout of the runfiles tree in the image.
+	runfilesLayout tree.RunfilesLayout
 }
 
 type runfilesForExecutables []runfilesForExecutable
@@ -257,6 +267,38 @@ func (m *contentManifests) Set(value string) error {
 	return nil
 }
 
+// filtersFlag implements flag.Value for content filters
+type filtersFlag filter.Pipeline
+
+func (f *filtersFlag) String() string {
+	return fmt.Sprintf("%d filters", len(*f))
+}
+
+func (f *filtersFlag) Set(value string) error {
+	contentFilter, err := filter.New(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, contentFilter)
+	return nil
+}
+
+// observersFlag implements flag.Value for layer observers
+type observersFlag []observer.Observer
+
+func (o *observersFlag) String() string {
+	return fmt.Sprintf("%d observers", len(*o))
+}
+
+func (o *observersFlag) Set(value string) error {
+	layerObserver, err := observer.New(value)
+	if err != nil {
+		return err
+	}
+	*o = append(*o, layerObserver)
+	return nil
+}
+
 // annotationsFlag implements flag.Value for key-value pairs
 type annotationsFlag map[string]string
 
@@ -316,3 +358,165 @@ func (f fileMetadataFlag) Set(value string) error {
 	f[path] = jsonMetadata
 	return nil
 }
+
+// runfilesLayoutFlag maps paths of executables in the image to the layout of their runfiles trees.
+type runfilesLayoutFlag map[string]tree.RunfilesLayout
+
+func (r runfilesLayoutFlag) String() string {
+	var pairs []string
+	for path, layout := range r {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", path, layout))
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (r runfilesLayoutFlag) Set(value string) error {
+	path, name, found := strings.Cut(value, "=")
+	if !found {
+		return fmt.Errorf("runfiles layout must be in format path=layout, got: %s", value)
+	}
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return fmt.Errorf("path in image cannot be empty: %s", value)
+	}
+	layout, err := tree.ParseRunfilesLayout(name)
+	if err != nil {
+		return err
+	}
+	r[path] = layout
+	return nil
+}
+
+// apply sets the runfiles layout of the executables.
+// Every path of the flag must be the path of an executable.
+func (r runfilesLayoutFlag) apply(ops executables) error {
+	for path, layout := range r {
+		i := slices.IndexFunc(ops, func(op executable) bool { return op.PathInImage == path })
+		if i < 0 {
+			return fmt.Errorf("runfiles layout for %s, which is not the path of an executable", path)
+		}
+		ops[i].runfilesLayout = layout
+	}
+	return nil
+}
+
+// srcLabelsFlag implements flag.Value for path=label pairs.
+// A path may be provided by more than one label.
+type srcLabelsFlag map[string][]string
+
+func (s srcLabelsFlag) String() string {
+	var keys []string
+	for k := range s {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, label := range s[k] {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, label))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (s srcLabelsFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("source label must be in format path=label, got: %s", value)
+	}
+	path := strings.TrimPrefix(parts[0], "/")
+	if path == "" {
+		return fmt.Errorf("path in image cannot be empty: %s", value)
+	}
+	s[path] = append(s[path], parts[1])
+	return nil
+}
+
+// xattrsFlag implements flag.Value for path=key=value extended attributes.
+// It maps paths in the image to their extended attributes.
+type xattrsFlag map[string]map[string]string
+
+func (x xattrsFlag) String() string {
+	var keys []string
+	for k := range x {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var triples []string
+	for _, k := range keys {
+		var names []string
+		for name := range x[k] {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			triples = append(triples, fmt.Sprintf("%s=%s=%s", k, name, x[k][name]))
+		}
+	}
+	return strings.Join(triples, ",")
+}
+
+func (x xattrsFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("extended attribute must be in format path=key=value, got: %s", value)
+	}
+	path := strings.TrimPrefix(parts[0], "/")
+	if path == "" {
+		return fmt.Errorf("path in image cannot be empty: %s", value)
+	}
+	if parts[1] == "" {
+		return fmt.Errorf("extended attribute name cannot be empty: %s", value)
+	}
+	if x[path] == nil {
+		x[path] = make(map[string]string)
+	}
+	x[path][parts[1]] = parts[2]
+	return nil
+}
+
+// byteSize is a size in bytes.
+// It accepts plain numbers as well as numbers with a unit suffix (e.g. "512KiB", "10MB", "1GiB").
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	if b == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	number := strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size %q: expected a non-negative number of bytes with optional unit (e.g. 500MiB)", value)
+	}
+	*b = byteSize(size * float64(multiplier))
+	return nil
+}
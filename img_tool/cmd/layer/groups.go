@@ -0,0 +1,197 @@
+package layer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// layerGroup is a separate layer for the inputs whose paths in the image match one of its patterns.
+type layerGroup struct {
+	name     string
+	patterns []*regexp.Regexp
+	// excludes are expressions of paths that never belong to the group, even if a pattern matches.
+	excludes []*regexp.Regexp
+	output   string
+	metadata string
+}
+
+// layerGroups are the groups in the order of their layers, from the lowest to the highest.
+// Inputs are assigned to the first group with a matching pattern and inputs without a group stay in the main layer.
+type layerGroups []*layerGroup
+
+// layerGroupsFlag collects the --layer-group, --layer-group-output and --layer-group-metadata flags.
+type layerGroupsFlag struct {
+	groups layerGroups
+}
+
+func (f *layerGroupsFlag) group(name string) *layerGroup {
+	for _, g := range f.groups {
+		if g.name == name {
+			return g
+		}
+	}
+	g := &layerGroup{name: name}
+	f.groups = append(f.groups, g)
+	return g
+}
+
+func (f *layerGroupsFlag) patternFlag() groupSetter {
+	return groupSetter{f: f, set: func(g *layerGroup, value string) error {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of layer group %s: %w", g.name, err)
+		}
+		g.patterns = append(g.patterns, pattern)
+		return nil
+	}}
+}
+
+func (f *layerGroupsFlag) excludeFlag() groupSetter {
+	return groupSetter{f: f, set: func(g *layerGroup, value string) error {
+		exclude, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid exclude of layer group %s: %w", g.name, err)
+		}
+		g.excludes = append(g.excludes, exclude)
+		return nil
+	}}
+}
+
+func (f *layerGroupsFlag) outputFlag() groupSetter {
+	return groupSetter{f: f, set: func(g *layerGroup, value string) error {
+		g.output = value
+		return nil
+	}}
+}
+
+func (f *layerGroupsFlag) metadataFlag() groupSetter {
+	return groupSetter{f: f, set: func(g *layerGroup, value string) error {
+		g.metadata = value
+		return nil
+	}}
+}
+
+// validate checks that every group has patterns and an output.
+func (f *layerGroupsFlag) validate() error {
+	for _, g := range f.groups {
+		if len(g.patterns) == 0 {
+			return fmt.Errorf("layer group %s has no patterns (use --layer-group %s=<regex>)", g.name, g.name)
+		}
+		if len(g.output) == 0 {
+			return fmt.Errorf("layer group %s has no output (use --layer-group-output %s=<path>)", g.name, g.name)
+		}
+	}
+	return nil
+}
+
+// groupSetter is a flag.Value that sets a value of a layer group in the format name=value.
+type groupSetter struct {
+	f   *layerGroupsFlag
+	set func(g *layerGroup, value string) error
+}
+
+func (s groupSetter) String() string {
+	if s.f == nil {
+		return ""
+	}
+	var names []string
+	for _, g := range s.f.groups {
+		names = append(names, g.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s groupSetter) Set(value string) error {
+	name, groupValue, found := strings.Cut(value, "=")
+	if !found || len(name) == 0 {
+		return fmt.Errorf("layer group flags must be in the format name=value, got: %s", value)
+	}
+	return s.set(s.f.group(name), groupValue)
+}
+
+// assign returns the index of the group of a path in the image, or -1 if the path stays in the main layer.
+func (g layerGroups) assign(pathInImage string) int {
+	p := path.Join("/", pathInImage)
+	for i, group := range g {
+		if group.matches(p) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *layerGroup) matches(p string) bool {
+	for _, exclude := range g.excludes {
+		if exclude.MatchString(p) {
+			return false
+		}
+	}
+	for _, pattern := range g.patterns {
+		if pattern.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// layerInputs are the inputs of a single layer.
+type layerInputs struct {
+	addFiles    addFiles
+	executables executables
+	symlinks    symlinks
+}
+
+// partition assigns the inputs to the groups.
+// Executables are assigned by the paths of the executable and of every runfile, so the runfiles of an executable may be spread over several layers.
+// The inputs that don't belong to any group are returned as rest.
+func (g layerGroups) partition(inputs layerInputs) (groupInputs []layerInputs, rest layerInputs, err error) {
+	groupInputs = make([]layerInputs, len(g))
+	index := func(i int) *layerInputs {
+		if i < 0 {
+			return &rest
+		}
+		return &groupInputs[i]
+	}
+
+	for _, op := range inputs.addFiles {
+		in := index(g.assign(op.PathInImage))
+		in.addFiles = append(in.addFiles, op)
+	}
+	for _, op := range inputs.symlinks {
+		in := index(g.assign(op.LinkName))
+		in.symlinks = append(in.symlinks, op)
+	}
+	for _, op := range inputs.executables {
+		runfileGroup := func(runfilesPath string) int {
+			return g.assign(path.Join(op.PathInImage+".runfiles", op.runfilesLayout.Path(runfilesPath)))
+		}
+		// collect the groups (and the main layer) that receive parts of the executable
+		used := map[int]bool{g.assign(op.PathInImage): true}
+		if len(op.RunfilesParameterFile) > 0 {
+			runfilesList, err := readParamFile(op.RunfilesParameterFile)
+			if err != nil {
+				return nil, layerInputs{}, fmt.Errorf("reading runfiles parameter file: %w", err)
+			}
+			for _, f := range runfilesList {
+				if op.includeRunfile == nil || op.includeRunfile(f.PathInImage) {
+					used[runfileGroup(f.PathInImage)] = true
+				}
+			}
+		}
+		for i := -1; i < len(g); i++ {
+			if !used[i] {
+				continue
+			}
+			groupOp := op
+			groupOp.runfilesOnly = op.runfilesOnly || g.assign(op.PathInImage) != i
+			groupOp.includeRunfile = func(runfilesPath string) bool {
+				return (op.includeRunfile == nil || op.includeRunfile(runfilesPath)) && runfileGroup(runfilesPath) == i
+			}
+			in := index(i)
+			in.executables = append(in.executables, groupOp)
+		}
+	}
+	return groupInputs, rest, nil
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -15,10 +16,15 @@ import (
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/compress"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/contentmanifest"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/diagnostics"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/digestfs"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tarcas"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/filter"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/observer"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/runfiles"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/wclayer"
 )
 
 func LayerProcess(ctx context.Context, args []string) {
@@ -40,7 +46,20 @@ func LayerProcess(ctx context.Context, args []string) {
 	var defaultMetadataFlag string
 	var compressorJobsFlag string
 	var compressionLevelFlag int
+	var externalCompressorFlag string
+	var filterFlags filtersFlag
+	var windowsFlag bool
+	var observerFlags observersFlag
+	var duplicatePathsFlag string
+	var sortFlag string
+	var preserveHardlinksFlag bool
+	var validation layerValidation
+	var split runfilesSplit
+	var groups layerGroupsFlag
+	srcLabels := make(srcLabelsFlag)
+	runfilesLayouts := make(runfilesLayoutFlag)
 	fileMetadataFlags := make(fileMetadataFlag)
+	xattrFlags := make(xattrsFlag)
 
 	flagSet := flag.NewFlagSet("layer", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -51,6 +70,17 @@ func LayerProcess(ctx context.Context, args []string) {
 			"img layer --add /etc/passwd=./passwd --executable /bin/myapp=./myapp layer.tgz",
 			"img layer --add-from-file param_file.txt layer.tgz",
 			"img layer --add --executable /bin/app=./app --runfiles ./app=runfiles_list.txt layer.tgz",
+			"img layer --executable /bin/app=./app --runfiles ./app=runfiles_list.txt --runfiles-layout /bin/app=symlinks layer.tgz",
+			"img layer --filter pyc=normalize --add-from-file param_file.txt layer.tgz",
+			"img layer --windows --add /app/app.exe=./app.exe layer.tgz",
+			"img layer --observe filelist=files.txt --add-from-file param_file.txt layer.tgz",
+			"img layer --duplicate-paths error --src-label app/lib=//lib:all --add-from-file param_file.txt layer.tgz",
+			"img layer --sort path --import-tar rootfs.tar.xz --import-tar overlay.tar layer.tgz",
+			"img layer --preserve-hardlinks --import-tar rootfs.tar layer.tgz",
+			"img layer --add /bin/server=./server --xattr bin/server=security.capability=cap_net_bind_service+ep layer.tgz",
+			"img layer --executable /bin/app=./app --runfiles ./app=runfiles_list.txt --split-runfiles-output deps.tgz --split-runfiles-metadata deps_metadata.json layer.tgz",
+			"img layer --layer-group deps=^/usr/lib --layer-group-output deps=deps.tgz --layer-group-metadata deps=deps_metadata.json --add-from-file param_file.txt layer.tgz",
+			"img layer --fail-on-dangling-symlink --fail-on-duplicate-path --allow-absolute-symlinks=false --import-tar rootfs.tar layer.tgz",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -63,22 +93,47 @@ func LayerProcess(ctx context.Context, args []string) {
 	flagSet.Var(&addFromFile, "add-from-file", `Add all files listed in the parameter file to the image layer. The parameter file is usually written by Bazel.
 The file contains one line per file, where each line contains a path in the image and a path in the host filesystem, separated by a a null byte and a single character indicating the type of the file.
 The type is either 'f' for regular files, 'd' for directories. The parameter file is usually written by Bazel.`)
-	flagSet.Var(&importTarFlags, "import-tar", `Import all files from the given tar file into the image layer while deduplicating the contents.`)
+	flagSet.Var(&importTarFlags, "import-tar", `Import all files from the given tar file into the image layer while deduplicating the contents. The tar file may be compressed with gzip, zstd or xz (detected automatically).`)
 	flagSet.Var(&executableFlags, "executable", `Add the executable file at the specified path in the image. This should be combined with the --runfiles flag to include the runfiles of the executable.`)
 	flagSet.Var(&runfilesFlags, "runfiles", `Add the runfiles of an executable file. The runfiles are read from the specified parameter file with the same encoding used by --add-from-file. The parameter file is usually written by Bazel.`)
+	flagSet.Var(runfilesLayouts, "runfiles-layout", `Layout of the runfiles tree of an executable, in the form <path_in_image>=<layout>. Can be specified multiple times.
+"classic" (default) stores runfiles at <executable>.runfiles/<repo>/<path>. "merged" stores the runfiles of all repositories below the main repository (at <executable>.runfiles/_main/external/<repo>/<path>).
+"symlinks" stores every runfile as a symlink to a single copy of its content, like the runfiles trees of Bazel on Linux.`)
 	flagSet.Var(&symlinkFlags, "symlink", `Add a symlink to the image layer. The parameter is a string of the form <path_in_image>=<target> where <path_in_image> is the path in the image and <target> is the target of the symlink.`)
 	flagSet.Var(&symlinksFromFiles, "symlinks-from-file", `Add all symlinks listed in the parameter file to the image layer. The parameter file is usually written by Bazel.`)
 	flagSet.Var(&contentManifestInputFlags, "deduplicate", `Path of a content manifest of a previous layer that can be used for deduplication.`)
-	flagSet.StringVar(&contentManifestCollection, "deduplicate-collection", "", `Path of a content manifest collection file that can be used for deduplication.`)
+	flagSet.StringVar(&contentManifestCollection, "deduplicate-collection", "", `Path of a content manifest collection file that can be used for deduplication. The collection either lists the paths of content manifests (one per line) or is a merged content manifest (see "img content-manifest merge").`)
 	flagSet.StringVar(&formatFlag, "format", "", `The compression format of the output layer. Can be "gzip" or "none". Default is to guess the algorithm based on the filename, but fall back to "gzip".`)
 	flagSet.BoolVar(&estargzFlag, "estargz", false, `Use estargz format for compression. This creates seekable gzip streams optimized for lazy pulling.`)
-	flagSet.StringVar(&compressorJobsFlag, "compressor-jobs", "1", `Number of compressor jobs. 1 uses single-threaded stdlib gzip. n>1 uses pgzip. "nproc" uses NumCPU.`)
+	flagSet.StringVar(&compressorJobsFlag, "compressor-jobs", "1", `Number of parallel gzip compressor jobs. The output is the same for any number of jobs. "nproc" uses NumCPU.`)
 	flagSet.IntVar(&compressionLevelFlag, "compression-level", -1, `Compression level. For gzip: 0-9. If unset, use library default.`)
+	flagSet.StringVar(&externalCompressorFlag, "external-compressor", "", `Path of a pigz (for gzip) or zstd binary that compresses the layer with multiple threads. Estargz layers use the built-in compressors; a missing binary is an error.`)
 	flagSet.Var(&annotations, "annotation", `Add an annotation as key=value. Can be specified multiple times.`)
 	flagSet.StringVar(&metadataOutputFlag, "metadata", "", `Write the metadata to the specified file. The metadata is a JSON file containing info needed to use the layer as part of an OCI image.`)
 	flagSet.StringVar(&contentManifestOutputFlag, "content-manifest", "", `Write a manifest of the contents of the layer to the specified file. The manifest uses a custom binary format listing all blobs, nodes, and trees in the layer after deduplication.`)
 	flagSet.StringVar(&defaultMetadataFlag, "default-metadata", "", `JSON-encoded default metadata to apply to all files in the layer. Can include fields like mode, uid, gid, uname, gname, mtime, and pax_records.`)
+	flagSet.Var(&filterFlags, "filter", `Apply a content filter to files as they are stored in the layer. Can be specified multiple times; filters run in order. Available filters: "pyc=normalize" (set the source mtime embedded in timestamp-based Python bytecode to the mtime of the file in the layer), "pyc=drop" (remove .pyc files and __pycache__ directories) and "jar[=<RFC 3339 timestamp>]" (rewrite .jar, .war and .ear archives with fixed entry timestamps, 2010-01-01T00:00:00Z by default, and entries sorted by name).`)
+	flagSet.Var(&observerFlags, "observe", `Write a side output while building the layer in the format name=output. Can be specified multiple times. Available observers: "filelist" (one line per entry with its type and path).`)
+	flagSet.BoolVar(&windowsFlag, "windows", false, `Write a Windows container layer. All files are stored below "Files/", and the "Files/" and "Hives/" directories are created at the root of the layer.`)
+	flagSet.StringVar(&duplicatePathsFlag, "duplicate-paths", duplicatePathsRename, `Policy for paths in the image that are provided by more than one file. "rename" adds the basename of each file to the path, "error" fails with a list of the conflicting files, and "last-wins" only keeps the file that is added last.`)
+	flagSet.BoolVar(&preserveHardlinksFlag, "preserve-hardlinks", false, `Keep the hardlinks of tar files imported with --import-tar. Files that are the target of a hardlink are written in place instead of being deduplicated, so their hardlinks keep pointing to the original targets and they never share an inode with unrelated files of the same content. All other files are still deduplicated.`)
+	flagSet.StringVar(&sortFlag, "sort", "none", `Order of the entries in the layer. "none" writes entries in the order they are added (following the order of the flags), "path" sorts all entries by path (hardlinks are kept behind their targets), so that reordering the inputs produces the same layer. Sorting spools the layer contents to a temporary file.`)
+	flagSet.BoolVar(&validation.failOnDanglingSymlink, "fail-on-dangling-symlink", false, `Fail if a symlink in the layer points to a path that does not exist in the layer. Only the layer itself is considered, so symlinks into other layers (like the base image) are reported as dangling.`)
+	flagSet.BoolVar(&validation.failOnDuplicatePath, "fail-on-duplicate-path", false, `Fail if a path is written more than once to the layer (by any input, including imported tar files and runfiles) and report the colliding sources. Directories may be written more than once.`)
+	flagSet.BoolVar(&validation.allowAbsoluteSymlinks, "allow-absolute-symlinks", true, `Allow symlinks with absolute targets. Absolute targets are resolved against the root of the container, not the directory of the symlink.`)
+	flagSet.Var(&validation.maxSize, "max-size", `Fail if the compressed layer is larger than this size in bytes, with optional unit (e.g. "500MiB"). The error lists the inputs and files that contribute the most. 0 disables the check.`)
+	flagSet.IntVar(&validation.maxEntries, "max-entries", 0, `Fail if the layer has more entries than this (counting every file, directory and symlink added by the inputs). The error lists the inputs and files that contribute the most. 0 disables the check.`)
+	flagSet.StringVar(&split.output, "split-runfiles-output", "", `Write the runfiles of third-party repositories to a separate layer at this path, which is lower than the main layer. The layer only changes when the third-party runfiles change, so it can be reused by builds that only change first-party code. Both layers use the same format and options.`)
+	flagSet.StringVar(&split.metadata, "split-runfiles-metadata", "", `Write the metadata of the layer with the third-party runfiles to the specified file.`)
+	flagSet.Var(&split.repos, "third-party-repo", `Name (or prefix of the canonical name) of a repository whose runfiles are written to the layer of --split-runfiles-output. Can be specified multiple times. If unset, the runfiles of all repositories except the main repository are third-party.`)
+	flagSet.Var(groups.patternFlag(), "layer-group", `Move the inputs whose paths in the image match a regular expression to a separate layer, in the format name=regex (e.g. deps=^/usr/lib). Can be specified multiple times, also with the same name to add more expressions to a group. Inputs belong to the first group with a matching expression, all other inputs stay in the main layer. The layers of the groups are lower than the main layer, in the order of their first flag. Files, executables (including every runfile), and symlinks are assigned by path, imported tar files stay in the main layer.`)
+	flagSet.Var(groups.excludeFlag(), "layer-group-exclude", `Keep the inputs whose paths in the image match a regular expression out of a group, in the format name=regex (e.g. deps=\.so$). Excluded inputs belong to the next group with a matching expression, or stay in the main layer. Can be specified multiple times.`)
+	flagSet.Var(groups.outputFlag(), "layer-group-output", `Output path of the layer of a group in the format name=path. Required for every group.`)
+	flagSet.Var(groups.metadataFlag(), "layer-group-metadata", `Write the metadata of the layer of a group to a file in the format name=path.`)
+	flagSet.Var(srcLabels, "src-label", `Label that provides a path in the image in the format path=label. Can be specified multiple times. Only used for diagnostics.`)
 	flagSet.Var(&fileMetadataFlags, "file-metadata", `Per-file metadata override in the format path=json. Can be specified multiple times. Overrides any defaults from --default-metadata.`)
+	flagSet.Var(xattrFlags, "xattr", `Set an extended attribute in the format path=key=value (stored as PAX record). Can be specified multiple times. Values starting with "0x" are hex encoded and values starting with "0s" are base64 encoded. Values of security.capability can also be given like for setcap (e.g. "cap_net_bind_service+ep").`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -91,6 +146,21 @@ The type is either 'f' for regular files, 'd' for directories. The parameter fil
 	}
 
 	outputFilePath := flagSet.Arg(0)
+	diagnostics.Set("estargz", strconv.FormatBool(estargzFlag))
+	diagnostics.Set("compressor-jobs", compressorJobsFlag)
+	diagnostics.Set("compression-level", strconv.Itoa(compressionLevelFlag))
+	diagnostics.Set("sort", sortFlag)
+	diagnostics.Set("windows", strconv.FormatBool(windowsFlag))
+
+	if err := groups.validate(); err != nil {
+		slog.Error("Invalid layer groups", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+
+	if sortFlag != "none" && sortFlag != "path" {
+		slog.Error(`Unknown sort order, supported orders are "none" and "path"`, "sort", sortFlag)
+		diagnostics.Exit(1)
+	}
 
 	var compressionAlgorithm api.CompressionAlgorithm
 	switch formatFlag {
@@ -111,30 +181,34 @@ The type is either 'f' for regular files, 'd' for directories. The parameter fil
 	case "none", "uncompressed", "tar":
 		compressionAlgorithm = api.Uncompressed
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown format %s. Supported formats are gzip, zstd and uncompressed.\n", formatFlag)
-		os.Exit(1)
+		slog.Error("Unknown format, supported formats are gzip, zstd and uncompressed", "format", formatFlag)
+		diagnostics.Exit(1)
 	}
+	diagnostics.Set("compression", string(compressionAlgorithm))
 
 	outputFile, err := os.OpenFile(outputFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
-		os.Exit(1)
+		slog.Error("Opening output file", logging.ErrKey, err)
+		diagnostics.Exit(1)
 	}
 	defer outputFile.Close()
 
 	// Parse layer metadata
 	layerMetadata, err := ParseLayerMetadata(defaultMetadataFlag, fileMetadataFlags)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing metadata: %v\n", err)
-		os.Exit(1)
+		slog.Error("Parsing metadata", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+	for path, xattrs := range xattrFlags {
+		layerMetadata.AddXattrs(path, xattrs)
 	}
 
 	// read the addFromFile parameter file and create a list of operations
 	for _, paramFile := range addFromFile {
 		addFileOpsFromParamFile, err := readParamFile(paramFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading parameter file: %v\n", err)
-			os.Exit(1)
+			slog.Error("Reading parameter file", logging.ErrKey, err)
+			diagnostics.Exit(1)
 		}
 		addFiles = append(addFiles, addFileOpsFromParamFile...)
 	}
@@ -143,35 +217,29 @@ The type is either 'f' for regular files, 'd' for directories. The parameter fil
 	for _, paramFile := range symlinksFromFiles {
 		symlinkOpsFromParamFile, err := readSymlinkParamFile(paramFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading symlink parameter file: %v\n", err)
-			os.Exit(1)
+			slog.Error("Reading symlink parameter file", logging.ErrKey, err)
+			diagnostics.Exit(1)
 		}
 		symlinkFlags = append(symlinkFlags, symlinkOpsFromParamFile...)
 	}
 
-	// first, due to the way Bazel attributes work, we need to find out if a pathInImage is used multiple times
-	// If so, we add the basename of each file to the pathInImage
-	pathsInImageCount := make(map[string]int)
-	for _, op := range addFiles {
-		pathsInImageCount[op.PathInImage]++
-	}
-	for _, op := range executableFlags {
-		pathsInImageCount[op.PathInImage]++
+	if err := runfilesLayouts.apply(executableFlags); err != nil {
+		slog.Error("Invalid runfiles layout", logging.ErrKey, err)
+		diagnostics.Exit(1)
 	}
 
-	// now, we fixup the operations
-	for i, op := range addFiles {
-		if pathsInImageCount[op.PathInImage] > 1 {
-			addFiles[i].PathInImage = fmt.Sprintf("%s/%s", op.PathInImage, filepath.Base(op.File))
-		}
+	// due to the way Bazel attributes work, a pathInImage may be used by multiple files
+	// (e.g., if a label provides more than one file). The policy decides what happens in this case.
+	addFiles, executableFlags, err = resolveDuplicatePaths(duplicatePathsFlag, addFiles, executableFlags, srcLabels)
+	if err != nil {
+		slog.Error("Resolving duplicate paths", logging.ErrKey, err)
+		diagnostics.Exit(1)
 	}
+
+	// try to match the runfiles parameter file to the executable
+	// This is inefficient, but we don't expect a lot of executables
+	// to be added.
 	for i, op := range executableFlags {
-		if pathsInImageCount[op.PathInImage] > 1 {
-			executableFlags[i].PathInImage = fmt.Sprintf("%s/%s", op.PathInImage, filepath.Base(op.Executable))
-		}
-		// try to match the runfiles parameter file to the executable
-		// This is inefficient, but we don't expect a lot of executables
-		// to be added.
 		for _, runfilesOp := range runfilesFlags {
 			if runfilesOp.Executable == op.Executable {
 				executableFlags[i].RunfilesParameterFile = runfilesOp.RunfilesFromFile
@@ -182,7 +250,10 @@ The type is either 'f' for regular files, 'd' for directories. The parameter fil
 
 	casImporter := contentmanifest.NewMultiImporter(contentManifestInputFlags, api.SHA256)
 	if len(contentManifestCollection) > 0 {
-		casImporter.AddCollection(contentManifestCollection)
+		if err := casImporter.AddCollection(contentManifestCollection); err != nil {
+			slog.Error("Reading content manifest collection", logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
 	}
 
 	var casExporter api.CASStateExporter
@@ -192,35 +263,98 @@ The type is either 'f' for regular files, 'd' for directories. The parameter fil
 		casExporter = contentmanifest.NopExporter()
 	}
 
+	var validator *layerValidator
+	if validation.enabled() {
+		validator = newLayerValidator(validation, srcLabels)
+	}
+
+	// lower layers (runfiles of third-party repositories and layer groups) are written before the main layer
+	settings := layerSettings{
+		compressionAlgorithm: compressionAlgorithm,
+		useEstargz:           estargzFlag,
+		annotations:          annotations,
+		casImporter:          casImporter,
+		layerMetadata:        layerMetadata,
+		filters:              filter.Pipeline(filterFlags),
+		compressorJobs:       compressorJobsFlag,
+		compressionLevel:     compressionLevelFlag,
+		externalCompressor:   externalCompressorFlag,
+		windows:              windowsFlag,
+		sortByPath:           sortFlag == "path",
+	}
+	groupInputs, rest, err := groups.groups.partition(layerInputs{addFiles: addFiles, executables: executableFlags, symlinks: symlinkFlags})
+	if err != nil {
+		slog.Error("Assigning inputs to layer groups", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+	addFiles, executableFlags, symlinkFlags = rest.addFiles, rest.executables, rest.symlinks
+	if split.enabled() {
+		var thirdPartyExecutables executables
+		thirdPartyExecutables, executableFlags = split.partition(executableFlags)
+		if err := writeLowerLayer(
+			split.output, split.metadata, lowerLayerName(layerName, "third-party runfiles"), layerInputs{executables: thirdPartyExecutables}, settings,
+		); err != nil {
+			slog.Error("Writing third-party runfiles layer", logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
+	}
+	for i, group := range groups.groups {
+		if err := writeLowerLayer(
+			group.output, group.metadata, lowerLayerName(layerName, "group "+group.name), groupInputs[i], settings,
+		); err != nil {
+			slog.Error("Writing layer of group", "group", group.name, logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
+	}
+
 	compressorState, err := handleLayerState(
 		compressionAlgorithm, estargzFlag, addFiles, importTarFlags, executableFlags, symlinkFlags,
-		casImporter, casExporter, outputFile, layerMetadata,
-		compressorJobsFlag, compressionLevelFlag,
+		casImporter, casExporter, outputFile, layerMetadata, filter.Pipeline(filterFlags),
+		compressorJobsFlag, compressionLevelFlag, externalCompressorFlag, windowsFlag, sortFlag == "path", preserveHardlinksFlag, observerFlags, validator,
 	)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Writing layer: %v\n", err)
-		os.Exit(1)
+		slog.Error("Writing layer", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+	// Verify that all file metadata entries were used (by any layer)
+	if layerMetadata != nil {
+		if err := layerMetadata.VerifyAllFileMetadataUsed(); err != nil {
+			slog.Error("Writing layer", logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
+	}
+	if validator != nil {
+		if err := validator.checkBudget(compressorState.CompressedSize); err != nil {
+			slog.Error("Layer exceeds its budget", logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
+	}
+	for _, o := range observerFlags {
+		if err := o.Close(); err != nil {
+			slog.Error("Closing observer", logging.ErrKey, err)
+			diagnostics.Exit(1)
+		}
 	}
 
 	if len(metadataOutputFlag) > 0 {
 		metadataOutputFile, err := os.OpenFile(metadataOutputFlag, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening metadata output file: %v\n", err)
-			os.Exit(1)
+			slog.Error("Opening metadata output file", logging.ErrKey, err)
+			diagnostics.Exit(1)
 		}
 		defer metadataOutputFile.Close()
 
 		if err := writeMetadata(layerName, compressionAlgorithm, estargzFlag, annotations, compressorState, metadataOutputFile); err != nil {
-			fmt.Fprintf(os.Stderr, "Writing metadata: %v\n", err)
-			os.Exit(1)
+			slog.Error("Writing metadata", logging.ErrKey, err)
+			diagnostics.Exit(1)
 		}
 	}
 }
 
 func handleLayerState(
 	compressionAlgorithm api.CompressionAlgorithm, useEstargz bool, addFiles addFiles, importTars importTars, addExecutables executables, addSymlinks symlinks,
-	casImporter api.CASStateSupplier, casExporter api.CASStateExporter, outputFile io.Writer, layerMetadata *LayerMetadata,
-	compressorJobsFlag string, compressionLevelFlag int,
+	casImporter api.CASStateSupplier, casExporter api.CASStateExporter, outputFile io.Writer, layerMetadata *LayerMetadata, filters filter.Pipeline,
+	compressorJobsFlag string, compressionLevelFlag int, externalCompressorFlag string, windowsLayer, sortByPath, preserveHardlinks bool, observers []observer.Observer, validator *layerValidator,
 ) (compressorState api.AppenderState, err error) {
 	// Create shared digestfs with precaching
 	digestFS := digestfs.New(&tarcas.SHA256Helper{})
@@ -243,53 +377,83 @@ func handleLayerState(
 			opts = append(opts, compress.CompressorJobs(n))
 		}
 	}
+	if len(externalCompressorFlag) > 0 {
+		opts = append(opts, compress.ExternalCompressor(externalCompressorFlag))
+	}
 
 	compressor, err := compress.TarAppenderFactory("sha256", string(compressionAlgorithm), useEstargz, outputFile, opts...)
 	if err != nil {
 		return compressorState, fmt.Errorf("creating compressor: %w", err)
 	}
+	if windowsLayer {
+		compressor = wclayer.NewAppender(compressor)
+	}
 	defer func() {
 		var compressorCloseErr error
 		compressorState, compressorCloseErr = compressor.Finalize()
 		if compressorCloseErr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing compressor: %v\n", compressorCloseErr)
-			os.Exit(1)
+			slog.Error("Closing compressor", logging.ErrKey, compressorCloseErr)
+			diagnostics.Exit(1)
 		}
 	}()
 
-	tw, err := tarcas.CASFactoryWithDigestFS("sha256", compressor, digestFS)
+	var casOptions []tarcas.Option
+	if sortByPath {
+		casOptions = append(casOptions, tarcas.SortByPath())
+	}
+	for _, o := range observers {
+		casOptions = append(casOptions, tarcas.Observe(o.Observe, tarcas.WriteHeaderCallbackFilterAll))
+	}
+	tw, err := tarcas.CASFactoryWithDigestFS("sha256", compressor, digestFS, casOptions...)
 	if err != nil {
 		return compressorState, fmt.Errorf("creating Content-addressable storage inside tar file: %w", err)
 	}
 	defer func() {
 		if err := tw.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing tar writer: %v\n", err)
-			os.Exit(1)
+			slog.Error("Closing tar writer", logging.ErrKey, err)
+			diagnostics.Exit(1)
 		}
 	}()
 	if err := tw.Import(casImporter); err != nil {
 		return compressorState, fmt.Errorf("importing content manifests for deduplication: %w", err)
 	}
 
-	recorder := tree.NewRecorder(tw)
+	var tf api.TarCAS = tw
+	if validator != nil {
+		tf = validator.wrap(tw)
+	}
+	recorder := tree.NewRecorder(tf)
 	if layerMetadata != nil {
 		recorder = recorder.WithMetadata(layerMetadata)
 	}
-	if err := writeLayer(recorder, addFiles, importTars, addExecutables, addSymlinks, layerMetadata); err != nil {
+	if len(filters) > 0 {
+		recorder = recorder.WithFilters(filters)
+	}
+	if preserveHardlinks {
+		recorder = recorder.WithPreservedHardlinks()
+	}
+	if err := writeLayer(recorder, addFiles, importTars, addExecutables, addSymlinks, validator); err != nil {
 		return compressorState, err
 	}
+	if validator != nil {
+		if err := validator.check(); err != nil {
+			return compressorState, err
+		}
+	}
 
 	return compressorState, tw.Export(casExporter)
 }
 
-func writeLayer(recorder tree.Recorder, addFiles addFiles, importTars importTars, addExecutables executables, addSymlinks symlinks, layerMetadata *LayerMetadata) error {
+func writeLayer(recorder tree.Recorder, addFiles addFiles, importTars importTars, addExecutables executables, addSymlinks symlinks, validator *layerValidator) error {
 	for _, tarFile := range importTars {
+		validator.setSource("tar file " + tarFile)
 		if err := recorder.ImportTar(tarFile); err != nil {
 			return fmt.Errorf("importing tar file: %w", err)
 		}
 	}
 
 	for _, op := range addFiles {
+		validator.setSource(validator.fileSource(op.File, op.PathInImage))
 		switch op.FileType {
 		case api.RegularFile:
 			if err := recorder.RegularFileFromPath(op.File, op.PathInImage); err != nil {
@@ -309,29 +473,85 @@ func writeLayer(recorder tree.Recorder, addFiles addFiles, importTars importTars
 		if err != nil {
 			return fmt.Errorf("reading runfiles parameter file: %w", err)
 		}
+		validator.setSource("executable " + validator.fileSource(op.Executable, op.PathInImage) + " and its runfiles")
 		accessor := runfiles.NewRunfilesFS()
 		for _, f := range runfilesList {
-			accessor.Add(f.PathInImage, f)
+			if op.includeRunfile == nil || op.includeRunfile(f.PathInImage) {
+				accessor.Add(f.PathInImage, f)
+			}
 		}
-		if err := recorder.Executable(op.Executable, op.PathInImage, accessor); err != nil {
+		executableRecorder := recorder.WithRunfilesLayout(op.runfilesLayout)
+		if op.runfilesOnly {
+			if err := executableRecorder.Runfiles(op.PathInImage, accessor); err != nil {
+				return fmt.Errorf("writing runfiles: %w", err)
+			}
+			continue
+		}
+		if err := executableRecorder.Executable(op.Executable, op.PathInImage, accessor); err != nil {
 			return fmt.Errorf("writing executable: %w", err)
 		}
 	}
 
+	validator.setSource("symlinks")
 	for _, op := range addSymlinks {
 		if err := recorder.Symlink(op.Target, op.LinkName); err != nil {
 			return fmt.Errorf("writing symlink: %w", err)
 		}
 	}
 
-	// Verify that all file metadata entries were used
-	if layerMetadata != nil {
-		if err := layerMetadata.VerifyAllFileMetadataUsed(); err != nil {
-			return err
-		}
+	return nil
+}
+
+// layerSettings are the settings of the main layer that also apply to lower layers.
+type layerSettings struct {
+	compressionAlgorithm api.CompressionAlgorithm
+	useEstargz           bool
+	annotations          map[string]string
+	casImporter          api.CASStateSupplier
+	layerMetadata        *LayerMetadata
+	filters              filter.Pipeline
+	compressorJobs       string
+	compressionLevel     int
+	externalCompressor   string
+	windows              bool
+	sortByPath           bool
+}
+
+// lowerLayerName returns the name of a lower layer written by the same action as the main layer.
+func lowerLayerName(layerName, description string) string {
+	if len(layerName) == 0 {
+		// the name defaults to the digest
+		return ""
 	}
+	return fmt.Sprintf("%s (%s)", layerName, description)
+}
 
-	return nil
+// writeLowerLayer writes a layer (and its metadata) below the main layer,
+// like the runfiles of third-party repositories or the layer of a group.
+func writeLowerLayer(output, metadataOutput, name string, inputs layerInputs, settings layerSettings) error {
+	outputFile, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	compressorState, err := handleLayerState(
+		settings.compressionAlgorithm, settings.useEstargz, inputs.addFiles, nil, inputs.executables, inputs.symlinks,
+		settings.casImporter, contentmanifest.NopExporter(), outputFile, settings.layerMetadata, settings.filters,
+		settings.compressorJobs, settings.compressionLevel, settings.externalCompressor, settings.windows, settings.sortByPath, false, nil, nil,
+	)
+	if err != nil {
+		return err
+	}
+	if len(metadataOutput) == 0 {
+		return nil
+	}
+	metadataOutputFile, err := os.OpenFile(metadataOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer metadataOutputFile.Close()
+	return writeMetadata(name, settings.compressionAlgorithm, settings.useEstargz, settings.annotations, compressorState, metadataOutputFile)
 }
 
 func writeMetadata(name string, compressionAlgorithm api.CompressionAlgorithm, useEstargz bool, annotations map[string]string, compressorState api.AppenderState, outputFile io.Writer) error {
@@ -396,14 +616,16 @@ func startPrecaching(precacher *digestfs.Precacher, addFiles addFiles, addExecut
 
 	// Add executable files and their runfiles
 	for _, op := range addExecutables {
-		filesToPrecache = append(filesToPrecache, op.Executable)
+		if !op.runfilesOnly {
+			filesToPrecache = append(filesToPrecache, op.Executable)
+		}
 
 		// Add runfiles if available
 		if op.RunfilesParameterFile != "" {
 			runfilesList, err := readParamFile(op.RunfilesParameterFile)
 			if err == nil {
 				for _, f := range runfilesList {
-					if f.FileType == api.RegularFile {
+					if f.FileType == api.RegularFile && (op.includeRunfile == nil || op.includeRunfile(f.PathInImage)) {
 						filesToPrecache = append(filesToPrecache, f.File)
 					}
 				}
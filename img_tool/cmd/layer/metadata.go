@@ -19,6 +19,9 @@ type FileMetadata struct {
 	Gname      *string           `json:"gname,omitempty"`
 	Mtime      *string           `json:"mtime,omitempty"`
 	PAXRecords map[string]string `json:"pax_records,omitempty"`
+	// Xattrs are extended attributes (like security.capability or security.selinux).
+	// Values are encoded like the values of setfattr (see decodeXattrValue).
+	Xattrs map[string]string `json:"xattrs,omitempty"`
 }
 
 // LayerMetadata holds all metadata configuration for a layer
@@ -60,6 +63,23 @@ func ParseLayerMetadata(defaultJSON string, fileMetadata map[string]string) (*La
 	return result, nil
 }
 
+// AddXattrs adds extended attributes to the metadata of a path in the image.
+// They take precedence over extended attributes of the defaults and the file-specific metadata.
+func (lm *LayerMetadata) AddXattrs(path string, xattrs map[string]string) {
+	metadata, ok := lm.FileOverrides[path]
+	if !ok {
+		metadata = &FileMetadata{}
+		lm.FileOverrides[path] = metadata
+		lm.usageCounts[path] = 0
+	}
+	if metadata.Xattrs == nil {
+		metadata.Xattrs = make(map[string]string, len(xattrs))
+	}
+	for name, value := range xattrs {
+		metadata.Xattrs[name] = value
+	}
+}
+
 // ApplyToHeader applies the metadata to a tar header, with file-specific overrides taking precedence
 // This implements the tree.MetadataProvider interface
 func (lm *LayerMetadata) ApplyToHeader(hdr *tar.Header, pathInImage string) error {
@@ -150,5 +170,18 @@ func applyFileMetadata(hdr *tar.Header, metadata *FileMetadata) error {
 		}
 	}
 
+	if metadata.Xattrs != nil {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		for name, value := range metadata.Xattrs {
+			raw, err := decodeXattrValue(name, value)
+			if err != nil {
+				return err
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = raw
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,72 @@
+package layer
+
+import (
+	"strings"
+)
+
+// runfilesSplit moves the runfiles of third-party repositories into a separate layer.
+// Third-party runfiles rarely change, so the layer can be reused (and doesn't need to be pushed again)
+// when only the first-party binary and its runfiles change.
+type runfilesSplit struct {
+	// output is the path of the layer with the third-party runfiles.
+	// Runfiles are only split if it is set.
+	output string
+	// metadata is the path of the metadata file of the third-party layer.
+	metadata string
+	// repos are the names (or prefixes of canonical names) of third-party repositories.
+	// If empty, all repositories except the main repository are third-party.
+	repos thirdPartyRepos
+}
+
+func (s runfilesSplit) enabled() bool {
+	return len(s.output) > 0
+}
+
+// isThirdParty reports whether a path in the runfiles tree belongs to a third-party repository.
+func (s runfilesSplit) isThirdParty(runfilesPath string) bool {
+	repo, _, found := strings.Cut(runfilesPath, "/")
+	if !found {
+		// files at the root of the runfiles tree (like _repo_mapping) change with the main repository
+		return false
+	}
+	if len(s.repos) == 0 {
+		return repo != "_main"
+	}
+	for _, prefix := range s.repos {
+		if strings.HasPrefix(repo, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// partition splits the executables into the runfiles of third-party repositories
+// and the executables with their remaining (first-party) runfiles.
+func (s runfilesSplit) partition(ops executables) (thirdParty, firstParty executables) {
+	for _, op := range ops {
+		included := func(runfilesPath string) bool {
+			return op.includeRunfile == nil || op.includeRunfile(runfilesPath)
+		}
+
+		thirdPartyOp := op
+		thirdPartyOp.runfilesOnly = true
+		thirdPartyOp.includeRunfile = func(runfilesPath string) bool { return included(runfilesPath) && s.isThirdParty(runfilesPath) }
+		thirdParty = append(thirdParty, thirdPartyOp)
+
+		firstPartyOp := op
+		firstPartyOp.includeRunfile = func(runfilesPath string) bool { return included(runfilesPath) && !s.isThirdParty(runfilesPath) }
+		firstParty = append(firstParty, firstPartyOp)
+	}
+	return thirdParty, firstParty
+}
+
+type thirdPartyRepos []string
+
+func (r *thirdPartyRepos) String() string {
+	return strings.Join(*r, ", ")
+}
+
+func (r *thirdPartyRepos) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
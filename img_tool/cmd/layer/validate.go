@@ -0,0 +1,235 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// maxSymlinkHops is the maximum number of symlinks followed while resolving a path (like MAXSYMLINKS on Linux).
+const maxSymlinkHops = 40
+
+// layerValidation configures the checks of the entries of a layer.
+type layerValidation struct {
+	failOnDanglingSymlink bool
+	failOnDuplicatePath   bool
+	allowAbsoluteSymlinks bool
+	// maxSize (compressed) and maxEntries are the budget of the layer. Zero disables the check.
+	maxSize    byteSize
+	maxEntries int
+}
+
+func (c layerValidation) enabled() bool {
+	return c.failOnDanglingSymlink || c.failOnDuplicatePath || !c.allowAbsoluteSymlinks || c.maxSize > 0 || c.maxEntries > 0
+}
+
+// layerValidator records the entries of a layer together with the input that provided them,
+// so that broken layers are rejected at build time instead of at container start.
+type layerValidator struct {
+	layerValidation
+	labels srcLabelsFlag
+	// source describes the input that is currently written.
+	source string
+	// entries maps cleaned absolute paths to all entries written at that path.
+	entries map[string][]validatedEntry
+	// dirs contains all parent directories of entries (which exist even without a directory entry).
+	dirs map[string]bool
+}
+
+type validatedEntry struct {
+	typeflag byte
+	linkname string
+	size     int64
+	source   string
+}
+
+func newLayerValidator(validation layerValidation, labels srcLabelsFlag) *layerValidator {
+	return &layerValidator{
+		layerValidation: validation,
+		labels:          labels,
+		entries:         make(map[string][]validatedEntry),
+		dirs:            map[string]bool{"/": true},
+	}
+}
+
+// setSource sets the input that provides the entries written next.
+// It is a no-op on a nil validator.
+func (v *layerValidator) setSource(source string) {
+	if v != nil {
+		v.source = source
+	}
+}
+
+// fileSource describes a file of the layer, including the labels that provide its path if known.
+func (v *layerValidator) fileSource(file, pathInImage string) string {
+	if v == nil {
+		return ""
+	}
+	if labels := v.labels[pathInImage]; len(labels) > 0 {
+		return fmt.Sprintf("%s (%s)", file, strings.Join(labels, ", "))
+	}
+	return file
+}
+
+func (v *layerValidator) record(hdr *tar.Header) {
+	name := path.Clean("/" + hdr.Name)
+	if name == "/" {
+		return
+	}
+	v.entries[name] = append(v.entries[name], validatedEntry{typeflag: hdr.Typeflag, linkname: hdr.Linkname, size: hdr.Size, source: v.source})
+	for dir := path.Dir(name); !v.dirs[dir]; dir = path.Dir(dir) {
+		v.dirs[dir] = true
+	}
+}
+
+// wrap returns a TarCAS that records every entry before writing it to tf.
+func (v *layerValidator) wrap(tf api.TarCAS) api.TarCAS {
+	return validatingTarCAS{TarCAS: tf, v: v}
+}
+
+// check returns an error describing all problems found in the recorded entries.
+func (v *layerValidator) check() error {
+	names := make([]string, 0, len(v.entries))
+	for name := range v.entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var problems []string
+	for _, name := range names {
+		entries := v.entries[name]
+		if v.failOnDuplicatePath && isDuplicate(entries) {
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "%s is written %d times:", name, len(entries))
+			for _, entry := range entries {
+				fmt.Fprintf(&sb, "\n    %s from %s", typeName(entry.typeflag), entry.source)
+			}
+			problems = append(problems, sb.String())
+		}
+		for _, entry := range entries {
+			if entry.typeflag != tar.TypeSymlink {
+				continue
+			}
+			if !v.allowAbsoluteSymlinks && path.IsAbs(entry.linkname) {
+				problems = append(problems, fmt.Sprintf("%s -> %s is an absolute symlink (from %s)", name, entry.linkname, entry.source))
+			}
+			if v.failOnDanglingSymlink && !v.exists(symlinkTarget(name, entry.linkname), maxSymlinkHops) {
+				problems = append(problems, fmt.Sprintf("%s -> %s is a dangling symlink (from %s)", name, entry.linkname, entry.source))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problem(s) found in the layer:\n  %s", len(problems), strings.Join(problems, "\n  "))
+}
+
+// exists reports whether the absolute, cleaned path p exists in the layer, following symlinks in the layer.
+// Only the layer itself is considered: symlinks to files of other layers (like the base image) are dangling.
+func (v *layerValidator) exists(p string, hops int) bool {
+	if p == "/.cas" || strings.HasPrefix(p, "/.cas/") {
+		// CAS objects are written by the layer itself
+		return true
+	}
+	components := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	current := "/"
+	for i, component := range components {
+		if component == "" {
+			continue
+		}
+		next := path.Join(current, component)
+		entries := v.entries[next]
+		if len(entries) == 0 {
+			if !v.dirs[next] {
+				return false
+			}
+			current = next
+			continue
+		}
+		// the last entry at a path wins when the layer is extracted
+		last := entries[len(entries)-1]
+		if last.typeflag != tar.TypeSymlink {
+			current = next
+			continue
+		}
+		if hops == 0 {
+			return false
+		}
+		rest := append([]string{symlinkTarget(next, last.linkname)}, components[i+1:]...)
+		return v.exists(path.Join(rest...), hops-1)
+	}
+	return true
+}
+
+// isDuplicate reports whether a path is written more than once with an entry that is not a directory.
+// Writing the same directory more than once is harmless.
+func isDuplicate(entries []validatedEntry) bool {
+	if len(entries) < 2 {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.typeflag != tar.TypeDir {
+			return true
+		}
+	}
+	return false
+}
+
+// symlinkTarget returns the absolute path a symlink at linkPath points to.
+func symlinkTarget(linkPath, linkname string) string {
+	if path.IsAbs(linkname) {
+		return path.Clean(linkname)
+	}
+	return path.Join(path.Dir(linkPath), linkname)
+}
+
+func typeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "regular file"
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	default:
+		return "special file"
+	}
+}
+
+// validatingTarCAS records all entries in the validator before writing them.
+type validatingTarCAS struct {
+	api.TarCAS
+	v *layerValidator
+}
+
+func (t validatingTarCAS) WriteHeader(hdr *tar.Header) error {
+	t.v.record(hdr)
+	return t.TarCAS.WriteHeader(hdr)
+}
+
+func (t validatingTarCAS) WriteRegular(hdr *tar.Header, r io.Reader) error {
+	t.v.record(hdr)
+	return t.TarCAS.WriteRegular(hdr, r)
+}
+
+func (t validatingTarCAS) WriteRegularDeduplicated(hdr *tar.Header, r io.Reader) error {
+	t.v.record(hdr)
+	return t.TarCAS.WriteRegularDeduplicated(hdr, r)
+}
+
+func (t validatingTarCAS) WriteRegularFromPath(hdr *tar.Header, filePath string) error {
+	t.v.record(hdr)
+	return t.TarCAS.WriteRegularFromPath(hdr, filePath)
+}
+
+func (t validatingTarCAS) WriteRegularFromPathDeduplicated(hdr *tar.Header, filePath string) error {
+	t.v.record(hdr)
+	return t.TarCAS.WriteRegularFromPathDeduplicated(hdr, filePath)
+}
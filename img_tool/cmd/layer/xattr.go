@@ -0,0 +1,101 @@
+package layer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// paxXattrPrefix is the prefix of PAX records that store extended attributes
+// (understood by GNU tar, bsdtar and container runtimes).
+const paxXattrPrefix = "SCHILY.xattr."
+
+// xattrCapability is the extended attribute holding the file capabilities of an executable.
+const xattrCapability = "security.capability"
+
+// File capability encoding (see linux/capability.h).
+const (
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// capabilityNames are the Linux capabilities in the order of their bit numbers.
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill", "setgid", "setuid",
+	"setpcap", "linux_immutable", "net_bind_service", "net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct", "sys_admin", "sys_boot", "sys_nice",
+	"sys_resource", "sys_time", "sys_tty_config", "mknod", "lease", "audit_write", "audit_control", "setfcap",
+	"mac_override", "mac_admin", "syslog", "wake_alarm", "block_suspend", "audit_read", "perfmon", "bpf",
+	"checkpoint_restore",
+}
+
+// decodeXattrValue returns the raw value of an extended attribute.
+// Values use the encoding of setfattr: "0x" starts a hex value, "0s" a base64 value and anything else is used as is.
+// Values of security.capability without a prefix are parsed like the capabilities of setcap (e.g. "cap_net_bind_service+ep").
+func decodeXattrValue(name, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X"):
+		raw, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return "", fmt.Errorf("invalid hex value of extended attribute %s: %w", name, err)
+		}
+		return string(raw), nil
+	case strings.HasPrefix(value, "0s") || strings.HasPrefix(value, "0S"):
+		raw, err := base64.StdEncoding.DecodeString(value[2:])
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 value of extended attribute %s: %w", name, err)
+		}
+		return string(raw), nil
+	case name == xattrCapability:
+		return encodeCapabilities(value)
+	}
+	return value, nil
+}
+
+// encodeCapabilities encodes capabilities in the text form of setcap ("cap_a,cap_b+eip" or "cap_a,cap_b=eip")
+// as the value of security.capability (revision 2, which is understood by all supported kernels).
+func encodeCapabilities(text string) (string, error) {
+	sep := strings.LastIndexAny(text, "+=")
+	if sep < 0 {
+		return "", fmt.Errorf("invalid capabilities %q: expected a list of capabilities followed by flags, like cap_net_bind_service+ep", text)
+	}
+	var mask uint64
+	for _, name := range strings.Split(text[:sep], ",") {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "cap_")
+		bit := -1
+		for i, known := range capabilityNames {
+			if known == name {
+				bit = i
+				break
+			}
+		}
+		if bit < 0 {
+			return "", fmt.Errorf("invalid capabilities %q: unknown capability cap_%s", text, name)
+		}
+		mask |= 1 << bit
+	}
+	var magic uint32 = vfsCapRevision2
+	var permitted, inheritable uint64
+	for _, flag := range strings.TrimPrefix(strings.ToLower(text[sep+1:]), "+") {
+		switch flag {
+		case 'e':
+			magic |= vfsCapFlagsEffective
+		case 'p':
+			permitted = mask
+		case 'i':
+			inheritable = mask
+		default:
+			return "", fmt.Errorf("invalid capabilities %q: unknown flag %q (expected e, i or p)", text, flag)
+		}
+	}
+
+	encoded := make([]byte, 0, 20)
+	encoded = binary.LittleEndian.AppendUint32(encoded, magic)
+	encoded = binary.LittleEndian.AppendUint32(encoded, uint32(permitted))
+	encoded = binary.LittleEndian.AppendUint32(encoded, uint32(inheritable))
+	encoded = binary.LittleEndian.AppendUint32(encoded, uint32(permitted>>32))
+	encoded = binary.LittleEndian.AppendUint32(encoded, uint32(inheritable>>32))
+	return string(encoded), nil
+}
@@ -11,15 +11,33 @@ import (
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
-var (
-	layerName   string
-	annotations annotationsFlag
-)
+// Config holds the options of a layer-metadata invocation.
+type Config struct {
+	// Name of the layer. Defaults to the digest.
+	Name        string
+	Annotations map[string]string
+	// LayerFile is the path of the existing layer.
+	LayerFile string
+	// Output receives the layer metadata.
+	Output string
+}
+
+// Runner calculates metadata about an existing layer file.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
 
 func LayerMetadataProcess(ctx context.Context, args []string) {
-	annotations = make(annotationsFlag)
+	annotations := make(annotationsFlag)
+	var cfg Config
 	flagSet := flag.NewFlagSet("layer-metadata", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Calculates metadata about an existing layer file.\n\n")
@@ -35,8 +53,9 @@ func LayerMetadataProcess(ctx context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.StringVar(&layerName, "name", "", `Optional name of the layer. Defaults to digest.`)
+	flagSet.StringVar(&cfg.Name, "name", "", `Optional name of the layer. Defaults to digest.`)
 	flagSet.Var(&annotations, "annotation", `Add an annotation as key=value. Can be specified multiple times.`)
+	logging.RegisterFlags(flagSet)
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
 		os.Exit(1)
@@ -47,61 +66,60 @@ func LayerMetadataProcess(ctx context.Context, args []string) {
 		os.Exit(1)
 	}
 
-	layerFile := flagSet.Arg(0)
-	outputFile := flagSet.Arg(1)
+	cfg.Annotations = annotations
+	cfg.LayerFile = flagSet.Arg(0)
+	cfg.Output = flagSet.Arg(1)
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing layer metadata", logging.ErrKey, err)
+	}
+}
 
-	layerFileHandle, err := os.Open(layerFile)
+// Run calculates the metadata of the layer and writes it to the output file.
+func (r *Runner) Run(_ context.Context) error {
+	layerFileHandle, err := os.Open(r.cfg.LayerFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening layer file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("opening layer file: %w", err)
 	}
 	defer layerFileHandle.Close()
 
 	hasher := sha256.New()
 	compressedSize, err := io.Copy(hasher, layerFileHandle)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading layer file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("reading layer file: %w", err)
 	}
-	_, err = layerFileHandle.Seek(0, io.SeekStart)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error seeking to start of layer file: %v\n", err)
-		os.Exit(1)
+	if _, err := layerFileHandle.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to start of layer file: %w", err)
 	}
 	digest := hasher.Sum(nil)
 
 	layerFormat, err := fileopener.LearnLayerFormat(layerFileHandle)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error determining layer format: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("determining layer format: %w", err)
 	}
 
 	reader, err := fileopener.CompressionReaderWithFormat(layerFileHandle, layerFormat.CompressionAlgorithm())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening layer file with compression: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("opening layer file with compression: %w", err)
 	}
 
-	layerMetadata, err := calculateLayerMetadata(reader, digest, compressedSize, layerFormat, annotations)
+	layerMetadata, err := calculateLayerMetadata(r.cfg.Name, reader, digest, compressedSize, layerFormat, r.cfg.Annotations)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
-	outputFileHandle, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	outputFileHandle, err := os.OpenFile(r.cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("opening output file: %w", err)
 	}
 	defer outputFileHandle.Close()
 
-	json.NewEncoder(outputFileHandle).SetIndent("", "  ")
 	if err := json.NewEncoder(outputFileHandle).Encode(layerMetadata); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("writing output file: %w", err)
 	}
+	return nil
 }
 
-func calculateLayerMetadata(layerFile io.Reader, digest []byte, compressedSize int64, layerFormat api.LayerFormat, annotations map[string]string) (api.Descriptor, error) {
+func calculateLayerMetadata(layerName string, layerFile io.Reader, digest []byte, compressedSize int64, layerFormat api.LayerFormat, annotations map[string]string) (api.Descriptor, error) {
 	if len(layerName) == 0 {
 		layerName = fmt.Sprintf("sha256:%x", digest)
 	}
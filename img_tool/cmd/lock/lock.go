@@ -0,0 +1,220 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// ErrOutdated is returned by Run in check mode if the lock file is missing images.
+var ErrOutdated = errors.New("lock file is out of date")
+
+// Config holds the options of a lock invocation.
+type Config struct {
+	// LockFile is the path of the lock file to update.
+	LockFile string
+	// Images are references of the form registry/repository:tag to add to the lock file.
+	Images []string
+	// Update re-resolves the digests of all images in the lock file.
+	// Otherwise, only images that are not locked yet are resolved.
+	Update bool
+	// Check only verifies that all images are locked without resolving any tags.
+	Check bool
+}
+
+// Runner resolves tags to digests and writes them to a lock file.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func LockProcess(ctx context.Context, args []string) {
+	var cfg Config
+	var images stringSliceFlag
+	var tlsOptions reg.TLSOptions
+
+	flagSet := flag.NewFlagSet("lock", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Resolves floating tags of base images to digests and writes them to a lock file.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img lock [OPTIONS] [lock_file]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img lock --image index.docker.io/library/ubuntu:24.04 img.lock.json",
+			"img lock --update img.lock.json",
+			"img lock --check --image index.docker.io/library/ubuntu:24.04 img.lock.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.Var(&images, "image", "Image to lock in the form registry/repository:tag (can be specified multiple times).")
+	flagSet.BoolVar(&cfg.Update, "update", false, "Refresh the digests of all images in the lock file. By default, only images that are not locked yet are resolved.")
+	flagSet.BoolVar(&cfg.Check, "check", false, "Only check that all images are locked. Exits with an error if the lock file is out of date.")
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	cfg.LockFile = flagSet.Arg(0)
+	cfg.Images = images
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Updating lock file", logging.ErrKey, err)
+	}
+}
+
+// dispatchRequest is the request written by the image_lock rule.
+type dispatchRequest struct {
+	// LockFile is the path of the lock file relative to the workspace root.
+	LockFile string   `json:"lock_file"`
+	Images   []string `json:"images"`
+}
+
+// LockDispatch updates the lock file of an image_lock target (bazel run).
+// The flags --update and --check can be passed on the command line.
+func LockDispatch(ctx context.Context, rawRequest []byte, args []string) {
+	var request dispatchRequest
+	if err := json.Unmarshal(rawRequest, &request); err != nil {
+		logging.Fatal("Unmarshaling lock request", logging.ErrKey, err)
+	}
+	workspace := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	if workspace == "" {
+		logging.Fatal(`BUILD_WORKSPACE_DIRECTORY is not set, use "bazel run" to update the lock file`)
+	}
+	cfg := Config{
+		LockFile: filepath.Join(workspace, filepath.FromSlash(request.LockFile)),
+		Images:   request.Images,
+	}
+	flagSet := flag.NewFlagSet("lock", flag.ExitOnError)
+	flagSet.BoolVar(&cfg.Update, "update", false, "Refresh the digests of all images in the lock file.")
+	flagSet.BoolVar(&cfg.Check, "check", false, "Only check that all images are locked.")
+	var tlsOptions reg.TLSOptions
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Updating lock file", logging.ErrKey, err)
+	}
+}
+
+// Run updates the lock file.
+func (r *Runner) Run(ctx context.Context) error {
+	if r.cfg.Check && r.cfg.Update {
+		return errors.New("--check and --update cannot be combined")
+	}
+	lockFile, err := ReadLockFile(r.cfg.LockFile)
+	if err != nil {
+		return err
+	}
+
+	requested := make(map[string]LockedImage)
+	for _, image := range r.cfg.Images {
+		locked, err := parseImage(image)
+		if err != nil {
+			return err
+		}
+		requested[locked.key()] = locked
+	}
+
+	var toResolve []LockedImage
+	for key, image := range requested {
+		if existing, ok := lockFile.Images[key]; !ok || existing.Digest == "" || r.cfg.Update {
+			toResolve = append(toResolve, image)
+		}
+	}
+	if r.cfg.Update {
+		for key, image := range lockFile.Images {
+			if _, ok := requested[key]; !ok {
+				toResolve = append(toResolve, image)
+			}
+		}
+	}
+	slices.SortFunc(toResolve, func(a, b LockedImage) int { return strings.Compare(a.key(), b.key()) })
+
+	if r.cfg.Check {
+		for _, image := range toResolve {
+			slog.Warn("Image is not locked", "image", image.key())
+		}
+		if len(toResolve) > 0 {
+			return fmt.Errorf("%w: run img lock to update %s", ErrOutdated, r.cfg.LockFile)
+		}
+		return nil
+	}
+
+	for _, image := range toResolve {
+		digest, err := resolve(ctx, image)
+		if err != nil {
+			return err
+		}
+		if previous := lockFile.Images[image.key()].Digest; previous != "" && previous != digest {
+			slog.Info("Updated image", "image", image.key(), "previous", previous, "digest", digest)
+		} else if previous == "" {
+			slog.Info("Locked image", "image", image.key(), "digest", digest)
+		}
+		image.Digest = digest
+		lockFile.Images[image.key()] = image
+	}
+	return lockFile.Write(r.cfg.LockFile)
+}
+
+// resolve returns the digest the tag of the image currently points to.
+func resolve(ctx context.Context, image LockedImage) (string, error) {
+	ref, err := name.NewTag(image.key())
+	if err != nil {
+		return "", fmt.Errorf("creating reference for %s: %w", image.key(), err)
+	}
+	opts := []remote.Option{reg.WithAuthFromMultiKeychain(), remote.WithContext(ctx)}
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		// Some registries don't return the digest on HEAD requests.
+		getDesc, getErr := remote.Get(ref, opts...)
+		if getErr != nil {
+			return "", fmt.Errorf("resolving %s: %w", image.key(), reg.Diagnose(getErr))
+		}
+		return getDesc.Digest.String(), nil
+	}
+	return desc.Digest.String(), nil
+}
+
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
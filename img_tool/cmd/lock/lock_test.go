@@ -0,0 +1,124 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+// pushRandomImage pushes a new image to ref and returns its digest.
+func pushRandomImage(t *testing.T, ref string) registryv1.Hash {
+	t.Helper()
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := serverURL.Host + "/app:v1"
+	base := serverURL.Host + "/base:latest"
+	appV1 := pushRandomImage(t, app)
+	baseV1 := pushRandomImage(t, base)
+	lockPath := filepath.Join(t.TempDir(), "img.lock.json")
+
+	digests := func() map[string]string {
+		t.Helper()
+		lockFile, err := ReadLockFile(lockPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests := make(map[string]string)
+		for key, image := range lockFile.Images {
+			digests[key] = image.Digest
+		}
+		return digests
+	}
+
+	// check mode fails for images that are not locked yet
+	err = NewRunner(Config{LockFile: lockPath, Images: []string{app}, Check: true}).Run(context.Background())
+	if !errors.Is(err, ErrOutdated) {
+		t.Errorf("Run() in check mode with unlocked image: error = %v, want %v", err, ErrOutdated)
+	}
+
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{app, base}}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := digests(); got[app] != appV1.String() || got[base] != baseV1.String() {
+		t.Errorf("locked digests = %v, want %s and %s", got, appV1, baseV1)
+	}
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{app, base}, Check: true}).Run(context.Background()); err != nil {
+		t.Errorf("Run() in check mode with locked images: %v", err)
+	}
+
+	// locked images keep their digest until they are updated
+	appV2 := pushRandomImage(t, app)
+	baseV2 := pushRandomImage(t, base)
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{app}}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := digests(); got[app] != appV1.String() {
+		t.Errorf("locked digest of %s changed to %s without --update", app, got[app])
+	}
+	// --update refreshes all images of the lock file, including the ones that were not requested
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{app}, Update: true}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := digests(); got[app] != appV2.String() || got[base] != baseV2.String() {
+		t.Errorf("updated digests = %v, want %s and %s", got, appV2, baseV2)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "img.lock.json")
+	if err := NewRunner(Config{LockFile: lockPath, Check: true, Update: true}).Run(context.Background()); err == nil || err.Error() != "--check and --update cannot be combined" {
+		t.Errorf("Run() with --check and --update: error = %v", err)
+	}
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{"ubuntu:24.04"}}).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "expected registry/repository:tag") {
+		t.Errorf("Run() with an image without registry: error = %v", err)
+	}
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := serverURL.Host + "/missing:v1"
+	if err := NewRunner(Config{LockFile: lockPath, Images: []string{missing}}).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "resolving "+missing) {
+		t.Errorf("Run() with a missing image: error = %v", err)
+	}
+	if _, err := ReadLockFile(lockPath); err != nil {
+		t.Errorf("lock file is unreadable after a failed run: %v", err)
+	}
+}
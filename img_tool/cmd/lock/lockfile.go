@@ -0,0 +1,98 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// lockFileVersion is the version of the lock file format written by img lock.
+const lockFileVersion = 1
+
+// LockFile pins floating tags of base images to digests.
+// It is read by the pull repository rule (see the lock_file attribute).
+type LockFile struct {
+	Version int `json:"version"`
+	// Images maps an image reference of the form registry/repository:tag to the locked image.
+	Images map[string]LockedImage `json:"images"`
+}
+
+// LockedImage is the digest a tag resolved to when the lock file was last updated.
+type LockedImage struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+}
+
+// ReadLockFile reads the lock file at path.
+// A missing or empty file is treated as an empty lock file.
+func ReadLockFile(path string) (LockFile, error) {
+	lockFile := LockFile{Version: lockFileVersion, Images: make(map[string]LockedImage)}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lockFile, nil
+	} else if err != nil {
+		return LockFile{}, fmt.Errorf("reading lock file: %w", err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return lockFile, nil
+	}
+	if err := json.Unmarshal(raw, &lockFile); err != nil {
+		return LockFile{}, fmt.Errorf("parsing lock file %s: %w", path, err)
+	}
+	if lockFile.Version > lockFileVersion {
+		return LockFile{}, fmt.Errorf("lock file %s has version %d, but only versions up to %d are supported", path, lockFile.Version, lockFileVersion)
+	}
+	lockFile.Version = lockFileVersion
+	if lockFile.Images == nil {
+		lockFile.Images = make(map[string]LockedImage)
+	}
+	return lockFile, nil
+}
+
+// Write writes the lock file to path.
+// The output is stable (sorted keys, fixed indentation), so that it produces small diffs when checked in.
+func (l LockFile) Write(path string) error {
+	raw, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling lock file: %w", err)
+	}
+	raw = append(raw, '\n')
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return fmt.Errorf("writing lock file: %w", err)
+	}
+	return nil
+}
+
+// parseImage parses an image reference of the form registry/repository:tag.
+// The registry is required and the reference is not normalized,
+// so that the key in the lock file matches the attributes of the pull rule.
+func parseImage(image string) (LockedImage, error) {
+	registry, rest, ok := strings.Cut(image, "/")
+	if !ok || registry == "" {
+		return LockedImage{}, fmt.Errorf("invalid image %q: expected registry/repository:tag", image)
+	}
+	lastSlash := strings.LastIndex(rest, "/")
+	colon := strings.LastIndex(rest, ":")
+	if colon <= lastSlash || colon == len(rest)-1 {
+		return LockedImage{}, fmt.Errorf("invalid image %q: expected registry/repository:tag", image)
+	}
+	if strings.Contains(rest, "@") {
+		return LockedImage{}, fmt.Errorf("invalid image %q: only tags can be locked", image)
+	}
+	return LockedImage{
+		Registry:   registry,
+		Repository: rest[:colon],
+		Tag:        rest[colon+1:],
+	}, nil
+}
+
+// key returns the key of the image in the lock file.
+func (i LockedImage) key() string {
+	return fmt.Sprintf("%s/%s:%s", i.Registry, i.Repository, i.Tag)
+}
@@ -0,0 +1,120 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    LockedImage
+		wantErr string
+	}{
+		{image: "index.docker.io/library/ubuntu:24.04", want: LockedImage{Registry: "index.docker.io", Repository: "library/ubuntu", Tag: "24.04"}},
+		{image: "localhost:5000/app:latest", want: LockedImage{Registry: "localhost:5000", Repository: "app", Tag: "latest"}},
+		{image: "ubuntu:24.04", wantErr: "expected registry/repository:tag"},
+		{image: "localhost:5000/app", wantErr: "expected registry/repository:tag"},
+		{image: "index.docker.io/library/ubuntu:", wantErr: "expected registry/repository:tag"},
+		{image: "/library/ubuntu:24.04", wantErr: "expected registry/repository:tag"},
+		{image: "index.docker.io/library/ubuntu@sha256:abc:1", wantErr: "only tags can be locked"},
+	}
+	for _, tt := range tests {
+		got, err := parseImage(tt.image)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseImage(%q) error = %v, want %q", tt.image, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseImage(%q): %v", tt.image, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseImage(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+		if got.key() != tt.image {
+			t.Errorf("key() = %q, want %q", got.key(), tt.image)
+		}
+	}
+}
+
+func TestReadLockFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), write("empty.json", " \n")} {
+		lockFile, err := ReadLockFile(path)
+		if err != nil {
+			t.Fatalf("ReadLockFile(%s): %v", path, err)
+		}
+		if lockFile.Version != lockFileVersion || lockFile.Images == nil || len(lockFile.Images) != 0 {
+			t.Errorf("ReadLockFile(%s) = %+v, want an empty lock file", path, lockFile)
+		}
+	}
+
+	lockFile, err := ReadLockFile(write("noimages.json", `{"version":0}`))
+	if err != nil || lockFile.Version != lockFileVersion || lockFile.Images == nil {
+		t.Errorf("ReadLockFile() of a lock file without images = %+v, %v", lockFile, err)
+	}
+
+	if _, err := ReadLockFile(write("future.json", `{"version":2,"images":{}}`)); err == nil || !strings.Contains(err.Error(), "has version 2, but only versions up to 1 are supported") {
+		t.Errorf("ReadLockFile() of a newer version: error = %v", err)
+	}
+	if _, err := ReadLockFile(write("invalid.json", `{"images":[]}`)); err == nil || !strings.Contains(err.Error(), "parsing lock file") {
+		t.Errorf("ReadLockFile() of invalid JSON: error = %v", err)
+	}
+}
+
+func TestWriteLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "img.lock.json")
+	lockFile := LockFile{Version: lockFileVersion, Images: map[string]LockedImage{
+		"b.example.com/app:v1": {Registry: "b.example.com", Repository: "app", Tag: "v1", Digest: "sha256:bbb"},
+		"a.example.com/app:v1": {Registry: "a.example.com", Repository: "app", Tag: "v1", Digest: "sha256:aaa"},
+	}}
+	if err := lockFile.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "version": 1,
+  "images": {
+    "a.example.com/app:v1": {
+      "registry": "a.example.com",
+      "repository": "app",
+      "tag": "v1",
+      "digest": "sha256:aaa"
+    },
+    "b.example.com/app:v1": {
+      "registry": "b.example.com",
+      "repository": "app",
+      "tag": "v1",
+      "digest": "sha256:bbb"
+    }
+  }
+}
+`
+	if string(raw) != want {
+		t.Errorf("lock file = %s, want %s", raw, want)
+	}
+
+	roundTrip, err := ReadLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roundTrip.Images) != 2 || roundTrip.Images["a.example.com/app:v1"].Digest != "sha256:aaa" {
+		t.Errorf("ReadLockFile() of a written lock file = %+v", roundTrip)
+	}
+}
@@ -0,0 +1,299 @@
+package login
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/registriesconf"
+)
+
+// Config holds the options of a login.
+type Config struct {
+	// Registry is the registry to log in to, like ghcr.io. Defaults to the registry of Repository.
+	Registry string
+	// Repository is a repository (like ghcr.io/my-org/my-app) that is checked for Scope access (optional).
+	Repository string
+	// Scope is the access to Repository that is checked: "pull" or "push".
+	Scope string
+	// Username and Password are new credentials, which are checked and stored.
+	// Without a username, the credentials that push and pull would use are checked.
+	Username string
+	Password string
+	// NoStore only checks new credentials, without storing them.
+	NoStore bool
+}
+
+// Runner checks (and stores) registry credentials.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func LoginProcess(ctx context.Context, args []string) {
+	var cfg Config
+	var passwordStdin bool
+	var tlsOptions reg.TLSOptions
+	var registriesConfOptions registriesconf.Options
+
+	flagSet := flag.NewFlagSet("login", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Checks credentials for a container registry and stores them where image_push and pull look them up.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Without --username, the credentials that are already configured (Docker config, credential helpers and default keychains) are checked.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img login [OPTIONS] [REGISTRY]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img login --repository ghcr.io/my-org/my-app --scope push",
+			"echo \"$TOKEN\" | img login --username my-user --password-stdin ghcr.io",
+			"echo \"$TOKEN\" | img login --username my-user --password-stdin --repository ghcr.io/my-org/my-app --scope push --no-store",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&cfg.Repository, "repository", "", `Repository to check access to, like ghcr.io/my-org/my-app. Without a repository, only the login to the registry is checked.`)
+	flagSet.StringVar(&cfg.Scope, "scope", "pull", `Access to --repository that is checked: "pull" (list tags) or "push" (start and cancel a blob upload).`)
+	flagSet.StringVar(&cfg.Username, "username", "", `Username of new credentials. Requires --password-stdin.`)
+	flagSet.BoolVar(&passwordStdin, "password-stdin", false, `Read the password (or token) of --username from stdin.`)
+	flagSet.BoolVar(&cfg.NoStore, "no-store", false, `Only check the credentials of --username, without storing them.`)
+	tlsOptions.RegisterFlags(flagSet)
+	registriesConfOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() > 1 {
+		slog.Error("Expected at most one registry", "args", strings.Join(flagSet.Args(), " "))
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	cfg.Registry = flagSet.Arg(0)
+	if cfg.Registry == "" && cfg.Repository == "" {
+		slog.Error("A registry or --repository is required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if cfg.Scope != "pull" && cfg.Scope != "push" {
+		slog.Error("--scope must be pull or push", "scope", cfg.Scope)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if (cfg.Username != "") != passwordStdin {
+		slog.Error("--username and --password-stdin must be used together")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if passwordStdin {
+		password, err := readPassword(os.Stdin)
+		if err != nil {
+			logging.Fatal("Reading password from stdin", logging.ErrKey, err)
+		}
+		cfg.Password = password
+	}
+
+	registriesConf, err := registriesConfOptions.Load()
+	if err != nil {
+		logging.Fatal("Loading registries configuration", logging.ErrKey, err)
+	}
+	registriesConf.ConfigureTLS(&tlsOptions)
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+
+	if err := NewRunner(cfg).Run(ctx, os.Stdout); err != nil {
+		logging.Fatal("Login failed", logging.ErrKey, err)
+	}
+}
+
+// Run checks the credentials with a token exchange and (with a repository) a request of the scope.
+// New credentials are stored afterwards, unless NoStore is set.
+func (r *Runner) Run(ctx context.Context, out io.Writer) error {
+	registry, repo, err := r.target()
+	if err != nil {
+		return err
+	}
+
+	var resource authn.Resource = registry
+	if repo != nil {
+		resource = *repo
+	}
+	var auth authn.Authenticator
+	if r.cfg.Username != "" {
+		auth = authn.FromConfig(authn.AuthConfig{Username: r.cfg.Username, Password: r.cfg.Password})
+	} else {
+		auth, err = authn.Resolve(ctx, reg.MultiKeychain(), resource)
+		if err != nil {
+			return fmt.Errorf("resolving credentials for %s: %w", registry, err)
+		}
+		if auth == authn.Anonymous {
+			slog.Warn("No credentials found, checking anonymous access", "registry", registry.RegistryStr())
+		}
+	}
+
+	if err := checkAccess(ctx, registry, repo, auth, r.cfg.Scope); err != nil {
+		if r.cfg.Username == "" {
+			// explains how the credentials were looked up
+			return reg.Diagnose(err)
+		}
+		return err
+	}
+	if repo != nil {
+		fmt.Fprintf(out, "Credentials for %s are valid (%s access to %s)\n", registry, r.cfg.Scope, repo)
+	} else {
+		fmt.Fprintf(out, "Credentials for %s are valid\n", registry)
+	}
+
+	if r.cfg.Username == "" || r.cfg.NoStore {
+		return nil
+	}
+	location, err := reg.StoreCredentials(ctx, registry.RegistryStr(), r.cfg.Username, r.cfg.Password)
+	if err != nil {
+		return fmt.Errorf("storing credentials: %w", err)
+	}
+	fmt.Fprintf(out, "Stored credentials for %s in %s\n", registry, location)
+	return nil
+}
+
+// target returns the registry and the (optional) repository to check.
+func (r *Runner) target() (name.Registry, *name.Repository, error) {
+	var repo *name.Repository
+	if r.cfg.Repository != "" {
+		parsed, err := name.NewRepository(r.cfg.Repository)
+		if err != nil {
+			return name.Registry{}, nil, fmt.Errorf("invalid repository: %w", err)
+		}
+		repo = &parsed
+	}
+	if r.cfg.Registry == "" {
+		return repo.Registry, repo, nil
+	}
+	registry, err := name.NewRegistry(r.cfg.Registry)
+	if err != nil {
+		return name.Registry{}, nil, fmt.Errorf("invalid registry: %w", err)
+	}
+	if repo != nil && repo.RegistryStr() != registry.RegistryStr() {
+		return name.Registry{}, nil, fmt.Errorf("repository %s is not in registry %s", repo, registry)
+	}
+	return registry, repo, nil
+}
+
+// checkAccess exchanges the credentials for a token of the scope.
+// Many registries hand out tokens without the requested scope (or anonymous tokens),
+// so the scope is confirmed with a request to the repository.
+func checkAccess(ctx context.Context, registry name.Registry, repo *name.Repository, auth authn.Authenticator, scope string) error {
+	var scopes []string
+	if repo != nil {
+		actions := transport.PullScope
+		if scope == "push" {
+			actions = transport.PushScope
+		}
+		scopes = []string{repo.Scope(actions)}
+	}
+	rt, err := transport.NewWithContext(ctx, registry, auth, reg.Transport(reg.BaseTransport()), scopes)
+	if err != nil {
+		return fmt.Errorf("authenticating to %s: %w", registry, err)
+	}
+	if repo == nil {
+		return nil
+	}
+	client := &http.Client{Transport: rt}
+	if scope == "push" {
+		return checkPush(ctx, client, *repo)
+	}
+	return checkPull(ctx, client, *repo)
+}
+
+// checkPull lists the tags of the repository.
+func checkPull(ctx context.Context, client *http.Client, repo name.Repository) error {
+	u := repositoryURL(repo, "tags/list")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("listing tags of %s: %w", repo, err)
+	}
+	defer resp.Body.Close()
+	// a repository that doesn't exist yet is not a problem of the credentials
+	if err := transport.CheckError(resp, http.StatusOK, http.StatusNotFound); err != nil {
+		return fmt.Errorf("listing tags of %s: %w", repo, err)
+	}
+	return nil
+}
+
+// checkPush starts a blob upload and cancels it, so nothing is written to the repository.
+func checkPush(ctx context.Context, client *http.Client, repo name.Repository) error {
+	u := repositoryURL(repo, "blobs/uploads/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("starting upload to %s: %w", repo, err)
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusAccepted); err != nil {
+		return fmt.Errorf("starting upload to %s: %w", repo, err)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		slog.Debug("Upload has no location to cancel", "repository", repo.String(), logging.ErrKey, err)
+		return nil
+	}
+	// not every registry supports canceling uploads, unfinished uploads expire
+	cancelReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, location.String(), nil)
+	if err != nil {
+		return nil
+	}
+	if cancelResp, err := client.Do(cancelReq); err == nil {
+		cancelResp.Body.Close()
+	} else {
+		slog.Debug("Canceling upload", "repository", repo.String(), logging.ErrKey, err)
+	}
+	return nil
+}
+
+func repositoryURL(repo name.Repository, path string) *url.URL {
+	return &url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/%s", repo.RepositoryStr(), path),
+	}
+}
+
+// readPassword reads the first line of r, without the line ending.
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("the password is empty")
+	}
+	return password, nil
+}
@@ -0,0 +1,177 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// image is an OCI image config extended by fields of the Docker image spec.
+// These fields are not part of the OCI spec, but some runtimes and platforms
+// still inspect them.
+type image struct {
+	specv1.Image
+	Config imageConfig `json:"config,omitempty"`
+}
+
+// MarshalJSON writes the fields in the order of the OCI image config.
+// Without it, the embedded specv1.Image is written before config,
+// which changes the digest of every config.
+func (i image) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Created *time.Time `json:"created,omitempty"`
+		Author  string     `json:"author,omitempty"`
+		specv1.Platform
+		Config  imageConfig      `json:"config,omitempty"`
+		RootFS  specv1.RootFS    `json:"rootfs"`
+		History []specv1.History `json:"history,omitempty"`
+	}{
+		Created:  i.Created,
+		Author:   i.Author,
+		Platform: i.Platform,
+		Config:   i.Config,
+		RootFS:   i.RootFS,
+		History:  i.History,
+	})
+}
+
+// imageConfig is the execution config of an image,
+// including the legacy fields of Docker.
+type imageConfig struct {
+	specv1.ImageConfig
+	// OnBuild holds Dockerfile instructions that are executed
+	// when the image is used as the base image of a Dockerfile build.
+	OnBuild []string `json:"OnBuild,omitempty"`
+	// Shell is the shell used for the shell form of Dockerfile instructions.
+	Shell []string `json:"Shell,omitempty"`
+	// Healthcheck is the command Docker runs to check that the container is healthy.
+	Healthcheck *healthConfig `json:"Healthcheck,omitempty"`
+}
+
+// healthConfig is the health check of a container, as defined by Docker.
+// Durations are encoded as nanoseconds.
+type healthConfig struct {
+	// Test is either ["NONE"] (disable the inherited health check),
+	// ["CMD", args...] (exec form) or ["CMD-SHELL", command] (shell form).
+	Test        []string      `json:"Test,omitempty"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// onBuildForbidden lists instructions that Docker does not allow as ONBUILD triggers.
+var onBuildForbidden = []string{"ONBUILD", "FROM", "MAINTAINER"}
+
+// validateOnBuild checks that every trigger is a Dockerfile instruction
+// that can be used with ONBUILD.
+func validateOnBuild(triggers []string) error {
+	for _, trigger := range triggers {
+		instruction, _, _ := strings.Cut(strings.TrimSpace(trigger), " ")
+		if instruction == "" {
+			return fmt.Errorf("empty ONBUILD trigger")
+		}
+		for _, forbidden := range onBuildForbidden {
+			if strings.EqualFold(instruction, forbidden) {
+				return fmt.Errorf("%s is not allowed as ONBUILD trigger: %q", forbidden, trigger)
+			}
+		}
+	}
+	return nil
+}
+
+// validateShell checks that the shell is a non-empty command line.
+func validateShell(shell []string) error {
+	if len(shell) == 0 {
+		return nil
+	}
+	for _, arg := range shell {
+		if arg == "" {
+			return fmt.Errorf("shell contains an empty argument: %q", shell)
+		}
+	}
+	return nil
+}
+
+// validateArgsEscaped checks that the image follows the conventions of Docker for ArgsEscaped:
+// it is only used for Windows images, where the command line
+// is a single, pre-escaped string in Entrypoint (or Cmd, if there is no Entrypoint).
+func validateArgsEscaped(config *image) error {
+	if config.OS != "windows" {
+		return fmt.Errorf("ArgsEscaped is only supported for windows images, but the image os is %s", config.OS)
+	}
+	commandLine := config.Config.Entrypoint
+	field := "entrypoint"
+	if len(commandLine) == 0 {
+		commandLine = config.Config.Cmd
+		field = "cmd"
+	}
+	if len(commandLine) != 1 {
+		return fmt.Errorf("ArgsEscaped requires the %s to be a single, pre-escaped command line, but it has %d elements", field, len(commandLine))
+	}
+	return nil
+}
+
+// normalizePort returns the port in the form port/protocol used by ExposedPorts.
+// The protocol defaults to tcp.
+func normalizePort(port string) (string, error) {
+	number, protocol, hasProtocol := strings.Cut(port, "/")
+	if !hasProtocol {
+		protocol = "tcp"
+	}
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid protocol of exposed port %q: must be tcp, udp or sctp", port)
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid exposed port %q: must be a number between 1 and 65535", port)
+	}
+	return fmt.Sprintf("%d/%s", n, protocol), nil
+}
+
+// validateHealthcheck checks a health check the same way Docker does.
+func validateHealthcheck(healthcheck *healthConfig) error {
+	if healthcheck == nil {
+		return nil
+	}
+	if len(healthcheck.Test) == 0 {
+		return fmt.Errorf("healthcheck requires a test command")
+	}
+	switch healthcheck.Test[0] {
+	case "NONE":
+		if len(healthcheck.Test) != 1 {
+			return fmt.Errorf("healthcheck test NONE takes no arguments: %q", healthcheck.Test)
+		}
+	case "CMD":
+		if len(healthcheck.Test) < 2 {
+			return fmt.Errorf("healthcheck test CMD requires a command: %q", healthcheck.Test)
+		}
+	case "CMD-SHELL":
+		if len(healthcheck.Test) != 2 {
+			return fmt.Errorf("healthcheck test CMD-SHELL requires exactly one command line: %q", healthcheck.Test)
+		}
+	default:
+		return fmt.Errorf("healthcheck test must start with NONE, CMD or CMD-SHELL: %q", healthcheck.Test)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"interval", healthcheck.Interval},
+		{"timeout", healthcheck.Timeout},
+		{"start period", healthcheck.StartPeriod},
+	} {
+		if d.value != 0 && d.value < time.Millisecond {
+			return fmt.Errorf("healthcheck %s must be at least 1ms, got %s", d.name, d.value)
+		}
+	}
+	if healthcheck.Retries < 0 {
+		return fmt.Errorf("healthcheck retries must not be negative, got %d", healthcheck.Retries)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizePort(t *testing.T) {
+	tests := []struct {
+		port    string
+		want    string
+		wantErr string
+	}{
+		{port: "8080", want: "8080/tcp"},
+		{port: "53/udp", want: "53/udp"},
+		{port: "9000/SCTP", want: "9000/sctp"},
+		{port: "0080/tcp", want: "80/tcp"},
+		{port: "80/icmp", wantErr: "invalid protocol"},
+		{port: "0", wantErr: "must be a number between 1 and 65535"},
+		{port: "65536", wantErr: "must be a number between 1 and 65535"},
+		{port: "http", wantErr: "must be a number between 1 and 65535"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			got, err := normalizePort(tt.port)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("normalizePort(%q) error = %v, want %q", tt.port, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("normalizePort(%q) = %q, %v, want %q", tt.port, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHealthcheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthcheck *healthConfig
+		wantErr     string
+	}{
+		{name: "none set"},
+		{name: "disabled", healthcheck: &healthConfig{Test: []string{"NONE"}}},
+		{name: "exec form", healthcheck: &healthConfig{Test: []string{"CMD", "/bin/check", "--quick"}, Interval: 30 * time.Second, Retries: 3}},
+		{name: "shell form", healthcheck: &healthConfig{Test: []string{"CMD-SHELL", "curl -f localhost"}}},
+		{name: "only durations of the base image", healthcheck: &healthConfig{Interval: time.Second}, wantErr: "requires a test command"},
+		{name: "NONE with arguments", healthcheck: &healthConfig{Test: []string{"NONE", "x"}}, wantErr: "NONE takes no arguments"},
+		{name: "CMD without command", healthcheck: &healthConfig{Test: []string{"CMD"}}, wantErr: "CMD requires a command"},
+		{name: "CMD-SHELL with two arguments", healthcheck: &healthConfig{Test: []string{"CMD-SHELL", "a", "b"}}, wantErr: "exactly one command line"},
+		{name: "unknown test", healthcheck: &healthConfig{Test: []string{"RUN", "x"}}, wantErr: "must start with NONE, CMD or CMD-SHELL"},
+		{name: "interval too short", healthcheck: &healthConfig{Test: []string{"NONE"}, Interval: time.Microsecond}, wantErr: "interval must be at least 1ms"},
+		{name: "negative retries", healthcheck: &healthConfig{Test: []string{"NONE"}, Retries: -1}, wantErr: "retries must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHealthcheck(tt.healthcheck)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateHealthcheck() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateHealthcheck() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthcheckTest(t *testing.T) {
+	tests := []struct {
+		test []string
+		want []string
+	}{
+		{test: []string{"/bin/check"}, want: []string{"CMD", "/bin/check"}},
+		{test: []string{"CMD", "/bin/check"}, want: []string{"CMD", "/bin/check"}},
+		{test: []string{"CMD-SHELL", "check || exit 1"}, want: []string{"CMD-SHELL", "check || exit 1"}},
+		{test: []string{"NONE"}, want: []string{"NONE"}},
+	}
+	for _, tt := range tests {
+		if got := healthcheckTest(tt.test); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("healthcheckTest(%q) = %q, want %q", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestOverlayHealthcheck(t *testing.T) {
+	config := image{}
+	config.Config.Healthcheck = &healthConfig{Test: []string{"CMD", "/bin/old"}, Interval: time.Minute, Retries: 5}
+	r := NewRunner(Config{HealthcheckTimeout: 10 * time.Second, HealthcheckRetries: 2})
+	if err := r.overlayNewConfigValues(&config, nil, nil); err != nil {
+		t.Fatalf("overlayNewConfigValues() error = %v", err)
+	}
+	// values that are not set keep the values of the base image
+	want := healthConfig{Test: []string{"CMD", "/bin/old"}, Interval: time.Minute, Timeout: 10 * time.Second, Retries: 2}
+	if !reflect.DeepEqual(*config.Config.Healthcheck, want) {
+		t.Errorf("overlayNewConfigValues() healthcheck = %+v, want %+v", *config.Config.Healthcheck, want)
+	}
+
+	// a duration without a test command (and no base health check) is rejected
+	config = image{}
+	r = NewRunner(Config{HealthcheckInterval: time.Second})
+	if err := r.overlayNewConfigValues(&config, nil, nil); err == nil || !strings.Contains(err.Error(), "requires a test command") {
+		t.Errorf("overlayNewConfigValues() error = %v, want the missing test command", err)
+	}
+}
+
+func TestOverlayPortsAndVolumes(t *testing.T) {
+	config := image{}
+	config.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}}
+	config.Config.Volumes = map[string]struct{}{"/data": {}}
+	r := NewRunner(Config{ExposedPorts: []string{"8080", "53/udp"}, Volumes: []string{"/cache"}})
+	if err := r.overlayNewConfigValues(&config, nil, nil); err != nil {
+		t.Fatalf("overlayNewConfigValues() error = %v", err)
+	}
+	wantPorts := map[string]struct{}{"80/tcp": {}, "8080/tcp": {}, "53/udp": {}}
+	if !reflect.DeepEqual(config.Config.ExposedPorts, wantPorts) {
+		t.Errorf("overlayNewConfigValues() ports = %v, want %v", config.Config.ExposedPorts, wantPorts)
+	}
+	wantVolumes := map[string]struct{}{"/data": {}, "/cache": {}}
+	if !reflect.DeepEqual(config.Config.Volumes, wantVolumes) {
+		t.Errorf("overlayNewConfigValues() volumes = %v, want %v", config.Config.Volumes, wantVolumes)
+	}
+
+	for _, cfg := range []Config{{ExposedPorts: []string{"99999"}}, {Volumes: []string{""}}} {
+		if err := NewRunner(cfg).overlayNewConfigValues(&image{}, nil, nil); err == nil {
+			t.Errorf("overlayNewConfigValues(%+v) error = nil, want an error", cfg)
+		}
+	}
+}
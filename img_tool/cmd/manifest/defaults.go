@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+)
+
+// DefaultLabels are organization-wide labels and annotations that are added to every image,
+// like org.opencontainers.image.vendor.
+// They replace the values of the base image, but the values of the image itself
+// (config fragment, labels and annotations) replace them.
+type DefaultLabels struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// readDefaultLabels reads the default labels file.
+// Unknown fields are rejected, since the file is shared by all images and a typo would go unnoticed.
+func readDefaultLabels(filePath string) (*DefaultLabels, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("opening default labels file: %w", err)
+	}
+	defer file.Close()
+
+	var defaults DefaultLabels
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&defaults); err != nil {
+		return nil, fmt.Errorf("decoding default labels file: %w", err)
+	}
+	return &defaults, nil
+}
+
+// mergeDefaults copies the defaults into values, replacing existing values.
+// A nil map is allocated if there are defaults.
+func mergeDefaults(values map[string]string, defaults map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return values
+	}
+	if values == nil {
+		values = make(map[string]string, len(defaults))
+	}
+	maps.Copy(values, defaults)
+	return values
+}
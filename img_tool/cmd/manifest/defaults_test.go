@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadDefaultLabels(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    DefaultLabels
+		wantErr string
+	}{
+		{
+			name:    "labels and annotations",
+			content: `{"labels": {"org.opencontainers.image.vendor": "ACME"}, "annotations": {"org.opencontainers.image.url": "https://example.com"}}`,
+			want: DefaultLabels{
+				Labels:      map[string]string{"org.opencontainers.image.vendor": "ACME"},
+				Annotations: map[string]string{"org.opencontainers.image.url": "https://example.com"},
+			},
+		},
+		{
+			name:    "only labels",
+			content: `{"labels": {"team": "infra"}}`,
+			want:    DefaultLabels{Labels: map[string]string{"team": "infra"}},
+		},
+		{
+			name:    "empty object",
+			content: `{}`,
+		},
+		{
+			name:    "unknown field",
+			content: `{"label": {"team": "infra"}}`,
+			wantErr: `unknown field "label"`,
+		},
+		{
+			name:    "label that is not a string",
+			content: `{"labels": {"version": 2}}`,
+			wantErr: "decoding default labels file",
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"labels": `,
+			wantErr: "decoding default labels file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readDefaultLabels(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("readDefaultLabels() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got.Labels, tt.want.Labels) || !maps.Equal(got.Annotations, tt.want.Annotations) {
+				t.Errorf("readDefaultLabels() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := readDefaultLabels(filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "opening default labels file") {
+			t.Errorf("readDefaultLabels() error = %v, want an error about opening the file", err)
+		}
+	})
+}
+
+func TestMergeDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		defaults map[string]string
+		want     map[string]string
+	}{
+		{
+			name: "no values and no defaults",
+		},
+		{
+			name:   "no defaults",
+			values: map[string]string{"a": "base"},
+			want:   map[string]string{"a": "base"},
+		},
+		{
+			name:     "no values",
+			defaults: map[string]string{"a": "default"},
+			want:     map[string]string{"a": "default"},
+		},
+		{
+			name:     "defaults replace values",
+			values:   map[string]string{"a": "base", "b": "base"},
+			defaults: map[string]string{"a": "default", "c": "default"},
+			want:     map[string]string{"a": "default", "b": "base", "c": "default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeDefaults(tt.values, tt.defaults)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("mergeDefaults(%v, %v) = %v, want %v", tt.values, tt.defaults, got, tt.want)
+			}
+			if tt.values == nil && len(tt.defaults) == 0 && got != nil {
+				t.Errorf("mergeDefaults() without values and defaults = %v, want nil", got)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package manifest
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -52,3 +53,30 @@ func (m *stringMap) Set(value string) error {
 	(*m)[parts[0]] = parts[1]
 	return nil
 }
+
+// layerDescriptions maps the index of a layer (including base layers) to a description.
+type layerDescriptions map[int]string
+
+func (m *layerDescriptions) String() string {
+	var parts []string
+	for k, v := range *m {
+		parts = append(parts, fmt.Sprintf("%d=%s", k, v))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (m *layerDescriptions) Set(value string) error {
+	if *m == nil {
+		*m = make(map[int]string)
+	}
+	index, description, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid index=description format: %s", value)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 {
+		return fmt.Errorf("invalid layer index %q: must be a non-negative integer", index)
+	}
+	(*m)[i] = description
+	return nil
+}
@@ -0,0 +1,302 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// fragmentProblem is a problem of a config fragment at a position of the JSON document.
+type fragmentProblem struct {
+	line, column int
+	path         string
+	message      string
+}
+
+func (p fragmentProblem) String() string {
+	return fmt.Sprintf("line %d, column %d: %s: %s", p.line, p.column, p.path, p.message)
+}
+
+// fragmentChecker validates a config fragment against the fields of the image config
+// (including the legacy fields of Docker), before it is decoded.
+// encoding/json silently drops unknown fields and reports wrong types without the position of the field,
+// so the checker walks the tokens of the document and reports every problem with its line and JSON path.
+type fragmentChecker struct {
+	raw     []byte
+	dec     *json.Decoder
+	unknown []fragmentProblem
+	invalid []fragmentProblem
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// checkConfigFragment returns an error if the config fragment is not valid JSON or has values of the wrong type.
+// Unknown fields are errors in strict mode and warnings (written to warnings) otherwise.
+func checkConfigFragment(raw []byte, strict bool, warnings io.Writer) error {
+	c := &fragmentChecker{raw: raw, dec: json.NewDecoder(bytes.NewReader(raw))}
+	c.dec.UseNumber()
+	if err := c.value(reflect.TypeFor[image](), "$"); err != nil {
+		return c.syntaxError(err)
+	}
+	end := c.dec.InputOffset()
+	if _, err := c.dec.Token(); err != io.EOF {
+		line, column := c.position(end)
+		return fmt.Errorf("line %d, column %d: unexpected data after the config fragment", line, column)
+	}
+
+	problems := c.invalid
+	if strict {
+		problems = append(problems, c.unknown...)
+	} else {
+		for _, problem := range c.unknown {
+			fmt.Fprintf(warnings, "Warning: config fragment: %s (the field is ignored, --strict-config makes this an error)\n", problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.String()
+	}
+	return fmt.Errorf("invalid config fragment:\n  %s", strings.Join(messages, "\n  "))
+}
+
+// value checks the next value of the document against t. A nil t accepts any value.
+func (c *fragmentChecker) value(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	start := c.dec.InputOffset()
+	tok, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return c.object(t, path, start)
+		}
+		return c.array(t, path, start)
+	case nil:
+		// null resets fields of any type
+		return nil
+	case string:
+		if t != nil && t.Kind() != reflect.String && t != timeType {
+			c.typeProblem(start, path, t, "a string")
+		} else if t == timeType {
+			if _, err := time.Parse(time.RFC3339Nano, tok); err != nil {
+				c.problem(&c.invalid, start, path, fmt.Sprintf("expected an RFC 3339 timestamp, got %q", tok))
+			}
+		}
+	case json.Number:
+		switch {
+		case t == nil:
+		case t == durationType:
+			if _, err := tok.Int64(); err != nil {
+				c.problem(&c.invalid, start, path, fmt.Sprintf("expected a duration in nanoseconds, got %s", tok))
+			}
+		case isInteger(t.Kind()):
+			if _, err := tok.Int64(); err != nil {
+				c.typeProblem(start, path, t, "the number "+tok.String())
+			}
+		case t.Kind() != reflect.Float32 && t.Kind() != reflect.Float64:
+			c.typeProblem(start, path, t, "a number")
+		}
+	case bool:
+		if t != nil && t.Kind() != reflect.Bool {
+			c.typeProblem(start, path, t, "a boolean")
+		}
+	}
+	return nil
+}
+
+func (c *fragmentChecker) object(t reflect.Type, path string, start int64) error {
+	var fields map[string]reflect.Type
+	var elem reflect.Type
+	switch {
+	case t == nil:
+	case t.Kind() == reflect.Struct && t != timeType:
+		fields = jsonFields(t)
+	case t.Kind() == reflect.Map:
+		elem = t.Elem()
+	default:
+		c.typeProblem(start, path, t, "an object")
+		t = nil
+	}
+	for c.dec.More() {
+		keyStart := c.dec.InputOffset()
+		tok, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		fieldPath := path + "." + key
+		fieldType := elem
+		if fields != nil {
+			var known bool
+			fieldType, known = lookupField(fields, key)
+			if !known {
+				c.problem(&c.unknown, keyStart, fieldPath, fmt.Sprintf("unknown field %q", key))
+			}
+		}
+		if err := c.value(fieldType, fieldPath); err != nil {
+			return err
+		}
+	}
+	// consume the closing delimiter
+	_, err := c.dec.Token()
+	return err
+}
+
+func (c *fragmentChecker) array(t reflect.Type, path string, start int64) error {
+	var elem reflect.Type
+	switch {
+	case t == nil:
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		elem = t.Elem()
+	default:
+		c.typeProblem(start, path, t, "an array")
+	}
+	for i := 0; c.dec.More(); i++ {
+		if err := c.value(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	_, err := c.dec.Token()
+	return err
+}
+
+func (c *fragmentChecker) typeProblem(offset int64, path string, t reflect.Type, got string) {
+	c.problem(&c.invalid, offset, path, fmt.Sprintf("expected %s, got %s", describeType(t), got))
+}
+
+func (c *fragmentChecker) problem(problems *[]fragmentProblem, offset int64, path, message string) {
+	line, column := c.position(offset)
+	*problems = append(*problems, fragmentProblem{line: line, column: column, path: path, message: message})
+}
+
+// position returns the line and column of the first non-space character at or after offset.
+// Offsets of the decoder point behind the previous token, which may be followed by a separator.
+func (c *fragmentChecker) position(offset int64) (line, column int) {
+	i := int(min(offset, int64(len(c.raw))))
+	for i < len(c.raw) && strings.ContainsRune(" \t\r\n,:", rune(c.raw[i])) {
+		i++
+	}
+	return c.lineColumn(i)
+}
+
+func (c *fragmentChecker) lineColumn(i int) (line, column int) {
+	i = max(0, min(i, len(c.raw)))
+	line = 1 + bytes.Count(c.raw[:i], []byte("\n"))
+	column = i - bytes.LastIndexByte(c.raw[:i], '\n')
+	return line, column
+}
+
+// syntaxError adds the position to JSON syntax errors.
+func (c *fragmentChecker) syntaxError(err error) error {
+	var syntaxErr *json.SyntaxError
+	// the decoder reports truncated documents as syntax errors at the end of the input
+	truncated := errors.As(err, &syntaxErr) && syntaxErr.Error() == "unexpected end of JSON input"
+	if syntaxErr != nil && !truncated {
+		// the offset is behind the invalid character
+		line, column := c.lineColumn(int(syntaxErr.Offset) - 1)
+		return fmt.Errorf("line %d, column %d: invalid JSON: %w", line, column, err)
+	}
+	if truncated || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid JSON: unexpected end of the config fragment")
+	}
+	return fmt.Errorf("invalid JSON: %w", err)
+}
+
+// jsonFields returns the JSON names of the fields of a struct, following the rules of encoding/json:
+// fields of embedded structs are promoted, unless a field of the outer struct has the same name.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	var embedded []reflect.Type
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embeddedType := field.Type
+			if embeddedType.Kind() == reflect.Pointer {
+				embeddedType = embeddedType.Elem()
+			}
+			if embeddedType.Kind() == reflect.Struct {
+				embedded = append(embedded, embeddedType)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	for _, embeddedType := range embedded {
+		for name, fieldType := range jsonFields(embeddedType) {
+			if _, ok := fields[name]; !ok {
+				fields[name] = fieldType
+			}
+		}
+	}
+	return fields
+}
+
+// lookupField finds the field of a key. Like encoding/json, keys match field names case-insensitively.
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// describeType describes the JSON type that is expected for t.
+func describeType(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "an RFC 3339 timestamp"
+	case t == durationType:
+		return "a duration in nanoseconds"
+	case isInteger(t.Kind()):
+		return "an integer"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem()), "an "), "a ") + "s"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
@@ -0,0 +1,161 @@
+package manifest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		strict   bool
+		// wantErr lists substrings of the error, in order. Empty means success.
+		wantErr     []string
+		wantWarning string
+	}{
+		{
+			name:     "valid fragment",
+			fragment: `{"architecture":"arm64","variant":"v8","created":"2024-01-02T03:04:05Z","config":{"Env":["A=1"],"ExposedPorts":{"80/tcp":{}},"Healthcheck":{"Test":["NONE"],"Interval":30000000000,"Retries":3}}}`,
+			strict:   true,
+		},
+		{
+			name:     "null resets a field",
+			fragment: `{"config":{"Entrypoint":null,"Healthcheck":null}}`,
+			strict:   true,
+		},
+		{
+			name:     "field names are case-insensitive",
+			fragment: `{"Architecture":"amd64","config":{"user":"root"}}`,
+			strict:   true,
+		},
+		{
+			name:     "fields of embedded structs",
+			fragment: `{"os":"linux","rootfs":{"type":"layers","diff_ids":[]},"config":{"OnBuild":["RUN true"],"StopSignal":"SIGTERM"}}`,
+			strict:   true,
+		},
+		{
+			name:        "unknown field is a warning",
+			fragment:    `{"config":{"Enviroment":["A=1"]}}`,
+			wantWarning: `Warning: config fragment: line 1, column 12: $.config.Enviroment: unknown field "Enviroment" (the field is ignored, --strict-config makes this an error)`,
+		},
+		{
+			name:     "unknown field in strict mode",
+			fragment: "{\n  \"config\": {\n    \"Enviroment\": [\"A=1\"]\n  }\n}",
+			strict:   true,
+			wantErr:  []string{"invalid config fragment:", `line 3, column 5: $.config.Enviroment: unknown field "Enviroment"`},
+		},
+		{
+			name:     "values of unknown fields are not checked",
+			fragment: `{"config":{"Labelz":{"a":[1,{"b":true}]}},"extra":42}`,
+			wantWarning: `line 1, column 12: $.config.Labelz: unknown field "Labelz"` + " (the field is ignored, --strict-config makes this an error)\n" +
+				`Warning: config fragment: line 1, column 43: $.extra: unknown field "extra"`,
+		},
+		{
+			name:     "wrong types",
+			fragment: "{\n  \"config\": {\n    \"Entrypoint\": \"/bin/app\",\n    \"ExposedPorts\": [\"80/tcp\"],\n    \"Env\": [\"A=1\", 2]\n  },\n  \"architecture\": true\n}",
+			wantErr: []string{
+				"line 3, column 19: $.config.Entrypoint: expected an array of strings, got a string",
+				"line 4, column 21: $.config.ExposedPorts: expected an object, got an array",
+				"line 5, column 20: $.config.Env[1]: expected a string, got a number",
+				"line 7, column 19: $.architecture: expected a string, got a boolean",
+			},
+		},
+		{
+			name:        "problems are errors without strict mode",
+			fragment:    `{"config":{"User":1,"Userr":"root"}}`,
+			wantErr:     []string{`line 1, column 19: $.config.User: expected a string, got a number`},
+			wantWarning: `line 1, column 21: $.config.Userr: unknown field "Userr"`,
+		},
+		{
+			name:     "fractional integer",
+			fragment: `{"config":{"Healthcheck":{"Retries":1.5}}}`,
+			strict:   true,
+			wantErr:  []string{"$.config.Healthcheck.Retries: expected an integer, got the number 1.5"},
+		},
+		{
+			name:     "fractional duration",
+			fragment: `{"config":{"Healthcheck":{"Interval":0.5}}}`,
+			strict:   true,
+			wantErr:  []string{"$.config.Healthcheck.Interval: expected a duration in nanoseconds, got 0.5"},
+		},
+		{
+			name:     "duration as string",
+			fragment: `{"config":{"Healthcheck":{"Timeout":"30s"}}}`,
+			strict:   true,
+			wantErr:  []string{"$.config.Healthcheck.Timeout: expected a duration in nanoseconds, got a string"},
+		},
+		{
+			name:     "invalid timestamp",
+			fragment: `{"created":"yesterday"}`,
+			strict:   true,
+			wantErr:  []string{`line 1, column 12: $.created: expected an RFC 3339 timestamp, got "yesterday"`},
+		},
+		{
+			name:     "object instead of a string",
+			fragment: `{"config":{"WorkingDir":{}}}`,
+			strict:   true,
+			wantErr:  []string{"$.config.WorkingDir: expected a string, got an object"},
+		},
+		{
+			name:     "syntax error",
+			fragment: "{\n  \"config\": {\"User\": root}\n}",
+			strict:   true,
+			wantErr:  []string{"line 2, column 22: invalid JSON: invalid character 'r'"},
+		},
+		{
+			name:     "truncated fragment",
+			fragment: `{"config":{"User":"root"`,
+			strict:   true,
+			wantErr:  []string{"invalid JSON: unexpected end of the config fragment"},
+		},
+		{
+			name:     "invalid last character",
+			fragment: `{"os":x`,
+			strict:   true,
+			wantErr:  []string{"line 1, column 7: invalid JSON: invalid character 'x'"},
+		},
+		{
+			name:     "empty fragment",
+			fragment: ``,
+			strict:   true,
+			wantErr:  []string{"invalid JSON: unexpected end of the config fragment"},
+		},
+		{
+			name:     "data after the fragment",
+			fragment: "{\"os\":\"linux\"}\n{\"os\":\"windows\"}",
+			strict:   true,
+			wantErr:  []string{"line 2, column 1: unexpected data after the config fragment"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings bytes.Buffer
+			err := checkConfigFragment([]byte(tt.fragment), tt.strict, &warnings)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("checkConfigFragment() error = %v", err)
+				}
+			} else if err == nil {
+				t.Errorf("checkConfigFragment() succeeded, want error %q", tt.wantErr)
+			} else {
+				msg := err.Error()
+				for _, want := range tt.wantErr {
+					i := strings.Index(msg, want)
+					if i < 0 {
+						t.Errorf("checkConfigFragment() error = %v, want %q", err, want)
+						break
+					}
+					msg = msg[i+len(want):]
+				}
+			}
+			if !strings.Contains(warnings.String(), tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", warnings.String(), tt.wantWarning)
+			}
+			if tt.wantWarning == "" && warnings.Len() > 0 {
+				t.Errorf("warnings = %q, want none", warnings.String())
+			}
+		})
+	}
+}
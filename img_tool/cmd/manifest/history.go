@@ -0,0 +1,177 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// appendHistory synthesizes the history of the image config, like "docker build" would.
+// Every layer that is not yet described by an existing history entry (of the base or inherited image)
+// gets one entry, followed by one empty_layer entry per config value set by this invocation.
+func (r *Runner) appendHistory(config *image, layers []api.Descriptor, templatesData *ConfigTemplates) error {
+	described := 0
+	for _, entry := range config.History {
+		if !entry.EmptyLayer {
+			described++
+		}
+	}
+	if described > len(layers) {
+		return fmt.Errorf("config history describes %d layers, but the image has %d layers", described, len(layers))
+	}
+	for i := range r.cfg.LayerCreatedBy {
+		if i >= len(layers) {
+			return fmt.Errorf("description of layer %d, but the image has %d layers", i, len(layers))
+		}
+	}
+
+	for i := described; i < len(layers); i++ {
+		createdBy, ok := r.cfg.LayerCreatedBy[i]
+		if !ok {
+			createdBy = layers[i].Name
+		}
+		if createdBy == "" {
+			createdBy = layers[i].Digest
+		}
+		config.History = append(config.History, specv1.History{
+			Created:   config.Created,
+			CreatedBy: createdBy,
+		})
+	}
+
+	instructions, err := r.configInstructions(config.OS, templatesData)
+	if err != nil {
+		return err
+	}
+	for _, instruction := range instructions {
+		config.History = append(config.History, specv1.History{
+			Created:    config.Created,
+			CreatedBy:  instruction,
+			EmptyLayer: true,
+		})
+	}
+	return nil
+}
+
+// configInstructions describes the config values set by this invocation as Dockerfile instructions.
+func (r *Runner) configInstructions(operatingSystem string, templatesData *ConfigTemplates) ([]string, error) {
+	env := r.cfg.Env
+	labels := r.cfg.Labels
+	if templatesData != nil && templatesData.Env != nil {
+		env = templatesData.Env
+	}
+	if templatesData != nil && templatesData.Labels != nil {
+		labels = templatesData.Labels
+	}
+
+	var instructions []string
+	if r.cfg.User != "" {
+		instructions = append(instructions, "USER "+r.cfg.User)
+	}
+	if len(env) > 0 {
+		instructions = append(instructions, "ENV "+keyValues(env))
+	}
+	if len(r.cfg.EnvPrepend) > 0 || len(r.cfg.EnvAppend) > 0 {
+		separator := pathListSeparator(operatingSystem)
+		var pairs []string
+		for _, key := range slices.Sorted(maps.Keys(r.cfg.EnvPrepend)) {
+			pairs = append(pairs, fmt.Sprintf("%s=%s%s$%s", key, r.cfg.EnvPrepend[key], separator, key))
+		}
+		for _, key := range slices.Sorted(maps.Keys(r.cfg.EnvAppend)) {
+			pairs = append(pairs, fmt.Sprintf("%s=$%s%s%s", key, key, separator, r.cfg.EnvAppend[key]))
+		}
+		instructions = append(instructions, "ENV "+strings.Join(pairs, " "))
+	}
+	if r.cfg.WorkingDir != "" {
+		instructions = append(instructions, "WORKDIR "+r.cfg.WorkingDir)
+	}
+	if len(labels) > 0 {
+		instructions = append(instructions, "LABEL "+keyValues(labels))
+	}
+	if r.cfg.StopSignal != "" {
+		instructions = append(instructions, "STOPSIGNAL "+r.cfg.StopSignal)
+	}
+	if len(r.cfg.ExposedPorts) > 0 {
+		instructions = append(instructions, "EXPOSE "+strings.Join(r.cfg.ExposedPorts, " "))
+	}
+	if len(r.cfg.Volumes) > 0 {
+		volumesRaw, err := json.Marshal(r.cfg.Volumes)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling VOLUME: %w", err)
+		}
+		instructions = append(instructions, "VOLUME "+string(volumesRaw))
+	}
+	if r.setsHealthcheck() {
+		instruction := "HEALTHCHECK"
+		for _, option := range []struct {
+			name  string
+			value time.Duration
+		}{
+			{"interval", r.cfg.HealthcheckInterval},
+			{"timeout", r.cfg.HealthcheckTimeout},
+			{"start-period", r.cfg.HealthcheckStartPeriod},
+		} {
+			if option.value != 0 {
+				instruction += fmt.Sprintf(" --%s=%s", option.name, option.value)
+			}
+		}
+		if r.cfg.HealthcheckRetries != 0 {
+			instruction += fmt.Sprintf(" --retries=%d", r.cfg.HealthcheckRetries)
+		}
+		if len(r.cfg.Healthcheck) > 0 {
+			test := healthcheckTest(r.cfg.Healthcheck)
+			testRaw, err := json.Marshal(test[1:])
+			if err != nil {
+				return nil, fmt.Errorf("marshaling HEALTHCHECK: %w", err)
+			}
+			instruction += " " + test[0]
+			if len(test) > 1 {
+				instruction += " " + string(testRaw)
+			}
+		}
+		instructions = append(instructions, instruction)
+	}
+	for _, trigger := range r.cfg.OnBuild {
+		instructions = append(instructions, "ONBUILD "+trigger)
+	}
+	for _, exec := range []struct {
+		instruction string
+		args        []string
+	}{
+		{"SHELL", r.cfg.Shell},
+		{"ENTRYPOINT", r.cfg.Entrypoint},
+		{"CMD", r.cfg.Cmd},
+	} {
+		if len(exec.args) == 0 {
+			continue
+		}
+		// exec form, as used by docker
+		argsRaw, err := json.Marshal(exec.args)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling %s: %w", exec.instruction, err)
+		}
+		instructions = append(instructions, exec.instruction+" "+string(argsRaw))
+	}
+	return instructions, nil
+}
+
+// keyValues formats a map as sorted key=value pairs.
+func keyValues(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + m[key]
+	}
+	return strings.Join(pairs, " ")
+}
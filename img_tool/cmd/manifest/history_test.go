@@ -0,0 +1,194 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func TestAppendHistory(t *testing.T) {
+	layers := []api.Descriptor{
+		{Name: "base", Digest: "sha256:0000"},
+		{Name: "app", Digest: "sha256:1111"},
+		{Digest: "sha256:2222"},
+	}
+	tests := []struct {
+		name        string
+		cfg         Config
+		baseHistory []specv1.History
+		want        []string
+		wantEmpty   []bool
+	}{
+		{
+			name:      "one entry per layer",
+			want:      []string{"base", "app", "sha256:2222"},
+			wantEmpty: []bool{false, false, false},
+		},
+		{
+			name: "layers described by the base image are skipped",
+			baseHistory: []specv1.History{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{CreatedBy: "ENV A=b", EmptyLayer: true},
+			},
+			want:      []string{"ADD rootfs.tar /", "ENV A=b", "app", "sha256:2222"},
+			wantEmpty: []bool{false, true, false, false},
+		},
+		{
+			name:      "descriptions by index",
+			cfg:       Config{LayerCreatedBy: map[int]string{2: "COPY config /etc"}},
+			want:      []string{"base", "app", "COPY config /etc"},
+			wantEmpty: []bool{false, false, false},
+		},
+		{
+			name: "config values are empty layers after the layers",
+			cfg: Config{
+				User:       "app",
+				Env:        map[string]string{"PATH": "/bin", "HOME": "/root"},
+				WorkingDir: "/srv",
+				Labels:     map[string]string{"version": "1"},
+				Entrypoint: []string{"/app", "--serve"},
+				Cmd:        []string{"--port=80"},
+			},
+			want: []string{
+				"base", "app", "sha256:2222",
+				"USER app",
+				"ENV HOME=/root PATH=/bin",
+				"WORKDIR /srv",
+				"LABEL version=1",
+				`ENTRYPOINT ["/app","--serve"]`,
+				`CMD ["--port=80"]`,
+			},
+			wantEmpty: []bool{false, false, false, true, true, true, true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := image{}
+			config.History = tt.baseHistory
+			if err := NewRunner(tt.cfg).appendHistory(&config, layers, nil); err != nil {
+				t.Fatalf("appendHistory() error = %v", err)
+			}
+			var got []string
+			var gotEmpty []bool
+			for _, entry := range config.History {
+				got = append(got, entry.CreatedBy)
+				gotEmpty = append(gotEmpty, entry.EmptyLayer)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appendHistory() created_by = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotEmpty, tt.wantEmpty) {
+				t.Errorf("appendHistory() empty_layer = %v, want %v", gotEmpty, tt.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestAppendHistoryTemplates(t *testing.T) {
+	r := NewRunner(Config{Env: map[string]string{"A": "flag"}})
+	templates := &ConfigTemplates{Env: map[string]string{"A": "expanded"}}
+	config := image{}
+	if err := r.appendHistory(&config, nil, templates); err != nil {
+		t.Fatalf("appendHistory() error = %v", err)
+	}
+	if len(config.History) != 1 || config.History[0].CreatedBy != "ENV A=expanded" {
+		t.Errorf("appendHistory() = %+v, want the expanded env", config.History)
+	}
+}
+
+func TestConfigInstructionsPortsVolumesHealthcheck(t *testing.T) {
+	r := NewRunner(Config{
+		ExposedPorts:        []string{"8080", "53/udp"},
+		Volumes:             []string{"/data"},
+		Healthcheck:         []string{"/bin/check"},
+		HealthcheckInterval: 30 * time.Second,
+		HealthcheckRetries:  3,
+	})
+	got, err := r.configInstructions("linux", nil)
+	if err != nil {
+		t.Fatalf("configInstructions() error = %v", err)
+	}
+	want := []string{
+		"EXPOSE 8080 53/udp",
+		`VOLUME ["/data"]`,
+		`HEALTHCHECK --interval=30s --retries=3 CMD ["/bin/check"]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("configInstructions() = %q, want %q", got, want)
+	}
+
+	r = NewRunner(Config{Healthcheck: []string{"NONE"}})
+	if got, _ := r.configInstructions("linux", nil); !reflect.DeepEqual(got, []string{"HEALTHCHECK NONE"}) {
+		t.Errorf("configInstructions() = %q, want HEALTHCHECK NONE", got)
+	}
+}
+
+func TestConfigInstructionsEnvAppendPrepend(t *testing.T) {
+	r := NewRunner(Config{
+		EnvPrepend: map[string]string{"PATH": "/app/bin"},
+		EnvAppend:  map[string]string{"LD_LIBRARY_PATH": "/app/lib"},
+	})
+	for _, tt := range []struct {
+		os   string
+		want string
+	}{
+		{os: "linux", want: "ENV PATH=/app/bin:$PATH LD_LIBRARY_PATH=$LD_LIBRARY_PATH:/app/lib"},
+		{os: "windows", want: "ENV PATH=/app/bin;$PATH LD_LIBRARY_PATH=$LD_LIBRARY_PATH;/app/lib"},
+	} {
+		got, err := r.configInstructions(tt.os, nil)
+		if err != nil || !reflect.DeepEqual(got, []string{tt.want}) {
+			t.Errorf("configInstructions(%q) = %q, %v, want %q", tt.os, got, err, tt.want)
+		}
+	}
+}
+
+func TestAppendHistoryErrors(t *testing.T) {
+	layers := []api.Descriptor{{Name: "base"}}
+	tests := []struct {
+		name        string
+		cfg         Config
+		baseHistory []specv1.History
+		wantErr     string
+	}{
+		{
+			name:        "base history describes more layers",
+			baseHistory: []specv1.History{{CreatedBy: "a"}, {CreatedBy: "b"}},
+			wantErr:     "config history describes 2 layers, but the image has 1 layers",
+		},
+		{
+			name:    "description of a missing layer",
+			cfg:     Config{LayerCreatedBy: map[int]string{1: "COPY"}},
+			wantErr: "description of layer 1, but the image has 1 layers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := image{}
+			config.History = tt.baseHistory
+			err := NewRunner(tt.cfg).appendHistory(&config, layers, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("appendHistory() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLayerDescriptionsSet(t *testing.T) {
+	var m layerDescriptions
+	if err := m.Set("2=COPY app /app=x"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if m[2] != "COPY app /app=x" {
+		t.Errorf("Set() = %v, want the description after the first =", m)
+	}
+	for _, value := range []string{"COPY", "-1=COPY", "one=COPY"} {
+		if err := m.Set(value); err == nil {
+			t.Errorf("Set(%q) error = nil, want an error", value)
+		}
+	}
+}
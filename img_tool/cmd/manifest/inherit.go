@@ -0,0 +1,65 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// inheritedImage is an existing image that a variant (like a platform-specific entrypoint) is derived from.
+// Unlike a base image, everything is kept: the layers, the annotations and subject of the manifest, and the complete config.
+type inheritedImage struct {
+	manifest specv1.Manifest
+	config   image
+}
+
+func (r *Runner) readInheritedImage() (*inheritedImage, error) {
+	if r.cfg.InheritManifest == "" && r.cfg.InheritConfig == "" {
+		return nil, nil
+	}
+	if r.cfg.InheritManifest == "" || r.cfg.InheritConfig == "" {
+		return nil, fmt.Errorf("--inherit-manifest and --inherit-config must be used together")
+	}
+	if r.cfg.BaseManifest != "" || r.cfg.BaseConfig != "" {
+		return nil, fmt.Errorf("--inherit-manifest cannot be combined with a base image")
+	}
+	var inherited inheritedImage
+	if err := readJSONFile(r.cfg.InheritManifest, &inherited.manifest); err != nil {
+		return nil, fmt.Errorf("reading inherited manifest: %w", err)
+	}
+	if err := readJSONFile(r.cfg.InheritConfig, &inherited.config); err != nil {
+		return nil, fmt.Errorf("reading inherited config: %w", err)
+	}
+	if len(inherited.manifest.Layers) != len(inherited.config.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("inherited image has %d layers, but %d diff IDs", len(inherited.manifest.Layers), len(inherited.config.RootFS.DiffIDs))
+	}
+	return &inherited, nil
+}
+
+// layers returns the layers of the inherited image in the format of "img layer --metadata".
+func (i *inheritedImage) layers() []api.Descriptor {
+	layers := make([]api.Descriptor, len(i.manifest.Layers))
+	for n, layer := range i.manifest.Layers {
+		layers[n] = api.Descriptor{
+			DiffID:      i.config.RootFS.DiffIDs[n].String(),
+			MediaType:   layer.MediaType,
+			Digest:      layer.Digest.String(),
+			Size:        layer.Size,
+			Annotations: layer.Annotations,
+			URLs:        layer.URLs,
+		}
+	}
+	return layers
+}
+
+func readJSONFile(filePath string, v any) error {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
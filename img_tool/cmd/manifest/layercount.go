@@ -0,0 +1,95 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// maxMergeCandidates is the number of merge candidates suggested when an image has too many layers.
+const maxMergeCandidates = 5
+
+// checkLayerCount warns about (or rejects) images with more layers than the configured thresholds.
+// Registries and container runtimes degrade or fail beyond ~127 layers.
+func (r *Runner) checkLayerCount(layers []api.Descriptor, w io.Writer) error {
+	count := len(layers)
+	exceedsLimit := r.cfg.MaxLayers > 0 && count > r.cfg.MaxLayers
+	exceedsWarning := r.cfg.MaxLayersWarning > 0 && count > r.cfg.MaxLayersWarning
+	if !exceedsLimit && !exceedsWarning {
+		return nil
+	}
+
+	baseLayers := 0
+	if r.cfg.BaseManifest != "" {
+		var err error
+		baseLayers, err = baseLayerCount(r.cfg.BaseManifest)
+		if err != nil {
+			return fmt.Errorf("reading base manifest: %w", err)
+		}
+	}
+	suggestion := mergeSuggestion(layers, baseLayers)
+
+	if exceedsLimit {
+		return fmt.Errorf("image has %d layers (%d from the base image), more than the maximum of %d%s", count, baseLayers, r.cfg.MaxLayers, suggestion)
+	}
+	fmt.Fprintf(w, "Warning: image has %d layers (%d from the base image), more than %d. Registries and runtimes degrade with many layers.%s\n", count, baseLayers, r.cfg.MaxLayersWarning, suggestion)
+	return nil
+}
+
+// mergeSuggestion lists the smallest pairs of adjacent layers that are not part of the base image.
+func mergeSuggestion(layers []api.Descriptor, baseLayers int) string {
+	type candidate struct {
+		index int
+		size  int64
+	}
+	var candidates []candidate
+	for i := baseLayers; i+1 < len(layers); i++ {
+		candidates = append(candidates, candidate{index: i, size: layers[i].Size + layers[i+1].Size})
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.size < b.size:
+			return -1
+		case a.size > b.size:
+			return 1
+		}
+		return 0
+	})
+
+	var sb strings.Builder
+	sb.WriteString("\nConsider merging the smallest adjacent layers:")
+	for _, c := range candidates[:min(len(candidates), maxMergeCandidates)] {
+		fmt.Fprintf(&sb, "\n  layers %d and %d (%d bytes together): %s + %s",
+			c.index, c.index+1, c.size, layerName(layers[c.index]), layerName(layers[c.index+1]))
+	}
+	return sb.String()
+}
+
+func layerName(layer api.Descriptor) string {
+	if layer.Name != "" {
+		return layer.Name
+	}
+	return layer.Digest
+}
+
+func baseLayerCount(baseManifestPath string) (int, error) {
+	raw, err := os.ReadFile(baseManifestPath)
+	if err != nil {
+		return 0, err
+	}
+	var baseManifest specv1.Manifest
+	if err := json.Unmarshal(raw, &baseManifest); err != nil {
+		return 0, err
+	}
+	return len(baseManifest.Layers), nil
+}
@@ -0,0 +1,45 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func TestMergeSuggestion(t *testing.T) {
+	layers := make([]api.Descriptor, 10)
+	for i := range layers {
+		layers[i] = api.Descriptor{Name: fmt.Sprintf("layer%d", i), Size: 100}
+	}
+	layers[9].Size = 1000
+
+	suggestion := mergeSuggestion(layers, 2)
+	lines := strings.Split(strings.TrimPrefix(suggestion, "\n"), "\n")
+	// pairs of the same size keep their order, the pair with the large layer is dropped
+	want := []string{
+		"Consider merging the smallest adjacent layers:",
+		"  layers 2 and 3 (200 bytes together): layer2 + layer3",
+		"  layers 3 and 4 (200 bytes together): layer3 + layer4",
+		"  layers 4 and 5 (200 bytes together): layer4 + layer5",
+		"  layers 5 and 6 (200 bytes together): layer5 + layer6",
+		"  layers 6 and 7 (200 bytes together): layer6 + layer7",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mergeSuggestion() =%s\nwant\n%s", suggestion, strings.Join(want, "\n"))
+	}
+
+	// layers of the base image are never suggested
+	if got := mergeSuggestion(layers[:3], 2); got != "" {
+		t.Errorf("mergeSuggestion() with a single own layer = %q, want none", got)
+	}
+}
+
+func TestCheckLayerCountDisabled(t *testing.T) {
+	r := NewRunner(Config{})
+	var w strings.Builder
+	if err := r.checkLayerCount(make([]api.Descriptor, 200), &w); err != nil || w.Len() > 0 {
+		t.Errorf("checkLayerCount() without thresholds = %v with output %q, want no check", err, w.String())
+	}
+}
@@ -6,48 +6,108 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log/slog"
 	"maps"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 	specs "github.com/opencontainers/image-spec/specs-go"
 	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/diagnostics"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/subject"
 )
 
-var (
-	operatingSystem       string
-	architecture          string
-	layerFromMetadataArgs fileList
-	configFragment        string
-	configTemplates       string
-	baseManifest          string
-	baseConfig            string
-	manifestOutput        string
-	configOutput          string
-	descriptorOutput      string
-	digestOutput          string
-	user                  string
-	env                   stringMap
-	entrypoint            stringList
-	cmd                   stringList
-	workingDir            string
-	labels                stringMap
-	annotations           stringMap
-	stopSignal            string
-)
+// Config holds the options of a manifest invocation.
+type Config struct {
+	// OperatingSystem, Architecture and Variant of the image.
+	OperatingSystem string
+	Architecture    string
+	Variant         string
+	// StrictConfig rejects config fragments with unknown fields instead of warning about them.
+	StrictConfig bool
+	// EmptyBase builds the image on an empty base ("scratch"), with platform defaults (see defaultVariant).
+	EmptyBase bool
+	// LayerMetadataFiles is the ordered list of layer metadata files ("img layer --metadata").
+	LayerMetadataFiles []string
+	ConfigFragment     string
+	ConfigTemplates    string
+	BaseManifest       string
+	BaseConfig         string
+	// InheritManifest and InheritConfig are an existing image to derive a variant from (see inheritedImage).
+	InheritManifest string
+	InheritConfig   string
+	// Output files. Empty outputs are not written.
+	ManifestOutput   string
+	ConfigOutput     string
+	DescriptorOutput string
+	DigestOutput     string
+	// Values of the image config.
+	User        string
+	Env         map[string]string
+	Entrypoint  []string
+	Cmd         []string
+	WorkingDir  string
+	Labels      map[string]string
+	StopSignal  string
+	OnBuild     []string
+	Shell       []string
+	ArgsEscaped bool
+	// EnvAppend and EnvPrepend extend environment variables of the base image (like PATH)
+	// with a path list separator instead of replacing them.
+	EnvAppend  map[string]string
+	EnvPrepend map[string]string
+	// ExposedPorts and Volumes are added to the ports and volumes of the base image.
+	ExposedPorts []string
+	Volumes      []string
+	// Healthcheck is the test command of the health check. The other health check values
+	// override the values of the base image (zero keeps them).
+	Healthcheck            []string
+	HealthcheckInterval    time.Duration
+	HealthcheckTimeout     time.Duration
+	HealthcheckStartPeriod time.Duration
+	HealthcheckRetries     int
+	// Annotations of the manifest.
+	Annotations map[string]string
+	// DefaultLabelsFile is a JSON file with organization-wide labels and annotations (see DefaultLabels).
+	DefaultLabelsFile string
+	// Subject is a raw image manifest or image index referenced as the subject of the manifest.
+	Subject string
+	// MaxLayersWarning and MaxLayers are thresholds for the number of layers (including base layers).
+	// Exceeding MaxLayersWarning prints a warning, exceeding MaxLayers is an error. Zero disables the check.
+	MaxLayersWarning int
+	MaxLayers        int
+	// History synthesizes config history entries for layers and config values.
+	// LayerCreatedBy overrides the description of layers by index (including base layers).
+	History        bool
+	LayerCreatedBy map[int]string
+}
+
+// Runner creates an image config and manifest.
+type Runner struct {
+	cfg Config
+}
 
-func ManifestProcess(_ context.Context, args []string) {
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func ManifestProcess(ctx context.Context, args []string) {
+	var cfg Config
 	flagSet := flag.NewFlagSet("manifest", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Creates an OCI image config and manifest based on layers and other metadata.\n\n")
-		fmt.Fprintf(flagSet.Output(), "Usage: img manifest [--os os] [--architecture arch] [--layer-from-metadata param_file] [--config-fragment config_file] [--base-manifest manifest_file] [--base-config config_file] [--manifest manifest_file] [--config config_file]\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img manifest [--os os] [--architecture arch] [--variant variant] [--layer-from-metadata param_file] [--config-fragment config_file] [--base-manifest manifest_file] [--base-config config_file] [--manifest manifest_file] [--config config_file]\n")
 		flagSet.PrintDefaults()
 		examples := []string{
 			"img manifest --os linux --architecture amd64 --layer-from-metadata layer-metadata.json --config-fragment extra-config.json --base-manifest base-manifest.json --base-config base-config.json --manifest manifest.json --config config.json",
+			"img manifest --empty-base --os linux --architecture arm64 --variant v8 --layer-from-metadata layer-metadata.json --manifest manifest.json --config config.json",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -55,67 +115,127 @@ func ManifestProcess(_ context.Context, args []string) {
 		}
 		os.Exit(1)
 	}
-	flagSet.StringVar(&operatingSystem, "os", "linux", `The operating system of the image. Defaults to linux.`)
-	flagSet.StringVar(&architecture, "architecture", "amd64", `The architecture of the image. Defaults to amd64.`)
-	flagSet.Var(&layerFromMetadataArgs, "layer-from-metadata", `Ordered list of layer metadata files that will make up the image, as produced by "img layer --metadata".`)
-	flagSet.StringVar(&configFragment, "config-fragment", "", `A JSON file containing a config fragment to be merged into the final config. This is useful for adding custom labels or other metadata to the image.`)
-	flagSet.StringVar(&configTemplates, "config-templates", "", `A JSON file containing template-expanded env, labels, and annotations values.`)
-	flagSet.StringVar(&baseManifest, "base-manifest", "", `A JSON file containing a base manifest to be merged into the final manifest. This is useful for adding custom layers or other metadata to the image.`)
-	flagSet.StringVar(&baseConfig, "base-config", "", `A JSON file containing a base config to be merged into the final config. This is useful for adding custom labels or other metadata to the image.`)
-	flagSet.StringVar(&manifestOutput, "manifest", "", `The output file for the final manifest.`)
-	flagSet.StringVar(&configOutput, "config", "", `The output file for the final config.`)
-	flagSet.StringVar(&descriptorOutput, "descriptor", "", `The output file for the descriptor of the manifest.`)
-	flagSet.StringVar(&digestOutput, "digest", "", `The (optional) output file for the digest of the manifest. This is useful for postprocessing.`)
-	flagSet.StringVar(&user, "user", "", `The username or UID which the process in the container should run as.`)
-	flagSet.Var(&env, "env", `Environment variables to set in the container (can be specified multiple times as key=value).`)
-	flagSet.Var(&entrypoint, "entrypoint", `Command to execute when the container starts (can be specified multiple times).`)
-	flagSet.Var(&cmd, "cmd", `Default arguments to the entrypoint (can be specified multiple times).`)
-	flagSet.StringVar(&workingDir, "working-dir", "", `Working directory inside the container.`)
-	flagSet.Var(&labels, "label", `Metadata labels for the container (can be specified multiple times as key=value).`)
-	flagSet.Var(&annotations, "annotation", `Metadata annotations for the manifest (can be specified multiple times as key=value).`)
-	flagSet.StringVar(&stopSignal, "stop-signal", "", `Signal to stop the container.`)
+	flagSet.StringVar(&cfg.OperatingSystem, "os", "linux", `The operating system of the image. Defaults to linux.`)
+	flagSet.StringVar(&cfg.Architecture, "architecture", "amd64", `The architecture of the image. Defaults to amd64.`)
+	flagSet.StringVar(&cfg.Variant, "variant", "", `The variant of the architecture of the image, like v8 for arm64 or v7 for arm. Defaults to the variant of the base image.`)
+	flagSet.BoolVar(&cfg.EmptyBase, "empty-base", false, `Build the image on an empty base image (scratch) with a minimal config for --os, --architecture and --variant. The variant defaults to v8 for arm64 and v7 for arm. Cannot be combined with a base image.`)
+	flagSet.Var((*fileList)(&cfg.LayerMetadataFiles), "layer-from-metadata", `Ordered list of layer metadata files that will make up the image, as produced by "img layer --metadata".`)
+	flagSet.StringVar(&cfg.ConfigFragment, "config-fragment", "", `A JSON file containing a config fragment to be merged into the final config. This is useful for adding custom labels or other metadata to the image.`)
+	flagSet.BoolVar(&cfg.StrictConfig, "strict-config", false, `Fail if the config fragment has fields that are not part of the image config (instead of printing a warning). Values of the wrong type are always an error.`)
+	flagSet.StringVar(&cfg.ConfigTemplates, "config-templates", "", `A JSON file containing template-expanded env, labels, and annotations values.`)
+	flagSet.StringVar(&cfg.BaseManifest, "base-manifest", "", `A JSON file containing a base manifest to be merged into the final manifest. This is useful for adding custom layers or other metadata to the image.`)
+	flagSet.StringVar(&cfg.BaseConfig, "base-config", "", `A JSON file containing a base config to be merged into the final config. This is useful for adding custom labels or other metadata to the image.`)
+	flagSet.StringVar(&cfg.InheritManifest, "inherit-manifest", "", `A JSON file containing the manifest of an existing image to derive a variant from. Unlike a base manifest, its layers, annotations and subject are kept. Requires --inherit-config.`)
+	flagSet.StringVar(&cfg.InheritConfig, "inherit-config", "", `A JSON file containing the config of an existing image to derive a variant from. Unlike a base config, every field is kept. Requires --inherit-manifest.`)
+	flagSet.StringVar(&cfg.ManifestOutput, "manifest", "", `The output file for the final manifest.`)
+	flagSet.StringVar(&cfg.ConfigOutput, "config", "", `The output file for the final config.`)
+	flagSet.StringVar(&cfg.DescriptorOutput, "descriptor", "", `The output file for the descriptor of the manifest.`)
+	flagSet.StringVar(&cfg.DigestOutput, "digest", "", `The (optional) output file for the digest of the manifest. This is useful for postprocessing.`)
+	flagSet.StringVar(&cfg.User, "user", "", `The username or UID which the process in the container should run as.`)
+	flagSet.Var((*stringMap)(&cfg.Env), "env", `Environment variables to set in the container (can be specified multiple times as key=value).`)
+	flagSet.Var((*stringMap)(&cfg.EnvAppend), "env-append", `Environment variables to append to the value of the base image, separated by the path list separator (: or ; on windows), like PATH=/app/bin (can be specified multiple times as key=value).`)
+	flagSet.Var((*stringMap)(&cfg.EnvPrepend), "env-prepend", `Environment variables to prepend to the value of the base image, separated by the path list separator (: or ; on windows), like PATH=/app/bin (can be specified multiple times as key=value).`)
+	flagSet.Var((*stringList)(&cfg.Entrypoint), "entrypoint", `Command to execute when the container starts (can be specified multiple times).`)
+	flagSet.Var((*stringList)(&cfg.Cmd), "cmd", `Default arguments to the entrypoint (can be specified multiple times).`)
+	flagSet.StringVar(&cfg.WorkingDir, "working-dir", "", `Working directory inside the container.`)
+	flagSet.Var((*stringMap)(&cfg.Labels), "label", `Metadata labels for the container (can be specified multiple times as key=value).`)
+	flagSet.Var((*stringMap)(&cfg.Annotations), "annotation", `Metadata annotations for the manifest (can be specified multiple times as key=value).`)
+	flagSet.StringVar(&cfg.DefaultLabelsFile, "default-labels-file", "", `A JSON file with default labels and annotations for every image, like {"labels": {"org.opencontainers.image.vendor": "ACME"}, "annotations": {...}}. They replace the values of the base image, but the config fragment, --label and --annotation replace them.`)
+	flagSet.StringVar(&cfg.StopSignal, "stop-signal", "", `Signal to stop the container.`)
+	flagSet.Var((*stringList)(&cfg.OnBuild), "onbuild", `Dockerfile instruction to execute when the image is used as the base of a Dockerfile build (can be specified multiple times). Legacy Docker field, not part of the OCI spec.`)
+	flagSet.Var((*stringList)(&cfg.Shell), "shell", `Shell used for the shell form of Dockerfile instructions (can be specified multiple times, one argument each). Legacy Docker field, not part of the OCI spec.`)
+	flagSet.BoolVar(&cfg.ArgsEscaped, "args-escaped", false, `Mark the entrypoint (or cmd) of a windows image as a single, pre-escaped command line. Legacy Docker field, deprecated by the OCI spec.`)
+	flagSet.Var((*stringList)(&cfg.ExposedPorts), "expose", `Port to expose as port or port/protocol, like 8080 or 53/udp (can be specified multiple times). The protocol defaults to tcp.`)
+	flagSet.Var((*stringList)(&cfg.Volumes), "volume", `Path of a volume in the container (can be specified multiple times).`)
+	flagSet.Var((*stringList)(&cfg.Healthcheck), "healthcheck", `Test command of the health check (can be specified multiple times, one argument each). Starts with NONE, CMD or CMD-SHELL; other commands are run in exec form (CMD). Legacy Docker field, not part of the OCI spec.`)
+	flagSet.DurationVar(&cfg.HealthcheckInterval, "healthcheck-interval", 0, `Time between two health checks, like 30s.`)
+	flagSet.DurationVar(&cfg.HealthcheckTimeout, "healthcheck-timeout", 0, `Time after which a health check is considered to have failed, like 10s.`)
+	flagSet.DurationVar(&cfg.HealthcheckStartPeriod, "healthcheck-start-period", 0, `Time for the container to start before failing health checks count, like 1m.`)
+	flagSet.IntVar(&cfg.HealthcheckRetries, "healthcheck-retries", 0, `Number of consecutive failed health checks after which the container is unhealthy.`)
+	flagSet.StringVar(&cfg.Subject, "subject", "", `A raw image manifest or image index to reference as the subject of the manifest (OCI referrers API).`)
+	flagSet.IntVar(&cfg.MaxLayersWarning, "max-layers-warning", 0, `Print a warning (with suggested layers to merge) if the image has more layers than this, including base layers. 0 disables the warning.`)
+	flagSet.IntVar(&cfg.MaxLayers, "max-layers", 0, `Fail (with suggested layers to merge) if the image has more layers than this, including base layers. 0 disables the check.`)
+	flagSet.BoolVar(&cfg.History, "history", false, `Add config history entries: one per layer that is not described by the history of the base image, and one empty_layer entry per config value set by this invocation.`)
+	flagSet.Var((*layerDescriptions)(&cfg.LayerCreatedBy), "layer-created-by", `The created_by description of the history entry of a layer as index=description, where index counts base layers (can be specified multiple times). Defaults to the name of the layer.`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if flagSet.NArg() != 0 {
-		fmt.Fprintf(os.Stderr, "Unexpected positional arguments: %s\n", strings.Join(flagSet.Args(), " "))
+		slog.Error("Unexpected positional arguments", "args", strings.Join(flagSet.Args(), " "))
 		flagSet.Usage()
 		os.Exit(1)
 	}
+	platform := cfg.OperatingSystem + "/" + cfg.Architecture
+	if cfg.Variant != "" {
+		platform += "/" + cfg.Variant
+	}
+	diagnostics.Set("platform", platform)
+	diagnostics.Set("max-layers", fmt.Sprintf("%d (warning at %d)", cfg.MaxLayers, cfg.MaxLayersWarning))
 
-	layers := make([]api.Descriptor, len(layerFromMetadataArgs))
-	for i, layerFile := range layerFromMetadataArgs {
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		slog.Error("Writing manifest", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+}
+
+// Run writes the config, manifest, descriptor and digest to the configured outputs.
+func (r *Runner) Run(_ context.Context) error {
+	if r.cfg.EmptyBase {
+		if r.cfg.BaseManifest != "" || r.cfg.BaseConfig != "" || r.cfg.InheritManifest != "" || r.cfg.InheritConfig != "" {
+			return fmt.Errorf("--empty-base cannot be combined with a base image")
+		}
+		if r.cfg.Variant == "" {
+			r.cfg.Variant = defaultVariant(r.cfg.Architecture)
+		}
+	}
+	inherited, err := r.readInheritedImage()
+	if err != nil {
+		return err
+	}
+	var layers []api.Descriptor
+	if inherited != nil {
+		layers = inherited.layers()
+	}
+	for _, layerFile := range r.cfg.LayerMetadataFiles {
 		layer, err := readLayerMetadata(layerFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read layer metadata file %s: %v\n", layerFile, err)
-			os.Exit(1)
+			return fmt.Errorf("reading layer metadata file %s: %w", layerFile, err)
 		}
-		layers[i] = layer
+		layers = append(layers, layer)
+	}
+	if err := r.checkLayerCount(layers, os.Stderr); err != nil {
+		return err
 	}
 
 	// Read config templates once if provided
 	var templatesData *ConfigTemplates
-	if configTemplates != "" {
+	if r.cfg.ConfigTemplates != "" {
 		var err error
-		templatesData, err = readConfigTemplates(configTemplates)
+		templatesData, err = readConfigTemplates(r.cfg.ConfigTemplates)
+		if err != nil {
+			return fmt.Errorf("reading config templates: %w", err)
+		}
+	}
+
+	defaults := &DefaultLabels{}
+	if r.cfg.DefaultLabelsFile != "" {
+		defaults, err = readDefaultLabels(r.cfg.DefaultLabelsFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read config templates: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("reading default labels: %w", err)
 		}
 	}
 
-	config, err := prepareConfig(layers, templatesData)
+	config, err := r.prepareConfig(inherited, layers, templatesData, defaults)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to prepare config: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("preparing config: %w", err)
 	}
 
 	configRaw, err := json.Marshal(config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal config: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("marshaling config: %w", err)
 	}
 	sha256Hash := sha256.Sum256(configRaw)
 
@@ -126,6 +246,7 @@ func ManifestProcess(_ context.Context, args []string) {
 			Digest:      digest.Digest(layer.Digest),
 			Size:        layer.Size,
 			Annotations: layer.Annotations,
+			URLs:        layer.URLs,
 		}
 	}
 
@@ -141,15 +262,32 @@ func ManifestProcess(_ context.Context, args []string) {
 		},
 		Layers: layerDescriptors,
 	}
+	if inherited != nil {
+		manifest.Subject = inherited.manifest.Subject
+		manifest.Annotations = maps.Clone(inherited.manifest.Annotations)
+	}
+
+	if r.cfg.Subject != "" {
+		subjectDescriptor, err := subject.ReadDescriptor(r.cfg.Subject)
+		if err != nil {
+			return fmt.Errorf("reading subject: %w", err)
+		}
+		manifest.Subject = subjectDescriptor
+	}
+
+	// Default annotations replace inherited ones, but not the annotations of this image
+	manifest.Annotations = mergeDefaults(manifest.Annotations, defaults.Annotations)
 
 	// Apply annotations from config templates or command line
-	annotationsToApply := annotations
+	annotationsToApply := r.cfg.Annotations
 	if templatesData != nil && templatesData.Annotations != nil {
 		annotationsToApply = templatesData.Annotations
 	}
 
 	if len(annotationsToApply) > 0 {
-		manifest.Annotations = make(map[string]string)
+		if manifest.Annotations == nil {
+			manifest.Annotations = make(map[string]string)
+		}
 		// Add annotations in sorted order to ensure determinism
 		keys := make([]string, 0, len(annotationsToApply))
 		for key := range annotationsToApply {
@@ -163,8 +301,7 @@ func ManifestProcess(_ context.Context, args []string) {
 
 	manifestRaw, err := json.Marshal(manifest)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal manifest: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("marshaling manifest: %w", err)
 	}
 
 	manifestSHA256 := sha256.Sum256(manifestRaw)
@@ -173,69 +310,74 @@ func ManifestProcess(_ context.Context, args []string) {
 		Digest:    digest.NewDigestFromBytes(digest.SHA256, manifestSHA256[:]),
 		Size:      int64(len(manifestRaw)),
 		Platform: &specv1.Platform{
-			Architecture: architecture,
-			OS:           operatingSystem,
+			Architecture: r.cfg.Architecture,
+			OS:           r.cfg.OperatingSystem,
+			Variant:      config.Variant,
+			// Windows hosts refuse to run images whose platform
+			// does not carry the os.version of the base image.
+			OSVersion:  config.OSVersion,
+			OSFeatures: slices.Clone(config.OSFeatures),
 		},
+		// image indexes list the annotations of their manifests (like org.opencontainers.image.ref.name)
+		Annotations: maps.Clone(manifest.Annotations),
 	}
 	descriptorRaw, err := json.Marshal(descriptor)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to marshal manifest descriptor: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("marshaling manifest descriptor: %w", err)
 	}
 
-	if manifestOutput != "" {
-		if err := os.WriteFile(manifestOutput, manifestRaw, 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write manifest to %s: %v\n", manifestOutput, err)
-			os.Exit(1)
+	if r.cfg.ManifestOutput != "" {
+		if err := os.WriteFile(r.cfg.ManifestOutput, manifestRaw, 0o644); err != nil {
+			return fmt.Errorf("writing manifest to %s: %w", r.cfg.ManifestOutput, err)
 		}
 	}
-	if configOutput != "" {
-		if err := os.WriteFile(configOutput, configRaw, 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write config to %s: %v\n", configOutput, err)
-			os.Exit(1)
+	if r.cfg.ConfigOutput != "" {
+		if err := os.WriteFile(r.cfg.ConfigOutput, configRaw, 0o644); err != nil {
+			return fmt.Errorf("writing config to %s: %w", r.cfg.ConfigOutput, err)
 		}
 	}
-	if descriptorOutput != "" {
-		if err := os.WriteFile(descriptorOutput, descriptorRaw, 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write manifest descriptor to %s: %v\n", descriptorOutput, err)
-			os.Exit(1)
+	if r.cfg.DescriptorOutput != "" {
+		if err := os.WriteFile(r.cfg.DescriptorOutput, descriptorRaw, 0o644); err != nil {
+			return fmt.Errorf("writing manifest descriptor to %s: %w", r.cfg.DescriptorOutput, err)
 		}
 	}
-	if digestOutput != "" {
-		digestFile, err := os.Create(digestOutput)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create digest file %s: %v\n", digestOutput, err)
-			os.Exit(1)
-		}
-		defer digestFile.Close()
-
-		if _, err := fmt.Fprintf(digestFile, "%s", fmt.Sprintf("sha256:%x", manifestSHA256)); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write digest to %s: %v\n", digestOutput, err)
-			os.Exit(1)
+	if r.cfg.DigestOutput != "" {
+		if err := os.WriteFile(r.cfg.DigestOutput, []byte(fmt.Sprintf("sha256:%x", manifestSHA256)), 0o644); err != nil {
+			return fmt.Errorf("writing digest to %s: %w", r.cfg.DigestOutput, err)
 		}
 	}
+	return nil
 }
 
-func prepareConfig(layers []api.Descriptor, templatesData *ConfigTemplates) (specv1.Image, error) {
-	// first, read the base config
-	// then, layer the config fragment on top of it
+func (r *Runner) prepareConfig(inherited *inheritedImage, layers []api.Descriptor, templatesData *ConfigTemplates, defaults *DefaultLabels) (image, error) {
+	// first, read the base config (or start with the inherited config)
+	// then, add the default labels and layer the config fragment on top of it
 	// finally, add our own stuff
 
-	var config specv1.Image
-	if baseConfig != "" {
-		if err := overlayConfigFromFile(&config, baseConfig, true); err != nil {
+	var config image
+	if inherited != nil {
+		config = inherited.config
+	}
+	if r.cfg.BaseConfig != "" {
+		if err := overlayConfigFromFile(&config, r.cfg.BaseConfig, true, false); err != nil {
 			return config, fmt.Errorf("reading base config: %w", err)
 		}
 	}
-	if configFragment != "" {
-		if err := overlayConfigFromFile(&config, configFragment, false); err != nil {
+	config.Config.Labels = mergeDefaults(config.Config.Labels, defaults.Labels)
+	if r.cfg.ConfigFragment != "" {
+		if err := overlayConfigFromFile(&config, r.cfg.ConfigFragment, false, r.cfg.StrictConfig); err != nil {
 			return config, fmt.Errorf("reading config fragment: %w", err)
 		}
 	}
 
-	if err := overlayNewConfigValues(&config, layers, templatesData); err != nil {
+	if err := r.overlayNewConfigValues(&config, layers, templatesData); err != nil {
 		return config, fmt.Errorf("overlaying new config values: %w", err)
 	}
+	if r.cfg.History {
+		if err := r.appendHistory(&config, layers, templatesData); err != nil {
+			return config, fmt.Errorf("synthesizing history: %w", err)
+		}
+	}
 	return config, nil
 }
 
@@ -256,15 +398,22 @@ func readLayerMetadata(filePath string) (api.Descriptor, error) {
 	return layer, nil
 }
 
-func overlayConfigFromFile(config *specv1.Image, filePath string, isBase bool) error {
-	file, err := os.Open(filePath)
+// overlayConfigFromFile merges a base config or config fragment into config.
+// Config fragments are written by hand, so they are validated first (see checkConfigFragment).
+// With strict, unknown fields of a fragment are errors instead of warnings.
+func overlayConfigFromFile(config *image, filePath string, isBase, strict bool) error {
+	raw, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("opening config file: %w", err)
 	}
-	defer file.Close()
+	if !isBase {
+		if err := checkConfigFragment(raw, strict, os.Stderr); err != nil {
+			return fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
 
-	var configFragment specv1.Image
-	if err := json.NewDecoder(file).Decode(&configFragment); err != nil {
+	var configFragment image
+	if err := json.Unmarshal(raw, &configFragment); err != nil {
 		return fmt.Errorf("decoding config file: %w", err)
 	}
 
@@ -283,6 +432,15 @@ func overlayConfigFromFile(config *specv1.Image, filePath string, isBase bool) e
 	if configFragment.Architecture != "" {
 		config.Architecture = configFragment.Architecture
 	}
+	if configFragment.Variant != "" {
+		config.Variant = configFragment.Variant
+	}
+	if configFragment.OSVersion != "" {
+		config.OSVersion = configFragment.OSVersion
+	}
+	if configFragment.OSFeatures != nil {
+		config.OSFeatures = slices.Clone(configFragment.OSFeatures)
+	}
 	if len(configFragment.History) > 0 {
 		config.History = append(config.History, configFragment.History...)
 	}
@@ -356,6 +514,14 @@ func overlayConfigFromFile(config *specv1.Image, filePath string, isBase bool) e
 	if configFragment.Config.StopSignal != "" {
 		config.Config.StopSignal = configFragment.Config.StopSignal
 	}
+	if configFragment.Config.Shell != nil {
+		config.Config.Shell = slices.Clone(configFragment.Config.Shell)
+	}
+	if configFragment.Config.Healthcheck != nil {
+		healthcheck := *configFragment.Config.Healthcheck
+		healthcheck.Test = slices.Clone(healthcheck.Test)
+		config.Config.Healthcheck = &healthcheck
+	}
 
 	// inherit some fields if this is not a base config
 	if !isBase {
@@ -365,23 +531,37 @@ func overlayConfigFromFile(config *specv1.Image, filePath string, isBase bool) e
 		if configFragment.Author != "" {
 			config.Author = configFragment.Author
 		}
+		if configFragment.Config.ArgsEscaped {
+			config.Config.ArgsEscaped = true
+		}
+		// Docker executes the ONBUILD triggers of a base image
+		// while building the child image, so only the fragment can set them.
+		if configFragment.Config.OnBuild != nil {
+			config.Config.OnBuild = slices.Clone(configFragment.Config.OnBuild)
+		}
 	}
 
 	return nil
 }
 
-func overlayNewConfigValues(config *specv1.Image, layers []api.Descriptor, templatesData *ConfigTemplates) error {
-	if config.OS != "" && operatingSystem != "" && config.OS != operatingSystem {
-		return fmt.Errorf("OS mismatch: %s != %s", config.OS, operatingSystem)
+func (r *Runner) overlayNewConfigValues(config *image, layers []api.Descriptor, templatesData *ConfigTemplates) error {
+	if config.OS != "" && r.cfg.OperatingSystem != "" && config.OS != r.cfg.OperatingSystem {
+		return fmt.Errorf("OS mismatch: %s != %s", config.OS, r.cfg.OperatingSystem)
 	}
 	if config.OS == "" {
-		config.OS = operatingSystem
+		config.OS = r.cfg.OperatingSystem
 	}
-	if config.Architecture != "" && architecture != "" && config.Architecture != architecture {
-		return fmt.Errorf("architecture mismatch: %s != %s", config.Architecture, architecture)
+	if config.Architecture != "" && r.cfg.Architecture != "" && config.Architecture != r.cfg.Architecture {
+		return fmt.Errorf("architecture mismatch: %s != %s", config.Architecture, r.cfg.Architecture)
 	}
 	if config.Architecture == "" {
-		config.Architecture = architecture
+		config.Architecture = r.cfg.Architecture
+	}
+	if config.Variant != "" && r.cfg.Variant != "" && config.Variant != r.cfg.Variant {
+		return fmt.Errorf("variant mismatch: %s != %s", config.Variant, r.cfg.Variant)
+	}
+	if config.Variant == "" {
+		config.Variant = r.cfg.Variant
 	}
 
 	// Set the rootfs struct
@@ -392,12 +572,12 @@ func overlayNewConfigValues(config *specv1.Image, layers []api.Descriptor, templ
 	}
 
 	// Apply command-line config values
-	if user != "" {
-		config.Config.User = user
+	if r.cfg.User != "" {
+		config.Config.User = r.cfg.User
 	}
 
 	// Apply environment variables from config templates or command line
-	envToApply := env
+	envToApply := r.cfg.Env
 	if templatesData != nil && templatesData.Env != nil {
 		envToApply = templatesData.Env
 	}
@@ -426,20 +606,36 @@ func overlayNewConfigValues(config *specv1.Image, layers []api.Descriptor, templ
 		}
 	}
 
-	if len(entrypoint) > 0 {
-		config.Config.Entrypoint = []string(entrypoint)
+	// Extend environment variables after setting them,
+	// so that PATH can be both set and extended by the same image.
+	separator := pathListSeparator(config.OS)
+	for _, key := range slices.Sorted(maps.Keys(r.cfg.EnvPrepend)) {
+		config.Config.Env = extendEnv(config.Config.Env, key, r.cfg.EnvPrepend[key], separator, true)
+	}
+	for _, key := range slices.Sorted(maps.Keys(r.cfg.EnvAppend)) {
+		config.Config.Env = extendEnv(config.Config.Env, key, r.cfg.EnvAppend[key], separator, false)
 	}
 
-	if len(cmd) > 0 {
-		config.Config.Cmd = []string(cmd)
+	if len(r.cfg.Entrypoint) > 0 {
+		config.Config.Entrypoint = slices.Clone(r.cfg.Entrypoint)
 	}
 
-	if workingDir != "" {
-		config.Config.WorkingDir = workingDir
+	if len(r.cfg.Cmd) > 0 {
+		config.Config.Cmd = slices.Clone(r.cfg.Cmd)
+	}
+
+	// ArgsEscaped describes the form of the command line,
+	// so a new command line resets it.
+	if len(r.cfg.Entrypoint) > 0 || len(r.cfg.Cmd) > 0 || r.cfg.ArgsEscaped {
+		config.Config.ArgsEscaped = r.cfg.ArgsEscaped
+	}
+
+	if r.cfg.WorkingDir != "" {
+		config.Config.WorkingDir = r.cfg.WorkingDir
 	}
 
 	// Apply labels from config templates or command line
-	labelsToApply := labels
+	labelsToApply := r.cfg.Labels
 	if templatesData != nil && templatesData.Labels != nil {
 		labelsToApply = templatesData.Labels
 	}
@@ -459,13 +655,142 @@ func overlayNewConfigValues(config *specv1.Image, layers []api.Descriptor, templ
 		}
 	}
 
-	if stopSignal != "" {
-		config.Config.StopSignal = stopSignal
+	if r.cfg.StopSignal != "" {
+		config.Config.StopSignal = r.cfg.StopSignal
+	}
+
+	if len(r.cfg.ExposedPorts) > 0 {
+		if config.Config.ExposedPorts == nil {
+			config.Config.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range r.cfg.ExposedPorts {
+			normalized, err := normalizePort(port)
+			if err != nil {
+				return err
+			}
+			config.Config.ExposedPorts[normalized] = struct{}{}
+		}
+	}
+
+	if len(r.cfg.Volumes) > 0 {
+		if config.Config.Volumes == nil {
+			config.Config.Volumes = make(map[string]struct{})
+		}
+		for _, volume := range r.cfg.Volumes {
+			if volume == "" {
+				return fmt.Errorf("empty volume path")
+			}
+			config.Config.Volumes[volume] = struct{}{}
+		}
+	}
+
+	if r.setsHealthcheck() {
+		healthcheck := healthConfig{}
+		if config.Config.Healthcheck != nil {
+			healthcheck = *config.Config.Healthcheck
+		}
+		if len(r.cfg.Healthcheck) > 0 {
+			healthcheck.Test = healthcheckTest(r.cfg.Healthcheck)
+		}
+		if r.cfg.HealthcheckInterval != 0 {
+			healthcheck.Interval = r.cfg.HealthcheckInterval
+		}
+		if r.cfg.HealthcheckTimeout != 0 {
+			healthcheck.Timeout = r.cfg.HealthcheckTimeout
+		}
+		if r.cfg.HealthcheckStartPeriod != 0 {
+			healthcheck.StartPeriod = r.cfg.HealthcheckStartPeriod
+		}
+		if r.cfg.HealthcheckRetries != 0 {
+			healthcheck.Retries = r.cfg.HealthcheckRetries
+		}
+		config.Config.Healthcheck = &healthcheck
+	}
+
+	if len(r.cfg.OnBuild) > 0 {
+		config.Config.OnBuild = slices.Clone(r.cfg.OnBuild)
 	}
 
+	if len(r.cfg.Shell) > 0 {
+		config.Config.Shell = slices.Clone(r.cfg.Shell)
+	}
+
+	if err := validateOnBuild(config.Config.OnBuild); err != nil {
+		return err
+	}
+	if err := validateShell(config.Config.Shell); err != nil {
+		return err
+	}
+	if err := validateHealthcheck(config.Config.Healthcheck); err != nil {
+		return err
+	}
+	if r.cfg.ArgsEscaped {
+		return validateArgsEscaped(config)
+	}
 	return nil
 }
 
+// defaultVariant returns the variant of images on an empty base for architectures that have variants.
+// These are the variants that container runtimes assume for images without a variant.
+func defaultVariant(architecture string) string {
+	switch architecture {
+	case "arm64":
+		return "v8"
+	case "arm":
+		return "v7"
+	}
+	return ""
+}
+
+// pathListSeparator returns the separator of lists of paths (like PATH) on the given OS.
+func pathListSeparator(operatingSystem string) string {
+	if operatingSystem == "windows" {
+		return ";"
+	}
+	return ":"
+}
+
+// extendEnv appends (or prepends) value to the environment variable key, separated by separator.
+// Variables that are not set (or empty) are set to value.
+func extendEnv(env []string, key, value, separator string, prepend bool) []string {
+	for i, envVar := range env {
+		k, current, _ := strings.Cut(envVar, "=")
+		if k != key {
+			continue
+		}
+		switch {
+		case current == "":
+			current = value
+		case prepend:
+			current = value + separator + current
+		default:
+			current = current + separator + value
+		}
+		env[i] = key + "=" + current
+		return env
+	}
+	return append(env, key+"="+value)
+}
+
+// setsHealthcheck reports whether any health check value is set by this invocation.
+func (r *Runner) setsHealthcheck() bool {
+	return len(r.cfg.Healthcheck) > 0 ||
+		r.cfg.HealthcheckInterval != 0 ||
+		r.cfg.HealthcheckTimeout != 0 ||
+		r.cfg.HealthcheckStartPeriod != 0 ||
+		r.cfg.HealthcheckRetries != 0
+}
+
+// healthcheckTest returns the test of a health check in the form used by Docker.
+// Commands without a NONE, CMD or CMD-SHELL prefix use the exec form.
+func healthcheckTest(test []string) []string {
+	switch test[0] {
+	case "NONE", "CMD", "CMD-SHELL":
+		return slices.Clone(test)
+	}
+	return append([]string{"CMD"}, test...)
+}
+
 // ConfigTemplates represents the structure of the config templates JSON file
 type ConfigTemplates struct {
 	Env         map[string]string `json:"env"`
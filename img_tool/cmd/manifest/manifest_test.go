@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtendEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		key       string
+		value     string
+		separator string
+		prepend   bool
+		want      []string
+	}{
+		{
+			name:      "append",
+			env:       []string{"HOME=/root", "PATH=/usr/bin:/bin"},
+			key:       "PATH",
+			value:     "/app/bin",
+			separator: ":",
+			want:      []string{"HOME=/root", "PATH=/usr/bin:/bin:/app/bin"},
+		},
+		{
+			name:      "prepend",
+			env:       []string{"PATH=/usr/bin"},
+			key:       "PATH",
+			value:     "/app/bin",
+			separator: ":",
+			prepend:   true,
+			want:      []string{"PATH=/app/bin:/usr/bin"},
+		},
+		{
+			name:      "windows separator",
+			env:       []string{`PATH=C:\Windows`},
+			key:       "PATH",
+			value:     `C:\app`,
+			separator: ";",
+			want:      []string{`PATH=C:\Windows;C:\app`},
+		},
+		{
+			name:      "unset variable is set",
+			env:       []string{"HOME=/root"},
+			key:       "PYTHONPATH",
+			value:     "/app/lib",
+			separator: ":",
+			prepend:   true,
+			want:      []string{"HOME=/root", "PYTHONPATH=/app/lib"},
+		},
+		{
+			name:      "empty variable has no leading separator",
+			env:       []string{"LD_LIBRARY_PATH="},
+			key:       "LD_LIBRARY_PATH",
+			value:     "/app/lib",
+			separator: ":",
+			want:      []string{"LD_LIBRARY_PATH=/app/lib"},
+		},
+		{
+			name:      "variable without value",
+			env:       []string{"LD_LIBRARY_PATH"},
+			key:       "LD_LIBRARY_PATH",
+			value:     "/app/lib",
+			separator: ":",
+			want:      []string{"LD_LIBRARY_PATH=/app/lib"},
+		},
+		{
+			name:      "prefix of another variable",
+			env:       []string{"PATHEXT=.exe"},
+			key:       "PATH",
+			value:     "/bin",
+			separator: ":",
+			want:      []string{"PATHEXT=.exe", "PATH=/bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extendEnv(tt.env, tt.key, tt.value, tt.separator, tt.prepend)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extendEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlayEnvAppendPrepend(t *testing.T) {
+	tests := []struct {
+		name string
+		os   string
+		env  []string
+		cfg  Config
+		want []string
+	}{
+		{
+			name: "set and extended by the same image",
+			os:   "linux",
+			env:  []string{"PATH=/usr/bin"},
+			cfg: Config{
+				Env:        map[string]string{"PATH": "/bin"},
+				EnvPrepend: map[string]string{"PATH": "/app/bin"},
+				EnvAppend:  map[string]string{"PATH": "/opt/bin"},
+			},
+			want: []string{"PATH=/app/bin:/bin:/opt/bin"},
+		},
+		{
+			name: "windows",
+			os:   "windows",
+			env:  []string{`PATH=C:\Windows`},
+			cfg:  Config{EnvAppend: map[string]string{"PATH": `C:\app`}},
+			want: []string{`PATH=C:\Windows;C:\app`},
+		},
+		{
+			name: "not set by the base image",
+			os:   "linux",
+			cfg:  Config{EnvAppend: map[string]string{"PYTHONPATH": "/app/lib"}},
+			want: []string{"PYTHONPATH=/app/lib"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := image{}
+			config.OS = tt.os
+			config.Config.Env = tt.env
+			if err := NewRunner(tt.cfg).overlayNewConfigValues(&config, nil, nil); err != nil {
+				t.Fatalf("overlayNewConfigValues() error = %v", err)
+			}
+			if !reflect.DeepEqual(config.Config.Env, tt.want) {
+				t.Errorf("overlayNewConfigValues() env = %q, want %q", config.Config.Env, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestRunnersConcurrently runs several runners with different configs in parallel
+// and checks that they write the same outputs as when run one after another.
+func TestRunnersConcurrently(t *testing.T) {
+	const runners = 8
+	dir := t.TempDir()
+	configs := func(prefix string) []Config {
+		cfgs := make([]Config, runners)
+		for i := range cfgs {
+			arch := "amd64"
+			if i%2 == 1 {
+				arch = "arm64"
+			}
+			cfgs[i] = Config{
+				OperatingSystem: "linux",
+				Architecture:    arch,
+				EmptyBase:       true,
+				Env:             map[string]string{"RUNNER": fmt.Sprint(i)},
+				Labels:          map[string]string{"org.example.runner": fmt.Sprint(i)},
+				Entrypoint:      []string{"/bin/app", fmt.Sprintf("--id=%d", i)},
+				History:         true,
+				ManifestOutput:  filepath.Join(dir, fmt.Sprintf("%s%d_manifest.json", prefix, i)),
+				ConfigOutput:    filepath.Join(dir, fmt.Sprintf("%s%d_config.json", prefix, i)),
+				DigestOutput:    filepath.Join(dir, fmt.Sprintf("%s%d_digest", prefix, i)),
+			}
+		}
+		return cfgs
+	}
+
+	sequential := configs("sequential")
+	for _, cfg := range sequential {
+		if err := NewRunner(cfg).Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	concurrent := configs("concurrent")
+	errs := make([]error, runners)
+	var wg sync.WaitGroup
+	for i, cfg := range concurrent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = NewRunner(cfg).Run(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	digests := make(map[string]bool)
+	for i := range concurrent {
+		if errs[i] != nil {
+			t.Fatalf("runner %d: %v", i, errs[i])
+		}
+		for _, output := range []struct{ sequential, concurrent string }{
+			{sequential[i].ManifestOutput, concurrent[i].ManifestOutput},
+			{sequential[i].ConfigOutput, concurrent[i].ConfigOutput},
+			{sequential[i].DigestOutput, concurrent[i].DigestOutput},
+		} {
+			want, err := os.ReadFile(output.sequential)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(output.concurrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("runner %d wrote %s concurrently:\n%s\nwant (sequentially):\n%s", i, filepath.Base(output.concurrent), got, want)
+			}
+		}
+		digest, err := os.ReadFile(concurrent[i].DigestOutput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[string(digest)] = true
+	}
+	if len(digests) != runners {
+		t.Errorf("got %d different manifest digests, want one per runner (%d)", len(digests), runners)
+	}
+}
@@ -0,0 +1,103 @@
+package ocilayout
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// copyMethod is the mechanism used to place a blob in the OCI layout.
+type copyMethod string
+
+const (
+	copyMethodSymlink       copyMethod = "symlink"
+	copyMethodHardlink      copyMethod = "hardlink"
+	copyMethodReflink       copyMethod = "reflink"
+	copyMethodCopyFileRange copyMethod = "copy_file_range"
+	copyMethodCopy          copyMethod = "copy"
+	copyMethodTar           copyMethod = "tar"
+)
+
+func copyFile(src, dst string, useSymlinks bool) (copyMethod, error) {
+	if useSymlinks {
+		absSrc, err := filepath.Abs(src)
+		if err != nil {
+			return "", err
+		}
+		return copyMethodSymlink, os.Symlink(absSrc, dst)
+	}
+
+	if err := os.Link(src, dst); err == nil {
+		return copyMethodHardlink, nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer dstFile.Close()
+
+	method, err := cloneFile(dstFile, srcFile, srcInfo.Size())
+	if err != nil {
+		return "", err
+	}
+	return method, dstFile.Close()
+}
+
+// regularCopy copies the remaining contents of src to dst in user space.
+func regularCopy(dst, src *os.File) error {
+	// hide ReadFrom of *os.File, so that the Go runtime doesn't
+	// retry the kernel copy mechanisms that already failed
+	_, err := io.Copy(struct{ io.Writer }{dst}, src)
+	return err
+}
+
+// copySummary counts the blobs and bytes placed by each copy mechanism.
+type copySummary struct {
+	mu    sync.Mutex
+	blobs map[copyMethod]int
+	bytes map[copyMethod]int64
+}
+
+func newCopySummary() *copySummary {
+	return &copySummary{
+		blobs: make(map[copyMethod]int),
+		bytes: make(map[copyMethod]int64),
+	}
+}
+
+func (s *copySummary) add(method copyMethod, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[method]++
+	s.bytes[method] += size
+}
+
+func (s *copySummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for _, method := range slices.Sorted(maps.Keys(s.blobs)) {
+		parts = append(parts, fmt.Sprintf("%s: %d blobs (%d bytes)", method, s.blobs[method], s.bytes[method]))
+	}
+	if len(parts) == 0 {
+		return "no blobs copied"
+	}
+	return strings.Join(parts, ", ")
+}
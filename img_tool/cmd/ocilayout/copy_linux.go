@@ -3,40 +3,62 @@
 package ocilayout
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
-// tryReflink attempts to use FICLONE ioctl for efficient copying on Linux
-// This creates a reflink (copy-on-write) copy when supported by the filesystem
-func tryReflink(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
+// maxCopyFileRangeChunk bounds a single copy_file_range call (the kernel limits it to ~2GiB anyway).
+const maxCopyFileRangeChunk = 1 << 30
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
+// cloneFile copies the contents of src into dst using the most efficient mechanism supported by the filesystems:
+// a reflink (FICLONE, copy-on-write on btrfs/xfs), copy_file_range (in-kernel copy that works across
+// filesystems on recent kernels and is offloaded to the server on NFS), or a regular copy.
+func cloneFile(dst, src *os.File, size int64) (copyMethod, error) {
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if err == nil {
+		return copyMethodReflink, nil
+	}
+	if !isUnsupported(err) {
+		return "", fmt.Errorf("FICLONE ioctl failed: %w", err)
 	}
-	defer dstFile.Close()
 
-	// Try FICLONE ioctl for reflink copy
-	// FICLONE = 0x40049409
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dstFile.Fd(), 0x40049409, srcFile.Fd())
-	if errno == 0 {
-		return nil
+	copied, err := copyFileRange(dst, src, size)
+	if err == nil {
+		return copyMethodCopyFileRange, nil
+	}
+	if copied > 0 || !isUnsupported(err) {
+		return "", fmt.Errorf("copy_file_range failed: %w", err)
 	}
 
-	// If FICLONE is not supported (e.g., different filesystem, not btrfs/xfs),
-	// fall back to regular copy
-	if errno == syscall.ENOTSUP || errno == syscall.EXDEV || errno == syscall.EINVAL {
-		_, err = io.Copy(dstFile, srcFile)
-		return err
+	return copyMethodCopy, regularCopy(dst, src)
+}
+
+func copyFileRange(dst, src *os.File, size int64) (int64, error) {
+	var copied int64
+	for copied < size {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(min(size-copied, maxCopyFileRangeChunk)), 0)
+		if err != nil {
+			return copied, err
+		}
+		if n == 0 {
+			// source is shorter than expected
+			break
+		}
+		copied += int64(n)
 	}
+	return copied, nil
+}
 
-	return fmt.Errorf("FICLONE ioctl failed: %v", errno)
+// isUnsupported reports whether the error means that the mechanism is not available
+// for this pair of files (different filesystems, old kernels, filesystems without support).
+func isUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) ||
+		errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.ENOTTY)
 }
@@ -2,25 +2,9 @@
 
 package ocilayout
 
-import (
-	"io"
-	"os"
-)
+import "os"
 
-// tryReflink is not supported on non-Linux platforms, so it just does a regular copy
-func tryReflink(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+// cloneFile is limited to a regular copy on non-Linux platforms
+func cloneFile(dst, src *os.File, _ int64) (copyMethod, error) {
+	return copyMethodCopy, regularCopy(dst, src)
 }
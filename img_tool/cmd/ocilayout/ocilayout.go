@@ -6,12 +6,18 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
 	v1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 const OCILayoutVersion = "1.0.0"
@@ -48,6 +54,8 @@ func OCILayoutProcess(ctx context.Context, args []string) {
 	var useSymlinks bool
 	var allowMissingBlobs bool
 	var format string
+	var jobs int
+	var printSummary bool
 
 	flagSet := flag.NewFlagSet("oci-layout", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -74,6 +82,9 @@ func OCILayoutProcess(ctx context.Context, args []string) {
 	flagSet.Var(&configPaths, "config-path", "Path to config file (for index, can be specified multiple times)")
 	flagSet.BoolVar(&useSymlinks, "symlink", false, "Use symlinks instead of copying files")
 	flagSet.BoolVar(&allowMissingBlobs, "allow-missing-blobs", false, "Allow missing blobs instead of failing the build")
+	flagSet.IntVar(&jobs, "j", runtime.NumCPU(), "Number of concurrent blob copies (only used for the directory format)")
+	flagSet.BoolVar(&printSummary, "summary", false, "Print which mechanism (hardlink, reflink, copy_file_range, copy, ...) was used to place the blobs")
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
@@ -82,57 +93,67 @@ func OCILayoutProcess(ctx context.Context, args []string) {
 
 	// Validate required flags
 	if outputDir == "" {
-		fmt.Fprintf(os.Stderr, "Error: --output is required\n")
+		slog.Error("--output is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
+	if jobs < 1 {
+		logging.Fatal("-j must be at least 1", "jobs", jobs)
+	}
+
 	// Validate format parameter
 	if format != "directory" && format != "tar" {
-		fmt.Fprintf(os.Stderr, "Error: --format must be 'directory' or 'tar', got '%s'\n", format)
+		slog.Error(fmt.Sprintf("--format must be 'directory' or 'tar', got '%s'", format))
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
+	opts := copyOptions{useSymlinks: useSymlinks, jobs: jobs, summary: newCopySummary()}
 	var err error
 	if indexPath != "" {
 		if manifestPath != "" || configPath != "" {
-			fmt.Fprintf(os.Stderr, "Error: cannot use --manifest or --config with --index\n")
-			os.Exit(1)
+			logging.Fatal("Cannot use --manifest or --config with --index")
 		}
 		if len(manifestPaths) != len(configPaths) {
-			fmt.Fprintf(os.Stderr, "Error: number of --manifest-path must match --config-path\n")
-			os.Exit(1)
+			logging.Fatal("Number of --manifest-path must match --config-path")
 		}
 		if len(manifestPaths) == 0 {
-			fmt.Fprintf(os.Stderr, "Error: --index requires at least one --manifest-path and --config-path\n")
-			os.Exit(1)
+			logging.Fatal("--index requires at least one --manifest-path and --config-path")
 		}
-		err = assembleOCILayoutWithIndex(indexPath, outputDir, format, manifestPaths, configPaths, layerFlags, useSymlinks, allowMissingBlobs)
+		err = assembleOCILayoutWithIndex(indexPath, outputDir, format, manifestPaths, configPaths, layerFlags, opts, allowMissingBlobs)
 	} else {
 		if manifestPath == "" {
-			fmt.Fprintf(os.Stderr, "Error: either --manifest or --index is required\n")
+			slog.Error("Either --manifest or --index is required")
 			flagSet.Usage()
 			os.Exit(1)
 		}
 		if configPath == "" {
-			fmt.Fprintf(os.Stderr, "Error: --config is required when using --manifest\n")
+			slog.Error("--config is required when using --manifest")
 			flagSet.Usage()
 			os.Exit(1)
 		}
 		if len(manifestPaths) > 0 || len(configPaths) > 0 {
-			fmt.Fprintf(os.Stderr, "Error: cannot use --manifest-path or --config-path without --index\n")
-			os.Exit(1)
+			logging.Fatal("Cannot use --manifest-path or --config-path without --index")
 		}
-		err = assembleOCILayout(manifestPath, configPath, outputDir, format, layerFlags, useSymlinks, allowMissingBlobs)
+		err = assembleOCILayout(manifestPath, configPath, outputDir, format, layerFlags, opts, allowMissingBlobs)
 	}
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		logging.Fatal("Assembling OCI layout", logging.ErrKey, err)
+	}
+	if printSummary {
+		slog.Info("Placed OCI layout blobs", "via", opts.summary)
 	}
 }
 
+// copyOptions control how blobs are placed in the OCI layout.
+type copyOptions struct {
+	useSymlinks bool
+	jobs        int
+	summary     *copySummary
+}
+
 // createSink creates the appropriate sink based on the format
 func createSink(outputPath, format string) (OCILayoutSink, error) {
 	switch format {
@@ -145,7 +166,7 @@ func createSink(outputPath, format string) (OCILayoutSink, error) {
 	}
 }
 
-func assembleOCILayout(manifestPath, configPath, outputPath, format string, layers layerMappingFlag, useSymlinks, allowMissingBlobs bool) error {
+func assembleOCILayout(manifestPath, configPath, outputPath, format string, layers layerMappingFlag, opts copyOptions, allowMissingBlobs bool) error {
 	sink, err := createSink(outputPath, format)
 	if err != nil {
 		return err
@@ -207,7 +228,7 @@ func assembleOCILayout(manifestPath, configPath, outputPath, format string, laye
 	manifestDigest := hashBytes(manifestData)
 	blobs[manifestDigest.Hex] = manifestPath
 
-	if err := copyBlobsWithSink(sink, blobs, useSymlinks); err != nil {
+	if err := copyBlobsWithSink(sink, blobs, opts); err != nil {
 		return err
 	}
 
@@ -226,45 +247,12 @@ func assembleOCILayout(manifestPath, configPath, outputPath, format string, laye
 	return writeJSONWithSink(sink, "index.json", index)
 }
 
-func copyFile(src, dst string, useSymlinks bool) error {
-	if useSymlinks {
-		absSrc, err := filepath.Abs(src)
-		if err != nil {
-			return err
-		}
-		return os.Symlink(absSrc, dst)
-	}
-
-	if err := os.Link(src, dst); err == nil {
-		return nil
-	}
-
-	if err := tryReflink(src, dst); err == nil {
-		return nil
-	}
-
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, srcFile)
-	return err
-}
-
 func hashBytes(data []byte) v1.Hash {
 	h, _, _ := v1.SHA256(bytes.NewReader(data))
 	return h
 }
 
-func assembleOCILayoutWithIndex(indexPath, outputPath, format string, manifestPaths, configPaths []string, layers layerMappingFlag, useSymlinks, allowMissingBlobs bool) error {
+func assembleOCILayoutWithIndex(indexPath, outputPath, format string, manifestPaths, configPaths []string, layers layerMappingFlag, opts copyOptions, allowMissingBlobs bool) error {
 	sink, err := createSink(outputPath, format)
 	if err != nil {
 		return err
@@ -330,12 +318,13 @@ func assembleOCILayoutWithIndex(indexPath, outputPath, format string, manifestPa
 		return &MissingBlobsError{MissingBlobs: allMissingBlobs}
 	}
 
-	if err := copyBlobsWithSink(sink, blobs, useSymlinks); err != nil {
+	if err := copyBlobsWithSink(sink, blobs, opts); err != nil {
 		return err
 	}
 
 	// Copy the index file unmodified
-	return sink.CopyFile("index.json", indexPath, false)
+	_, err = sink.CopyFile("index.json", indexPath, false)
+	return err
 }
 
 func setupOCILayout(outputDir string) error {
@@ -383,19 +372,37 @@ func writeJSONWithSink(sink OCILayoutSink, path string, v interface{}) error {
 func copyBlobs(blobs blobMap, blobsDir string, useSymlinks bool) error {
 	for digest, srcPath := range blobs {
 		dstPath := filepath.Join(blobsDir, digest)
-		if err := copyFile(srcPath, dstPath, useSymlinks); err != nil {
+		if _, err := copyFile(srcPath, dstPath, useSymlinks); err != nil {
 			return fmt.Errorf("copying blob %s: %w", digest, err)
 		}
 	}
 	return nil
 }
 
-func copyBlobsWithSink(sink OCILayoutSink, blobs blobMap, useSymlinks bool) error {
-	for digest, srcPath := range blobs {
-		dstPath := filepath.Join("blobs", "sha256", digest)
-		if err := sink.CopyFile(dstPath, srcPath, useSymlinks); err != nil {
-			return fmt.Errorf("copying blob %s: %w", digest, err)
-		}
+// copyBlobsWithSink places all blobs in the sink.
+// Blobs are copied concurrently if the sink supports it, which matters for layouts with many large layers.
+func copyBlobsWithSink(sink OCILayoutSink, blobs blobMap, opts copyOptions) error {
+	var g errgroup.Group
+	if sink.ConcurrentCopies() {
+		g.SetLimit(opts.jobs)
+	} else {
+		g.SetLimit(1)
+	}
+	for _, digest := range slices.Sorted(maps.Keys(blobs)) {
+		srcPath := blobs[digest]
+		g.Go(func() error {
+			dstPath := filepath.Join("blobs", "sha256", digest)
+			method, err := sink.CopyFile(dstPath, srcPath, opts.useSymlinks)
+			if err != nil {
+				return fmt.Errorf("copying blob %s: %w", digest, err)
+			}
+			var size int64
+			if info, err := os.Stat(srcPath); err == nil {
+				size = info.Size()
+			}
+			opts.summary.add(method, size)
+			return nil
+		})
 	}
-	return nil
+	return g.Wait()
 }
@@ -17,7 +17,11 @@ type OCILayoutSink interface {
 	WriteFile(path string, data []byte, mode os.FileMode) error
 
 	// CopyFile copies a source file to the destination
-	CopyFile(dstPath, srcPath string, useSymlinks bool) error
+	// and reports the mechanism that was used.
+	CopyFile(dstPath, srcPath string, useSymlinks bool) (copyMethod, error)
+
+	// ConcurrentCopies reports whether CopyFile may be called concurrently
+	ConcurrentCopies() bool
 
 	// Close finalizes the sink
 	Close() error
@@ -43,11 +47,16 @@ func (d *DirectorySink) WriteFile(path string, data []byte, mode os.FileMode) er
 	return os.WriteFile(fullPath, data, mode)
 }
 
-func (d *DirectorySink) CopyFile(dstPath, srcPath string, useSymlinks bool) error {
+func (d *DirectorySink) CopyFile(dstPath, srcPath string, useSymlinks bool) (copyMethod, error) {
 	fullDstPath := filepath.Join(d.basePath, dstPath)
 	return copyFile(srcPath, fullDstPath, useSymlinks)
 }
 
+func (d *DirectorySink) ConcurrentCopies() bool {
+	// every blob is a separate file
+	return true
+}
+
 func (d *DirectorySink) Close() error {
 	// Nothing to close for directory sink
 	return nil
@@ -115,17 +124,17 @@ func (t *TarSink) WriteFile(path string, data []byte, mode os.FileMode) error {
 	return nil
 }
 
-func (t *TarSink) CopyFile(dstPath, srcPath string, useSymlinks bool) error {
+func (t *TarSink) CopyFile(dstPath, srcPath string, useSymlinks bool) (copyMethod, error) {
 	// For tar sink, we can't use symlinks, so we always copy the file content
 	srcFile, err := os.Open(srcPath)
 	if err != nil {
-		return fmt.Errorf("opening source file %s: %w", srcPath, err)
+		return "", fmt.Errorf("opening source file %s: %w", srcPath, err)
 	}
 	defer srcFile.Close()
 
 	srcInfo, err := srcFile.Stat()
 	if err != nil {
-		return fmt.Errorf("getting file info for %s: %w", srcPath, err)
+		return "", fmt.Errorf("getting file info for %s: %w", srcPath, err)
 	}
 
 	header := &tar.Header{
@@ -135,15 +144,20 @@ func (t *TarSink) CopyFile(dstPath, srcPath string, useSymlinks bool) error {
 	}
 
 	if err := t.writer.WriteHeader(header); err != nil {
-		return fmt.Errorf("writing tar header for %s: %w", dstPath, err)
+		return "", fmt.Errorf("writing tar header for %s: %w", dstPath, err)
 	}
 
 	_, err = io.Copy(t.writer, srcFile)
 	if err != nil {
-		return fmt.Errorf("copying file data to tar for %s: %w", dstPath, err)
+		return "", fmt.Errorf("copying file data to tar for %s: %w", dstPath, err)
 	}
 
-	return nil
+	return copyMethodTar, nil
+}
+
+func (t *TarSink) ConcurrentCopies() bool {
+	// entries of a tar file are written sequentially
+	return false
 }
 
 func (t *TarSink) Close() error {
@@ -0,0 +1,223 @@
+package pull
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// blobDownloadAttempts is the number of times a blob download is started
+// before giving up. Every attempt after the first resumes the download
+// where the previous attempt stopped.
+const blobDownloadAttempts = 5
+
+// blobFetcher downloads blobs from a repository.
+// Every blob is verified against its digest and size.
+// Interrupted downloads are resumed using HTTP range requests.
+type blobFetcher struct {
+	client *http.Client
+	repo   name.Repository
+}
+
+func newBlobFetcher(ctx context.Context, repo name.Repository) (*blobFetcher, error) {
+	auth, err := authn.Resolve(ctx, reg.MultiKeychain(), repo)
+	if err != nil {
+		return nil, fmt.Errorf("resolving credentials for %s: %w", repo, err)
+	}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, reg.BaseTransport(), []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to registry %s: %w", repo.RegistryStr(), err)
+	}
+	return &blobFetcher{
+		client: &http.Client{Transport: rt},
+		repo:   repo,
+	}, nil
+}
+
+// download writes the blob with the given digest and size to outputPath.
+// Data is written to a temporary file next to outputPath first,
+// which is renamed once the download is complete and verified.
+// A temporary file left behind by an earlier (interrupted) run is resumed.
+func (f *blobFetcher) download(ctx context.Context, digest registryv1.Hash, size int64, outputPath string) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm %s for blob %s", digest.Algorithm, digest)
+	}
+	if err := verifyFile(outputPath, digest, size); err == nil {
+		// already downloaded
+		return nil
+	}
+
+	partialPath := outputPath + ".partial"
+	out, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating blob file: %w", err)
+	}
+	defer out.Close()
+
+	// hash the data of earlier attempts
+	hasher := sha256.New()
+	offset, err := io.Copy(hasher, out)
+	if err != nil {
+		return fmt.Errorf("reading partial blob file: %w", err)
+	}
+	if offset > size {
+		// the partial file cannot be the prefix of this blob
+		if err := restart(out, hasher); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < blobDownloadAttempts && offset < size; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+			slog.Warn("Resuming download", "digest", digest, "offset", offset, "size", size, logging.ErrKey, lastErr)
+		}
+		offset, lastErr = f.fetchRange(ctx, digest, offset, out, hasher)
+		if lastErr != nil && !isRetryable(lastErr) {
+			break
+		}
+	}
+	if offset < size {
+		if lastErr == nil {
+			lastErr = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("downloading blob %s: %w", digest, lastErr)
+	}
+
+	if offset != size {
+		out.Close()
+		os.Remove(partialPath)
+		return fmt.Errorf("blob %s has unexpected size: expected %d bytes, got at least %d", digest, size, offset)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != digest.Hex {
+		out.Close()
+		os.Remove(partialPath)
+		return fmt.Errorf("blob digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing blob file: %w", err)
+	}
+	return os.Rename(partialPath, outputPath)
+}
+
+// errNotRetryable wraps errors that won't go away by trying again.
+type errNotRetryable struct{ error }
+
+func (e errNotRetryable) Unwrap() error { return e.error }
+
+func isRetryable(err error) bool {
+	var notRetryable errNotRetryable
+	return !errors.As(err, &notRetryable)
+}
+
+// fetchRange downloads the blob starting at offset and appends it to out.
+// It returns the number of bytes of the blob in out afterwards.
+func (f *blobFetcher) fetchRange(ctx context.Context, digest registryv1.Hash, offset int64, out *os.File, hasher hash.Hash) (int64, error) {
+	u := url.URL{
+		Scheme: f.repo.Registry.Scheme(),
+		Host:   f.repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", f.repo.RepositoryStr(), digest),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return offset, errNotRetryable{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the registry ignored the range request (or none was sent) and sends the whole blob
+		if err := restart(out, hasher); err != nil {
+			return 0, errNotRetryable{err}
+		}
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is longer than the blob
+		if err := restart(out, hasher); err != nil {
+			return 0, errNotRetryable{err}
+		}
+		return 0, fmt.Errorf("GET %s: %s", u.String(), resp.Status)
+	default:
+		err := transport.CheckError(resp, http.StatusOK, http.StatusPartialContent)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return offset, err
+		}
+		return offset, errNotRetryable{err}
+	}
+
+	n, err := io.Copy(io.MultiWriter(out, hasher), resp.Body)
+	return offset + n, err
+}
+
+// restart truncates the partial blob file and resets the hash.
+func restart(out *os.File, hasher hash.Hash) error {
+	if err := out.Truncate(0); err != nil {
+		return fmt.Errorf("truncating partial blob file: %w", err)
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("truncating partial blob file: %w", err)
+	}
+	hasher.Reset()
+	return nil
+}
+
+// verifyFile checks that the file at path has the given digest and size.
+func verifyFile(path string, digest registryv1.Hash, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, f)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != digest.Hex {
+		return fmt.Errorf("expected %s, got sha256:%s", digest, actual)
+	}
+	return nil
+}
+
+// verifyBlob checks that data has the given digest.
+func verifyBlob(data []byte, digest registryv1.Hash) error {
+	if actual := fmt.Sprintf("%x", sha256.Sum256(data)); digest.Algorithm != "sha256" || actual != digest.Hex {
+		return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+	return nil
+}
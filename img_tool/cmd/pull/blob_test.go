@@ -0,0 +1,255 @@
+package pull
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+)
+
+// blobServer serves a single blob and records the Range headers of the requests.
+type blobServer struct {
+	mu   sync.Mutex
+	data []byte
+	// cutAfter aborts the first response after this many bytes (0 disables this).
+	cutAfter int
+	// ignoreRange sends the whole blob for range requests.
+	ignoreRange bool
+	// statuses are answered (in order) before the blob is served.
+	statuses []int
+	ranges   []string
+}
+
+func (s *blobServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, req.Header.Get("Range"))
+	var status int
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	cutAfter := s.cutAfter
+	s.cutAfter = 0
+	s.mu.Unlock()
+
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	data := s.data
+	start, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if ok && !s.ignoreRange {
+		offset, err := strconv.Atoi(strings.TrimSuffix(start, "-"))
+		if err != nil || offset >= len(data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		data = data[offset:]
+		w.Header().Set("Content-Range", "bytes "+start+strconv.Itoa(len(s.data)-1)+"/"+strconv.Itoa(len(s.data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+	}
+	if cutAfter > 0 {
+		w.Write(data[:cutAfter])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(data)
+}
+
+func newTestBlobFetcher(t *testing.T, server *blobServer) *blobFetcher {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	repo, err := name.NewRepository(mustHost(t, httpServer.URL)+"/app", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blobFetcher{client: httpServer.Client(), repo: repo}
+}
+
+func testBlob() ([]byte, registryv1.Hash) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	sum := sha256.Sum256(data)
+	return data, registryv1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+func TestDownloadBlob(t *testing.T) {
+	data, digest := testBlob()
+	tests := []struct {
+		name       string
+		server     *blobServer
+		partial    []byte
+		wantRanges []string
+	}{
+		{
+			name:       "complete",
+			server:     &blobServer{},
+			wantRanges: []string{""},
+		},
+		{
+			name:       "resume interrupted download",
+			server:     &blobServer{cutAfter: 300},
+			wantRanges: []string{"", "bytes=300-"},
+		},
+		{
+			name:       "resume partial file of an earlier run",
+			server:     &blobServer{},
+			partial:    data[:400],
+			wantRanges: []string{"bytes=400-"},
+		},
+		{
+			name:       "registry ignores range requests",
+			server:     &blobServer{ignoreRange: true},
+			partial:    data[:400],
+			wantRanges: []string{"bytes=400-"},
+		},
+		{
+			name:       "partial file longer than the blob",
+			server:     &blobServer{},
+			partial:    append(bytes.Clone(data), "trailing garbage"...),
+			wantRanges: []string{""},
+		},
+		{
+			name:       "server error is retried",
+			server:     &blobServer{statuses: []int{http.StatusServiceUnavailable}},
+			wantRanges: []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			server.data = data
+			fetcher := newTestBlobFetcher(t, server)
+			outputPath := filepath.Join(t.TempDir(), "blob")
+			if tt.partial != nil {
+				if err := os.WriteFile(outputPath+".partial", tt.partial, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := fetcher.download(context.Background(), digest, int64(len(data)), outputPath); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("downloaded blob has %d bytes and differs from the served blob", len(got))
+			}
+			if _, err := os.Stat(outputPath + ".partial"); !os.IsNotExist(err) {
+				t.Errorf("partial file was not renamed: %v", err)
+			}
+			if strings.Join(server.ranges, ",") != strings.Join(tt.wantRanges, ",") {
+				t.Errorf("Range headers = %q, want %q", server.ranges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestDownloadBlobAlreadyDownloaded(t *testing.T) {
+	data, digest := testBlob()
+	server := &blobServer{data: data}
+	fetcher := newTestBlobFetcher(t, server)
+	outputPath := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(outputPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetcher.download(context.Background(), digest, int64(len(data)), outputPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.ranges) != 0 {
+		t.Errorf("verified blob was downloaded again")
+	}
+}
+
+func TestDownloadBlobErrors(t *testing.T) {
+	data, digest := testBlob()
+	corrupted := bytes.Clone(data)
+	corrupted[500] = 'x'
+	tests := []struct {
+		name         string
+		server       *blobServer
+		digest       registryv1.Hash
+		size         int64
+		want         string
+		wantRequests int
+	}{
+		{
+			name:         "digest mismatch",
+			server:       &blobServer{data: corrupted},
+			want:         "blob digest mismatch: expected " + digest.String(),
+			wantRequests: 1,
+		},
+		{
+			name:         "blob larger than expected",
+			server:       &blobServer{data: data},
+			size:         int64(len(data)) - 1,
+			want:         "has unexpected size: expected 999 bytes, got at least 1000",
+			wantRequests: 1,
+		},
+		{
+			name:         "blob not found is not retried",
+			server:       &blobServer{statuses: []int{http.StatusNotFound}},
+			want:         "downloading blob " + digest.String(),
+			wantRequests: 1,
+		},
+		{
+			name:   "unsupported digest algorithm",
+			server: &blobServer{},
+			digest: registryv1.Hash{Algorithm: "sha512", Hex: strings.Repeat("0", 128)},
+			want:   "unsupported digest algorithm sha512",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			fetcher := newTestBlobFetcher(t, server)
+			outputPath := filepath.Join(t.TempDir(), "blob")
+			if tt.digest.Algorithm == "" {
+				tt.digest = digest
+			}
+			if tt.size == 0 {
+				tt.size = int64(len(data))
+			}
+
+			err := fetcher.download(context.Background(), tt.digest, tt.size, outputPath)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("download() error = %v, want %q", err, tt.want)
+			}
+			if len(server.ranges) != tt.wantRequests {
+				t.Errorf("%d requests, want %d", len(server.ranges), tt.wantRequests)
+			}
+			if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+				t.Errorf("blob was written despite the error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyBlob(t *testing.T) {
+	data, digest := testBlob()
+	if err := verifyBlob(data, digest); err != nil {
+		t.Errorf("verifyBlob() of matching data: %v", err)
+	}
+	if err := verifyBlob(data[1:], digest); err == nil || !strings.HasPrefix(err.Error(), "digest mismatch: expected "+digest.String()) {
+		t.Errorf("verifyBlob() of other data: error = %v", err)
+	}
+	if err := verifyBlob(data, registryv1.Hash{Algorithm: "sha512", Hex: digest.Hex}); err == nil {
+		t.Errorf("verifyBlob() accepted a sha512 digest")
+	}
+}
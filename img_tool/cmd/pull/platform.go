@@ -0,0 +1,60 @@
+package pull
+
+import (
+	"fmt"
+	"strings"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+)
+
+// platformFilter selects the manifests of an index that should be pulled.
+// An empty filter selects all manifests.
+type platformFilter []registryv1.Platform
+
+// parsePlatforms parses a comma-separated list of platforms like "linux/amd64,linux/arm64/v8".
+func parsePlatforms(value string) (platformFilter, error) {
+	var filter platformFilter
+	for _, platform := range strings.Split(value, ",") {
+		platform = strings.TrimSpace(platform)
+		if platform == "" {
+			continue
+		}
+		parts := strings.Split(platform, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q: expected os/architecture[/variant]", platform)
+		}
+		p := registryv1.Platform{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			p.Variant = parts[2]
+		}
+		filter = append(filter, p)
+	}
+	return filter, nil
+}
+
+// matches reports whether the platform of a manifest is selected by the filter.
+// Manifests without a platform (like attestations) only match an empty filter.
+func (f platformFilter) matches(platform *registryv1.Platform) bool {
+	if len(f) == 0 {
+		return true
+	}
+	if platform == nil {
+		return false
+	}
+	for _, want := range f {
+		if platform.OS == want.OS &&
+			platform.Architecture == want.Architecture &&
+			(want.Variant == "" || platform.Variant == want.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f platformFilter) String() string {
+	platforms := make([]string, len(f))
+	for i, p := range f {
+		platforms[i] = p.String()
+	}
+	return strings.Join(platforms, ",")
+}
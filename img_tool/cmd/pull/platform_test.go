@@ -0,0 +1,154 @@
+package pull
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/empty"
+	"github.com/malt3/go-containerregistry/pkg/v1/mutate"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	filter, err := parsePlatforms("linux/amd64, linux/arm64/v8,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := platformFilter{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("parsePlatforms() = %v, want %v", filter, want)
+	}
+	if filter.String() != "linux/amd64,linux/arm64/v8" {
+		t.Errorf("String() = %q", filter.String())
+	}
+	if filter, err := parsePlatforms(""); err != nil || filter != nil {
+		t.Errorf("parsePlatforms(\"\") = %v, %v, want an empty filter", filter, err)
+	}
+
+	for _, value := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, err := parsePlatforms(value); err == nil || !strings.Contains(err.Error(), "expected os/architecture[/variant]") {
+			t.Errorf("parsePlatforms(%q) error = %v", value, err)
+		}
+	}
+}
+
+func TestPlatformFilterMatches(t *testing.T) {
+	filter := platformFilter{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}
+	tests := []struct {
+		platform *registryv1.Platform
+		want     bool
+	}{
+		{platform: &registryv1.Platform{OS: "linux", Architecture: "amd64"}, want: true},
+		{platform: &registryv1.Platform{OS: "linux", Architecture: "amd64", Variant: "v3"}, want: true},
+		{platform: &registryv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, want: true},
+		{platform: &registryv1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, want: false},
+		{platform: &registryv1.Platform{OS: "windows", Architecture: "amd64"}, want: false},
+		{platform: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := filter.matches(tt.platform); got != tt.want {
+			t.Errorf("matches(%v) = %v, want %v", tt.platform, got, tt.want)
+		}
+	}
+	if !(platformFilter{}).matches(nil) {
+		t.Errorf("empty filter does not match a manifest without platform")
+	}
+}
+
+func TestPullIndexPlatforms(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := mustHost(t, server.URL)
+
+	images := make(map[string]registryv1.Image)
+	index := mutate.IndexMediaType(empty.Index, "application/vnd.oci.image.index.v1+json")
+	for _, platform := range []registryv1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images[platform.String()] = img
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: registryv1.Descriptor{Platform: &platform},
+		})
+	}
+	if err := remote.WriteIndex(mustParseReference(t, host+"/app:v1"), index); err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := index.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputDir, "blobs", "sha256"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	platforms, err := parsePlatforms("linux/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pullFromRegistry(context.Background(), host, "app", indexDigest.String(), indexDigest.String(), outputDir, "eager", platforms, 2); err != nil {
+		t.Fatal(err)
+	}
+	for platform, img := range images {
+		wantPulled := platform == "linux/arm64/v8"
+		for _, blob := range imageBlobs(t, img) {
+			_, err := os.Stat(blobPath(outputDir, blob.String()))
+			if pulled := err == nil; pulled != wantPulled {
+				t.Errorf("blob %s of %s pulled = %v, want %v", blob, platform, pulled, wantPulled)
+			}
+		}
+	}
+
+	platforms, err = parsePlatforms("windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(emptyDir, "blobs", "sha256"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err = pullFromRegistry(context.Background(), host, "app", indexDigest.String(), indexDigest.String(), emptyDir, "shallow", platforms, 2)
+	if err == nil || !strings.Contains(err.Error(), "no manifest in index matches platform(s) windows/amd64") {
+		t.Errorf("pulling a platform that is not in the index: error = %v", err)
+	}
+}
+
+// imageBlobs returns the digests of the manifest, config and layers of img.
+func imageBlobs(t *testing.T, img registryv1.Image) []registryv1.Hash {
+	t.Helper()
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := []registryv1.Hash{imgDigest, manifest.Config.Digest}
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+	return blobs
+}
@@ -0,0 +1,88 @@
+package pull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+)
+
+var (
+	errUnauthorized      = errors.New("authentication failed")
+	errRepositoryUnknown = errors.New("repository not found")
+	errManifestUnknown   = errors.New("manifest not found")
+)
+
+// prefetchBackoff keeps the existence check short, so that a missing image
+// does not cause long retry loops across mirrored registries.
+var prefetchBackoff = remote.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+	Steps:    2,
+}
+
+// prefetchManifest verifies that the requested manifest exists in the registry
+// using a HEAD request before any data is downloaded.
+// Failures are classified as authentication errors (errUnauthorized),
+// a missing or mistyped repository (errRepositoryUnknown),
+// or a missing tag or digest within an existing repository (errManifestUnknown).
+func prefetchManifest(ctx context.Context, ref name.Reference, opts ...remote.Option) error {
+	opts = append(opts, remote.WithContext(ctx), remote.WithRetryBackoff(prefetchBackoff))
+	_, err := remote.Head(ref, opts...)
+	if err == nil {
+		return nil
+	}
+	return classifyRegistryError(ctx, ref, err, opts...)
+}
+
+func classifyRegistryError(ctx context.Context, ref name.Reference, err error, opts ...remote.Option) error {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return fmt.Errorf("checking %s: %w", ref, err)
+	}
+	for _, diagnostic := range transportErr.Errors {
+		switch diagnostic.Code {
+		case transport.NameUnknownErrorCode:
+			return repositoryUnknown(ref)
+		case transport.ManifestUnknownErrorCode:
+			return manifestUnknown(ref)
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return unauthorized(ref, transportErr.StatusCode)
+		}
+	}
+	switch transportErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return unauthorized(ref, transportErr.StatusCode)
+	case http.StatusNotFound:
+		// Responses to HEAD requests have no body, so we cannot tell from the error code
+		// whether the repository or only the manifest is missing.
+		// Listing the tags of the repository tells them apart.
+		_, listErr := remote.ListWithContext(ctx, ref.Context(), opts...)
+		var listTransportErr *transport.Error
+		if errors.As(listErr, &listTransportErr) && listTransportErr.StatusCode == http.StatusNotFound {
+			return repositoryUnknown(ref)
+		}
+		return manifestUnknown(ref)
+	}
+	return fmt.Errorf("checking %s: %w", ref, err)
+}
+
+func unauthorized(ref name.Reference, statusCode int) error {
+	return fmt.Errorf("%w for %s\n%s", errUnauthorized, ref.Context(), reg.Diagnosis(ref.Context().RegistryStr(), statusCode))
+}
+
+func repositoryUnknown(ref name.Reference) error {
+	return fmt.Errorf("%w: %s does not exist in %s, check the repository name for typos", errRepositoryUnknown, ref.Context().RepositoryStr(), ref.Context().RegistryStr())
+}
+
+func manifestUnknown(ref name.Reference) error {
+	return fmt.Errorf("%w: %s does not exist in %s", errManifestUnknown, ref.Identifier(), ref.Context())
+}
@@ -0,0 +1,87 @@
+package pull
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPrefetchManifest(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := mustHost(t, server.URL)
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(mustParseReference(t, host+"/app:v1"), img); err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref     string
+		wantErr error
+	}{
+		{ref: host + "/app:v1"},
+		{ref: host + "/app@" + imgDigest.String()},
+		{ref: host + "/app:v2", wantErr: errManifestUnknown},
+		{ref: host + "/typo:v1", wantErr: errRepositoryUnknown},
+	}
+	for _, tt := range tests {
+		err := prefetchManifest(context.Background(), mustParseReference(t, tt.ref))
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+			t.Errorf("prefetchManifest(%s) error = %v, want %v", tt.ref, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPrefetchManifestUnauthorized(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/v2/" {
+				// anonymous access to the API root, like registries that only protect repositories
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.WriteHeader(status)
+		}))
+		ref := mustParseReference(t, mustHost(t, server.URL)+"/private:latest")
+		if err := prefetchManifest(context.Background(), ref); !errors.Is(err, errUnauthorized) {
+			t.Errorf("prefetchManifest() with status %d: error = %v, want %v", status, err, errUnauthorized)
+		}
+		server.Close()
+	}
+}
+
+func mustHost(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func mustParseReference(t *testing.T, s string) name.Reference {
+	t.Helper()
+	ref, err := name.ParseReference(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
@@ -3,8 +3,10 @@ package pull
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +17,8 @@ import (
 	"github.com/malt3/go-containerregistry/pkg/v1/remote"
 
 	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/registriesconf"
 )
 
 func PullProcess(ctx context.Context, args []string) {
@@ -22,8 +26,15 @@ func PullProcess(ctx context.Context, args []string) {
 	var repository string
 	var outputDir string
 	var registries stringSliceFlag
+	var mirrors stringSliceFlag
+	var mirrorFallback bool
 	var layerHandling string
 	var concurrency int
+	var platforms string
+	var verifyTag string
+	var tagMovedHint string
+	var tlsOptions reg.TLSOptions
+	var registriesConfOptions registriesconf.Options
 
 	flagSet := flag.NewFlagSet("pull", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -33,6 +44,9 @@ func PullProcess(ctx context.Context, args []string) {
 		examples := []string{
 			"img pull --reference sha256:abc123... --repository myapp --output ./outdir",
 			"img pull --reference sha256:abc123... --repository myapp --registry docker.io",
+			"img pull --reference sha256:abc123... --repository library/ubuntu --mirror artifactory.example.com/docker-remote --registry index.docker.io",
+			"img pull --reference sha256:abc123... --repository library/ubuntu --platform linux/amd64,linux/arm64 --layer-handling eager",
+			"img pull --reference sha256:abc123... --repository library/ubuntu --registry index.docker.io --verify-tag 24.04",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -44,36 +58,61 @@ func PullProcess(ctx context.Context, args []string) {
 	flagSet.StringVar(&repository, "repository", "", "Repository name of the image (required)")
 	flagSet.StringVar(&outputDir, "output", ".", "Output directory to save the downloaded image to")
 	flagSet.Var(&registries, "registry", "Registry to use (can be specified multiple times, defaults to docker.io)")
+	flagSet.Var(&mirrors, "mirror", `Mirror or pull-through cache to try before the registries, in the form host[/prefix] (can be specified multiple times). The image is pulled from <host>/<prefix>/<repository>. Credentials are looked up for the host of the mirror.`)
+	flagSet.BoolVar(&mirrorFallback, "mirror-fallback", true, "Fall back to the registries if the image cannot be pulled from any mirror. If false, only mirrors are used (unless no mirror is configured).")
 	flagSet.StringVar(&layerHandling, "layer-handling", "shallow", "Method used for handling layer data. \"eager\" causes layer data to be materialized.")
 	flagSet.IntVar(&concurrency, "j", 10, "Number of concurrent download workers")
+	flagSet.StringVar(&platforms, "platform", "", "Comma-separated list of platforms to pull from an image index (e.g., linux/amd64,linux/arm64). Only the manifests, configs and (with eager layer handling) layers of these platforms are downloaded. If not set, all platforms are pulled.")
+	flagSet.StringVar(&verifyTag, "verify-tag", "", "Tag that is expected to point to the digest of --reference. Fails with the current digest if the tag moved.")
+	flagSet.StringVar(&tagMovedHint, "tag-moved-hint", "", "Command printed if the tag of --verify-tag moved, to pin the current digest. {digest} is replaced by the current digest. Defaults to an \"img lock\" command.")
+	tlsOptions.RegisterFlags(flagSet)
+	registriesConfOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
 		os.Exit(1)
 	}
+	registriesConf, err := registriesConfOptions.Load()
+	if err != nil {
+		logging.Fatal("Loading registries configuration", logging.ErrKey, err)
+	}
+	registriesConf.ConfigureTLS(&tlsOptions)
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
 
 	if reference == "" {
-		fmt.Fprintf(os.Stderr, "Error: --reference is required\n")
+		slog.Error("--reference is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if repository == "" {
-		fmt.Fprintf(os.Stderr, "Error: --repository is required\n")
+		slog.Error("--repository is required")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 	if outputDir == "" {
-		fmt.Fprintf(os.Stderr, "Error: --output must be a valid path\n")
+		slog.Error("--output must be a valid path")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	if err := os.MkdirAll(filepath.Join(outputDir, "blobs", "sha256"), 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
+	selectedPlatforms, err := parsePlatforms(platforms)
+	if err != nil {
+		slog.Error("Invalid --platform", logging.ErrKey, err)
+		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	// Default to docker.io if no registries specified
+	if err := os.MkdirAll(filepath.Join(outputDir, "blobs", "sha256"), 0o755); err != nil {
+		logging.Fatal("Creating output directory", logging.ErrKey, err)
+	}
+
+	// Default to the search registries of registries.conf or docker.io if no registries specified
+	if len(registries) == 0 {
+		registries = registriesConf.Qualify()
+	}
 	if len(registries) == 0 {
 		registries = []string{"docker.io"}
 	}
@@ -82,20 +121,107 @@ func PullProcess(ctx context.Context, args []string) {
 	if strings.HasPrefix(reference, "sha256:") {
 		digest = reference
 	}
+	if verifyTag != "" && digest == "" {
+		slog.Error("--verify-tag requires a digest as --reference")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	sources, err := pullSources(mirrors, registries, repository, mirrorFallback, digest != "", registriesConf)
+	if err != nil {
+		logging.Fatal("Invalid --mirror", logging.ErrKey, err)
+	}
+	if len(sources) == 0 {
+		logging.Fatal("No registry to pull from", "repository", repository)
+	}
 
-	// Try each registry until success
+	// Try each mirror and registry until success
 	var lastErr error
-	for _, registry := range registries {
-		err := pullFromRegistry(ctx, registry, repository, reference, digest, outputDir, layerHandling, concurrency)
+	notFoundEverywhere := true
+	var tried []string
+	for _, source := range sources {
+		if verifyTag != "" {
+			err := checkTag(ctx, source.registry, source.repository, verifyTag, digest, tagMovedHint)
+			var moved *tagMovedError
+			if errors.As(err, &moved) {
+				// the registry answered, so there is no point in trying other sources
+				logging.Fatal("Verifying tag", logging.ErrKey, err)
+			}
+			if err != nil {
+				lastErr = err
+				notFoundEverywhere = false
+				tried = append(tried, source.String())
+				slog.Warn("Failed to verify tag", "source", source, logging.ErrKey, err)
+				continue
+			}
+		}
+		err := pullFromRegistry(ctx, source.registry, source.repository, reference, digest, outputDir, layerHandling, selectedPlatforms, concurrency)
 		if err == nil {
 			return
 		}
 		lastErr = err
-		fmt.Fprintf(os.Stderr, "Failed to download from %s: %v\n", registry, err)
+		if !errors.Is(err, errManifestUnknown) {
+			notFoundEverywhere = false
+		}
+		tried = append(tried, source.String())
+		slog.Warn("Failed to download", "source", source, logging.ErrKey, err)
+	}
+
+	if notFoundEverywhere {
+		logging.Fatal("Image does not exist in any registry", "reference", reference, "repository", repository, "tried", strings.Join(tried, ", "))
 	}
+	logging.Fatal("Failed to download image from all registries", logging.ErrKey, reg.Diagnose(lastErr))
+}
 
-	fmt.Fprintf(os.Stderr, "Error: Failed to download blob from all registries: %v\n", lastErr)
-	os.Exit(1)
+// pullSource is a registry (or mirror) and the repository of the image in it.
+type pullSource struct {
+	registry   string
+	repository string
+}
+
+func (s pullSource) String() string {
+	return s.registry + "/" + s.repository
+}
+
+// pullSources returns the mirrors followed by the registries (unless mirrorFallback is false)
+// in the order they are tried.
+func pullSources(mirrors, registries []string, repository string, mirrorFallback, byDigest bool, registriesConf *registriesconf.Config) ([]pullSource, error) {
+	sources := make([]pullSource, 0, len(mirrors)+len(registries))
+	for _, mirror := range mirrors {
+		source, err := mirrorSource(mirror, repository)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	if !mirrorFallback && len(mirrors) > 0 {
+		return sources, nil
+	}
+	for _, registry := range registries {
+		// registries.conf may add mirrors, rewrite the location or block the registry.
+		registrySources, err := registriesConf.PullSources(registry, repository, byDigest)
+		if err != nil {
+			slog.Warn("Skipping registry", "registry", registry, logging.ErrKey, err)
+			continue
+		}
+		for _, source := range registrySources {
+			sources = append(sources, pullSource{registry: source.Registry, repository: source.Repository})
+		}
+	}
+	return sources, nil
+}
+
+// mirrorSource parses a mirror of the form host[/prefix].
+// Mirrors (like pull-through caches) often serve upstream repositories below a prefix.
+func mirrorSource(mirror, repository string) (pullSource, error) {
+	host, prefix, _ := strings.Cut(strings.Trim(mirror, "/"), "/")
+	if host == "" {
+		return pullSource{}, fmt.Errorf("invalid mirror %q: expected host[/prefix]", mirror)
+	}
+	if prefix != "" {
+		repository = prefix + "/" + repository
+	}
+	return pullSource{registry: host, repository: repository}, nil
 }
 
 type downloadJob struct {
@@ -108,14 +234,16 @@ type workerPool struct {
 	results chan error
 	wg      *sync.WaitGroup
 	ctx     context.Context
+	fetcher *blobFetcher
 }
 
-func newWorkerPool(ctx context.Context, numWorkers int) *workerPool {
+func newWorkerPool(ctx context.Context, numWorkers int, fetcher *blobFetcher) *workerPool {
 	return &workerPool{
 		jobs:    make(chan downloadJob, numWorkers*2),
 		results: make(chan error, numWorkers*2),
 		wg:      &sync.WaitGroup{},
 		ctx:     ctx,
+		fetcher: fetcher,
 	}
 }
 
@@ -134,7 +262,7 @@ func (wp *workerPool) worker() {
 			wp.results <- wp.ctx.Err()
 			return
 		default:
-			err := downloadLayer(job.layer, job.outputDir)
+			err := downloadLayer(wp.ctx, wp.fetcher, job.layer, job.outputDir)
 			wp.results <- err
 		}
 	}
@@ -153,13 +281,21 @@ func (wp *workerPool) wait() {
 	close(wp.results)
 }
 
-func pullFromRegistry(ctx context.Context, registry, repository, tag, digest, outputDir, layerHandling string, concurrency int) error {
+func pullFromRegistry(ctx context.Context, registry, repository, tag, digest, outputDir, layerHandling string, platforms platformFilter, concurrency int) error {
 	sha256sum := strings.TrimPrefix(digest, "sha256:")
 	manifestFilename := filepath.Join(outputDir, "manifest.json")
 	if len(sha256sum) > 0 {
 		manifestFilename = filepath.Join(outputDir, "blobs", "sha256", sha256sum)
 	}
-	desc, err := downloadManifest(registry, repository, tag, digest, manifestFilename)
+	ref, err := manifestReference(registry, repository, tag, digest)
+	if err != nil {
+		return err
+	}
+	// Fail fast with a specific error if the image cannot be found or accessed.
+	if err := prefetchManifest(ctx, ref, reg.WithAuthFromMultiKeychain()); err != nil {
+		return err
+	}
+	desc, err := downloadManifest(ref, digest, manifestFilename)
 	if err != nil {
 		return fmt.Errorf("downloading manifest: %w", err)
 	}
@@ -178,7 +314,7 @@ func pullFromRegistry(ctx context.Context, registry, repository, tag, digest, ou
 		if err != nil {
 			return fmt.Errorf("getting index from descriptor: %w", err)
 		}
-		layers, err = downloadIndex(ctx, index, outputDir, concurrency)
+		layers, err = downloadIndex(ctx, index, outputDir, platforms, concurrency)
 		if err != nil {
 			return fmt.Errorf("downloading index: %w", err)
 		}
@@ -189,7 +325,11 @@ func pullFromRegistry(ctx context.Context, registry, repository, tag, digest, ou
 		return nil
 	}
 
-	pool := newWorkerPool(ctx, concurrency)
+	fetcher, err := newBlobFetcher(ctx, ref.Context())
+	if err != nil {
+		return err
+	}
+	pool := newWorkerPool(ctx, concurrency, fetcher)
 	pool.start(concurrency)
 
 	var errors []error
@@ -208,7 +348,18 @@ func pullFromRegistry(ctx context.Context, registry, repository, tag, digest, ou
 		}
 	}()
 
+	seen := make(map[registryv1.Hash]struct{})
 	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			pool.results <- fmt.Errorf("getting layer digest: %w", err)
+			continue
+		}
+		if _, ok := seen[digest]; ok {
+			// images of an index often share layers
+			continue
+		}
+		seen[digest] = struct{}{}
 		pool.submit(downloadJob{layer: layer, outputDir: outputDir})
 	}
 
@@ -234,18 +385,29 @@ type manifestResult struct {
 	err    error
 }
 
-func downloadIndex(ctx context.Context, index registryv1.ImageIndex, outputDir string, concurrency int) ([]registryv1.Layer, error) {
-	manifests, err := index.IndexManifest()
+func downloadIndex(ctx context.Context, index registryv1.ImageIndex, outputDir string, platforms platformFilter, concurrency int) ([]registryv1.Layer, error) {
+	indexManifest, err := index.IndexManifest()
 	if err != nil {
 		return nil, fmt.Errorf("getting index manifest: %w", err)
 	}
 
-	jobs := make(chan manifestJob, len(manifests.Manifests))
-	results := make(chan manifestResult, len(manifests.Manifests))
+	// positions of the selected manifests in the index
+	var selected []int
+	for i, desc := range indexManifest.Manifests {
+		if platforms.matches(desc.Platform) {
+			selected = append(selected, i)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no manifest in index matches platform(s) %s", platforms)
+	}
+
+	jobs := make(chan manifestJob, len(selected))
+	results := make(chan manifestResult, len(selected))
 
 	numWorkers := concurrency
-	if numWorkers > len(manifests.Manifests) {
-		numWorkers = len(manifests.Manifests)
+	if numWorkers > len(selected) {
+		numWorkers = len(selected)
 	}
 
 	var wg sync.WaitGroup
@@ -266,8 +428,8 @@ func downloadIndex(ctx context.Context, index registryv1.ImageIndex, outputDir s
 		}()
 	}
 
-	for i, desc := range manifests.Manifests {
-		jobs <- manifestJob{index: index, desc: desc, outputDir: outputDir, i: i}
+	for _, i := range selected {
+		jobs <- manifestJob{index: index, desc: indexManifest.Manifests[i], outputDir: outputDir, i: i}
 	}
 	close(jobs)
 
@@ -296,6 +458,9 @@ func processManifest(index registryv1.ImageIndex, desc registryv1.Descriptor, ou
 	if err != nil {
 		return nil, fmt.Errorf("getting image %d from index: %w", i, err)
 	}
+	if err := verifyBlob(manifestBytes, desc.Digest); err != nil {
+		return nil, fmt.Errorf("verifying image manifest %d: %w", i, err)
+	}
 	manifestPath := blobPath(outputDir, desc.Digest.Hex)
 	if err := os.WriteFile(manifestPath, manifestBytes, 0o755); err != nil {
 		return nil, fmt.Errorf("writing image manifest %d: %w", i, err)
@@ -317,6 +482,9 @@ func downloadImage(image registryv1.Image, outputDir string) ([]registryv1.Layer
 	if err != nil {
 		return nil, fmt.Errorf("getting config digest: %w", err)
 	}
+	if err := verifyBlob(rawConfig, configHash); err != nil {
+		return nil, fmt.Errorf("verifying config file: %w", err)
+	}
 	configPath := blobPath(outputDir, configHash.Hex)
 	if err := os.WriteFile(configPath, rawConfig, 0o644); err != nil {
 		return nil, fmt.Errorf("writing config file: %w", err)
@@ -325,47 +493,34 @@ func downloadImage(image registryv1.Image, outputDir string) ([]registryv1.Layer
 	return image.Layers()
 }
 
-func downloadLayer(layer registryv1.Layer, outputDir string) error {
+func downloadLayer(ctx context.Context, fetcher *blobFetcher, layer registryv1.Layer, outputDir string) error {
 	digest, err := layer.Digest()
 	if err != nil {
 		return fmt.Errorf("getting layer digest: %w", err)
 	}
-	layerPath := blobPath(outputDir, digest.Hex)
-	rc, err := layer.Compressed()
+	size, err := layer.Size()
 	if err != nil {
-		return fmt.Errorf("getting compressed layer: %w", err)
-	}
-	defer rc.Close()
-
-	f, err := os.Create(layerPath)
-	if err != nil {
-		return fmt.Errorf("creating layer file: %w", err)
-	}
-	defer f.Close()
-
-	if _, err := f.ReadFrom(rc); err != nil {
-		return fmt.Errorf("writing layer file: %w", err)
+		return fmt.Errorf("getting layer size: %w", err)
 	}
-
-	return nil
+	return fetcher.download(ctx, digest, size, blobPath(outputDir, digest.Hex))
 }
 
-func downloadManifest(registry, repository, tag, digest, outputPath string) (*remote.Descriptor, error) {
-	var ref name.Reference
+func manifestReference(registry, repository, tag, digest string) (name.Reference, error) {
 	if len(digest) > 0 {
-		var err error
-		ref, err = name.NewDigest(fmt.Sprintf("%s/%s@%s", registry, repository, digest))
+		ref, err := name.NewDigest(fmt.Sprintf("%s/%s@%s", registry, repository, digest))
 		if err != nil {
 			return nil, fmt.Errorf("creating manifest reference with digest: %w", err)
 		}
-	} else {
-		var err error
-		ref, err = name.NewTag(fmt.Sprintf("%s/%s:%s", registry, repository, tag))
-		if err != nil {
-			return nil, fmt.Errorf("creating manifest reference: %w", err)
-		}
+		return ref, nil
+	}
+	ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", registry, repository, tag))
+	if err != nil {
+		return nil, fmt.Errorf("creating manifest reference: %w", err)
 	}
+	return ref, nil
+}
 
+func downloadManifest(ref name.Reference, digest, outputPath string) (*remote.Descriptor, error) {
 	desc, err := remote.Get(ref, reg.WithAuthFromMultiKeychain())
 	if err != nil {
 		return nil, fmt.Errorf("getting manifest: %w", err)
@@ -373,7 +528,7 @@ func downloadManifest(registry, repository, tag, digest, outputPath string) (*re
 
 	if len(digest) == 0 {
 		digest = desc.Descriptor.Digest.String()
-		fmt.Fprintf(os.Stderr, "Missing valid image digest. Observed the following digest when pulling manifest for %s:\n    %s\n", ref.String(), digest)
+		slog.Error("Missing valid image digest, observed a digest when pulling the manifest", "reference", ref, "digest", digest)
 		return nil, fmt.Errorf("missing valid digest, please specify the digest explicitly")
 	}
 
@@ -0,0 +1,163 @@
+package pull
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/registriesconf"
+)
+
+func TestMirrorSource(t *testing.T) {
+	tests := []struct {
+		mirror  string
+		want    string
+		wantErr bool
+	}{
+		{mirror: "mirror.example.com", want: "mirror.example.com/library/ubuntu"},
+		{mirror: "mirror.example.com:5000/docker-remote", want: "mirror.example.com:5000/docker-remote/library/ubuntu"},
+		{mirror: "mirror.example.com/a/b/", want: "mirror.example.com/a/b/library/ubuntu"},
+		{mirror: "/", wantErr: true},
+		{mirror: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		source, err := mirrorSource(tt.mirror, "library/ubuntu")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mirrorSource(%q) error = %v, wantErr %v", tt.mirror, err, tt.wantErr)
+			continue
+		}
+		if err == nil && source.String() != tt.want {
+			t.Errorf("mirrorSource(%q) = %s, want %s", tt.mirror, source, tt.want)
+		}
+	}
+}
+
+func TestPullSources(t *testing.T) {
+	confPath := filepath.Join(t.TempDir(), "registries.conf")
+	conf := `
+[[registry]]
+prefix = "blocked.example.com"
+blocked = true
+`
+	if err := os.WriteFile(confPath, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registriesConf, err := registriesconf.Load(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		mirrors        []string
+		registries     []string
+		mirrorFallback bool
+		want           []string
+	}{
+		{
+			name:           "mirrors before registries",
+			mirrors:        []string{"mirror.example.com/hub", "cache.example.com"},
+			registries:     []string{"index.docker.io", "ghcr.io"},
+			mirrorFallback: true,
+			want:           []string{"mirror.example.com/hub/app", "cache.example.com/app", "index.docker.io/app", "ghcr.io/app"},
+		},
+		{
+			name:       "mirrors only",
+			mirrors:    []string{"mirror.example.com/hub"},
+			registries: []string{"index.docker.io"},
+			want:       []string{"mirror.example.com/hub/app"},
+		},
+		{
+			name:       "no fallback without mirrors",
+			registries: []string{"index.docker.io"},
+			want:       []string{"index.docker.io/app"},
+		},
+		{
+			name:           "blocked registry is skipped",
+			registries:     []string{"blocked.example.com", "ghcr.io"},
+			mirrorFallback: true,
+			want:           []string{"ghcr.io/app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := pullSources(tt.mirrors, tt.registries, "app", tt.mirrorFallback, true, registriesConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, source := range sources {
+				got = append(got, source.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pullSources() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := pullSources([]string{"/"}, []string{"index.docker.io"}, "app", true, true, nil); err == nil || !strings.Contains(err.Error(), `invalid mirror "/"`) {
+		t.Errorf("pullSources() with invalid mirror: error = %v", err)
+	}
+}
+
+func TestPullFromMirrorWithPrefix(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := mustHost(t, server.URL)
+
+	// the mirror serves the upstream repository library/app below the prefix docker-remote
+	img, err := random.Image(64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(mustParseReference(t, host+"/docker-remote/library/app:v1"), img); err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := mirrorSource(host+"/docker-remote", "library/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(outputDir, "blobs", "sha256"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := pullFromRegistry(context.Background(), source.registry, source.repository, imgDigest.String(), imgDigest.String(), outputDir, "eager", nil, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range layers {
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(blobPath(outputDir, layerDigest.String())); err != nil {
+			t.Errorf("layer %s was not pulled from the mirror: %v", layerDigest, err)
+		}
+	}
+
+	// without the prefix, the mirror does not have the image
+	err = pullFromRegistry(context.Background(), host, "library/app", imgDigest.String(), imgDigest.String(), t.TempDir(), "shallow", nil, 2)
+	if !errors.Is(err, errRepositoryUnknown) {
+		t.Errorf("pulling from the mirror without prefix: error = %v, want %v", err, errRepositoryUnknown)
+	}
+}
@@ -0,0 +1,58 @@
+package pull
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+)
+
+// tagMovedError is returned if a tag no longer points to the expected digest.
+type tagMovedError struct {
+	tag      string
+	expected string
+	current  string
+	// hint is a command (or instruction) that pins the current digest.
+	hint string
+}
+
+func (e *tagMovedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tag %s moved:\n", e.tag)
+	fmt.Fprintf(&b, "  - expected digest: %s\n", e.expected)
+	fmt.Fprintf(&b, "  + current digest:  %s\n", e.current)
+	fmt.Fprintf(&b, "If the new image is expected, pin the current digest:\n")
+	fmt.Fprintf(&b, "  %s", e.hint)
+	return b.String()
+}
+
+// checkTag resolves the tag in the registry and compares it to the expected digest.
+// If the tag moved, a *tagMovedError with the current digest is returned.
+// An empty hint defaults to the "img lock" command that updates a lock file.
+func checkTag(ctx context.Context, registry, repository, tag, expected, hint string) error {
+	ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", registry, repository, tag))
+	if err != nil {
+		return fmt.Errorf("creating tag reference: %w", err)
+	}
+	desc, err := remote.Head(ref, reg.WithAuthFromMultiKeychain(), remote.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("resolving tag %s: %w", ref, err)
+	}
+	current := desc.Digest.String()
+	if current == expected {
+		return nil
+	}
+	if hint == "" {
+		hint = fmt.Sprintf("img lock --update --image %s <lock_file>", ref)
+	}
+	return &tagMovedError{
+		tag:      ref.String(),
+		expected: expected,
+		current:  current,
+		hint:     strings.ReplaceAll(hint, "{digest}", current),
+	}
+}
@@ -0,0 +1,88 @@
+package pull
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+func TestCheckTag(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := mustHost(t, server.URL)
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(mustParseReference(t, host+"/library/app:v1"), img); err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pinned = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	if err := checkTag(context.Background(), host, "library/app", "v1", imgDigest.String(), ""); err != nil {
+		t.Errorf("checkTag() of an unchanged tag: error = %v, want nil", err)
+	}
+
+	tests := []struct {
+		name     string
+		hint     string
+		wantHint string
+	}{
+		{
+			name:     "default hint",
+			wantHint: "img lock --update --image " + host + "/library/app:v1 <lock_file>",
+		},
+		{
+			name:     "custom hint with digest",
+			hint:     `set digest = "{digest}" in MODULE.bazel`,
+			wantHint: `set digest = "` + imgDigest.String() + `" in MODULE.bazel`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTag(context.Background(), host, "library/app", "v1", pinned, tt.hint)
+			var moved *tagMovedError
+			if !errors.As(err, &moved) {
+				t.Fatalf("checkTag() error = %v, want a *tagMovedError", err)
+			}
+			if moved.current != imgDigest.String() || moved.expected != pinned {
+				t.Errorf("checkTag() = %+v, want current %s and expected %s", moved, imgDigest, pinned)
+			}
+			msg := err.Error()
+			for _, want := range []string{
+				"tag " + host + "/library/app:v1 moved:",
+				"  - expected digest: " + pinned,
+				"  + current digest:  " + imgDigest.String(),
+				"  " + tt.wantHint,
+			} {
+				if !strings.Contains(msg, want) {
+					t.Errorf("checkTag() error = %q, want it to contain %q", msg, want)
+				}
+			}
+		})
+	}
+
+	// a missing tag is an error of the registry, not a moved tag
+	err = checkTag(context.Background(), host, "library/app", "missing", pinned, "")
+	var moved *tagMovedError
+	if err == nil || errors.As(err, &moved) {
+		t.Errorf("checkTag() of a missing tag: error = %v, want a registry error", err)
+	}
+
+	if err := checkTag(context.Background(), host, "library/app", "not a tag", pinned, ""); err == nil || !strings.Contains(err.Error(), "creating tag reference") {
+		t.Errorf("checkTag() of an invalid tag: error = %v, want an invalid reference", err)
+	}
+}
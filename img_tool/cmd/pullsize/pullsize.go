@@ -0,0 +1,136 @@
+package pullsize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/pullsize"
+)
+
+// ErrBudgetExceeded is returned by Run if the estimated pull size is larger than Config.MaxPullSize.
+var ErrBudgetExceeded = errors.New("estimated pull size exceeds the budget")
+
+// Config holds the options of a pull size estimation.
+type Config struct {
+	// Previous is the path of the deploy manifest of the previous release.
+	Previous string
+	// Current is the path of the deploy manifest of the new build.
+	Current string
+	// MarkdownOutput is the path of the Markdown report (optional).
+	MarkdownOutput string
+	// JSONOutput is the path of the JSON report (optional).
+	JSONOutput string
+	// MaxPullSize fails the estimation if more bytes need to be pulled. Zero means no limit.
+	MaxPullSize int64
+}
+
+// Runner estimates the pull size of a deploy manifest.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func PullSizeProcess(ctx context.Context, args []string) {
+	var cfg Config
+
+	flagSet := flag.NewFlagSet("pull-size", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Estimates how many bytes a node that runs the previous release needs to pull to deploy the new build.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img pull-size [OPTIONS]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img pull-size --previous release.json --current push.json --output report.md",
+			"img pull-size --previous release.json --current push.json --json-output report.json --max-pull-size 104857600",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&cfg.Previous, "previous", "", "Deploy manifest of the previous release (required)")
+	flagSet.StringVar(&cfg.Current, "current", "", "Deploy manifest of the new build (required)")
+	flagSet.StringVar(&cfg.MarkdownOutput, "output", "", "Output file path for the Markdown report")
+	flagSet.StringVar(&cfg.JSONOutput, "json-output", "", "Output file path for the JSON report")
+	flagSet.Int64Var(&cfg.MaxPullSize, "max-pull-size", 0, "Fail if the estimated pull size in bytes is larger than this value (0 means no limit). The reports are written anyway.")
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if cfg.Previous == "" || cfg.Current == "" {
+		slog.Error("--previous and --current are required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Estimating pull size", logging.ErrKey, err)
+	}
+}
+
+// Run writes the reports and checks the budget.
+func (r *Runner) Run(_ context.Context) error {
+	previous, err := readDeployManifest(r.cfg.Previous)
+	if err != nil {
+		return err
+	}
+	current, err := readDeployManifest(r.cfg.Current)
+	if err != nil {
+		return err
+	}
+	estimate, err := pullsize.Calculate(previous, current)
+	if err != nil {
+		return err
+	}
+
+	if r.cfg.MarkdownOutput != "" {
+		out, err := os.Create(r.cfg.MarkdownOutput)
+		if err != nil {
+			return fmt.Errorf("creating report: %w", err)
+		}
+		defer out.Close()
+		if err := pullsize.WriteMarkdown(out, estimate); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+	if r.cfg.JSONOutput != "" {
+		raw, err := json.MarshalIndent(estimate, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling report: %w", err)
+		}
+		if err := os.WriteFile(r.cfg.JSONOutput, append(raw, '\n'), 0o644); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+
+	if r.cfg.MaxPullSize > 0 && estimate.PullSize > r.cfg.MaxPullSize {
+		return fmt.Errorf("%w: %s (%d bytes) > %s (%d bytes)", ErrBudgetExceeded,
+			humansize.Format(estimate.PullSize), estimate.PullSize, humansize.Format(r.cfg.MaxPullSize), r.cfg.MaxPullSize)
+	}
+	return nil
+}
+
+func readDeployManifest(path string) (api.DeployManifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return api.DeployManifest{}, fmt.Errorf("reading deploy manifest: %w", err)
+	}
+	var manifest api.DeployManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return api.DeployManifest{}, fmt.Errorf("parsing deploy manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,114 @@
+package push
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/explore"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// CatProcess writes a single file of the image of a push or load target (or of a list of layers) to stdout.
+func CatProcess(ctx context.Context, args []string) {
+	var deployManifestPath string
+	var runfilesDir string
+	var operation int
+	var platform string
+	var layers stringSliceFlag
+	var tlsOptions registry.TLSOptions
+
+	flagSet := flag.NewFlagSet("cat", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Writes a file of the image of a push or load target (or of the given layers) to stdout, without loading the image into a container runtime.\n")
+		fmt.Fprintf(flagSet.Output(), "The layers are merged like in a container (respecting their order and whiteouts) and symlinks are followed. Only the tar headers are read, except for the layer that provides the file.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img cat [OPTIONS] [RUNFILES_DIR] PATH\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img cat bazel-bin/push.runfiles /etc/os-release",
+			"img cat --platform linux/arm64 bazel-bin/push.runfiles /app/config.json",
+			"img cat --layer base.tgz --layer app.tgz /app/config.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&deployManifestPath, "deploy-manifest", "", "Deploy manifest of the push or load target. Defaults to dispatch.json in the runfiles.")
+	flagSet.StringVar(&runfilesDir, "runfiles-dir", "", "Runfiles directory of the push or load target (like bazel-bin/push.runfiles). Can also be given as argument.")
+	flagSet.IntVar(&operation, "operation", 0, "Index of the push or load operation of the deploy manifest (for multi_deploy targets).")
+	flagSet.StringVar(&platform, "platform", "", "Platform of the image if the target is an index (like linux/arm64). Defaults to the first image.")
+	flagSet.Var(&layers, "layer", "Layer blob (tar, optionally compressed) to read instead of the image of a push or load target. Can be specified multiple times, from the lowest to the highest layer.")
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	switch {
+	case flagSet.NArg() == 2 && runfilesDir == "" && len(layers) == 0:
+		runfilesDir = flagSet.Arg(0)
+	case flagSet.NArg() != 1:
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	filePath := flagSet.Arg(flagSet.NArg() - 1)
+
+	var img *explore.Image
+	var err error
+	if len(layers) > 0 {
+		img, err = loadLayerFiles(ctx, layers)
+	} else {
+		if err := registry.ConfigureTransport(tlsOptions); err != nil {
+			logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+		}
+		if err := useRunfilesDir(runfilesDir); err != nil {
+			logging.Fatal("Invalid runfiles directory", logging.ErrKey, err)
+		}
+		img, err = loadExploredImage(ctx, deployManifestPath, operation, platform)
+	}
+	if err != nil {
+		logging.Fatal("Loading image", logging.ErrKey, err)
+	}
+
+	if err := catFile(img, filePath, os.Stdout); err != nil {
+		logging.Fatal("Reading file", logging.ErrKey, err)
+	}
+}
+
+func catFile(img *explore.Image, filePath string, w io.Writer) error {
+	node, err := img.Resolve(filePath)
+	if err != nil {
+		return err
+	}
+	slog.Info("File is provided by layer", "path", node.Path, "layer", node.Layer, "digest", img.Layers[node.Layer].Digest)
+	rc, err := img.Open(node)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// loadLayerFiles merges layer blobs from the local filesystem.
+func loadLayerFiles(ctx context.Context, layers []string) (*explore.Image, error) {
+	var sources []explore.LayerSource
+	for _, layer := range layers {
+		info, err := os.Stat(layer)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, explore.LayerSource{
+			Digest: layer,
+			Size:   info.Size(),
+			Open:   func() (io.ReadCloser, error) { return os.Open(layer) },
+		})
+	}
+	return explore.Load(ctx, sources)
+}
@@ -0,0 +1,171 @@
+package push
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/deployvfs"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/explore"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// ExploreProcess browses the merged filesystem of an image of a push or load target.
+func ExploreProcess(ctx context.Context, args []string) {
+	var deployManifestPath string
+	var runfilesDir string
+	var operation int
+	var platform string
+	var printTree bool
+	var depth int
+	var tlsOptions registry.TLSOptions
+
+	flagSet := flag.NewFlagSet("explore", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Browses the layers and the merged filesystem of the image of a push or load target: per-directory sizes, which layer provides each path, and hardlinks (deduplicated files).\n")
+		fmt.Fprintf(flagSet.Output(), "Only the tar headers of the layers are read, nothing is unpacked.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img explore [OPTIONS] [RUNFILES_DIR]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img explore bazel-bin/push.runfiles",
+			"img explore --platform linux/arm64 bazel-bin/push.runfiles",
+			"img explore --print --depth 2 bazel-bin/push.runfiles",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&deployManifestPath, "deploy-manifest", "", "Deploy manifest of the push or load target. Defaults to dispatch.json in the runfiles.")
+	flagSet.StringVar(&runfilesDir, "runfiles-dir", "", "Runfiles directory of the push or load target (like bazel-bin/push.runfiles). Can also be given as argument.")
+	flagSet.IntVar(&operation, "operation", 0, "Index of the push or load operation of the deploy manifest to explore (for multi_deploy targets).")
+	flagSet.StringVar(&platform, "platform", "", "Platform of the image to explore if the target is an index (like linux/arm64). Defaults to the first image.")
+	flagSet.BoolVar(&printTree, "print", false, "Print the layers and the directory tree instead of starting the interactive explorer. Used automatically if stdin or stdout is not a terminal.")
+	flagSet.IntVar(&depth, "depth", -1, "Maximum directory depth printed with --print. -1 prints the whole tree.")
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() > 1 || (flagSet.NArg() == 1 && runfilesDir != "") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() == 1 {
+		runfilesDir = flagSet.Arg(0)
+	}
+	if err := registry.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	if err := useRunfilesDir(runfilesDir); err != nil {
+		logging.Fatal("Invalid runfiles directory", logging.ErrKey, err)
+	}
+
+	img, err := loadExploredImage(ctx, deployManifestPath, operation, platform)
+	if err != nil {
+		logging.Fatal("Loading image", logging.ErrKey, err)
+	}
+	if printTree || !explore.IsTerminal(int(os.Stdin.Fd())) || !explore.IsTerminal(int(os.Stdout.Fd())) {
+		explore.Print(os.Stdout, img, img.Root, depth)
+		return
+	}
+	if err := explore.Run(img, os.Stdin, os.Stdout); err != nil {
+		logging.Fatal("Exploring image", logging.ErrKey, err)
+	}
+}
+
+func loadExploredImage(ctx context.Context, deployManifestPath string, operation int, platform string) (*explore.Image, error) {
+	req, vfs, err := loadDeployVFS(deployManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := req.BaseOperations()
+	if err != nil {
+		return nil, err
+	}
+	if operation < 0 || operation >= len(ops) {
+		return nil, fmt.Errorf("operation %d does not exist, the deploy manifest has %d operation(s)", operation, len(ops))
+	}
+	op := ops[operation]
+	manifest, err := selectManifest(vfs, op, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []explore.LayerSource
+	for _, layer := range manifest.LayerBlobs {
+		digest, err := registryv1.NewHash(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if location, err := vfs.Location(digest); err == nil && location == "stub" {
+			return nil, fmt.Errorf("layer %s is only available in the remote CAS and cannot be explored", layer.Digest)
+		}
+		blob, err := vfs.Layer(digest)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, explore.LayerSource{
+			Digest: layer.Digest,
+			Size:   layer.Size,
+			Open:   func() (io.ReadCloser, error) { return blob.Compressed() },
+		})
+	}
+	slog.Info("Reading layers", "count", len(sources), "manifest", manifest.Descriptor.Digest)
+	return explore.Load(ctx, sources)
+}
+
+// selectManifest returns the manifest of the operation for the platform (or the first manifest).
+func selectManifest(vfs *deployvfs.VFS, op api.BaseCommandOperation, platform string) (api.ManifestDeployInfo, error) {
+	if len(op.Manifests) == 0 {
+		return api.ManifestDeployInfo{}, fmt.Errorf("operation has no images")
+	}
+	if platform == "" {
+		return op.Manifests[0], nil
+	}
+	if op.RootKind != "index" {
+		return api.ManifestDeployInfo{}, fmt.Errorf("--platform requires an image index, but the operation pushes a single image")
+	}
+	rootDigest, err := registryv1.NewHash(op.Root.Digest)
+	if err != nil {
+		return api.ManifestDeployInfo{}, err
+	}
+	index, err := vfs.ImageIndex(rootDigest)
+	if err != nil {
+		return api.ManifestDeployInfo{}, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return api.ManifestDeployInfo{}, err
+	}
+	var available []string
+	for _, desc := range indexManifest.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		descPlatform := desc.Platform.OS + "/" + desc.Platform.Architecture
+		if desc.Platform.Variant != "" {
+			descPlatform += "/" + desc.Platform.Variant
+		}
+		available = append(available, descPlatform)
+		if descPlatform != platform && desc.Platform.OS+"/"+desc.Platform.Architecture != platform {
+			continue
+		}
+		for _, manifest := range op.Manifests {
+			if manifest.Descriptor.Digest == desc.Digest.String() {
+				return manifest, nil
+			}
+		}
+	}
+	return api.ManifestDeployInfo{}, fmt.Errorf("no image for platform %s (available: %s)", platform, strings.Join(available, ", "))
+}
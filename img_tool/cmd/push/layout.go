@@ -0,0 +1,375 @@
+package push
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/types"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/deployvfs"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/push"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/registriesconf"
+)
+
+// PushLayoutProcess pushes an image or index of an OCI layout directory (or tar) that was not built by rules_img.
+func PushLayoutProcess(ctx context.Context, args []string) {
+	var repository string
+	var tags stringSliceFlag
+	var refName string
+	var digest string
+	var manifestFormat string
+	var checkCapabilities bool
+	var checkExistingBlobs bool
+	var chunkSize int64
+	var jobs int
+	var tlsOptions registry.TLSOptions
+	var registriesConfOptions registriesconf.Options
+	var output resultOutput
+
+	flagSet := flag.NewFlagSet("push-layout", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Pushes the image or index of an OCI layout directory or tar (like the output of docker buildx --output type=oci) to a registry.\n")
+		fmt.Fprintf(flagSet.Output(), "The push uses the same credentials, existing blob checks and concurrent uploads as push targets.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img push-layout [OPTIONS] --repository REGISTRY/REPOSITORY LAYOUT\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img push-layout --repository ghcr.io/my-org/my-image --tag latest ./layout",
+			"img push-layout --repository ghcr.io/my-org/my-image --tag v1.2.3 image.tar",
+			"img push-layout --repository ghcr.io/my-org/my-image --ref-name v1.2.3 --tag v1.2.3 ./layout",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&repository, "repository", "", "Repository to push to, including the registry (like ghcr.io/my-org/my-image). Required.")
+	flagSet.Var(&tags, "tag", "Tag to apply (can be used multiple times). The image is always pushed by digest.")
+	flagSet.Var(&tags, "t", "Tag to apply (can be used multiple times). The image is always pushed by digest.")
+	flagSet.StringVar(&refName, "ref-name", "", "Push the manifest of index.json with this org.opencontainers.image.ref.name annotation. Required if index.json lists more than one manifest (unless --digest is given).")
+	flagSet.StringVar(&digest, "digest", "", "Push the manifest of index.json with this digest.")
+	flagSet.StringVar(&manifestFormat, "manifest-format", "", `Rewrite the media types of pushed manifests, configs, and layers to "docker" (Docker schema 2) or "oci". Defaults to pushing manifests unchanged.`)
+	flagSet.BoolVar(&checkCapabilities, "check-capabilities", true, "Query the target registry before pushing and print a summary of its capabilities.")
+	flagSet.BoolVar(&checkExistingBlobs, "check-existing-blobs", true, "Check which layers already exist in the target repository with parallel HEAD requests before uploading, and only upload the missing layers.")
+	flagSet.Int64Var(&chunkSize, "chunk-size", 0, "Upload layers larger than this many bytes in chunks and resume interrupted uploads. 0 uploads every blob in a single request.")
+	flagSet.IntVar(&jobs, "jobs", jobsFromEnv(), fmt.Sprintf("Maximum number of blobs uploaded concurrently (env: %s, 0 uses %d)", jobsEnv, push.DefaultJobs))
+	flagSet.StringVar(&output.format, "output-format", "text", `Format of the result: "text" prints the pushed references, one per line. "json" writes a structured result.`)
+	flagSet.StringVar(&output.path, "output-file", "", "Write the result to this file instead of stdout.")
+	tlsOptions.RegisterFlags(flagSet)
+	registriesConfOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+	}
+	if flagSet.NArg() != 1 || repository == "" {
+		flagSet.Usage()
+	}
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		logging.Fatal("Invalid --repository", logging.ErrKey, err)
+	}
+	format, err := push.ParseManifestFormat(manifestFormat)
+	if err != nil {
+		logging.Fatal("Invalid --manifest-format", logging.ErrKey, err)
+	}
+	if output.format != "text" && output.format != "json" {
+		logging.Fatal(`Invalid --output-format, must be "text" or "json"`, "output_format", output.format)
+	}
+	registriesConf, err := registriesConfOptions.Load()
+	if err != nil {
+		logging.Fatal("Loading registries configuration", logging.ErrKey, err)
+	}
+	if err := registriesConf.CheckPush(repo.RegistryStr(), repo.RepositoryStr()); err != nil {
+		logging.Fatal("Push rejected", logging.ErrKey, err)
+	}
+	registriesConf.ConfigureTLS(&tlsOptions)
+	if err := registry.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+
+	layoutDir, cleanup, err := openLayout(flagSet.Arg(0))
+	if err != nil {
+		logging.Fatal("Opening OCI layout", logging.ErrKey, err)
+	}
+	defer cleanup()
+
+	op, err := layoutPushOperation(layoutDir, refName, digest)
+	if err != nil {
+		cleanup()
+		logging.Fatal("Reading OCI layout", logging.ErrKey, err)
+	}
+	op.Registry = repo.RegistryStr()
+	op.Repository = repo.RepositoryStr()
+	op.Tags = tags
+
+	if err := pushLayout(ctx, layoutDir, op, format, checkCapabilities, checkExistingBlobs, chunkSize, jobs, output); err != nil {
+		cleanup()
+		logging.Fatal("Push failed", logging.ErrKey, registry.Diagnose(err))
+	}
+}
+
+// pushLayout pushes a single operation whose blobs are read from an OCI layout directory.
+func pushLayout(ctx context.Context, layoutDir string, op api.PushDeployOperation, manifestFormat push.ManifestFormat, checkCapabilities, checkExistingBlobs bool, chunkSize int64, jobs int, output resultOutput) error {
+	result := deployResult{StartTime: time.Now().UTC(), Loaded: []loadResult{}}
+	rawOp, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	dm := api.DeployManifest{
+		Operations: []json.RawMessage{rawOp},
+		Settings:   api.DeploySettings{PushStrategy: "eager"},
+	}
+	vfs, err := deployvfs.Builder(dm).WithLayout(layoutDir).Build()
+	if err != nil {
+		return fmt.Errorf("locating blobs in OCI layout: %w", err)
+	}
+	pushOperations, err := dm.PushOperations()
+	if err != nil {
+		return err
+	}
+
+	uploadBuilder := push.NewBuilder(vfs).WithJobs(jobs)
+	if manifestFormat != push.ManifestFormatUnchanged {
+		uploadBuilder = uploadBuilder.WithManifestFormat(manifestFormat)
+	}
+	if checkCapabilities {
+		uploadBuilder = uploadBuilder.WithCapabilityCheck(registry.MultiKeychain())
+	}
+	if checkExistingBlobs {
+		uploadBuilder = uploadBuilder.WithExistingBlobCheck(registry.MultiKeychain())
+	}
+	if chunkSize > 0 {
+		uploadBuilder = uploadBuilder.WithChunkedUpload(chunkSize, registry.MultiKeychain())
+	}
+	uploader := uploadBuilder.WithRemoteOptions(registry.WithAuthFromMultiKeychain()).Build()
+	pushedTags, err := uploader.PushAll(ctx, pushOperations, "eager")
+	if err != nil {
+		return fmt.Errorf("pushing images: %w", err)
+	}
+	if output.format == "json" {
+		result.Pushed = uploader.Results()
+		result.DurationSeconds = time.Since(result.StartTime).Seconds()
+		return output.write(result)
+	}
+	return output.write(pushedTags)
+}
+
+// layoutPushOperation describes the push of the manifest of index.json selected by refName or digest
+// (or the only manifest). Indexes are pushed with all their image manifests.
+func layoutPushOperation(layoutDir, refName, digest string) (api.PushDeployOperation, error) {
+	var index specv1.Index
+	if err := readLayoutJSON(filepath.Join(layoutDir, "index.json"), &index); err != nil {
+		return api.PushDeployOperation{}, err
+	}
+	root, err := selectLayoutManifest(index.Manifests, refName, digest)
+	if err != nil {
+		return api.PushDeployOperation{}, err
+	}
+
+	op := api.PushDeployOperation{
+		BaseCommandOperation: api.BaseCommandOperation{
+			Command: "push",
+			Root:    apiDescriptor(root),
+		},
+	}
+	switch root.MediaType {
+	case specv1.MediaTypeImageIndex, string(types.DockerManifestList):
+		op.RootKind = "index"
+		var rootIndex specv1.Index
+		if err := readLayoutBlob(layoutDir, root, &rootIndex); err != nil {
+			return api.PushDeployOperation{}, err
+		}
+		for _, desc := range rootIndex.Manifests {
+			if desc.MediaType == specv1.MediaTypeImageIndex || desc.MediaType == string(types.DockerManifestList) {
+				return api.PushDeployOperation{}, fmt.Errorf("index %s contains the nested index %s, which is not supported", root.Digest, desc.Digest)
+			}
+			manifest, err := layoutManifestInfo(layoutDir, desc)
+			if err != nil {
+				return api.PushDeployOperation{}, err
+			}
+			op.Manifests = append(op.Manifests, manifest)
+		}
+	case specv1.MediaTypeImageManifest, string(types.DockerManifestSchema2):
+		op.RootKind = "manifest"
+		manifest, err := layoutManifestInfo(layoutDir, root)
+		if err != nil {
+			return api.PushDeployOperation{}, err
+		}
+		op.Manifests = []api.ManifestDeployInfo{manifest}
+	default:
+		return api.PushDeployOperation{}, fmt.Errorf("unsupported media type %q of %s", root.MediaType, root.Digest)
+	}
+	return op, nil
+}
+
+func selectLayoutManifest(manifests []specv1.Descriptor, refName, digest string) (specv1.Descriptor, error) {
+	var candidates []specv1.Descriptor
+	for _, desc := range manifests {
+		if refName != "" && desc.Annotations[specv1.AnnotationRefName] != refName {
+			continue
+		}
+		if digest != "" && desc.Digest.String() != digest {
+			continue
+		}
+		candidates = append(candidates, desc)
+	}
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case len(manifests) == 0:
+		return specv1.Descriptor{}, errors.New("index.json lists no manifests")
+	case len(candidates) == 0:
+		return specv1.Descriptor{}, fmt.Errorf("no manifest of index.json matches (available: %s)", describeLayoutManifests(manifests))
+	}
+	return specv1.Descriptor{}, fmt.Errorf("index.json lists %d manifests, select one with --ref-name or --digest (available: %s)", len(candidates), describeLayoutManifests(candidates))
+}
+
+func describeLayoutManifests(manifests []specv1.Descriptor) string {
+	var descriptions []string
+	for _, desc := range manifests {
+		if refName := desc.Annotations[specv1.AnnotationRefName]; refName != "" {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", refName, desc.Digest))
+		} else {
+			descriptions = append(descriptions, desc.Digest.String())
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func layoutManifestInfo(layoutDir string, desc specv1.Descriptor) (api.ManifestDeployInfo, error) {
+	var manifest specv1.Manifest
+	if err := readLayoutBlob(layoutDir, desc, &manifest); err != nil {
+		return api.ManifestDeployInfo{}, err
+	}
+	info := api.ManifestDeployInfo{
+		Descriptor: apiDescriptor(desc),
+		Config:     apiDescriptor(manifest.Config),
+	}
+	for _, layer := range manifest.Layers {
+		info.LayerBlobs = append(info.LayerBlobs, apiDescriptor(layer))
+	}
+	return info, nil
+}
+
+func apiDescriptor(desc specv1.Descriptor) api.Descriptor {
+	return api.Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      desc.Digest.String(),
+		Size:        desc.Size,
+		Annotations: desc.Annotations,
+		URLs:        desc.URLs,
+	}
+}
+
+func readLayoutBlob(layoutDir string, desc specv1.Descriptor, v any) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", desc.Digest, err)
+	}
+	return readLayoutJSON(filepath.Join(layoutDir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()), v)
+}
+
+func readLayoutJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// openLayout returns the directory of an OCI layout.
+// Tars (optionally gzip compressed) are extracted to a temporary directory, which is removed by cleanup.
+func openLayout(path string) (dir string, cleanup func(), err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return path, func() {}, nil
+	}
+	dir, err = os.MkdirTemp("", "img-push-layout-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	if err := extractLayoutTar(path, dir); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("extracting %s: %w", path, err)
+	}
+	return dir, cleanup, nil
+}
+
+func extractLayoutTar(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if target == dir {
+			continue
+		}
+		if !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("tar entry %q is outside of the layout", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
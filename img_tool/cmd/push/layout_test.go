@@ -0,0 +1,330 @@
+package push
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/empty"
+	"github.com/malt3/go-containerregistry/pkg/v1/layout"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/push"
+)
+
+// writeLayout writes an OCI layout with an image (ref name "app") and an index of two images (ref name "multi").
+func writeLayout(t *testing.T) (string, registryv1.Image, registryv1.ImageIndex) {
+	t.Helper()
+	dir := t.TempDir()
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := path.AppendImage(img, layout.WithAnnotations(map[string]string{specv1.AnnotationRefName: "app"})); err != nil {
+		t.Fatal(err)
+	}
+	index, err := random.Index(128, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := path.AppendIndex(index, layout.WithAnnotations(map[string]string{specv1.AnnotationRefName: "multi"})); err != nil {
+		t.Fatal(err)
+	}
+	return dir, img, index
+}
+
+func digestOf(t *testing.T, v interface {
+	Digest() (registryv1.Hash, error)
+}) string {
+	t.Helper()
+	digest, err := v.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest.String()
+}
+
+func TestLayoutPushOperation(t *testing.T) {
+	dir, img, index := writeLayout(t)
+
+	op, err := layoutPushOperation(dir, "app", "")
+	if err != nil {
+		t.Fatalf("layoutPushOperation(app) error = %v", err)
+	}
+	if op.RootKind != "manifest" || op.Root.Digest != digestOf(t, img) || len(op.Manifests) != 1 || len(op.Manifests[0].LayerBlobs) != 2 {
+		t.Errorf("layoutPushOperation(app) = %+v, want the image with 2 layers", op)
+	}
+
+	op, err = layoutPushOperation(dir, "", digestOf(t, index))
+	if err != nil {
+		t.Fatalf("layoutPushOperation(digest of index) error = %v", err)
+	}
+	if op.RootKind != "index" || op.Root.Digest != digestOf(t, index) || len(op.Manifests) != 2 {
+		t.Errorf("layoutPushOperation(digest of index) = %+v, want the index with 2 manifests", op)
+	}
+	if op.Root.Annotations[specv1.AnnotationRefName] != "multi" {
+		t.Errorf("root annotations = %v, want the ref name of index.json", op.Root.Annotations)
+	}
+}
+
+// writeIndexJSON writes the index.json of a layout to dir.
+func writeIndexJSON(t *testing.T, dir string, manifests ...specv1.Descriptor) string {
+	t.Helper()
+	raw, err := json.Marshal(specv1.Index{Manifests: manifests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeLayoutBlob writes data to the blobs directory of a layout and returns its descriptor.
+func writeLayoutBlob(t *testing.T, dir, mediaType, data string) specv1.Descriptor {
+	t.Helper()
+	hash, size, err := registryv1.SHA256(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobDir := filepath.Join(dir, "blobs", hash.Algorithm)
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobDir, hash.Hex), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return specv1.Descriptor{MediaType: mediaType, Digest: digest.Digest(hash.String()), Size: size}
+}
+
+func TestLayoutPushOperationErrors(t *testing.T) {
+	dir, _, _ := writeLayout(t)
+	nestedDir := t.TempDir()
+	nestedDigest := "sha256:" + strings.Repeat("b", 64)
+	nested := writeLayoutBlob(t, nestedDir, specv1.MediaTypeImageIndex, `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"`+nestedDigest+`","size":1}]}`)
+	writeIndexJSON(t, nestedDir, nested)
+
+	tests := []struct {
+		name    string
+		dir     string
+		refName string
+		digest  string
+		wantErr string
+	}{
+		{name: "ambiguous", dir: dir, wantErr: "index.json lists 2 manifests, select one with --ref-name or --digest (available: app (sha256:"},
+		{name: "unknown ref name", dir: dir, refName: "other", wantErr: "no manifest of index.json matches (available: app (sha256:"},
+		{name: "unknown digest", dir: dir, digest: "sha256:" + strings.Repeat("0", 64), wantErr: "no manifest of index.json matches"},
+		{name: "empty index", dir: writeIndexJSON(t, t.TempDir()), wantErr: "index.json lists no manifests"},
+		{name: "missing index", dir: t.TempDir(), wantErr: "index.json: no such file or directory"},
+		{name: "unsupported media type", dir: writeIndexJSON(t, t.TempDir(), specv1.Descriptor{MediaType: "application/octet-stream", Digest: digest.Digest(nestedDigest)}), wantErr: `unsupported media type "application/octet-stream"`},
+		{name: "invalid digest", dir: writeIndexJSON(t, t.TempDir(), specv1.Descriptor{MediaType: specv1.MediaTypeImageManifest, Digest: "sha256:xyz"}), wantErr: `invalid digest "sha256:xyz"`},
+		{name: "missing manifest", dir: writeIndexJSON(t, t.TempDir(), specv1.Descriptor{MediaType: specv1.MediaTypeImageManifest, Digest: digest.Digest(nestedDigest)}), wantErr: "no such file or directory"},
+		{name: "nested index", dir: nestedDir, wantErr: "contains the nested index " + nestedDigest + ", which is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := layoutPushOperation(tt.dir, tt.refName, tt.digest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("layoutPushOperation() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPushLayout(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	dir, _, index := writeLayout(t)
+
+	op, err := layoutPushOperation(dir, "multi", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.Registry = host
+	op.Repository = "third-party/app"
+	op.Tags = []string{"v1"}
+	output := resultOutput{format: "json", path: filepath.Join(t.TempDir(), "result.json")}
+	if err := pushLayout(context.Background(), dir, op, push.ManifestFormatUnchanged, false, false, 0, 1, output); err != nil {
+		t.Fatalf("pushLayout() error = %v", err)
+	}
+
+	ref, err := name.ParseReference(host + "/third-party/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := remote.Index(ref)
+	if err != nil {
+		t.Fatalf("pulling pushed index: %v", err)
+	}
+	if got, want := digestOf(t, pushed), digestOf(t, index); got != want {
+		t.Errorf("pushed index digest = %s, want %s", got, want)
+	}
+	manifest, err := pushed.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range manifest.Manifests {
+		if _, err := remote.Image(ref.Context().Digest(desc.Digest.String())); err != nil {
+			t.Errorf("pulling pushed image %s: %v", desc.Digest, err)
+		}
+	}
+
+	raw, err := os.ReadFile(output.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Pushed []push.Result `json:"pushed"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("parsing result: %v", err)
+	}
+	if len(result.Pushed) != 1 || result.Pushed[0].Digest != digestOf(t, index) || result.Pushed[0].Repository != "third-party/app" {
+		t.Errorf("result = %s, want the pushed index", raw)
+	}
+}
+
+func TestPushLayoutMissingBlob(t *testing.T) {
+	dir, img, _ := writeLayout(t)
+	op, err := layoutPushOperation(dir, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := op.Manifests[0].LayerBlobs[0].Digest
+	hash, err := registryv1.NewHash(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "blobs", hash.Algorithm, hash.Hex)); err != nil {
+		t.Fatal(err)
+	}
+	op.Registry = "127.0.0.1:1"
+	op.Repository = "app"
+	err = pushLayout(context.Background(), dir, op, push.ManifestFormatUnchanged, false, false, 0, 1, resultOutput{format: "text"})
+	if err == nil || !strings.Contains(err.Error(), "layer not found in OCI layout") {
+		t.Errorf("pushLayout() of %s without layer error = %v, want layer not found", digestOf(t, img), err)
+	}
+}
+
+// tarLayout packs a layout directory into a tar (gzip compressed if compress is set).
+func tarLayout(t *testing.T, dir string, compress bool, extra ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "layout.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: filepath.ToSlash(rel) + "/", Mode: 0o755})
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: filepath.ToSlash(rel), Mode: 0o644, Size: int64(len(data))}); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range extra {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestOpenLayout(t *testing.T) {
+	dir, img, _ := writeLayout(t)
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{name: "directory", path: dir},
+		{name: "tar", path: tarLayout(t, dir, false)},
+		{name: "tar.gz", path: tarLayout(t, dir, true)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			layoutDir, cleanup, err := openLayout(tt.path)
+			if err != nil {
+				t.Fatalf("openLayout() error = %v", err)
+			}
+			op, err := layoutPushOperation(layoutDir, "app", "")
+			if err != nil || op.Root.Digest != digestOf(t, img) {
+				t.Errorf("layoutPushOperation() of opened layout = %s, %v, want %s", op.Root.Digest, err, digestOf(t, img))
+			}
+			cleanup()
+			if tt.path != dir {
+				if _, err := os.Stat(layoutDir); !os.IsNotExist(err) {
+					t.Errorf("cleanup() kept %s", layoutDir)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenLayoutErrors(t *testing.T) {
+	dir, _, _ := writeLayout(t)
+	notTar := filepath.Join(t.TempDir(), "layout.tar")
+	if err := os.WriteFile(notTar, []byte("not a tar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing"), wantErr: "no such file or directory"},
+		{name: "outside of layout", path: tarLayout(t, dir, false, "../escape"), wantErr: `tar entry "../escape" is outside of the layout`},
+		{name: "not a tar", path: notTar, wantErr: "extracting " + notTar},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := openLayout(tt.path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openLayout() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
@@ -21,8 +24,10 @@ import (
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/deployvfs"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/load"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/proto/blobcache"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/push"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/registriesconf"
 )
 
 func PushProcess(ctx context.Context, args []string) {
@@ -35,22 +40,67 @@ func DeployDispatch(ctx context.Context, rawRequest []byte) {
 	var overrideRegistry string
 	var overrideRepository string
 	var platforms string
+	var dryRun bool
+	var manifestFormat string
+	var checkCapabilities bool
+	var checkExistingBlobs bool
+	var tlsOptions registry.TLSOptions
+	var registriesConfOptions registriesconf.Options
+	var testRegistry string
+	var chunkSize int64
+	var jobs int
+	var hotSwap load.HotSwap
+	var hotSwapSignal string
+	var output resultOutput
 
 	fs := flag.NewFlagSet("push", flag.ContinueOnError)
 	fs.Var(&additionalTags, "tag", "Additional tag to apply (can be used multiple times)")
 	fs.Var(&additionalTags, "t", "Additional tag to apply (can be used multiple times)")
 	fs.StringVar(&overrideRegistry, "registry", "", "Override registry to push to")
 	fs.StringVar(&overrideRepository, "repository", "", "Override repository to push to")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the references and blobs that would be pushed (and where their data comes from) without contacting the target registry or loading images.")
+	fs.StringVar(&manifestFormat, "manifest-format", "", `Rewrite the media types of pushed manifests, configs, and layers to "docker" (Docker schema 2) or "oci". The digests of converted manifests differ from the built image. Defaults to pushing manifests unchanged.`)
+	fs.BoolVar(&checkCapabilities, "check-capabilities", true, "Query every target registry before pushing and print a summary of its capabilities. Unreachable registries and missing push permissions are reported before any blob is uploaded.")
+	fs.BoolVar(&checkExistingBlobs, "check-existing-blobs", true, "Check which layers already exist in the target repositories with parallel HEAD requests before uploading, and only upload the missing layers.")
+	fs.Int64Var(&chunkSize, "chunk-size", 0, "Upload layers larger than this many bytes in chunks and resume interrupted uploads from the last offset received by the registry. The registry may require a larger minimum chunk size. 0 uploads every blob in a single request.")
+	fs.IntVar(&jobs, "jobs", jobsFromEnv(), fmt.Sprintf("Maximum number of blobs uploaded concurrently, shared by all images of the push. Blobs are uploaded before the manifests referencing them and tags are written last (env: %s, 0 uses %d)", jobsEnv, push.DefaultJobs))
 	fs.StringVar(&platforms, "platform", "", "Comma-separated list of platforms to load (e.g., linux/amd64,linux/arm64). If not set, all platforms are loaded. Doesn't affect push, only load.")
+	fs.StringVar(&testRegistry, "test-registry", os.Getenv(registry.TestRegistryEnv), fmt.Sprintf(`Push to a registry fixture started by a test, given as "unix://<socket>" or "<host>:<port>" of a loopback address. Only the fixture is contacted (over plain HTTP), credentials are never looked up and only the eager push strategy is supported (env: %s)`, registry.TestRegistryEnv))
+	fs.StringVar(&hotSwap.ContainerID, "hot-swap-container", "", "Experimental: after loading, apply the changed layers of the image to the rootfs of this running container (full container ID) instead of recreating it. Requires loading into containerd. Files removed from the image are kept in the container.")
+	fs.StringVar(&hotSwapSignal, "hot-swap-signal", "", `Signal sent to the container after hot-swapping its rootfs (like "HUP" or "1"), for example to make the service reload.`)
+	fs.StringVar(&output.format, "output-format", "text", `Format of the result: "text" prints the pushed and loaded references, one per line. "json" writes a structured result (references, tags, transferred blobs and timing) for CI pipelines.`)
+	fs.StringVar(&output.path, "output-file", "", "Write the result to this file instead of stdout.")
+	tlsOptions.RegisterFlags(fs)
+	registriesConfOptions.RegisterFlags(fs)
+	logging.RegisterFlags(fs)
 
 	// Parse os.Args, skipping the program name
 	if len(os.Args) > 1 {
 		if err := fs.Parse(os.Args[1:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: failed to parse flags: %v\n", err)
+			slog.Error("Failed to parse flags", logging.ErrKey, err)
 			fs.Usage()
 			os.Exit(1)
 		}
 	}
+	registriesConf, err := registriesConfOptions.Load()
+	if err != nil {
+		logging.Fatal("Loading registries configuration", logging.ErrKey, err)
+	}
+	registriesConf.ConfigureTLS(&tlsOptions)
+	if err := registry.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	if testRegistry != "" {
+		fixture, err := registry.ParseTestRegistry(testRegistry)
+		if err != nil {
+			logging.Fatal("Invalid --test-registry", logging.ErrKey, err)
+		}
+		if overrideRegistry != "" && overrideRegistry != fixture.Host {
+			logging.Fatal("--registry cannot be combined with the test registry", "test_registry", fixture)
+		}
+		registry.UseTestRegistry(fixture)
+		overrideRegistry = fixture.Host
+	}
 
 	// Parse platforms
 	var platformList []string
@@ -62,13 +112,27 @@ func DeployDispatch(ctx context.Context, rawRequest []byte) {
 		}
 	}
 
-	if err := DeployWithExtras(ctx, rawRequest, []string(additionalTags), overrideRegistry, overrideRepository, platformList); err != nil {
-		fmt.Fprintf(os.Stderr, "Error during deploy: %v\n", err)
-		os.Exit(1)
+	format, err := push.ParseManifestFormat(manifestFormat)
+	if err != nil {
+		logging.Fatal("Invalid --manifest-format", logging.ErrKey, err)
+	}
+	if output.format != "text" && output.format != "json" {
+		logging.Fatal(`Invalid --output-format, must be "text" or "json"`, "output_format", output.format)
+	}
+	if hotSwapSignal != "" {
+		hotSwap.Signal, err = parseSignal(hotSwapSignal)
+		if err != nil {
+			logging.Fatal("Invalid --hot-swap-signal", logging.ErrKey, err)
+		}
+	}
+
+	if err := DeployWithExtras(ctx, rawRequest, []string(additionalTags), overrideRegistry, overrideRepository, platformList, dryRun, format, checkCapabilities, checkExistingBlobs, chunkSize, jobs, registriesConf, hotSwap, output); err != nil {
+		logging.Fatal("Deploy failed", logging.ErrKey, registry.Diagnose(err))
 	}
 }
 
-func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []string, overrideRegistry, overrideRepository string, platformList []string) error {
+func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []string, overrideRegistry, overrideRepository string, platformList []string, dryRun bool, manifestFormat push.ManifestFormat, checkCapabilities, checkExistingBlobs bool, chunkSize int64, jobs int, registriesConf *registriesconf.Config, hotSwap load.HotSwap, output resultOutput) error {
+	result := deployResult{StartTime: time.Now().UTC(), Pushed: []push.Result{}, Loaded: []loadResult{}}
 	var req api.DeployManifest
 	decoder := json.NewDecoder(bytes.NewReader(rawRequest))
 	decoder.DisallowUnknownFields()
@@ -97,6 +161,26 @@ func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []s
 	if len(pushOperations) == 0 && len(loadOperations) == 0 {
 		return fmt.Errorf("no push or load operations found in deploy manifest")
 	}
+	if fixture := registry.ActiveTestRegistry(); fixture != nil {
+		if err := checkHermeticTestPush(fixture, req, pushOperations, loadOperations); err != nil {
+			return err
+		}
+	}
+	for _, op := range pushOperations {
+		targetRegistry, targetRepository := op.Registry, op.Repository
+		if overrideRegistry != "" {
+			targetRegistry = overrideRegistry
+		}
+		if overrideRepository != "" {
+			targetRepository = overrideRepository
+		}
+		if err := registriesConf.CheckPush(targetRegistry, targetRepository); err != nil {
+			return err
+		}
+	}
+	if manifestFormat != push.ManifestFormatUnchanged && len(pushOperations) > 0 && req.Settings.PushStrategy == "bes" {
+		return fmt.Errorf("--manifest-format is not supported with the bes push strategy, since manifests are uploaded by the Build Event Service")
+	}
 
 	// check if any operation requires a reapi endpoint
 	var casReader *cas.CAS
@@ -139,6 +223,7 @@ func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []s
 
 	var pushedTags []string
 	var loadedTags []string
+	var pushResults []push.Result
 	g, ctx := errgroup.WithContext(ctx)
 
 	if len(pushOperations) > 0 {
@@ -155,17 +240,59 @@ func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []s
 		if len(additionalTags) > 0 {
 			uploadBuilder = uploadBuilder.WithExtraTags(additionalTags)
 		}
+		if webhookSecret := os.Getenv("IMG_WEBHOOK_SECRET"); webhookSecret != "" {
+			uploadBuilder = uploadBuilder.WithWebhookSecret([]byte(webhookSecret))
+		}
+		if manifestFormat != push.ManifestFormatUnchanged {
+			uploadBuilder = uploadBuilder.WithManifestFormat(manifestFormat)
+		}
+		if checkCapabilities {
+			uploadBuilder = uploadBuilder.WithCapabilityCheck(registry.MultiKeychain())
+		}
+		if checkExistingBlobs {
+			uploadBuilder = uploadBuilder.WithExistingBlobCheck(registry.MultiKeychain())
+		}
+		if chunkSize > 0 {
+			uploadBuilder = uploadBuilder.WithChunkedUpload(chunkSize, registry.MultiKeychain())
+		}
+		uploadBuilder.WithJobs(jobs)
 		uploadBuilder.WithRemoteOptions(registry.WithAuthFromMultiKeychain())
 		uploader := uploadBuilder.Build()
 
-		g.Go(func() error {
-			tags, err := uploader.PushAll(ctx, pushOperations, req.Settings.PushStrategy)
+		if dryRun {
+			plans, err := uploader.Plan(pushOperations, req.Settings.PushStrategy)
 			if err != nil {
-				return err
+				return fmt.Errorf("planning push: %w", err)
 			}
-			pushedTags = tags
-			return nil
-		})
+			if output.format == "json" {
+				result.Planned = plans
+			} else {
+				printPushPlans(plans, req.Settings.PushStrategy)
+			}
+		} else {
+			g.Go(func() error {
+				tags, err := uploader.PushAll(ctx, pushOperations, req.Settings.PushStrategy)
+				if err != nil {
+					return err
+				}
+				pushedTags = tags
+				pushResults = uploader.Results()
+				return nil
+			})
+		}
+	}
+	if dryRun {
+		if output.format == "json" {
+			result.DryRun = true
+			for _, op := range loadOperations {
+				result.Loaded = append(result.Loaded, newLoadResult(op))
+			}
+			return output.write(result)
+		}
+		for _, op := range loadOperations {
+			fmt.Printf("Would load %s into %s as %s\n", op.Root.Digest, op.Daemon, strings.Join(op.Tags, ", "))
+		}
+		return nil
 	}
 	if len(loadOperations) > 0 {
 		g.Go(func() error {
@@ -173,6 +300,9 @@ func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []s
 			if len(platformList) > 0 {
 				builder = builder.WithPlatforms(platformList)
 			}
+			if hotSwap.ContainerID != "" {
+				builder = builder.WithHotSwap(hotSwap)
+			}
 			loadedTags, err = builder.Build().LoadAll(ctx, loadOperations)
 			return err
 		})
@@ -182,17 +312,124 @@ func DeployWithExtras(ctx context.Context, rawRequest []byte, additionalTags []s
 		return fmt.Errorf("deploying images: %w", err)
 	}
 
+	if output.format == "json" {
+		if pushResults != nil {
+			result.Pushed = pushResults
+		}
+		for _, op := range loadOperations {
+			result.Loaded = append(result.Loaded, newLoadResult(op))
+		}
+		result.DurationSeconds = time.Since(result.StartTime).Seconds()
+		return output.write(result)
+	}
+
 	// Print all pushed tags to stdout, one per line.
-	for _, tag := range pushedTags {
-		fmt.Println(tag)
+	var lines []string
+	lines = append(lines, pushedTags...)
+	lines = append(lines, loadedTags...)
+	return output.write(lines)
+}
+
+// resultOutput configures how the result of a deploy is reported (--output-format and --output-file).
+type resultOutput struct {
+	format string
+	path   string
+}
+
+// write writes the result to the output file or stdout.
+// The text format expects a list of lines, the json format any JSON-serializable value.
+func (o resultOutput) write(v any) error {
+	var data []byte
+	if o.format == "json" {
+		raw, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling result: %w", err)
+		}
+		data = append(raw, '\n')
+	} else {
+		for _, line := range v.([]string) {
+			data = append(data, line...)
+			data = append(data, '\n')
+		}
+	}
+	if o.path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
 	}
-	for _, tag := range loadedTags {
-		fmt.Println(tag)
+	if err := os.WriteFile(o.path, data, 0o644); err != nil {
+		return fmt.Errorf("writing result to %s: %w", o.path, err)
 	}
+	return nil
+}
+
+// deployResult is the machine-readable result of a deploy (--output-format=json).
+type deployResult struct {
+	// Pushed lists the pushed images and indexes. Images pushed by the Build Event Service are not listed.
+	Pushed []push.Result `json:"pushed"`
+	// Loaded lists the images loaded into a container daemon.
+	Loaded []loadResult `json:"loaded"`
+	// Planned lists what a push would do, only set for --dry-run.
+	Planned []push.PlannedPush `json:"planned,omitempty"`
+	DryRun  bool               `json:"dry_run,omitempty"`
+	// StartTime and DurationSeconds measure the whole deploy.
+	StartTime       time.Time `json:"start_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// loadResult describes an image loaded into a container daemon.
+type loadResult struct {
+	Daemon    string   `json:"daemon"`
+	Digest    string   `json:"digest"`
+	MediaType string   `json:"media_type"`
+	Tags      []string `json:"tags"`
+}
 
+func newLoadResult(op api.IndexedLoadDeployOperation) loadResult {
+	return loadResult{
+		Daemon:    op.Daemon,
+		Digest:    op.Root.Digest,
+		MediaType: op.Root.MediaType,
+		Tags:      op.Tags,
+	}
+}
+
+// checkHermeticTestPush rejects operations that would need anything but the test registry.
+func checkHermeticTestPush(fixture *registry.TestRegistry, req api.DeployManifest, pushOperations []api.IndexedPushDeployOperation, loadOperations []api.IndexedLoadDeployOperation) error {
+	if len(loadOperations) > 0 {
+		return fmt.Errorf("pushing to the test registry %s: loading images into a container daemon is not supported", fixture)
+	}
+	if req.Settings.PushStrategy != "eager" {
+		return fmt.Errorf("pushing to the test registry %s: push strategy %q is not supported, use the eager push strategy", fixture, req.Settings.PushStrategy)
+	}
+	for _, op := range pushOperations {
+		if len(op.Webhooks) > 0 {
+			return fmt.Errorf("pushing to the test registry %s: webhooks of %s/%s cannot be notified", fixture, op.Registry, op.Repository)
+		}
+	}
 	return nil
 }
 
+// printPushPlans prints the result of a dry-run push to stdout.
+func printPushPlans(plans []push.PlannedPush, strategy string) {
+	for _, plan := range plans {
+		fmt.Printf("Would push %s (strategy %s) to:\n", plan.Root.Digest, strategy)
+		for _, ref := range plan.References {
+			fmt.Printf("  %s\n", ref)
+		}
+		if strategy == "bes" {
+			fmt.Println("Blobs are uploaded by the Build Event Service, not by this command.")
+			continue
+		}
+		var totalSize int64
+		fmt.Println("Blobs (skipped if already present in the target registry):")
+		for _, blob := range plan.Blobs {
+			fmt.Printf("  %s %12d bytes  from %-12s %s\n", blob.Digest, blob.Size, blob.Source, blob.MediaType)
+			totalSize += blob.Size
+		}
+		fmt.Printf("Total: %d blobs, %d bytes\n", len(plan.Blobs), totalSize)
+	}
+}
+
 // stringSliceFlag implements flag.Value for collecting multiple string values
 type stringSliceFlag []string
 
@@ -208,6 +445,44 @@ func (s *stringSliceFlag) Set(value string) error {
 	return nil
 }
 
+// parseSignal parses a signal name (like "HUP" or "SIGHUP") or number.
+// Names use the Linux signal numbers, since containers run Linux.
+func parseSignal(value string) (uint32, error) {
+	if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	signals := map[string]uint32{
+		"HUP":  1,
+		"INT":  2,
+		"QUIT": 3,
+		"KILL": 9,
+		"USR1": 10,
+		"USR2": 12,
+		"TERM": 15,
+	}
+	if n, ok := signals[strings.TrimPrefix(strings.ToUpper(value), "SIG")]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", value)
+}
+
+// jobsEnv provides the default of --jobs. It is set from the push_jobs build setting.
+const jobsEnv = "IMG_PUSH_JOBS"
+
+// jobsFromEnv returns the number of concurrent uploads of $IMG_PUSH_JOBS, or 0 (the default) if unset or invalid.
+func jobsFromEnv() int {
+	value := os.Getenv(jobsEnv)
+	if value == "" {
+		return 0
+	}
+	jobs, err := strconv.Atoi(value)
+	if err != nil || jobs < 0 {
+		slog.Warn("Ignoring invalid number of push jobs", "env", jobsEnv, "value", value)
+		return 0
+	}
+	return jobs
+}
+
 func pushFromArgs(ctx context.Context, args []string) {
 	panic("not implemented")
 }
@@ -0,0 +1,118 @@
+package push
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/push"
+)
+
+func TestCheckHermeticTestPush(t *testing.T) {
+	fixture, err := registry.ParseTestRegistry("127.0.0.1:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushOp := api.IndexedPushDeployOperation{PushDeployOperation: api.PushDeployOperation{
+		PushTarget: api.PushTarget{Registry: "registry.example.com", Repository: "app"},
+	}}
+	withWebhook := pushOp
+	withWebhook.Webhooks = []string{"https://hooks.example.com/push"}
+
+	tests := []struct {
+		name     string
+		strategy string
+		push     []api.IndexedPushDeployOperation
+		load     []api.IndexedLoadDeployOperation
+		wantErr  string
+	}{
+		{name: "eager push", strategy: "eager", push: []api.IndexedPushDeployOperation{pushOp}},
+		{name: "lazy push", strategy: "lazy", push: []api.IndexedPushDeployOperation{pushOp}, wantErr: `push strategy "lazy" is not supported`},
+		{name: "load", strategy: "eager", load: []api.IndexedLoadDeployOperation{{}}, wantErr: "loading images into a container daemon is not supported"},
+		{name: "webhook", strategy: "eager", push: []api.IndexedPushDeployOperation{withWebhook}, wantErr: "webhooks of registry.example.com/app cannot be notified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := api.DeployManifest{Settings: api.DeploySettings{PushStrategy: tt.strategy}}
+			err := checkHermeticTestPush(fixture, req, tt.push, tt.load)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkHermeticTestPush() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "pushing to the test registry 127.0.0.1:5000: "+tt.wantErr) {
+				t.Errorf("checkHermeticTestPush() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResultOutputWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	text := resultOutput{format: "text", path: filepath.Join(dir, "result.txt")}
+	if err := text.write([]string{"registry.example.com/app:v1", "app:latest"}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if got, _ := os.ReadFile(text.path); string(got) != "registry.example.com/app:v1\napp:latest\n" {
+		t.Errorf("text result = %q, want one reference per line", got)
+	}
+
+	result := deployResult{
+		Pushed: []push.Result{{Registry: "registry.example.com", Repository: "app", Digest: "sha256:aaaa", Tags: []string{"v1"}}},
+		Loaded: []loadResult{newLoadResult(api.IndexedLoadDeployOperation{LoadDeployOperation: api.LoadDeployOperation{
+			BaseCommandOperation: api.BaseCommandOperation{Root: api.Descriptor{Digest: "sha256:bbbb", MediaType: "application/vnd.oci.image.manifest.v1+json"}},
+			Daemon:               "docker",
+			Tags:                 []string{"app:latest"},
+		}})},
+		DurationSeconds: 1.5,
+	}
+	jsonOutput := resultOutput{format: "json", path: filepath.Join(dir, "result.json")}
+	if err := jsonOutput.write(result); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	raw, err := os.ReadFile(jsonOutput.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("json result %s: %v", raw, err)
+	}
+	for _, key := range []string{"pushed", "loaded", "start_time", "duration_seconds"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("json result %s has no %q", raw, key)
+		}
+	}
+	for _, key := range []string{"planned", "dry_run"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("json result %s has %q, want it only for dry runs", raw, key)
+		}
+	}
+	for _, want := range []string{`"daemon": "docker"`, `"digest": "sha256:bbbb"`, `"tags": [`, `"repository": "app"`} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("json result %s does not contain %s", raw, want)
+		}
+	}
+
+	missing := resultOutput{format: "text", path: filepath.Join(dir, "missing", "result.txt")}
+	if err := missing.write([]string{"app:latest"}); err == nil || !strings.Contains(err.Error(), "writing result to") {
+		t.Errorf("write() to a missing directory: error = %v, want a write error", err)
+	}
+}
+
+func TestJobsFromEnv(t *testing.T) {
+	for value, want := range map[string]int{"": 0, "8": 8, "0": 0, "-2": 0, "many": 0} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv(jobsEnv, value)
+			if got := jobsFromEnv(); got != want {
+				t.Errorf("jobsFromEnv() with %s=%q = %d, want %d", jobsEnv, value, got, want)
+			}
+		})
+	}
+}
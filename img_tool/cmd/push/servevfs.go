@@ -0,0 +1,165 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/credential"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/protohelper"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/deployvfs"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// ServeVFSProcess serves the images of a deploy manifest as a read-only registry.
+func ServeVFSProcess(ctx context.Context, args []string) {
+	var deployManifestPath string
+	var runfilesDir string
+	var address string
+	var tlsOptions registry.TLSOptions
+
+	flagSet := flag.NewFlagSet("serve-vfs", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Serves the images of a push or load target as a read-only registry, so that other tools can analyze the exact image that would be deployed without pushing it.\n")
+		fmt.Fprintf(flagSet.Output(), "Blobs are read from the runfiles of the target, the registry of a shallow base image or the remote cache (lazy strategy, needs $IMG_REAPI_ENDPOINT).\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img serve-vfs [OPTIONS]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img serve-vfs --runfiles-dir bazel-bin/push.runfiles --addr localhost:0",
+			"img serve-vfs --runfiles-dir bazel-bin/push.runfiles --deploy-manifest bazel-bin/push.runfiles/dispatch.json --addr :5000",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&deployManifestPath, "deploy-manifest", "", "Deploy manifest of the push or load target. Defaults to dispatch.json in the runfiles.")
+	flagSet.StringVar(&runfilesDir, "runfiles-dir", "", "Runfiles directory of the push or load target (like bazel-bin/push.runfiles). Defaults to the runfiles found via $RUNFILES_DIR.")
+	flagSet.StringVar(&address, "addr", "localhost:0", "Address to listen on. Port 0 selects a free port.")
+	tlsOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := registry.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	if err := useRunfilesDir(runfilesDir); err != nil {
+		logging.Fatal("Invalid runfiles directory", logging.ErrKey, err)
+	}
+
+	if err := serveVFS(ctx, deployManifestPath, address); err != nil {
+		logging.Fatal("Serving images", logging.ErrKey, err)
+	}
+}
+
+// useRunfilesDir makes the runfiles library (and therefore the VFS) read from the given runfiles directory.
+func useRunfilesDir(runfilesDir string) error {
+	if runfilesDir == "" {
+		return nil
+	}
+	absRunfilesDir, err := filepath.Abs(runfilesDir)
+	if err != nil {
+		return err
+	}
+	os.Setenv("RUNFILES_DIR", absRunfilesDir)
+	os.Unsetenv("RUNFILES_MANIFEST_FILE")
+	return nil
+}
+
+// loadDeployVFS reads the deploy manifest (by default dispatch.json in the runfiles) and builds its VFS.
+func loadDeployVFS(deployManifestPath string) (api.DeployManifest, *deployvfs.VFS, error) {
+	var req api.DeployManifest
+	if deployManifestPath == "" {
+		var err error
+		deployManifestPath, err = runfiles.Rlocation("dispatch.json")
+		if err != nil {
+			return req, nil, fmt.Errorf("locating deploy manifest in runfiles (use --deploy-manifest or --runfiles-dir): %w", err)
+		}
+	}
+	rawRequest, err := os.ReadFile(deployManifestPath)
+	if err != nil {
+		return req, nil, fmt.Errorf("reading deploy manifest: %w", err)
+	}
+	if err := json.Unmarshal(rawRequest, &req); err != nil {
+		return req, nil, fmt.Errorf("unmarshalling deploy manifest file: %w", err)
+	}
+
+	vfsBuilder := deployvfs.Builder(req).WithContainerRegistryOption(registry.WithAuthFromMultiKeychain())
+	if reapiEndpoint := os.Getenv("IMG_REAPI_ENDPOINT"); reapiEndpoint != "" {
+		credentialHelper := credential.NopHelper()
+		if helperPath := credentialHelperPath(); helperPath != "" {
+			credentialHelper = credential.New(helperPath)
+		}
+		grpcClientConn, err := protohelper.Client(reapiEndpoint, credentialHelper)
+		if err != nil {
+			return req, nil, fmt.Errorf("creating gRPC client connection: %w", err)
+		}
+		casReader, err := cas.New(grpcClientConn)
+		if err != nil {
+			return req, nil, fmt.Errorf("creating CAS client: %w", err)
+		}
+		vfsBuilder = vfsBuilder.WithCASReader(casReader)
+	}
+	vfs, err := vfsBuilder.Build()
+	if err != nil {
+		return req, nil, fmt.Errorf("building VFS: %w", err)
+	}
+	return req, vfs, nil
+}
+
+func serveVFS(ctx context.Context, deployManifestPath, address string) error {
+	_, vfs, err := loadDeployVFS(deployManifestPath)
+	if err != nil {
+		return err
+	}
+	handler, err := vfs.Handler()
+	if err != nil {
+		return err
+	}
+	images, err := vfs.ServedImages()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", address, err)
+	}
+	host := listener.Addr().String()
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		host = fmt.Sprintf("localhost:%d", tcpAddr.Port)
+	}
+	fmt.Printf("Serving %d image reference(s) read-only at http://%s\n", len(images), host)
+	for _, image := range images {
+		if image.Tag != "" {
+			fmt.Printf("  %s/%s:%s\n", host, image.Repository, image.Tag)
+		} else {
+			fmt.Printf("  %s/%s@%s\n", host, image.Repository, image.Digest)
+		}
+	}
+	fmt.Println("Use plain HTTP (e.g. --insecure for crane, TRIVY_INSECURE=true for trivy) to connect. Press Ctrl+C to stop.")
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -24,3 +24,17 @@ func (s *blobStores) Set(value string) error {
 	*s = append(*s, value)
 	return nil
 }
+
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	if s == nil || len(*s) == 0 {
+		return ""
+	}
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
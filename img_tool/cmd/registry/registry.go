@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -14,20 +15,29 @@ import (
 	"github.com/malt3/go-containerregistry/pkg/registry"
 	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/credential"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/protohelper"
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	blobcache_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/blobcache"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/blobcache"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/health"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/metrics"
 	combined "github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry/reapi"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry/s3"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry/upstream"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/serverauth"
 )
 
 const usage = `Usage: registry [ARGS...]`
 
 func Run(ctx context.Context, args []string) {
+	logging.Init(logging.WithTimestamps())
+
 	var registryAddress string
 	var httpPort int
 	var grpcPort int
@@ -40,6 +50,11 @@ func Run(ctx context.Context, args []string) {
 	var s3Region string
 	var s3profile string
 	var credentialHelperPath string
+	var healthInterval time.Duration
+	var readOnly bool
+	var healthGrace time.Duration
+	var pullThroughRegistries stringSliceFlag
+	var metricsAddress string
 
 	flagSet := flag.NewFlagSet("registry", flag.ExitOnError)
 	flagSet.Usage = func() {
@@ -49,6 +64,9 @@ func Run(ctx context.Context, args []string) {
 		examples := []string{
 			"registry --address 0.0.0.0 --port 8080",
 			"registry --blob-store s3 --blob-store reapi",
+			"registry --address 0.0.0.0 --port 8080 --blob-store reapi --reapi-endpoint grpcs://remote.buildbuddy.io --read-only",
+			"registry --address 0.0.0.0 --port 8080 --blob-store reapi --reapi-endpoint grpcs://remote.buildbuddy.io --pull-through-registry index.docker.io --pull-through-registry gcr.io",
+			"registry --address 0.0.0.0 --port 8443 --blob-store reapi --reapi-endpoint grpcs://remote.buildbuddy.io --tls-cert server.crt --tls-key server.key --auth-token-file tokens.txt",
 		}
 		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
 		for _, example := range examples {
@@ -68,14 +86,35 @@ func Run(ctx context.Context, args []string) {
 	flagSet.StringVar(&s3Region, "s3-region", "", "S3 region to use for the S3 blob store (optional, defaults to auto detect)")
 	flagSet.StringVar(&s3profile, "s3-profile", "", "AWS profile to use for the S3 blob store (optional, defaults to default profile)")
 	flagSet.StringVar(&credentialHelperPath, "credential-helper", "", "Path to credential helper binary (optional, defaults to no helper)")
+	flagSet.BoolVar(&readOnly, "read-only", false, "Reject pushes and only serve pulls, e.g. for a cluster that pulls images pushed with the cas_registry strategy through another replica")
+	flagSet.Var(&pullThroughRegistries, "pull-through-registry", `Registry (like "index.docker.io", or "*" for any) that clients may mount blobs from, so that layers of shallow base images are pulled through from it on demand. Can be specified multiple times. Requires the reapi blob store.`)
+	flagSet.StringVar(&metricsAddress, "metrics-address", "", "Address (host:port) to serve Prometheus metrics on at /metrics without credentials (optional). If empty, /metrics is served on the registry port and requires the same credentials as the registry")
+	flagSet.DurationVar(&healthInterval, "health-check-interval", 30*time.Second, "Time between two runs of the health checks served on /healthz and /readyz")
+	flagSet.DurationVar(&healthGrace, "health-failure-grace", 5*time.Minute, "Time a health check may fail before /healthz fails, so that the pod is restarted")
+	var tlsOptions reg.TLSOptions
+	tlsOptions.RegisterFlags(flagSet)
+	var serverOptions serverauth.Options
+	serverOptions.RegisterFlags(flagSet)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args[1:]); err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		slog.Error("Invalid arguments", logging.ErrKey, err)
 		flagSet.Usage()
 		os.Exit(1)
 	}
+	if err := reg.ConfigureTransport(tlsOptions); err != nil {
+		logging.Fatal("Invalid registry TLS options", logging.ErrKey, err)
+	}
+	serverTLS, err := serverOptions.TLSConfig()
+	if err != nil {
+		logging.Fatal("Invalid server TLS options", logging.ErrKey, err)
+	}
+	authorizer, err := serverOptions.Authorizer()
+	if err != nil {
+		logging.Fatal("Invalid server authorization options", logging.ErrKey, err)
+	}
 	if len(blobStores) == 0 {
-		fmt.Fprintln(os.Stderr, "Error: at least one blob store must be specified")
+		slog.Error("At least one blob store must be specified")
 		flagSet.Usage()
 		os.Exit(1)
 	}
@@ -92,7 +131,7 @@ func Run(ctx context.Context, args []string) {
 		var err error
 		grpcClientConn, err = protohelper.Client(reapiEndpoint, credentialHelper)
 		if err != nil {
-			log.Fatalf("Failed to create gRPC client connection: %v", err)
+			logging.Fatal("Failed to create gRPC client connection", logging.ErrKey, err)
 		}
 	}
 
@@ -128,7 +167,7 @@ func Run(ctx context.Context, args []string) {
 				s3Opts...,
 			)
 			if err != nil {
-				log.Fatalf("Failed to create S3 blob store: %v", err)
+				logging.Fatal("Failed to create S3 blob store", logging.ErrKey, err)
 			}
 			stores = append(stores, s3Store)
 			nonREAPIStores = append(nonREAPIStores, s3Store)
@@ -137,49 +176,64 @@ func Run(ctx context.Context, args []string) {
 			nonREAPIStores = append(nonREAPIStores, upstream.New(upstreamURL))
 		case "reapi":
 			if reapiEndpoint == "" || grpcClientConn == nil {
-				log.Fatalln("REAPI endpoint must be specified when using the reapi blob store")
+				logging.Fatal("REAPI endpoint must be specified when using the reapi blob store")
 			}
 			if wantREAPI {
-				log.Fatalln("Only one reapi blob store can be specified")
+				logging.Fatal("Only one reapi blob store can be specified")
 			}
 			wantREAPI = true
 			reapiIndex = len(stores)
 			stores = append(stores, nil) // Placeholder for reapi store, will be set later
 		}
 	}
+	checker := health.New(health.WithInterval(healthInterval), health.WithLivenessGrace(healthGrace))
+	if grpcClientConn != nil {
+		casClient, err := cas.New(grpcClientConn)
+		if err != nil {
+			logging.Fatal("Failed to create CAS client", logging.ErrKey, err)
+		}
+		checker.Add("reapi", health.CASCheck(casClient))
+	}
+	go checker.Run(ctx)
+
 	var blobWriter combined.Writer
+	var reapiStore *reapi.REAPIBlobHandler
 	if wantREAPI {
 		var reapiUpstream combined.Handler = combined.NewCombinedBlobStore(blobSizeCache, nil /* writer */, nonREAPIStores...).(combined.Handler)
-		reapiStore, err := reapi.New(reapiUpstream, grpcClientConn, blobSizeCache)
+		var err error
+		reapiStore, err = reapi.New(reapiUpstream, grpcClientConn, blobSizeCache)
 		if err != nil {
-			log.Fatalf("Failed to create REAPI blob store: %v", err)
+			logging.Fatal("Failed to create REAPI blob store", logging.ErrKey, err)
 		}
 		stores[reapiIndex] = reapiStore
-		blobWriter = reapiStore
+		if !readOnly {
+			blobWriter = reapiStore
+		}
 	}
 	if enableBlobCache {
 		if grpcClientConn == nil {
-			log.Fatalln("gRPC client connection must be provided to enable blob cache")
+			logging.Fatal("gRPC client connection must be provided to enable blob cache")
 		}
 		service := blobcache.NewServer(grpcClientConn, blobSizeCache)
 		grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
 		if err != nil {
-			log.Fatalf("Failed to start gRPC server: %v", err)
+			logging.Fatal("Failed to start gRPC server", logging.ErrKey, err)
 		}
-		fmt.Fprintf(os.Stderr, "gRPC blob cache server listening on %d\n", grpcPort)
+		slog.Info("gRPC blob cache server listening", "port", grpcPort)
 		go func() {
 			// TOODO: Handle errors and shutdown gracefully.
-			grpcServer := grpc.NewServer()
+			grpcServer := grpc.NewServer(serverauth.GRPCServerOptions(serverTLS, authorizer)...)
 			blobcache_proto.RegisterBlobsServer(grpcServer, service)
+			healthpb.RegisterHealthServer(grpcServer, checker.GRPCServer())
 			if err := grpcServer.Serve(grpcListener); err != nil {
-				log.Fatalf("Failed to serve gRPC server: %v", err)
+				logging.Fatal("Failed to serve gRPC server", logging.ErrKey, err)
 			}
 		}()
 	}
 
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", registryAddress, httpPort))
 	if err != nil {
-		log.Fatalln(err)
+		logging.Fatal("Failed to listen", logging.ErrKey, err)
 	}
 	porti := listener.Addr().(*net.TCPAddr).Port
 
@@ -189,20 +243,76 @@ func Run(ctx context.Context, args []string) {
 	protos.SetHTTP1(true)
 	protos.SetHTTP2(false)
 	protos.SetUnencryptedHTTP2(false)
+	mux := http.NewServeMux()
+	// Health probes stay reachable without credentials, so that probes don't need a token.
+	checker.Register(mux)
+	// Metrics need credentials on the registry port. Scrapers without credentials use --metrics-address.
+	serveErrs := make(chan error, 2)
+	if metricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Default.Handler())
+		metricsServer := &http.Server{
+			Addr:              metricsAddress,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		slog.Info("Serving metrics", "url", "http://"+metricsAddress+"/metrics")
+		go func() {
+			serveErrs <- fmt.Errorf("serving metrics on %s: %w", metricsAddress, metricsServer.ListenAndServe())
+		}()
+	} else {
+		mux.Handle("/metrics", serverauth.Handler(authorizer, metrics.Default.Handler()))
+	}
+	var registryHandler http.Handler = registry.New(
+		registry.WithBlobHandler(combinedStore),
+		registry.WithManifestPutCallback(func(repo, target, contentType string, blob []byte) error {
+			if err := callbacker.ManifestPutCallback(repo, target, contentType, blob); err != nil {
+				return err
+			}
+			if reapiStore == nil {
+				return nil
+			}
+			// Store the manifest in the remote cache, so that any replica can serve pulls of it.
+			return reapiStore.RecordManifest(repo, target, contentType, blob)
+		}),
+	)
+	if reapiStore != nil {
+		// Manifests that were pushed to another replica (or before a restart) are read from the remote cache.
+		registryHandler = reapiStore.ManifestHandler(registryHandler)
+		if len(pullThroughRegistries) > 0 {
+			// Layers of shallow base images are mounted from their original registry and pulled through on demand.
+			reapiStore.EnablePullThrough(pullThroughRegistries)
+			registryHandler = reapiStore.MountHandler(registryHandler)
+		}
+	} else if len(pullThroughRegistries) > 0 {
+		logging.Fatal("--pull-through-registry requires the reapi blob store")
+	}
+	if readOnly {
+		registryHandler = combined.ReadOnlyHandler(registryHandler)
+	}
+	mux.Handle("/", metrics.Default.InstrumentHandler(
+		"img_registry_http_requests_total",
+		"Number of HTTP requests served by the registry, partitioned by method and status code.",
+		serverauth.Handler(authorizer, registryHandler),
+	))
 	server := &http.Server{
-		Handler: registry.New(
-			registry.WithBlobHandler(combinedStore),
-			registry.WithManifestPutCallback(callbacker.ManifestPutCallback),
-		),
+		Handler:           mux,
 		IdleTimeout:       30 * time.Minute,
 		ReadTimeout:       30 * time.Minute,
 		WriteTimeout:      30 * time.Minute,
 		ReadHeaderTimeout: 30 * time.Minute,
 		Protocols:         protos,
+		TLSConfig:         serverTLS,
+	}
+	slog.Info("Registry listening", "port", porti, "tls", serverTLS != nil, "read-only", readOnly)
+	if serverTLS != nil {
+		listener = tls.NewListener(listener, serverTLS)
 	}
-	fmt.Fprintf(os.Stderr, "Listening on %d\n", porti)
-	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Failed to serve HTTP server: %v", err)
+	go func() {
+		serveErrs <- server.Serve(listener)
+	}()
+	if err := <-serveErrs; err != nil && err != http.ErrServerClosed {
+		logging.Fatal("Failed to serve HTTP server", logging.ErrKey, err)
 	}
 }
 
@@ -0,0 +1,183 @@
+package soci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/soci"
+)
+
+// IndexConfig holds the options of a soci-index invocation.
+type IndexConfig struct {
+	// Subject is the path of the raw image manifest the SOCI index belongs to.
+	Subject string
+	// Ztocs are the ztocs of the layers, in the order of the layers.
+	Ztocs []ztocFlag
+	// ManifestOutput, ConfigOutput and DescriptorOutput receive the SOCI index manifest, its config and its descriptor.
+	ManifestOutput   string
+	ConfigOutput     string
+	DescriptorOutput string
+}
+
+// IndexRunner writes the SOCI index manifest of an image.
+type IndexRunner struct {
+	cfg IndexConfig
+}
+
+// NewIndexRunner returns a runner for the given config.
+func NewIndexRunner(cfg IndexConfig) *IndexRunner {
+	return &IndexRunner{cfg: cfg}
+}
+
+// ztocFlag pairs the metadata file of a layer (as produced by "img layer --metadata") with the ztoc of the layer.
+type ztocFlag struct {
+	layerMetadata string
+	ztoc          string
+}
+
+type ztocFlags []ztocFlag
+
+func (z *ztocFlags) String() string {
+	var pairs []string
+	for _, f := range *z {
+		pairs = append(pairs, f.layerMetadata+"="+f.ztoc)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (z *ztocFlags) Set(value string) error {
+	layerMetadata, ztoc, ok := strings.Cut(value, "=")
+	if !ok || layerMetadata == "" || ztoc == "" {
+		return fmt.Errorf("ztoc must be in format layer_metadata=ztoc, got: %s", value)
+	}
+	*z = append(*z, ztocFlag{layerMetadata: layerMetadata, ztoc: ztoc})
+	return nil
+}
+
+func SociIndexProcess(ctx context.Context, args []string) {
+	var cfg IndexConfig
+	flagSet := flag.NewFlagSet("soci-index", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Writes the SOCI index manifest (v1) of an image from the ztocs of its layers.\n")
+		fmt.Fprintf(flagSet.Output(), "The SOCI index references the image manifest as its subject, so that it can be pushed as a referrer of the image.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img soci-index --subject=manifest.json [--ztoc=layer_metadata=ztoc]... --manifest=output --config=output [--descriptor=output]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img soci-index --subject manifest.json --ztoc layer_metadata.json=layer.ztoc --manifest soci_index.json --config soci_config.json",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&cfg.Subject, "subject", "", `The raw image manifest that the SOCI index belongs to.`)
+	flagSet.Var((*ztocFlags)(&cfg.Ztocs), "ztoc", `Layer metadata file and ztoc of a layer as layer_metadata=ztoc. Can be specified multiple times, in the order of the layers.`)
+	flagSet.StringVar(&cfg.ManifestOutput, "manifest", "", `The output file for the SOCI index manifest.`)
+	flagSet.StringVar(&cfg.ConfigOutput, "config", "", `The output file for the (empty) config of the SOCI index manifest.`)
+	flagSet.StringVar(&cfg.DescriptorOutput, "descriptor", "", `The (optional) output file for the descriptor of the SOCI index manifest.`)
+	logging.RegisterFlags(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 0 || cfg.Subject == "" || cfg.ManifestOutput == "" || cfg.ConfigOutput == "" {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if err := NewIndexRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Writing SOCI index", logging.ErrKey, err)
+	}
+}
+
+// Run writes the SOCI index manifest, its config and its descriptor.
+func (r *IndexRunner) Run(_ context.Context) error {
+	subjectRaw, err := os.ReadFile(r.cfg.Subject)
+	if err != nil {
+		return fmt.Errorf("reading subject: %w", err)
+	}
+	var subjectHeader struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(subjectRaw, &subjectHeader); err != nil {
+		return fmt.Errorf("decoding subject: %w", err)
+	}
+	subject := api.Descriptor{
+		MediaType: subjectHeader.MediaType,
+		Digest:    sha256Digest(subjectRaw),
+		Size:      int64(len(subjectRaw)),
+	}
+
+	ztocs := make([]api.Descriptor, 0, len(r.cfg.Ztocs))
+	for _, z := range r.cfg.Ztocs {
+		var layer api.Descriptor
+		if err := readJSONFile(z.layerMetadata, &layer); err != nil {
+			return fmt.Errorf("reading layer metadata: %w", err)
+		}
+		digest, size, err := sha256File(z.ztoc)
+		if err != nil {
+			return fmt.Errorf("reading ztoc: %w", err)
+		}
+		ztocs = append(ztocs, soci.ZtocDescriptor(digest, size, layer))
+	}
+
+	manifestRaw, err := soci.IndexManifest(subject, sha256Digest(soci.IndexConfig), ztocs)
+	if err != nil {
+		return fmt.Errorf("marshaling SOCI index: %w", err)
+	}
+	if err := os.WriteFile(r.cfg.ManifestOutput, manifestRaw, 0o644); err != nil {
+		return fmt.Errorf("writing SOCI index to %s: %w", r.cfg.ManifestOutput, err)
+	}
+	if err := os.WriteFile(r.cfg.ConfigOutput, soci.IndexConfig, 0o644); err != nil {
+		return fmt.Errorf("writing SOCI index config to %s: %w", r.cfg.ConfigOutput, err)
+	}
+	if r.cfg.DescriptorOutput != "" {
+		descriptorRaw, err := json.Marshal(api.Descriptor{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    sha256Digest(manifestRaw),
+			Size:      int64(len(manifestRaw)),
+		})
+		if err != nil {
+			return fmt.Errorf("marshaling SOCI index descriptor: %w", err)
+		}
+		if err := os.WriteFile(r.cfg.DescriptorOutput, descriptorRaw, 0o644); err != nil {
+			return fmt.Errorf("writing SOCI index descriptor to %s: %w", r.cfg.DescriptorOutput, err)
+		}
+	}
+	return nil
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func sha256File(filePath string) (string, int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), size, nil
+}
+
+func readJSONFile(filePath string, v any) error {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,78 @@
+package soci
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/soci"
+)
+
+// ZtocConfig holds the options of a soci-ztoc invocation.
+type ZtocConfig struct {
+	// LayerFile is the path of the gzip compressed layer.
+	LayerFile string
+	// SpanSize is the number of uncompressed bytes between decompression checkpoints.
+	SpanSize int64
+	// Output receives the ztoc.
+	Output string
+}
+
+// ZtocRunner builds the ztoc of a layer.
+type ZtocRunner struct {
+	cfg ZtocConfig
+}
+
+// NewZtocRunner returns a runner for the given config.
+func NewZtocRunner(cfg ZtocConfig) *ZtocRunner {
+	return &ZtocRunner{cfg: cfg}
+}
+
+func SociZtocProcess(ctx context.Context, args []string) {
+	var cfg ZtocConfig
+	flagSet := flag.NewFlagSet("soci-ztoc", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Builds the SOCI ztoc (table of contents and decompression checkpoints) of a gzip compressed layer.\n")
+		fmt.Fprintf(flagSet.Output(), "The soci-snapshotter uses ztocs to lazily load images.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img soci-ztoc [--span-size=bytes] [layer] [output]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img soci-ztoc layer.tgz layer.ztoc",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.Int64Var(&cfg.SpanSize, "span-size", soci.DefaultSpanSize, `Minimum number of uncompressed bytes between decompression checkpoints.`)
+	logging.RegisterFlags(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 2 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	cfg.LayerFile = flagSet.Arg(0)
+	cfg.Output = flagSet.Arg(1)
+
+	if err := NewZtocRunner(cfg).Run(ctx); err != nil {
+		logging.Fatal("Building ztoc", logging.ErrKey, err)
+	}
+}
+
+// Run builds the ztoc and writes it to the output file.
+func (r *ZtocRunner) Run(_ context.Context) error {
+	ztoc, err := soci.Ztoc(r.cfg.LayerFile, r.cfg.SpanSize)
+	if err != nil {
+		return fmt.Errorf("building ztoc of %s: %w", r.cfg.LayerFile, err)
+	}
+	if err := os.WriteFile(r.cfg.Output, ztoc, 0o644); err != nil {
+		return fmt.Errorf("writing ztoc: %w", err)
+	}
+	return nil
+}
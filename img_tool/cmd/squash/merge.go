@@ -0,0 +1,190 @@
+package squash
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tarreader"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// entryRef identifies a tar entry by the index of its layer and its position in the layer.
+type entryRef struct {
+	layer int
+	entry int
+}
+
+// squashState is the merged filesystem of the squashed layers.
+// Only headers are kept, the content of files is copied from the layers when writing.
+type squashState struct {
+	// paths maps the (normalized) paths of the merged layers to the entry providing them.
+	paths map[string]entryRef
+	// dirs is the set of paths that are directories.
+	dirs map[string]bool
+	// whiteouts removes paths of layers below the squashed layers.
+	whiteouts map[string]bool
+	// opaque directories hide the content of layers below the squashed layers.
+	opaque map[string]bool
+}
+
+// squashLayers merges the layers (from lowest to highest) into a single uncompressed tar.
+// Files of lower layers that are replaced or removed by higher layers are dropped.
+// Whiteouts are kept if they may refer to files below the squashed layers.
+func squashLayers(layers []string, w io.Writer) error {
+	state := &squashState{
+		paths:     make(map[string]entryRef),
+		dirs:      make(map[string]bool),
+		whiteouts: make(map[string]bool),
+		opaque:    make(map[string]bool),
+	}
+	for i, layer := range layers {
+		if err := state.apply(i, layer); err != nil {
+			return fmt.Errorf("reading layer %s: %w", layer, err)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	if err := state.writeWhiteouts(tw); err != nil {
+		return err
+	}
+	written := make(map[string]bool, len(state.paths))
+	for i, layer := range layers {
+		entry := 0
+		err := tarreader.Walk(layer, func(header *tar.Header, content io.Reader) error {
+			ref := entryRef{layer: i, entry: entry}
+			entry++
+			name := normalizePath(header.Name)
+			if provider, ok := state.paths[name]; !ok || provider != ref {
+				// whiteouts, and entries removed or replaced by a higher layer
+				return nil
+			}
+			if header.Typeflag == tar.TypeLink {
+				// hardlinks to files of lower layers must be written after their target
+				target := normalizePath(header.Linkname)
+				if _, squashed := state.paths[target]; squashed && !written[target] {
+					return fmt.Errorf("cannot squash hardlink %s: its target %s is replaced by a higher layer", header.Name, header.Linkname)
+				}
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, content); err != nil {
+				return err
+			}
+			written[name] = true
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("copying layer %s: %w", layer, err)
+		}
+	}
+	return tw.Close()
+}
+
+// apply records the entries of a layer on top of the previous layers.
+func (s *squashState) apply(index int, layer string) error {
+	entry := 0
+	return tarreader.Walk(layer, func(header *tar.Header, _ io.Reader) error {
+		ref := entryRef{layer: index, entry: entry}
+		entry++
+		name := normalizePath(header.Name)
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if base == opaqueWhiteout {
+			s.removeBelow(dir)
+			s.opaque[dir] = true
+			return nil
+		}
+		if removed, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			removed = path.Join(dir, removed)
+			s.remove(removed)
+			s.whiteouts[removed] = true
+			return nil
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		if s.dirs[name] && !isDir {
+			// a file replaces a directory, including everything below it
+			s.removeBelow(name)
+			delete(s.opaque, name)
+		}
+		if s.whiteouts[name] {
+			// a file overwrites the removed path of lower layers,
+			// but a directory would merge with it, so it hides it instead.
+			delete(s.whiteouts, name)
+			if isDir {
+				s.opaque[name] = true
+			}
+		}
+		s.paths[name] = ref
+		if isDir {
+			s.dirs[name] = true
+		} else {
+			delete(s.dirs, name)
+		}
+		return nil
+	})
+}
+
+// remove removes a path and everything below it.
+func (s *squashState) remove(name string) {
+	delete(s.paths, name)
+	delete(s.dirs, name)
+	delete(s.opaque, name)
+	s.removeBelow(name)
+}
+
+// removeBelow removes everything below a directory (but not the directory itself).
+// Whiteouts below the directory become redundant.
+func (s *squashState) removeBelow(dir string) {
+	below := func(p string) bool { return dir == "" || strings.HasPrefix(p, dir+"/") }
+	for p := range s.paths {
+		if below(p) {
+			delete(s.paths, p)
+		}
+	}
+	for _, m := range []map[string]bool{s.dirs, s.whiteouts, s.opaque} {
+		for p := range m {
+			if below(p) {
+				delete(m, p)
+			}
+		}
+	}
+}
+
+// writeWhiteouts writes the whiteouts and opaque markers first,
+// so that they only apply to the layers below the squashed layers.
+func (s *squashState) writeWhiteouts(tw *tar.Writer) error {
+	var names []string
+	for p := range s.opaque {
+		names = append(names, path.Join(p, opaqueWhiteout))
+	}
+	for p := range s.whiteouts {
+		dir, base := path.Split(p)
+		names = append(names, dir+whiteoutPrefix+base)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func normalizePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,227 @@
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// entry is a tar entry of a test layer. Names ending in "/" are directories, and linkname makes a hardlink.
+type entry struct {
+	name     string
+	content  string
+	linkname string
+}
+
+func writeLayer(t *testing.T, entries ...entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0o644, Size: int64(len(e.content))}
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr = &tar.Header{Typeflag: tar.TypeDir, Name: e.name, Mode: 0o755}
+		case e.linkname != "":
+			hdr = &tar.Header{Typeflag: tar.TypeLink, Name: e.name, Linkname: e.linkname, Mode: 0o644}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "layer-*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// squashed returns the entries of the squashed layer as "name" for directories and whiteouts,
+// "name=content" for files and "name->target" for hardlinks.
+func squashed(t *testing.T, layers ...string) ([]string, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := squashLayers(layers, &buf); err != nil {
+		return nil, err
+	}
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case hdr.Typeflag == tar.TypeLink:
+			got = append(got, hdr.Name+"->"+hdr.Linkname)
+		case hdr.Typeflag == tar.TypeDir || strings.Contains(hdr.Name, whiteoutPrefix):
+			got = append(got, hdr.Name)
+		default:
+			got = append(got, hdr.Name+"="+string(content))
+		}
+	}
+}
+
+func TestSquashLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers [][]entry
+		want   []string
+	}{
+		{
+			name:   "single layer",
+			layers: [][]entry{{{name: "app/"}, {name: "app/a", content: "a"}}},
+			want:   []string{"app/", "app/a=a"},
+		},
+		{
+			name: "file replaced",
+			layers: [][]entry{
+				{{name: "a", content: "old"}, {name: "b", content: "b"}},
+				{{name: "./a", content: "new"}},
+			},
+			want: []string{"b=b", "./a=new"},
+		},
+		{
+			name: "file removed by whiteout",
+			layers: [][]entry{
+				{{name: "app/a", content: "a"}, {name: "app/b", content: "b"}},
+				{{name: "app/.wh.a"}},
+			},
+			want: []string{"app/.wh.a", "app/b=b"},
+		},
+		{
+			name: "whiteout of lower layer",
+			layers: [][]entry{
+				{{name: "app/b", content: "b"}},
+				{{name: "etc/.wh.passwd"}},
+			},
+			want: []string{"etc/.wh.passwd", "app/b=b"},
+		},
+		{
+			name: "file written over whiteout",
+			layers: [][]entry{
+				{{name: "etc/.wh.passwd"}},
+				{{name: "etc/passwd", content: "root"}},
+			},
+			want: []string{"etc/passwd=root"},
+		},
+		{
+			name: "directory written over whiteout is opaque",
+			layers: [][]entry{
+				{{name: ".wh.cache"}},
+				{{name: "cache/"}, {name: "cache/b", content: "b"}},
+			},
+			want: []string{"cache/.wh..wh..opq", "cache/", "cache/b=b"},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]entry{
+				{{name: "cache/"}, {name: "cache/a", content: "a"}, {name: "cache/sub/.wh.x"}},
+				{{name: "cache/.wh..wh..opq"}, {name: "cache/b", content: "b"}},
+			},
+			want: []string{"cache/.wh..wh..opq", "cache/", "cache/b=b"},
+		},
+		{
+			name: "file replaces directory",
+			layers: [][]entry{
+				{{name: "lib/"}, {name: "lib/a", content: "a"}},
+				{{name: "lib", content: "file"}},
+			},
+			want: []string{"lib=file"},
+		},
+		{
+			name: "hardlink to file of same layer",
+			layers: [][]entry{
+				{{name: "a", content: "a"}, {name: "b", linkname: "a"}},
+				{{name: "c", content: "c"}},
+			},
+			want: []string{"a=a", "b->a", "c=c"},
+		},
+		{
+			name: "hardlink to file of lower layer",
+			layers: [][]entry{
+				{{name: "a", content: "a"}},
+				{{name: "b", linkname: "a"}},
+			},
+			want: []string{"a=a", "b->a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers []string
+			for _, entries := range tt.layers {
+				layers = append(layers, writeLayer(t, entries...))
+			}
+			got, err := squashed(t, layers...)
+			if err != nil {
+				t.Fatalf("squashLayers() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("squashLayers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSquashLayersErrors(t *testing.T) {
+	replacedTarget := []string{
+		writeLayer(t, entry{name: "a", content: "a"}, entry{name: "b", linkname: "a"}),
+		writeLayer(t, entry{name: "a", content: "new"}),
+	}
+	missing := filepath.Join(t.TempDir(), "missing.tar")
+	tests := []struct {
+		name    string
+		layers  []string
+		wantErr string
+	}{
+		{name: "hardlink target replaced", layers: replacedTarget, wantErr: "cannot squash hardlink b: its target a is replaced by a higher layer"},
+		{name: "missing layer", layers: []string{missing}, wantErr: "reading layer " + missing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := squashLayers(tt.layers, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("squashLayers() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAnnotationsFlag(t *testing.T) {
+	annotations := make(annotationsFlag)
+	for _, value := range []string{"b=2", " a = 1 ", "c=x=y"} {
+		if err := annotations.Set(value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+	if got, want := annotations.String(), "a=1,b=2,c=x=y"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	for _, value := range []string{"novalue", "=1"} {
+		if err := annotations.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", value)
+		}
+	}
+}
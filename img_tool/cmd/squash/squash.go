@@ -0,0 +1,177 @@
+package squash
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/compress"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/diagnostics"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// Config holds the options of a squash invocation.
+type Config struct {
+	// Name of the squashed layer. Defaults to the digest.
+	Name string
+	// Format is the format of the squashed layer ("tar", "gzip" or "zstd").
+	Format           string
+	Estargz          bool
+	CompressorJobs   string
+	CompressionLevel int
+	// ExternalCompressor is the optional path of a pigz or zstd binary.
+	ExternalCompressor string
+	Annotations        map[string]string
+	// Layers are the blobs of the layers to squash, from lowest to highest.
+	Layers []string
+	// Output is the path of the squashed layer.
+	Output string
+	// MetadataOutput optionally receives the layer metadata.
+	MetadataOutput string
+}
+
+// Runner squashes consecutive layers into one.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
+
+func SquashProcess(ctx context.Context, args []string) {
+	cfg := Config{Annotations: make(annotationsFlag)}
+	flagSet := flag.NewFlagSet("squash", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Squashes consecutive layers into a single layer.\n\n")
+		fmt.Fprintf(flagSet.Output(), "The layers are merged like in a container: files replaced or removed by higher layers are dropped, ")
+		fmt.Fprintf(flagSet.Output(), "and whiteouts are kept where they may remove files of the layers below the squashed layers.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img squash [--name name] [--format format] [--metadata=metadata_output_file] --output output [layer...]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img squash --format gzip --output squashed.tgz layer1.tgz layer2.tar layer3.tar.zst",
+			"img squash --format zstd --metadata squashed.json --output squashed.tar.zst layer1.tgz layer2.tgz",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+		os.Exit(1)
+	}
+	flagSet.StringVar(&cfg.Name, "name", "", `Optional name of the squashed layer. Defaults to digest.`)
+	flagSet.StringVar(&cfg.Format, "format", "gzip", `The format of the squashed layer. Can be "tar", "gzip" or "zstd".`)
+	flagSet.BoolVar(&cfg.Estargz, "estargz", false, `Use estargz format for compression. This creates seekable gzip streams optimized for lazy pulling.`)
+	flagSet.StringVar(&cfg.CompressorJobs, "compressor-jobs", "1", `Number of parallel gzip compressor jobs. The output is the same for any number of jobs. "nproc" uses NumCPU.`)
+	flagSet.IntVar(&cfg.CompressionLevel, "compression-level", -1, `Compression level. For gzip: 0-9. If unset, use library default.`)
+	flagSet.StringVar(&cfg.ExternalCompressor, "external-compressor", "", `Path of a pigz (for gzip) or zstd binary that compresses the layer with multiple threads. Estargz layers use the built-in compressors; a missing binary is an error.`)
+	flagSet.Var(annotationsFlag(cfg.Annotations), "annotation", `Add an annotation as key=value. Can be specified multiple times.`)
+	flagSet.StringVar(&cfg.Output, "output", "", `Path of the squashed layer (required).`)
+	flagSet.StringVar(&cfg.MetadataOutput, "metadata", "", `Write the metadata to the specified file. The metadata is a JSON file containing info needed to use the layer as part of an OCI image.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if flagSet.NArg() == 0 || cfg.Output == "" {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	cfg.Layers = flagSet.Args()
+	diagnostics.Set("format", cfg.Format)
+	diagnostics.Set("layers", strconv.Itoa(len(cfg.Layers)))
+
+	if err := NewRunner(cfg).Run(ctx); err != nil {
+		slog.Error("Squashing layers", logging.ErrKey, err)
+		diagnostics.Exit(1)
+	}
+}
+
+// Run squashes the layers and writes the layer metadata.
+func (r *Runner) Run(_ context.Context) error {
+	var algorithm api.CompressionAlgorithm
+	var mediaType api.LayerFormat
+	switch r.cfg.Format {
+	case "tar", "none", "uncompressed":
+		algorithm, mediaType = api.Uncompressed, api.TarLayer
+	case "gzip":
+		algorithm, mediaType = api.Gzip, api.TarGzipLayer
+	case "zstd":
+		algorithm, mediaType = api.Zstd, api.TarZstdLayer
+	default:
+		return fmt.Errorf("unsupported output format: %q", r.cfg.Format)
+	}
+
+	outputHandle, err := os.OpenFile(r.cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening output file: %w", err)
+	}
+	defer outputHandle.Close()
+
+	var opts []compress.Option
+	if r.cfg.CompressionLevel >= 0 {
+		opts = append(opts, compress.CompressionLevel(r.cfg.CompressionLevel))
+	}
+	if r.cfg.CompressorJobs == "nproc" {
+		opts = append(opts, compress.CompressorJobs(runtime.NumCPU()))
+	} else if n, err := strconv.Atoi(r.cfg.CompressorJobs); err == nil {
+		opts = append(opts, compress.CompressorJobs(n))
+	}
+	if r.cfg.ExternalCompressor != "" {
+		opts = append(opts, compress.ExternalCompressor(r.cfg.ExternalCompressor))
+	}
+	compressor, err := compress.TarAppenderFactory(string(api.SHA256), string(algorithm), r.cfg.Estargz, outputHandle, append(opts, compress.ContentType("tar"))...)
+	if err != nil {
+		return fmt.Errorf("creating compressor: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(squashLayers(r.cfg.Layers, pw))
+	}()
+	if err := compressor.AppendTar(pr); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	compressorState, err := compressor.Finalize()
+	if err != nil {
+		return fmt.Errorf("closing compressor: %w", err)
+	}
+
+	if len(r.cfg.MetadataOutput) == 0 {
+		return nil
+	}
+	name := r.cfg.Name
+	if name == "" {
+		name = fmt.Sprintf("sha256:%x", compressorState.OuterHash)
+	}
+	annotations := maps.Clone(r.cfg.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	// layer annotations of the compressor (e.g., estargz annotations) take precedence
+	maps.Copy(annotations, compressorState.LayerAnnotations)
+	metadata := api.Descriptor{
+		Name:        name,
+		DiffID:      fmt.Sprintf("sha256:%x", compressorState.ContentHash),
+		MediaType:   string(mediaType),
+		Digest:      fmt.Sprintf("sha256:%x", compressorState.OuterHash),
+		Size:        compressorState.CompressedSize,
+		Annotations: annotations,
+	}
+	metadataRaw, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+	return os.WriteFile(r.cfg.MetadataOutput, metadataRaw, 0o644)
+}
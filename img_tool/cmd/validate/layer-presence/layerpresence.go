@@ -4,23 +4,46 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
-var (
-	layerMetadataArgs layerMetadata
-	outputs           validationOutputs
-)
+// ErrLayersMissing is returned by Run if required layers are missing or misordered.
+var ErrLayersMissing = errors.New("required layers are missing from the image")
+
+// Config holds the options of a layer-presence validation.
+type Config struct {
+	// LayerMetadata maps the index of every layer in the image to its metadata.
+	LayerMetadata map[int]api.Descriptor
+	// RequiredLayersParamFile is the path of the file listing the required layers of every layer.
+	RequiredLayersParamFile string
+	// Output receives the validation result.
+	Output io.Writer
+}
+
+// Runner checks the presence of required layers in an image.
+type Runner struct {
+	cfg Config
+}
+
+// NewRunner returns a runner for the given config.
+func NewRunner(cfg Config) *Runner {
+	return &Runner{cfg: cfg}
+}
 
-func LayerPresenceProcess(_ context.Context, args []string) {
+func LayerPresenceProcess(ctx context.Context, args []string) {
+	var layerMetadataArgs layerMetadata
+	var outputs validationOutputs
 	flagSet := flag.NewFlagSet("layer-presence", flag.ExitOnError)
 	flagSet.Usage = func() {
 		fmt.Fprintf(flagSet.Output(), "Checks the presence of required layers in an image.\nLayers are required if they have been used to deduplicate content in present layers.\n\n")
@@ -37,33 +60,53 @@ func LayerPresenceProcess(_ context.Context, args []string) {
 	}
 	flagSet.Var(&layerMetadataArgs, "layer-metadata", `Key-value pairs of layer index number and associated layer metadata file (as produced by "img layer --metadata").`)
 	flagSet.Var(&outputs, "file", `Write validation result to a file. "-" writes the output to stdout.`)
+	logging.RegisterFlags(flagSet)
 
 	if err := flagSet.Parse(args); err != nil {
 		flagSet.Usage()
 		os.Exit(1)
 	}
-	defer func() {
+	closeOutputs := func() {
 		for _, closer := range outputs {
 			closer.Close()
 		}
-	}()
+	}
 	var writers []io.Writer
 	for _, w := range outputs {
 		writers = append(writers, w)
 	}
-	output := io.MultiWriter(writers...)
 	if flagSet.NArg() != 1 {
-		fmt.Fprintln(os.Stderr, "Missing positional argument for required layers parameter file")
+		slog.Error("Missing positional argument for required layers parameter file")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
-	requiredLayers, err := parseParamFile(strings.TrimPrefix(flagSet.Arg(0), "@"))
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+	err := NewRunner(Config{
+		LayerMetadata:           layerMetadataArgs,
+		RequiredLayersParamFile: strings.TrimPrefix(flagSet.Arg(0), "@"),
+		Output:                  io.MultiWriter(writers...),
+	}).Run(ctx)
+	closeOutputs()
+	if errors.Is(err, ErrLayersMissing) {
+		// the report was already written
 		os.Exit(1)
+	} else if err != nil {
+		logging.Fatal("Validating layer presence", logging.ErrKey, err)
+	}
+}
+
+// Run validates the layers and writes the result to the output.
+// If layers are missing, the report is also written to stderr and ErrLayersMissing is returned.
+func (r *Runner) Run(_ context.Context) error {
+	output := r.cfg.Output
+	if output == nil {
+		output = io.Discard
+	}
+	requiredLayers, err := parseParamFile(r.cfg.RequiredLayersParamFile)
+	if err != nil {
+		return err
 	}
-	presence := findMissingLayers(requiredLayers)
+	presence := findMissingLayers(r.cfg.LayerMetadata, requiredLayers)
 	var failed bool
 	for _, layerPresence := range presence {
 		if len(layerPresence.missingLayers) > 0 || len(layerPresence.misorderedLayers) > 0 {
@@ -73,7 +116,7 @@ func LayerPresenceProcess(_ context.Context, args []string) {
 	}
 	if !failed {
 		fmt.Fprintln(output, "ok")
-		os.Exit(0)
+		return nil
 	}
 	output = io.MultiWriter(output, os.Stderr)
 	for _, layerPresence := range presence {
@@ -89,10 +132,10 @@ func LayerPresenceProcess(_ context.Context, args []string) {
 			fmt.Fprintf(output, "  depends on layer %s, which is present but needs to be placed earlier in the list of layers\n", misordered.Name)
 		}
 	}
-	os.Exit(1)
+	return ErrLayersMissing
 }
 
-func findMissingLayers(requiredLayersForLayer map[int][]api.Descriptor) []layerPresenceInfo {
+func findMissingLayers(layerMetadataArgs map[int]api.Descriptor, requiredLayersForLayer map[int][]api.Descriptor) []layerPresenceInfo {
 	indices := make([]int, 0, len(layerMetadataArgs))
 	for index := range layerMetadataArgs {
 		indices = append(indices, index)
@@ -0,0 +1,315 @@
+package oci
+
+import (
+	// sha256 and sha512 are the registered digest algorithms of the OCI image spec
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	godigest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerConfigMediaType       = "application/vnd.docker.container.image.v1+json"
+)
+
+var (
+	hexPattern = regexp.MustCompile(`^[a-f0-9]+$`)
+	// hexDigestSizes are the lengths of the hex-encoded digests of the registered algorithms of the OCI image spec.
+	hexDigestSizes = map[string]int{"sha256": 64, "sha512": 128}
+	// mediaTypePattern is the media type grammar of RFC 6838.
+	mediaTypePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+	manifestMediaTypes = []string{specv1.MediaTypeImageManifest, dockerManifestMediaType}
+	indexMediaTypes    = []string{specv1.MediaTypeImageIndex, dockerManifestListMediaType}
+	configMediaTypes   = []string{specv1.MediaTypeImageConfig, dockerConfigMediaType}
+)
+
+// validator collects all problems instead of stopping at the first one,
+// so that a single run reports everything that needs fixing.
+type validator struct {
+	problems []string
+}
+
+func (v *validator) errorf(format string, args ...any) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) descriptor(where string, desc specv1.Descriptor) {
+	if desc.MediaType == "" {
+		v.errorf("%s: mediaType is required", where)
+	} else if !mediaTypePattern.MatchString(desc.MediaType) {
+		v.errorf("%s: mediaType %q is not a valid media type (RFC 6838)", where, desc.MediaType)
+	}
+	v.digest(where, desc.Digest.String())
+	if desc.Size < 0 {
+		v.errorf("%s: size must not be negative, got %d", where, desc.Size)
+	}
+	if desc.Platform != nil {
+		if desc.Platform.OS == "" {
+			v.errorf("%s: platform.os is required when platform is set", where)
+		}
+		if desc.Platform.Architecture == "" {
+			v.errorf("%s: platform.architecture is required when platform is set", where)
+		}
+	}
+}
+
+func (v *validator) digest(where, digest string) {
+	if digest == "" {
+		v.errorf("%s: digest is required", where)
+		return
+	}
+	if !api.DigestPattern.MatchString(digest) {
+		v.errorf("%s: digest %q does not match the OCI digest grammar (algorithm:encoded)", where, digest)
+		return
+	}
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	if size, ok := hexDigestSizes[algorithm]; ok && (len(encoded) != size || !hexPattern.MatchString(encoded)) {
+		v.errorf("%s: %s digest %q must consist of %d lowercase hex characters", where, algorithm, digest, size)
+	}
+}
+
+// verifiable returns the algorithm of the descriptor digest if the content can be checked against it.
+func (v *validator) verifiable(where string, desc specv1.Descriptor) (godigest.Algorithm, bool) {
+	algorithm := desc.Digest.Algorithm()
+	if !algorithm.Available() {
+		v.errorf("%s: digest algorithm %q is not supported, the content cannot be verified", where, algorithm)
+		return "", false
+	}
+	return algorithm, true
+}
+
+// content checks that the descriptor matches the given raw content.
+func (v *validator) content(where string, desc specv1.Descriptor, raw []byte) {
+	if desc.Size != int64(len(raw)) {
+		v.errorf("%s: descriptor size is %d, but the content has %d bytes", where, desc.Size, len(raw))
+	}
+	algorithm, ok := v.verifiable(where, desc)
+	if !ok {
+		return
+	}
+	if actual := algorithm.FromBytes(raw); desc.Digest != actual {
+		v.errorf("%s: descriptor digest is %s, but the content hashes to %s", where, desc.Digest, actual)
+	}
+}
+
+// blobFile checks that the file at path matches the descriptor without reading it into memory.
+func (v *validator) blobFile(where string, desc specv1.Descriptor, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		v.errorf("%s: %v", where, err)
+		return
+	}
+	defer f.Close()
+	algorithm, verifiable := v.verifiable(where, desc)
+	if !verifiable {
+		// the size is still checked
+		algorithm = godigest.Canonical
+	}
+	digester := algorithm.Digester()
+	n, err := f.WriteTo(digester.Hash())
+	if err != nil {
+		v.errorf("%s: reading %s: %v", where, path, err)
+		return
+	}
+	if desc.Size != n {
+		v.errorf("%s: descriptor size is %d, but %s has %d bytes", where, desc.Size, path, n)
+	}
+	if actual := digester.Digest(); verifiable && desc.Digest != actual {
+		v.errorf("%s: descriptor digest is %s, but %s hashes to %s", where, desc.Digest, path, actual)
+	}
+}
+
+func (v *validator) versioned(where string, versioned specs.Versioned) {
+	if versioned.SchemaVersion != 2 {
+		v.errorf("%s: schemaVersion must be 2, got %d", where, versioned.SchemaVersion)
+	}
+}
+
+func (v *validator) manifest(where string, raw []byte) *specv1.Manifest {
+	var manifest specv1.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		v.errorf("%s: not a valid manifest: %v", where, err)
+		return nil
+	}
+	v.versioned(where, manifest.Versioned)
+	if manifest.MediaType != "" && !slices.Contains(manifestMediaTypes, manifest.MediaType) {
+		v.errorf("%s: mediaType %q is not an image manifest media type (expected one of %s)", where, manifest.MediaType, strings.Join(manifestMediaTypes, ", "))
+	}
+	v.descriptor(where+": config", manifest.Config)
+	if manifest.ArtifactType == "" && !slices.Contains(configMediaTypes, manifest.Config.MediaType) {
+		v.errorf("%s: config mediaType %q is not an image config media type; artifacts must set artifactType", where, manifest.Config.MediaType)
+	}
+	for i, layer := range manifest.Layers {
+		v.descriptor(fmt.Sprintf("%s: layers[%d]", where, i), layer)
+	}
+	if manifest.Subject != nil {
+		v.descriptor(where+": subject", *manifest.Subject)
+	}
+	return &manifest
+}
+
+func (v *validator) config(where string, raw []byte, manifest *specv1.Manifest) {
+	var config specv1.Image
+	if err := json.Unmarshal(raw, &config); err != nil {
+		v.errorf("%s: not a valid image config: %v", where, err)
+		return
+	}
+	if config.Architecture == "" {
+		v.errorf("%s: architecture is required", where)
+	}
+	if config.OS == "" {
+		v.errorf("%s: os is required", where)
+	}
+	if config.RootFS.Type != "layers" {
+		v.errorf("%s: rootfs.type must be \"layers\", got %q", where, config.RootFS.Type)
+	}
+	for i, diffID := range config.RootFS.DiffIDs {
+		v.digest(fmt.Sprintf("%s: rootfs.diff_ids[%d]", where, i), diffID.String())
+	}
+	if manifest == nil {
+		return
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		v.errorf("%s: rootfs.diff_ids has %d entries, but the manifest has %d layers", where, len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	if len(config.History) > 0 {
+		var nonEmpty int
+		for _, entry := range config.History {
+			if !entry.EmptyLayer {
+				nonEmpty++
+			}
+		}
+		if nonEmpty != len(manifest.Layers) {
+			v.errorf("%s: history has %d entries without empty_layer, but the manifest has %d layers (set empty_layer for history entries that did not create a layer)", where, nonEmpty, len(manifest.Layers))
+		}
+	}
+}
+
+func (v *validator) index(where string, raw []byte) *specv1.Index {
+	var index specv1.Index
+	if err := json.Unmarshal(raw, &index); err != nil {
+		v.errorf("%s: not a valid index: %v", where, err)
+		return nil
+	}
+	v.versioned(where, index.Versioned)
+	if index.MediaType != "" && !slices.Contains(indexMediaTypes, index.MediaType) {
+		v.errorf("%s: mediaType %q is not an image index media type (expected one of %s)", where, index.MediaType, strings.Join(indexMediaTypes, ", "))
+	}
+	for i, manifest := range index.Manifests {
+		v.descriptor(fmt.Sprintf("%s: manifests[%d]", where, i), manifest)
+	}
+	if index.Subject != nil {
+		v.descriptor(where+": subject", *index.Subject)
+	}
+	return &index
+}
+
+// layout validates an OCI layout directory and all blobs reachable from its index.
+func (v *validator) layout(dir string, allowMissingBlobs bool) {
+	rawLayout, err := os.ReadFile(filepath.Join(dir, specv1.ImageLayoutFile))
+	if err != nil {
+		v.errorf("%s: %v", dir, err)
+	} else {
+		var layout specv1.ImageLayout
+		if err := json.Unmarshal(rawLayout, &layout); err != nil {
+			v.errorf("%s: not valid JSON: %v", specv1.ImageLayoutFile, err)
+		} else if layout.Version != specv1.ImageLayoutVersion {
+			v.errorf("%s: imageLayoutVersion must be %q, got %q", specv1.ImageLayoutFile, specv1.ImageLayoutVersion, layout.Version)
+		}
+	}
+
+	rawIndex, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		v.errorf("%s: %v", dir, err)
+		return
+	}
+	index := v.index("index.json", rawIndex)
+	if index == nil {
+		return
+	}
+	for i, desc := range index.Manifests {
+		v.layoutDescriptor(dir, fmt.Sprintf("index.json: manifests[%d]", i), desc, allowMissingBlobs)
+	}
+}
+
+func (v *validator) layoutDescriptor(dir, where string, desc specv1.Descriptor, allowMissingBlobs bool) {
+	blobPath, ok := v.blobPath(dir, where, desc)
+	if !ok {
+		return
+	}
+	switch {
+	case slices.Contains(indexMediaTypes, desc.MediaType):
+		raw, ok := v.readBlob(where, desc, blobPath)
+		if !ok {
+			return
+		}
+		index := v.index(where, raw)
+		if index == nil {
+			return
+		}
+		for i, child := range index.Manifests {
+			v.layoutDescriptor(dir, fmt.Sprintf("%s: manifests[%d]", where, i), child, allowMissingBlobs)
+		}
+	case slices.Contains(manifestMediaTypes, desc.MediaType):
+		raw, ok := v.readBlob(where, desc, blobPath)
+		if !ok {
+			return
+		}
+		manifest := v.manifest(where, raw)
+		if manifest == nil {
+			return
+		}
+		configWhere := where + ": config"
+		if configPath, ok := v.blobPath(dir, configWhere, manifest.Config); ok {
+			if rawConfig, ok := v.readBlob(configWhere, manifest.Config, configPath); ok && slices.Contains(configMediaTypes, manifest.Config.MediaType) {
+				v.config(configWhere, rawConfig, manifest)
+			}
+		}
+		for i, layer := range manifest.Layers {
+			layerWhere := fmt.Sprintf("%s: layers[%d]", where, i)
+			layerPath, ok := v.blobPath(dir, layerWhere, layer)
+			if !ok {
+				continue
+			}
+			if _, err := os.Stat(layerPath); os.IsNotExist(err) && allowMissingBlobs {
+				continue
+			}
+			v.blobFile(layerWhere, layer, layerPath)
+		}
+	default:
+		v.blobFile(where, desc, blobPath)
+	}
+}
+
+func (v *validator) blobPath(dir, where string, desc specv1.Descriptor) (string, bool) {
+	algorithm, encoded, ok := strings.Cut(desc.Digest.String(), ":")
+	if !ok || !api.DigestPattern.MatchString(desc.Digest.String()) {
+		v.errorf("%s: cannot locate blob with invalid digest %q", where, desc.Digest)
+		return "", false
+	}
+	return filepath.Join(dir, "blobs", algorithm, encoded), true
+}
+
+func (v *validator) readBlob(where string, desc specv1.Descriptor, path string) ([]byte, bool) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		v.errorf("%s: %v", where, err)
+		return nil, false
+	}
+	v.content(where, desc, raw)
+	return raw, true
+}
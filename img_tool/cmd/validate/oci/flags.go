@@ -0,0 +1,15 @@
+package oci
+
+import "fmt"
+
+// stringSliceFlag is a custom flag type for string slices
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
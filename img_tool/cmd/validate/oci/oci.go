@@ -0,0 +1,160 @@
+package oci
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+
+	godigest "github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+func OCIProcess(_ context.Context, args []string) {
+	var manifestPath, configPath, indexPath, layoutPath, outputPath string
+	var manifestFiles, layerFiles stringSliceFlag
+	var allowMissingBlobs bool
+
+	flagSet := flag.NewFlagSet("oci", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Validates manifests, configs, indexes, and OCI layouts against the OCI image spec.\n")
+		fmt.Fprintf(flagSet.Output(), "Checks required fields, media types, digest correctness, and that the number of diff_ids matches the number of layers.\n\n")
+		fmt.Fprintf(flagSet.Output(), "Usage: img validate oci [OPTIONS]\n")
+		flagSet.PrintDefaults()
+		examples := []string{
+			"img validate oci --manifest manifest.json --config config.json",
+			"img validate oci --manifest manifest.json --config config.json --layer layer0.tar.gz --layer layer1.tar.gz",
+			"img validate oci --index index.json --index-manifest linux_amd64_manifest.json --index-manifest linux_arm64_manifest.json",
+			"img validate oci --oci-layout path/to/layout",
+		}
+		fmt.Fprintf(flagSet.Output(), "\nExamples:\n")
+		for _, example := range examples {
+			fmt.Fprintf(flagSet.Output(), "  $ %s\n", example)
+		}
+	}
+	flagSet.StringVar(&manifestPath, "manifest", "", "Path to an image manifest.")
+	flagSet.StringVar(&configPath, "config", "", "Path to the image config referenced by --manifest.")
+	flagSet.Var(&layerFiles, "layer", "Path to a layer blob referenced by --manifest, in the order of the manifest (can be specified multiple times). Enables digest and size checks for layers.")
+	flagSet.StringVar(&indexPath, "index", "", "Path to an image index.")
+	flagSet.Var(&manifestFiles, "index-manifest", "Path to a manifest referenced by --index (can be specified multiple times). Enables digest and size checks for manifests of the index.")
+	flagSet.StringVar(&layoutPath, "oci-layout", "", "Path to an OCI layout directory. All blobs reachable from index.json are validated.")
+	flagSet.BoolVar(&allowMissingBlobs, "allow-missing-blobs", false, "Do not report missing layer blobs in an OCI layout (for layouts of shallow pulled images).")
+	flagSet.StringVar(&outputPath, "file", "", `Write the validation result to a file in addition to stderr.`)
+	logging.RegisterFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if manifestPath == "" && indexPath == "" && layoutPath == "" {
+		slog.Error("At least one of --manifest, --index, or --oci-layout is required")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if configPath != "" && manifestPath == "" {
+		logging.Fatal("--config requires --manifest")
+	}
+	if len(layerFiles) > 0 && manifestPath == "" {
+		logging.Fatal("--layer requires --manifest")
+	}
+	if len(manifestFiles) > 0 && indexPath == "" {
+		logging.Fatal("--index-manifest requires --index")
+	}
+
+	v := &validator{}
+	if manifestPath != "" {
+		validateManifestFiles(v, manifestPath, configPath, layerFiles)
+	}
+	if indexPath != "" {
+		validateIndexFiles(v, indexPath, manifestFiles)
+	}
+	if layoutPath != "" {
+		v.layout(layoutPath, allowMissingBlobs)
+	}
+
+	output := io.Writer(os.Stderr)
+	if outputPath != "" {
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			logging.Fatal("Creating output file", logging.ErrKey, err)
+		}
+		defer outputFile.Close()
+		output = io.MultiWriter(os.Stderr, outputFile)
+	}
+
+	if len(v.problems) == 0 {
+		fmt.Fprintln(output, "ok")
+		return
+	}
+	fmt.Fprintf(output, "found %d problem(s):\n", len(v.problems))
+	for _, problem := range v.problems {
+		fmt.Fprintf(output, "  %s\n", problem)
+	}
+	os.Exit(1)
+}
+
+func validateManifestFiles(v *validator, manifestPath, configPath string, layerFiles []string) {
+	raw, err := os.ReadFile(manifestPath)
+	if err != nil {
+		v.errorf("%s: %v", manifestPath, err)
+		return
+	}
+	manifest := v.manifest(manifestPath, raw)
+	if manifest == nil {
+		return
+	}
+	if configPath != "" {
+		rawConfig, err := os.ReadFile(configPath)
+		if err != nil {
+			v.errorf("%s: %v", configPath, err)
+		} else {
+			v.content(manifestPath+": config", manifest.Config, rawConfig)
+			v.config(configPath, rawConfig, manifest)
+		}
+	}
+	if len(layerFiles) == 0 {
+		return
+	}
+	if len(layerFiles) != len(manifest.Layers) {
+		v.errorf("%s: got %d layer files, but the manifest has %d layers", manifestPath, len(layerFiles), len(manifest.Layers))
+		return
+	}
+	for i, layer := range manifest.Layers {
+		v.blobFile(fmt.Sprintf("%s: layers[%d]", manifestPath, i), layer, layerFiles[i])
+	}
+}
+
+func validateIndexFiles(v *validator, indexPath string, manifestFiles []string) {
+	raw, err := os.ReadFile(indexPath)
+	if err != nil {
+		v.errorf("%s: %v", indexPath, err)
+		return
+	}
+	index := v.index(indexPath, raw)
+	if index == nil {
+		return
+	}
+	for _, manifestFile := range manifestFiles {
+		rawManifest, err := os.ReadFile(manifestFile)
+		if err != nil {
+			v.errorf("%s: %v", manifestFile, err)
+			continue
+		}
+		// the manifest is hashed with the algorithm of each descriptor
+		i := slices.IndexFunc(index.Manifests, func(desc specv1.Descriptor) bool {
+			return desc.Digest.Algorithm().Available() && desc.Digest.Algorithm().FromBytes(rawManifest) == desc.Digest
+		})
+		if i < 0 {
+			v.errorf("%s: manifest with digest %s is not referenced by index %s", manifestFile, godigest.FromBytes(rawManifest), indexPath)
+			continue
+		}
+		desc := index.Manifests[i]
+		v.content(fmt.Sprintf("%s: descriptor of %s", indexPath, manifestFile), desc, rawManifest)
+		v.manifest(manifestFile, rawManifest)
+	}
+}
@@ -0,0 +1,19 @@
+// Package humansize formats sizes in bytes for humans.
+package humansize
+
+import "fmt"
+
+// Format formats a size in bytes with binary units, like "1.5 MiB".
+// Sizes below 1 KiB are written in bytes, like "512 B".
+func Format(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,23 @@
+package humansize
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 << 20, "10.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{1 << 40, "1.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.size); got != tt.want {
+			t.Errorf("Format(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
@@ -18,6 +18,8 @@ const (
 	Uncompressed CompressionAlgorithm = "uncompressed"
 	Gzip         CompressionAlgorithm = "gzip"
 	Zstd         CompressionAlgorithm = "zstd"
+	// Xz is only supported for reading input archives, not for layers.
+	Xz CompressionAlgorithm = "xz"
 
 	// Hash algorithms
 	SHA256 HashAlgorithm = "sha256"
@@ -68,6 +70,8 @@ type Descriptor struct {
 	Digest      string            `json:"digest"`
 	Size        int64             `json:"size"`
 	Annotations map[string]string `json:"annotations,omitempty"`
+	// URLs lists locations a foreign (non-distributable) layer may be downloaded from.
+	URLs []string `json:"urls,omitempty"`
 }
 
 type AppenderState struct {
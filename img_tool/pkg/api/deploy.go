@@ -101,8 +101,8 @@ type IndexedPushDeployOperation struct {
 
 type LoadDeployOperation struct {
 	BaseCommandOperation
-	Tag    string `json:"tag,omitempty"`
-	Daemon string `json:"daemon,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Daemon string   `json:"daemon,omitempty"`
 }
 
 type IndexedLoadDeployOperation struct {
@@ -115,6 +115,7 @@ type PushTarget struct {
 	Registry   string   `json:"registry"`
 	Repository string   `json:"repository"`
 	Tags       []string `json:"tags,omitempty"`
+	Webhooks   []string `json:"webhooks,omitempty"`
 }
 
 type PullInfo struct {
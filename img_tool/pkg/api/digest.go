@@ -0,0 +1,7 @@
+package api
+
+import "regexp"
+
+// DigestPattern is the digest grammar of the OCI image spec (algorithm:encoded).
+// It doesn't check that the algorithm is known or that the encoded part has the right length.
+var DigestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
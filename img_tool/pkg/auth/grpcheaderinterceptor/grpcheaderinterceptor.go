@@ -2,15 +2,15 @@ package grpcheaderinterceptor
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net/url"
-	"os"
 	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/credential"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 type authenticatingInterceptor struct {
@@ -46,13 +46,13 @@ func (i *authenticatingInterceptor) streamAddHeaders(ctx context.Context, desc *
 func addCredentialsToMD(ctx context.Context, target, method string, md metadata.MD, helper credential.Helper) metadata.MD {
 	hostname, ok := strings.CutPrefix(target, "dns:")
 	if !ok {
-		fmt.Fprintf(os.Stderr, "WARNING: authenticating gRPC: unknown target definition %s\n", target)
+		slog.Warn("Authenticating gRPC: unknown target definition", "target", target)
 		return md
 	}
 
 	methodParts := strings.Split(method, "/")
 	if len(methodParts) < 2 || len(methodParts[0]) != 0 {
-		fmt.Fprintf(os.Stderr, "WARNING: authenticating gRPC: unknown method definition %s\n", method)
+		slog.Warn("Authenticating gRPC: unknown method definition", "method", method)
 		return md
 	}
 
@@ -63,11 +63,11 @@ func addCredentialsToMD(ctx context.Context, target, method string, md metadata.
 	}
 	headers, _, err := helper.Get(ctx, u.String())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "WARNING: authenticating gRPC: failed to get credentials for %s: %v\n", u.String(), err)
+		slog.Warn("Authenticating gRPC: failed to get credentials", "uri", u.String(), logging.ErrKey, err)
 		return md
 	}
 	if len(headers) == 0 {
-		fmt.Fprintf(os.Stderr, "WARNING: authenticating gRPC: credential helper found no headers for %s - trying unauthenticated connection\n", u.String())
+		slog.Warn("Authenticating gRPC: credential helper found no headers, trying unauthenticated connection", "uri", u.String())
 		return md
 	}
 
@@ -2,7 +2,7 @@ package protohelper
 
 import (
 	"fmt"
-	"os"
+	"log/slog"
 	"slices"
 	"strings"
 	"sync"
@@ -47,7 +47,7 @@ func warnUnencryptedGRPC(uri string) {
 		return
 	}
 	WarnedURIs[uri] = struct{}{}
-	fmt.Fprintf(os.Stderr, "WARNING: using unencrypted grpc connection to %s - please consider using grpcs instead", uri)
+	slog.Warn("Using unencrypted gRPC connection, please consider using grpcs instead", "uri", uri)
 }
 
 // WarnedURIs is a set of URIs that have already been warned about.
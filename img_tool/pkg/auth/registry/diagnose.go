@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// AuthError is returned by Diagnose for requests that were rejected by a registry with 401 or 403.
+// Its message explains how credentials were resolved for the registry and suggests fixes.
+type AuthError struct {
+	Registry   string
+	StatusCode int
+	Err        error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%v\n%s", e.Err, Diagnosis(e.Registry, e.StatusCode))
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnose turns authentication failures (HTTP 401 and 403) of registry requests into an *AuthError.
+// Other errors are returned unchanged.
+func Diagnose(err error) error {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return err
+	}
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return err
+	}
+	if transportErr.StatusCode != http.StatusUnauthorized && transportErr.StatusCode != http.StatusForbidden {
+		return err
+	}
+	var host string
+	if transportErr.Request != nil && transportErr.Request.URL != nil {
+		host = transportErr.Request.URL.Host
+	}
+	return &AuthError{Registry: diagnostics.registryFor(host), StatusCode: transportErr.StatusCode, Err: err}
+}
+
+// Diagnosis describes how credentials for the registry were resolved in this process
+// and what the registry answered, followed by suggestions to fix the authentication.
+func Diagnosis(registry string, statusCode int) string {
+	diagnostics.mu.Lock()
+	defer diagnostics.mu.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Authentication to %s failed with HTTP %d %s.\n", registry, statusCode, http.StatusText(statusCode))
+	attempts := diagnostics.attempts[registry]
+	if len(attempts) == 0 {
+		sb.WriteString("No credentials were looked up for this registry.\n")
+	} else {
+		sb.WriteString("Credentials tried:\n")
+		for _, attempt := range attempts {
+			fmt.Fprintf(&sb, "  - %s\n", attempt)
+		}
+	}
+	response, hasResponse := diagnostics.responses[registry]
+	if hasResponse {
+		if response.withCredentials {
+			sb.WriteString("The last rejected request was sent with credentials.")
+		} else {
+			sb.WriteString("The last rejected request was sent without credentials.")
+		}
+		if response.challenge != "" {
+			fmt.Fprintf(&sb, " Challenge: %s", response.challenge)
+		}
+		sb.WriteString("\n")
+	}
+
+	suggestions := diagnostics.hints[registry]
+	foundCredentials := diagnostics.found[registry]
+	switch {
+	case statusCode == http.StatusForbidden:
+		suggestions = append(suggestions, "The registry accepted the credentials, but they don't grant access. Check the repository name and that the account may pull (or push to) the repository.")
+	case foundCredentials:
+		suggestions = append(suggestions, "The registry rejected the credentials. They may be expired or belong to another account: log in again or refresh the token of the credential helper.")
+	default:
+		suggestions = append(suggestions,
+			fmt.Sprintf("No credentials were found. Run \"docker login %s\" or configure a credential helper for %s in credHelpers of $DOCKER_CONFIG/config.json (default: ~/.docker/config.json).", registry, registry),
+			"Some registries answer 401 for repositories that don't exist, so check the repository name as well.",
+		)
+	}
+	sb.WriteString("Suggestions:")
+	for _, suggestion := range suggestions {
+		fmt.Fprintf(&sb, "\n  - %s", suggestion)
+	}
+	return sb.String()
+}
+
+// authDiagnostics collects information about authentication for the registries used in this process.
+type authDiagnostics struct {
+	mu sync.Mutex
+	// attempts describes the result of each keychain per registry.
+	attempts map[string][]string
+	// hints are suggestions found while resolving credentials (e.g., a missing credential helper).
+	hints map[string][]string
+	// found is true if any keychain returned credentials for the registry.
+	found map[string]bool
+	// responses holds the last 401 or 403 response per host.
+	responses map[string]authResponse
+	// lastRegistry is the registry credentials were last resolved for.
+	// It is used for failed requests to token servers, which usually live on another host.
+	lastRegistry string
+}
+
+type authResponse struct {
+	withCredentials bool
+	challenge       string
+}
+
+var diagnostics = &authDiagnostics{
+	attempts:  make(map[string][]string),
+	hints:     make(map[string][]string),
+	found:     make(map[string]bool),
+	responses: make(map[string]authResponse),
+}
+
+func (d *authDiagnostics) attempt(registry, attempt string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastRegistry = registry
+	if slices.Contains(d.attempts[registry], attempt) {
+		// keychains are consulted for every request
+		return
+	}
+	d.attempts[registry] = append(d.attempts[registry], attempt)
+}
+
+func (d *authDiagnostics) hint(registry, hint string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !slices.Contains(d.hints[registry], hint) {
+		d.hints[registry] = append(d.hints[registry], hint)
+	}
+}
+
+func (d *authDiagnostics) registryFor(host string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.attempts[host]; ok || d.lastRegistry == "" {
+		return host
+	}
+	return d.lastRegistry
+}
+
+// recordingKeychain records the result of a keychain for diagnostics.
+type recordingKeychain struct {
+	name     string
+	keychain authn.Keychain
+}
+
+func (k recordingKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return k.ResolveContext(context.Background(), target)
+}
+
+func (k recordingKeychain) ResolveContext(ctx context.Context, target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	auth, err := authn.Resolve(ctx, k.keychain, target)
+	if err != nil {
+		diagnostics.attempt(registry, fmt.Sprintf("%s: %v", k.name, err))
+		return auth, err
+	}
+	if auth == authn.Anonymous {
+		diagnostics.attempt(registry, fmt.Sprintf("%s: no credentials", k.name))
+		return auth, nil
+	}
+	diagnostics.attempt(registry, fmt.Sprintf("%s: %s", k.name, describeAuthenticator(ctx, auth)))
+	diagnostics.mu.Lock()
+	diagnostics.found[registry] = true
+	diagnostics.mu.Unlock()
+	return auth, nil
+}
+
+// describeAuthenticator describes the kind of credentials without revealing secrets.
+func describeAuthenticator(ctx context.Context, auth authn.Authenticator) string {
+	cfg, err := authn.Authorization(ctx, auth)
+	if err != nil {
+		return fmt.Sprintf("credentials could not be used: %v", err)
+	}
+	switch {
+	case cfg.IdentityToken != "":
+		return "identity token"
+	case cfg.RegistryToken != "":
+		return "registry token"
+	case cfg.Username != "":
+		return fmt.Sprintf("username %q", cfg.Username)
+	default:
+		return "credentials"
+	}
+}
+
+// diagnosingTransport records rejected requests for diagnostics.
+type diagnosingTransport struct {
+	inner http.RoundTripper
+}
+
+// Transport wraps inner and records responses with status 401 and 403,
+// so that Diagnose can tell whether credentials were sent and how the registry challenged the request.
+func Transport(inner http.RoundTripper) http.RoundTripper {
+	return &diagnosingTransport{inner: inner}
+}
+
+func (t *diagnosingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		diagnostics.mu.Lock()
+		diagnostics.responses[req.URL.Host] = authResponse{
+			withCredentials: req.Header.Get("Authorization") != "",
+			challenge:       resp.Header.Get("WWW-Authenticate"),
+		}
+		diagnostics.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// combineOptions combines several options into one.
+// The type parameter stands in for the unexported options type of the remote package.
+func combineOptions[T any](options ...func(T) error) func(T) error {
+	return func(o T) error {
+		for _, option := range options {
+			if err := option(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (k staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
+
+// rejectingRegistry answers every request with status and a basic auth challenge.
+func rejectingRegistry(t *testing.T, status int) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serverURL.Host
+}
+
+func getManifest(t *testing.T, host string, auth authn.Authenticator) error {
+	t.Helper()
+	ref, err := name.ParseReference(host + "/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.Get(ref,
+		remote.WithAuthFromKeychain(recordingKeychain{name: "test keychain", keychain: staticKeychain{auth: auth}}),
+		remote.WithTransport(Transport(http.DefaultTransport)),
+	)
+	if err == nil {
+		t.Fatal("request to the rejecting registry succeeded")
+	}
+	return err
+}
+
+func TestDiagnoseRejectedCredentials(t *testing.T) {
+	host := rejectingRegistry(t, http.StatusUnauthorized)
+	err := Diagnose(getManifest(t, host, authn.FromConfig(authn.AuthConfig{Username: "user", Password: "secret"})))
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Diagnose() = %v, want an *AuthError", err)
+	}
+	if authErr.Registry != host || authErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("AuthError = %s with %d, want %s with 401", authErr.Registry, authErr.StatusCode, host)
+	}
+	message := err.Error()
+	for _, want := range []string{
+		"Authentication to " + host + " failed with HTTP 401 Unauthorized.",
+		"Credentials tried:\n  - test keychain: username \"user\"\n",
+		`The last rejected request was sent with credentials. Challenge: Basic realm="test"`,
+		"The registry rejected the credentials.",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("diagnosis does not contain %q:\n%s", want, message)
+		}
+	}
+	if strings.Contains(message, "secret") {
+		t.Errorf("diagnosis reveals the password:\n%s", message)
+	}
+	// diagnosing twice keeps the error
+	if again := Diagnose(err); again != err {
+		t.Errorf("Diagnose() of an *AuthError = %v, want it unchanged", again)
+	}
+}
+
+func TestDiagnoseMissingCredentials(t *testing.T) {
+	host := rejectingRegistry(t, http.StatusUnauthorized)
+	message := Diagnose(getManifest(t, host, authn.Anonymous)).Error()
+	for _, want := range []string{
+		"Credentials tried:\n  - test keychain: no credentials\n",
+		"The last rejected request was sent without credentials.",
+		`No credentials were found. Run "docker login ` + host + `"`,
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("diagnosis does not contain %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestDiagnoseForbidden(t *testing.T) {
+	host := rejectingRegistry(t, http.StatusForbidden)
+	message := Diagnose(getManifest(t, host, authn.FromConfig(authn.AuthConfig{IdentityToken: "token"}))).Error()
+	for _, want := range []string{
+		"failed with HTTP 403 Forbidden.",
+		"test keychain: identity token",
+		"The registry accepted the credentials, but they don't grant access.",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("diagnosis does not contain %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestDiagnoseOtherErrors(t *testing.T) {
+	plain := errors.New("connection refused")
+	if err := Diagnose(plain); err != plain {
+		t.Errorf("Diagnose() of a non-registry error = %v, want it unchanged", err)
+	}
+	host := rejectingRegistry(t, http.StatusNotFound)
+	notFound := getManifest(t, host, authn.Anonymous)
+	var authErr *AuthError
+	if err := Diagnose(notFound); errors.As(err, &authErr) {
+		t.Errorf("Diagnose() of a 404 = %v, want it unchanged", err)
+	}
+}
+
+func TestDiagnosisWithoutLookup(t *testing.T) {
+	message := Diagnosis("unused.example.com", http.StatusUnauthorized)
+	if !strings.Contains(message, "No credentials were looked up for this registry.") {
+		t.Errorf("diagnosis of a registry without lookups:\n%s", message)
+	}
+}
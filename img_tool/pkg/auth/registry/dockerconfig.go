@@ -0,0 +1,392 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// dockerHubServerURL is the key Docker uses for Docker Hub in config.json and credential helpers.
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// dockerConfig is the subset of the Docker config file (config.json) used for authentication.
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// helperResponse is the output of "docker-credential-<name> get".
+type helperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// DockerConfigKeychain returns a keychain that reads credentials from the Docker config file.
+// The config file is read from $DOCKER_CONFIG/config.json if DOCKER_CONFIG is set, and from
+// ~/.docker/config.json otherwise.
+// Per-registry credential helpers (credHelpers) take precedence over the default credential store (credsStore),
+// which takes precedence over credentials stored in auths. Identity tokens are supported in auths and
+// for credential helpers that return "<token>" as the username.
+func DockerConfigKeychain() authn.Keychain {
+	return dockerConfigKeychainInstance
+}
+
+var dockerConfigKeychainInstance = &dockerConfigKeychain{
+	cache:          make(map[string]authn.AuthConfig),
+	missingHelpers: make(map[string]bool),
+}
+
+type dockerConfigKeychain struct {
+	mu sync.Mutex
+	// cache holds the resolved credentials per registry,
+	// so credential helpers are only executed once per process.
+	cache          map[string]authn.AuthConfig
+	missingHelpers map[string]bool
+}
+
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return k.ResolveContext(context.Background(), target)
+}
+
+func (k *dockerConfigKeychain) ResolveContext(ctx context.Context, target authn.Resource) (authn.Authenticator, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	registry := target.RegistryStr()
+	if cfg, ok := k.cache[registry]; ok {
+		return authenticator(cfg), nil
+	}
+	cfg, err := k.lookup(ctx, registry)
+	if err != nil {
+		return nil, err
+	}
+	k.cache[registry] = cfg
+	return authenticator(cfg), nil
+}
+
+func (k *dockerConfigKeychain) lookup(ctx context.Context, registry string) (authn.AuthConfig, error) {
+	configPath, ok := dockerConfigPath()
+	if !ok {
+		return authn.AuthConfig{}, nil
+	}
+	raw, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		diagnostics.attempt(registry, fmt.Sprintf("Docker config: %s does not exist", configPath))
+		return authn.AuthConfig{}, nil
+	} else if err != nil {
+		return authn.AuthConfig{}, fmt.Errorf("reading Docker config: %w", err)
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return authn.AuthConfig{}, fmt.Errorf("parsing Docker config %s: %w", configPath, err)
+	}
+
+	serverURL := serverURLFor(registry)
+	helper := config.credentialHelperFor(registry)
+	if helper != "" {
+		cfg, found, err := k.runCredentialHelper(ctx, registry, helper, serverURL)
+		if err != nil {
+			return authn.AuthConfig{}, err
+		}
+		if found {
+			return cfg, nil
+		}
+	}
+
+	for key, entry := range config.Auths {
+		if normalizeServerAddress(key) != normalizeServerAddress(registry) {
+			continue
+		}
+		cfg := authn.AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return authn.AuthConfig{}, fmt.Errorf("decoding auth for %s in Docker config: %w", key, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return authn.AuthConfig{}, fmt.Errorf("invalid auth for %s in Docker config: expected username:password", key)
+			}
+			cfg.Username = username
+			cfg.Password = password
+		}
+		return cfg, nil
+	}
+	return authn.AuthConfig{}, nil
+}
+
+// runCredentialHelper executes "docker-credential-<helper> get" for the server URL.
+// A helper that is not installed or doesn't know the server is not an error,
+// so that other sources of credentials (or anonymous access) can still be used.
+func (k *dockerConfigKeychain) runCredentialHelper(ctx context.Context, registry, helper, serverURL string) (authn.AuthConfig, bool, error) {
+	if k.missingHelpers[helper] {
+		return authn.AuthConfig{}, false, nil
+	}
+	binary, err := lookupCredentialHelper(helper)
+	if err != nil {
+		k.missingHelpers[helper] = true
+		slog.Warn("Docker credential helper is configured but not found", "helper", helper, logging.ErrKey, err)
+		diagnostics.attempt(registry, fmt.Sprintf("Docker config: credential helper docker-credential-%s not found", helper))
+		diagnostics.hint(registry, fmt.Sprintf("Install docker-credential-%s or add its directory to PATH. Bazel may run the tool with a reduced PATH (see --repo_env and --action_env).", helper))
+		return authn.AuthConfig{}, false, nil
+	}
+
+	cmd := exec.CommandContext(ctx, binary, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		// This is the message of the credential helper protocol for unknown servers.
+		if strings.Contains(output, "credentials not found") {
+			diagnostics.attempt(registry, fmt.Sprintf("Docker config: credential helper %s has no credentials for %s", binary, serverURL))
+			return authn.AuthConfig{}, false, nil
+		}
+		return authn.AuthConfig{}, false, fmt.Errorf("running Docker credential helper %s for %s: %w: %s", binary, serverURL, err, output)
+	}
+	var resp helperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return authn.AuthConfig{}, false, fmt.Errorf("parsing output of Docker credential helper %s: %w", binary, err)
+	}
+	diagnostics.attempt(registry, fmt.Sprintf("Docker config: credential helper %s returned credentials", binary))
+	if resp.Username == "<token>" {
+		return authn.AuthConfig{IdentityToken: resp.Secret}, true, nil
+	}
+	return authn.AuthConfig{Username: resp.Username, Password: resp.Secret}, true, nil
+}
+
+// StoreCredentials stores the username and password for a registry where DockerConfigKeychain finds them:
+// in the credential helper that is configured for the registry (credHelpers or credsStore),
+// or base64-encoded in the auths of the Docker config file otherwise.
+// Other entries of the config file are kept. It returns a description of the location.
+func StoreCredentials(ctx context.Context, registry, username, password string) (string, error) {
+	configPath, ok := dockerConfigPath()
+	if !ok {
+		return "", errors.New("cannot determine the path of the Docker config file, set DOCKER_CONFIG")
+	}
+	// the raw fields keep the parts of the config file that are not used for authentication
+	fields := make(map[string]json.RawMessage)
+	var config dockerConfig
+	raw, err := os.ReadFile(configPath)
+	if err == nil {
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return "", fmt.Errorf("parsing Docker config %s: %w", configPath, err)
+		}
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return "", fmt.Errorf("parsing Docker config %s: %w", configPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("reading Docker config: %w", err)
+	}
+
+	// credentials that were resolved before are outdated now
+	dockerConfigKeychainInstance.mu.Lock()
+	delete(dockerConfigKeychainInstance.cache, registry)
+	dockerConfigKeychainInstance.mu.Unlock()
+
+	serverURL := serverURLFor(registry)
+	if helper := config.credentialHelperFor(registry); helper != "" {
+		binary, err := lookupCredentialHelper(helper)
+		if err != nil {
+			return "", fmt.Errorf("credential helper docker-credential-%s is configured in %s but not found: %w", helper, configPath, err)
+		}
+		input, err := json.Marshal(helperResponse{ServerURL: serverURL, Username: username, Secret: password})
+		if err != nil {
+			return "", err
+		}
+		cmd := exec.CommandContext(ctx, binary, "store")
+		cmd.Stdin = bytes.NewReader(input)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("running Docker credential helper %s for %s: %w: %s", binary, serverURL, err, strings.TrimSpace(string(output)))
+		}
+		return "credential helper " + binary, nil
+	}
+
+	auths := make(map[string]json.RawMessage)
+	if rawAuths, ok := fields["auths"]; ok {
+		if err := json.Unmarshal(rawAuths, &auths); err != nil {
+			return "", fmt.Errorf("parsing auths of Docker config %s: %w", configPath, err)
+		}
+	}
+	// replace entries of the same registry with a different spelling (like https://ghcr.io)
+	for key := range auths {
+		if normalizeServerAddress(key) == normalizeServerAddress(registry) {
+			delete(auths, key)
+		}
+	}
+	entry, err := json.Marshal(map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	})
+	if err != nil {
+		return "", err
+	}
+	auths[serverURL] = entry
+	if fields["auths"], err = json.Marshal(auths); err != nil {
+		return "", err
+	}
+	raw, err = json.MarshalIndent(fields, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(configPath, append(raw, '\n')); err != nil {
+		return "", fmt.Errorf("writing Docker config: %w", err)
+	}
+	return configPath, nil
+}
+
+// writeFileAtomic replaces a file that may hold secrets, which are only readable by the user.
+// A symlinked file (like a config.json managed in a dotfiles repository) is replaced at the target of the link,
+// so that the link is kept.
+func writeFileAtomic(path string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// credentialHelperFor returns the credential helper of a registry:
+// the per-registry helper of credHelpers, or the default credential store.
+func (c dockerConfig) credentialHelperFor(registry string) string {
+	for key, credHelper := range c.CredHelpers {
+		if normalizeServerAddress(key) == normalizeServerAddress(registry) {
+			return credHelper
+		}
+	}
+	return c.CredsStore
+}
+
+// serverURLFor returns the key of a registry in the Docker config and for credential helpers.
+func serverURLFor(registry string) string {
+	if registry == name.DefaultRegistry {
+		return dockerHubServerURL
+	}
+	return registry
+}
+
+// dockerConfigPath returns the path of the Docker config file.
+func dockerConfigPath() (string, bool) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), true
+	}
+	// UserHomeDir uses %USERPROFILE% on Windows.
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, ".docker", "config.json"), true
+}
+
+// lookupCredentialHelper finds the binary of a Docker credential helper.
+// Bazel runs actions and repository rules with a reduced PATH, which often misses
+// the directories that Docker Desktop and the cloud SDKs install their helpers into on Windows.
+func lookupCredentialHelper(helper string) (string, error) {
+	binary := "docker-credential-" + helper
+	path, err := exec.LookPath(binary)
+	if err == nil || runtime.GOOS != "windows" {
+		return path, err
+	}
+	for _, dir := range windowsCredentialHelperDirs() {
+		// gcloud installs its helper as a batch file.
+		for _, ext := range []string{".exe", ".cmd", ".bat"} {
+			candidate := filepath.Join(dir, binary+ext)
+			if info, statErr := os.Stat(candidate); statErr == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+	}
+	return "", err
+}
+
+func windowsCredentialHelperDirs() []string {
+	var dirs []string
+	for _, env := range []string{"ProgramFiles", "ProgramW6432"} {
+		if root := os.Getenv(env); root != "" {
+			dirs = append(dirs,
+				filepath.Join(root, "Docker", "Docker", "resources", "bin"),
+				filepath.Join(root, "Amazon", "ECR-Credential-Helper"),
+			)
+		}
+	}
+	if root := os.Getenv("ProgramFiles(x86)"); root != "" {
+		dirs = append(dirs, filepath.Join(root, "Google", "Cloud SDK", "google-cloud-sdk", "bin"))
+	}
+	if root := os.Getenv("LOCALAPPDATA"); root != "" {
+		dirs = append(dirs,
+			filepath.Join(root, "Google", "Cloud SDK", "google-cloud-sdk", "bin"),
+			filepath.Join(root, "Microsoft", "WinGet", "Links"),
+		)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, "go", "bin"))
+	}
+	return dirs
+}
+
+// normalizeServerAddress strips the scheme and path of a key in the Docker config,
+// so that "https://index.docker.io/v1/" and "index.docker.io" refer to the same registry.
+func normalizeServerAddress(address string) string {
+	address = strings.TrimPrefix(address, "https://")
+	address = strings.TrimPrefix(address, "http://")
+	address, _, _ = strings.Cut(address, "/")
+	if address == "docker.io" || address == "registry-1.docker.io" {
+		return name.DefaultRegistry
+	}
+	return address
+}
+
+func authenticator(cfg authn.AuthConfig) authn.Authenticator {
+	if cfg == (authn.AuthConfig{}) {
+		return authn.Anonymous
+	}
+	return authn.FromConfig(cfg)
+}
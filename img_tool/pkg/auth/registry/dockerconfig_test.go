@@ -0,0 +1,348 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+)
+
+func writeDockerConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readDockerConfig(t *testing.T, path string) map[string]any {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(raw, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func basicAuthEntry(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestStoreCredentialsAuths(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	path := writeDockerConfig(t, dir, `{
+	"auths": {
+		"https://ghcr.io": {"auth": "b2xkOm9sZA=="},
+		"docker.io": {"auth": "b2xkOm9sZA=="},
+		"quay.io": {"auth": "cXVheTpxdWF5", "email": "me@example.com"}
+	},
+	"proxies": {"default": {"httpProxy": "http://proxy.example.com:3128"}},
+	"psFormat": "table {{.ID}}"
+}`)
+
+	for _, registry := range []string{"ghcr.io", "index.docker.io"} {
+		location, err := StoreCredentials(context.Background(), registry, "user", "secret")
+		if err != nil {
+			t.Fatalf("StoreCredentials(%s) error = %v", registry, err)
+		}
+		if location != path {
+			t.Errorf("StoreCredentials(%s) location = %q, want %q", registry, location, path)
+		}
+	}
+
+	config := readDockerConfig(t, path)
+	auths := config["auths"].(map[string]any)
+	// other spellings of the same registry are replaced, Docker Hub uses the key of Docker
+	want := map[string]string{
+		"ghcr.io":                     basicAuthEntry("user", "secret"),
+		"https://index.docker.io/v1/": basicAuthEntry("user", "secret"),
+		"quay.io":                     "cXVheTpxdWF5",
+	}
+	if len(auths) != len(want) {
+		t.Errorf("auths = %v, want the keys of %v", auths, want)
+	}
+	for key, auth := range want {
+		entry, ok := auths[key].(map[string]any)
+		if !ok || entry["auth"] != auth {
+			t.Errorf("auths[%q] = %v, want auth %q", key, auths[key], auth)
+		}
+	}
+	// fields that are not used for authentication are kept
+	if email := auths["quay.io"].(map[string]any)["email"]; email != "me@example.com" {
+		t.Errorf("email of quay.io = %v, want it to be kept", email)
+	}
+	if config["psFormat"] != "table {{.ID}}" || config["proxies"] == nil {
+		t.Errorf("config = %v, want psFormat and proxies to be kept", config)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o600 {
+			t.Errorf("config mode = %o, want 600", mode)
+		}
+	}
+}
+
+func TestStoreCredentialsNewConfig(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "docker")
+	t.Setenv("DOCKER_CONFIG", dir)
+	location, err := StoreCredentials(context.Background(), "registry.example.com:5000", "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auths := readDockerConfig(t, location)["auths"].(map[string]any)
+	if entry, ok := auths["registry.example.com:5000"].(map[string]any); !ok || entry["auth"] != basicAuthEntry("user", "secret") {
+		t.Errorf("auths = %v, want credentials of registry.example.com:5000", auths)
+	}
+}
+
+func TestStoreCredentialsSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", filepath.Join(dir, "docker"))
+	dotfiles := filepath.Join(dir, "dotfiles")
+	if err := os.MkdirAll(filepath.Join(dir, "docker"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dotfiles, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	target := writeDockerConfig(t, dotfiles, `{"auths": {}}`)
+	link := filepath.Join(dir, "docker", "config.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := StoreCredentials(context.Background(), "ghcr.io", "user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("config.json is no longer a symlink: %v", err)
+	}
+	auths := readDockerConfig(t, target)["auths"].(map[string]any)
+	if _, ok := auths["ghcr.io"]; !ok {
+		t.Errorf("auths of the symlink target = %v, want credentials of ghcr.io", auths)
+	}
+}
+
+func TestStoreCredentialsHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	// the fake helper records the input of "store"
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stored := filepath.Join(dir, "stored.json")
+	helper := "#!/bin/sh\n[ \"$1\" = store ] || exit 1\ncat > " + stored + "\n"
+	for _, name := range []string{"docker-credential-registry", "docker-credential-desktop"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(helper), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	config := `{"credsStore": "desktop", "credHelpers": {"https://ghcr.io": "registry"}}`
+	path := writeDockerConfig(t, dir, config)
+
+	tests := []struct {
+		registry      string
+		wantLocation  string
+		wantServerURL string
+	}{
+		// credHelpers take precedence over the credential store, also with another spelling of the registry
+		{registry: "ghcr.io", wantLocation: filepath.Join(bin, "docker-credential-registry"), wantServerURL: "ghcr.io"},
+		{registry: "index.docker.io", wantLocation: filepath.Join(bin, "docker-credential-desktop"), wantServerURL: "https://index.docker.io/v1/"},
+	}
+	for _, tt := range tests {
+		location, err := StoreCredentials(context.Background(), tt.registry, "user", "secret")
+		if err != nil {
+			t.Fatalf("StoreCredentials(%s) error = %v", tt.registry, err)
+		}
+		if location != "credential helper "+tt.wantLocation {
+			t.Errorf("StoreCredentials(%s) location = %q, want the helper %s", tt.registry, location, tt.wantLocation)
+		}
+		raw, err := os.ReadFile(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var input helperResponse
+		if err := json.Unmarshal(raw, &input); err != nil {
+			t.Fatal(err)
+		}
+		if input != (helperResponse{ServerURL: tt.wantServerURL, Username: "user", Secret: "secret"}) {
+			t.Errorf("StoreCredentials(%s) sent %+v to the helper", tt.registry, input)
+		}
+	}
+	// the config file is not touched if a helper stores the credentials
+	if raw, err := os.ReadFile(path); err != nil || string(raw) != config {
+		t.Errorf("config = %s, want it unchanged", raw)
+	}
+}
+
+func newDockerConfigKeychain() *dockerConfigKeychain {
+	return &dockerConfigKeychain{
+		cache:          make(map[string]authn.AuthConfig),
+		missingHelpers: make(map[string]bool),
+	}
+}
+
+func resolveAuth(t *testing.T, keychain authn.Keychain, registry string) (authn.AuthConfig, error) {
+	t.Helper()
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := keychain.Resolve(reg)
+	if err != nil {
+		return authn.AuthConfig{}, err
+	}
+	if auth == authn.Anonymous {
+		return authn.AuthConfig{}, nil
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *cfg, nil
+}
+
+// writeCredentialHelper installs a fake docker-credential-<name> that runs script for "get".
+// It appends the server URLs it was asked for to calls.
+func writeCredentialHelper(t *testing.T, bin, helper, script string) (calls string) {
+	t.Helper()
+	calls = filepath.Join(bin, helper+".calls")
+	content := "#!/bin/sh\n[ \"$1\" = get ] || exit 1\nread server\necho \"$server\" >> " + calls + "\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker-credential-"+helper), []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+func TestDockerConfigKeychainAuths(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	writeDockerConfig(t, dir, `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+basicAuthEntry("hubuser", "hubpass:with:colons")+`"},
+		"https://ghcr.io": {"username": "ghuser", "password": "ghpass"},
+		"registry.example.com": {"identitytoken": "refresh-token"},
+		"broken.example.com": {"auth": "bm9jb2xvbg=="}
+	}}`)
+	keychain := newDockerConfigKeychain()
+
+	tests := []struct {
+		registry string
+		want     authn.AuthConfig
+	}{
+		{registry: "docker.io", want: authn.AuthConfig{Username: "hubuser", Password: "hubpass:with:colons"}},
+		{registry: "ghcr.io", want: authn.AuthConfig{Username: "ghuser", Password: "ghpass"}},
+		{registry: "registry.example.com", want: authn.AuthConfig{IdentityToken: "refresh-token"}},
+		{registry: "quay.io", want: authn.AuthConfig{}},
+	}
+	for _, tt := range tests {
+		got, err := resolveAuth(t, keychain, tt.registry)
+		if err != nil {
+			t.Errorf("Resolve(%s) error = %v", tt.registry, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%s) = %+v, want %+v", tt.registry, got, tt.want)
+		}
+	}
+	if _, err := resolveAuth(t, keychain, "broken.example.com"); err == nil || !strings.Contains(err.Error(), "expected username:password") {
+		t.Errorf("Resolve() of an auth without colon: error = %v", err)
+	}
+}
+
+func TestDockerConfigKeychainMissingOrInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	if got, err := resolveAuth(t, newDockerConfigKeychain(), "ghcr.io"); err != nil || got != (authn.AuthConfig{}) {
+		t.Errorf("Resolve() without config = %+v, %v, want anonymous", got, err)
+	}
+	writeDockerConfig(t, dir, `{"auths": [`)
+	if _, err := resolveAuth(t, newDockerConfigKeychain(), "ghcr.io"); err == nil || !strings.Contains(err.Error(), "parsing Docker config") {
+		t.Errorf("Resolve() with invalid config: error = %v", err)
+	}
+}
+
+func TestDockerConfigKeychainHelpers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helpers are shell scripts")
+	}
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	storeCalls := writeCredentialHelper(t, bin, "store", `echo '{"ServerURL":"'$server'","Username":"storeuser","Secret":"storepass"}'`)
+	writeCredentialHelper(t, bin, "token", `echo '{"Username":"<token>","Secret":"identity"}'`)
+	writeCredentialHelper(t, bin, "unknown", `echo "credentials not found in native keychain"; exit 1`)
+	writeCredentialHelper(t, bin, "broken", `echo "keychain is locked" >&2; exit 1`)
+	writeDockerConfig(t, dir, `{
+		"credsStore": "store",
+		"credHelpers": {"token.example.com": "token", "fallback.example.com": "unknown", "broken.example.com": "broken", "missing.example.com": "missing"},
+		"auths": {"fallback.example.com": {"auth": "`+basicAuthEntry("authsuser", "authspass")+`"}}
+	}`)
+	keychain := newDockerConfigKeychain()
+
+	tests := []struct {
+		registry string
+		want     authn.AuthConfig
+	}{
+		// the credential store gets the Docker Hub server URL
+		{registry: "index.docker.io", want: authn.AuthConfig{Username: "storeuser", Password: "storepass"}},
+		{registry: "ghcr.io", want: authn.AuthConfig{Username: "storeuser", Password: "storepass"}},
+		{registry: "token.example.com", want: authn.AuthConfig{IdentityToken: "identity"}},
+		// a helper without credentials for the server falls back to auths
+		{registry: "fallback.example.com", want: authn.AuthConfig{Username: "authsuser", Password: "authspass"}},
+		// a helper that is not installed is skipped
+		{registry: "missing.example.com", want: authn.AuthConfig{}},
+	}
+	for _, tt := range tests {
+		got, err := resolveAuth(t, keychain, tt.registry)
+		if err != nil {
+			t.Errorf("Resolve(%s) error = %v", tt.registry, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%s) = %+v, want %+v", tt.registry, got, tt.want)
+		}
+	}
+	if _, err := resolveAuth(t, keychain, "broken.example.com"); err == nil || !strings.Contains(err.Error(), "keychain is locked") {
+		t.Errorf("Resolve() with a failing helper: error = %v", err)
+	}
+
+	// credentials are cached, so helpers only run once per registry
+	if _, err := resolveAuth(t, keychain, "ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	calls, err := os.ReadFile(storeCalls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(calls) != "https://index.docker.io/v1/\nghcr.io\n" {
+		t.Errorf("credential store was asked for %q", calls)
+	}
+}
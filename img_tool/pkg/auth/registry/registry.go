@@ -1,16 +1,31 @@
 package registry
 
-import(
+import (
 	"github.com/malt3/go-containerregistry/pkg/authn"
 	"github.com/malt3/go-containerregistry/pkg/v1/google"
 	"github.com/malt3/go-containerregistry/pkg/v1/remote"
 )
 
-func WithAuthFromMultiKeychain() remote.Option {
-	kc := authn.NewMultiKeychain(
-		authn.DefaultKeychain,
-		google.Keychain,
+// MultiKeychain returns the keychain used to authenticate to registries.
+// The Docker config file (including DOCKER_CONFIG and credential helpers) is consulted first,
+// followed by the default keychain (which also knows about Podman's auth file) and the Google keychain.
+// While a test registry is in use, no credentials are looked up.
+func MultiKeychain() authn.Keychain {
+	if ActiveTestRegistry() != nil {
+		return anonymousKeychain{}
+	}
+	return authn.NewMultiKeychain(
+		recordingKeychain{name: "Docker config", keychain: DockerConfigKeychain()},
+		recordingKeychain{name: "default keychain", keychain: authn.DefaultKeychain},
+		recordingKeychain{name: "Google keychain", keychain: google.Keychain},
 	)
+}
 
-	return remote.WithAuthFromKeychain(kc)
+// WithAuthFromMultiKeychain authenticates with the MultiKeychain.
+// It also records rejected requests, so that authentication failures can be explained by Diagnose.
+func WithAuthFromMultiKeychain() remote.Option {
+	return combineOptions(
+		remote.WithAuthFromKeychain(MultiKeychain()),
+		remote.WithTransport(Transport(BaseTransport())),
+	)
 }
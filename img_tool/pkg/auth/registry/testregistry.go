@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+)
+
+// TestRegistryEnv names the fixture registry that a test started for hermetic pushes.
+// The value is either "unix://<socket path>" or "<host>:<port>" of a loopback address.
+const TestRegistryEnv = "IMG_TEST_REGISTRY"
+
+// unixSocketHost is the registry name of a test registry on a unix socket.
+// It needs a dot, since image references like "localhost/app" refer to Docker Hub.
+const unixSocketHost = "test-registry.localhost"
+
+// TestRegistry is a registry fixture started by a test (for example a Bazel test action).
+// While a TestRegistry is in use (see UseTestRegistry), registry requests only reach the fixture:
+// requests to any other host fail, no proxy is used and credentials are never looked up.
+type TestRegistry struct {
+	// Host is the registry name to use in image references.
+	Host    string
+	network string
+	address string
+}
+
+// ParseTestRegistry parses the value of IMG_TEST_REGISTRY or the --test-registry flag.
+func ParseTestRegistry(value string) (*TestRegistry, error) {
+	if socket, ok := strings.CutPrefix(value, "unix://"); ok {
+		if socket == "" {
+			return nil, fmt.Errorf("test registry %q: missing socket path", value)
+		}
+		return &TestRegistry{Host: unixSocketHost, network: "unix", address: socket}, nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return nil, fmt.Errorf("test registry %q: expected unix://<socket> or <host>:<port>: %w", value, err)
+	}
+	if port == "" || port == "0" {
+		return nil, fmt.Errorf("test registry %q: missing port", value)
+	}
+	if !isLoopback(host) {
+		return nil, fmt.Errorf("test registry %q: host must be a loopback address", value)
+	}
+	return &TestRegistry{Host: value, network: "tcp", address: value}, nil
+}
+
+// Transport returns a transport that sends requests for the test registry over plain HTTP
+// to its socket or port and refuses to contact any other host.
+func (r *TestRegistry) Transport() http.RoundTripper {
+	dialer := &net.Dialer{}
+	return &testRegistryTransport{
+		host: r.Host,
+		inner: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, r.network, r.address)
+			},
+		},
+	}
+}
+
+func (r *TestRegistry) String() string {
+	if r.network == "unix" {
+		return "unix://" + r.address
+	}
+	return r.address
+}
+
+// UseTestRegistry routes all registry operations of this process to the test registry.
+// It replaces the transport set by ConfigureTransport and makes MultiKeychain anonymous.
+func UseTestRegistry(r *TestRegistry) {
+	baseTransportMu.Lock()
+	defer baseTransportMu.Unlock()
+	baseTransport = r.Transport()
+	testRegistry = r
+}
+
+// ActiveTestRegistry returns the registry set by UseTestRegistry, or nil.
+func ActiveTestRegistry() *TestRegistry {
+	baseTransportMu.Lock()
+	defer baseTransportMu.Unlock()
+	return testRegistry
+}
+
+var testRegistry *TestRegistry
+
+// anonymousKeychain never provides credentials.
+type anonymousKeychain struct{}
+
+func (anonymousKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return authn.Anonymous, nil
+}
+
+type testRegistryTransport struct {
+	host  string
+	inner http.RoundTripper
+}
+
+func (t *testRegistryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return nil, fmt.Errorf("hermetic test push: refusing to contact %s, only the test registry %s is reachable", req.URL.Host, t.host)
+	}
+	if req.URL.Scheme == "https" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	return t.inner.RoundTrip(req)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/malt3/go-containerregistry/pkg/registry"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+)
+
+func TestParseTestRegistry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{value: "unix:///tmp/registry.sock", want: "unix:///tmp/registry.sock"},
+		{value: "127.0.0.1:5000", want: "127.0.0.1:5000"},
+		{value: "localhost:5000", want: "localhost:5000"},
+		{value: "[::1]:5000", want: "[::1]:5000"},
+		{value: "unix://", wantErr: "missing socket path"},
+		{value: "localhost", wantErr: "expected unix://<socket> or <host>:<port>"},
+		{value: "localhost:0", wantErr: "missing port"},
+		{value: "registry.example.com:5000", wantErr: "host must be a loopback address"},
+		{value: "10.0.0.1:5000", wantErr: "host must be a loopback address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTestRegistry(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseTestRegistry() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTestRegistry() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseTestRegistry() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// socketRegistry serves an in-memory registry on a unix socket.
+func socketRegistry(t *testing.T) string {
+	t.Helper()
+	// socket paths are limited to about 100 bytes, so don't use t.TempDir
+	dir, err := os.MkdirTemp("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "registry.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0)))}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return socket
+}
+
+func TestTestRegistryTransport(t *testing.T) {
+	fixture, err := ParseTestRegistry("unix://" + socketRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(fixture.Host + "/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img, remote.WithTransport(fixture.Transport())); err != nil {
+		t.Fatalf("pushing to the test registry: %v", err)
+	}
+	pulled, err := remote.Image(ref, remote.WithTransport(fixture.Transport()))
+	if err != nil {
+		t.Fatalf("pulling from the test registry: %v", err)
+	}
+	wantDigest, _ := img.Digest()
+	if gotDigest, _ := pulled.Digest(); gotDigest != wantDigest {
+		t.Errorf("pulled %s, want %s", gotDigest, wantDigest)
+	}
+
+	other, err := name.ParseReference("registry.example.com/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.Image(other, remote.WithTransport(fixture.Transport()))
+	if err == nil || !strings.Contains(err.Error(), "refusing to contact registry.example.com, only the test registry test-registry.localhost is reachable") {
+		t.Errorf("pulling from another registry: error = %v, want a refusal", err)
+	}
+}
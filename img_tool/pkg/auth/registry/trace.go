@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are headers whose values are never logged.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Amz-Security-Token"}
+
+// sensitiveQueryParameters are query parameters whose values are never logged,
+// like the tokens of token endpoints and the signatures of presigned blob URLs.
+var sensitiveQueryParameters = []string{"token", "access_token", "refresh_token", "password", "signature", "sig", "x-amz-signature", "x-amz-credential", "x-amz-security-token", "x-goog-signature", "x-goog-credential"}
+
+// tracingTransport logs every registry request at trace level (--log-level trace):
+// the method, URL, status, duration and attempt, the rate limit headers and all other headers.
+// Credentials are redacted. Retries of go-containerregistry send the same request again,
+// so the attempt counts how often a request was sent.
+type tracingTransport struct {
+	inner http.RoundTripper
+	// attempts counts the attempts of requests that may still be retried.
+	attempts sync.Map
+}
+
+func traceTransport(inner http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{inner: inner}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !traceEnabled(ctx) {
+		return t.inner.RoundTrip(req)
+	}
+	attempt := 1
+	if previous, ok := t.attempts.Load(req); ok {
+		attempt = previous.(int) + 1
+	}
+	start := time.Now()
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil || retryableStatus(resp.StatusCode) {
+		t.attempts.Store(req, attempt)
+	} else {
+		t.attempts.Delete(req)
+	}
+
+	args := []any{
+		"method", req.Method,
+		"url", redactURL(req.URL),
+		"attempt", attempt,
+		"duration", time.Since(start).Round(time.Millisecond),
+	}
+	if err != nil {
+		args = append(args, logging.ErrKey, err)
+		args = append(args, headerGroup("request", req.Header))
+		slog.Log(ctx, logging.LevelTrace, "Registry request failed", args...)
+		return resp, err
+	}
+	args = append(args, "status", resp.StatusCode)
+	args = append(args, rateLimitAttrs(resp.Header)...)
+	args = append(args, headerGroup("request", req.Header), headerGroup("response", resp.Header))
+	slog.Log(ctx, logging.LevelTrace, "Registry request", args...)
+	return resp, nil
+}
+
+// retryableStatus reports whether go-containerregistry retries requests with the status.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rateLimitAttrs returns the rate limit headers of a response as attributes,
+// like "ratelimit-remaining=98;w=21600" of Docker Hub or Retry-After of 429 responses.
+func rateLimitAttrs(header http.Header) []any {
+	var attrs []any
+	for _, key := range sortedKeys(header) {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "ratelimit-") || strings.HasPrefix(lower, "x-ratelimit-") ||
+			lower == "docker-ratelimit-source" || lower == "retry-after" {
+			attrs = append(attrs, lower, header.Get(key))
+		}
+	}
+	return attrs
+}
+
+func headerGroup(name string, header http.Header) slog.Attr {
+	var attrs []any
+	for _, key := range sortedKeys(header) {
+		attrs = append(attrs, key, redactHeader(key, header.Values(key)))
+	}
+	return slog.Group(name+"-headers", attrs...)
+}
+
+// redactHeader keeps the scheme of credentials (like "Bearer"), but not the credentials.
+func redactHeader(key string, values []string) string {
+	if !slices.ContainsFunc(sensitiveHeaders, func(sensitive string) bool { return strings.EqualFold(sensitive, key) }) {
+		return strings.Join(values, ", ")
+	}
+	redactedValues := make([]string, len(values))
+	for i, value := range values {
+		if scheme, _, ok := strings.Cut(value, " "); ok && (strings.EqualFold(key, "Authorization") || strings.EqualFold(key, "Proxy-Authorization")) {
+			redactedValues[i] = scheme + " " + redacted
+			continue
+		}
+		redactedValues[i] = redacted
+	}
+	return strings.Join(redactedValues, ", ")
+}
+
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	if u.User != nil {
+		redactedURL.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		parameters := strings.Split(u.RawQuery, "&")
+		for i, parameter := range parameters {
+			key, _, _ := strings.Cut(parameter, "=")
+			if unescaped, err := url.QueryUnescape(key); err == nil && slices.Contains(sensitiveQueryParameters, strings.ToLower(unescaped)) {
+				parameters[i] = key + "=" + redacted
+			}
+		}
+		redactedURL.RawQuery = strings.Join(parameters, "&")
+	}
+	return redactedURL.String()
+}
+
+func sortedKeys(header http.Header) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// traceEnabled reports whether registry requests are logged.
+func traceEnabled(ctx context.Context) bool {
+	return slog.Default().Enabled(ctx, logging.LevelTrace)
+}
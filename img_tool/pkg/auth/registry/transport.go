@@ -0,0 +1,258 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// Environment variables that provide defaults for TLSOptions.
+const (
+	caBundleEnv           = "IMG_REGISTRY_CA_BUNDLE"
+	insecureSkipVerifyEnv = "IMG_REGISTRY_INSECURE_SKIP_VERIFY"
+	plainHTTPEnv          = "IMG_REGISTRY_PLAIN_HTTP"
+)
+
+// TLSOptions configure how connections to registries are secured.
+// Proxies are configured with the standard environment variables HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+type TLSOptions struct {
+	// CABundle is the path of a PEM file with additional root certificates,
+	// for example of a proxy that intercepts TLS connections.
+	// The certificates are trusted in addition to the system roots.
+	CABundle string
+	// InsecureSkipVerify lists registries (host or host:port) whose certificates are not verified.
+	InsecureSkipVerify []string
+	// PlainHTTP lists registries (host or host:port) that are contacted over HTTP without TLS,
+	// like local development registries (e.g. localhost:5000 or kind-registry:5001).
+	PlainHTTP []string
+	// HTTPFallback lists registries (host or host:port) that are contacted over plain HTTP
+	// if they do not speak TLS, like insecure registries of a registries.conf.
+	HTTPFallback []string
+}
+
+// TLSOptionsFromEnv returns the options set by IMG_REGISTRY_CA_BUNDLE,
+// IMG_REGISTRY_INSECURE_SKIP_VERIFY and IMG_REGISTRY_PLAIN_HTTP (comma-separated lists of registries).
+func TLSOptionsFromEnv() TLSOptions {
+	var opts TLSOptions
+	opts.CABundle = os.Getenv(caBundleEnv)
+	if value := os.Getenv(insecureSkipVerifyEnv); value != "" {
+		opts.InsecureSkipVerify = splitRegistries(value)
+	}
+	if value := os.Getenv(plainHTTPEnv); value != "" {
+		opts.PlainHTTP = splitRegistries(value)
+	}
+	return opts
+}
+
+// RegisterFlags adds --registry-ca-bundle, --registry-insecure-skip-verify and --registry-plain-http to the flag set.
+// The environment variables of TLSOptionsFromEnv provide the defaults.
+func (o *TLSOptions) RegisterFlags(flagSet *flag.FlagSet) {
+	*o = TLSOptionsFromEnv()
+	flagSet.StringVar(&o.CABundle, "registry-ca-bundle", o.CABundle, fmt.Sprintf("PEM file with additional root certificates for registries and proxies, e.g. of a TLS intercepting proxy (env: %s)", caBundleEnv))
+	flagSet.Func("registry-insecure-skip-verify", fmt.Sprintf("Registry (host or host:port) whose TLS certificate is not verified. Can be specified multiple times or as a comma-separated list (env: %s)", insecureSkipVerifyEnv), func(value string) error {
+		o.InsecureSkipVerify = append(o.InsecureSkipVerify, splitRegistries(value)...)
+		return nil
+	})
+	flagSet.Func("registry-plain-http", fmt.Sprintf("Registry (host or host:port) that is contacted over plain HTTP without TLS, e.g. a local development registry. Can be specified multiple times or as a comma-separated list (env: %s)", plainHTTPEnv), func(value string) error {
+		o.PlainHTTP = append(o.PlainHTTP, splitRegistries(value)...)
+		return nil
+	})
+}
+
+var (
+	baseTransportMu sync.Mutex
+	baseTransport   http.RoundTripper
+)
+
+// ConfigureTransport sets the TLS options used by all registry operations of this process.
+// Without a call to ConfigureTransport, the options are read from the environment (see TLSOptionsFromEnv).
+func ConfigureTransport(opts TLSOptions) error {
+	rt, err := newTransport(opts)
+	if err != nil {
+		return err
+	}
+	baseTransportMu.Lock()
+	defer baseTransportMu.Unlock()
+	baseTransport = traceTransport(rt)
+	return nil
+}
+
+// BaseTransport returns the transport for registry requests.
+// It honors the TLS options of ConfigureTransport and the proxy environment variables,
+// and logs every request with --log-level trace.
+func BaseTransport() http.RoundTripper {
+	baseTransportMu.Lock()
+	defer baseTransportMu.Unlock()
+	if baseTransport != nil {
+		return baseTransport
+	}
+	rt, err := newTransport(TLSOptionsFromEnv())
+	if err != nil {
+		slog.Warn("Ignoring TLS options from the environment", logging.ErrKey, err)
+		rt = remote.DefaultTransport
+	}
+	baseTransport = traceTransport(rt)
+	return baseTransport
+}
+
+func newTransport(opts TLSOptions) (http.RoundTripper, error) {
+	rt, err := newTLSTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.PlainHTTP) == 0 && len(opts.HTTPFallback) == 0 {
+		return rt, nil
+	}
+	return &plainHTTPRouter{underlying: rt, plainHTTPRegistries: opts.PlainHTTP, fallbackRegistries: opts.HTTPFallback}, nil
+}
+
+func newTLSTransport(opts TLSOptions) (http.RoundTripper, error) {
+	if opts.CABundle == "" && len(opts.InsecureSkipVerify) == 0 {
+		return remote.DefaultTransport, nil
+	}
+	base, ok := remote.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("default transport is not an *http.Transport")
+	}
+
+	secure := base.Clone()
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading registry CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("registry CA bundle %s contains no PEM certificates", opts.CABundle)
+		}
+		secure.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if len(opts.InsecureSkipVerify) == 0 {
+		return secure, nil
+	}
+
+	insecure := secure.Clone()
+	if insecure.TLSClientConfig == nil {
+		insecure.TLSClientConfig = &tls.Config{}
+	}
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+	return &tlsRouter{secure: secure, insecure: insecure, insecureRegistries: opts.InsecureSkipVerify}, nil
+}
+
+// tlsRouter sends requests to registries without certificate verification through a separate transport.
+type tlsRouter struct {
+	secure             http.RoundTripper
+	insecure           http.RoundTripper
+	insecureRegistries []string
+}
+
+func (r *tlsRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, registry := range r.insecureRegistries {
+		if hostMatches(registry, req.URL.Host) {
+			return r.insecure.RoundTrip(req)
+		}
+	}
+	return r.secure.RoundTrip(req)
+}
+
+// plainHTTPRouter downgrades requests to registries that don't serve TLS from https to http.
+// Rewriting the scheme here (instead of in every image reference) also covers
+// the ping and token requests that go-containerregistry sends on its own.
+// Registries with HTTP fallback are first contacted over https.
+// Once a registry answered with plain HTTP, all further requests to it use http.
+type plainHTTPRouter struct {
+	underlying          http.RoundTripper
+	plainHTTPRegistries []string
+	fallbackRegistries  []string
+	// plainHosts records the hosts with HTTP fallback that don't speak TLS.
+	plainHosts sync.Map
+}
+
+func (r *plainHTTPRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return r.underlying.RoundTrip(req)
+	}
+	for _, registry := range r.plainHTTPRegistries {
+		if hostMatches(registry, req.URL.Host) {
+			return r.underlying.RoundTrip(withHTTPScheme(req))
+		}
+	}
+	if _, plain := r.plainHosts.Load(req.URL.Host); plain {
+		return r.underlying.RoundTrip(withHTTPScheme(req))
+	}
+	resp, err := r.underlying.RoundTrip(req)
+	if err == nil || !isHTTPResponseToHTTPS(err) {
+		return resp, err
+	}
+	for _, registry := range r.fallbackRegistries {
+		if !hostMatches(registry, req.URL.Host) {
+			continue
+		}
+		retry := withHTTPScheme(req)
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+		r.plainHosts.Store(req.URL.Host, struct{}{})
+		return r.underlying.RoundTrip(retry)
+	}
+	return nil, err
+}
+
+// isHTTPResponseToHTTPS reports whether the server answered a TLS handshake with plain HTTP.
+// http.Client turns this error into "server gave HTTP response to HTTPS client",
+// but a RoundTripper sees the underlying TLS error.
+func isHTTPResponseToHTTPS(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &recordErr) && string(recordErr.RecordHeader[:]) == "HTTP/"
+}
+
+func withHTTPScheme(req *http.Request) *http.Request {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	return req
+}
+
+// hostMatches reports whether the host of a request belongs to the registry.
+// A registry without port matches all ports of the host.
+func hostMatches(registry, host string) bool {
+	if registry == host {
+		return true
+	}
+	if _, _, err := net.SplitHostPort(registry); err == nil {
+		return false
+	}
+	hostname, _, err := net.SplitHostPort(host)
+	return err == nil && hostname == registry
+}
+
+func splitRegistries(value string) []string {
+	var registries []string
+	for _, registry := range strings.Split(value, ",") {
+		if registry = strings.TrimSpace(registry); registry != "" {
+			registries = append(registries, registry)
+		}
+	}
+	return registries
+}
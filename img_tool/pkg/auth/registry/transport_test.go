@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"encoding/pem"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tlsRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeCABundle(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, bundle, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(rt http.RoundTripper, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestNewTransportTLS(t *testing.T) {
+	server := tlsRegistry(t)
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		name    string
+		opts    func() TLSOptions
+		wantErr string
+	}{
+		{
+			name:    "system roots only",
+			opts:    func() TLSOptions { return TLSOptions{} },
+			wantErr: "certificate signed by unknown authority",
+		},
+		{
+			name: "CA bundle",
+			opts: func() TLSOptions { return TLSOptions{CABundle: writeCABundle(t, server)} },
+		},
+		{
+			name: "skip verification of the registry",
+			opts: func() TLSOptions { return TLSOptions{InsecureSkipVerify: []string{host}} },
+		},
+		{
+			name: "skip verification of all ports of the host",
+			opts: func() TLSOptions { return TLSOptions{InsecureSkipVerify: []string{"127.0.0.1"}} },
+		},
+		{
+			name:    "skip verification of another registry",
+			opts:    func() TLSOptions { return TLSOptions{InsecureSkipVerify: []string{"registry.example.com"}} },
+			wantErr: "certificate signed by unknown authority",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := newTransport(tt.opts())
+			if err != nil {
+				t.Fatalf("newTransport() error = %v", err)
+			}
+			err = get(rt, server.URL+"/v2/")
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("GET error = %v, want success", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("GET error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTransportInvalidCABundle(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTransport(TLSOptions{CABundle: filepath.Join(dir, "missing.pem")}); err == nil || !strings.Contains(err.Error(), "reading registry CA bundle") {
+		t.Errorf("newTransport() error = %v, want an error reading the CA bundle", err)
+	}
+	if _, err := newTransport(TLSOptions{CABundle: empty}); err == nil || !strings.Contains(err.Error(), "contains no PEM certificates") {
+		t.Errorf("newTransport() error = %v, want an error about missing certificates", err)
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		registry string
+		host     string
+		want     bool
+	}{
+		{"registry.example.com", "registry.example.com", true},
+		{"registry.example.com", "registry.example.com:5000", true},
+		{"registry.example.com:5000", "registry.example.com:5000", true},
+		{"registry.example.com:5000", "registry.example.com:5001", false},
+		{"registry.example.com:5000", "registry.example.com", false},
+		{"example.com", "registry.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := hostMatches(tt.registry, tt.host); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", tt.registry, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTLSOptionsFlags(t *testing.T) {
+	t.Setenv(caBundleEnv, "/etc/proxy-ca.pem")
+	t.Setenv(insecureSkipVerifyEnv, "a.example.com, b.example.com:5000,")
+	t.Setenv(plainHTTPEnv, "")
+
+	var opts TLSOptions
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterFlags(flagSet)
+	want := TLSOptions{CABundle: "/etc/proxy-ca.pem", InsecureSkipVerify: []string{"a.example.com", "b.example.com:5000"}}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("options from the environment = %+v, want %+v", opts, want)
+	}
+
+	if err := flagSet.Parse([]string{"--registry-ca-bundle", "ca.pem", "--registry-insecure-skip-verify", "c.example.com,d.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	want = TLSOptions{CABundle: "ca.pem", InsecureSkipVerify: []string{"a.example.com", "b.example.com:5000", "c.example.com", "d.example.com"}}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("options from flags = %+v, want %+v", opts, want)
+	}
+}
+
+// plainRegistry is a registry without TLS that records the paths it served.
+func plainRegistry(t *testing.T) (host string, requests *[]string) {
+	t.Helper()
+	requests = new([]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		*requests = append(*requests, req.Method+" "+req.URL.Path+" "+string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://"), requests
+}
+
+func TestNewTransportPlainHTTP(t *testing.T) {
+	host, requests := plainRegistry(t)
+	rt, err := newTransport(TLSOptions{PlainHTTP: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("newTransport() error = %v", err)
+	}
+	if err := get(rt, "https://"+host+"/v2/"); err != nil {
+		t.Errorf("GET error = %v, want the request to be sent over http", err)
+	}
+	if want := []string{"GET /v2/ "}; !reflect.DeepEqual(*requests, want) {
+		t.Errorf("registry received %q, want %q", *requests, want)
+	}
+
+	// other registries still use TLS
+	rt, err = newTransport(TLSOptions{PlainHTTP: []string{"localhost:5000"}})
+	if err != nil {
+		t.Fatalf("newTransport() error = %v", err)
+	}
+	if err := get(rt, "https://"+host+"/v2/"); err == nil || !strings.Contains(err.Error(), "does not look like a TLS handshake") {
+		t.Errorf("GET error = %v, want a TLS error", err)
+	}
+}
+
+func TestNewTransportHTTPFallback(t *testing.T) {
+	host, requests := plainRegistry(t)
+	rt, err := newTransport(TLSOptions{HTTPFallback: []string{host}})
+	if err != nil {
+		t.Fatalf("newTransport() error = %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/v2/app/blobs/uploads/", strings.NewReader("blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("POST error = %v, want a retry over http", err)
+	}
+	resp.Body.Close()
+	// the fallback is remembered for the host
+	if err := get(rt, "https://"+host+"/v2/"); err != nil {
+		t.Errorf("GET error = %v", err)
+	}
+	if want := []string{"POST /v2/app/blobs/uploads/ blob", "GET /v2/ "}; !reflect.DeepEqual(*requests, want) {
+		t.Errorf("registry received %q, want %q", *requests, want)
+	}
+
+	// a body that can't be replayed is not retried
+	req, err = http.NewRequest(http.MethodPost, "https://"+host+"/v2/other/blobs/uploads/", io.NopCloser(strings.NewReader("blob")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := newTransport(TLSOptions{HTTPFallback: []string{host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "does not look like a TLS handshake") {
+		t.Errorf("POST error = %v, want the TLS error", err)
+	}
+}
+
+func TestTLSOptionsPlainHTTPFlag(t *testing.T) {
+	t.Setenv(caBundleEnv, "")
+	t.Setenv(insecureSkipVerifyEnv, "")
+	t.Setenv(plainHTTPEnv, "localhost:5000")
+
+	var opts TLSOptions
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterFlags(flagSet)
+	if err := flagSet.Parse([]string{"--registry-plain-http", "kind-registry:5001"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"localhost:5000", "kind-registry:5001"}; !reflect.DeepEqual(opts.PlainHTTP, want) {
+		t.Errorf("PlainHTTP = %q, want %q", opts.PlainHTTP, want)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	bytestream_proto "google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc"
 
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
 	remoteexecution_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/remote-apis/build/bazel/remote/execution/v2"
 )
 
@@ -18,6 +19,7 @@ type CAS struct {
 	casClient        remoteexecution_proto.ContentAddressableStorageClient
 	byteStreamClient bytestream_proto.ByteStreamClient
 	capabilities     capabilities
+	ids              hermetic.IDSource
 }
 
 func New(clientConn *grpc.ClientConn, opts ...casOption) (*CAS, error) {
@@ -52,6 +54,7 @@ func New(clientConn *grpc.ClientConn, opts ...casOption) (*CAS, error) {
 		casClient:        casClient,
 		byteStreamClient: byteStreamClient,
 		capabilities:     capabilities,
+		ids:              hermetic.IDsOrRandom(casOpts.ids),
 	}, nil
 }
 
@@ -371,6 +374,7 @@ func (b *byteStreamReadCloser) nilOrEOF() error {
 type casOptions struct {
 	capabilities      capabilities
 	learnCapabilities bool
+	ids               hermetic.IDSource
 }
 
 type casOption func(*casOptions)
@@ -398,3 +402,10 @@ func WithSHA512(supported bool) casOption {
 		opts.capabilities.DigestFunctionSHA512 = supported
 	}
 }
+
+// WithIDSource sets the source of the upload identifiers used in ByteStream resource names.
+func WithIDSource(ids hermetic.IDSource) casOption {
+	return func(opts *casOptions) {
+		opts.ids = ids
+	}
+}
@@ -7,8 +7,6 @@ import (
 
 	"google.golang.org/genproto/googleapis/bytestream"
 
-	"github.com/google/uuid"
-
 	remoteexecution_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/remote-apis/build/bazel/remote/execution/v2"
 )
 
@@ -57,7 +55,7 @@ func (c *CAS) streamUploadOne(ctx context.Context, digest Digest, r io.Reader) e
 		return fmt.Errorf("creating bytestream client for writing: %w", casErr(err))
 	}
 
-	resourceName := fmt.Sprintf("uploads/%s/blobs/%x/%d", uuid.NewString(), digest.Hash, digest.SizeBytes)
+	resourceName := fmt.Sprintf("uploads/%s/blobs/%x/%d", c.ids.NewID(), digest.Hash, digest.SizeBytes)
 	buf := make([]byte, c.capabilities.MaxBatchTotalSizeBytes)
 	var offset int64
 	var eof bool
@@ -10,6 +10,10 @@ import (
 	pgzip "github.com/klauspost/pgzip"
 )
 
+// pgzipBlockSize is the size of the blocks that pgzip compresses in parallel.
+// Changing it changes the compressed output (and digests) of gzip layers.
+const pgzipBlockSize = 1 << 20
+
 // Appender appends data to a compressed blob, while
 // hashing the compressed and uncompressed data.
 // It implements the io.Writer interface.
@@ -172,14 +176,12 @@ func setupWriterPipeline[C Compressor, CM compressorMaker[C]](output io.Writer,
 	} else {
 		compress = compressorMaker.NewWriter(outputTee)
 	}
-	// Configure optional concurrency for compressors that support it (e.g., pgzip)
+	// Configure concurrency for compressors that support it (e.g., pgzip).
+	// The block size is fixed, so that the output doesn't depend on the number of jobs.
 	switch any(compress).(type) {
 	case *pgzip.Writer:
-		if opts.compressorJobs != nil {
-			jobs := *opts.compressorJobs
-			if err := any(compress).(*pgzip.Writer).SetConcurrency(1<<20, jobs); err != nil {
-				return nil, compress, err
-			}
+		if err := any(compress).(*pgzip.Writer).SetConcurrency(pgzipBlockSize, compressorJobs(opts)); err != nil {
+			return nil, compress, err
 		}
 	}
 	inputTee := io.MultiWriter(compress, contentHash)
@@ -0,0 +1,76 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// testData returns compressible data that spans multiple pgzip blocks.
+func testData(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"layer", "image", "manifest", "config", "blob", "digest", "tar", "gzip", "\n"}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rng.Intn(len(words))])
+		buf.WriteByte(byte(rng.Intn(256)))
+	}
+	return buf.Bytes()[:size]
+}
+
+func compressGzip(t testing.TB, data []byte, jobs int) []byte {
+	var out bytes.Buffer
+	appender, err := AppenderFactory("sha256", "gzip", &out, CompressorJobs(jobs), CompressionLevel(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in odd chunks, so that writes don't line up with blocks
+	for chunk := range slices.Chunk(data, 12345) {
+		if _, err := appender.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := appender.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestGzipOutputIndependentOfJobs(t *testing.T) {
+	data := testData(5*pgzipBlockSize + 4321)
+	want := compressGzip(t, data, 1)
+
+	reader, err := gzip.NewReader(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("decompressed data differs from input")
+	}
+
+	for _, jobs := range []int{2, 3, 8, -1} {
+		if got := compressGzip(t, data, jobs); !bytes.Equal(got, want) {
+			t.Errorf("output with %d jobs differs from output with 1 job", jobs)
+		}
+	}
+}
+
+func BenchmarkGzipAppender(b *testing.B) {
+	data := testData(16 * pgzipBlockSize)
+	for _, jobs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				compressGzip(b, data, jobs)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// externalCompressor pipes data through an external compressor binary (pigz or zstd).
+// Both compress with multiple threads, which is a lot faster than the pure Go compressors for huge layers.
+type externalCompressor struct {
+	binary string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+}
+
+// useExternalCompressor reports whether the options configure an external compressor.
+// A configured compressor is always used: if its binary is missing, creating the appender fails
+// instead of falling back to the pure Go compressors, which write layers with other digests.
+func useExternalCompressor(opts options) bool {
+	return opts.externalCompressor != ""
+}
+
+// externalCompressorArgs returns the arguments of pigz (for gzip) or zstd that compress stdin to stdout.
+func externalCompressorArgs(compressionAlgorithm string, opts options) ([]string, error) {
+	jobs := runtime.NumCPU()
+	if opts.compressorJobs != nil && *opts.compressorJobs > 0 {
+		jobs = *opts.compressorJobs
+	}
+	args := []string{"-c"}
+	switch compressionAlgorithm {
+	case "gzip":
+		// -n omits the name and modification time of the input, so that the output is reproducible.
+		args = append(args, "-n", "-p", strconv.Itoa(jobs))
+		if opts.compressionLevel != nil && *opts.compressionLevel >= 0 {
+			args = append(args, "-"+strconv.Itoa(int(*opts.compressionLevel)))
+		}
+	case "zstd":
+		args = append(args, "-q", "-T"+strconv.Itoa(jobs))
+		if opts.compressionLevel != nil {
+			args = append(args, "-"+strconv.Itoa(zstdCLILevel(int(*opts.compressionLevel))))
+		}
+	default:
+		return nil, fmt.Errorf("external compressors don't support %s", compressionAlgorithm)
+	}
+	return args, nil
+}
+
+func newExternalCompressor(binary, compressionAlgorithm string, w io.Writer, opts options) (*externalCompressor, error) {
+	args, err := externalCompressorArgs(compressionAlgorithm, opts)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(binary); err != nil {
+		return nil, fmt.Errorf("external compressor: %w", err)
+	} else if info.IsDir() {
+		return nil, fmt.Errorf("external compressor %s is a directory", binary)
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = w
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting external compressor %s: %w", binary, err)
+	}
+	return &externalCompressor{
+		binary: binary,
+		cmd:    cmd,
+		stdin:  stdin,
+		stderr: stderr,
+	}, nil
+}
+
+func (e *externalCompressor) Write(p []byte) (int, error) {
+	return e.stdin.Write(p)
+}
+
+// Flush is a no-op: external compressors decide on their own when to write compressed blocks.
+func (e *externalCompressor) Flush() error {
+	return nil
+}
+
+// Close closes the input of the compressor and waits until all compressed data is written.
+func (e *externalCompressor) Close() error {
+	if err := e.stdin.Close(); err != nil {
+		return err
+	}
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("external compressor %s: %w: %s", e.binary, err, strings.TrimSpace(e.stderr.String()))
+	}
+	return nil
+}
+
+// zstdCLILevel converts the compression levels of the pure Go zstd encoder (1-4)
+// to the closest levels of the zstd CLI.
+func zstdCLILevel(level int) int {
+	switch level {
+	case 1:
+		return 1
+	case 2:
+		return 3
+	case 3:
+		return 7
+	case 4:
+		return 11
+	}
+	return 3
+}
+
+// newSHA256ExternalAppender creates an Appender that compresses with an external compressor.
+// Appenders can't be resumed with external compressors, but the pure Go compressors can resume them.
+func newSHA256ExternalAppender(compressionAlgorithm string, output io.Writer, opts options) (Appender[*externalCompressor], error) {
+	var hashMaker SHA256Maker
+	outerHash := hashMaker.New()
+	contentHash := hashMaker.New()
+	outputWriter := &countingWriter{w: output}
+	compressor, err := newExternalCompressor(opts.externalCompressor, compressionAlgorithm, io.MultiWriter(outputWriter, outerHash), opts)
+	if err != nil {
+		return Appender[*externalCompressor]{}, err
+	}
+	return Appender[*externalCompressor]{
+		hashFunctionName:         hashMaker.Name(),
+		compressionAlgorithmName: compressionAlgorithm,
+		outerHash:                outerHash,
+		contentHash:              contentHash,
+		compressor:               compressor,
+		pipelineWriter:           &countingWriter{w: io.MultiWriter(compressor, contentHash)},
+		outputWriter:             outputWriter,
+		options:                  opts,
+	}, nil
+}
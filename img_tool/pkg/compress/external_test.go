@@ -0,0 +1,95 @@
+package compress
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExternalCompressorArgs(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		opts      []Option
+		want      []string
+	}{
+		{"gzip", []Option{CompressorJobs(4)}, []string{"-c", "-n", "-p", "4"}},
+		{"gzip", []Option{CompressorJobs(4), CompressionLevel(9)}, []string{"-c", "-n", "-p", "4", "-9"}},
+		{"gzip", []Option{CompressorJobs(2), CompressionLevel(-1)}, []string{"-c", "-n", "-p", "2"}},
+		{"gzip", nil, []string{"-c", "-n", "-p", strconv.Itoa(runtime.NumCPU())}},
+		{"zstd", []Option{CompressorJobs(8)}, []string{"-c", "-q", "-T8"}},
+		{"zstd", []Option{CompressorJobs(8), CompressionLevel(1)}, []string{"-c", "-q", "-T8", "-1"}},
+		{"zstd", []Option{CompressorJobs(8), CompressionLevel(4)}, []string{"-c", "-q", "-T8", "-11"}},
+	}
+	for _, tt := range tests {
+		got, err := externalCompressorArgs(tt.algorithm, collectOptions(tt.opts...))
+		if err != nil {
+			t.Errorf("externalCompressorArgs(%s) error = %v", tt.algorithm, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("externalCompressorArgs(%s) = %q, want %q", tt.algorithm, got, tt.want)
+		}
+	}
+
+	if _, err := externalCompressorArgs("uncompressed", collectOptions()); err == nil {
+		t.Error("externalCompressorArgs(uncompressed) succeeded, want an error")
+	}
+}
+
+// TestExternalCompressorCommand runs a fake compressor that records its arguments and copies its input.
+func TestExternalCompressorCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake compressor is a shell script")
+	}
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	pigz := filepath.Join(dir, "pigz")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\nexec cat\n"
+	if err := os.WriteFile(pigz, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	appender, err := AppenderFactory("sha256", "gzip", &out, ExternalCompressor(pigz), CompressorJobs(3), CompressionLevel(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appender.Write([]byte("layer content")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appender.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "layer content" {
+		t.Errorf("output = %q, want the input copied by the fake compressor", got)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(args)), "-c -n -p 3 -7"; got != want {
+		t.Errorf("pigz was called with %q, want %q", got, want)
+	}
+}
+
+// TestExternalCompressorMissing checks that a configured compressor that doesn't exist is an error,
+// instead of a silent fallback to the pure Go compressors (which write layers with other digests).
+func TestExternalCompressorMissing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "zstd")
+	for _, algorithm := range []string{"gzip", "zstd"} {
+		if _, err := AppenderFactory("sha256", algorithm, &bytes.Buffer{}, ExternalCompressor(missing)); err == nil || !strings.Contains(err.Error(), "external compressor") {
+			t.Errorf("AppenderFactory(%s) with a missing compressor: error = %v, want an external compressor error", algorithm, err)
+		}
+		if _, err := TarAppenderFactory("sha256", algorithm, false, &bytes.Buffer{}, ExternalCompressor(missing)); err == nil || !strings.Contains(err.Error(), "external compressor") {
+			t.Errorf("TarAppenderFactory(%s) with a missing compressor: error = %v, want an external compressor error", algorithm, err)
+		}
+	}
+	if _, err := AppenderFactory("sha256", "gzip", &bytes.Buffer{}, ExternalCompressor(t.TempDir())); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("AppenderFactory with a directory as compressor: error = %v, want an error", err)
+	}
+}
@@ -1,7 +1,6 @@
 package compress
 
 import (
-	"compress/gzip"
 	"crypto/sha256"
 	"errors"
 	"io"
@@ -24,25 +23,13 @@ func (SHA256Maker) Name() string {
 	return "sha256"
 }
 
-type GZipMaker struct{}
-
-func (GZipMaker) NewWriter(w io.Writer) *gzip.Writer {
-	return gzip.NewWriter(w)
-}
-
-func (GZipMaker) NewWriterLevel(w io.Writer, level int) (*gzip.Writer, error) {
-	return gzip.NewWriterLevel(w, level)
-}
-
-func (GZipMaker) Name() string {
-	return "gzip"
-}
-
-// PGZipMaker uses klauspost/pgzip (parallel gzip)
+// PGZipMaker uses klauspost/pgzip (parallel gzip).
+// pgzip compresses fixed-size blocks in parallel, using the end of the previous block as dictionary.
+// The output only depends on the block size, so it is the same for any number of compressor jobs.
 type PGZipMaker struct{}
 
 func (PGZipMaker) NewWriter(w io.Writer) *pgzip.Writer {
-	// default pgzip writer; caller may adjust concurrency via options in pipeline
+	// default pgzip writer; the pipeline sets the block size and concurrency
 	return pgzip.NewWriter(w)
 }
 
@@ -97,18 +84,10 @@ func (ZstdMaker) Name() string {
 	return "zstd"
 }
 
-func NewSHA256GzipAppender(w io.Writer, options ...Option) (Appender[*gzip.Writer], error) {
-	return New[*gzip.Writer, SHA256Maker, GZipMaker](w, options...)
-}
-
 func NewSHA256PGzipAppender(w io.Writer, options ...Option) (Appender[*pgzip.Writer], error) {
 	return New[*pgzip.Writer, SHA256Maker, PGZipMaker](w, options...)
 }
 
-func ResumeSHA256GzipAppender(state api.AppenderState, w io.Writer, options ...Option) (Appender[*gzip.Writer], error) {
-	return Resume[*gzip.Writer, SHA256Maker, GZipMaker](state, w, options...)
-}
-
 func ResumeSHA256PGzipAppender(state api.AppenderState, w io.Writer, options ...Option) (Appender[*pgzip.Writer], error) {
 	return Resume[*pgzip.Writer, SHA256Maker, PGZipMaker](state, w, options...)
 }
@@ -153,6 +132,18 @@ func ResumeSHA256EstargzZstdTarAppender(state api.AppenderState, w io.Writer, op
 	return &appender, nil
 }
 
+// compressorJobs returns the number of parallel compressor jobs of the options.
+// Negative values use all CPUs.
+func compressorJobs(opts options) int {
+	if opts.compressorJobs == nil || *opts.compressorJobs == 0 {
+		return 1
+	}
+	if *opts.compressorJobs < 0 {
+		return runtime.NumCPU()
+	}
+	return *opts.compressorJobs
+}
+
 func collectOptions(optionsList ...Option) options {
 	opts := options{}
 	for _, o := range optionsList {
@@ -164,22 +155,10 @@ func collectOptions(optionsList ...Option) options {
 func AppenderFactory(hashAlgorithm, compressionAlgorithm string, w io.Writer, optionsList ...Option) (api.Appender, error) {
 	opts := collectOptions(optionsList...)
 	switch {
+	case hashAlgorithm == "sha256" && (compressionAlgorithm == "gzip" || compressionAlgorithm == "zstd") && useExternalCompressor(opts):
+		return newSHA256ExternalAppender(compressionAlgorithm, w, opts)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip":
-		// Use pgzip if jobs > 1 or == -1 (auto) and CPUs > 1
-		usePGzip := false
-		if opts.compressorJobs != nil {
-			jobs := *opts.compressorJobs
-			if jobs < 0 {
-				jobs = runtime.NumCPU()
-			}
-			if jobs > 1 {
-				usePGzip = true
-			}
-		}
-		if usePGzip {
-			return NewSHA256PGzipAppender(w, optionsList...)
-		}
-		return NewSHA256GzipAppender(w, optionsList...)
+		return NewSHA256PGzipAppender(w, optionsList...)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "zstd":
 		return NewSHA256ZstdAppender(w, optionsList...)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "uncompressed":
@@ -189,23 +168,9 @@ func AppenderFactory(hashAlgorithm, compressionAlgorithm string, w io.Writer, op
 }
 
 func ResumeFactory(hashAlgorithm, compressionAlgorithm string, state api.AppenderState, w io.Writer, optionsList ...Option) (api.Appender, error) {
-	opts := collectOptions(optionsList...)
 	switch {
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip":
-		usePGzip := false
-		if opts.compressorJobs != nil {
-			jobs := *opts.compressorJobs
-			if jobs < 0 {
-				jobs = runtime.NumCPU()
-			}
-			if jobs > 1 {
-				usePGzip = true
-			}
-		}
-		if usePGzip {
-			return ResumeSHA256PGzipAppender(state, w, optionsList...)
-		}
-		return ResumeSHA256GzipAppender(state, w, optionsList...)
+		return ResumeSHA256PGzipAppender(state, w, optionsList...)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "zstd":
 		return ResumeSHA256ZstdAppender(state, w, optionsList...)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "uncompressed":
@@ -220,25 +185,14 @@ func TarAppenderFactory(hashAlgorithm, compressionAlgorithm string, seekable boo
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip" && seekable:
 		// estargz path: cannot (easily) parallelize gzip here
 		return NewSHA256EstargzGzipTarAppender(w, optionsList...)
-	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip" && !seekable:
-		usePGzip := false
-		if opts.compressorJobs != nil {
-			jobs := *opts.compressorJobs
-			if jobs < 0 {
-				jobs = runtime.NumCPU()
-			}
-			if jobs > 1 {
-				usePGzip = true
-			}
-		}
-		if usePGzip {
-			appender, err := NewSHA256PGzipAppender(w, optionsList...)
-			if err != nil {
-				return nil, err
-			}
-			return appender.TarAppender(), nil
+	case hashAlgorithm == "sha256" && (compressionAlgorithm == "gzip" || compressionAlgorithm == "zstd") && !seekable && useExternalCompressor(opts):
+		appender, err := newSHA256ExternalAppender(compressionAlgorithm, w, opts)
+		if err != nil {
+			return nil, err
 		}
-		appender, err := NewSHA256GzipAppender(w, optionsList...)
+		return appender.TarAppender(), nil
+	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip" && !seekable:
+		appender, err := NewSHA256PGzipAppender(w, optionsList...)
 		if err != nil {
 			return nil, err
 		}
@@ -251,34 +205,23 @@ func TarAppenderFactory(hashAlgorithm, compressionAlgorithm string, seekable boo
 			return nil, err
 		}
 		return appender.TarAppender(), nil
+
+	case hashAlgorithm == "sha256" && compressionAlgorithm == "uncompressed" && !seekable:
+		appender, err := New[nopCompressor, SHA256Maker, UncompressedMaker](w, optionsList...)
+		if err != nil {
+			return nil, err
+		}
+		return appender.TarAppender(), nil
 	}
 	return nil, errors.New("unsupported hash or compression algorithm")
 }
 
 func ResumeTarFactory(hashAlgorithm, compressionAlgorithm string, seekable bool, state api.AppenderState, w io.Writer, optionsList ...Option) (api.TarAppender, error) {
-	opts := collectOptions(optionsList...)
 	switch {
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip" && seekable:
 		return ResumeSHA256EstargzGzipTarAppender(state, w, optionsList...)
 	case hashAlgorithm == "sha256" && compressionAlgorithm == "gzip" && !seekable:
-		usePGzip := false
-		if opts.compressorJobs != nil {
-			jobs := *opts.compressorJobs
-			if jobs < 0 {
-				jobs = runtime.NumCPU()
-			}
-			if jobs > 1 {
-				usePGzip = true
-			}
-		}
-		if usePGzip {
-			appender, err := ResumeSHA256PGzipAppender(state, w, optionsList...)
-			if err != nil {
-				return nil, err
-			}
-			return appender.TarAppender(), nil
-		}
-		appender, err := ResumeSHA256GzipAppender(state, w, optionsList...)
+		appender, err := ResumeSHA256PGzipAppender(state, w, optionsList...)
 		if err != nil {
 			return nil, err
 		}
@@ -291,6 +234,13 @@ func ResumeTarFactory(hashAlgorithm, compressionAlgorithm string, seekable bool,
 			return nil, err
 		}
 		return appender.TarAppender(), nil
+
+	case hashAlgorithm == "sha256" && compressionAlgorithm == "uncompressed" && !seekable:
+		appender, err := Resume[nopCompressor, SHA256Maker, UncompressedMaker](state, w, optionsList...)
+		if err != nil {
+			return nil, err
+		}
+		return appender.TarAppender(), nil
 	}
 	return nil, errors.New("unsupported hash or compression algorithm")
 }
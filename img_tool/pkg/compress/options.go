@@ -16,9 +16,13 @@ type options struct {
     contentType      ContentType
     compressionLevel *CompressionLevel
     compressorJobs   *int
+    externalCompressor string
 }
 
 func (c ContentType) apply(opts *options)      { opts.contentType = c }
 func (l CompressionLevel) apply(opts *options) { opts.compressionLevel = &l }
 type CompressorJobs int
 func (j CompressorJobs) apply(opts *options)   { v := int(j); opts.compressorJobs = &v }
+// ExternalCompressor is the path of a pigz or zstd binary that compresses instead of the pure Go compressors.
+type ExternalCompressor string
+func (e ExternalCompressor) apply(opts *options) { opts.externalCompressor = string(e) }
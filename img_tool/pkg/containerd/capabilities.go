@@ -0,0 +1,57 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	introspectionapi "github.com/containerd/containerd/api/services/introspection/v1"
+	versionapi "github.com/containerd/containerd/api/services/version/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Capabilities summarizes the features of a containerd daemon that matter for loading images.
+type Capabilities struct {
+	Address  string
+	Version  string
+	Revision string
+	// Snapshotters lists the snapshotter plugins that initialized successfully.
+	Snapshotters []string
+}
+
+// Capabilities queries the version and the available snapshotters of the daemon.
+// A failure means that the daemon does not speak the containerd API we rely on.
+func (c *Client) Capabilities(ctx context.Context) (Capabilities, error) {
+	version, err := versionapi.NewVersionClient(c.conn).Version(ctx, &emptypb.Empty{})
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("querying containerd version: %w", err)
+	}
+	caps := Capabilities{
+		Address:  c.address,
+		Version:  version.Version,
+		Revision: version.Revision,
+	}
+
+	plugins, err := introspectionapi.NewIntrospectionClient(c.conn).Plugins(ctx, &introspectionapi.PluginsRequest{
+		Filters: []string{"type==io.containerd.snapshotter.v1"},
+	})
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("listing containerd snapshotters: %w", err)
+	}
+	for _, plugin := range plugins.Plugins {
+		if plugin.InitErr != nil {
+			continue
+		}
+		caps.Snapshotters = append(caps.Snapshotters, plugin.ID)
+	}
+	return caps, nil
+}
+
+// String returns a one-line summary of the capabilities.
+func (c Capabilities) String() string {
+	snapshotters := "none"
+	if len(c.Snapshotters) > 0 {
+		snapshotters = strings.Join(c.Snapshotters, ", ")
+	}
+	return fmt.Sprintf("containerd %s at %s, snapshotters: %s", c.Version, c.Address, snapshotters)
+}
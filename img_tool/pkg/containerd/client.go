@@ -6,24 +6,45 @@ import (
 	"net"
 	"time"
 
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
 	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
 	imagesapi "github.com/containerd/containerd/api/services/images/v1"
 	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
 )
 
 // Client is a minimal containerd client
 type Client struct {
-	conn          *grpc.ClientConn
-	contentClient contentapi.ContentClient
-	imagesClient  imagesapi.ImagesClient
-	leasesClient  leasesapi.LeasesClient
-	address       string
+	conn             *grpc.ClientConn
+	contentClient    contentapi.ContentClient
+	imagesClient     imagesapi.ImagesClient
+	leasesClient     leasesapi.LeasesClient
+	containersClient containersapi.ContainersClient
+	snapshotsClient  snapshotsapi.SnapshotsClient
+	diffClient       diffapi.DiffClient
+	tasksClient      tasksapi.TasksClient
+	address          string
+	ids              hermetic.IDSource
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithIDSource sets the source of the lease IDs and content write refs created by the client.
+func WithIDSource(ids hermetic.IDSource) ClientOption {
+	return func(c *Client) {
+		c.ids = ids
+	}
 }
 
 // New creates a new containerd client
-func New(address string) (*Client, error) {
+func New(address string, opts ...ClientOption) (*Client, error) {
 	if address == "" {
 		addr, err := FindContainerdSocket()
 		if err != nil {
@@ -50,13 +71,22 @@ func New(address string) (*Client, error) {
 		return nil, fmt.Errorf("failed to connect to containerd: %w", err)
 	}
 
-	return &Client{
-		conn:          conn,
-		contentClient: contentapi.NewContentClient(conn),
-		imagesClient:  imagesapi.NewImagesClient(conn),
-		leasesClient:  leasesapi.NewLeasesClient(conn),
-		address:       address,
-	}, nil
+	c := &Client{
+		conn:             conn,
+		contentClient:    contentapi.NewContentClient(conn),
+		imagesClient:     imagesapi.NewImagesClient(conn),
+		leasesClient:     leasesapi.NewLeasesClient(conn),
+		containersClient: containersapi.NewContainersClient(conn),
+		snapshotsClient:  snapshotsapi.NewSnapshotsClient(conn),
+		diffClient:       diffapi.NewDiffClient(conn),
+		tasksClient:      tasksapi.NewTasksClient(conn),
+		address:          address,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.ids = hermetic.IDsOrRandom(c.ids)
+	return c, nil
 }
 
 // Close closes the client connection
@@ -66,14 +96,34 @@ func (c *Client) Close() error {
 
 // ContentStore returns the content store
 func (c *Client) ContentStore() Store {
-	return &contentStore{client: c.contentClient}
+	return &contentStore{client: c.contentClient, ids: c.ids}
 }
 
 func (c *Client) LeaseService() LeaseService {
-	return &leaseService{client: c.leasesClient}
+	return &leaseService{client: c.leasesClient, ids: c.ids}
 }
 
 // ImageService returns the image service
 func (c *Client) ImageService() ImageService {
 	return &imageService{client: c.imagesClient}
 }
+
+// ContainerService returns the container service
+func (c *Client) ContainerService() ContainerService {
+	return &containerService{client: c.containersClient}
+}
+
+// SnapshotService returns the snapshot service
+func (c *Client) SnapshotService() SnapshotService {
+	return &snapshotService{client: c.snapshotsClient}
+}
+
+// DiffService returns the diff service
+func (c *Client) DiffService() DiffService {
+	return &diffService{client: c.diffClient}
+}
+
+// TaskService returns the task service
+func (c *Client) TaskService() TaskService {
+	return &taskService{client: c.tasksClient}
+}
@@ -0,0 +1,44 @@
+package containerd
+
+import (
+	"context"
+
+	api "github.com/containerd/containerd/api/services/containers/v1"
+)
+
+// ContainerService is the container service interface
+type ContainerService interface {
+	Get(ctx context.Context, id string) (Container, error)
+}
+
+type containerService struct {
+	client api.ContainersClient
+}
+
+// Get returns the container with the given ID
+func (s *containerService) Get(ctx context.Context, id string) (Container, error) {
+	resp, err := s.client.Get(ctx, &api.GetContainerRequest{
+		ID: id,
+	})
+	if err != nil {
+		return Container{}, err
+	}
+
+	return Container{
+		ID:          resp.Container.ID,
+		Image:       resp.Container.Image,
+		Labels:      resp.Container.Labels,
+		Snapshotter: resp.Container.Snapshotter,
+		SnapshotKey: resp.Container.SnapshotKey,
+	}, nil
+}
+
+// Container represents a container
+type Container struct {
+	ID          string
+	Image       string
+	Labels      map[string]string
+	Snapshotter string
+	// SnapshotKey is the key of the active (writable) snapshot of the container's rootfs.
+	SnapshotKey string
+}
@@ -2,8 +2,6 @@ package containerd
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"strings"
@@ -15,6 +13,8 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
 )
 
 // Store is the content store interface
@@ -25,6 +25,7 @@ type Store interface {
 
 type contentStore struct {
 	client api.ContentClient
+	ids    hermetic.IDSource
 }
 
 // Info returns the info for a content
@@ -56,7 +57,7 @@ func (s *contentStore) Writer(ctx context.Context, opts ...WriterOpt) (Writer, e
 
 	// Generate a unique ref if not provided
 	if wOpts.Ref == "" {
-		wOpts.Ref = generateContentWriteRef()
+		wOpts.Ref = "write-" + s.ids.NewID()
 	}
 
 	stream, err := s.client.Write(ctx)
@@ -251,12 +252,6 @@ func (w *contentWriter) Truncate(size int64) error {
 
 // Helper functions and types
 
-func generateContentWriteRef() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return "write-" + hex.EncodeToString(b)
-}
-
 // WriterOpt is an option for creating a writer
 type WriterOpt func(*WriterOpts)
 
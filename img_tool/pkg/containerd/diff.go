@@ -0,0 +1,46 @@
+package containerd
+
+import (
+	"context"
+
+	api "github.com/containerd/containerd/api/services/diff/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DiffService is the diff service interface
+type DiffService interface {
+	Apply(ctx context.Context, layer ocispec.Descriptor, mounts []Mount) (ocispec.Descriptor, error)
+}
+
+type diffService struct {
+	client api.DiffClient
+}
+
+// Apply unpacks a layer from the content store onto the given mounts
+// and returns the descriptor of the applied (uncompressed) diff.
+func (s *diffService) Apply(ctx context.Context, layer ocispec.Descriptor, mounts []Mount) (ocispec.Descriptor, error) {
+	req := &api.ApplyRequest{
+		Diff: &types.Descriptor{
+			MediaType: layer.MediaType,
+			Digest:    layer.Digest.String(),
+			Size:      layer.Size,
+		},
+		SyncFs: true,
+	}
+	for _, m := range mounts {
+		req.Mounts = append(req.Mounts, mountToProto(m))
+	}
+
+	resp, err := s.client.Apply(ctx, req)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	return ocispec.Descriptor{
+		MediaType: resp.Applied.MediaType,
+		Digest:    digest.Digest(resp.Applied.Digest),
+		Size:      resp.Applied.Size,
+	}, nil
+}
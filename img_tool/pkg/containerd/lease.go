@@ -2,10 +2,10 @@ package containerd
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 
 	api "github.com/containerd/containerd/api/services/leases/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
 )
 
 // ImageService is the image service interface
@@ -16,18 +16,13 @@ type LeaseService interface {
 
 type leaseService struct {
 	client api.LeasesClient
-}
-
-func generateLeaseID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return "lease-" + hex.EncodeToString(b)
+	ids    hermetic.IDSource
 }
 
 // Create creates a new image
 func (s *leaseService) Create(ctx context.Context, labels map[string]string) (string, error) {
 	req := &api.CreateRequest{
-		ID:     generateLeaseID(),
+		ID:     "lease-" + s.ids.NewID(),
 		Labels: labels,
 	}
 
@@ -0,0 +1,86 @@
+package containerd
+
+import (
+	"context"
+
+	api "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+)
+
+// SnapshotService is the snapshot service interface
+type SnapshotService interface {
+	Stat(ctx context.Context, snapshotter, key string) (SnapshotInfo, error)
+	Mounts(ctx context.Context, snapshotter, key string) ([]Mount, error)
+}
+
+type snapshotService struct {
+	client api.SnapshotsClient
+}
+
+// Stat returns the info of a snapshot
+func (s *snapshotService) Stat(ctx context.Context, snapshotter, key string) (SnapshotInfo, error) {
+	resp, err := s.client.Stat(ctx, &api.StatSnapshotRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+	})
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	return SnapshotInfo{
+		Name:   resp.Info.Name,
+		Parent: resp.Info.Parent,
+		Labels: resp.Info.Labels,
+	}, nil
+}
+
+// Mounts returns the mounts of an active snapshot
+func (s *snapshotService) Mounts(ctx context.Context, snapshotter, key string) ([]Mount, error) {
+	resp, err := s.client.Mounts(ctx, &api.MountsRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := make([]Mount, len(resp.Mounts))
+	for i, m := range resp.Mounts {
+		mounts[i] = mountFromProto(m)
+	}
+	return mounts, nil
+}
+
+// SnapshotInfo contains snapshot info
+type SnapshotInfo struct {
+	Name string
+	// Parent is the name of the parent snapshot (the chain ID of the topmost image layer for image snapshots).
+	Parent string
+	Labels map[string]string
+}
+
+// Mount describes a mount of a snapshot
+type Mount struct {
+	Type    string
+	Source  string
+	Target  string
+	Options []string
+}
+
+func mountFromProto(m *types.Mount) Mount {
+	return Mount{
+		Type:    m.Type,
+		Source:  m.Source,
+		Target:  m.Target,
+		Options: m.Options,
+	}
+}
+
+func mountToProto(m Mount) *types.Mount {
+	return &types.Mount{
+		Type:    m.Type,
+		Source:  m.Source,
+		Target:  m.Target,
+		Options: m.Options,
+	}
+}
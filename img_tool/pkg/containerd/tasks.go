@@ -0,0 +1,43 @@
+package containerd
+
+import (
+	"context"
+
+	api "github.com/containerd/containerd/api/services/tasks/v1"
+)
+
+// TaskService is the task service interface
+type TaskService interface {
+	Pause(ctx context.Context, containerID string) error
+	Resume(ctx context.Context, containerID string) error
+	Kill(ctx context.Context, containerID string, signal uint32) error
+}
+
+type taskService struct {
+	client api.TasksClient
+}
+
+// Pause freezes all processes of the task of a container
+func (s *taskService) Pause(ctx context.Context, containerID string) error {
+	_, err := s.client.Pause(ctx, &api.PauseTaskRequest{
+		ContainerID: containerID,
+	})
+	return err
+}
+
+// Resume thaws the processes of a paused task
+func (s *taskService) Resume(ctx context.Context, containerID string) error {
+	_, err := s.client.Resume(ctx, &api.ResumeTaskRequest{
+		ContainerID: containerID,
+	})
+	return err
+}
+
+// Kill sends a signal to the init process of the task of a container
+func (s *taskService) Kill(ctx context.Context, containerID string, signal uint32) error {
+	_, err := s.client.Kill(ctx, &api.KillRequest{
+		ContainerID: containerID,
+		Signal:      signal,
+	})
+	return err
+}
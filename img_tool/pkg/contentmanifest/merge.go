@@ -0,0 +1,73 @@
+package contentmanifest
+
+import (
+	"bytes"
+	"iter"
+	"slices"
+)
+
+// Merge writes a single content manifest with the blobs, nodes, and trees of all content manifests of the importer.
+// Hashes are sorted and deduplicated, so the merged manifest doesn't depend on the order of the inputs
+// and can replace all of them for deduplication.
+func Merge(outputPath string, importer *MultiImporter) error {
+	blobs, err := collectHashes(importer.BlobHashes())
+	if err != nil {
+		return err
+	}
+	nodes, err := collectHashes(importer.NodeHashes())
+	if err != nil {
+		return err
+	}
+	trees, err := collectHashes(importer.TreeHashes())
+	if err != nil {
+		return err
+	}
+	return New(outputPath, importer.algorithm).Export(mergedState{
+		blobs: blobs,
+		nodes: nodes,
+		trees: trees,
+	})
+}
+
+func collectHashes(hashes iter.Seq2[[]byte, error]) ([][]byte, error) {
+	var collected [][]byte
+	for hash, err := range hashes {
+		if err != nil {
+			return nil, err
+		}
+		// empty sections yield a nil hash
+		if hash != nil {
+			collected = append(collected, hash)
+		}
+	}
+	slices.SortFunc(collected, bytes.Compare)
+	return slices.CompactFunc(collected, bytes.Equal), nil
+}
+
+type mergedState struct {
+	blobs [][]byte
+	nodes [][]byte
+	trees [][]byte
+}
+
+func (s mergedState) BlobHashes() iter.Seq2[[]byte, error] {
+	return hashSeq(s.blobs)
+}
+
+func (s mergedState) NodeHashes() iter.Seq2[[]byte, error] {
+	return hashSeq(s.nodes)
+}
+
+func (s mergedState) TreeHashes() iter.Seq2[[]byte, error] {
+	return hashSeq(s.trees)
+}
+
+func hashSeq(hashes [][]byte) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for _, hash := range hashes {
+			if !yield(hash, nil) {
+				return
+			}
+		}
+	}
+}
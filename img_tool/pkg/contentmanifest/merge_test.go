@@ -0,0 +1,176 @@
+package contentmanifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	a := writeManifest(t, filepath.Join(dir, "a.cm"), mergedState{
+		blobs: hashes("blob 3", "blob 1"),
+		nodes: hashes("node 1"),
+	})
+	b := writeManifest(t, filepath.Join(dir, "b.cm"), mergedState{
+		blobs: hashes("blob 2", "blob 1"),
+		trees: hashes("tree 1"),
+	})
+
+	merged := filepath.Join(dir, "merged.cm")
+	if err := Merge(merged, NewMultiImporter([]string{a, b}, api.SHA256)); err != nil {
+		t.Fatal(err)
+	}
+	got := New(merged, api.SHA256)
+	assertHashes(t, "blobs", got.BlobHashes(), sortedHashes("blob 1", "blob 2", "blob 3"))
+	assertHashes(t, "nodes", got.NodeHashes(), sortedHashes("node 1"))
+	assertHashes(t, "trees", got.TreeHashes(), sortedHashes("tree 1"))
+
+	// the merged manifest doesn't depend on the order of the inputs
+	reversed := filepath.Join(dir, "reversed.cm")
+	if err := Merge(reversed, NewMultiImporter([]string{b, a}, api.SHA256)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readFile(t, merged), readFile(t, reversed)) {
+		t.Error("Merge() output depends on the order of the inputs")
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.cm")
+	if err := os.WriteFile(invalid, bytes.Repeat([]byte("x"), 1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		inputs  []string
+		wantErr string
+	}{
+		{
+			name:    "missing content manifest",
+			inputs:  []string{filepath.Join(dir, "missing.cm")},
+			wantErr: "no such file or directory",
+		},
+		{
+			name:    "not a content manifest",
+			inputs:  []string{invalid},
+			wantErr: "magic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, "merged.cm")
+			err := Merge(output, NewMultiImporter(tt.inputs, api.SHA256))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Merge() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddCollection(t *testing.T) {
+	dir := t.TempDir()
+	a := writeManifest(t, filepath.Join(dir, "a.cm"), mergedState{blobs: hashes("blob 1")})
+	b := writeManifest(t, filepath.Join(dir, "b.cm"), mergedState{blobs: hashes("blob 2")})
+	list := filepath.Join(dir, "collection.txt")
+	if err := os.WriteFile(list, []byte(a+"\n"+b+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	merged := filepath.Join(dir, "merged.cm")
+	if err := Merge(merged, NewMultiImporter([]string{a, b}, api.SHA256)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		collection string
+	}{
+		{name: "list of content manifests", collection: list},
+		{name: "merged content manifest", collection: merged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			importer := NewMultiImporter(nil, api.SHA256)
+			if err := importer.AddCollection(tt.collection); err != nil {
+				t.Fatal(err)
+			}
+			assertHashes(t, "blobs", sortedSeq(t, importer.BlobHashes()), sortedHashes("blob 1", "blob 2"))
+		})
+	}
+
+	t.Run("missing collection", func(t *testing.T) {
+		importer := NewMultiImporter(nil, api.SHA256)
+		if err := importer.AddCollection(filepath.Join(dir, "missing.txt")); err == nil {
+			t.Error("AddCollection() of a missing file succeeded, want error")
+		}
+	})
+}
+
+func writeManifest(t *testing.T, path string, state mergedState) string {
+	t.Helper()
+	if err := New(path, api.SHA256).Export(state); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func hashes(contents ...string) [][]byte {
+	var result [][]byte
+	for _, content := range contents {
+		hash := sha256.Sum256([]byte(content))
+		result = append(result, hash[:])
+	}
+	return result
+}
+
+func sortedHashes(contents ...string) [][]byte {
+	result, _ := collectHashes(hashSeq(hashes(contents...)))
+	return result
+}
+
+// sortedSeq sorts the hashes of a sequence, so that they can be compared independent of the order of the inputs.
+func sortedSeq(t *testing.T, seq iter.Seq2[[]byte, error]) iter.Seq2[[]byte, error] {
+	t.Helper()
+	sorted, err := collectHashes(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashSeq(sorted)
+}
+
+func assertHashes(t *testing.T, kind string, seq iter.Seq2[[]byte, error], want [][]byte) {
+	t.Helper()
+	var got [][]byte
+	for hash, err := range seq {
+		if err != nil {
+			t.Fatalf("reading %s: %v", kind, err)
+		}
+		if hash != nil {
+			got = append(got, hash)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s = %x, want %x", kind, got, want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("%s[%d] = %x, want %x", kind, i, got[i], want[i])
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -25,6 +25,9 @@ func (i *MultiImporter) AddOne(manifestPath string) {
 	i.manifestPaths = append(i.manifestPaths, manifestPath)
 }
 
+// AddCollection adds the content manifests of a collection file.
+// A collection is either a text file with one path of a content manifest per line,
+// or a content manifest itself (like the output of "img content-manifest merge").
 func (i *MultiImporter) AddCollection(collectionPath string) error {
 	collection, err := i.fs.Open(collectionPath)
 	if err != nil {
@@ -32,7 +35,12 @@ func (i *MultiImporter) AddCollection(collectionPath string) error {
 	}
 	defer collection.Close()
 
-	scanner := bufio.NewScanner(collection)
+	reader := bufio.NewReader(collection)
+	if magic, _ := reader.Peek(len(magicPrefix)); string(magic) == magicPrefix {
+		i.manifestPaths = append(i.manifestPaths, collectionPath)
+		return nil
+	}
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		i.manifestPaths = append(i.manifestPaths, scanner.Text())
 	}
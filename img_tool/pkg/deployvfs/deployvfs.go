@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strings"
 
@@ -214,6 +215,18 @@ func (vfs *VFS) DigestsFromImage(root registryv1.Hash) ([]registryv1.Hash, error
 	return digests, nil
 }
 
+// Location returns the source of the blob or manifest with the given digest.
+// It is one of "file", "registry", "remote_cache", or "stub".
+func (vfs *VFS) Location(digest registryv1.Hash) (string, error) {
+	entry, found := vfs.blobs[digest.String()]
+	if !found {
+		if entry, found = vfs.manifests[digest.String()]; !found {
+			return "", fmt.Errorf("blob or manifest with digest %s not found in VFS", digest.String())
+		}
+	}
+	return entry.Location, nil
+}
+
 func (vfs *VFS) SizeOf(digest registryv1.Hash) (int64, error) {
 	entry, found := vfs.blobs[digest.String()]
 	if !found {
@@ -228,6 +241,7 @@ type vfsBuilder struct {
 	dm                       api.DeployManifest
 	casReader                casReader
 	containerRegistryOptions []remote.Option
+	layoutDir                string
 }
 
 func Builder(dm api.DeployManifest) *vfsBuilder {
@@ -244,6 +258,14 @@ func (b *vfsBuilder) WithContainerRegistryOption(o remote.Option) *vfsBuilder {
 	return b
 }
 
+// WithLayout reads local blobs from the blobs directory of an OCI layout
+// (like blobs/sha256/<hex>) instead of the runfiles tree.
+// This allows pushing layouts that were not produced by rules_img.
+func (b *vfsBuilder) WithLayout(dir string) *vfsBuilder {
+	b.layoutDir = dir
+	return b
+}
+
 func (b *vfsBuilder) Build() (*VFS, error) {
 	blobs, manifests, err := b.ingest()
 	if err != nil {
@@ -279,11 +301,11 @@ func (b *vfsBuilder) ingest() (map[string]blobEntry, map[string]blobEntry, error
 		}
 		if op.RootKind == "index" {
 			// There must be a "index.json" file in the runfiles
-			manifests[op.Root.Digest] = localIndex(i, op.Root)
+			manifests[op.Root.Digest] = b.localBlob(path.Join(fmt.Sprintf("%d", i), "index.json"), op.Root)
 		}
 		for manifestIndex, manifest := range op.Manifests {
-			manifests[manifest.Descriptor.Digest] = localManifest(i, manifestIndex, manifest.Descriptor)
-			blobs[manifest.Config.Digest] = localConfig(i, manifestIndex, manifest.Config)
+			manifests[manifest.Descriptor.Digest] = b.localBlob(path.Join(fmt.Sprintf("%d", i), "manifests", fmt.Sprintf("%d", manifestIndex), "manifest.json"), manifest.Descriptor)
+			blobs[manifest.Config.Digest] = b.localBlob(path.Join(fmt.Sprintf("%d", i), "manifests", fmt.Sprintf("%d", manifestIndex), "config.json"), manifest.Config)
 			for layerIndex, layer := range manifest.LayerBlobs {
 				blob, err := b.layerBlob(i, manifestIndex, layerIndex, strategy, op.PullInfo, manifest, layer)
 				if err != nil {
@@ -328,14 +350,19 @@ func (b *vfsBuilder) layerBlob(operationIndex int, manifestIndex int, layerIndex
 	if entry, found := b.layerFromRegistry(pullInfo, manifestInfo.MissingBlobs, desc); found {
 		return entry, nil
 	}
+	if !registrytypes.MediaType(desc.MediaType).IsDistributable() {
+		// foreign layers (like Windows base layers) are never pushed or loaded.
+		// They are downloaded from their URLs by the container runtime instead.
+		return stubBlob(desc), nil
+	}
 	switch strategy {
 	case "eager":
-		return blobEntry{}, fmt.Errorf("layer not found in runfiles (%s) or base image registry, cannot proceed with eager strategy", layerRunfilesPath(operationIndex, manifestIndex, layerIndex))
+		return blobEntry{}, fmt.Errorf("layer not found in %s or base image registry, cannot proceed with eager strategy", b.describeLocalPath(layerRunfilesPath(operationIndex, manifestIndex, layerIndex), desc))
 	case "lazy":
 		if entry, found := b.layerFromCAS(desc); found {
 			return entry, nil
 		}
-		return blobEntry{}, fmt.Errorf("layer not found in runfiles (%s) or base image registry, and not found in remote cache, cannot proceed with lazy strategy", layerRunfilesPath(operationIndex, manifestIndex, layerIndex))
+		return blobEntry{}, fmt.Errorf("layer not found in %s or base image registry, and not found in remote cache, cannot proceed with lazy strategy", b.describeLocalPath(layerRunfilesPath(operationIndex, manifestIndex, layerIndex), desc))
 	case "cas_registry", "bes":
 		// create a stub blob that cannot be read.
 		// The push code should never try to read it, since the remote CAS is assumed to already have it.
@@ -345,9 +372,9 @@ func (b *vfsBuilder) layerBlob(operationIndex int, manifestIndex int, layerIndex
 	return blobEntry{}, fmt.Errorf("unknown push/load strategy: %s", strategy)
 }
 
-// layerFromFile tries to find the layer in the runfiles tree. If it exists, it returns the blobEntry and true.
+// layerFromFile tries to find the layer in the runfiles tree (or OCI layout). If it exists, it returns the blobEntry and true.
 func (b *vfsBuilder) layerFromFile(operationIndex int, manifestIndex int, layerIndex int, desc api.Descriptor) (blobEntry, bool) {
-	fpath, err := runfiles.Rlocation(layerRunfilesPath(operationIndex, manifestIndex, layerIndex))
+	fpath, err := b.localPath(layerRunfilesPath(operationIndex, manifestIndex, layerIndex), desc)
 	if err != nil {
 		return blobEntry{}, false
 	}
@@ -442,12 +469,13 @@ type blobEntry struct {
 	Opener   func() (io.ReadCloser, error)
 }
 
-func localIndex(operationIndex int, desc api.Descriptor) blobEntry {
+// localBlob returns a blob that is read from the runfiles tree (or OCI layout).
+func (b *vfsBuilder) localBlob(runfilesPath string, desc api.Descriptor) blobEntry {
 	return blobEntry{
 		Descriptor: desc,
 		Location:   "file",
 		Opener: func() (io.ReadCloser, error) {
-			fpath, err := runfiles.Rlocation(path.Join(fmt.Sprintf("%d", operationIndex), "index.json"))
+			fpath, err := b.localPath(runfilesPath, desc)
 			if err != nil {
 				return nil, err
 			}
@@ -456,32 +484,24 @@ func localIndex(operationIndex int, desc api.Descriptor) blobEntry {
 	}
 }
 
-func localManifest(operationIndex int, manifestIndex int, desc api.Descriptor) blobEntry {
-	return blobEntry{
-		Descriptor: desc,
-		Location:   "file",
-		Opener: func() (io.ReadCloser, error) {
-			fpath, err := runfiles.Rlocation(path.Join(fmt.Sprintf("%d", operationIndex), "manifests", fmt.Sprintf("%d", manifestIndex), "manifest.json"))
-			if err != nil {
-				return nil, err
-			}
-			return os.Open(fpath)
-		},
+// localPath returns the path of a blob in the OCI layout (if any) or in the runfiles tree.
+func (b *vfsBuilder) localPath(runfilesPath string, desc api.Descriptor) (string, error) {
+	if b.layoutDir == "" {
+		return runfiles.Rlocation(runfilesPath)
+	}
+	digest, err := registryv1.NewHash(desc.Digest)
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(b.layoutDir, "blobs", digest.Algorithm, digest.Hex), nil
 }
 
-func localConfig(operationIndex int, manifestIndex int, desc api.Descriptor) blobEntry {
-	return blobEntry{
-		Descriptor: desc,
-		Location:   "file",
-		Opener: func() (io.ReadCloser, error) {
-			fpath, err := runfiles.Rlocation(path.Join(fmt.Sprintf("%d", operationIndex), "manifests", fmt.Sprintf("%d", manifestIndex), "config.json"))
-			if err != nil {
-				return nil, err
-			}
-			return os.Open(fpath)
-		},
+func (b *vfsBuilder) describeLocalPath(runfilesPath string, desc api.Descriptor) string {
+	if b.layoutDir == "" {
+		return fmt.Sprintf("runfiles (%s)", runfilesPath)
 	}
+	fpath, _ := b.localPath(runfilesPath, desc)
+	return fmt.Sprintf("OCI layout (%s)", fpath)
 }
 
 func (b blobEntry) Digest() (registryv1.Hash, error) {
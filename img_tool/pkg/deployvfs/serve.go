@@ -0,0 +1,244 @@
+package deployvfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+)
+
+// ServedImage is an image (or index) that the read-only registry of a VFS serves under a tag.
+type ServedImage struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ServedImages returns the images of the deploy manifest under the names used by the registry handler.
+// Pushed images use the target repository and tags, loaded images use their tags.
+// Images without tags are served under the target repository by digest only (Tag is empty).
+func (vfs *VFS) ServedImages() ([]ServedImage, error) {
+	var images []ServedImage
+	pushOps, err := vfs.dm.PushOperations()
+	if err != nil {
+		return nil, fmt.Errorf("getting push operations: %w", err)
+	}
+	for _, op := range pushOps {
+		if len(op.Tags) == 0 {
+			images = append(images, ServedImage{Repository: op.Repository, Digest: op.Root.Digest})
+		}
+		for _, tag := range op.Tags {
+			images = append(images, ServedImage{Repository: op.Repository, Tag: tag, Digest: op.Root.Digest})
+		}
+	}
+	loadOps, err := vfs.dm.LoadOperations()
+	if err != nil {
+		return nil, fmt.Errorf("getting load operations: %w", err)
+	}
+	for _, op := range loadOps {
+		for _, tag := range op.Tags {
+			repository, tag := splitLoadTag(tag)
+			images = append(images, ServedImage{Repository: repository, Tag: tag, Digest: op.Root.Digest})
+		}
+	}
+	return images, nil
+}
+
+// splitLoadTag splits a tag of a load operation (like "my/image:latest") into repository and tag.
+func splitLoadTag(ref string) (string, string) {
+	repository, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repository, tag = ref[:i], ref[i+1:]
+	}
+	// The registry part of the reference is not part of the repository name.
+	if first, rest, ok := strings.Cut(repository, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		repository = rest
+	}
+	return repository, tag
+}
+
+// Handler returns a read-only OCI distribution API serving the manifests and blobs of the VFS.
+// It allows tools that analyze images from a registry to inspect the exact image
+// that would be pushed, without pushing it.
+// Any manifest or blob of the VFS can be fetched by digest from every served repository.
+// Blobs that only exist in the remote CAS (stubs) cannot be served.
+func (vfs *VFS) Handler() (http.Handler, error) {
+	images, err := vfs.ServedImages()
+	if err != nil {
+		return nil, err
+	}
+	h := &vfsHandler{vfs: vfs, tags: make(map[string]map[string]string)}
+	for _, image := range images {
+		tags, ok := h.tags[image.Repository]
+		if !ok {
+			tags = make(map[string]string)
+			h.tags[image.Repository] = tags
+		}
+		if image.Tag != "" {
+			tags[image.Tag] = image.Digest
+		}
+	}
+	return h, nil
+}
+
+type vfsHandler struct {
+	vfs *VFS
+	// tags maps repository names to their tags and the digests they point to.
+	tags map[string]map[string]string
+}
+
+func (h *vfsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the registry is read-only")
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+	if !ok {
+		writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+		return
+	}
+	if rest == "" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+	if rest == "_catalog" {
+		h.serveCatalog(w)
+		return
+	}
+	for _, kind := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		i := strings.LastIndex(rest, kind)
+		if i < 0 {
+			continue
+		}
+		repository, reference := rest[:i], rest[i+len(kind):]
+		tags, ok := h.tags[repository]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository %s is not part of the deploy manifest", repository))
+			return
+		}
+		switch kind {
+		case "/manifests/":
+			h.serveManifest(w, r, tags, reference)
+		case "/blobs/":
+			h.serveBlob(w, r, reference)
+		case "/tags/":
+			if reference != "list" {
+				writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+				return
+			}
+			h.serveTags(w, repository, tags)
+		}
+		return
+	}
+	writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown path "+r.URL.Path)
+}
+
+func (h *vfsHandler) serveCatalog(w http.ResponseWriter) {
+	repositories := make([]string, 0, len(h.tags))
+	for repository := range h.tags {
+		repositories = append(repositories, repository)
+	}
+	slices.Sort(repositories)
+	writeJSON(w, map[string][]string{"repositories": repositories})
+}
+
+func (h *vfsHandler) serveTags(w http.ResponseWriter, repository string, tags map[string]string) {
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	slices.Sort(names)
+	writeJSON(w, map[string]any{"name": repository, "tags": names})
+}
+
+func (h *vfsHandler) serveManifest(w http.ResponseWriter, r *http.Request, tags map[string]string, reference string) {
+	digestStr := reference
+	if !strings.Contains(reference, ":") {
+		var ok bool
+		if digestStr, ok = tags[reference]; !ok {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("tag %s not found", reference))
+			return
+		}
+	}
+	digest, err := registryv1.NewHash(digestStr)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+	manifest, err := h.vfs.ManifestBlob(digest)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", err.Error())
+		return
+	}
+	mediaType, err := manifest.MediaType()
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", string(mediaType))
+	h.serveContent(w, r, digest, manifest)
+}
+
+func (h *vfsHandler) serveBlob(w http.ResponseWriter, r *http.Request, reference string) {
+	digest, err := registryv1.NewHash(reference)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+	blob, err := h.vfs.Layer(digest)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", err.Error())
+		return
+	}
+	if location, _ := h.vfs.Location(digest); location == "stub" {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", fmt.Sprintf("blob %s is only available in the remote CAS", digest))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	h.serveContent(w, r, digest, blob)
+}
+
+// serveContent writes the headers of a manifest or blob and, for GET requests, its data.
+func (h *vfsHandler) serveContent(w http.ResponseWriter, r *http.Request, digest registryv1.Hash, blob registryv1.Layer) {
+	size, err := blob.Size()
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", digest.String())
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	rc, err := blob.Compressed()
+	if err != nil {
+		w.Header().Del("Content-Length")
+		writeRegistryError(w, http.StatusBadGateway, "UNKNOWN", fmt.Sprintf("opening %s: %v", digest, err))
+		return
+	}
+	defer rc.Close()
+	w.WriteHeader(http.StatusOK)
+	// The status is already sent, so errors can only abort the response.
+	io.Copy(w, rc)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
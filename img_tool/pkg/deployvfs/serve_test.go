@@ -0,0 +1,263 @@
+package deployvfs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// writeLayout writes the blobs of a random image to an OCI layout directory
+// and returns the image and its base operation.
+func writeLayout(t *testing.T, command string) (string, registryv1.Image, api.BaseCommandOperation) {
+	t.Helper()
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layoutDir := t.TempDir()
+	writeBlob := func(mediaType string, digest registryv1.Hash, data []byte) api.Descriptor {
+		dir := filepath.Join(layoutDir, "blobs", digest.Algorithm)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, digest.Hex), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return api.Descriptor{MediaType: mediaType, Digest: digest.String(), Size: int64(len(data))}
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawManifest, _ := img.RawManifest()
+	manifestDigest, _ := img.Digest()
+	rawConfig, _ := img.RawConfigFile()
+	info := api.ManifestDeployInfo{
+		Descriptor: writeBlob(string(manifest.MediaType), manifestDigest, rawManifest),
+		Config:     writeBlob(string(manifest.Config.MediaType), manifest.Config.Digest, rawConfig),
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.LayerBlobs = append(info.LayerBlobs, writeBlob(string(manifest.Layers[i].MediaType), manifest.Layers[i].Digest, data))
+	}
+	op := api.BaseCommandOperation{
+		Command:   command,
+		RootKind:  "manifest",
+		Root:      info.Descriptor,
+		Manifests: []api.ManifestDeployInfo{info},
+	}
+	return layoutDir, img, op
+}
+
+func serveVFS(t *testing.T, layoutDir string, strategy string, ops ...any) *httptest.Server {
+	t.Helper()
+	dm := api.DeployManifest{Settings: api.DeploySettings{PushStrategy: strategy, LoadStrategy: strategy}}
+	for _, op := range ops {
+		raw, err := json.Marshal(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm.Operations = append(dm.Operations, raw)
+	}
+	vfs, err := Builder(dm).WithLayout(layoutDir).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := vfs.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func request(t *testing.T, method, url string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	layoutDir, img, op := writeLayout(t, "push")
+	server := serveVFS(t, layoutDir, "eager",
+		api.PushDeployOperation{BaseCommandOperation: op, PushTarget: api.PushTarget{Registry: "registry.example.com", Repository: "my-org/app", Tags: []string{"v1", "latest"}}},
+		api.LoadDeployOperation{BaseCommandOperation: api.BaseCommandOperation{Command: "load", RootKind: op.RootKind, Root: op.Root, Manifests: op.Manifests}, Tags: []string{"localhost:5000/dev/app:debug"}},
+	)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	// a registry client can pull the image by tag
+	for _, reference := range []string{"/my-org/app:v1", "/dev/app:debug", "/my-org/app@" + op.Root.Digest} {
+		ref, err := name.ParseReference(host + reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pulled, err := remote.Image(ref)
+		if err != nil {
+			t.Fatalf("pulling %s: %v", ref, err)
+		}
+		want, _ := img.Digest()
+		if got, err := pulled.Digest(); err != nil || got != want {
+			t.Errorf("pulled %s = %s (%v), want %s", ref, got, err, want)
+		}
+		layers, err := pulled.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, layer := range layers {
+			rc, err := layer.Compressed()
+			if err != nil {
+				t.Fatalf("fetching layer of %s: %v", ref, err)
+			}
+			// reading verifies the digest
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				t.Errorf("reading layer of %s: %v", ref, err)
+			}
+			rc.Close()
+		}
+	}
+
+	_, body := request(t, http.MethodGet, server.URL+"/v2/_catalog")
+	if want := `{"repositories":["dev/app","my-org/app"]}`; strings.TrimSpace(body) != want {
+		t.Errorf("catalog = %s, want %s", body, want)
+	}
+	_, body = request(t, http.MethodGet, server.URL+"/v2/my-org/app/tags/list")
+	if want := `{"name":"my-org/app","tags":["latest","v1"]}`; strings.TrimSpace(body) != want {
+		t.Errorf("tags = %s, want %s", body, want)
+	}
+
+	resp, body := request(t, http.MethodHead, server.URL+"/v2/my-org/app/manifests/latest")
+	if resp.StatusCode != http.StatusOK || body != "" {
+		t.Errorf("HEAD manifest = %s with body %q, want 200 without body", resp.Status, body)
+	}
+	if got := resp.Header.Get("Docker-Content-Digest"); got != op.Root.Digest {
+		t.Errorf("Docker-Content-Digest = %s, want %s", got, op.Root.Digest)
+	}
+	if got := resp.Header.Get("Content-Type"); got != op.Root.MediaType {
+		t.Errorf("Content-Type = %s, want %s", got, op.Root.MediaType)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	layoutDir, _, op := writeLayout(t, "push")
+	server := serveVFS(t, layoutDir, "eager",
+		api.PushDeployOperation{BaseCommandOperation: op, PushTarget: api.PushTarget{Registry: "registry.example.com", Repository: "app", Tags: []string{"v1"}}},
+	)
+	unknownDigest := "sha256:" + strings.Repeat("0", 64)
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{http.MethodPut, "/v2/app/manifests/v1", http.StatusMethodNotAllowed, "UNSUPPORTED"},
+		{http.MethodPost, "/v2/app/blobs/uploads/", http.StatusMethodNotAllowed, "UNSUPPORTED"},
+		{http.MethodGet, "/v2/other/manifests/v1", http.StatusNotFound, "NAME_UNKNOWN"},
+		{http.MethodGet, "/v2/app/manifests/v2", http.StatusNotFound, "MANIFEST_UNKNOWN"},
+		{http.MethodGet, "/v2/app/manifests/" + unknownDigest, http.StatusNotFound, "MANIFEST_UNKNOWN"},
+		{http.MethodGet, "/v2/app/manifests/sha256:short", http.StatusBadRequest, "DIGEST_INVALID"},
+		{http.MethodGet, "/v2/app/blobs/" + unknownDigest, http.StatusNotFound, "BLOB_UNKNOWN"},
+		{http.MethodGet, "/v2/app/tags/other", http.StatusNotFound, "NOT_FOUND"},
+		{http.MethodGet, "/v2/app/referrers/" + op.Root.Digest, http.StatusNotFound, "NOT_FOUND"},
+		{http.MethodGet, "/other", http.StatusNotFound, "NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			resp, body := request(t, tt.method, server.URL+tt.path)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			var registryErr struct {
+				Errors []struct{ Code string } `json:"errors"`
+			}
+			if err := json.Unmarshal([]byte(body), &registryErr); err != nil || len(registryErr.Errors) != 1 || registryErr.Errors[0].Code != tt.wantCode {
+				t.Errorf("body = %s, want error code %s", body, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandlerStubBlob(t *testing.T) {
+	layoutDir, _, op := writeLayout(t, "push")
+	layer := op.Manifests[0].LayerBlobs[0]
+	hex := strings.TrimPrefix(layer.Digest, "sha256:")
+	if err := os.Remove(filepath.Join(layoutDir, "blobs", "sha256", hex)); err != nil {
+		t.Fatal(err)
+	}
+	server := serveVFS(t, layoutDir, "cas_registry",
+		api.PushDeployOperation{BaseCommandOperation: op, PushTarget: api.PushTarget{Registry: "registry.example.com", Repository: "app"}},
+	)
+
+	resp, body := request(t, http.MethodGet, server.URL+"/v2/app/blobs/"+layer.Digest)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "is only available in the remote CAS") {
+		t.Errorf("GET stub blob = %s: %s, want 404 because it is only in the remote CAS", resp.Status, body)
+	}
+	// images without tags are served by digest
+	resp, _ = request(t, http.MethodGet, server.URL+"/v2/app/manifests/"+op.Root.Digest)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET manifest by digest = %s, want 200", resp.Status)
+	}
+	_, body = request(t, http.MethodGet, server.URL+"/v2/app/tags/list")
+	if want := `{"name":"app","tags":[]}`; strings.TrimSpace(body) != want {
+		t.Errorf("tags = %s, want %s", body, want)
+	}
+}
+
+func TestSplitLoadTag(t *testing.T) {
+	tests := []struct {
+		ref            string
+		wantRepository string
+		wantTag        string
+	}{
+		{"app", "app", "latest"},
+		{"my/app:v1", "my/app", "v1"},
+		{"localhost/my/app", "my/app", "latest"},
+		{"localhost:5000/app:v1", "app", "v1"},
+		{"registry.example.com/my/app:v1", "my/app", "v1"},
+	}
+	for _, tt := range tests {
+		repository, tag := splitLoadTag(tt.ref)
+		if got, want := []string{repository, tag}, []string{tt.wantRepository, tt.wantTag}; !reflect.DeepEqual(got, want) {
+			t.Errorf("splitLoadTag(%q) = %q, want %q", tt.ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package diagnostics describes the img binary and the settings of a build action when the action fails.
+// The compact block is printed to stderr, so that bug reports about failing image builds contain
+// everything needed for triage (like the ResolvedToolchains output of Bazel).
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// EnvVar is the environment variable that holds a JSON object with the settings of the action
+// (like the target, the toolchain and build settings). It is set by the Bazel rules.
+const EnvVar = "IMG_DIAGNOSTICS"
+
+var (
+	mu       sync.Mutex
+	settings = make(map[string]string)
+)
+
+// Set records a setting of the running command (like the selected compression).
+func Set(key, value string) {
+	mu.Lock()
+	defer mu.Unlock()
+	settings[key] = value
+}
+
+// Exit writes the diagnostics to stderr if code is not zero and exits the process with code.
+func Exit(code int) {
+	if code != 0 {
+		Write(os.Stderr)
+	}
+	os.Exit(code)
+}
+
+// Write writes the diagnostics block to w.
+func Write(w io.Writer) {
+	values := make(map[string]string)
+	if raw := os.Getenv(EnvVar); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			values["error"] = fmt.Sprintf("invalid %s: %v", EnvVar, err)
+		}
+	}
+	mu.Lock()
+	maps.Copy(values, settings)
+	mu.Unlock()
+
+	fmt.Fprintln(w, "--- img diagnostics (please include in bug reports) ---")
+	fmt.Fprintf(w, "  img: %s\n", version())
+	if len(os.Args) > 1 {
+		fmt.Fprintf(w, "  command: %s\n", os.Args[1])
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(w, "  %s: %s\n", key, values[key])
+	}
+	fmt.Fprintln(w, "---")
+}
+
+// version describes the build of the img binary.
+func version() string {
+	v := "unknown"
+	goVersion := runtime.Version()
+	revision := ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			v = info.Main.Version
+		}
+		if info.GoVersion != "" {
+			goVersion = info.GoVersion
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				if setting.Value == "true" {
+					revision += "-dirty"
+				}
+			}
+		}
+	}
+	if revision != "" {
+		v += " (" + revision + ")"
+	}
+	return fmt.Sprintf("%s %s %s/%s", v, goVersion, runtime.GOOS, runtime.GOARCH)
+}
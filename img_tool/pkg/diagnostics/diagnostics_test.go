@@ -0,0 +1,95 @@
+package diagnostics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		settings map[string]string
+		want     []string
+		wantNot  []string
+	}{
+		{
+			name: "settings only",
+			settings: map[string]string{
+				"compression": "gzip",
+			},
+			want: []string{"  compression: gzip\n"},
+		},
+		{
+			name: "action settings from the environment",
+			env:  `{"target":"//app:layer","toolchain":"@img_toolchain//:linux_amd64"}`,
+			want: []string{
+				"  target: //app:layer\n",
+				"  toolchain: @img_toolchain//:linux_amd64\n",
+			},
+		},
+		{
+			name: "settings of the command replace the environment",
+			env:  `{"compression":"zstd","target":"//app:layer"}`,
+			settings: map[string]string{
+				"compression": "gzip",
+			},
+			want:    []string{"  compression: gzip\n", "  target: //app:layer\n"},
+			wantNot: []string{"zstd"},
+		},
+		{
+			name: "invalid environment",
+			env:  `not json`,
+			want: []string{"  error: invalid IMG_DIAGNOSTICS: "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvVar, tt.env)
+			mu.Lock()
+			settings = make(map[string]string)
+			mu.Unlock()
+			for key, value := range tt.settings {
+				Set(key, value)
+			}
+
+			var buf bytes.Buffer
+			Write(&buf)
+			got := buf.String()
+
+			if !strings.HasPrefix(got, "--- img diagnostics (please include in bug reports) ---\n  img: ") {
+				t.Errorf("Write() = %q, want the header and the img version first", got)
+			}
+			if !strings.HasSuffix(got, "\n---\n") {
+				t.Errorf("Write() = %q, want a closing line", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Write() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, wantNot := range tt.wantNot {
+				if strings.Contains(got, wantNot) {
+					t.Errorf("Write() = %q, want it not to contain %q", got, wantNot)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSortsKeys(t *testing.T) {
+	t.Setenv(EnvVar, `{"b":"2","a":"1"}`)
+	mu.Lock()
+	settings = map[string]string{"c": "3"}
+	mu.Unlock()
+
+	var buf bytes.Buffer
+	Write(&buf)
+	got := buf.String()
+
+	a, b, c := strings.Index(got, "  a: 1\n"), strings.Index(got, "  b: 2\n"), strings.Index(got, "  c: 3\n")
+	if a < 0 || b < 0 || c < 0 || !(a < b && b < c) {
+		t.Errorf("Write() = %q, want the settings sorted by key", got)
+	}
+}
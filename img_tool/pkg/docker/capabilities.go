@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Capabilities summarizes the features of a Docker daemon that matter for loading images.
+type Capabilities struct {
+	Version    string
+	APIVersion string
+	Driver     string
+	// ContainerdSnapshotter is true if the daemon uses the containerd image store.
+	// Only then are images loaded into containerd (namespace "moby") visible to Docker.
+	ContainerdSnapshotter bool
+}
+
+type versionOutput struct {
+	Server *struct {
+		Version    string `json:"Version"`
+		APIVersion string `json:"ApiVersion"`
+	} `json:"Server"`
+}
+
+type infoOutput struct {
+	Driver       string     `json:"Driver"`
+	DriverStatus [][]string `json:"DriverStatus"`
+}
+
+// ProbeCapabilities queries the Docker daemon via the docker CLI.
+// A failure means that images cannot be loaded via "docker load".
+func ProbeCapabilities(ctx context.Context) (Capabilities, error) {
+	var version versionOutput
+	if err := dockerJSON(ctx, &version, "version", "-f", "json"); err != nil {
+		return Capabilities{}, err
+	}
+	if version.Server == nil {
+		return Capabilities{}, fmt.Errorf("docker daemon is not reachable")
+	}
+	var info infoOutput
+	if err := dockerJSON(ctx, &info, "system", "info", "-f", "json"); err != nil {
+		return Capabilities{}, err
+	}
+
+	caps := Capabilities{
+		Version:    version.Server.Version,
+		APIVersion: version.Server.APIVersion,
+		Driver:     info.Driver,
+	}
+	for _, status := range info.DriverStatus {
+		if len(status) >= 2 && status[0] == "driver-type" && status[1] == "io.containerd.snapshotter.v1" {
+			caps.ContainerdSnapshotter = true
+		}
+	}
+	return caps, nil
+}
+
+// String returns a one-line summary of the capabilities.
+func (c Capabilities) String() string {
+	store := "classic image store"
+	if c.ContainerdSnapshotter {
+		store = "containerd image store"
+	}
+	return fmt.Sprintf("docker %s (API %s), storage driver %s (%s)", c.Version, c.APIVersion, c.Driver, store)
+}
+
+func dockerJSON(ctx context.Context, v any, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return fmt.Errorf("docker %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("docker %s: %w", strings.Join(args, " "), err)
+	}
+	if err := json.Unmarshal(output, v); err != nil {
+		return fmt.Errorf("parsing output of docker %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDocker puts a docker CLI on PATH that prints version and info
+// or fails with the given message.
+func fakeDocker(t *testing.T, version, info, failure string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n"
+	if failure != "" {
+		script += "echo '" + failure + "' >&2\nexit 1\n"
+	} else {
+		script += "case \"$1\" in\nversion) echo '" + version + "' ;;\nsystem) echo '" + info + "' ;;\nesac\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		info string
+		want string
+	}{
+		{
+			name: "containerd image store",
+			info: `{"Driver":"overlayfs","DriverStatus":[["driver-type","io.containerd.snapshotter.v1"]]}`,
+			want: "docker 27.1.1 (API 1.46), storage driver overlayfs (containerd image store)",
+		},
+		{
+			name: "classic image store",
+			info: `{"Driver":"overlay2","DriverStatus":[["Backing Filesystem","extfs"]]}`,
+			want: "docker 27.1.1 (API 1.46), storage driver overlay2 (classic image store)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeDocker(t, `{"Client":{},"Server":{"Version":"27.1.1","ApiVersion":"1.46"}}`, tc.info, "")
+			caps, err := ProbeCapabilities(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caps.String() != tc.want {
+				t.Errorf("capabilities = %q, want %q", caps, tc.want)
+			}
+		})
+	}
+}
+
+func TestProbeCapabilitiesErrors(t *testing.T) {
+	fakeDocker(t, `{"Client":{},"Server":null}`, `{}`, "")
+	if _, err := ProbeCapabilities(context.Background()); err == nil || err.Error() != "docker daemon is not reachable" {
+		t.Errorf("ProbeCapabilities() without server: error = %v", err)
+	}
+
+	fakeDocker(t, "", "", "Cannot connect to the Docker daemon")
+	if _, err := ProbeCapabilities(context.Background()); err == nil || !strings.Contains(err.Error(), "docker version -f json: Cannot connect to the Docker daemon") {
+		t.Errorf("ProbeCapabilities() with failing CLI: error = %v", err)
+	}
+
+	fakeDocker(t, "not json", "", "")
+	if _, err := ProbeCapabilities(context.Background()); err == nil || !strings.Contains(err.Error(), "parsing output of docker version") {
+		t.Errorf("ProbeCapabilities() with invalid output: error = %v", err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := ProbeCapabilities(context.Background()); err == nil || !strings.Contains(err.Error(), "executable file not found") {
+		t.Errorf("ProbeCapabilities() without docker: error = %v", err)
+	}
+}
@@ -12,10 +12,29 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
-// TarWriter streams a docker-compatible tar file
+// copyBufferSize is the size of the buffer used to stream layer contents.
+// Layers are never held in memory as a whole.
+const copyBufferSize = 1 << 20
+
+// TarWriter streams a docker-compatible tar file.
+// Layers are copied directly from their readers into the archive,
+// so memory usage is bounded independent of the image size.
 type TarWriter struct {
 	tw           *tar.Writer
 	manifestData []ManifestEntry
+	copyBuffer   []byte
+
+	// state of the image that is currently written
+	diffIDs       []digest.Digest
+	layerIndex    int
+	chainID       digest.Digest
+	legacyLayerID string
+}
+
+// legacyLayerConfig is the content of the per-layer "json" file of the legacy docker save format.
+type legacyLayerConfig struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent,omitempty"`
 }
 
 // ManifestEntry represents an entry in the manifest.json
@@ -30,7 +49,8 @@ type ManifestEntry struct {
 // NewTarWriter creates a new streaming tar writer
 func NewTarWriter(w io.Writer) *TarWriter {
 	return &TarWriter{
-		tw: tar.NewWriter(w),
+		tw:         tar.NewWriter(w),
+		copyBuffer: make([]byte, copyBufferSize),
 	}
 }
 
@@ -44,6 +64,12 @@ func (t *TarWriter) WriteConfig(configData []byte) error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
+	// Reset the layer chain for the new image
+	t.diffIDs = imageConfig.RootFS.DiffIDs
+	t.layerIndex = 0
+	t.chainID = ""
+	t.legacyLayerID = ""
+
 	// Store manifest entry
 	t.manifestData = append(t.manifestData, ManifestEntry{
 		Config:       configName,
@@ -56,9 +82,15 @@ func (t *TarWriter) WriteConfig(configData []byte) error {
 	return t.writeFile(configName, configData)
 }
 
-// WriteLayer streams a layer to the tar
+// WriteLayer streams a layer to the tar.
+// Layers must be written in order, after the config of the image.
 func (t *TarWriter) WriteLayer(layerDigest registryv1.Hash, size int64, reader io.Reader) error {
-	layerDir := layerDigest.Hex
+	parentID := t.legacyLayerID
+	layerID, err := t.nextLegacyLayerID()
+	if err != nil {
+		return fmt.Errorf("computing legacy layer ID of %s: %w", layerDigest, err)
+	}
+	layerDir := layerID
 	layerPath := path.Join(layerDir, "layer.tar")
 
 	// Update manifest entry
@@ -83,6 +115,15 @@ func (t *TarWriter) WriteLayer(layerDigest registryv1.Hash, size int64, reader i
 		return fmt.Errorf("writing VERSION: %w", err)
 	}
 
+	// Write legacy layer config
+	layerJSON, err := json.Marshal(legacyLayerConfig{ID: layerID, Parent: parentID})
+	if err != nil {
+		return fmt.Errorf("marshaling layer json: %w", err)
+	}
+	if err := t.writeFile(path.Join(layerDir, "json"), layerJSON); err != nil {
+		return fmt.Errorf("writing layer json: %w", err)
+	}
+
 	// Write layer content
 	hdr := &tar.Header{
 		Name: layerPath,
@@ -94,7 +135,7 @@ func (t *TarWriter) WriteLayer(layerDigest registryv1.Hash, size int64, reader i
 	}
 
 	// Stream the layer content
-	n, err := io.Copy(t.tw, reader)
+	n, err := io.CopyBuffer(t.tw, io.LimitReader(reader, size+1), t.copyBuffer)
 	if err != nil {
 		return fmt.Errorf("streaming layer content: %w", err)
 	}
@@ -105,6 +146,33 @@ func (t *TarWriter) WriteLayer(layerDigest registryv1.Hash, size int64, reader i
 	return nil
 }
 
+// nextLegacyLayerID computes the legacy (v1) ID of the next layer of the current image.
+// Like "docker save", the ID is derived from the chain ID of the layer and the ID of its parent,
+// so that identical layers at different positions of the image get different directories.
+func (t *TarWriter) nextLegacyLayerID() (string, error) {
+	if t.layerIndex >= len(t.diffIDs) {
+		return "", fmt.Errorf("image config has %d diff IDs, but layer %d was written", len(t.diffIDs), t.layerIndex+1)
+	}
+	diffID := t.diffIDs[t.layerIndex]
+	if t.chainID == "" {
+		t.chainID = diffID
+	} else {
+		t.chainID = digest.FromString(t.chainID.String() + " " + diffID.String())
+	}
+
+	v1Config := map[string]string{"layer_id": t.chainID.String()}
+	if t.legacyLayerID != "" {
+		v1Config["parent"] = "sha256:" + t.legacyLayerID
+	}
+	rawV1Config, err := json.Marshal(v1Config)
+	if err != nil {
+		return "", err
+	}
+	t.legacyLayerID = digest.FromBytes(rawV1Config).Encoded()
+	t.layerIndex++
+	return t.legacyLayerID, nil
+}
+
 // SetTags sets the repository tags for the image
 func (t *TarWriter) SetTags(tags []string) {
 	if len(t.manifestData) > 0 {
@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTarWriter(t *testing.T) {
+	layer := []byte("identical layer content")
+	layerDigest := registryv1.Hash{Algorithm: "sha256", Hex: digest.FromBytes(layer).Encoded()}
+	diffID := digest.FromBytes(layer)
+	config := []byte(`{"architecture":"arm64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + diffID.String() + `","` + diffID.String() + `"]}}`)
+
+	var buf bytes.Buffer
+	tw := NewTarWriter(&buf)
+	if err := tw.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := tw.WriteLayer(layerDigest, int64(len(layer)), bytes.NewReader(layer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.SetTags([]string{"docker.io/library/app:latest", "docker.io/library/app:v1"})
+	if err := tw.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readTar(t, &buf)
+	var manifest []ManifestEntry
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 {
+		t.Fatalf("manifest.json has %d entries, want 1", len(manifest))
+	}
+	entry := manifest[0]
+	if entry.Config != digest.FromBytes(config).Encoded()+".json" || entry.Architecture != "arm64" || entry.Os != "linux" {
+		t.Errorf("manifest.json entry = %+v", entry)
+	}
+	if strings.Join(entry.RepoTags, ",") != "docker.io/library/app:latest,docker.io/library/app:v1" {
+		t.Errorf("RepoTags = %q", entry.RepoTags)
+	}
+	if len(entry.Layers) != 2 {
+		t.Fatalf("Layers = %q, want 2 layers", entry.Layers)
+	}
+
+	// identical layers at different positions get different legacy IDs, chained by their parent
+	firstID, secondID := path.Dir(entry.Layers[0]), path.Dir(entry.Layers[1])
+	if firstID == secondID {
+		t.Errorf("both layers have the legacy ID %s", firstID)
+	}
+	if want := digest.FromString(`{"layer_id":"` + diffID.String() + `"}`).Encoded(); firstID != want {
+		t.Errorf("legacy ID of the first layer = %s, want %s", firstID, want)
+	}
+	var first, second legacyLayerConfig
+	if err := json.Unmarshal(files[firstID+"/json"], &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(files[secondID+"/json"], &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != firstID || first.Parent != "" || second.ID != secondID || second.Parent != firstID {
+		t.Errorf("layer json files = %+v and %+v, want the second layer to be a child of the first", first, second)
+	}
+	for _, layerPath := range entry.Layers {
+		if !bytes.Equal(files[layerPath], layer) {
+			t.Errorf("%s = %q, want the layer content", layerPath, files[layerPath])
+		}
+		if string(files[path.Join(path.Dir(layerPath), "VERSION")]) != "1.0" {
+			t.Errorf("%s has no VERSION file", path.Dir(layerPath))
+		}
+	}
+}
+
+func TestTarWriterErrors(t *testing.T) {
+	layer := []byte("layer")
+	layerDigest := registryv1.Hash{Algorithm: "sha256", Hex: digest.FromBytes(layer).Encoded()}
+	config := []byte(`{"rootfs":{"type":"layers","diff_ids":["` + digest.FromBytes(layer).String() + `"]}}`)
+
+	tw := NewTarWriter(io.Discard)
+	if err := tw.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteLayer(layerDigest, int64(len(layer))+1, bytes.NewReader(layer)); err == nil || !strings.Contains(err.Error(), "layer size mismatch") {
+		t.Errorf("WriteLayer() of a short layer: error = %v, want a size mismatch", err)
+	}
+
+	tw = NewTarWriter(io.Discard)
+	if err := tw.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteLayer(layerDigest, int64(len(layer)), bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteLayer(layerDigest, int64(len(layer)), bytes.NewReader(layer)); err == nil || !strings.Contains(err.Error(), "has 1 diff IDs") {
+		t.Errorf("WriteLayer() of more layers than diff IDs: error = %v", err)
+	}
+}
+
+func readTar(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+}
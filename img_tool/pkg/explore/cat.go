@@ -0,0 +1,131 @@
+package explore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+)
+
+// maxSymlinks is the maximum number of symlinks followed while resolving a path (like MAXSYMLINKS on Linux).
+const maxSymlinks = 40
+
+// Resolve returns the node at the absolute path p in the image.
+// Symlinks (including symlinks of parent directories) are followed like inside of a running container.
+func (img *Image) Resolve(p string) (*Node, error) {
+	remaining := strings.Split(cleanPath(p), "/")
+	current := img.Root
+	hops := 0
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if current.Parent != nil {
+				current = current.Parent
+			}
+			continue
+		}
+		child, ok := current.children[component]
+		if !ok {
+			return nil, fmt.Errorf("%s: no such file or directory", p)
+		}
+		if child.Header == nil || child.Header.Typeflag != tar.TypeSymlink {
+			current = child
+			continue
+		}
+		hops++
+		if hops > maxSymlinks {
+			return nil, fmt.Errorf("%s: too many levels of symbolic links", p)
+		}
+		target := child.Header.Linkname
+		if path.IsAbs(target) {
+			current = img.Root
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return current, nil
+}
+
+// Open returns the content of the regular file (or hardlink to a regular file) at node.
+// Only the layer that provides the file is read (and lower layers for hardlinks to files of other layers).
+func (img *Image) Open(node *Node) (io.ReadCloser, error) {
+	if node.IsDir() {
+		return nil, fmt.Errorf("%s: is a directory", node.Path)
+	}
+	switch node.Header.Typeflag {
+	case tar.TypeReg:
+		return img.openEntry(node.Layer, node.Header.Name)
+	case tar.TypeLink:
+		// the target of a hardlink is resolved when its layer is extracted,
+		// so it is provided by the same layer (like deduplicated files in .cas/) or a lower layer.
+		for index := node.Layer; index >= 0; index-- {
+			rc, err := img.openEntry(index, node.Header.Linkname)
+			if err == nil {
+				return rc, nil
+			}
+			if !errors.Is(err, errEntryNotFound) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%s: target %s of hardlink not found", node.Path, node.Header.Linkname)
+	}
+	return nil, fmt.Errorf("%s: is a %s, not a regular file", node.Path, node.Kind())
+}
+
+var errEntryNotFound = errors.New("entry not found")
+
+// openEntry streams the content of the regular file with the given name from a layer.
+func (img *Image) openEntry(index int, name string) (io.ReadCloser, error) {
+	want := cleanPath(name)
+	source := img.sources[index]
+	rc, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := fileopener.StreamCompressionReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	entry := &entryReader{source: rc}
+	if closer, ok := reader.(io.Closer); ok {
+		entry.decompressor = closer
+	}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			entry.Close()
+			return nil, errEntryNotFound
+		}
+		if err != nil {
+			entry.Close()
+			return nil, fmt.Errorf("reading layer %d (%s): %w", index, source.Digest, err)
+		}
+		if header.Typeflag == tar.TypeReg && cleanPath(header.Name) == want {
+			entry.Reader = tarReader
+			return entry, nil
+		}
+	}
+}
+
+// entryReader reads an entry of a layer and closes the layer when it is closed.
+type entryReader struct {
+	io.Reader
+	decompressor io.Closer
+	source       io.Closer
+}
+
+func (r *entryReader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	return r.source.Close()
+}
@@ -0,0 +1,99 @@
+package explore
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func catImage(t *testing.T) *Image {
+	t.Helper()
+	base := tarLayer(t, "sha256:base", true,
+		file("usr/lib/libc.so.6", 12),
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "lib", Linkname: "usr/lib"},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "loop", Linkname: "/loop"},
+		file("etc/config", 5),
+	)
+	app := tarLayer(t, "sha256:app", false,
+		file(".cas/abc", 9),
+		&tar.Header{Typeflag: tar.TypeLink, Name: "app/data", Linkname: ".cas/abc"},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "app/config", Linkname: "etc/config"},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "app/dangling", Linkname: "missing"},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "app/libc", Linkname: "../lib/libc.so.6"},
+		&tar.Header{Typeflag: tar.TypeFifo, Name: "app/fifo"},
+	)
+	img, err := Load(context.Background(), []LayerSource{base, app})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return img
+}
+
+func TestCat(t *testing.T) {
+	img := catImage(t)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/usr/lib/libc.so.6", fileContent("usr/lib/libc.so.6", 12)},
+		// symlinks of parent directories and relative symlinks are followed
+		{"/lib/libc.so.6", fileContent("usr/lib/libc.so.6", 12)},
+		{"/app/libc", fileContent("usr/lib/libc.so.6", 12)},
+		{"app/../etc/./config", fileContent("etc/config", 5)},
+		// hardlinks to files of the same layer and of a lower layer
+		{"/app/data", fileContent(".cas/abc", 9)},
+		{"/app/config", fileContent("etc/config", 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			node, err := img.Resolve(tt.path)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			rc, err := img.Open(node)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatErrors(t *testing.T) {
+	img := catImage(t)
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/missing", "/missing: no such file or directory"},
+		{"/lib/missing", "/lib/missing: no such file or directory"},
+		{"/loop", "/loop: too many levels of symbolic links"},
+		{"/usr/lib", "/usr/lib: is a directory"},
+		{"/app/fifo", "/app/fifo: is a fifo, not a regular file"},
+		{"/app/dangling", "/app/dangling: target missing of hardlink not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			node, err := img.Resolve(tt.path)
+			if err == nil {
+				var rc io.ReadCloser
+				rc, err = img.Open(node)
+				if err == nil {
+					rc.Close()
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("cat %s error = %v, want %q", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package explore
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+)
+
+// Print writes a summary of the layers and the directory tree up to maxDepth levels below root.
+// A negative maxDepth prints the whole tree.
+func Print(w io.Writer, img *Image, root *Node, maxDepth int) {
+	printLayers(w, img)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%10s  %5s  %s\n", "SIZE", "LAYER", "PATH")
+	printNode(w, root, 0, maxDepth)
+}
+
+func printLayers(w io.Writer, img *Image) {
+	fmt.Fprintf(w, "%5s  %-19s  %10s  %8s  %10s  %10s  %10s\n", "LAYER", "DIGEST", "BLOB", "ENTRIES", "FILES", "HARDLINKED", "SHADOWED")
+	for _, layer := range img.Layers {
+		fmt.Fprintf(w, "%5d  %-19s  %10s  %8d  %10s  %10s  %10s\n",
+			layer.Index, shortDigest(layer.Digest), humansize.Format(layer.Size), layer.Entries,
+			humansize.Format(layer.Bytes), humansize.Format(layer.LinkedBytes), humansize.Format(layer.ShadowedBytes))
+	}
+}
+
+func printNode(w io.Writer, node *Node, depth, maxDepth int) {
+	fmt.Fprintf(w, "%10s  %5d  %s%s\n", humansize.Format(node.TotalSize), node.Layer, strings.Repeat("  ", depth), describe(node))
+	if maxDepth >= 0 && depth >= maxDepth {
+		return
+	}
+	for _, child := range node.Children {
+		printNode(w, child, depth+1, maxDepth)
+	}
+}
+
+// describe returns the name of the node with its type-specific details.
+func describe(node *Node) string {
+	if node.Parent == nil {
+		return "/"
+	}
+	name := node.Name
+	var details []string
+	switch node.Kind() {
+	case "symlink":
+		details = append(details, "-> "+node.Header.Linkname)
+	case "hardlink":
+		details = append(details, "=> /"+cleanPath(node.Header.Linkname))
+	default:
+		if node.IsDir() {
+			name += "/"
+		}
+	}
+	if len(node.Links) > 0 {
+		details = append(details, fmt.Sprintf("(%d hardlinks)", len(node.Links)))
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(details, " ")
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
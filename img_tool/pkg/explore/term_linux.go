@@ -0,0 +1,41 @@
+//go:build linux
+
+package explore
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal into raw mode (no echo, unbuffered input) and returns a function that restores it.
+func makeRaw(fd int) (func(), error) {
+	original, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *original
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Iflag &^= unix.IXON | unix.ICRNL
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, original)
+	}, nil
+}
+
+// terminalSize returns the number of columns and rows of the terminal.
+func terminalSize(fd int) (int, int, error) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(size.Col), int(size.Row), nil
+}
+
+// IsTerminal reports whether fd is a terminal that supports the interactive explorer.
+func IsTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package explore
+
+import "errors"
+
+var errNoTerminal = errors.New("the interactive explorer is only supported on Linux, use --print instead")
+
+func makeRaw(int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
+
+// IsTerminal reports whether fd is a terminal that supports the interactive explorer.
+func IsTerminal(int) bool {
+	return false
+}
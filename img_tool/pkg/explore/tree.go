@@ -0,0 +1,298 @@
+// Package explore builds the merged filesystem of an image from the headers of its layers
+// and lets users browse it interactively.
+//
+// Only tar headers are read: file contents are skipped and nothing is unpacked,
+// so even very large images can be explored quickly.
+package explore
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+)
+
+// LayerSource is a layer blob of the image.
+type LayerSource struct {
+	Digest string
+	// Size is the size of the (compressed) blob.
+	Size int64
+	Open func() (io.ReadCloser, error)
+}
+
+// Layer summarizes the contents of a layer.
+type Layer struct {
+	Index  int
+	Digest string
+	Size   int64
+	// Entries is the number of tar entries (including whiteouts).
+	Entries int
+	// Bytes is the sum of the sizes of the regular files stored in the layer.
+	Bytes int64
+	// LinkedBytes is the sum of the sizes of hardlinks to regular files.
+	// These files are stored only once (for example because they were deduplicated).
+	LinkedBytes int64
+	// ShadowedBytes is the sum of the sizes of files that are overwritten or removed by later layers.
+	ShadowedBytes int64
+	// Whiteouts is the number of paths removed from lower layers.
+	Whiteouts int
+}
+
+// Node is a path in the merged filesystem of the image.
+type Node struct {
+	Name     string
+	Path     string
+	Parent   *Node
+	Children []*Node
+	// Header is nil for directories that only exist implicitly.
+	Header *tar.Header
+	// Layer is the index of the layer that provides the path.
+	Layer int
+	// Size is the size of a regular file. Hardlinks have no size of their own.
+	Size int64
+	// TotalSize is the size of the node and all nodes below it.
+	TotalSize int64
+	// Links are the paths of hardlinks to this node.
+	Links []string
+
+	children map[string]*Node
+}
+
+// IsDir reports whether the node is a directory.
+func (n *Node) IsDir() bool {
+	return n.Header == nil || n.Header.Typeflag == tar.TypeDir || len(n.Children) > 0
+}
+
+// Kind returns a short description of the type of the node.
+func (n *Node) Kind() string {
+	if n.Header == nil {
+		return "dir"
+	}
+	switch n.Header.Typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return "other"
+}
+
+// Image is the merged filesystem of an image.
+type Image struct {
+	Layers []Layer
+	Root   *Node
+
+	sources []LayerSource
+}
+
+// Load reads the headers of all layers (in parallel) and merges them in order.
+func Load(ctx context.Context, sources []LayerSource) (*Image, error) {
+	headers := make([][]*tar.Header, len(sources))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(4)
+	for i, source := range sources {
+		g.Go(func() error {
+			layerHeaders, err := readHeaders(ctx, source)
+			if err != nil {
+				return fmt.Errorf("reading layer %d (%s): %w", i, source.Digest, err)
+			}
+			headers[i] = layerHeaders
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	img := &Image{Root: newNode("", "/", nil), sources: sources}
+	for i, source := range sources {
+		img.Layers = append(img.Layers, Layer{Index: i, Digest: source.Digest, Size: source.Size})
+		img.apply(i, headers[i])
+	}
+	img.Root.computeSizes()
+	return img, nil
+}
+
+func readHeaders(ctx context.Context, source LayerSource) ([]*tar.Header, error) {
+	rc, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	reader, err := fileopener.StreamCompressionReader(rc)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	var headers []*tar.Header
+	tarReader := tar.NewReader(reader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+}
+
+// apply merges the entries of layer index on top of the previous layers.
+func (img *Image) apply(index int, headers []*tar.Header) {
+	layer := &img.Layers[index]
+	for _, header := range headers {
+		layer.Entries++
+		name := cleanPath(header.Name)
+		dir, base := path.Split(name)
+		if whiteout, ok := strings.CutPrefix(base, ".wh."); ok {
+			layer.Whiteouts++
+			parent := img.lookup(dir)
+			if parent == nil {
+				continue
+			}
+			if whiteout == ".wh..opq" {
+				// An opaque directory hides the contents of lower layers only.
+				for _, child := range slices.Clone(parent.Children) {
+					if child.Layer < index {
+						img.shadow(child)
+						parent.removeChild(child.Name)
+					}
+				}
+				continue
+			}
+			if removed, ok := parent.children[whiteout]; ok {
+				img.shadow(removed)
+				parent.removeChild(whiteout)
+			}
+			continue
+		}
+		if name == "" {
+			img.Root.Header = header
+			img.Root.Layer = index
+			continue
+		}
+
+		parent := img.mkdirAll(dir, index)
+		node, exists := parent.children[base]
+		if exists && header.Typeflag != tar.TypeDir {
+			// The entry replaces the path (and everything below it).
+			img.shadow(node)
+			parent.removeChild(base)
+			exists = false
+		}
+		if !exists {
+			node = newNode(base, "/"+name, parent)
+			parent.addChild(node)
+		}
+		node.Header = header
+		node.Layer = index
+		switch header.Typeflag {
+		case tar.TypeReg:
+			node.Size = header.Size
+			layer.Bytes += header.Size
+		case tar.TypeLink:
+			if target := img.lookup(cleanPath(header.Linkname)); target != nil {
+				target.Links = append(target.Links, node.Path)
+				layer.LinkedBytes += target.Size
+			}
+		}
+	}
+}
+
+// shadow records that the node and everything below it is overwritten or removed.
+func (img *Image) shadow(node *Node) {
+	if node.Header != nil && node.Header.Typeflag == tar.TypeReg {
+		img.Layers[node.Layer].ShadowedBytes += node.Size
+	}
+	for _, child := range node.Children {
+		img.shadow(child)
+	}
+}
+
+// lookup returns the node at the (clean, relative) path or nil.
+func (img *Image) lookup(p string) *Node {
+	node := img.Root
+	for _, component := range strings.Split(strings.Trim(p, "/"), "/") {
+		if component == "" {
+			continue
+		}
+		child, ok := node.children[component]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// Lookup returns the node at the absolute path in the image or nil.
+func (img *Image) Lookup(p string) *Node {
+	return img.lookup(cleanPath(p))
+}
+
+func (img *Image) mkdirAll(dir string, index int) *Node {
+	node := img.Root
+	for _, component := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if component == "" {
+			continue
+		}
+		child, ok := node.children[component]
+		if !ok {
+			child = newNode(component, strings.TrimSuffix(node.Path, "/")+"/"+component, node)
+			child.Layer = index
+			node.addChild(child)
+		}
+		node = child
+	}
+	return node
+}
+
+func newNode(name, p string, parent *Node) *Node {
+	return &Node{Name: name, Path: p, Parent: parent, children: make(map[string]*Node)}
+}
+
+func (n *Node) addChild(child *Node) {
+	n.children[child.Name] = child
+	n.Children = append(n.Children, child)
+}
+
+func (n *Node) removeChild(name string) {
+	delete(n.children, name)
+	n.Children = slices.DeleteFunc(n.Children, func(child *Node) bool { return child.Name == name })
+}
+
+func (n *Node) computeSizes() int64 {
+	n.TotalSize = n.Size
+	for _, child := range n.Children {
+		n.TotalSize += child.computeSizes()
+	}
+	slices.SortFunc(n.Children, func(a, b *Node) int { return strings.Compare(a.Name, b.Name) })
+	return n.TotalSize
+}
+
+// cleanPath returns the path of a tar entry without leading "./" or "/" and trailing "/".
+func cleanPath(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
@@ -0,0 +1,204 @@
+package explore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tarLayer returns a layer source with the given entries. Regular files contain fileContent.
+func tarLayer(t *testing.T, digest string, compress bool, headers ...*tar.Header) LayerSource {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, header := range headers {
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, fileContent(header.Name, header.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+	return LayerSource{
+		Digest: digest,
+		Size:   int64(len(data)),
+		Open:   func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+	}
+}
+
+// fileContent returns size bytes of the repeated name of a file.
+func fileContent(name string, size int64) string {
+	return strings.Repeat(name, int(size)/len(name)+1)[:size]
+}
+
+func file(name string, size int64) *tar.Header {
+	return &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size}
+}
+
+func testImage(t *testing.T) *Image {
+	t.Helper()
+	base := tarLayer(t, "sha256:1111111111111111111111111111111111111111111111111111111111111111", true,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0o755},
+		file("./etc/a.txt", 10),
+		file("./etc/b.txt", 5),
+		file("bin/tool", 20),
+		&tar.Header{Typeflag: tar.TypeLink, Name: "bin/tool2", Linkname: "bin/tool"},
+		file("usr/share/doc", 7),
+	)
+	app := tarLayer(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", false,
+		file("etc/a.txt", 3),
+		file("etc/.wh.b.txt", 0),
+		// the opaque directory hides the lower layers, but not entries of its own layer
+		file("usr/keep", 2),
+		file("usr/.wh..wh..opq", 0),
+		file("usr/new", 1),
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/a.txt"},
+	)
+	img, err := Load(context.Background(), []LayerSource{base, app})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return img
+}
+
+func TestLoad(t *testing.T) {
+	img := testImage(t)
+
+	wantLayers := []Layer{
+		{Index: 0, Digest: img.Layers[0].Digest, Size: img.Layers[0].Size, Entries: 6, Bytes: 42, LinkedBytes: 20, ShadowedBytes: 22},
+		{Index: 1, Digest: img.Layers[1].Digest, Size: img.Layers[1].Size, Entries: 6, Bytes: 6, Whiteouts: 2},
+	}
+	if !reflect.DeepEqual(img.Layers, wantLayers) {
+		t.Errorf("Layers = %+v, want %+v", img.Layers, wantLayers)
+	}
+
+	var names []string
+	for _, child := range img.Root.Children {
+		names = append(names, child.Name)
+	}
+	if want := []string{"bin", "etc", "link", "usr"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("children of / = %q, want %q", names, want)
+	}
+	if img.Root.TotalSize != 26 {
+		t.Errorf("total size = %d, want 26", img.Root.TotalSize)
+	}
+
+	tests := []struct {
+		path      string
+		wantKind  string
+		wantLayer int
+		wantSize  int64
+	}{
+		{"/etc/a.txt", "file", 1, 3},
+		{"/bin", "dir", 0, 20},
+		{"/bin/tool2", "hardlink", 0, 0},
+		{"/link", "symlink", 1, 0},
+		{"/usr", "dir", 0, 3},
+		{"/usr/keep", "file", 1, 2},
+	}
+	for _, tt := range tests {
+		node := img.Lookup(tt.path)
+		if node == nil {
+			t.Errorf("Lookup(%q) = nil", tt.path)
+			continue
+		}
+		if node.Kind() != tt.wantKind || node.Layer != tt.wantLayer || node.TotalSize != tt.wantSize {
+			t.Errorf("Lookup(%q) = %s from layer %d with %d bytes, want %s from layer %d with %d bytes", tt.path, node.Kind(), node.Layer, node.TotalSize, tt.wantKind, tt.wantLayer, tt.wantSize)
+		}
+	}
+	for _, removed := range []string{"/etc/b.txt", "/usr/share", "/usr/share/doc"} {
+		if node := img.Lookup(removed); node != nil {
+			t.Errorf("Lookup(%q) = %s, want the path to be removed", removed, node.Path)
+		}
+	}
+	if links := img.Lookup("/bin/tool").Links; !reflect.DeepEqual(links, []string{"/bin/tool2"}) {
+		t.Errorf("hardlinks of /bin/tool = %q, want [/bin/tool2]", links)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	broken := LayerSource{
+		Digest: "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+		Open:   func() (io.ReadCloser, error) { return nil, errors.New("blob not found") },
+	}
+	truncated := tarLayer(t, "sha256:4444444444444444444444444444444444444444444444444444444444444444", true, file("a", 1))
+	rc, err := truncated.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated.Open = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data[:len(data)/2])), nil }
+
+	tests := []struct {
+		name    string
+		sources []LayerSource
+		wantErr string
+	}{
+		{"open fails", []LayerSource{tarLayer(t, "sha256:base", false), broken}, "reading layer 1 (sha256:3333333333333333333333333333333333333333333333333333333333333333): blob not found"},
+		{"truncated", []LayerSource{truncated}, "reading layer 0 (sha256:4444444444444444444444444444444444444444444444444444444444444444)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(context.Background(), tt.sources); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	img := testImage(t)
+	var b strings.Builder
+	// blob sizes depend on the compression
+	img.Layers[0].Size = 2048
+	img.Layers[1].Size = 1536
+	Print(&b, img, img.Root, 2)
+	want := `LAYER  DIGEST                     BLOB   ENTRIES       FILES  HARDLINKED    SHADOWED
+    0  sha256:111111111111     2.0 KiB         6        42 B        20 B        22 B
+    1  sha256:222222222222     1.5 KiB         6         6 B         0 B         0 B
+
+      SIZE  LAYER  PATH
+      26 B      0  /
+      20 B      0    bin/
+      20 B      0      tool (1 hardlinks)
+       0 B      0      tool2 => /bin/tool
+       3 B      0    etc/
+       3 B      1      a.txt
+       0 B      1    link -> /etc/a.txt
+       3 B      0    usr/
+       2 B      1      keep
+       1 B      1      new
+`
+	if got := b.String(); got != want {
+		t.Errorf("Print() =\n%s\nwant\n%s", got, want)
+	}
+}
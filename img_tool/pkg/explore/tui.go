@@ -0,0 +1,283 @@
+package explore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+)
+
+const (
+	clearScreen   = "\x1b[H\x1b[2J"
+	hideCursor    = "\x1b[?25l"
+	showCursor    = "\x1b[?25h"
+	reverseVideo  = "\x1b[7m"
+	boldText      = "\x1b[1m"
+	resetStyle    = "\x1b[0m"
+	maxLayerLines = 8
+)
+
+const helpLine = "↑/↓ move  →/enter open  ←/backspace up  [ ] select layer  f only show paths of selected layer  q quit"
+
+// explorer is the state of the interactive explorer.
+type explorer struct {
+	img *Image
+	cwd *Node
+	// cursor is the index of the selected entry in cwd.
+	cursor int
+	// offset is the index of the first visible entry.
+	offset int
+	// layer is the selected layer.
+	layer int
+	// filter only shows paths provided by the selected layer.
+	filter bool
+	// touched caches whether a subtree contains paths of the selected layer.
+	touched map[*Node]bool
+}
+
+// Run starts the interactive explorer on the terminal of in and out.
+func Run(img *Image, in, out *os.File) error {
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("switching terminal to raw mode: %w", err)
+	}
+	defer restore()
+	w := bufio.NewWriter(out)
+	defer func() {
+		fmt.Fprint(w, resetStyle, showCursor, clearScreen)
+		w.Flush()
+	}()
+	fmt.Fprint(w, hideCursor)
+
+	e := &explorer{img: img, cwd: img.Root}
+	keys := bufio.NewReader(in)
+	for {
+		width, height, err := terminalSize(int(out.Fd()))
+		if err != nil {
+			return err
+		}
+		e.render(w, width, height)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		key, err := readKey(keys)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !e.handle(key, height) {
+			return nil
+		}
+	}
+}
+
+// readKey reads a key press and returns its name ("up", "down", "left", "right", "enter", "backspace")
+// or the typed character.
+func readKey(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case '\x1b':
+		if r.Buffered() < 2 {
+			return "esc", nil
+		}
+		if next, _ := r.ReadByte(); next != '[' && next != 'O' {
+			return "esc", nil
+		}
+		switch code, _ := r.ReadByte(); code {
+		case 'A':
+			return "up", nil
+		case 'B':
+			return "down", nil
+		case 'C':
+			return "right", nil
+		case 'D':
+			return "left", nil
+		case '5', '6':
+			r.ReadByte() // trailing '~'
+			if code == '5' {
+				return "pgup", nil
+			}
+			return "pgdown", nil
+		}
+		return "esc", nil
+	case '\r', '\n':
+		return "enter", nil
+	case 127, '\b':
+		return "backspace", nil
+	case 3:
+		// Ctrl+C (ISIG is disabled in raw mode)
+		return "q", nil
+	}
+	return string(b), nil
+}
+
+// handle applies a key press and reports whether the explorer keeps running.
+func (e *explorer) handle(key string, height int) bool {
+	entries := e.entries()
+	page := max(e.listHeight(height), 1)
+	switch key {
+	case "q", "esc":
+		return false
+	case "up", "k":
+		e.cursor--
+	case "down", "j":
+		e.cursor++
+	case "pgup":
+		e.cursor -= page
+	case "pgdown":
+		e.cursor += page
+	case "right", "enter", "l":
+		if e.cursor < len(entries) && entries[e.cursor].IsDir() {
+			e.cwd = entries[e.cursor]
+			e.cursor, e.offset = 0, 0
+		}
+	case "left", "backspace", "h":
+		if e.cwd.Parent != nil {
+			previous := e.cwd
+			e.cwd = e.cwd.Parent
+			e.offset = 0
+			e.cursor = max(indexOf(e.entries(), previous), 0)
+		}
+	case "[":
+		e.selectLayer(e.layer - 1)
+	case "]":
+		e.selectLayer(e.layer + 1)
+	case "f":
+		e.filter = !e.filter
+		e.cursor, e.offset = 0, 0
+	}
+	e.cursor = min(max(e.cursor, 0), max(len(e.entries())-1, 0))
+	return true
+}
+
+func (e *explorer) selectLayer(layer int) {
+	if layer < 0 || layer >= len(e.img.Layers) {
+		return
+	}
+	e.layer = layer
+	e.touched = nil
+	if e.filter {
+		e.cursor, e.offset = 0, 0
+	}
+}
+
+// entries returns the visible children of the current directory.
+func (e *explorer) entries() []*Node {
+	if !e.filter {
+		return e.cwd.Children
+	}
+	var entries []*Node
+	for _, child := range e.cwd.Children {
+		if e.touchedBySelectedLayer(child) {
+			entries = append(entries, child)
+		}
+	}
+	return entries
+}
+
+func (e *explorer) touchedBySelectedLayer(node *Node) bool {
+	if e.touched == nil {
+		e.touched = make(map[*Node]bool)
+	}
+	if touched, ok := e.touched[node]; ok {
+		return touched
+	}
+	touched := node.Header != nil && node.Layer == e.layer
+	for _, child := range node.Children {
+		if e.touchedBySelectedLayer(child) {
+			touched = true
+		}
+	}
+	e.touched[node] = touched
+	return touched
+}
+
+// listHeight returns the number of rows available for directory entries.
+func (e *explorer) listHeight(height int) int {
+	// title, layer header, layers, separator, entry header, help
+	return height - 5 - min(len(e.img.Layers), maxLayerLines)
+}
+
+func (e *explorer) render(w io.Writer, width, height int) {
+	fmt.Fprint(w, clearScreen)
+	line := func(style, text string) {
+		text = truncate(text, width)
+		if style != "" {
+			fmt.Fprint(w, style, text, resetStyle, "\r\n")
+		} else {
+			fmt.Fprint(w, text, "\r\n")
+		}
+	}
+
+	title := fmt.Sprintf("img explore  %s  (%s)", e.cwd.Path, humansize.Format(e.cwd.TotalSize))
+	if e.filter {
+		title += fmt.Sprintf("  [only layer %d]", e.layer)
+	}
+	line(boldText, title)
+
+	line(boldText, fmt.Sprintf("%5s  %-19s  %10s  %8s  %10s  %10s  %10s", "LAYER", "DIGEST", "BLOB", "ENTRIES", "FILES", "HARDLINKED", "SHADOWED"))
+	first := min(max(e.layer-maxLayerLines/2, 0), max(len(e.img.Layers)-maxLayerLines, 0))
+	for i := first; i < len(e.img.Layers) && i < first+maxLayerLines; i++ {
+		layer := e.img.Layers[i]
+		text := fmt.Sprintf("%5d  %-19s  %10s  %8d  %10s  %10s  %10s",
+			layer.Index, shortDigest(layer.Digest), humansize.Format(layer.Size), layer.Entries,
+			humansize.Format(layer.Bytes), humansize.Format(layer.LinkedBytes), humansize.Format(layer.ShadowedBytes))
+		if i == e.layer {
+			line(reverseVideo, text)
+		} else {
+			line("", text)
+		}
+	}
+	line("", strings.Repeat("─", max(width, 1)))
+
+	line(boldText, fmt.Sprintf("%10s  %5s  %-8s  %s", "SIZE", "LAYER", "TYPE", "NAME"))
+	entries := e.entries()
+	rows := e.listHeight(height)
+	if e.cursor < e.offset {
+		e.offset = e.cursor
+	}
+	if rows > 0 && e.cursor >= e.offset+rows {
+		e.offset = e.cursor - rows + 1
+	}
+	for i := e.offset; i < len(entries) && i < e.offset+rows; i++ {
+		node := entries[i]
+		text := fmt.Sprintf("%10s  %5d  %-8s  %s", humansize.Format(node.TotalSize), node.Layer, node.Kind(), describe(node))
+		switch {
+		case i == e.cursor:
+			line(reverseVideo, text)
+		case node.Header != nil && node.Layer == e.layer:
+			line(boldText, text)
+		default:
+			line("", text)
+		}
+	}
+	for i := len(entries) - e.offset; i < rows; i++ {
+		line("", "")
+	}
+	fmt.Fprint(w, truncate(helpLine, width))
+}
+
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return text
+	}
+	return string(runes[:width])
+}
+
+func indexOf(nodes []*Node, node *Node) int {
+	for i, candidate := range nodes {
+		if candidate == node {
+			return i
+		}
+	}
+	return -1
+}
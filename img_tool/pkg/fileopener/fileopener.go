@@ -1,12 +1,14 @@
 package fileopener
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 )
@@ -21,6 +23,8 @@ func CompressionReaderWithFormat(r io.Reader, format api.CompressionAlgorithm) (
 			return nil, err
 		}
 		return decoder.IOReadCloser(), nil
+	case api.Xz:
+		return xz.NewReader(r)
 	case api.Uncompressed, "tar", "none":
 		return r, nil
 	default:
@@ -37,18 +41,37 @@ func CompressionReader(r underlyingReader) (io.Reader, error) {
 	return CompressionReaderWithFormat(r, compressionFormat)
 }
 
+// StreamCompressionReader is like CompressionReader,
+// but it detects the compression format of streams that don't support random access.
+func StreamCompressionReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	startMagic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return CompressionReaderWithFormat(br, compressionFromMagic(startMagic))
+}
+
 func LearnCompressionAlgorithm(r io.ReaderAt) (api.CompressionAlgorithm, error) {
-	var startMagic [4]byte
-	if _, err := r.ReadAt(startMagic[:], 0); err != nil {
+	var startMagic [len(xzMagic)]byte
+	n, err := r.ReadAt(startMagic[:], 0)
+	if err != nil && (err != io.EOF || n < len(zstdMagic)) {
 		return "", err
 	}
-	if bytes.Compare(startMagic[:2], gzipMagic[:]) == 0 {
-		return api.Gzip, nil
-	}
-	if bytes.Compare(startMagic[:4], zstdMagic[:]) == 0 {
-		return api.Zstd, nil
+	return compressionFromMagic(startMagic[:n]), nil
+}
+
+// compressionFromMagic detects the compression algorithm from the first bytes of a file.
+func compressionFromMagic(startMagic []byte) api.CompressionAlgorithm {
+	switch {
+	case bytes.HasPrefix(startMagic, gzipMagic[:]):
+		return api.Gzip
+	case bytes.HasPrefix(startMagic, zstdMagic[:]):
+		return api.Zstd
+	case bytes.HasPrefix(startMagic, xzMagic[:]):
+		return api.Xz
 	}
-	return api.Uncompressed, nil
+	return api.Uncompressed
 }
 
 func LearnLayerFormat(r io.ReaderAt) (api.LayerFormat, error) {
@@ -95,6 +118,7 @@ type underlyingReader interface {
 var (
 	gzipMagic = [2]byte{0x1f, 0x8b}
 	zstdMagic = [4]byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = [6]byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
 	tarMagicA = [8]byte{0x75, 0x73, 0x74, 0x61, 0x72, 0x00, 0x30, 0x30}
 	tarMagicB = [8]byte{0x75, 0x73, 0x74, 0x61, 0x72, 0x20, 0x20, 0x00}
 )
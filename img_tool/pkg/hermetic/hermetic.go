@@ -0,0 +1,88 @@
+// Package hermetic provides injectable sources of wall-clock time and random identifiers.
+//
+// Code that puts timestamps or random values into its outputs (containerd leases,
+// temporary upload names, "created" fields, webhook payloads) takes a Clock or an IDSource
+// instead of calling time.Now or a random generator directly.
+// Production code uses System and Random, while unit tests and reproducibility audits
+// can substitute Fixed and Sequential to drive the values and assert that no wall-clock
+// time leaks into artifacts.
+package hermetic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDSource returns unique identifiers in the canonical UUID text form.
+type IDSource interface {
+	NewID() string
+}
+
+// System is the Clock backed by the wall clock.
+var System Clock = systemClock{}
+
+// Random is the IDSource returning random (version 4) UUIDs.
+var Random IDSource = randomIDs{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type randomIDs struct{}
+
+func (randomIDs) NewID() string { return uuid.NewString() }
+
+// Fixed returns a Clock that always returns t.
+func Fixed(t time.Time) Clock {
+	return fixedClock{t: t}
+}
+
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+// Sequential returns an IDSource returning predictable identifiers,
+// starting at 00000000-0000-0000-0000-000000000001 and counting up.
+// It is safe for concurrent use.
+func Sequential() IDSource {
+	return &sequentialIDs{}
+}
+
+type sequentialIDs struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+func (s *sequentialIDs) NewID() string {
+	s.mu.Lock()
+	s.next++
+	n := s.next
+	s.mu.Unlock()
+	return fmt.Sprintf("00000000-0000-0000-%04x-%012x", (n>>48)&0xffff, n&0xffffffffffff)
+}
+
+// ClockOrSystem returns c, or System if c is nil.
+func ClockOrSystem(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+// IDsOrRandom returns ids, or Random if ids is nil.
+func IDsOrRandom(ids IDSource) IDSource {
+	if ids == nil {
+		return Random
+	}
+	return ids
+}
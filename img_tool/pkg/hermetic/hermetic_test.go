@@ -0,0 +1,89 @@
+package hermetic
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFixed(t *testing.T) {
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := Fixed(want)
+	for range 2 {
+		if got := clock.Now(); !got.Equal(want) {
+			t.Errorf("Fixed().Now() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSequential(t *testing.T) {
+	ids := Sequential()
+	for _, want := range []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+	} {
+		if got := ids.NewID(); got != want {
+			t.Errorf("NewID() = %q, want %q", got, want)
+		}
+	}
+	// the third group carries the upper bits of the counter
+	large := &sequentialIDs{next: 1<<48 - 1}
+	if got, want := large.NewID(), "00000000-0000-0000-0001-000000000000"; got != want {
+		t.Errorf("NewID() = %q, want %q", got, want)
+	}
+}
+
+func TestSequentialConcurrent(t *testing.T) {
+	ids := Sequential()
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				id := ids.NewID()
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("NewID() returned %q twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 800 {
+		t.Errorf("NewID() returned %d distinct IDs, want 800", len(seen))
+	}
+}
+
+func TestRandom(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	first, second := Random.NewID(), Random.NewID()
+	if !uuidV4.MatchString(first) {
+		t.Errorf("Random.NewID() = %q, want a version 4 UUID", first)
+	}
+	if first == second {
+		t.Errorf("Random.NewID() returned %q twice", first)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	if got := ClockOrSystem(nil); got != System {
+		t.Errorf("ClockOrSystem(nil) = %v, want System", got)
+	}
+	fixed := Fixed(time.Unix(0, 0))
+	if got := ClockOrSystem(fixed); got != fixed {
+		t.Errorf("ClockOrSystem(fixed) = %v, want the fixed clock", got)
+	}
+	if got := IDsOrRandom(nil); got != Random {
+		t.Errorf("IDsOrRandom(nil) = %v, want Random", got)
+	}
+	sequential := Sequential()
+	if got := IDsOrRandom(sequential); got != sequential {
+		t.Errorf("IDsOrRandom(sequential) = %v, want the sequential source", got)
+	}
+}
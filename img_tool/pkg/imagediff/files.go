@@ -0,0 +1,151 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tarreader"
+)
+
+// FileChange is a path whose entry differs between the changed layers of two images.
+type FileChange struct {
+	Path string
+	// OldSize is -1 if a file of an unchanged layer is removed.
+	OldSize int64
+	NewSize int64
+}
+
+// FileDiff describes the differences between the files of the changed layers of two images.
+type FileDiff struct {
+	Added   []FileChange
+	Removed []FileChange
+	Changed []FileChange
+}
+
+// fileEntry is the state of a path after applying a sequence of layers.
+type fileEntry struct {
+	whiteout bool
+	typeflag byte
+	mode     int64
+	uid, gid int
+	linkname string
+	size     int64
+	// digest of the file content (regular files only)
+	digest [sha256.Size]byte
+}
+
+// CompareFiles compares the files of the layers that were removed from the old image
+// with the files of the layers that were added to the new image.
+// Files of layers that are present in both images are the same and not compared.
+// Directories are ignored. CompareFiles returns nil if the blobs of the changed layers are not available.
+func CompareFiles(report Report) (*FileDiff, error) {
+	if !hasLayers(report.Old, report.RemovedLayers) || !hasLayers(report.New, report.AddedLayers) {
+		return nil, nil
+	}
+	oldFiles, err := layerFiles(report.Old, report.RemovedLayers)
+	if err != nil {
+		return nil, fmt.Errorf("reading files of old image: %w", err)
+	}
+	newFiles, err := layerFiles(report.New, report.AddedLayers)
+	if err != nil {
+		return nil, fmt.Errorf("reading files of new image: %w", err)
+	}
+
+	var diff FileDiff
+	for _, name := range slices.Sorted(maps.Keys(newFiles)) {
+		newEntry := newFiles[name]
+		oldEntry, ok := oldFiles[name]
+		switch {
+		case newEntry.whiteout:
+			switch {
+			case !ok:
+				diff.Removed = append(diff.Removed, FileChange{Path: name, OldSize: -1})
+			case !oldEntry.whiteout:
+				diff.Removed = append(diff.Removed, FileChange{Path: name, OldSize: oldEntry.size})
+			}
+		case !ok || oldEntry.whiteout:
+			diff.Added = append(diff.Added, FileChange{Path: name, NewSize: newEntry.size})
+		case oldEntry != newEntry:
+			diff.Changed = append(diff.Changed, FileChange{Path: name, OldSize: oldEntry.size, NewSize: newEntry.size})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(oldFiles)) {
+		if _, ok := newFiles[name]; !ok && !oldFiles[name].whiteout {
+			diff.Removed = append(diff.Removed, FileChange{Path: name, OldSize: oldFiles[name].size})
+		}
+	}
+	slices.SortFunc(diff.Removed, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return &diff, nil
+}
+
+func hasLayers(img Image, layers []specv1.Descriptor) bool {
+	for _, layer := range layers {
+		if _, ok := img.LayerPaths[layer.Digest.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// layerFiles applies the layers in order and returns the resulting entries by path.
+// Whiteouts are kept as entries, so that removed files of lower layers can be reported.
+func layerFiles(img Image, layers []specv1.Descriptor) (map[string]fileEntry, error) {
+	files := make(map[string]fileEntry)
+	for _, layer := range layers {
+		err := tarreader.Walk(img.LayerPaths[layer.Digest.String()], func(hdr *tar.Header, content io.Reader) error {
+			name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+			dir, base := path.Split(name)
+			if whiteout, ok := strings.CutPrefix(base, ".wh."); ok {
+				if whiteout == ".wh..opq" {
+					removeBelow(files, strings.TrimSuffix(dir, "/"))
+					return nil
+				}
+				removed := dir + whiteout
+				removeBelow(files, removed)
+				files[removed] = fileEntry{whiteout: true}
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeDir {
+				return nil
+			}
+			entry := fileEntry{
+				typeflag: hdr.Typeflag,
+				mode:     hdr.Mode,
+				uid:      hdr.Uid,
+				gid:      hdr.Gid,
+				linkname: hdr.Linkname,
+				size:     hdr.Size,
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				h := sha256.New()
+				if _, err := io.Copy(h, content); err != nil {
+					return err
+				}
+				copy(entry.digest[:], h.Sum(nil))
+			}
+			files[name] = entry
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+	}
+	return files, nil
+}
+
+// removeBelow removes all entries below a directory.
+func removeBelow(files map[string]fileEntry, dir string) {
+	for existing := range files {
+		if dir == "" || strings.HasPrefix(existing, dir+"/") {
+			delete(files, existing)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package imagediff compares two container images and renders
+// a human-readable report of the differences.
+package imagediff
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Image is a single-platform image.
+type Image struct {
+	Digest   string
+	Manifest specv1.Manifest
+	Config   specv1.Image
+	// LayerPaths maps layer digests to the path of the layer blob.
+	// Layers of shallow pulled images have no entry.
+	LayerPaths map[string]string
+}
+
+// LoadImage reads a single-platform image from a raw manifest and config.
+func LoadImage(manifestPath, configPath string, layerPaths map[string]string) (Image, error) {
+	rawManifest, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return Image{}, fmt.Errorf("reading manifest: %w", err)
+	}
+	var manifest specv1.Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return Image{}, fmt.Errorf("decoding manifest %s: %w", manifestPath, err)
+	}
+	rawConfig, err := os.ReadFile(configPath)
+	if err != nil {
+		return Image{}, fmt.Errorf("reading config: %w", err)
+	}
+	var config specv1.Image
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return Image{}, fmt.Errorf("decoding config %s: %w", configPath, err)
+	}
+	return Image{
+		Digest:     digestOf(rawManifest),
+		Manifest:   manifest,
+		Config:     config,
+		LayerPaths: layerPaths,
+	}, nil
+}
+
+// Platform returns the platform of the image in the form os/architecture[/variant].
+func (img Image) Platform() string {
+	platform := img.Config.OS + "/" + img.Config.Architecture
+	if img.Config.Variant != "" {
+		platform += "/" + img.Config.Variant
+	}
+	return platform
+}
+
+// Size returns the sum of the sizes of the config and all layers.
+func (img Image) Size() int64 {
+	size := img.Manifest.Config.Size
+	for _, layer := range img.Manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// hasAllLayers reports whether the blobs of all layers are available.
+func (img Image) hasAllLayers() bool {
+	for _, layer := range img.Manifest.Layers {
+		if _, ok := img.LayerPaths[layer.Digest.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Change is a difference in a single config field.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Report describes the differences between two images of the same platform.
+type Report struct {
+	Platform      string
+	Old           Image
+	New           Image
+	AddedLayers   []specv1.Descriptor
+	RemovedLayers []specv1.Descriptor
+	ConfigChanges []Change
+	// Packages is nil if the installed packages could not be determined
+	// for one of the images.
+	Packages *PackageDiff
+	// Files is only set by CompareFiles.
+	Files *FileDiff
+}
+
+// Compare computes the differences between two images.
+func Compare(oldImage, newImage Image) (Report, error) {
+	report := Report{
+		Platform: newImage.Platform(),
+		Old:      oldImage,
+		New:      newImage,
+	}
+
+	oldLayers := make(map[string]struct{}, len(oldImage.Manifest.Layers))
+	for _, layer := range oldImage.Manifest.Layers {
+		oldLayers[layer.Digest.String()] = struct{}{}
+	}
+	newLayers := make(map[string]struct{}, len(newImage.Manifest.Layers))
+	for _, layer := range newImage.Manifest.Layers {
+		newLayers[layer.Digest.String()] = struct{}{}
+		if _, ok := oldLayers[layer.Digest.String()]; !ok {
+			report.AddedLayers = append(report.AddedLayers, layer)
+		}
+	}
+	for _, layer := range oldImage.Manifest.Layers {
+		if _, ok := newLayers[layer.Digest.String()]; !ok {
+			report.RemovedLayers = append(report.RemovedLayers, layer)
+		}
+	}
+
+	report.ConfigChanges = compareConfigs(oldImage.Config, newImage.Config)
+
+	if oldImage.hasAllLayers() && newImage.hasAllLayers() {
+		oldPackages, err := InstalledPackages(oldImage)
+		if err != nil {
+			return Report{}, fmt.Errorf("reading packages of old image: %w", err)
+		}
+		newPackages, err := InstalledPackages(newImage)
+		if err != nil {
+			return Report{}, fmt.Errorf("reading packages of new image: %w", err)
+		}
+		if oldPackages != nil || newPackages != nil {
+			diff := comparePackages(oldPackages, newPackages)
+			report.Packages = &diff
+		}
+	}
+	return report, nil
+}
+
+func compareConfigs(oldConfig, newConfig specv1.Image) []Change {
+	var changes []Change
+	compare := func(field string, oldValue, newValue any) {
+		oldStr, newStr := format(oldValue), format(newValue)
+		if oldStr != newStr {
+			changes = append(changes, Change{Field: field, Old: oldStr, New: newStr})
+		}
+	}
+	compare("os.version", oldConfig.OSVersion, newConfig.OSVersion)
+	compare("os.features", oldConfig.OSFeatures, newConfig.OSFeatures)
+	compare("User", oldConfig.Config.User, newConfig.Config.User)
+	compare("ExposedPorts", sortedKeys(oldConfig.Config.ExposedPorts), sortedKeys(newConfig.Config.ExposedPorts))
+	compare("Entrypoint", oldConfig.Config.Entrypoint, newConfig.Config.Entrypoint)
+	compare("Cmd", oldConfig.Config.Cmd, newConfig.Config.Cmd)
+	compare("Volumes", sortedKeys(oldConfig.Config.Volumes), sortedKeys(newConfig.Config.Volumes))
+	compare("WorkingDir", oldConfig.Config.WorkingDir, newConfig.Config.WorkingDir)
+	compare("StopSignal", oldConfig.Config.StopSignal, newConfig.Config.StopSignal)
+
+	// environment variables and labels are compared per key
+	oldEnv, newEnv := envMap(oldConfig.Config.Env), envMap(newConfig.Config.Env)
+	for _, key := range sortedKeys(mergeKeys(oldEnv, newEnv)) {
+		compare("Env "+key, oldEnv[key], newEnv[key])
+	}
+	for _, key := range sortedKeys(mergeKeys(oldConfig.Config.Labels, newConfig.Config.Labels)) {
+		compare("Label "+key, oldConfig.Config.Labels[key], newConfig.Config.Labels[key])
+	}
+	return changes
+}
+
+func format(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) == 0 {
+			return ""
+		}
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+	return fmt.Sprint(value)
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		m[key] = value
+	}
+	return m
+}
+
+func mergeKeys[V any](a, b map[string]V) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
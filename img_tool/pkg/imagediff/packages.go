@@ -0,0 +1,200 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+)
+
+// Package databases of common distributions, relative to the root of the image.
+const (
+	dpkgStatusFile = "var/lib/dpkg/status"
+	// distroless images ship one status file per package
+	dpkgStatusDir  = "var/lib/dpkg/status.d/"
+	apkInstalledDB = "lib/apk/db/installed"
+)
+
+// PackageChange is a package whose version differs between two images.
+type PackageChange struct {
+	Name       string
+	OldVersion string
+	NewVersion string
+}
+
+// PackageDiff describes the differences in installed packages between two images.
+type PackageDiff struct {
+	Added   []PackageChange
+	Removed []PackageChange
+	Changed []PackageChange
+}
+
+// InstalledPackages returns the installed packages (name to version) of the image
+// by reading the dpkg and apk package databases from its layers.
+// It returns nil if the image contains no known package database.
+func InstalledPackages(img Image) (map[string]string, error) {
+	// Package database files by path. Later layers replace files of earlier layers.
+	dbFiles := make(map[string][]byte)
+	for _, layer := range img.Manifest.Layers {
+		layerPath, ok := img.LayerPaths[layer.Digest.String()]
+		if !ok {
+			return nil, fmt.Errorf("layer %s is not available", layer.Digest)
+		}
+		if err := readPackageDBFiles(layerPath, dbFiles); err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+	}
+	if len(dbFiles) == 0 {
+		return nil, nil
+	}
+
+	packages := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(dbFiles)) {
+		if name == apkInstalledDB {
+			parseAPKInstalled(dbFiles[name], packages)
+		} else {
+			parseDpkgStatus(dbFiles[name], packages)
+		}
+	}
+	return packages, nil
+}
+
+func readPackageDBFiles(layerPath string, dbFiles map[string][]byte) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := fileopener.CompressionReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		dir, base := path.Split(name)
+		if whiteout, ok := strings.CutPrefix(base, ".wh."); ok {
+			if whiteout == ".wh..opq" {
+				// opaque directory: remove everything below it from lower layers
+				for existing := range dbFiles {
+					if strings.HasPrefix(existing, dir) {
+						delete(dbFiles, existing)
+					}
+				}
+				continue
+			}
+			removed := dir + whiteout
+			for existing := range dbFiles {
+				if existing == removed || strings.HasPrefix(existing, removed+"/") {
+					delete(dbFiles, existing)
+				}
+			}
+			continue
+		}
+		if !isPackageDBFile(name) || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		dbFiles[name] = contents
+	}
+}
+
+func isPackageDBFile(name string) bool {
+	if name == dpkgStatusFile || name == apkInstalledDB {
+		return true
+	}
+	return strings.HasPrefix(name, dpkgStatusDir) && !strings.HasSuffix(name, ".md5sums")
+}
+
+// parseDpkgStatus parses a dpkg status file (RFC 822 style stanzas).
+func parseDpkgStatus(data []byte, packages map[string]string) {
+	forEachStanza(data, func(fields map[string]string) {
+		name := fields["Package"]
+		if name == "" {
+			return
+		}
+		// status.d files of distroless images have no Status field
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			return
+		}
+		packages[name] = fields["Version"]
+	}, ":")
+}
+
+// parseAPKInstalled parses the apk database of Alpine Linux.
+func parseAPKInstalled(data []byte, packages map[string]string) {
+	forEachStanza(data, func(fields map[string]string) {
+		if name := fields["P"]; name != "" {
+			packages[name] = fields["V"]
+		}
+	}, ":")
+}
+
+func forEachStanza(data []byte, fn func(fields map[string]string), separator string) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	fields := make(map[string]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(fields) > 0 {
+				fn(fields)
+				fields = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// continuation line
+			continue
+		}
+		key, value, ok := strings.Cut(line, separator)
+		if ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+}
+
+func comparePackages(oldPackages, newPackages map[string]string) PackageDiff {
+	var diff PackageDiff
+	for _, name := range slices.Sorted(maps.Keys(newPackages)) {
+		oldVersion, ok := oldPackages[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, PackageChange{Name: name, NewVersion: newPackages[name]})
+		case oldVersion != newPackages[name]:
+			diff.Changed = append(diff.Changed, PackageChange{Name: name, OldVersion: oldVersion, NewVersion: newPackages[name]})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(oldPackages)) {
+		if _, ok := newPackages[name]; !ok {
+			diff.Removed = append(diff.Removed, PackageChange{Name: name, OldVersion: oldPackages[name]})
+		}
+	}
+	return diff
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
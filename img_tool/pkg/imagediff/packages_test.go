@@ -0,0 +1,139 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const testDpkgStatus = `Package: bash
+Status: install ok installed
+Version: 5.2.21-2ubuntu4
+Description: GNU Bourne Again SHell
+ continuation line: not a field
+
+Package: vim
+Status: deinstall ok config-files
+Version: 2:9.1.0016-1ubuntu7
+
+Package: libc6
+Status: install ok installed
+Version: 2.39-0ubuntu8
+`
+
+func TestInstalledPackages(t *testing.T) {
+	dir := t.TempDir()
+	img := testImage(t, dir,
+		map[string]string{
+			"var/lib/dpkg/status":                  testDpkgStatus,
+			"var/lib/dpkg/status.d/tzdata":         "Package: tzdata\nVersion: 2024a-2\n",
+			"var/lib/dpkg/status.d/tzdata.md5sums": "Package: ignored\nVersion: 0\n",
+			"etc/apt/status":                       "Package: ignored\nVersion: 0\n",
+		},
+		map[string]string{
+			// a later layer replaces the status file and deletes a status.d file
+			"var/lib/dpkg/status":              "Package: bash\nStatus: install ok installed\nVersion: 5.2.21-2ubuntu5\n",
+			"var/lib/dpkg/status.d/.wh.tzdata": "",
+		},
+	)
+	got, err := InstalledPackages(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"bash": "5.2.21-2ubuntu5"}; !maps.Equal(got, want) {
+		t.Errorf("InstalledPackages() = %v, want %v", got, want)
+	}
+
+	first := img
+	first.Manifest.Layers = first.Manifest.Layers[:1]
+	got, err = InstalledPackages(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"bash": "5.2.21-2ubuntu4", "libc6": "2.39-0ubuntu8", "tzdata": "2024a-2"}; !maps.Equal(got, want) {
+		t.Errorf("InstalledPackages() of the first layer = %v, want %v", got, want)
+	}
+}
+
+func TestInstalledPackagesAPK(t *testing.T) {
+	img := testImage(t, t.TempDir(), map[string]string{
+		"lib/apk/db/installed": "C:Q1abc=\nP:musl\nV:1.2.5-r0\nA:x86_64\n\nP:busybox\nV:1.36.1-r29\n",
+	})
+	got, err := InstalledPackages(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"musl": "1.2.5-r0", "busybox": "1.36.1-r29"}; !maps.Equal(got, want) {
+		t.Errorf("InstalledPackages() = %v, want %v", got, want)
+	}
+}
+
+func TestInstalledPackagesNoDatabase(t *testing.T) {
+	img := testImage(t, t.TempDir(), map[string]string{"app/main": "binary"})
+	got, err := InstalledPackages(img)
+	if err != nil || got != nil {
+		t.Errorf("InstalledPackages() = %v, %v, want nil, nil", got, err)
+	}
+
+	img.LayerPaths = nil
+	if _, err := InstalledPackages(img); err == nil {
+		t.Error("InstalledPackages() with a missing layer succeeded")
+	}
+}
+
+func TestComparePackages(t *testing.T) {
+	got := comparePackages(
+		map[string]string{"bash": "5.2.21-2ubuntu4", "libc6": "2.39-0ubuntu8", "perl": "5.38.2"},
+		map[string]string{"bash": "5.2.21-2ubuntu5", "libc6": "2.39-0ubuntu8", "curl": "8.5.0"},
+	)
+	want := PackageDiff{
+		Added:   []PackageChange{{Name: "curl", NewVersion: "8.5.0"}},
+		Removed: []PackageChange{{Name: "perl", OldVersion: "5.38.2"}},
+		Changed: []PackageChange{{Name: "bash", OldVersion: "5.2.21-2ubuntu4", NewVersion: "5.2.21-2ubuntu5"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comparePackages() = %+v, want %+v", got, want)
+	}
+}
+
+// testImage writes one gzip compressed layer per map of file contents and returns an image of them.
+func testImage(t *testing.T, dir string, layers ...map[string]string) Image {
+	t.Helper()
+	img := Image{LayerPaths: make(map[string]string)}
+	for i, files := range layers {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, name := range sortedKeys(files) {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(files[name]))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(files[name])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		layerPath := filepath.Join(dir, fmt.Sprintf("layer%d", i))
+		if err := os.WriteFile(layerPath, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		layerDigest := digest.FromBytes(buf.Bytes())
+		img.Manifest.Layers = append(img.Manifest.Layers, specv1.Descriptor{MediaType: specv1.MediaTypeImageLayerGzip, Digest: layerDigest, Size: int64(buf.Len())})
+		img.LayerPaths[layerDigest.String()] = layerPath
+	}
+	return img
+}
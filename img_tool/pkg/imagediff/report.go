@@ -0,0 +1,169 @@
+package imagediff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+)
+
+// maxFileRows limits the number of files listed per report,
+// so that the report of large changes still fits into a code review comment.
+const maxFileRows = 100
+
+// WriteMarkdown renders the reports as a Markdown document suitable for code review.
+func WriteMarkdown(w io.Writer, reports []Report) error {
+	var b strings.Builder
+	b.WriteString("# Base image update\n")
+	for _, report := range reports {
+		if writeReport(&b, report) {
+			writePackages(&b, report)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteChangesMarkdown renders the reports as a Markdown document describing what changed in an image,
+// including the files of changed layers (see CompareFiles).
+func WriteChangesMarkdown(w io.Writer, reports []Report) error {
+	var b strings.Builder
+	b.WriteString("# Image changes\n")
+	for _, report := range reports {
+		if !writeReport(&b, report) {
+			continue
+		}
+		writeFiles(&b, report)
+		if report.Packages != nil {
+			writePackages(&b, report)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeReport writes the summary, layers and config changes of a report.
+// It returns false if the images are the same.
+func writeReport(b *strings.Builder, report Report) bool {
+	fmt.Fprintf(b, "\n## %s\n\n", report.Platform)
+	fmt.Fprintf(b, "- Old: `%s`\n", report.Old.Digest)
+	fmt.Fprintf(b, "- New: `%s`\n", report.New.Digest)
+	if report.Old.Digest == report.New.Digest {
+		b.WriteString("\nThe image is unchanged.\n")
+		return false
+	}
+	oldSize, newSize := report.Old.Size(), report.New.Size()
+	fmt.Fprintf(b, "- Size: %s → %s (%s)\n", humansize.Format(oldSize), humansize.Format(newSize), signedHumanSize(newSize-oldSize))
+	fmt.Fprintf(b, "- Layers: %d → %d\n", len(report.Old.Manifest.Layers), len(report.New.Manifest.Layers))
+	if shared := sharedLayerPrefix(report.Old, report.New); shared > 0 {
+		fmt.Fprintf(b, "- Unchanged leading layers: %d\n", shared)
+	}
+
+	if len(report.AddedLayers) > 0 || len(report.RemovedLayers) > 0 {
+		b.WriteString("\n### Layers\n\n")
+		b.WriteString("| | Digest | Size |\n|---|---|---|\n")
+		for _, layer := range report.RemovedLayers {
+			fmt.Fprintf(b, "| - | `%s` | %s |\n", layer.Digest, humansize.Format(layer.Size))
+		}
+		for _, layer := range report.AddedLayers {
+			fmt.Fprintf(b, "| + | `%s` | %s |\n", layer.Digest, humansize.Format(layer.Size))
+		}
+	}
+
+	if len(report.ConfigChanges) > 0 {
+		b.WriteString("\n### Config\n\n")
+		b.WriteString("| Field | Old | New |\n|---|---|---|\n")
+		for _, change := range report.ConfigChanges {
+			fmt.Fprintf(b, "| %s | %s | %s |\n", escapeCell(change.Field), codeCell(change.Old), codeCell(change.New))
+		}
+	}
+	return true
+}
+
+func writePackages(b *strings.Builder, report Report) {
+	b.WriteString("\n### Packages\n\n")
+	switch {
+	case report.Packages == nil && (!report.Old.hasAllLayers() || !report.New.hasAllLayers()):
+		b.WriteString("Packages could not be compared because not all layers are available.\n")
+		b.WriteString("Use `layer_handling = \"eager\"` in `pull` to make layers available.\n")
+	case report.Packages == nil:
+		b.WriteString("No package database found.\n")
+	case len(report.Packages.Added)+len(report.Packages.Removed)+len(report.Packages.Changed) == 0:
+		b.WriteString("No package changes.\n")
+	default:
+		b.WriteString("| | Package | Old | New |\n|---|---|---|---|\n")
+		for _, pkg := range report.Packages.Removed {
+			fmt.Fprintf(b, "| - | %s | %s | |\n", escapeCell(pkg.Name), codeCell(pkg.OldVersion))
+		}
+		for _, pkg := range report.Packages.Added {
+			fmt.Fprintf(b, "| + | %s | | %s |\n", escapeCell(pkg.Name), codeCell(pkg.NewVersion))
+		}
+		for _, pkg := range report.Packages.Changed {
+			fmt.Fprintf(b, "| ~ | %s | %s | %s |\n", escapeCell(pkg.Name), codeCell(pkg.OldVersion), codeCell(pkg.NewVersion))
+		}
+	}
+}
+
+func writeFiles(b *strings.Builder, report Report) {
+	b.WriteString("\n### Files\n\n")
+	switch {
+	case report.Files == nil:
+		b.WriteString("Files could not be compared because the changed layers are not available.\n")
+	case len(report.Files.Added)+len(report.Files.Removed)+len(report.Files.Changed) == 0:
+		b.WriteString("No file changes in the changed layers.\n")
+	default:
+		b.WriteString("| | Path | Old size | New size |\n|---|---|---|---|\n")
+		rows := 0
+		writeRows := func(sign string, changes []FileChange) {
+			for _, change := range changes {
+				rows++
+				if rows > maxFileRows {
+					continue
+				}
+				oldSize, newSize := "", ""
+				if sign != "+" && change.OldSize >= 0 {
+					oldSize = humansize.Format(change.OldSize)
+				}
+				if sign != "-" {
+					newSize = humansize.Format(change.NewSize)
+				}
+				fmt.Fprintf(b, "| %s | %s | %s | %s |\n", sign, codeCell("/"+change.Path), oldSize, newSize)
+			}
+		}
+		writeRows("-", report.Files.Removed)
+		writeRows("+", report.Files.Added)
+		writeRows("~", report.Files.Changed)
+		if rows > maxFileRows {
+			fmt.Fprintf(b, "\n%d more files changed.\n", rows-maxFileRows)
+		}
+	}
+}
+
+// sharedLayerPrefix returns the number of leading layers that are the same in both images.
+func sharedLayerPrefix(oldImage, newImage Image) int {
+	shared := 0
+	for shared < len(oldImage.Manifest.Layers) && shared < len(newImage.Manifest.Layers) &&
+		oldImage.Manifest.Layers[shared].Digest == newImage.Manifest.Layers[shared].Digest {
+		shared++
+	}
+	return shared
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+func codeCell(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + escapeCell(s) + "`"
+}
+
+func signedHumanSize(delta int64) string {
+	if delta < 0 {
+		return "-" + humansize.Format(-delta)
+	}
+	return "+" + humansize.Format(delta)
+}
@@ -0,0 +1,237 @@
+// Package layercache provides a bounded on-disk cache of decompressed layers.
+//
+// Features that need random or repeated access to the uncompressed tar of (base) layers,
+// like squashing, extracting files from other images or diffing images,
+// can use the cache to avoid decompressing the same layer over and over again.
+// Entries are keyed by the diff_id of the layer (the digest of the uncompressed tar)
+// and verified against it when they are added.
+// The least recently used entries are evicted once the cache grows beyond its size limit.
+//
+// The cache is safe for concurrent use by multiple goroutines and multiple processes
+// sharing the same directory.
+package layercache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+)
+
+// DefaultMaxBytes is the size limit of the cache if none is configured.
+const DefaultMaxBytes int64 = 10 << 30
+
+// Cache is a directory of decompressed layers.
+// A nil *Cache is valid and decompresses layers on every access without caching them.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	group    singleflight.Group
+}
+
+// New returns a cache storing at most maxBytes of decompressed layers in dir.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid layer cache size %d", maxBytes)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0o755); err != nil {
+		return nil, fmt.Errorf("creating layer cache directory: %w", err)
+	}
+	return &Cache{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// FromEnv returns the cache configured by the environment.
+// IMG_LAYER_CACHE sets the directory of the cache and enables it.
+// IMG_LAYER_CACHE_MAX_BYTES optionally overrides the size limit (DefaultMaxBytes).
+// If IMG_LAYER_CACHE is unset, FromEnv returns a nil *Cache (caching is disabled).
+func FromEnv() (*Cache, error) {
+	dir := os.Getenv("IMG_LAYER_CACHE")
+	if dir == "" {
+		return nil, nil
+	}
+	maxBytes := DefaultMaxBytes
+	if value := os.Getenv("IMG_LAYER_CACHE_MAX_BYTES"); value != "" {
+		var err error
+		maxBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing IMG_LAYER_CACHE_MAX_BYTES: %w", err)
+		}
+	}
+	return New(dir, maxBytes)
+}
+
+// Opener opens the compressed (or uncompressed) blob of a layer.
+type Opener func() (io.ReadCloser, error)
+
+// Open returns the uncompressed tar of the layer with the given diff_id.
+// If the layer is not cached yet, it is decompressed from the blob returned by open
+// and verified against diffID before it is added to the cache.
+// Without a cache, the layer is streamed and verified when the end of the tar is read.
+func (c *Cache) Open(diffID string, open Opener) (io.ReadCloser, error) {
+	hexDigest, ok := strings.CutPrefix(diffID, "sha256:")
+	if !ok || len(hexDigest) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid diff_id %q", diffID)
+	}
+	if c == nil {
+		return openUncached(diffID, hexDigest, open)
+	}
+
+	entryPath := filepath.Join(c.dir, "sha256", hexDigest)
+	if f, err := c.openEntry(entryPath); err == nil {
+		return f, nil
+	}
+	// Only one goroutine decompresses a given layer. Others wait for the result.
+	if _, err, _ := c.group.Do(hexDigest, func() (any, error) {
+		return nil, c.add(diffID, hexDigest, entryPath, open)
+	}); err != nil {
+		return nil, err
+	}
+	f, err := c.openEntry(entryPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening cached layer %s: %w", diffID, err)
+	}
+	return f, nil
+}
+
+// openEntry opens a cached layer and marks it as recently used.
+// An open file stays readable even if the entry is evicted afterwards.
+func (c *Cache) openEntry(entryPath string) (*os.File, error) {
+	f, err := os.Open(entryPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_ = os.Chtimes(entryPath, now, now)
+	return f, nil
+}
+
+func (c *Cache) add(diffID, hexDigest, entryPath string, open Opener) error {
+	blob, err := open()
+	if err != nil {
+		return fmt.Errorf("opening layer %s: %w", diffID, err)
+	}
+	defer blob.Close()
+	r, err := fileopener.StreamCompressionReader(blob)
+	if err != nil {
+		return fmt.Errorf("decompressing layer %s: %w", diffID, err)
+	}
+
+	// Other processes may add the same layer concurrently.
+	// Every process writes its own temporary file and atomically renames it.
+	tmp, err := os.CreateTemp(c.dir, "tmp-"+hexDigest+"-")
+	if err != nil {
+		return fmt.Errorf("creating layer cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		return fmt.Errorf("decompressing layer %s: %w", diffID, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hexDigest {
+		return fmt.Errorf("diff_id mismatch for layer: expected %s, got sha256:%s", diffID, actual)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing layer cache entry: %w", err)
+	}
+	if err := c.evict(size, entryPath); err != nil {
+		return fmt.Errorf("evicting layer cache entries: %w", err)
+	}
+	return os.Rename(tmp.Name(), entryPath)
+}
+
+// evict removes the least recently used entries until an entry of the given size fits into the cache.
+// Entries that are still in use remain readable by their current readers.
+func (c *Cache) evict(incoming int64, keep string) error {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	total := incoming
+	err := filepath.WalkDir(filepath.Join(c.dir, "sha256"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || p == keep {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// evicted by another process
+			return nil
+		} else if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func openUncached(diffID, hexDigest string, open Opener) (io.ReadCloser, error) {
+	blob, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("opening layer %s: %w", diffID, err)
+	}
+	r, err := fileopener.StreamCompressionReader(blob)
+	if err != nil {
+		blob.Close()
+		return nil, fmt.Errorf("decompressing layer %s: %w", diffID, err)
+	}
+	return &verifyingReader{r: r, closer: blob, hasher: sha256.New(), diffID: diffID, hexDigest: hexDigest}, nil
+}
+
+// verifyingReader checks the digest of the data once all of it was read.
+type verifyingReader struct {
+	r         io.Reader
+	closer    io.Closer
+	hasher    hash.Hash
+	diffID    string
+	hexDigest string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hasher.Write(p[:n])
+	if err == io.EOF {
+		if actual := hex.EncodeToString(v.hasher.Sum(nil)); actual != v.hexDigest {
+			return n, fmt.Errorf("diff_id mismatch for layer: expected %s, got sha256:%s", v.diffID, actual)
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.closer.Close()
+}
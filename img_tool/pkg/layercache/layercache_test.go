@@ -0,0 +1,196 @@
+package layercache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLayer struct {
+	diffID     string
+	compressed []byte
+	data       []byte
+	opened     int
+}
+
+func newTestLayer(t *testing.T, content string, size int) *testLayer {
+	t.Helper()
+	data := bytes.Repeat([]byte(content), size/len(content))
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return &testLayer{diffID: "sha256:" + hex.EncodeToString(sum[:]), compressed: compressed.Bytes(), data: data}
+}
+
+func (l *testLayer) open() (io.ReadCloser, error) {
+	l.opened++
+	return io.NopCloser(bytes.NewReader(l.compressed)), nil
+}
+
+func readLayer(t *testing.T, c *Cache, l *testLayer) []byte {
+	t.Helper()
+	r, err := c.Open(l.diffID, l.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (l *testLayer) entry(c *Cache) string {
+	return filepath.Join(c.dir, "sha256", strings.TrimPrefix(l.diffID, "sha256:"))
+}
+
+func TestOpenCachesDecompressedLayer(t *testing.T) {
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := newTestLayer(t, "layer", 1000)
+	for range 2 {
+		if data := readLayer(t, c, layer); !bytes.Equal(data, layer.data) {
+			t.Errorf("Open() returned %d bytes that differ from the layer", len(data))
+		}
+	}
+	if layer.opened != 1 {
+		t.Errorf("blob was opened %d times, want 1", layer.opened)
+	}
+	if cached, err := os.ReadFile(layer.entry(c)); err != nil || !bytes.Equal(cached, layer.data) {
+		t.Errorf("cache entry is not the uncompressed layer: %v", err)
+	}
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	// room for two layers of 1000 bytes
+	c, err := New(t.TempDir(), 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestLayer(t, "a", 1000)
+	b := newTestLayer(t, "b", 1000)
+	readLayer(t, c, a)
+	readLayer(t, c, b)
+	old := time.Now().Add(-time.Hour)
+	for _, l := range []*testLayer{a, b} {
+		if err := os.Chtimes(l.entry(c), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// using a marks it as recently used, so b is evicted first
+	readLayer(t, c, a)
+	keepOpen, err := c.Open(b.diffID, b.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keepOpen.Close()
+	if err := os.Chtimes(b.entry(c), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	readLayer(t, c, newTestLayer(t, "c", 1000))
+	if _, err := os.Stat(b.entry(c)); !os.IsNotExist(err) {
+		t.Errorf("least recently used layer was not evicted: %v", err)
+	}
+	if _, err := os.Stat(a.entry(c)); err != nil {
+		t.Errorf("recently used layer was evicted: %v", err)
+	}
+	// readers of evicted entries keep working
+	if data, err := io.ReadAll(keepOpen); err != nil || !bytes.Equal(data, b.data) {
+		t.Errorf("reading an evicted entry: %v", err)
+	}
+	if a.opened != 1 || b.opened != 1 {
+		t.Errorf("blobs were opened %d and %d times, want 1", a.opened, b.opened)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := newTestLayer(t, "layer", 1000)
+
+	if _, err := c.Open("sha256:abc", layer.open); err == nil || err.Error() != `invalid diff_id "sha256:abc"` {
+		t.Errorf("Open() of invalid diff_id: error = %v", err)
+	}
+
+	other := newTestLayer(t, "other", 1000)
+	if _, err := c.Open(other.diffID, layer.open); err == nil || !strings.Contains(err.Error(), "diff_id mismatch for layer: expected "+other.diffID) {
+		t.Errorf("Open() of the wrong blob: error = %v", err)
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache directory contains temporary files after a failed add: %v", entries)
+	}
+	if _, err := os.Stat(other.entry(c)); !os.IsNotExist(err) {
+		t.Errorf("layer with mismatching diff_id was cached")
+	}
+
+	if _, err := New(t.TempDir(), 0); err == nil {
+		t.Errorf("New() accepted a size limit of 0")
+	}
+}
+
+func TestOpenUncached(t *testing.T) {
+	var c *Cache
+	layer := newTestLayer(t, "layer", 1000)
+	if data := readLayer(t, c, layer); !bytes.Equal(data, layer.data) {
+		t.Errorf("Open() without cache returned %d bytes that differ from the layer", len(data))
+	}
+
+	other := newTestLayer(t, "other", 1000)
+	r, err := c.Open(other.diffID, layer.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "diff_id mismatch") {
+		t.Errorf("reading the wrong blob without cache: error = %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("IMG_LAYER_CACHE", "")
+	if c, err := FromEnv(); c != nil || err != nil {
+		t.Errorf("FromEnv() without IMG_LAYER_CACHE = %v, %v, want no cache", c, err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("IMG_LAYER_CACHE", dir)
+	c, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.dir != dir || c.maxBytes != DefaultMaxBytes {
+		t.Errorf("FromEnv() = %s with %d bytes, want %s with the default size", c.dir, c.maxBytes, dir)
+	}
+
+	t.Setenv("IMG_LAYER_CACHE_MAX_BYTES", "1024")
+	if c, err := FromEnv(); err != nil || c.maxBytes != 1024 {
+		t.Errorf("FromEnv() with IMG_LAYER_CACHE_MAX_BYTES: %v", err)
+	}
+	t.Setenv("IMG_LAYER_CACHE_MAX_BYTES", "10GiB")
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), "parsing IMG_LAYER_CACHE_MAX_BYTES") {
+		t.Errorf("FromEnv() with invalid IMG_LAYER_CACHE_MAX_BYTES: error = %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/containerd"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/docker"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// checkCapabilities queries the load targets before any blobs are loaded
+// and prints a summary of their capabilities to stderr.
+// client is nil if no containerd socket is accessible.
+// It returns whether loads for Docker can be upgraded to direct containerd loads.
+func checkCapabilities(ctx context.Context, client *containerd.Client, needsDocker bool) (upgradeDocker bool, err error) {
+	if client != nil {
+		caps, err := client.Capabilities(ctx)
+		if err != nil {
+			return false, fmt.Errorf("containerd socket is accessible, but the daemon does not respond to API requests: %w", err)
+		}
+		slog.Info("Load target", "containerd", caps)
+	}
+	if !needsDocker {
+		return false, nil
+	}
+
+	caps, err := docker.ProbeCapabilities(ctx)
+	if err != nil {
+		if client != nil {
+			// Without the docker CLI, we cannot tell which image store Docker uses.
+			// Keep loading into containerd, which works for the containerd image store.
+			slog.Warn("Querying Docker failed, loading into containerd directly", logging.ErrKey, err)
+			return true, nil
+		}
+		return false, fmt.Errorf("docker is not available for loading images: %w", err)
+	}
+	slog.Info("Load target", "docker", caps)
+	if client != nil && !caps.ContainerdSnapshotter {
+		// Images in the "moby" namespace of containerd would be invisible to Docker.
+		slog.Info("Docker does not use the containerd image store, loading via 'docker load' instead of containerd")
+		return false, nil
+	}
+	return client != nil, nil
+}
@@ -0,0 +1,37 @@
+package load
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCapabilitiesWithoutContainerd(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	upgrade, err := checkCapabilities(context.Background(), nil, false)
+	if err != nil || upgrade {
+		t.Errorf("checkCapabilities() without docker loads = %v, %v, want no upgrade and no error", upgrade, err)
+	}
+
+	// without containerd, docker is required
+	_, err = checkCapabilities(context.Background(), nil, true)
+	if err == nil || !strings.Contains(err.Error(), "docker is not available for loading images") {
+		t.Errorf("checkCapabilities() without docker CLI: error = %v", err)
+	}
+
+	script := "#!/bin/sh\ncase \"$1\" in\n" +
+		"version) echo '{\"Server\":{\"Version\":\"27.1.1\",\"ApiVersion\":\"1.46\"}}' ;;\n" +
+		"system) echo '{\"Driver\":\"overlayfs\",\"DriverStatus\":[[\"driver-type\",\"io.containerd.snapshotter.v1\"]]}' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	upgrade, err = checkCapabilities(context.Background(), nil, true)
+	if err != nil || upgrade {
+		t.Errorf("checkCapabilities() with docker = %v, %v, want no upgrade without a containerd socket", upgrade, err)
+	}
+}
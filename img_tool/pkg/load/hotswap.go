@@ -0,0 +1,209 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	ocidigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/containerd"
+)
+
+// HotSwap configures the (experimental) hot-swapping of the rootfs of a running container
+// after its image was re-loaded into containerd.
+type HotSwap struct {
+	// ContainerID is the full ID of the container (the containerd container ID, which is the Docker container ID).
+	ContainerID string
+	// Signal is sent to the init process of the container after the layers were applied (0 sends no signal).
+	Signal uint32
+}
+
+// hotSwapContainer applies the layers of the loaded image that the running container doesn't have yet
+// on top of the writable snapshot of the container.
+//
+// The layers of the container are found by walking the parents of its snapshot, which are named by chain ID.
+// Only the layers after the longest common prefix with the new image are applied.
+// Files that were removed from the image are not removed from the container, and changes to the rootfs
+// made by the container itself are overwritten by files of the applied layers.
+func (l *loader) hotSwapContainer(ctx context.Context, client *containerd.Client, ops []api.IndexedLoadDeployOperation) error {
+	container, err := client.ContainerService().Get(ctx, l.hotSwap.ContainerID)
+	if err != nil {
+		return fmt.Errorf("getting container (use the full container ID): %w", err)
+	}
+	op, err := operationForImage(ops, container.Image)
+	if err != nil {
+		return err
+	}
+	manifestInfo := op.Manifests[0]
+	if op.RootKind == "index" {
+		manifestIndex, err := l.selectManifestForPlatform(op)
+		if err != nil {
+			return err
+		}
+		manifestInfo = op.Manifests[manifestIndex]
+	}
+	manifestDigest, err := registryv1.NewHash(manifestInfo.Descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	img, err := l.vfs.Image(manifestDigest)
+	if err != nil {
+		return err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("image %s has %d layers, but %d diff IDs", manifestDigest, len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	snapshots := client.SnapshotService()
+	containerLayers, err := snapshotChain(ctx, snapshots, container)
+	if err != nil {
+		return err
+	}
+	common := 0
+	for i, chainID := range chainIDs(config.RootFS.DiffIDs) {
+		if containerLayers[chainID.String()] {
+			common = i + 1
+		}
+	}
+	changed := manifest.Layers[common:]
+	slog.Info("Hot-swapping container", "container", shortID(container.ID), "image", container.Image, "changed-layers", fmt.Sprintf("%d/%d", len(changed), len(manifest.Layers)))
+	if len(changed) == 0 {
+		return nil
+	}
+	if replaced := len(containerLayers) - common; replaced > 0 {
+		slog.Warn("Applying new layers on top of outdated layers of the container. Files removed from the image are kept, recreate the container to get the exact image", "new", len(changed), "outdated", replaced)
+	}
+
+	mounts, err := snapshots.Mounts(ctx, container.Snapshotter, container.SnapshotKey)
+	if err != nil {
+		return fmt.Errorf("getting mounts of the container rootfs: %w", err)
+	}
+	mounts = upperDirMounts(mounts)
+
+	tasks := client.TaskService()
+	paused := true
+	if err := tasks.Pause(ctx, container.ID); err != nil {
+		if status.Code(err) != codes.NotFound {
+			return fmt.Errorf("pausing container: %w", err)
+		}
+		// the container is not running, so there is nothing to pause
+		paused = false
+	}
+	applyErr := applyLayers(ctx, client.DiffService(), changed, mounts)
+	if paused {
+		if err := tasks.Resume(ctx, container.ID); err != nil {
+			return fmt.Errorf("resuming container: %w", err)
+		}
+	}
+	if applyErr != nil {
+		return applyErr
+	}
+	if paused && l.hotSwap.Signal != 0 {
+		if err := tasks.Kill(ctx, container.ID, l.hotSwap.Signal); err != nil {
+			return fmt.Errorf("sending signal %d to container: %w", l.hotSwap.Signal, err)
+		}
+	}
+	return nil
+}
+
+func applyLayers(ctx context.Context, diffs containerd.DiffService, layers []registryv1.Descriptor, mounts []containerd.Mount) error {
+	for _, layer := range layers {
+		desc := ocispec.Descriptor{
+			MediaType: string(layer.MediaType),
+			Digest:    ocidigest.Digest(layer.Digest.String()),
+			Size:      layer.Size,
+		}
+		if _, err := diffs.Apply(ctx, desc, mounts); err != nil {
+			return fmt.Errorf("applying layer %s to container rootfs: %w", layer.Digest, err)
+		}
+		slog.Debug("Applied layer", "digest", layer.Digest)
+	}
+	return nil
+}
+
+// operationForImage returns the load operation that tags the image of the container.
+// If there is only a single load operation, it is used even if the tags differ.
+func operationForImage(ops []api.IndexedLoadDeployOperation, image string) (api.IndexedLoadDeployOperation, error) {
+	for _, op := range ops {
+		for _, tag := range normalizeDockerReferences(op.Tags) {
+			if tag == image {
+				return op, nil
+			}
+		}
+	}
+	if len(ops) == 1 {
+		return ops[0], nil
+	}
+	return api.IndexedLoadDeployOperation{}, fmt.Errorf("none of the loaded images is tagged as %s, the image of the container", image)
+}
+
+// snapshotChain returns the names of all committed snapshots below the active snapshot of the container.
+func snapshotChain(ctx context.Context, snapshots containerd.SnapshotService, container containerd.Container) (map[string]bool, error) {
+	active, err := snapshots.Stat(ctx, container.Snapshotter, container.SnapshotKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting snapshot of the container: %w", err)
+	}
+	chain := make(map[string]bool)
+	for name := active.Parent; name != ""; {
+		chain[name] = true
+		info, err := snapshots.Stat(ctx, container.Snapshotter, name)
+		if err != nil {
+			return nil, fmt.Errorf("getting parent snapshot %s: %w", name, err)
+		}
+		name = info.Parent
+	}
+	return chain, nil
+}
+
+// chainIDs returns the chain ID of every layer, which containerd uses as name of the unpacked snapshot.
+func chainIDs(diffIDs []registryv1.Hash) []ocidigest.Digest {
+	chain := make([]ocidigest.Digest, len(diffIDs))
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			chain[i] = ocidigest.Digest(diffID.String())
+			continue
+		}
+		chain[i] = ocidigest.FromString(chain[i-1].String() + " " + diffID.String())
+	}
+	return chain
+}
+
+// upperDirMounts replaces an overlay mount by a bind mount of its upper directory.
+// The overlay is already mounted by the running container and cannot be mounted a second time with the same work directory.
+func upperDirMounts(mounts []containerd.Mount) []containerd.Mount {
+	if len(mounts) != 1 || mounts[0].Type != "overlay" {
+		return mounts
+	}
+	for _, option := range mounts[0].Options {
+		if upperDir, ok := strings.CutPrefix(option, "upperdir="); ok {
+			return []containerd.Mount{{
+				Type:    "bind",
+				Source:  upperDir,
+				Options: []string{"rbind", "rw"},
+			}}
+		}
+	}
+	return mounts
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,151 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/containerd"
+)
+
+func TestChainIDs(t *testing.T) {
+	var diffIDs []registryv1.Hash
+	for _, c := range []string{"a", "b", "c"} {
+		diffIDs = append(diffIDs, registryv1.Hash{Algorithm: "sha256", Hex: strings.Repeat(c, 64)})
+	}
+	var got []string
+	for _, chainID := range chainIDs(diffIDs) {
+		got = append(got, chainID.String())
+	}
+	want := []string{
+		"sha256:" + strings.Repeat("a", 64),
+		"sha256:ccd722928bd92476ba1745586fed6e45a102504185ad88cd89e01ff116fd146c",
+		"sha256:c1377126441fb2f5ec2c21ae2a60255331d639e830f0ee1b40a36e52d4c40588",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chainIDs() = %q, want %q", got, want)
+	}
+}
+
+// fakeSnapshots maps snapshot names to their parents.
+type fakeSnapshots map[string]string
+
+func (s fakeSnapshots) Stat(_ context.Context, snapshotter, key string) (containerd.SnapshotInfo, error) {
+	parent, ok := s[key]
+	if !ok || snapshotter != "overlayfs" {
+		return containerd.SnapshotInfo{}, errors.New("snapshot not found")
+	}
+	return containerd.SnapshotInfo{Name: key, Parent: parent}, nil
+}
+
+func (s fakeSnapshots) Mounts(context.Context, string, string) ([]containerd.Mount, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestSnapshotChain(t *testing.T) {
+	container := containerd.Container{ID: "app", Snapshotter: "overlayfs", SnapshotKey: "app"}
+	snapshots := fakeSnapshots{"app": "layer2", "layer2": "layer1", "layer1": ""}
+	chain, err := snapshotChain(context.Background(), snapshots, container)
+	if err != nil {
+		t.Fatalf("snapshotChain() error = %v", err)
+	}
+	if want := map[string]bool{"layer1": true, "layer2": true}; !reflect.DeepEqual(chain, want) {
+		t.Errorf("snapshotChain() = %v, want %v", chain, want)
+	}
+
+	delete(snapshots, "layer1")
+	if _, err := snapshotChain(context.Background(), snapshots, container); err == nil || !strings.Contains(err.Error(), "getting parent snapshot layer1") {
+		t.Errorf("snapshotChain() error = %v, want an error about the missing parent", err)
+	}
+	container.SnapshotKey = "other"
+	if _, err := snapshotChain(context.Background(), snapshots, container); err == nil || !strings.Contains(err.Error(), "getting snapshot of the container") {
+		t.Errorf("snapshotChain() error = %v, want an error about the container snapshot", err)
+	}
+}
+
+func TestOperationForImage(t *testing.T) {
+	app := api.IndexedLoadDeployOperation{I: 0, LoadDeployOperation: api.LoadDeployOperation{Tags: []string{"my/app:dev"}}}
+	tool := api.IndexedLoadDeployOperation{I: 1, LoadDeployOperation: api.LoadDeployOperation{Tags: []string{"tool"}}}
+
+	op, err := operationForImage([]api.IndexedLoadDeployOperation{tool, app}, "docker.io/my/app:dev")
+	if err != nil || op.I != 0 {
+		t.Errorf("operationForImage() = operation %d (%v), want the operation tagging the image", op.I, err)
+	}
+	// a single operation is used even if the tags differ
+	op, err = operationForImage([]api.IndexedLoadDeployOperation{tool}, "docker.io/my/app:dev")
+	if err != nil || op.I != 1 {
+		t.Errorf("operationForImage() = operation %d (%v), want the only operation", op.I, err)
+	}
+	if _, err := operationForImage([]api.IndexedLoadDeployOperation{tool, app}, "docker.io/other:latest"); err == nil || !strings.Contains(err.Error(), "none of the loaded images is tagged as docker.io/other:latest") {
+		t.Errorf("operationForImage() error = %v, want an error about the image", err)
+	}
+}
+
+func TestUpperDirMounts(t *testing.T) {
+	overlay := containerd.Mount{Type: "overlay", Source: "overlay", Options: []string{"lowerdir=/l1:/l2", "upperdir=/snapshots/5/fs", "workdir=/snapshots/5/work"}}
+	bind := containerd.Mount{Type: "bind", Source: "/snapshots/1/fs", Options: []string{"rbind", "rw"}}
+
+	tests := []struct {
+		name   string
+		mounts []containerd.Mount
+		want   []containerd.Mount
+	}{
+		{"overlay", []containerd.Mount{overlay}, []containerd.Mount{{Type: "bind", Source: "/snapshots/5/fs", Options: []string{"rbind", "rw"}}}},
+		{"bind", []containerd.Mount{bind}, []containerd.Mount{bind}},
+		{"overlay without upper directory", []containerd.Mount{{Type: "overlay", Options: []string{"lowerdir=/l1"}}}, []containerd.Mount{{Type: "overlay", Options: []string{"lowerdir=/l1"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upperDirMounts(tt.mounts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("upperDirMounts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDiffs records the applied layers and fails for the layer with digest fail.
+type fakeDiffs struct {
+	applied []string
+	fail    string
+}
+
+func (d *fakeDiffs) Apply(_ context.Context, layer ocispec.Descriptor, _ []containerd.Mount) (ocispec.Descriptor, error) {
+	if layer.Digest.String() == d.fail {
+		return ocispec.Descriptor{}, errors.New("unpacking failed")
+	}
+	d.applied = append(d.applied, layer.Digest.String())
+	return ocispec.Descriptor{}, nil
+}
+
+func TestApplyLayers(t *testing.T) {
+	var layers []registryv1.Descriptor
+	var digests []string
+	for _, c := range []string{"a", "b", "c"} {
+		digest := registryv1.Hash{Algorithm: "sha256", Hex: strings.Repeat(c, 64)}
+		layers = append(layers, registryv1.Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digest, Size: 10})
+		digests = append(digests, digest.String())
+	}
+
+	diffs := &fakeDiffs{}
+	if err := applyLayers(context.Background(), diffs, layers, nil); err != nil {
+		t.Fatalf("applyLayers() error = %v", err)
+	}
+	if !reflect.DeepEqual(diffs.applied, digests) {
+		t.Errorf("applied layers = %q, want %q in order", diffs.applied, digests)
+	}
+
+	diffs = &fakeDiffs{fail: digests[1]}
+	err := applyLayers(context.Background(), diffs, layers, nil)
+	if err == nil || !strings.Contains(err.Error(), "applying layer "+digests[1]+" to container rootfs: unpacking failed") {
+		t.Errorf("applyLayers() error = %v, want an error about the second layer", err)
+	}
+	if !reflect.DeepEqual(diffs.applied, digests[:1]) {
+		t.Errorf("applied layers = %q, want to stop at the failing layer", diffs.applied)
+	}
+}
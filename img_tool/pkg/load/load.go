@@ -60,12 +60,12 @@ type indexLoader struct {
 	platforms []string
 }
 
-func ConnectToContainerd(ctx context.Context) (*containerd.Client, error) {
+func ConnectToContainerd(ctx context.Context, opts ...containerd.ClientOption) (*containerd.Client, error) {
 	address, err := containerd.FindContainerdSocket()
 	if err != nil {
 		return nil, fmt.Errorf("finding containerd socket: %w", err)
 	}
-	return containerd.New(address)
+	return containerd.New(address, opts...)
 }
 
 func computeManifestGCLabels(manifest *registryv1.Manifest) map[string]string {
@@ -249,6 +249,17 @@ func NormalizeDockerReference(ref string) string {
 	return name
 }
 
+// normalizeDockerReferences normalizes all non-empty references.
+func normalizeDockerReferences(refs []string) []string {
+	normalized := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref != "" {
+			normalized = append(normalized, NormalizeDockerReference(ref))
+		}
+	}
+	return normalized
+}
+
 // parsePlatform parses a platform string like "linux/amd64" into an OCI Platform
 func parsePlatform(platform string) (registryv1.Platform, error) {
 	parts := strings.Split(platform, "/")
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
 	"slices"
 	"time"
 
@@ -15,11 +15,16 @@ import (
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/containerd"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/docker"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 type builder struct {
 	vfs       vfs
 	platforms []string
+	clock     hermetic.Clock
+	ids       hermetic.IDSource
+	hotSwap   HotSwap
 }
 
 func NewBuilder(vfs vfs) *builder {
@@ -31,10 +36,31 @@ func (b *builder) WithPlatforms(platforms []string) *builder {
 	return b
 }
 
+// WithClock sets the clock used to compute the expiry of containerd leases.
+func (b *builder) WithClock(clock hermetic.Clock) *builder {
+	b.clock = clock
+	return b
+}
+
+// WithIDSource sets the source of containerd lease IDs and content write refs.
+func (b *builder) WithIDSource(ids hermetic.IDSource) *builder {
+	b.ids = ids
+	return b
+}
+
+// WithHotSwap applies the changed layers of the loaded image to the rootfs of a running container (experimental).
+func (b *builder) WithHotSwap(hotSwap HotSwap) *builder {
+	b.hotSwap = hotSwap
+	return b
+}
+
 func (b *builder) Build() *loader {
 	return &loader{
 		vfs:       b.vfs,
 		platforms: b.platforms,
+		clock:     hermetic.ClockOrSystem(b.clock),
+		ids:       hermetic.IDsOrRandom(b.ids),
+		hotSwap:   b.hotSwap,
 		taskSet:   newTaskSet(b.vfs),
 	}
 }
@@ -42,6 +68,9 @@ func (b *builder) Build() *loader {
 type loader struct {
 	vfs             vfs
 	platforms       []string
+	clock           hermetic.Clock
+	ids             hermetic.IDSource
+	hotSwap         HotSwap
 	taskSet         *taskSet
 	clientConn      *containerd.Client
 	triedContainerd bool
@@ -58,8 +87,17 @@ func (l *loader) LoadAll(ctx context.Context, ops []api.IndexedLoadDeployOperati
 		defer client.Close()
 	}
 
+	// diagnose the load targets before doing any work
+	needsDocker := slices.ContainsFunc(ops, func(op api.IndexedLoadDeployOperation) bool {
+		return op.Daemon == "docker"
+	})
+	upgradeDocker, err := checkCapabilities(ctx, l.clientConn, needsDocker)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, op := range ops {
-		if l.haveContainerd && op.Daemon == "docker" {
+		if upgradeDocker && op.Daemon == "docker" {
 			// upgrade docker loads to containerd loads if possible
 			op.Daemon = "containerd"
 		}
@@ -82,7 +120,7 @@ func (l *loader) LoadAll(ctx context.Context, ops []api.IndexedLoadDeployOperati
 
 			lease, err := leaseService.Create(ctx, map[string]string{
 				// max age of the lease
-				"containerd.io/gc.expire": time.Now().Add(1 * time.Hour).Format(time.RFC3339),
+				"containerd.io/gc.expire": l.clock.Now().Add(1 * time.Hour).Format(time.RFC3339),
 			})
 			if err != nil {
 				return nil, fmt.Errorf("creating lease: %w", err)
@@ -100,15 +138,23 @@ func (l *loader) LoadAll(ctx context.Context, ops []api.IndexedLoadDeployOperati
 				if err := l.loadContainerd(ctx, op); err != nil {
 					return nil, fmt.Errorf("loading image via containerd: %w", err)
 				}
-				pushedTags = append(pushedTags, NormalizeDockerReference(op.Tag))
+				pushedTags = append(pushedTags, normalizeDockerReferences(op.Tags)...)
+			}
+			if l.hotSwap.ContainerID != "" {
+				if err := l.hotSwapContainer(ctx, client, ops); err != nil {
+					return nil, fmt.Errorf("hot-swapping container %s: %w", l.hotSwap.ContainerID, err)
+				}
 			}
 		case "docker":
+			if l.hotSwap.ContainerID != "" {
+				return nil, fmt.Errorf("hot-swapping a container requires loading into containerd (Docker with the containerd image store)")
+			}
 			// Load all images via docker load
 			for _, op := range ops {
 				if err := l.loadViaDocker(ctx, op); err != nil {
 					return nil, fmt.Errorf("loading image via docker: %w", err)
 				}
-				pushedTags = append(pushedTags, NormalizeDockerReference(op.Tag))
+				pushedTags = append(pushedTags, normalizeDockerReferences(op.Tags)...)
 			}
 		default:
 			return nil, fmt.Errorf("unsupported daemon: %s", daemon)
@@ -138,20 +184,22 @@ func (l *loader) loadContainerd(ctx context.Context, op api.IndexedLoadDeployOpe
 		Digest:    ociDigest,
 		Size:      op.Root.Size,
 	}
-	normalizedTag := NormalizeDockerReference(op.Tag)
-	img := containerd.Image{
-		Name:   normalizedTag,
-		Target: target,
-	}
-	_, err = imageService.Create(ctx, img)
-	if err != nil && containerd.IsAlreadyExists(err) {
-		_, err = imageService.Update(ctx, img)
-	}
-	if err != nil {
-		return fmt.Errorf("creating/updating image: %w", err)
-	}
+	// every tag is a separate image record pointing to the same target
+	for _, normalizedTag := range normalizeDockerReferences(op.Tags) {
+		img := containerd.Image{
+			Name:   normalizedTag,
+			Target: target,
+		}
+		_, err = imageService.Create(ctx, img)
+		if err != nil && containerd.IsAlreadyExists(err) {
+			_, err = imageService.Update(ctx, img)
+		}
+		if err != nil {
+			return fmt.Errorf("creating/updating image %s: %w", normalizedTag, err)
+		}
 
-	fmt.Printf("%s@%s\n", normalizedTag, target.Digest)
+		fmt.Printf("%s@%s\n", normalizedTag, target.Digest)
+	}
 	return nil
 }
 
@@ -190,9 +238,9 @@ func (l *loader) streamDockerTar(ctx context.Context, op api.IndexedLoadDeployOp
 		if err != nil {
 			return err
 		}
-		return l.streamManifestToTar(ctx, op.Manifests[manifestIndex], op.Tag, tw)
+		return l.streamManifestToTar(ctx, op.Manifests[manifestIndex], op.Tags, tw)
 	} else if op.RootKind == "manifest" && len(op.Manifests) == 1 {
-		return l.streamManifestToTar(ctx, op.Manifests[0], op.Tag, tw)
+		return l.streamManifestToTar(ctx, op.Manifests[0], op.Tags, tw)
 	}
 
 	return fmt.Errorf("no manifest or index provided")
@@ -238,7 +286,7 @@ func (l *loader) selectManifestForPlatform(op api.IndexedLoadDeployOperation) (i
 	return 0, fmt.Errorf("no manifest found for platform(s): %v", platforms)
 }
 
-func (l *loader) streamManifestToTar(ctx context.Context, manifestInfo api.ManifestDeployInfo, tag string, tw *docker.TarWriter) error {
+func (l *loader) streamManifestToTar(ctx context.Context, manifestInfo api.ManifestDeployInfo, tags []string, tw *docker.TarWriter) error {
 	// Load config
 	digest, err := registryv1.NewHash(manifestInfo.Descriptor.Digest)
 	if err != nil {
@@ -259,8 +307,9 @@ func (l *loader) streamManifestToTar(ctx context.Context, manifestInfo api.Manif
 	}
 
 	// Set tags
-	if tag != "" {
-		tw.SetTags([]string{NormalizeDockerReference(tag)})
+	normalizedTags := normalizeDockerReferences(tags)
+	if len(normalizedTags) > 0 {
+		tw.SetTags(normalizedTags)
 	}
 
 	// Stream layers
@@ -273,9 +322,9 @@ func (l *loader) streamManifestToTar(ctx context.Context, manifestInfo api.Manif
 		return fmt.Errorf("finalizing tar: %w", err)
 	}
 
-	// Print the tag
-	if tag != "" {
-		fmt.Println(NormalizeDockerReference(tag))
+	// Print the tags
+	for _, tag := range normalizedTags {
+		fmt.Println(tag)
 	}
 	return nil
 }
@@ -294,11 +343,16 @@ func (l *loader) streamLayers(ctx context.Context, manifestInfo api.ManifestDepl
 		if err != nil {
 			return err
 		}
-		defer rc.Close()
-
-		if err := tw.WriteLayer(digest, layerDesc.Size, rc); err != nil {
+		// close each reader as soon as the layer is written,
+		// so that at most one layer is open at a time
+		err = tw.WriteLayer(digest, layerDesc.Size, rc)
+		closeErr := rc.Close()
+		if err != nil {
 			return err
 		}
+		if closeErr != nil {
+			return fmt.Errorf("closing layer %s: %w", digest, closeErr)
+		}
 	}
 	return nil
 }
@@ -310,21 +364,14 @@ func (l *loader) connect(ctx context.Context, daemon string) (*containerd.Client
 	if l.triedContainerd {
 		return nil, fmt.Errorf("containerd connection previously failed")
 	}
-	client, err := ConnectToContainerd(ctx)
+	client, err := ConnectToContainerd(ctx, containerd.WithIDSource(l.ids))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Connecting to containerd failed: %v\n", err)
-		// Print warning about performance impact and digest differences
-		fmt.Fprintln(os.Stderr, "\n\033[33mWARNING: Docker is not using containerd storage backend.\033[0m")
-		fmt.Fprintln(os.Stderr, "This will use 'docker load' which is significantly slower than direct containerd loading.")
-		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "\033[33mIMPORTANT: The digest of the image will be different due to the use of 'docker load'.\033[0m")
-		fmt.Fprintln(os.Stderr, "Docker load creates a custom Docker manifest that doesn't adhere to OCI spec.")
-		fmt.Fprintln(os.Stderr, "If you can load into the containerd backend, you can load the exact OCI image with the expected digest.")
-		fmt.Fprintln(os.Stderr, "See: https://github.com/bazel-contrib/rules_img/issues/76")
-		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "To improve performance, configure Docker to use containerd:")
-		fmt.Fprintln(os.Stderr, "  https://docs.docker.com/storage/containerd/")
-		fmt.Fprintln(os.Stderr, "")
+		// Warn about the performance impact and digest differences of 'docker load'.
+		slog.Warn("Connecting to containerd failed, falling back to 'docker load'. "+
+			"This is significantly slower than loading into containerd, and the digest of the image will be different: "+
+			"'docker load' creates a custom Docker manifest that doesn't adhere to the OCI spec. "+
+			"To load the exact OCI image faster, configure Docker to use containerd (https://docs.docker.com/storage/containerd/, "+
+			"see https://github.com/bazel-contrib/rules_img/issues/76)", logging.ErrKey, err)
 		l.haveContainerd = false
 		l.triedContainerd = true
 		return nil, fmt.Errorf("connecting to containerd: %w", err)
@@ -470,6 +517,10 @@ func (ts *taskSet) collectBlobsForManifest(imageDigest registryv1.Hash) ([]blobW
 	}
 
 	for _, entry := range imageManifest.Layers {
+		if !entry.MediaType.IsDistributable() {
+			// Like containerd, we don't fetch foreign layers (like Windows base layers).
+			continue
+		}
 		if err := handleLayer(entry); err != nil {
 			return nil, err
 		}
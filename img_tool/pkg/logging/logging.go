@@ -0,0 +1,168 @@
+// Package logging configures the structured logger shared by all commands of img and the servers.
+//
+// Code logs with the default logger of log/slog (slog.Info, slog.Warn, ...), passing errors
+// as the ErrKey attribute. Init installs the logger, and RegisterFlags lets every command
+// choose the level (--log-level) and format (--log-format) of its output.
+// The defaults come from IMG_LOG_LEVEL and IMG_LOG_FORMAT, which can be passed to build actions
+// with --action_env. Messages of the log package are redirected to the logger, too.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables that provide the defaults of --log-level and --log-format.
+const (
+	levelEnv  = "IMG_LOG_LEVEL"
+	formatEnv = "IMG_LOG_FORMAT"
+)
+
+// Formats of the log output.
+const (
+	// FormatText writes one line per message, like "Warning: message key=value: error".
+	// It is meant to be read by humans (in the output of Bazel actions or a terminal).
+	FormatText = "text"
+	// FormatJSON writes one JSON object per message, meant to be read by log collectors.
+	FormatJSON = "json"
+)
+
+// LevelTrace is below slog.LevelDebug and enables the most detailed messages, like every request to a registry.
+const LevelTrace = slog.LevelDebug - 4
+
+// ErrKey is the attribute key of errors, like in slog.Error("Pushing failed", logging.ErrKey, err).
+// The text format appends errors to the message.
+const ErrKey = "err"
+
+var (
+	mu         sync.Mutex
+	level      = new(slog.LevelVar)
+	format     = FormatText
+	timestamps bool
+)
+
+type option func()
+
+// WithTimestamps prefixes every message of the text format with the time (servers want this, build actions don't).
+func WithTimestamps() option {
+	return func() { timestamps = true }
+}
+
+// Init installs the logger as the default logger of slog and the log package.
+// The level and format are read from IMG_LOG_LEVEL and IMG_LOG_FORMAT (info and text if unset).
+// Invalid values are reported and ignored.
+func Init(opts ...option) {
+	mu.Lock()
+	for _, opt := range opts {
+		opt()
+	}
+	var errs []error
+	if value := os.Getenv(levelEnv); value != "" {
+		if err := setLevel(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", levelEnv, err))
+		}
+	}
+	if value := os.Getenv(formatEnv); value != "" {
+		if err := setFormat(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", formatEnv, err))
+		}
+	}
+	install()
+	mu.Unlock()
+	for _, err := range errs {
+		slog.Warn("Ignoring invalid logging configuration", ErrKey, err)
+	}
+}
+
+// RegisterFlags adds --log-level and --log-format to the flag set.
+// The logger is reconfigured as soon as the flags are parsed.
+func RegisterFlags(flagSet *flag.FlagSet) {
+	flagSet.Var(levelFlag{}, "log-level", fmt.Sprintf(`Minimum level of log messages: "trace", "debug", "info", "warn" or "error" (env: %s, default: info)`, levelEnv))
+	flagSet.Var(formatFlag{}, "log-format", fmt.Sprintf(`Format of log messages: "text" or "json" (env: %s, default: text)`, formatEnv))
+}
+
+// Fatal logs msg at error level and exits with status 1.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func setLevel(value string) error {
+	if strings.EqualFold(value, "trace") {
+		level.Set(LevelTrace)
+		return nil
+	}
+	return level.UnmarshalText([]byte(value))
+}
+
+func levelName(l slog.Level) string {
+	if l == LevelTrace {
+		return "trace"
+	}
+	return strings.ToLower(l.String())
+}
+
+func setFormat(value string) error {
+	switch value {
+	case FormatText, FormatJSON:
+		format = value
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (expected %q or %q)", value, FormatText, FormatJSON)
+}
+
+// install replaces the default logger. The caller must hold mu.
+func install() {
+	var handler slog.Handler
+	switch format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel})
+	default:
+		handler = newTextHandler(os.Stderr, level, timestamps)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// replaceLevel names LevelTrace "TRACE" in the JSON format (instead of "DEBUG-4").
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.LevelKey {
+		if l, ok := a.Value.Any().(slog.Level); ok && l == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+type levelFlag struct{}
+
+func (levelFlag) String() string {
+	return levelName(level.Level())
+}
+
+func (levelFlag) Set(value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return setLevel(value)
+}
+
+type formatFlag struct{}
+
+func (formatFlag) String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return format
+}
+
+func (formatFlag) Set(value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := setFormat(value); err != nil {
+		return err
+	}
+	install()
+	return nil
+}
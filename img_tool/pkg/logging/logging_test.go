@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLevelFlag(t *testing.T) {
+	t.Cleanup(func() { level.Set(slog.LevelInfo) })
+	tests := []struct {
+		value   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{value: "trace", want: LevelTrace},
+		{value: "TRACE", want: LevelTrace},
+		{value: "debug", want: slog.LevelDebug},
+		{value: "warn", want: slog.LevelWarn},
+		{value: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			level.Set(slog.LevelInfo)
+			err := levelFlag{}.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := level.Level(); got != tt.want {
+				t.Errorf("level = %v, want %v", got, tt.want)
+			}
+			if got, want := (levelFlag{}).String(), levelName(tt.want); got != want {
+				t.Errorf("String() = %q, want %q", got, want)
+			}
+		})
+	}
+	level.Set(LevelTrace)
+	if got := (levelFlag{}).String(); got != "trace" {
+		t.Errorf("String() = %q, want %q", got, "trace")
+	}
+}
+
+func TestTextHandlerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newTextHandler(&buf, LevelTrace, false))
+	logger.Log(context.Background(), LevelTrace, "request")
+	logger.Debug("details")
+	logger.Info("done")
+	logger.Warn("careful")
+	want := "Trace: request\nDebug: details\ndone\nWarning: careful\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	slog.New(newTextHandler(&buf, slog.LevelDebug, false)).Log(context.Background(), LevelTrace, "request")
+	if buf.Len() != 0 {
+		t.Errorf("output below level = %q, want nothing", buf.String())
+	}
+}
+
+func TestReplaceLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace, ReplaceAttr: replaceLevel}))
+	logger.Log(context.Background(), LevelTrace, "request")
+	logger.Debug("details")
+	if got := buf.String(); !strings.Contains(got, `"level":"TRACE"`) || !strings.Contains(got, `"level":"DEBUG"`) {
+		t.Errorf("output = %q, want levels TRACE and DEBUG", got)
+	}
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// textHandler writes messages as single lines of the form
+//
+//	[time] [Level: ]message key=value...[: error]
+//
+// Info messages have no level prefix, so that the output of commands reads like before structured logging.
+// Errors (attributes with ErrKey) are appended last, since they may span multiple lines (like registry.Diagnose).
+type textHandler struct {
+	mu         *sync.Mutex
+	w          io.Writer
+	level      slog.Leveler
+	timestamps bool
+	// attrs and errs are the preformatted attributes of WithAttrs.
+	attrs string
+	errs  []string
+	// group is the prefix of attribute keys added by WithGroup.
+	group string
+}
+
+func newTextHandler(w io.Writer, level slog.Leveler, timestamps bool) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, w: w, level: level, timestamps: timestamps}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.timestamps && !r.Time.IsZero() {
+		b.WriteString(r.Time.Format(time.RFC3339))
+		b.WriteByte(' ')
+	}
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelDebug:
+		b.WriteString("Trace: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("Debug: ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	errs := h.errs
+	r.Attrs(func(a slog.Attr) bool {
+		errs = appendAttr(&b, errs, h.group, a)
+		return true
+	})
+	for _, err := range errs {
+		b.WriteString(": ")
+		b.WriteString(err)
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	clone.errs = append([]string(nil), h.errs...)
+	for _, a := range attrs {
+		clone.errs = appendAttr(&b, clone.errs, h.group, a)
+	}
+	clone.attrs = b.String()
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// appendAttr writes " key=value" to b, or appends the value to errs if the attribute is an error.
+func appendAttr(b *strings.Builder, errs []string, group string, a slog.Attr) []string {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return errs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			errs = appendAttr(b, errs, prefix, member)
+		}
+		return errs
+	}
+	if a.Key == ErrKey && group == "" {
+		return append(errs, a.Value.String())
+	}
+	b.WriteByte(' ')
+	b.WriteString(group)
+	b.WriteString(a.Key)
+	b.WriteByte('=')
+	b.WriteString(quoteIfNeeded(a.Value.String()))
+	return errs
+}
+
+func quoteIfNeeded(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
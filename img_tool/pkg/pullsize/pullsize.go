@@ -0,0 +1,164 @@
+// Package pullsize estimates how many bytes a node has to pull to deploy a new release,
+// given the blobs it already pulled for the previous release.
+package pullsize
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// Estimate is the estimated pull size of all images of a deploy manifest.
+type Estimate struct {
+	Images []ImageEstimate `json:"images"`
+	// TotalSize is the size of all distinct blobs of the new release.
+	TotalSize int64 `json:"total_size"`
+	// PullSize is the size of all distinct blobs that are not part of the previous release.
+	PullSize int64 `json:"pull_size"`
+}
+
+// ImageEstimate is the estimated pull size of a single push or load operation.
+type ImageEstimate struct {
+	// Name is the registry and repository of a push, or the first tag of a load.
+	Name string `json:"name"`
+	// Root is the digest of the pushed or loaded manifest or index.
+	Root      string             `json:"root"`
+	Manifests []ManifestEstimate `json:"manifests"`
+	TotalSize int64              `json:"total_size"`
+	PullSize  int64              `json:"pull_size"`
+}
+
+// ManifestEstimate is the estimated pull size of a single platform of an image.
+// A node only pulls the manifest of its own platform.
+type ManifestEstimate struct {
+	Digest       string `json:"digest"`
+	Layers       int    `json:"layers"`
+	CachedLayers int    `json:"cached_layers"`
+	// TotalSize is the size of the manifest, its config, and all layers.
+	TotalSize int64 `json:"total_size"`
+	// PullSize is the size of the manifest, its config, and the layers that are not cached.
+	PullSize  int64            `json:"pull_size"`
+	NewLayers []api.Descriptor `json:"new_layers,omitempty"`
+}
+
+// Calculate estimates the pull size of the new deploy manifest for a node
+// that has pulled all blobs of the previous deploy manifest.
+// Blobs are compared across all operations and platforms, since layers are cached by digest.
+func Calculate(previous, current api.DeployManifest) (Estimate, error) {
+	previousOperations, err := operations(previous)
+	if err != nil {
+		return Estimate{}, fmt.Errorf("reading previous deploy manifest: %w", err)
+	}
+	currentOperations, err := operations(current)
+	if err != nil {
+		return Estimate{}, fmt.Errorf("reading current deploy manifest: %w", err)
+	}
+
+	cached := make(map[string]bool)
+	for _, op := range previousOperations {
+		for _, blob := range blobs(op.BaseCommandOperation) {
+			cached[blob.Digest] = true
+		}
+	}
+
+	var estimate Estimate
+	counted := make(map[string]bool)
+	for _, op := range currentOperations {
+		image := ImageEstimate{Name: op.name, Root: op.Root.Digest}
+		if op.RootKind == "index" {
+			image.TotalSize += op.Root.Size
+			if !cached[op.Root.Digest] {
+				image.PullSize += op.Root.Size
+			}
+		}
+		for _, manifest := range op.Manifests {
+			manifestEstimate := ManifestEstimate{Digest: manifest.Descriptor.Digest, Layers: len(manifest.LayerBlobs)}
+			for _, blob := range []api.Descriptor{manifest.Descriptor, manifest.Config} {
+				manifestEstimate.TotalSize += blob.Size
+				if !cached[blob.Digest] {
+					manifestEstimate.PullSize += blob.Size
+				}
+			}
+			for _, layer := range manifest.LayerBlobs {
+				manifestEstimate.TotalSize += layer.Size
+				if cached[layer.Digest] {
+					manifestEstimate.CachedLayers++
+					continue
+				}
+				manifestEstimate.PullSize += layer.Size
+				manifestEstimate.NewLayers = append(manifestEstimate.NewLayers, layer)
+			}
+			image.TotalSize += manifestEstimate.TotalSize
+			image.PullSize += manifestEstimate.PullSize
+			image.Manifests = append(image.Manifests, manifestEstimate)
+		}
+		for _, blob := range blobs(op.BaseCommandOperation) {
+			if counted[blob.Digest] {
+				continue
+			}
+			counted[blob.Digest] = true
+			estimate.TotalSize += blob.Size
+			if !cached[blob.Digest] {
+				estimate.PullSize += blob.Size
+			}
+		}
+		estimate.Images = append(estimate.Images, image)
+	}
+	return estimate, nil
+}
+
+type operation struct {
+	api.BaseCommandOperation
+	name string
+}
+
+// operations returns the push and load operations of a deploy manifest in their original order.
+func operations(manifest api.DeployManifest) ([]operation, error) {
+	pushOperations, err := manifest.PushOperations()
+	if err != nil {
+		return nil, err
+	}
+	loadOperations, err := manifest.LoadOperations()
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]operation, len(manifest.Operations))
+	for _, op := range pushOperations {
+		ops[op.I] = operation{
+			BaseCommandOperation: op.BaseCommandOperation,
+			name:                 fmt.Sprintf("%s/%s", op.Registry, op.Repository),
+		}
+	}
+	for _, op := range loadOperations {
+		name := "load"
+		if len(op.Tags) > 0 {
+			name = op.Tags[0]
+		}
+		ops[op.I] = operation{BaseCommandOperation: op.BaseCommandOperation, name: name}
+	}
+	// drop operations of unknown commands
+	return slices.DeleteFunc(ops, func(op operation) bool { return op.Command == "" }), nil
+}
+
+// blobs returns all blobs a node pulls for the operation.
+func blobs(op api.BaseCommandOperation) []api.Descriptor {
+	var result []api.Descriptor
+	if op.RootKind == "index" {
+		result = append(result, op.Root)
+	}
+	for _, manifest := range op.Manifests {
+		result = append(result, manifest.Descriptor, manifest.Config)
+		result = append(result, manifest.LayerBlobs...)
+	}
+	return result
+}
+
+func shortDigest(digest string) string {
+	_, hex, ok := strings.Cut(digest, ":")
+	if !ok || len(hex) < 12 {
+		return digest
+	}
+	return hex[:12]
+}
@@ -0,0 +1,143 @@
+package pullsize
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func blob(name string, size int64) api.Descriptor {
+	return api.Descriptor{Digest: "sha256:" + strings.Repeat(name, 64/len(name)), Size: size}
+}
+
+func deployManifest(t *testing.T, operations ...any) api.DeployManifest {
+	t.Helper()
+	var manifest api.DeployManifest
+	for _, op := range operations {
+		raw, err := json.Marshal(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Operations = append(manifest.Operations, raw)
+	}
+	return manifest
+}
+
+func push(rootKind string, root api.Descriptor, manifests ...api.ManifestDeployInfo) api.PushDeployOperation {
+	return api.PushDeployOperation{
+		BaseCommandOperation: api.BaseCommandOperation{Command: "push", RootKind: rootKind, Root: root, Manifests: manifests},
+		PushTarget:           api.PushTarget{Registry: "registry.example.com", Repository: "app"},
+	}
+}
+
+func manifest(descriptor, config api.Descriptor, layers ...api.Descriptor) api.ManifestDeployInfo {
+	return api.ManifestDeployInfo{Descriptor: descriptor, Config: config, LayerBlobs: layers}
+}
+
+func TestCalculate(t *testing.T) {
+	base := blob("a", 1000)
+	oldApp := blob("b", 200)
+	newApp := blob("c", 300)
+	newApp.Name = "app_layer"
+
+	previous := deployManifest(t, push("manifest", blob("d", 10), manifest(blob("d", 10), blob("e", 5), base, oldApp)))
+	current := deployManifest(t,
+		push("index", blob("f", 20),
+			manifest(blob("1", 10), blob("2", 5), base, newApp),
+			manifest(blob("3", 10), blob("4", 5), base)),
+		api.LoadDeployOperation{
+			BaseCommandOperation: api.BaseCommandOperation{Command: "load", RootKind: "manifest", Root: blob("1", 10), Manifests: []api.ManifestDeployInfo{manifest(blob("1", 10), blob("2", 5), base, newApp)}},
+			Tags:                 []string{"app:latest"},
+		},
+	)
+
+	estimate, err := Calculate(previous, current)
+	if err != nil {
+		t.Fatalf("Calculate() error = %v", err)
+	}
+	if len(estimate.Images) != 2 {
+		t.Fatalf("Calculate() returned %d images, want 2", len(estimate.Images))
+	}
+	pushed := estimate.Images[0]
+	if pushed.Name != "registry.example.com/app" || pushed.Root != blob("f", 20).Digest {
+		t.Errorf("first image = %s at %s, want registry.example.com/app at the index", pushed.Name, pushed.Root)
+	}
+	// index + two manifests and configs + the new layer
+	if pushed.PullSize != 20+15+15+300 || pushed.TotalSize != 20+1315+1015 {
+		t.Errorf("first image pull size = %d of %d, want 350 of 2350", pushed.PullSize, pushed.TotalSize)
+	}
+	amd64 := pushed.Manifests[0]
+	if amd64.Layers != 2 || amd64.CachedLayers != 1 || len(amd64.NewLayers) != 1 || amd64.NewLayers[0].Name != "app_layer" {
+		t.Errorf("first manifest = %+v, want one cached and one new layer", amd64)
+	}
+	if loaded := estimate.Images[1]; loaded.Name != "app:latest" || loaded.PullSize != 315 {
+		t.Errorf("second image = %s pulling %d, want app:latest pulling 315", loaded.Name, loaded.PullSize)
+	}
+	// blobs shared between operations are only counted once
+	if estimate.PullSize != 350 || estimate.TotalSize != 2350-1000 {
+		t.Errorf("Calculate() pull size = %d of %d, want 350 of 1350", estimate.PullSize, estimate.TotalSize)
+	}
+}
+
+func TestCalculateInvalidManifest(t *testing.T) {
+	invalid := api.DeployManifest{Operations: []json.RawMessage{json.RawMessage(`{"command":"push","unknown":true}`)}}
+	if _, err := Calculate(invalid, api.DeployManifest{}); err == nil || !strings.Contains(err.Error(), "reading previous deploy manifest") {
+		t.Errorf("Calculate() error = %v, want an error about the previous deploy manifest", err)
+	}
+	if _, err := Calculate(api.DeployManifest{}, invalid); err == nil || !strings.Contains(err.Error(), "reading current deploy manifest") {
+		t.Errorf("Calculate() error = %v, want an error about the current deploy manifest", err)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	layer := blob("c", 3<<20)
+	layer.Name = "a|b"
+	estimate := Estimate{
+		TotalSize: 5 << 20,
+		PullSize:  3 << 20,
+		Images: []ImageEstimate{{
+			Name:      "registry.example.com/app",
+			Root:      blob("1", 0).Digest,
+			TotalSize: 5 << 20,
+			PullSize:  3 << 20,
+			Manifests: []ManifestEstimate{{
+				Digest:       blob("1", 0).Digest,
+				Layers:       2,
+				CachedLayers: 1,
+				TotalSize:    5 << 20,
+				PullSize:     3 << 20,
+				NewLayers:    []api.Descriptor{layer, blob("d", 512)},
+			}},
+		}, {
+			Name:      "app:latest",
+			Root:      blob("2", 0).Digest,
+			TotalSize: 2 << 20,
+			Manifests: []ManifestEstimate{{Digest: blob("2", 0).Digest, Layers: 1, CachedLayers: 1, TotalSize: 2 << 20}},
+		}},
+	}
+	var b strings.Builder
+	if err := WriteMarkdown(&b, estimate); err != nil {
+		t.Fatalf("WriteMarkdown() error = %v", err)
+	}
+	want := "# Estimated pull size\n\n" +
+		"A node that runs the previous release needs to pull **3.0 MiB** of 5.0 MiB.\n" +
+		"\n## registry.example.com/app\n\n" +
+		"- Root: `" + blob("1", 0).Digest + "`\n" +
+		"- Pull size: 3.0 MiB of 5.0 MiB\n" +
+		"\n| Manifest | Cached layers | Pull size | Total size |\n|---|---|---|---|\n" +
+		"| `111111111111` | 1 / 2 | 3.0 MiB | 5.0 MiB |\n" +
+		"\n### Changed layers\n\n" +
+		"| Manifest | Layer | Size |\n|---|---|---|\n" +
+		"| `111111111111` | a\\|b (`" + layer.Digest + "`) | 3.0 MiB |\n" +
+		"| `111111111111` | `" + blob("d", 0).Digest + "` | 512 B |\n" +
+		"\n## app:latest\n\n" +
+		"- Root: `" + blob("2", 0).Digest + "`\n" +
+		"- Pull size: 0 B of 2.0 MiB\n" +
+		"\n| Manifest | Cached layers | Pull size | Total size |\n|---|---|---|---|\n" +
+		"| `222222222222` | 1 / 1 | 0 B | 2.0 MiB |\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteMarkdown() =\n%s\nwant\n%s", got, want)
+	}
+}
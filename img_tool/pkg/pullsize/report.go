@@ -0,0 +1,47 @@
+package pullsize
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bazel-contrib/rules_img/img_tool/internal/humansize"
+)
+
+// WriteMarkdown renders the estimate as a Markdown document suitable for code review.
+func WriteMarkdown(w io.Writer, estimate Estimate) error {
+	var b strings.Builder
+	b.WriteString("# Estimated pull size\n\n")
+	fmt.Fprintf(&b, "A node that runs the previous release needs to pull **%s** of %s.\n", humansize.Format(estimate.PullSize), humansize.Format(estimate.TotalSize))
+	for _, image := range estimate.Images {
+		fmt.Fprintf(&b, "\n## %s\n\n", image.Name)
+		fmt.Fprintf(&b, "- Root: `%s`\n", image.Root)
+		fmt.Fprintf(&b, "- Pull size: %s of %s\n", humansize.Format(image.PullSize), humansize.Format(image.TotalSize))
+		b.WriteString("\n| Manifest | Cached layers | Pull size | Total size |\n|---|---|---|---|\n")
+		for _, manifest := range image.Manifests {
+			fmt.Fprintf(&b, "| `%s` | %d / %d | %s | %s |\n", shortDigest(manifest.Digest), manifest.CachedLayers, manifest.Layers, humansize.Format(manifest.PullSize), humansize.Format(manifest.TotalSize))
+		}
+		var hasNewLayers bool
+		for _, manifest := range image.Manifests {
+			hasNewLayers = hasNewLayers || len(manifest.NewLayers) > 0
+		}
+		if !hasNewLayers {
+			continue
+		}
+		b.WriteString("\n### Changed layers\n\n")
+		b.WriteString("| Manifest | Layer | Size |\n|---|---|---|\n")
+		for _, manifest := range image.Manifests {
+			for _, layer := range manifest.NewLayers {
+				name := layer.Digest
+				if layer.Name != "" && layer.Name != layer.Digest {
+					name = fmt.Sprintf("%s (`%s`)", strings.ReplaceAll(layer.Name, "|", "\\|"), layer.Digest)
+				} else {
+					name = "`" + name + "`"
+				}
+				fmt.Fprintf(&b, "| `%s` | %s | %s |\n", shortDigest(manifest.Digest), name, humansize.Format(layer.Size))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
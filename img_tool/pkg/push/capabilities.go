@@ -0,0 +1,212 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+)
+
+// emptyDigest is the digest of the empty blob.
+// It is used to query the referrers API without depending on existing content.
+const emptyDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// RegistryCapabilities summarizes the features of a registry that matter for pushing images.
+type RegistryCapabilities struct {
+	Registry string
+	// APIVersion is the Docker-Distribution-API-Version announced by the /v2/ endpoint.
+	APIVersion string
+	// Referrers is true if the registry implements the referrers API (OCI distribution spec 1.1).
+	// Otherwise, referrers are tracked using the tag schema fallback.
+	Referrers bool
+	// ChunkMinLength is the minimum chunk size for chunked uploads
+	// announced via OCI-Chunk-Min-Length (0 if not announced).
+	ChunkMinLength int64
+	// Extensions lists the OCI extensions announced via /v2/_oci/ext/discover.
+	Extensions []string
+}
+
+// String returns a one-line summary of the capabilities.
+func (c RegistryCapabilities) String() string {
+	apiVersion := c.APIVersion
+	if apiVersion == "" {
+		apiVersion = "unknown API version"
+	}
+	referrers := "tag schema fallback"
+	if c.Referrers {
+		referrers = "supported"
+	}
+	chunkSize := "no minimum"
+	if c.ChunkMinLength > 0 {
+		chunkSize = fmt.Sprintf("minimum %d bytes", c.ChunkMinLength)
+	}
+	extensions := "none"
+	if len(c.Extensions) > 0 {
+		extensions = strings.Join(c.Extensions, ", ")
+	}
+	return fmt.Sprintf("registry %s (%s), referrers API: %s, chunked uploads: %s, extensions: %s",
+		c.Registry, apiVersion, referrers, chunkSize, extensions)
+}
+
+// ProbeRegistry queries the capabilities of the registry of repo.
+// It fails if the registry is unreachable or refuses to accept uploads to repo,
+// which would otherwise only surface after uploading blobs.
+func ProbeRegistry(ctx context.Context, repo name.Repository, keychain authn.Keychain) (RegistryCapabilities, error) {
+	auth, err := authn.Resolve(ctx, keychain, repo)
+	if err != nil {
+		return RegistryCapabilities{}, fmt.Errorf("resolving credentials for %s: %w", repo, err)
+	}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, reg.BaseTransport(), []string{repo.Scope(transport.PushScope)})
+	if err != nil {
+		return RegistryCapabilities{}, fmt.Errorf("connecting to registry %s: %w", repo.RegistryStr(), err)
+	}
+	p := &prober{
+		client: &http.Client{Transport: rt},
+		base:   url.URL{Scheme: repo.Registry.Scheme(), Host: repo.RegistryStr()},
+	}
+	caps := RegistryCapabilities{Registry: repo.RegistryStr()}
+
+	resp, err := p.do(ctx, http.MethodGet, "/v2/")
+	if err != nil {
+		return RegistryCapabilities{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RegistryCapabilities{}, fmt.Errorf("registry %s does not implement the distribution API: GET /v2/ returned %s", caps.Registry, resp.Status)
+	}
+	caps.APIVersion = resp.Header.Get("Docker-Distribution-API-Version")
+
+	caps.Extensions, err = p.extensions(ctx)
+	if err != nil {
+		return RegistryCapabilities{}, err
+	}
+
+	resp, err = p.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/referrers/%s", repo.RepositoryStr(), emptyDigest))
+	if err != nil {
+		return RegistryCapabilities{}, err
+	}
+	resp.Body.Close()
+	caps.Referrers = resp.StatusCode == http.StatusOK
+
+	caps.ChunkMinLength, err = p.chunkMinLength(ctx, repo)
+	if err != nil {
+		return RegistryCapabilities{}, err
+	}
+	return caps, nil
+}
+
+type prober struct {
+	client *http.Client
+	base   url.URL
+}
+
+func (p *prober) do(ctx context.Context, method, path string) (*http.Response, error) {
+	u := p.base
+	u.Path = path
+	return p.doURL(ctx, method, u.String())
+}
+
+func (p *prober) doURL(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, rawURL, err)
+	}
+	return resp, nil
+}
+
+// extensions returns the names of the extensions announced by the registry.
+// Most registries do not implement the discovery endpoint.
+func (p *prober) extensions(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/v2/_oci/ext/discover")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	var discovery struct {
+		Extensions []struct {
+			Name string `json:"name"`
+		} `json:"extensions"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&discovery); err != nil {
+		return nil, nil
+	}
+	var names []string
+	for _, extension := range discovery.Extensions {
+		names = append(names, extension.Name)
+	}
+	return names, nil
+}
+
+// chunkMinLength starts (and cancels) a blob upload.
+// This checks that we are allowed to push to the repository
+// and returns the minimum chunk size announced by the registry.
+func (p *prober) chunkMinLength(ctx context.Context, repo name.Repository) (int64, error) {
+	resp, err := p.do(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repo.RepositoryStr()))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return 0, fmt.Errorf("not allowed to push to %s: starting an upload returned %s", repo, resp.Status)
+	default:
+		return 0, fmt.Errorf("starting an upload to %s returned %s", repo, resp.Status)
+	}
+
+	var minLength int64
+	if value := resp.Header.Get("OCI-Chunk-Min-Length"); value != "" {
+		minLength, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if location, err := resp.Location(); err == nil {
+		// best effort: registries garbage collect abandoned uploads anyway
+		if cancel, err := p.doURL(ctx, http.MethodDelete, location.String()); err == nil {
+			cancel.Body.Close()
+		}
+	}
+	return minLength, nil
+}
+
+// checkCapabilities probes the registries of all references once
+// and prints a summary of their capabilities to stderr.
+func (u *uploader) checkCapabilities(ctx context.Context, refs []name.Reference) error {
+	if u.capabilityKeychain == nil {
+		return nil
+	}
+	u.capabilitiesMux.Lock()
+	defer u.capabilitiesMux.Unlock()
+	if u.capabilities == nil {
+		u.capabilities = make(map[string]RegistryCapabilities)
+	}
+	for _, ref := range refs {
+		registry := ref.Context().RegistryStr()
+		if _, ok := u.capabilities[registry]; ok {
+			continue
+		}
+		caps, err := ProbeRegistry(ctx, ref.Context(), u.capabilityKeychain)
+		if err != nil {
+			return fmt.Errorf("checking registry capabilities: %w", err)
+		}
+		slog.Info("Push target", "registry", caps)
+		u.capabilities[registry] = caps
+	}
+	return nil
+}
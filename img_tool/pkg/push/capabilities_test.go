@@ -0,0 +1,111 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+)
+
+// capabilityRegistry answers the requests of ProbeRegistry.
+type capabilityRegistry struct {
+	mu           sync.Mutex
+	referrers    bool
+	uploadStatus int
+	cancelled    []string
+}
+
+func (r *capabilityRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case req.URL.Path == "/v2/":
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		w.WriteHeader(http.StatusOK)
+	case req.URL.Path == "/v2/_oci/ext/discover":
+		w.Write([]byte(`{"extensions":[{"name":"_oci"},{"name":"_example"}]}`))
+	case strings.HasPrefix(req.URL.Path, "/v2/app/referrers/") && r.referrers:
+		w.Write([]byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`))
+	case req.Method == http.MethodPost && req.URL.Path == "/v2/app/blobs/uploads/":
+		if r.uploadStatus != http.StatusAccepted {
+			w.WriteHeader(r.uploadStatus)
+			return
+		}
+		w.Header().Set("Location", "/v2/app/blobs/uploads/session")
+		w.Header().Set("OCI-Chunk-Min-Length", "5242880")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, "/v2/app/blobs/uploads/"):
+		r.cancelled = append(r.cancelled, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func probeTestRegistry(t *testing.T, registry *capabilityRegistry) (RegistryCapabilities, string, error) {
+	t.Helper()
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := name.NewRepository(serverURL.Host+"/app", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps, err := ProbeRegistry(context.Background(), repo, authn.NewMultiKeychain())
+	return caps, serverURL.Host, err
+}
+
+func TestProbeRegistry(t *testing.T) {
+	registry := &capabilityRegistry{referrers: true, uploadStatus: http.StatusAccepted}
+	caps, host, err := probeTestRegistry(t, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "registry " + host + " (registry/2.0), referrers API: supported, chunked uploads: minimum 5242880 bytes, extensions: _oci, _example"
+	if caps.String() != want {
+		t.Errorf("capabilities = %q, want %q", caps, want)
+	}
+	if len(registry.cancelled) != 1 || registry.cancelled[0] != "/v2/app/blobs/uploads/session" {
+		t.Errorf("cancelled uploads = %q, want the probe upload", registry.cancelled)
+	}
+}
+
+func TestProbeRegistryWithoutReferrers(t *testing.T) {
+	caps, _, err := probeTestRegistry(t, &capabilityRegistry{uploadStatus: http.StatusAccepted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Referrers {
+		t.Errorf("Referrers = true for a registry without the referrers API")
+	}
+
+	want := "registry example.com (unknown API version), referrers API: tag schema fallback, chunked uploads: no minimum, extensions: none"
+	if got := (RegistryCapabilities{Registry: "example.com"}).String(); got != want {
+		t.Errorf("capabilities = %q, want %q", got, want)
+	}
+}
+
+func TestProbeRegistryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   string
+	}{
+		{status: http.StatusForbidden, want: "not allowed to push to"},
+		{status: http.StatusUnauthorized, want: "not allowed to push to"},
+		{status: http.StatusMethodNotAllowed, want: "returned 405 Method Not Allowed"},
+	} {
+		_, _, err := probeTestRegistry(t, &capabilityRegistry{uploadStatus: tc.status})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ProbeRegistry() with upload status %d: error = %v, want %q", tc.status, err, tc.want)
+		}
+	}
+}
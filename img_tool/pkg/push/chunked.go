@@ -0,0 +1,408 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+const chunkedUploadMaxAttempts = 5
+
+// chunkedUploadInitialBackoff is the wait before the first retry of a chunk. It doubles with every attempt.
+var chunkedUploadInitialBackoff = time.Second
+
+// chunkedUpload is a blob that is uploaded in chunks to a repository.
+type chunkedUpload struct {
+	repo name.Repository
+	desc api.Descriptor
+}
+
+// uploadLargeBlobs uploads all layers larger than the chunk size in chunks (PATCH with Content-Range).
+// An interrupted chunk is resumed from the offset reported by the registry, instead of restarting the whole blob.
+// The blobs are uploaded before the images are written, which then skips the existing blobs.
+// Blobs that only exist in another registry are left to the regular upload, so that they can be mounted.
+func (u *uploader) uploadLargeBlobs(ctx context.Context, ops []api.IndexedPushDeployOperation) error {
+	if u.chunkSize <= 0 {
+		return nil
+	}
+	var uploads []chunkedUpload
+	seen := make(map[string]struct{})
+	for _, op := range ops {
+		refs, err := u.tags(op)
+		if err != nil {
+			return err
+		}
+		repo := refs[0].Context()
+		for _, manifest := range op.Manifests {
+			for _, layer := range manifest.LayerBlobs {
+				if layer.Size <= u.chunkSize || u.blobKnownToExist(repo, layer.Digest) {
+					continue
+				}
+				key := repo.String() + "@" + layer.Digest
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				digest, err := registryv1.NewHash(layer.Digest)
+				if err != nil {
+					return err
+				}
+				location, err := u.vfs.Location(digest)
+				if err != nil {
+					return fmt.Errorf("locating source of blob %s: %w", layer.Digest, err)
+				}
+				if location != "file" && location != "remote_cache" {
+					continue
+				}
+				uploads = append(uploads, chunkedUpload{repo: repo, desc: layer})
+			}
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(u.jobs)
+	for _, upload := range uploads {
+		g.Go(func() error {
+			if err := u.uploadChunked(ctx, upload.repo, upload.desc); err != nil {
+				return fmt.Errorf("uploading blob %s to %s in chunks: %w", upload.desc.Digest, upload.repo, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func (u *uploader) uploadChunked(ctx context.Context, repo name.Repository, desc api.Descriptor) error {
+	digest, err := registryv1.NewHash(desc.Digest)
+	if err != nil {
+		return err
+	}
+	layer, err := u.vfs.Layer(digest)
+	if err != nil {
+		return err
+	}
+	c, err := newBlobClient(ctx, repo, u.chunkKeychain, transport.PushScope)
+	if err != nil {
+		return err
+	}
+
+	exists, err := c.blobExists(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		u.markBlobExists(repo, desc.Digest)
+		u.recordTransfer(repo, desc.Digest, BlobExisting)
+		return nil
+	}
+	location, minLength, err := c.startUpload(ctx)
+	if err != nil {
+		return err
+	}
+	chunkSize := max(u.chunkSize, minLength)
+
+	blob := &offsetReader{open: layer.Compressed}
+	defer blob.Close()
+	location, err = c.uploadChunks(ctx, location, blob, desc.Digest, desc.Size, chunkSize)
+	if err != nil {
+		return err
+	}
+	if err := c.finishUpload(ctx, location, desc.Digest); err != nil {
+		return err
+	}
+	u.markBlobExists(repo, desc.Digest)
+	u.recordTransfer(repo, desc.Digest, BlobChunked)
+	return nil
+}
+
+// uploadChunks sends the blob in chunks to the upload session and returns the location to finish the upload.
+// A failed chunk is retried from the offset that the registry reports.
+func (c *blobClient) uploadChunks(ctx context.Context, location string, blob *offsetReader, digest string, size, chunkSize int64) (string, error) {
+	var offset int64
+	attempt := 0
+	backoff := chunkedUploadInitialBackoff
+	for offset < size {
+		length := min(chunkSize, size-offset)
+		next, err := c.patchChunk(ctx, location, blob, offset, length)
+		if err == nil {
+			location = next
+			offset += length
+			attempt = 0
+			backoff = chunkedUploadInitialBackoff
+			continue
+		}
+		attempt++
+		if attempt >= chunkedUploadMaxAttempts || ctx.Err() != nil {
+			return "", err
+		}
+		slog.Warn("Upload interrupted", "digest", digest, "repository", c.repo, "offset", offset, "attempt", fmt.Sprintf("%d/%d", attempt, chunkedUploadMaxAttempts), logging.ErrKey, err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		// The registry may have received (parts of) the failed chunk.
+		// Continue from the offset it reports.
+		resumed, resumeLocation, statusErr := c.uploadStatus(ctx, location)
+		if statusErr != nil {
+			slog.Debug("Upload status unavailable, retrying the chunk", "digest", digest, "repository", c.repo, "offset", offset, logging.ErrKey, statusErr)
+			continue
+		}
+		offset = resumed
+		location = resumeLocation
+	}
+	return location, nil
+}
+
+// blobClient sends blob requests to a repository.
+type blobClient struct {
+	client *http.Client
+	base   url.URL
+	repo   name.Repository
+}
+
+// newBlobClient authenticates with the given scope (like transport.PushScope) for the repository.
+func newBlobClient(ctx context.Context, repo name.Repository, keychain authn.Keychain, scope string) (*blobClient, error) {
+	auth, err := authn.Resolve(ctx, keychain, repo)
+	if err != nil {
+		return nil, fmt.Errorf("resolving credentials for %s: %w", repo, err)
+	}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, reg.BaseTransport(), []string{repo.Scope(scope)})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to registry %s: %w", repo.RegistryStr(), err)
+	}
+	return &blobClient{
+		client: &http.Client{Transport: rt},
+		base:   url.URL{Scheme: repo.Registry.Scheme(), Host: repo.RegistryStr()},
+		repo:   repo,
+	}, nil
+}
+
+func (c *blobClient) url(path string) string {
+	u := c.base
+	u.Path = path
+	return u.String()
+}
+
+func (c *blobClient) do(ctx context.Context, method, rawURL string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		// The body is a section of a stream that cannot be replayed.
+		req.GetBody = nil
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, rawURL, err)
+	}
+	return resp, nil
+}
+
+func (c *blobClient) blobExists(ctx context.Context, digest string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.url(fmt.Sprintf("/v2/%s/blobs/%s", c.repo.RepositoryStr(), digest)), nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	// other errors (like 401 or 5xx) would otherwise fail the upload later with a less helpful error
+	return false, fmt.Errorf("checking for blob %s: %w", digest, transport.CheckError(resp, http.StatusOK, http.StatusNotFound))
+}
+
+// startUpload starts an upload session and returns its location
+// and the minimum chunk size announced by the registry.
+func (c *blobClient) startUpload(ctx context.Context) (string, int64, error) {
+	resp, err := c.do(ctx, http.MethodPost, c.url(fmt.Sprintf("/v2/%s/blobs/uploads/", c.repo.RepositoryStr())), nil, 0, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", 0, transport.CheckError(resp, http.StatusAccepted)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return "", 0, fmt.Errorf("starting upload: %w", err)
+	}
+	var minLength int64
+	if value := resp.Header.Get("OCI-Chunk-Min-Length"); value != "" {
+		minLength, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return location.String(), minLength, nil
+}
+
+// patchChunk uploads length bytes of the blob starting at offset and returns the location for the next request.
+func (c *blobClient) patchChunk(ctx context.Context, location string, blob *offsetReader, offset, length int64) (string, error) {
+	if err := blob.seek(offset); err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+length-1))
+	resp, err := c.do(ctx, http.MethodPatch, location, blob.section(length), length, header)
+	if err != nil {
+		blob.invalidate()
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		blob.invalidate()
+		return "", transport.CheckError(resp, http.StatusAccepted)
+	}
+	next, err := resp.Location()
+	if err != nil {
+		return location, nil
+	}
+	return next.String(), nil
+}
+
+// uploadStatus returns the offset of the next byte the registry expects and the location of the upload.
+func (c *blobClient) uploadStatus(ctx context.Context, location string) (int64, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, location, nil, 0, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, "", transport.CheckError(resp, http.StatusNoContent)
+	}
+	offset, err := parseRangeEnd(resp.Header.Get("Range"))
+	if err != nil {
+		return 0, "", err
+	}
+	if next, err := resp.Location(); err == nil {
+		location = next.String()
+	}
+	return offset, location, nil
+}
+
+func (c *blobClient) finishUpload(ctx context.Context, location, digest string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("parsing upload location: %w", err)
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	resp, err := c.do(ctx, http.MethodPut, u.String(), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return transport.CheckError(resp, http.StatusCreated)
+}
+
+// parseRangeEnd returns the offset after the last byte of a Range header like "0-1023" (or "bytes=0-1023").
+// An empty header means that no data was received yet.
+// Registries (like the distribution registry) report an empty session as "0-0",
+// which is indistinguishable from a single received byte, so it is treated as empty.
+func parseRangeEnd(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.TrimPrefix(value, "bytes=")
+	if value == "0-0" {
+		return 0, nil
+	}
+	_, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Range header %q", value)
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Range header %q: %w", value, err)
+	}
+	return last + 1, nil
+}
+
+// offsetReader reads a blob sequentially, reopening it when a chunk has to be sent again.
+type offsetReader struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+	pos  int64
+}
+
+func (r *offsetReader) seek(offset int64) error {
+	if r.rc != nil && r.pos == offset {
+		return nil
+	}
+	if r.rc == nil || offset < r.pos {
+		r.invalidate()
+		rc, err := r.open()
+		if err != nil {
+			return fmt.Errorf("opening blob: %w", err)
+		}
+		r.rc = rc
+		r.pos = 0
+	}
+	if seeker, ok := r.rc.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking blob to offset %d: %w", offset, err)
+		}
+		r.pos = offset
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, r.rc, offset-r.pos)
+	r.pos += n
+	if err != nil {
+		return fmt.Errorf("skipping blob to offset %d: %w", offset, err)
+	}
+	return nil
+}
+
+// section returns a reader for the next length bytes.
+func (r *offsetReader) section(length int64) io.Reader {
+	return &countingReader{r: io.LimitReader(r.rc, length), pos: &r.pos}
+}
+
+// invalidate closes the blob after a failed request, since it is unknown how much of it was read.
+func (r *offsetReader) invalidate() {
+	if r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+}
+
+func (r *offsetReader) Close() error {
+	r.invalidate()
+	return nil
+}
+
+type countingReader struct {
+	r   io.Reader
+	pos *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.pos += int64(n)
+	return n, err
+}
@@ -0,0 +1,219 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+)
+
+// testRegistry implements the blob upload endpoints of a registry for a single upload session.
+// The first PATCH that starts at dropOffset is cut off after dropAfter bytes (the connection is closed),
+// but the received bytes are kept, like registries do.
+type testRegistry struct {
+	mu         sync.Mutex
+	received   []byte
+	patches    []string
+	dropOffset int64
+	dropAfter  int64
+	dropped    bool
+	headStatus int
+	finished   string
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case req.Method == http.MethodHead && strings.HasPrefix(req.URL.Path, "/v2/test/blobs/"):
+		w.WriteHeader(r.headStatus)
+	case req.Method == http.MethodPost && req.URL.Path == "/v2/test/blobs/uploads/":
+		w.Header().Set("Location", "/v2/test/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodGet && req.URL.Path == "/v2/test/blobs/uploads/session":
+		// like the distribution registry, an empty session is reported as 0-0
+		w.Header().Set("Range", fmt.Sprintf("0-%d", max(0, len(r.received)-1)))
+		w.Header().Set("Location", "/v2/test/blobs/uploads/session")
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPatch && req.URL.Path == "/v2/test/blobs/uploads/session":
+		contentRange := req.Header.Get("Content-Range")
+		r.patches = append(r.patches, contentRange)
+		start, _, _ := strings.Cut(contentRange, "-")
+		if offset, err := strconv.ParseInt(start, 10, 64); err != nil || offset != int64(len(r.received)) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if !r.dropped && int64(len(r.received)) == r.dropOffset {
+			r.dropped = true
+			partial, _ := io.ReadAll(io.LimitReader(req.Body, r.dropAfter))
+			r.received = append(r.received, partial...)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		chunk, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.received = append(r.received, chunk...)
+		w.Header().Set("Location", "/v2/test/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && req.URL.Path == "/v2/test/blobs/uploads/session":
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(r.received)); req.URL.Query().Get("digest") != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.finished = req.URL.Query().Get("digest")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestBlobClient(t *testing.T, registry *testRegistry) *blobClient {
+	t.Helper()
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := name.NewRepository(serverURL.Host+"/test", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blobClient{
+		client: server.Client(),
+		base:   url.URL{Scheme: "http", Host: serverURL.Host},
+		repo:   repo,
+	}
+}
+
+func TestUploadChunksResume(t *testing.T) {
+	backoff := chunkedUploadInitialBackoff
+	chunkedUploadInitialBackoff = time.Millisecond
+	t.Cleanup(func() { chunkedUploadInitialBackoff = backoff })
+
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 4096) // 64 KiB
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	const chunkSize = 16 << 10
+
+	tests := []struct {
+		name       string
+		dropOffset int64
+		dropAfter  int64
+		wantPATCH  []string
+	}{
+		{
+			name:       "dropped mid-chunk",
+			dropOffset: 2 * chunkSize,
+			dropAfter:  5000,
+			wantPATCH:  []string{"0-16383", "16384-32767", "32768-49151", "37768-54151", "54152-65535"},
+		},
+		{
+			name:       "dropped before the first byte",
+			dropOffset: 0,
+			dropAfter:  0,
+			wantPATCH:  []string{"0-16383", "0-16383", "16384-32767", "32768-49151", "49152-65535"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := &testRegistry{dropOffset: tt.dropOffset, dropAfter: tt.dropAfter}
+			c := newTestBlobClient(t, registry)
+			ctx := context.Background()
+
+			location, _, err := c.startUpload(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := &offsetReader{open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(blob)), nil
+			}}
+			defer reader.Close()
+			location, err = c.uploadChunks(ctx, location, reader, digest, int64(len(blob)), chunkSize)
+			if err != nil {
+				t.Fatalf("uploadChunks: %v", err)
+			}
+			if err := c.finishUpload(ctx, location, digest); err != nil {
+				t.Fatalf("finishUpload: %v", err)
+			}
+
+			if !registry.dropped {
+				t.Fatal("no PATCH was dropped")
+			}
+			if registry.finished != digest || !bytes.Equal(registry.received, blob) {
+				t.Errorf("registry received %d bytes (finished %q), want the blob of %d bytes", len(registry.received), registry.finished, len(blob))
+			}
+			if got := strings.Join(registry.patches, " "); got != strings.Join(tt.wantPATCH, " ") {
+				t.Errorf("PATCH ranges = %s, want %s", got, strings.Join(tt.wantPATCH, " "))
+			}
+		})
+	}
+}
+
+func TestBlobExists(t *testing.T) {
+	tests := []struct {
+		status  int
+		want    bool
+		wantErr bool
+	}{
+		{status: http.StatusOK, want: true},
+		{status: http.StatusNotFound, want: false},
+		{status: http.StatusUnauthorized, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			c := newTestBlobClient(t, &testRegistry{headStatus: tt.status})
+			got, err := c.blobExists(context.Background(), "sha256:"+strings.Repeat("a", 64))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("blobExists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("blobExists() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRangeEnd(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "0-0", want: 0},
+		{value: "bytes=0-0", want: 0},
+		{value: "0-1", want: 2},
+		{value: "0-1023", want: 1024},
+		{value: "bytes=0-1023", want: 1024},
+		{value: "1023", wantErr: true},
+		{value: "0-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRangeEnd(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRangeEnd(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRangeEnd(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
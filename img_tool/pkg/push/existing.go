@@ -0,0 +1,201 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+	registrytypes "github.com/malt3/go-containerregistry/pkg/v1/types"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+const existenceCheckConcurrency = 16
+
+// blobInRepository is a layer that may already exist in a target repository.
+type blobInRepository struct {
+	repo   name.Repository
+	digest string
+}
+
+func (b blobInRepository) key() string {
+	return b.repo.String() + "@" + b.digest
+}
+
+// blobKnownToExist reports whether the blob was found in (or uploaded to) the repository during this push.
+func (u *uploader) blobKnownToExist(repo name.Repository, digest string) bool {
+	u.existingBlobsMux.Lock()
+	defer u.existingBlobsMux.Unlock()
+	return u.existingBlobs[blobInRepository{repo: repo, digest: digest}.key()]
+}
+
+func (u *uploader) markBlobExists(repo name.Repository, digest string) {
+	u.existingBlobsMux.Lock()
+	defer u.existingBlobsMux.Unlock()
+	if u.existingBlobs == nil {
+		u.existingBlobs = make(map[string]bool)
+	}
+	u.existingBlobs[blobInRepository{repo: repo, digest: digest}.key()] = true
+}
+
+// checkExistingBlobs sends HEAD requests for all layers of the operations to their target repositories in parallel
+// and remembers the layers that exist. Those layers are left out of the upload,
+// which saves one round trip per layer for images where only the top layers changed.
+// Errors are not fatal: layers that could not be checked are uploaded (and checked) as usual.
+func (u *uploader) checkExistingBlobs(ctx context.Context, ops []api.IndexedPushDeployOperation) error {
+	if u.existenceKeychain == nil {
+		return nil
+	}
+	var candidates []blobInRepository
+	seen := make(map[string]struct{})
+	for _, op := range ops {
+		refs, err := u.tags(op)
+		if err != nil {
+			return err
+		}
+		repo := refs[0].Context()
+		for _, manifest := range op.Manifests {
+			for _, layer := range manifest.LayerBlobs {
+				candidate := blobInRepository{repo: repo, digest: layer.Digest}
+				if _, ok := seen[candidate.key()]; ok || u.blobKnownToExist(repo, layer.Digest) {
+					continue
+				}
+				seen[candidate.key()] = struct{}{}
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// One authenticated client per repository, shared by all of its requests.
+	clients := make(map[string]*blobClient)
+	for _, candidate := range candidates {
+		if _, ok := clients[candidate.repo.String()]; ok {
+			continue
+		}
+		client, err := newBlobClient(ctx, candidate.repo, u.existenceKeychain, transport.PullScope)
+		if err != nil {
+			slog.Warn("Skipping check for existing blobs", "repository", candidate.repo, logging.ErrKey, err)
+		}
+		clients[candidate.repo.String()] = client
+	}
+
+	var existing int
+	var existingMux sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(existenceCheckConcurrency)
+	for _, candidate := range candidates {
+		client := clients[candidate.repo.String()]
+		if client == nil {
+			continue
+		}
+		g.Go(func() error {
+			exists, err := client.blobExists(ctx, candidate.digest)
+			if err != nil || !exists {
+				return nil
+			}
+			u.markBlobExists(candidate.repo, candidate.digest)
+			u.recordTransfer(candidate.repo, candidate.digest, BlobExisting)
+			existingMux.Lock()
+			existing++
+			existingMux.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	slog.Info("Skipping layers that already exist in the target repositories", "existing", existing, "total", len(candidates))
+	return nil
+}
+
+// withoutExistingLayers returns a taggable whose images leave out the layers known to exist in repo.
+// The manifests are unchanged, so the layers are still referenced, but remote.MultiWrite does not upload
+// (or check) them again.
+func (u *uploader) withoutExistingLayers(repo name.Repository, taggable remote.Taggable) remote.Taggable {
+	if u.existenceKeychain == nil {
+		return taggable
+	}
+	switch t := taggable.(type) {
+	case registryv1.ImageIndex:
+		return &prunedIndex{index: t, u: u, repo: repo}
+	case registryv1.Image:
+		return &prunedImage{Image: t, u: u, repo: repo}
+	}
+	return taggable
+}
+
+type prunedImage struct {
+	registryv1.Image
+	u    *uploader
+	repo name.Repository
+}
+
+func (img *prunedImage) Layers() ([]registryv1.Layer, error) {
+	layers, err := img.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var missing []registryv1.Layer
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if img.u.blobKnownToExist(img.repo, digest.String()) {
+			continue
+		}
+		missing = append(missing, layer)
+	}
+	return missing, nil
+}
+
+type prunedIndex struct {
+	index registryv1.ImageIndex
+	u     *uploader
+	repo  name.Repository
+}
+
+func (idx *prunedIndex) MediaType() (registrytypes.MediaType, error) {
+	return idx.index.MediaType()
+}
+
+func (idx *prunedIndex) Size() (int64, error) {
+	return idx.index.Size()
+}
+
+func (idx *prunedIndex) Digest() (registryv1.Hash, error) {
+	return idx.index.Digest()
+}
+
+func (idx *prunedIndex) IndexManifest() (*registryv1.IndexManifest, error) {
+	return idx.index.IndexManifest()
+}
+
+func (idx *prunedIndex) RawManifest() ([]byte, error) {
+	return idx.index.RawManifest()
+}
+
+func (idx *prunedIndex) Image(digest registryv1.Hash) (registryv1.Image, error) {
+	img, err := idx.index.Image(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &prunedImage{Image: img, u: idx.u, repo: idx.repo}, nil
+}
+
+func (idx *prunedIndex) ImageIndex(digest registryv1.Hash) (registryv1.ImageIndex, error) {
+	child, err := idx.index.ImageIndex(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &prunedIndex{index: child, u: idx.u, repo: idx.repo}, nil
+}
@@ -0,0 +1,134 @@
+package push
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/authn"
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// recordingRegistry is an in-memory registry that records HEAD requests for blobs and completed uploads.
+type recordingRegistry struct {
+	handler http.Handler
+	// failHeads is the number of HEAD requests for blobs that are answered with 401.
+	failHeads int
+
+	mu       sync.Mutex
+	heads    []string
+	uploaded []string
+}
+
+func (r *recordingRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	switch {
+	case req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/sha256:"):
+		r.heads = append(r.heads, req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+		if len(r.heads) <= r.failHeads {
+			r.mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case req.Method == http.MethodPut && req.URL.Query().Get("digest") != "":
+		r.uploaded = append(r.uploaded, req.URL.Query().Get("digest"))
+	}
+	r.mu.Unlock()
+	r.handler.ServeHTTP(w, req)
+}
+
+func TestCheckExistingBlobs(t *testing.T) {
+	layoutDir, op := writeTestLayout(t, "eager")
+	existingLayer := op.Manifests[0].LayerBlobs[0].Digest
+	newLayer := op.Manifests[0].LayerBlobs[1].Digest
+
+	tests := []struct {
+		name         string
+		failHeads    int
+		wantExisting bool
+	}{
+		{name: "existing layer is skipped", wantExisting: true},
+		// layers that can't be checked are uploaded as usual
+		{name: "check fails", failHeads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := &recordingRegistry{handler: registry.New(registry.Logger(log.New(io.Discard, "", 0)))}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			// the first layer was pushed by a previous release
+			vfs := testVFS(t, layoutDir, op)
+			digest, err := registryv1.NewHash(existingLayer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layer, err := vfs.Layer(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := name.NewRepository(host + "/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := remote.WriteLayer(repo, layer); err != nil {
+				t.Fatal(err)
+			}
+			reg.mu.Lock()
+			reg.heads, reg.uploaded = nil, nil
+			reg.failHeads = tt.failHeads
+			reg.mu.Unlock()
+
+			uploader := NewBuilder(vfs).
+				WithOverrideRegistry(host).
+				WithExistingBlobCheck(authn.NewMultiKeychain()).
+				Build()
+			if _, err := uploader.PushAll(context.Background(), []api.IndexedPushDeployOperation{op}, "eager"); err != nil {
+				t.Fatalf("PushAll() error = %v", err)
+			}
+
+			if len(reg.heads) < 2 || !slices.Contains(reg.heads[:2], existingLayer) || !slices.Contains(reg.heads[:2], newLayer) {
+				t.Errorf("HEAD requests = %q, want both layers to be checked first", reg.heads)
+			}
+			if slices.Contains(reg.uploaded, existingLayer) {
+				t.Errorf("uploaded blobs = %q, want the existing layer to be skipped", reg.uploaded)
+			}
+			if !slices.Contains(reg.uploaded, newLayer) {
+				t.Errorf("uploaded blobs = %q, want the new layer to be uploaded", reg.uploaded)
+			}
+
+			statuses := make(map[string]string)
+			for _, blob := range uploader.Results()[0].Blobs {
+				statuses[blob.Digest] = blob.Status
+			}
+			wantStatus := BlobPushed
+			if tt.wantExisting {
+				wantStatus = BlobExisting
+			}
+			if statuses[existingLayer] != wantStatus || statuses[newLayer] != BlobPushed {
+				t.Errorf("transfer statuses = %v, want %s for the existing and %s for the new layer", statuses, wantStatus, BlobPushed)
+			}
+		})
+	}
+}
+
+func TestCheckExistingBlobsDisabled(t *testing.T) {
+	layoutDir, op := writeTestLayout(t, "eager")
+	uploader := NewBuilder(testVFS(t, layoutDir, op)).WithOverrideRegistry("127.0.0.1:1").Build()
+	// without a keychain, no requests are sent
+	if err := uploader.checkExistingBlobs(context.Background(), []api.IndexedPushDeployOperation{op}); err != nil {
+		t.Errorf("checkExistingBlobs() error = %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	registrytypes "github.com/malt3/go-containerregistry/pkg/v1/types"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// ManifestFormat selects the media types of pushed manifests.
+type ManifestFormat string
+
+const (
+	// ManifestFormatUnchanged pushes manifests as they were built.
+	ManifestFormatUnchanged ManifestFormat = ""
+	// ManifestFormatOCI pushes OCI manifests, indexes, configs, and layers.
+	ManifestFormatOCI ManifestFormat = "oci"
+	// ManifestFormatDocker pushes Docker schema 2 manifests and manifest lists
+	// for registries that reject OCI media types.
+	ManifestFormatDocker ManifestFormat = "docker"
+)
+
+// ParseManifestFormat parses the value of the --manifest-format flag.
+func ParseManifestFormat(s string) (ManifestFormat, error) {
+	switch format := ManifestFormat(s); format {
+	case ManifestFormatUnchanged, ManifestFormatOCI, ManifestFormatDocker:
+		return format, nil
+	}
+	return ManifestFormatUnchanged, fmt.Errorf("invalid manifest format %q (expected %q or %q)", s, ManifestFormatOCI, ManifestFormatDocker)
+}
+
+// ociToDocker maps OCI media types to their Docker equivalent.
+// zstd compressed layers have no Docker equivalent.
+var ociToDocker = map[registrytypes.MediaType]registrytypes.MediaType{
+	registrytypes.OCIImageIndex:        registrytypes.DockerManifestList,
+	registrytypes.OCIManifestSchema1:   registrytypes.DockerManifestSchema2,
+	registrytypes.OCIConfigJSON:        registrytypes.DockerConfigJSON,
+	registrytypes.OCILayer:             registrytypes.DockerLayer,
+	registrytypes.OCIUncompressedLayer: registrytypes.DockerUncompressedLayer,
+	registrytypes.OCIRestrictedLayer:   registrytypes.DockerForeignLayer,
+}
+
+// dockerToOCI maps Docker media types to their OCI equivalent.
+var dockerToOCI = map[registrytypes.MediaType]registrytypes.MediaType{
+	registrytypes.DockerManifestList:      registrytypes.OCIImageIndex,
+	registrytypes.DockerManifestSchema2:   registrytypes.OCIManifestSchema1,
+	registrytypes.DockerConfigJSON:        registrytypes.OCIConfigJSON,
+	registrytypes.DockerLayer:             registrytypes.OCILayer,
+	registrytypes.DockerUncompressedLayer: registrytypes.OCIUncompressedLayer,
+	registrytypes.DockerForeignLayer:      registrytypes.OCIRestrictedLayer,
+}
+
+// mediaType returns the media type in the target format.
+// Media types that are already in the target format are returned unchanged.
+func (f ManifestFormat) mediaType(mediaType registrytypes.MediaType) (registrytypes.MediaType, error) {
+	var mapping, reverse map[registrytypes.MediaType]registrytypes.MediaType
+	switch f {
+	case ManifestFormatUnchanged:
+		return mediaType, nil
+	case ManifestFormatDocker:
+		mapping, reverse = ociToDocker, dockerToOCI
+	case ManifestFormatOCI:
+		mapping, reverse = dockerToOCI, ociToDocker
+	}
+	if converted, ok := mapping[mediaType]; ok {
+		return converted, nil
+	}
+	if _, ok := reverse[mediaType]; ok {
+		return mediaType, nil
+	}
+	return "", fmt.Errorf("media type %s has no %s equivalent", mediaType, f)
+}
+
+func (f ManifestFormat) descriptor(desc registryv1.Descriptor) (registryv1.Descriptor, error) {
+	mediaType, err := f.mediaType(desc.MediaType)
+	if err != nil {
+		return desc, err
+	}
+	desc.MediaType = mediaType
+	return desc, nil
+}
+
+// convertTaggable returns the image or index in the given format.
+// Rewriting media types changes the digests of all manifests,
+// so child manifests of an index are converted as well.
+func convertTaggable(t remote.Taggable, format ManifestFormat) (convertedTaggable, error) {
+	switch t := t.(type) {
+	case registryv1.ImageIndex:
+		return convertIndex(t, format)
+	case registryv1.Image:
+		return convertImage(t, format)
+	}
+	return nil, fmt.Errorf("converting manifest format: unsupported manifest type %T", t)
+}
+
+// convertedTaggable is an image or index with rewritten media types.
+type convertedTaggable interface {
+	remote.Taggable
+	// descriptor returns the descriptor of the converted manifest.
+	descriptor() api.Descriptor
+	// manifestDescriptors maps the digests of the original manifests
+	// (the root and its children) to the descriptors of the converted manifests.
+	manifestDescriptors() map[string]api.Descriptor
+}
+
+type convertedImage struct {
+	registryv1.Image
+	original    api.Descriptor
+	rawManifest []byte
+	manifest    *registryv1.Manifest
+	layers      map[registryv1.Hash]registryv1.Layer
+}
+
+func convertImage(img registryv1.Image, format ManifestFormat) (*convertedImage, error) {
+	rawOriginal, err := img.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	original := rawManifestDescriptor("", rawOriginal)
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	converted := *manifest
+	if converted.MediaType, err = format.mediaType(mediaType); err != nil {
+		return nil, fmt.Errorf("converting manifest %s: %w", original.Digest, err)
+	}
+	if converted.Config, err = format.descriptor(manifest.Config); err != nil {
+		return nil, fmt.Errorf("converting config of manifest %s: %w", original.Digest, err)
+	}
+	changed := converted.MediaType != mediaType || converted.Config.MediaType != manifest.Config.MediaType
+	converted.Layers = make([]registryv1.Descriptor, len(manifest.Layers))
+	layers := make(map[registryv1.Hash]registryv1.Layer, len(manifest.Layers))
+	for i, desc := range manifest.Layers {
+		if converted.Layers[i], err = format.descriptor(desc); err != nil {
+			return nil, fmt.Errorf("converting layer %s of manifest %s: %w", desc.Digest, original.Digest, err)
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		layers[desc.Digest] = mediaTypeLayer{Layer: layer, mediaType: converted.Layers[i].MediaType}
+		changed = changed || converted.Layers[i].MediaType != desc.MediaType
+	}
+	rawManifest, err := marshalIfChanged(rawOriginal, changed, &converted)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling converted manifest %s: %w", original.Digest, err)
+	}
+	return &convertedImage{
+		Image:       img,
+		original:    original,
+		rawManifest: rawManifest,
+		manifest:    &converted,
+		layers:      layers,
+	}, nil
+}
+
+func (img *convertedImage) Layers() ([]registryv1.Layer, error) {
+	layers := make([]registryv1.Layer, len(img.manifest.Layers))
+	for i, desc := range img.manifest.Layers {
+		layers[i] = img.layers[desc.Digest]
+	}
+	return layers, nil
+}
+
+func (img *convertedImage) LayerByDigest(digest registryv1.Hash) (registryv1.Layer, error) {
+	if layer, ok := img.layers[digest]; ok {
+		return layer, nil
+	}
+	return nil, fmt.Errorf("layer %s not found in manifest", digest)
+}
+
+func (img *convertedImage) LayerByDiffID(diffID registryv1.Hash) (registryv1.Layer, error) {
+	layer, err := img.Image.LayerByDiffID(diffID)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return img.LayerByDigest(digest)
+}
+
+func (img *convertedImage) MediaType() (registrytypes.MediaType, error) {
+	return img.manifest.MediaType, nil
+}
+
+func (img *convertedImage) Size() (int64, error) {
+	return int64(len(img.rawManifest)), nil
+}
+
+func (img *convertedImage) Digest() (registryv1.Hash, error) {
+	h, _, err := registryv1.SHA256(bytes.NewReader(img.rawManifest))
+	return h, err
+}
+
+func (img *convertedImage) Manifest() (*registryv1.Manifest, error) {
+	return img.manifest, nil
+}
+
+func (img *convertedImage) RawManifest() ([]byte, error) {
+	return img.rawManifest, nil
+}
+
+func (img *convertedImage) descriptor() api.Descriptor {
+	return rawManifestDescriptor(img.manifest.MediaType, img.rawManifest)
+}
+
+func (img *convertedImage) manifestDescriptors() map[string]api.Descriptor {
+	return map[string]api.Descriptor{img.original.Digest: img.descriptor()}
+}
+
+type convertedIndex struct {
+	original    api.Descriptor
+	rawManifest []byte
+	manifest    *registryv1.IndexManifest
+	images      map[registryv1.Hash]*convertedImage
+}
+
+func convertIndex(idx registryv1.ImageIndex, format ManifestFormat) (*convertedIndex, error) {
+	rawOriginal, err := idx.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	original := rawManifestDescriptor("", rawOriginal)
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	mediaType, err := idx.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	converted := *manifest
+	if converted.MediaType, err = format.mediaType(mediaType); err != nil {
+		return nil, fmt.Errorf("converting index %s: %w", original.Digest, err)
+	}
+	changed := converted.MediaType != mediaType
+	converted.Manifests = make([]registryv1.Descriptor, len(manifest.Manifests))
+	images := make(map[registryv1.Hash]*convertedImage, len(manifest.Manifests))
+	for i, desc := range manifest.Manifests {
+		if !desc.MediaType.IsImage() {
+			return nil, fmt.Errorf("converting index %s: unsupported child manifest %s with media type %s", original.Digest, desc.Digest, desc.MediaType)
+		}
+		child, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		convertedChild, err := convertImage(child, format)
+		if err != nil {
+			return nil, err
+		}
+		childDesc := convertedChild.descriptor()
+		digest, err := registryv1.NewHash(childDesc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		// keep platform and annotations of the original descriptor
+		converted.Manifests[i] = desc
+		converted.Manifests[i].MediaType = registrytypes.MediaType(childDesc.MediaType)
+		converted.Manifests[i].Digest = digest
+		converted.Manifests[i].Size = childDesc.Size
+		images[digest] = convertedChild
+		changed = changed || digest != desc.Digest
+	}
+	rawManifest, err := marshalIfChanged(rawOriginal, changed, &converted)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling converted index %s: %w", original.Digest, err)
+	}
+	return &convertedIndex{
+		original:    original,
+		rawManifest: rawManifest,
+		manifest:    &converted,
+		images:      images,
+	}, nil
+}
+
+func (idx *convertedIndex) MediaType() (registrytypes.MediaType, error) {
+	return idx.manifest.MediaType, nil
+}
+
+func (idx *convertedIndex) Size() (int64, error) {
+	return int64(len(idx.rawManifest)), nil
+}
+
+func (idx *convertedIndex) Digest() (registryv1.Hash, error) {
+	h, _, err := registryv1.SHA256(bytes.NewReader(idx.rawManifest))
+	return h, err
+}
+
+func (idx *convertedIndex) IndexManifest() (*registryv1.IndexManifest, error) {
+	return idx.manifest, nil
+}
+
+func (idx *convertedIndex) RawManifest() ([]byte, error) {
+	return idx.rawManifest, nil
+}
+
+func (idx *convertedIndex) Image(digest registryv1.Hash) (registryv1.Image, error) {
+	if img, ok := idx.images[digest]; ok {
+		return img, nil
+	}
+	return nil, fmt.Errorf("image %s not found in converted index", digest)
+}
+
+func (idx *convertedIndex) ImageIndex(digest registryv1.Hash) (registryv1.ImageIndex, error) {
+	return nil, fmt.Errorf("nested index %s is not supported in converted index", digest)
+}
+
+func (idx *convertedIndex) descriptor() api.Descriptor {
+	return rawManifestDescriptor(idx.manifest.MediaType, idx.rawManifest)
+}
+
+func (idx *convertedIndex) manifestDescriptors() map[string]api.Descriptor {
+	descriptors := map[string]api.Descriptor{idx.original.Digest: idx.descriptor()}
+	for _, img := range idx.images {
+		descriptors[img.original.Digest] = img.descriptor()
+	}
+	return descriptors
+}
+
+// mediaTypeLayer is a layer with a rewritten media type.
+// The blob (and its digest) stays the same.
+type mediaTypeLayer struct {
+	registryv1.Layer
+	mediaType registrytypes.MediaType
+}
+
+func (l mediaTypeLayer) MediaType() (registrytypes.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// marshalIfChanged keeps the original manifest if no media type was rewritten,
+// so that pushing in the format an image was built in keeps its digest.
+func marshalIfChanged(rawOriginal []byte, changed bool, converted any) ([]byte, error) {
+	if !changed {
+		return rawOriginal, nil
+	}
+	return json.Marshal(converted)
+}
+
+func rawManifestDescriptor(mediaType registrytypes.MediaType, rawManifest []byte) api.Descriptor {
+	h, size, _ := registryv1.SHA256(bytes.NewReader(rawManifest))
+	return api.Descriptor{
+		MediaType: string(mediaType),
+		Digest:    h.String(),
+		Size:      size,
+	}
+}
+
+// convertOperation returns the taggable to push for the operation,
+// along with a copy of the operation whose descriptors match the pushed format.
+func (u *uploader) convertOperation(op api.IndexedPushDeployOperation) (api.IndexedPushDeployOperation, remote.Taggable, error) {
+	digest, err := registryv1.NewHash(op.Root.Digest)
+	if err != nil {
+		return op, nil, err
+	}
+	taggable, err := u.vfs.Taggable(digest)
+	if err != nil {
+		return op, nil, err
+	}
+	if u.manifestFormat == ManifestFormatUnchanged {
+		return op, taggable, nil
+	}
+	converted, err := convertTaggable(taggable, u.manifestFormat)
+	if err != nil {
+		return op, nil, err
+	}
+	descriptors := converted.manifestDescriptors()
+	op.Root = converted.descriptor()
+	manifests := make([]api.ManifestDeployInfo, len(op.Manifests))
+	for i, manifest := range op.Manifests {
+		if desc, ok := descriptors[manifest.Descriptor.Digest]; ok {
+			manifest.Descriptor = desc
+		}
+		if manifest.Config, err = u.manifestFormat.apiDescriptor(manifest.Config); err != nil {
+			return op, nil, err
+		}
+		layers := make([]api.Descriptor, len(manifest.LayerBlobs))
+		for j, layer := range manifest.LayerBlobs {
+			if layers[j], err = u.manifestFormat.apiDescriptor(layer); err != nil {
+				return op, nil, err
+			}
+		}
+		manifest.LayerBlobs = layers
+		manifests[i] = manifest
+	}
+	op.Manifests = manifests
+	return op, converted, nil
+}
+
+func (f ManifestFormat) apiDescriptor(desc api.Descriptor) (api.Descriptor, error) {
+	mediaType, err := f.mediaType(registrytypes.MediaType(desc.MediaType))
+	if err != nil {
+		return desc, fmt.Errorf("converting %s: %w", desc.Digest, err)
+	}
+	desc.MediaType = string(mediaType)
+	return desc, nil
+}
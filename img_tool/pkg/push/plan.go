@@ -0,0 +1,86 @@
+package push
+
+import (
+	"fmt"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// PlannedBlob is a blob (or manifest) that a push would upload,
+// along with the source its data would be read from.
+type PlannedBlob struct {
+	api.Descriptor
+	// Source is one of "file", "registry", "remote_cache", or "stub".
+	Source string `json:"source"`
+}
+
+// PlannedPush describes what a single push operation would do.
+type PlannedPush struct {
+	Root       api.Descriptor `json:"root"`
+	References []string       `json:"references"`
+	Blobs      []PlannedBlob  `json:"blobs"`
+}
+
+// Plan computes the references and blobs of the given push operations without contacting the target registry.
+// Blobs that already exist in the target registry are skipped during a real push,
+// so the plan is an upper bound of the data that is uploaded.
+func (u *uploader) Plan(ops []api.IndexedPushDeployOperation, strategy string) ([]PlannedPush, error) {
+	plans := make([]PlannedPush, 0, len(ops))
+	for _, op := range ops {
+		if u.manifestFormat != ManifestFormatUnchanged {
+			// The plan shows the digests of the converted manifests.
+			var err error
+			if op, _, err = u.convertOperation(op); err != nil {
+				return nil, err
+			}
+		}
+		refs, err := u.tags(op)
+		if err != nil {
+			return nil, err
+		}
+		plan := PlannedPush{Root: op.Root}
+		for _, ref := range refs {
+			plan.References = append(plan.References, ref.String())
+		}
+		if strategy != "bes" {
+			// With the bes strategy, blobs are uploaded by the Build Event Service
+			// and never by the push tool itself.
+			blobs, err := u.planBlobs(op)
+			if err != nil {
+				return nil, err
+			}
+			plan.Blobs = blobs
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (u *uploader) planBlobs(op api.IndexedPushDeployOperation) ([]PlannedBlob, error) {
+	descriptors := []api.Descriptor{op.Root}
+	for _, manifest := range op.Manifests {
+		descriptors = append(descriptors, manifest.Descriptor, manifest.Config)
+		descriptors = append(descriptors, manifest.LayerBlobs...)
+	}
+
+	seen := make(map[string]struct{}, len(descriptors))
+	var blobs []PlannedBlob
+	for _, desc := range descriptors {
+		if _, ok := seen[desc.Digest]; ok {
+			continue
+		}
+		seen[desc.Digest] = struct{}{}
+		digest, err := registryv1.NewHash(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		source, err := u.vfs.Location(digest)
+		if err != nil {
+			return nil, fmt.Errorf("locating source of blob %s: %w", desc.Digest, err)
+		}
+		blobs = append(blobs, PlannedBlob{Descriptor: desc, Source: source})
+	}
+	return blobs, nil
+}
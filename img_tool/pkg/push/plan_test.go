@@ -0,0 +1,224 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/deployvfs"
+)
+
+func TestPlan(t *testing.T) {
+	layoutDir, op := writeTestLayout(t, "eager")
+	op.Tags = []string{"v1", "latest"}
+	uploader := NewBuilder(testVFS(t, layoutDir, op)).WithExtraTags([]string{"v1", "extra"}).Build()
+
+	plans, err := uploader.Plan([]api.IndexedPushDeployOperation{op}, "eager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("Plan() returned %d plans, want 1", len(plans))
+	}
+	plan := plans[0]
+	wantRefs := []string{
+		"registry.example/app@" + op.Root.Digest,
+		"registry.example/app:extra",
+		"registry.example/app:latest",
+		"registry.example/app:v1",
+	}
+	if len(plan.References) != len(wantRefs) {
+		t.Fatalf("References = %q, want %q", plan.References, wantRefs)
+	}
+	for i := range wantRefs {
+		if plan.References[i] != wantRefs[i] {
+			t.Errorf("References[%d] = %q, want %q", i, plan.References[i], wantRefs[i])
+		}
+	}
+
+	// the root is the manifest, so it is listed once, followed by the config and the layers
+	manifest := op.Manifests[0]
+	wantBlobs := append([]api.Descriptor{manifest.Descriptor, manifest.Config}, manifest.LayerBlobs...)
+	if len(plan.Blobs) != len(wantBlobs) {
+		t.Fatalf("Blobs = %+v, want %d blobs", plan.Blobs, len(wantBlobs))
+	}
+	for i, want := range wantBlobs {
+		got := plan.Blobs[i]
+		if got.Digest != want.Digest || got.Size != want.Size || got.Source != "file" {
+			t.Errorf("Blobs[%d] = %s (%d bytes from %s), want %s (%d bytes from file)", i, got.Digest, got.Size, got.Source, want.Digest, want.Size)
+		}
+	}
+}
+
+func TestPlanBES(t *testing.T) {
+	layoutDir, op := writeTestLayout(t, "bes")
+	uploader := NewBuilder(testVFS(t, layoutDir, op)).Build()
+
+	plans, err := uploader.Plan([]api.IndexedPushDeployOperation{op}, "bes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 || len(plans[0].References) != 1 {
+		t.Fatalf("Plan() = %+v, want a single plan with the digest reference", plans)
+	}
+	if len(plans[0].Blobs) != 0 {
+		t.Errorf("Plan() with the bes strategy lists blobs %+v, want none", plans[0].Blobs)
+	}
+}
+
+func TestPlanSharedLayers(t *testing.T) {
+	layoutDir := t.TempDir()
+	amd64 := writeTestImage(t, layoutDir)
+	arm64 := writeTestImage(t, layoutDir)
+	// a platform-independent layer is shared by the manifests of all platforms
+	shared := amd64.LayerBlobs[0]
+	arm64.LayerBlobs = []api.Descriptor{shared, arm64.LayerBlobs[1]}
+	rawIndex := []byte(`{"schemaVersion":2}`)
+	indexDigest, _, err := registryv1.SHA256(bytes.NewReader(rawIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := api.IndexedPushDeployOperation{
+		Strategy: "eager",
+		PushDeployOperation: api.PushDeployOperation{
+			BaseCommandOperation: api.BaseCommandOperation{
+				Command:   "push",
+				RootKind:  "index",
+				Root:      writeTestBlob(t, layoutDir, "application/vnd.oci.image.index.v1+json", indexDigest, rawIndex),
+				Manifests: []api.ManifestDeployInfo{amd64, arm64},
+			},
+			PushTarget: api.PushTarget{Registry: "registry.example", Repository: "app"},
+		},
+	}
+	uploader := NewBuilder(testVFS(t, layoutDir, op)).Build()
+
+	plans, err := uploader.Plan([]api.IndexedPushDeployOperation{op}, "eager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("Plan() returned %d plans, want 1", len(plans))
+	}
+	wantBlobs := []api.Descriptor{
+		op.Root,
+		amd64.Descriptor, amd64.Config, amd64.LayerBlobs[0], amd64.LayerBlobs[1],
+		arm64.Descriptor, arm64.Config, arm64.LayerBlobs[1],
+	}
+	blobs := plans[0].Blobs
+	if len(blobs) != len(wantBlobs) {
+		t.Fatalf("Blobs = %+v, want %d blobs", blobs, len(wantBlobs))
+	}
+	for i, want := range wantBlobs {
+		if blobs[i].Digest != want.Digest {
+			t.Errorf("Blobs[%d] = %s, want %s", i, blobs[i].Digest, want.Digest)
+		}
+	}
+}
+
+// writeTestLayout writes the blobs of a random image to an OCI layout directory
+// and returns the push operation of the image to registry.example/app.
+func writeTestLayout(t *testing.T, strategy string) (string, api.IndexedPushDeployOperation) {
+	t.Helper()
+	layoutDir := t.TempDir()
+	info := writeTestImage(t, layoutDir)
+	op := api.IndexedPushDeployOperation{
+		Strategy: strategy,
+		PushDeployOperation: api.PushDeployOperation{
+			BaseCommandOperation: api.BaseCommandOperation{
+				Command:   "push",
+				RootKind:  "manifest",
+				Root:      info.Descriptor,
+				Manifests: []api.ManifestDeployInfo{info},
+			},
+			PushTarget: api.PushTarget{Registry: "registry.example", Repository: "app"},
+		},
+	}
+	return layoutDir, op
+}
+
+// writeTestImage writes the blobs of a random image to an OCI layout directory
+// and returns its deploy info.
+func writeTestImage(t *testing.T, layoutDir string) api.ManifestDeployInfo {
+	t.Helper()
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBlob := func(mediaType string, digest registryv1.Hash, data []byte) api.Descriptor {
+		return writeTestBlob(t, layoutDir, mediaType, digest, data)
+	}
+
+	rawManifest, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := api.ManifestDeployInfo{
+		Descriptor: writeBlob(string(manifest.MediaType), manifestDigest, rawManifest),
+		Config:     writeBlob(string(manifest.Config.MediaType), manifest.Config.Digest, rawConfig),
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.LayerBlobs = append(info.LayerBlobs, writeBlob(string(manifest.Layers[i].MediaType), manifest.Layers[i].Digest, data))
+	}
+	return info
+}
+
+func writeTestBlob(t *testing.T, layoutDir, mediaType string, digest registryv1.Hash, data []byte) api.Descriptor {
+	t.Helper()
+	dir := filepath.Join(layoutDir, "blobs", digest.Algorithm)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, digest.Hex), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return api.Descriptor{MediaType: mediaType, Digest: digest.String(), Size: int64(len(data))}
+}
+
+func testVFS(t *testing.T, layoutDir string, op api.IndexedPushDeployOperation) *deployvfs.VFS {
+	t.Helper()
+	rawOp, err := json.Marshal(op.PushDeployOperation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm := api.DeployManifest{
+		Operations: []json.RawMessage{rawOp},
+		Settings:   api.DeploySettings{PushStrategy: op.Strategy},
+	}
+	vfs, err := deployvfs.Builder(dm).WithLayout(layoutDir).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vfs
+}
@@ -0,0 +1,90 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// mountMissingBlobs asks the CAS registry to mount the layers of shallow base images from their original registry.
+// Those layers were never downloaded, so they are not in the CAS. A CAS registry with pull-through enabled
+// remembers where they come from and pulls them through when they are requested.
+// Errors are not fatal: layers that could not be mounted are uploaded (or mounted by the registry client) as usual.
+func (u *uploader) mountMissingBlobs(ctx context.Context, ops []api.IndexedPushDeployOperation) error {
+	var mounted, total int
+	for _, op := range ops {
+		if len(op.OriginalBaseImageRegistries) == 0 || op.OriginalBaseImageRepository == "" {
+			continue
+		}
+		refs, err := u.tags(op)
+		if err != nil {
+			return err
+		}
+		repo := refs[0].Context()
+		var digests []string
+		for _, manifest := range op.Manifests {
+			for _, hex := range manifest.MissingBlobs {
+				digest := "sha256:" + hex
+				if !slices.Contains(digests, digest) && !u.blobKnownToExist(repo, digest) {
+					digests = append(digests, digest)
+				}
+			}
+		}
+		if len(digests) == 0 {
+			continue
+		}
+		client, err := newBlobClient(ctx, repo, reg.MultiKeychain(), transport.PushScope)
+		if err != nil {
+			slog.Warn("Skipping mounts of base image layers", "repository", repo, logging.ErrKey, err)
+			continue
+		}
+		var from []string
+		for _, registry := range op.OriginalBaseImageRegistries {
+			from = append(from, registry+"/"+op.OriginalBaseImageRepository)
+		}
+		for _, digest := range digests {
+			total++
+			ok, err := client.mountFrom(ctx, digest, from)
+			if err != nil {
+				slog.Warn("Failed to mount base image layer", "digest", digest, "from", op.OriginalBaseImageRepository, logging.ErrKey, err)
+				continue
+			}
+			if ok {
+				mounted++
+				u.markBlobExists(repo, digest)
+			}
+		}
+	}
+	if total > 0 {
+		slog.Info("Mounted base image layers from their original registry", "mounted", mounted, "total", total)
+	}
+	return nil
+}
+
+// mountFrom sends a cross-repository mount of the blob from the given repositories
+// and reports whether the registry mounted it (instead of starting an upload).
+func (c *blobClient) mountFrom(ctx context.Context, digest string, from []string) (bool, error) {
+	query := url.Values{"mount": {digest}, "from": from}
+	resp, err := c.do(ctx, http.MethodPost, c.url(fmt.Sprintf("/v2/%s/blobs/uploads/", c.repo.RepositoryStr()))+"?"+query.Encode(), nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// The registry started an upload session instead, which is abandoned.
+		return false, nil
+	}
+	return false, transport.CheckError(resp, http.StatusCreated, http.StatusAccepted)
+}
@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"slices"
 	"sort"
+	"sync"
 
+	"github.com/malt3/go-containerregistry/pkg/authn"
 	"github.com/malt3/go-containerregistry/pkg/name"
 	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
 	"github.com/malt3/go-containerregistry/pkg/v1/remote"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/proto/blobcache"
 	blobcache_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/blobcache"
 	remoteexecution_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/remote-apis/build/bazel/remote/execution/v2"
@@ -24,6 +27,14 @@ type builder struct {
 	overrideRepository string
 	extraTags          []string
 	remoteOptions      []remote.Option
+	webhookSecret      []byte
+	manifestFormat     ManifestFormat
+	capabilityKeychain authn.Keychain
+	clock              hermetic.Clock
+	chunkSize          int64
+	chunkKeychain      authn.Keychain
+	existenceKeychain  authn.Keychain
+	jobs               int
 }
 
 func NewBuilder(vfs vfs) *builder {
@@ -55,6 +66,61 @@ func (b *builder) WithRemoteOptions(opts ...remote.Option) *builder {
 	return b
 }
 
+// WithWebhookSecret sets the secret used to sign the payloads sent to post-push webhooks.
+func (b *builder) WithWebhookSecret(secret []byte) *builder {
+	b.webhookSecret = secret
+	return b
+}
+
+// WithManifestFormat rewrites the media types of pushed manifests to the given format.
+// The digests of converted manifests differ from the digests of the built manifests.
+func (b *builder) WithManifestFormat(format ManifestFormat) *builder {
+	b.manifestFormat = format
+	return b
+}
+
+// WithCapabilityCheck probes every target registry before pushing,
+// using keychain to authenticate, and prints a summary of its capabilities.
+// Unreachable registries and missing push permissions are reported before any blob is uploaded.
+func (b *builder) WithCapabilityCheck(keychain authn.Keychain) *builder {
+	b.capabilityKeychain = keychain
+	return b
+}
+
+// WithChunkedUpload uploads layers larger than chunkSize in chunks of (at least) chunkSize bytes.
+// Interrupted uploads are resumed from the offset the registry received last,
+// which makes pushing very large layers robust against connection resets.
+// The keychain is used to authenticate the uploads. A chunkSize <= 0 disables chunked uploads.
+func (b *builder) WithChunkedUpload(chunkSize int64, keychain authn.Keychain) *builder {
+	b.chunkSize = chunkSize
+	b.chunkKeychain = keychain
+	return b
+}
+
+// WithExistingBlobCheck checks which layers already exist in the target repositories
+// before uploading, using keychain to authenticate. All layers are checked in parallel
+// and existing layers are left out of the upload.
+func (b *builder) WithExistingBlobCheck(keychain authn.Keychain) *builder {
+	b.existenceKeychain = keychain
+	return b
+}
+
+// WithJobs limits the number of blobs that are uploaded concurrently, across all images of a push.
+// Chunked uploads and the blobs, manifests and tags written afterwards share the limit.
+// Blobs are always uploaded before the manifests referencing them, manifests before the index
+// referencing them, and tags are written last, so that registries never see a dangling reference.
+// A value <= 0 uses DefaultJobs.
+func (b *builder) WithJobs(jobs int) *builder {
+	b.jobs = jobs
+	return b
+}
+
+// WithClock sets the clock used for the invocation time reported to webhooks.
+func (b *builder) WithClock(clock hermetic.Clock) *builder {
+	b.clock = clock
+	return b
+}
+
 func (b *builder) Build() *uploader {
 	return &uploader{
 		blobcacheClient:    b.blobcacheClient,
@@ -63,6 +129,14 @@ func (b *builder) Build() *uploader {
 		overrideRepository: b.overrideRepository,
 		extraTags:          b.extraTags,
 		remoteOptions:      b.remoteOptions,
+		webhookSecret:      b.webhookSecret,
+		manifestFormat:     b.manifestFormat,
+		capabilityKeychain: b.capabilityKeychain,
+		clock:              hermetic.ClockOrSystem(b.clock),
+		chunkSize:          b.chunkSize,
+		chunkKeychain:      b.chunkKeychain,
+		existenceKeychain:  b.existenceKeychain,
+		jobs:               jobsOrDefault(b.jobs),
 	}
 }
 
@@ -73,42 +147,100 @@ type uploader struct {
 	overrideRepository string
 	extraTags          []string
 	remoteOptions      []remote.Option
+	webhookSecret      []byte
+	manifestFormat     ManifestFormat
+	capabilityKeychain authn.Keychain
+	clock              hermetic.Clock
+	chunkSize          int64
+	chunkKeychain      authn.Keychain
+	existenceKeychain  authn.Keychain
+	jobs               int
+
+	// capabilities caches the probed capabilities per registry.
+	capabilities    map[string]RegistryCapabilities
+	capabilitiesMux sync.Mutex
+
+	// existingBlobs caches the layers per (registry, repository, digest)
+	// that were found in or uploaded to a target repository.
+	existingBlobs    map[string]bool
+	existingBlobsMux sync.Mutex
+	// transfers records the transfer status of blobs per (registry, repository, digest), see Result.
+	transfers map[string]string
+
+	// results of the operations pushed by the last call to PushAll.
+	results []Result
 }
 
 func (u *uploader) PushAll(ctx context.Context, ops []api.IndexedPushDeployOperation, strategy string) ([]string, error) {
 	if strategy == "bes" {
 		return nil, nil // nothing to do
 	}
+	var targets []name.Reference
+	for _, op := range ops {
+		refs, err := u.tags(op)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, refs...)
+	}
+	if err := u.checkCapabilities(ctx, targets); err != nil {
+		return nil, err
+	}
 	if err := u.strategyPreHooks(ctx, ops, strategy); err != nil {
 		return nil, err
 	}
-	todo := make(map[name.Reference]remote.Taggable)
 	var allTags []string
 
 	// collect all operations
-	for _, op := range ops {
-		digest, err := registryv1.NewHash(op.Root.Digest)
+	pushedOps := make([]api.IndexedPushDeployOperation, len(ops))
+	taggables := make([]remote.Taggable, len(ops))
+	for i, op := range ops {
+		op, taggable, err := u.convertOperation(op)
 		if err != nil {
 			return nil, err
 		}
-		refs, err := u.tags(op)
-		if err != nil {
+		pushedOps[i] = op
+		taggables[i] = taggable
+	}
+
+	if strategy == "eager" || strategy == "lazy" {
+		if err := u.checkExistingBlobs(ctx, pushedOps); err != nil {
+			return nil, err
+		}
+		if err := u.uploadLargeBlobs(ctx, pushedOps); err != nil {
 			return nil, err
 		}
-		taggable, err := u.vfs.Taggable(digest)
+	}
+
+	todo := make(map[name.Reference]remote.Taggable)
+	for i, op := range pushedOps {
+		refs, err := u.tags(op)
 		if err != nil {
 			return nil, err
 		}
+		taggable := u.withoutExistingLayers(refs[0].Context(), taggables[i])
 		for _, ref := range refs {
 			todo[ref] = taggable
-		}
-		for _, ref := range refs {
 			allTags = append(allTags, ref.String())
 		}
 	}
 
-	// push all collected tags in parallel
-	return allTags, remote.MultiWrite(todo, u.remoteOptions...)
+	// push all collected tags in parallel, uploading at most u.jobs blobs at once
+	if err := remote.MultiWrite(todo, append(slices.Clone(u.remoteOptions), remote.WithJobs(u.jobs))...); err != nil {
+		return nil, err
+	}
+	u.results = make([]Result, len(pushedOps))
+	for i, op := range pushedOps {
+		result, err := u.result(op)
+		if err != nil {
+			return nil, err
+		}
+		u.results[i] = result
+	}
+	if err := u.notifyWebhooks(ctx, pushedOps); err != nil {
+		return allTags, fmt.Errorf("images were pushed, but notifying webhooks failed: %w", err)
+	}
+	return allTags, nil
 }
 
 // tags returns the list of tags to push for the given operation, applying any overrides and extra tags.
@@ -190,13 +322,25 @@ func (u *uploader) casRegistryPreHook(ctx context.Context, ops []api.IndexedPush
 	if err != nil {
 		return fmt.Errorf("committing blobs to CAS registry: %w", err)
 	}
-	return nil
+	return u.mountMissingBlobs(ctx, ops)
+}
+
+// DefaultJobs is the number of concurrent uploads if WithJobs is not used.
+const DefaultJobs = 4
+
+func jobsOrDefault(jobs int) int {
+	if jobs <= 0 {
+		return DefaultJobs
+	}
+	return jobs
 }
 
 type vfs interface {
 	Taggable(digest registryv1.Hash) (remote.Taggable, error)
+	Layer(digest registryv1.Hash) (registryv1.Layer, error)
 	Digests() ([]registryv1.Hash, error)
 	SizeOf(digest registryv1.Hash) (int64, error)
+	Location(digest registryv1.Hash) (string, error)
 }
 
 // deduplicateAndSort removes duplicates and sorts a slice of strings
@@ -0,0 +1,95 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// concurrencyRegistry is an in-memory registry that records the highest number of concurrent blob requests
+// and the order in which blobs and manifests are written.
+type concurrencyRegistry struct {
+	handler http.Handler
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	writes      []string
+}
+
+func (r *concurrencyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.Contains(req.URL.Path, "/blobs/") {
+		if req.Method == http.MethodPut {
+			r.record("manifest")
+		}
+		r.handler.ServeHTTP(w, req)
+		return
+	}
+	r.mu.Lock()
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+	r.mu.Unlock()
+	// Give concurrent uploads the chance to overlap.
+	time.Sleep(20 * time.Millisecond)
+	r.handler.ServeHTTP(w, req)
+	if req.Method == http.MethodPut {
+		r.record("blob")
+	}
+	r.mu.Lock()
+	r.inFlight--
+	r.mu.Unlock()
+}
+
+func (r *concurrencyRegistry) record(write string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes = append(r.writes, write)
+}
+
+func TestPushAllJobs(t *testing.T) {
+	for _, jobs := range []int{1, 2} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			reg := &concurrencyRegistry{handler: registry.New(registry.Logger(log.New(io.Discard, "", 0)))}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+			layoutDir, op := writeTestLayout(t, "eager")
+			uploader := NewBuilder(testVFS(t, layoutDir, op)).
+				WithOverrideRegistry(strings.TrimPrefix(server.URL, "http://")).
+				WithJobs(jobs).
+				Build()
+			if _, err := uploader.PushAll(context.Background(), []api.IndexedPushDeployOperation{op}, "eager"); err != nil {
+				t.Fatalf("PushAll() error = %v", err)
+			}
+
+			if reg.maxInFlight > jobs {
+				t.Errorf("%d concurrent blob requests, want at most %d", reg.maxInFlight, jobs)
+			}
+			// the config and two layers are uploaded before the manifest referencing them
+			if want := []string{"blob", "blob", "blob", "manifest"}; strings.Join(reg.writes, ",") != strings.Join(want, ",") {
+				t.Errorf("writes = %v, want %v", reg.writes, want)
+			}
+		})
+	}
+}
+
+func TestJobsOrDefault(t *testing.T) {
+	for jobs, want := range map[int]int{-1: DefaultJobs, 0: DefaultJobs, 1: 1, 16: 16} {
+		if got := jobsOrDefault(jobs); got != want {
+			t.Errorf("jobsOrDefault(%d) = %d, want %d", jobs, got, want)
+		}
+	}
+	if got := NewBuilder(nil).Build().jobs; got != DefaultJobs {
+		t.Errorf("jobs without WithJobs = %d, want %d", got, DefaultJobs)
+	}
+}
@@ -0,0 +1,106 @@
+package push
+
+import (
+	"fmt"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// Transfer statuses of blobs in a Result.
+const (
+	// BlobExisting blobs were found in the target repository before the upload and skipped.
+	BlobExisting = "existing"
+	// BlobChunked blobs were uploaded in chunks.
+	BlobChunked = "chunked"
+	// BlobPushed blobs were handed to the registry client, which uploads them,
+	// mounts them from another repository, or skips them if the registry already has them.
+	BlobPushed = "pushed"
+)
+
+// Result describes a completed push operation in machine-readable form.
+type Result struct {
+	Registry   string   `json:"registry"`
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	MediaType  string   `json:"media_type"`
+	Tags       []string `json:"tags"`
+	// References are the fully qualified references that were pushed (by digest and by tag).
+	References []string       `json:"references"`
+	Blobs      []BlobTransfer `json:"blobs"`
+}
+
+// BlobTransfer describes how a blob (or manifest) of a push operation was transferred.
+type BlobTransfer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type,omitempty"`
+	Size      int64  `json:"size"`
+	// Source is one of "file", "registry", "remote_cache", or "stub" (see PlannedBlob).
+	Source string `json:"source"`
+	// Status is one of BlobExisting, BlobChunked, or BlobPushed.
+	Status string `json:"status"`
+}
+
+// Results returns the results of the operations pushed by the last call to PushAll.
+// Operations of the bes strategy are pushed by the Build Event Service and have no results.
+func (u *uploader) Results() []Result {
+	return u.results
+}
+
+// recordTransfer remembers how a blob was transferred to a repository for the results of the push.
+func (u *uploader) recordTransfer(repo name.Repository, digest, status string) {
+	u.existingBlobsMux.Lock()
+	defer u.existingBlobsMux.Unlock()
+	if u.transfers == nil {
+		u.transfers = make(map[string]string)
+	}
+	u.transfers[blobInRepository{repo: repo, digest: digest}.key()] = status
+}
+
+func (u *uploader) transferStatus(repo name.Repository, digest string) string {
+	u.existingBlobsMux.Lock()
+	defer u.existingBlobsMux.Unlock()
+	if status, ok := u.transfers[blobInRepository{repo: repo, digest: digest}.key()]; ok {
+		return status
+	}
+	return BlobPushed
+}
+
+// result builds the result of a pushed operation.
+func (u *uploader) result(op api.IndexedPushDeployOperation) (Result, error) {
+	refs, err := u.tags(op)
+	if err != nil {
+		return Result{}, err
+	}
+	repo := refs[0].Context()
+	result := Result{
+		Registry:   repo.RegistryStr(),
+		Repository: repo.RepositoryStr(),
+		Digest:     op.Root.Digest,
+		MediaType:  op.Root.MediaType,
+		Tags:       []string{},
+		References: make([]string, 0, len(refs)),
+	}
+	for _, ref := range refs {
+		result.References = append(result.References, ref.String())
+		if tag, ok := ref.(name.Tag); ok {
+			result.Tags = append(result.Tags, tag.TagStr())
+		}
+	}
+	blobs, err := u.planBlobs(op)
+	if err != nil {
+		return Result{}, fmt.Errorf("collecting blobs of %s: %w", op.Root.Digest, err)
+	}
+	result.Blobs = make([]BlobTransfer, len(blobs))
+	for i, blob := range blobs {
+		result.Blobs[i] = BlobTransfer{
+			Digest:    blob.Digest,
+			MediaType: blob.MediaType,
+			Size:      blob.Size,
+			Source:    blob.Source,
+			Status:    u.transferStatus(repo, blob.Digest),
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package push
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func TestResults(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	layoutDir, op := writeTestLayout(t, "eager")
+	op.Tags = []string{"v1"}
+	uploader := NewBuilder(testVFS(t, layoutDir, op)).
+		WithOverrideRegistry(host).
+		WithExtraTags([]string{"latest"}).
+		Build()
+	if _, err := uploader.PushAll(context.Background(), []api.IndexedPushDeployOperation{op}, "eager"); err != nil {
+		t.Fatalf("PushAll() error = %v", err)
+	}
+
+	results := uploader.Results()
+	if len(results) != 1 {
+		t.Fatalf("Results() = %+v, want one result", results)
+	}
+	result := results[0]
+	if result.Registry != host || result.Repository != "app" || result.Digest != op.Root.Digest || result.MediaType != op.Root.MediaType {
+		t.Errorf("Results() = %s/%s@%s (%s), want %s/app@%s (%s)", result.Registry, result.Repository, result.Digest, result.MediaType, host, op.Root.Digest, op.Root.MediaType)
+	}
+	if want := []string{"latest", "v1"}; !reflect.DeepEqual(result.Tags, want) {
+		t.Errorf("Results() tags = %q, want %q", result.Tags, want)
+	}
+	wantRefs := []string{host + "/app@" + op.Root.Digest, host + "/app:latest", host + "/app:v1"}
+	if !reflect.DeepEqual(result.References, wantRefs) {
+		t.Errorf("Results() references = %q, want %q", result.References, wantRefs)
+	}
+
+	// the existing status is covered by TestCheckExistingBlobs
+	manifest := op.Manifests[0]
+	var want []BlobTransfer
+	for _, blob := range append([]api.Descriptor{manifest.Descriptor, manifest.Config}, manifest.LayerBlobs...) {
+		want = append(want, BlobTransfer{Digest: blob.Digest, MediaType: blob.MediaType, Size: blob.Size, Source: "file", Status: BlobPushed})
+	}
+	if !reflect.DeepEqual(result.Blobs, want) {
+		t.Errorf("Results() blobs = %+v, want %+v", result.Blobs, want)
+	}
+}
+
+func TestTransferStatus(t *testing.T) {
+	u := NewBuilder(nil).Build()
+	repo, err := name.NewRepository("registry.example/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := name.NewRepository("registry.example/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.recordTransfer(repo, "sha256:aaaa", BlobChunked)
+	if got := u.transferStatus(repo, "sha256:aaaa"); got != BlobChunked {
+		t.Errorf("transferStatus() = %q, want %q", got, BlobChunked)
+	}
+	// statuses are per repository, blobs without a recorded status were pushed by the registry client
+	if got := u.transferStatus(other, "sha256:aaaa"); got != BlobPushed {
+		t.Errorf("transferStatus() in another repository = %q, want %q", got, BlobPushed)
+	}
+}
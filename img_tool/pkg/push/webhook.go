@@ -0,0 +1,164 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
+)
+
+const (
+	// WebhookSignatureHeader carries the hex-encoded HMAC-SHA256 of the request body,
+	// prefixed with "sha256=".
+	WebhookSignatureHeader = "X-Img-Signature-256"
+
+	webhookMaxAttempts    = 3
+	webhookInitialBackoff = time.Second
+	webhookTimeout        = 30 * time.Second
+)
+
+// WebhookPayload is the JSON body sent to post-push webhooks.
+type WebhookPayload struct {
+	Registry   string                `json:"registry"`
+	Repository string                `json:"repository"`
+	Digest     string                `json:"digest"`
+	MediaType  string                `json:"media_type"`
+	Tags       []string              `json:"tags,omitempty"`
+	References []string              `json:"references"`
+	Invocation WebhookInvocationInfo `json:"invocation"`
+}
+
+// WebhookInvocationInfo describes the push invocation that triggered a webhook.
+type WebhookInvocationInfo struct {
+	Host      string    `json:"host,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Workspace string    `json:"workspace,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// notifyWebhooks sends a payload to each webhook of the given push operations.
+// All webhooks are tried, even if some of them fail.
+func (u *uploader) notifyWebhooks(ctx context.Context, ops []api.IndexedPushDeployOperation) error {
+	invocation := currentInvocation(u.clock)
+	var errs []error
+	for _, op := range ops {
+		if len(op.Webhooks) == 0 {
+			continue
+		}
+		payload, err := u.webhookPayload(op, invocation)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("marshalling webhook payload: %w", err)
+		}
+		for _, url := range op.Webhooks {
+			if err := u.sendWebhook(ctx, url, body); err != nil {
+				errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (u *uploader) webhookPayload(op api.IndexedPushDeployOperation, invocation WebhookInvocationInfo) (WebhookPayload, error) {
+	refs, err := u.tags(op)
+	if err != nil {
+		return WebhookPayload{}, err
+	}
+	payload := WebhookPayload{
+		Registry:   op.Registry,
+		Repository: op.Repository,
+		Digest:     op.Root.Digest,
+		MediaType:  op.Root.MediaType,
+		Tags:       deduplicateAndSort(append(append([]string{}, op.Tags...), u.extraTags...)),
+		Invocation: invocation,
+	}
+	if u.overrideRegistry != "" {
+		payload.Registry = u.overrideRegistry
+	}
+	if u.overrideRepository != "" {
+		payload.Repository = u.overrideRepository
+	}
+	for _, ref := range refs {
+		payload.References = append(payload.References, ref.String())
+	}
+	return payload, nil
+}
+
+// sendWebhook posts the body to the url, retrying on network errors and retryable status codes.
+func (u *uploader) sendWebhook(ctx context.Context, url string, body []byte) error {
+	backoff := webhookInitialBackoff
+	var err error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		var retryable bool
+		retryable, err = u.postWebhook(ctx, url, body)
+		if err == nil || !retryable || attempt == webhookMaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+func (u *uploader) postWebhook(ctx context.Context, url string, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(u.webhookSecret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(u.webhookSecret, body))
+	}
+	// Webhooks use the transport of registries, so that receivers behind the same TLS intercepting proxy
+	// or with certificates of the same private CA can be reached.
+	client := &http.Client{Transport: reg.BaseTransport(), Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// SignWebhookPayload returns the value of the signature header for the given body.
+// Receivers should compute the same value using the shared secret and compare
+// it in constant time.
+func SignWebhookPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func currentInvocation(clock hermetic.Clock) WebhookInvocationInfo {
+	host, _ := os.Hostname()
+	return WebhookInvocationInfo{
+		Host:      host,
+		User:      os.Getenv("USER"),
+		Workspace: os.Getenv("BUILD_WORKSPACE_DIRECTORY"),
+		Time:      clock.Now().UTC(),
+	}
+}
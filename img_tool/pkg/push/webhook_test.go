@@ -0,0 +1,90 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/hermetic"
+)
+
+func TestSendWebhook(t *testing.T) {
+	body := []byte(`{"digest":"sha256:aaaa"}`)
+	secret := []byte("secret")
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "ok", statuses: []int{http.StatusNoContent}, wantRequests: 1},
+		{name: "retried", statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, wantRequests: 2},
+		{name: "not retried", statuses: []int{http.StatusBadRequest}, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				got, _ := io.ReadAll(r.Body)
+				if string(got) != string(body) {
+					t.Errorf("webhook body = %s, want %s", got, body)
+				}
+				if sig := r.Header.Get(WebhookSignatureHeader); sig != SignWebhookPayload(secret, body) {
+					t.Errorf("webhook signature = %q", sig)
+				}
+				w.WriteHeader(tt.statuses[min(requests, len(tt.statuses)-1)])
+				requests++
+			}))
+			defer server.Close()
+
+			u := &uploader{webhookSecret: secret}
+			err := u.sendWebhook(context.Background(), server.URL, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sendWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("webhook received %d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestNotifyWebhooksClock(t *testing.T) {
+	invocationTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	var payloads []WebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding webhook payload: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	u := NewBuilder(nil).WithClock(hermetic.Fixed(invocationTime)).Build()
+	op := api.IndexedPushDeployOperation{PushDeployOperation: api.PushDeployOperation{
+		BaseCommandOperation: api.BaseCommandOperation{Root: api.Descriptor{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		}},
+		PushTarget: api.PushTarget{Registry: "registry.example.com", Repository: "app", Webhooks: []string{server.URL}},
+	}}
+	if err := u.notifyWebhooks(context.Background(), []api.IndexedPushDeployOperation{op}); err != nil {
+		t.Fatalf("notifyWebhooks() error = %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("webhook received %d payloads, want 1", len(payloads))
+	}
+	if got := payloads[0].Invocation.Time; !got.Equal(invocationTime) || got.Location() != time.UTC {
+		t.Errorf("invocation time = %v, want %v in UTC", got, invocationTime)
+	}
+}
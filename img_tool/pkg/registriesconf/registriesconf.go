@@ -0,0 +1,399 @@
+// Package registriesconf reads the registries configuration of containers/image
+// (registries.conf, as used by podman, buildah and CRI-O).
+//
+// It honors unqualified search registries, registry location rewrites, mirrors,
+// insecure registries and blocked registries, so that hosts with a mandated mirror configuration
+// work without duplicating it in rules_img specific settings.
+// Both the current (v2) format with [[registry]] tables and the legacy (v1) format
+// with [registries.search], [registries.insecure] and [registries.block] are supported.
+//
+// A nil *Config is valid and represents the absence of a configuration.
+package registriesconf
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+)
+
+// Environment variables that select the registries.conf file.
+const (
+	// Env selects the registries.conf file honored by img (IMG_REGISTRIES_CONF).
+	Env = "IMG_REGISTRIES_CONF"
+	// containersEnv is the override used by containers/image itself.
+	containersEnv = "CONTAINERS_REGISTRIES_CONF"
+	// SystemDefault selects the registries.conf file that podman would use.
+	SystemDefault = "system"
+)
+
+// Config is a parsed registries.conf.
+type Config struct {
+	// Path is the file the configuration was read from.
+	Path string
+	// UnqualifiedSearchRegistries are tried in order for image names without registry.
+	UnqualifiedSearchRegistries []string
+	// Registries are the [[registry]] entries.
+	Registries []Registry
+}
+
+// Registry is a [[registry]] entry.
+type Registry struct {
+	// Prefix selects the images the entry applies to: a registry, a repository namespace
+	// (like "example.com/foo") or a wildcard subdomain (like "*.example.com").
+	// Defaults to Location.
+	Prefix string
+	// Location replaces Prefix when images are pulled from the registry.
+	Location string
+	// Insecure allows plain HTTP and TLS connections without certificate verification.
+	Insecure bool
+	// Blocked forbids pulling images that match Prefix.
+	Blocked bool
+	// MirrorByDigestOnly restricts the mirrors to pulls by digest.
+	MirrorByDigestOnly bool
+	// Mirrors are tried in order before Location.
+	Mirrors []Mirror
+}
+
+// Mirror is a [[registry.mirror]] entry.
+type Mirror struct {
+	Location string
+	Insecure bool
+	// PullFromMirror is "all" (default), "digest-only" or "tag-only".
+	PullFromMirror string
+}
+
+// Source is a location to pull an image from.
+type Source struct {
+	// Registry is the host (and port) of the source.
+	Registry string
+	// Repository is the repository of the image in Registry.
+	Repository string
+	// Mirror is true for mirrors of the registry.
+	Mirror bool
+}
+
+func (s Source) String() string {
+	return s.Registry + "/" + s.Repository
+}
+
+// Options select the registries.conf file to honor.
+type Options struct {
+	// Path is the file to read. SystemDefault uses the file podman would use.
+	// If empty, no registries.conf is honored.
+	Path string
+}
+
+// RegisterFlags adds --registries-conf to the flag set. IMG_REGISTRIES_CONF provides the default.
+func (o *Options) RegisterFlags(flagSet *flag.FlagSet) {
+	o.Path = os.Getenv(Env)
+	flagSet.StringVar(&o.Path, "registries-conf", o.Path, fmt.Sprintf(`Honor the search registries, mirrors, insecure and blocked registries of a containers registries.conf file. Use %q for the file used by podman ($%s, $HOME/.config/containers/registries.conf or /etc/containers/registries.conf). Disabled if empty (env: %s)`, SystemDefault, containersEnv, Env))
+}
+
+// Load reads the selected registries.conf. It returns nil if no file is selected,
+// or if SystemDefault is selected and no file exists.
+func (o Options) Load() (*Config, error) {
+	switch o.Path {
+	case "":
+		return nil, nil
+	case SystemDefault:
+		path := systemPath()
+		if path == "" {
+			return nil, nil
+		}
+		return Load(path)
+	default:
+		return Load(o.Path)
+	}
+}
+
+func systemPath() string {
+	if path := os.Getenv(containersEnv); path != "" {
+		return path
+	}
+	var candidates []string
+	if configDir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(configDir, "containers", "registries.conf"))
+	}
+	candidates = append(candidates, "/etc/containers/registries.conf")
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// tomlConfig is the file format of registries.conf, like in containers/image.
+// Keys that are not relevant for pulling and pushing (like short name aliases) are ignored.
+type tomlConfig struct {
+	UnqualifiedSearchRegistries []string       `toml:"unqualified-search-registries"`
+	Registries                  []tomlRegistry `toml:"registry"`
+	// Legacy is the legacy (v1) format.
+	Legacy struct {
+		Search   tomlLegacyRegistries `toml:"search"`
+		Insecure tomlLegacyRegistries `toml:"insecure"`
+		Block    tomlLegacyRegistries `toml:"block"`
+	} `toml:"registries"`
+}
+
+type tomlRegistry struct {
+	Prefix             string       `toml:"prefix"`
+	Location           string       `toml:"location"`
+	Insecure           bool         `toml:"insecure"`
+	Blocked            bool         `toml:"blocked"`
+	MirrorByDigestOnly bool         `toml:"mirror-by-digest-only"`
+	Mirrors            []tomlMirror `toml:"mirror"`
+}
+
+type tomlMirror struct {
+	Location       string `toml:"location"`
+	Insecure       bool   `toml:"insecure"`
+	PullFromMirror string `toml:"pull-from-mirror"`
+}
+
+type tomlLegacyRegistries struct {
+	Registries []string `toml:"registries"`
+}
+
+// Load reads a registries.conf file.
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading registries.conf: %w", err)
+	}
+	config, err := parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	config.Path = path
+	return config, nil
+}
+
+// parse parses the contents of a registries.conf file.
+func parse(data string) (*Config, error) {
+	var file tomlConfig
+	if _, err := toml.Decode(data, &file); err != nil {
+		return nil, err
+	}
+	config := &Config{UnqualifiedSearchRegistries: file.UnqualifiedSearchRegistries}
+	for _, entry := range file.Registries {
+		registry, err := registryFromTOML(entry)
+		if err != nil {
+			return nil, err
+		}
+		config.Registries = append(config.Registries, registry)
+	}
+
+	// legacy (v1) format
+	config.UnqualifiedSearchRegistries = append(config.UnqualifiedSearchRegistries, file.Legacy.Search.Registries...)
+	for _, location := range file.Legacy.Insecure.Registries {
+		config.entry(location).Insecure = true
+	}
+	for _, location := range file.Legacy.Block.Registries {
+		config.entry(location).Blocked = true
+	}
+	return config, nil
+}
+
+func registryFromTOML(entry tomlRegistry) (Registry, error) {
+	registry := Registry{
+		Prefix:             entry.Prefix,
+		Location:           entry.Location,
+		Insecure:           entry.Insecure,
+		Blocked:            entry.Blocked,
+		MirrorByDigestOnly: entry.MirrorByDigestOnly,
+	}
+	if registry.Prefix == "" {
+		registry.Prefix = registry.Location
+	}
+	if registry.Prefix == "" {
+		return Registry{}, fmt.Errorf("[[registry]] entry needs a prefix or location")
+	}
+	if strings.HasPrefix(registry.Prefix, "*.") {
+		if registry.Location != "" {
+			return Registry{}, fmt.Errorf("[[registry]] entry with wildcard prefix %q cannot set a location", registry.Prefix)
+		}
+	} else if registry.Location == "" {
+		registry.Location = registry.Prefix
+	}
+	for _, mirror := range entry.Mirrors {
+		if mirror.Location == "" {
+			return Registry{}, fmt.Errorf("mirror of %s needs a location", registry.Prefix)
+		}
+		switch mirror.PullFromMirror {
+		case "", "all", "digest-only", "tag-only":
+		default:
+			return Registry{}, fmt.Errorf("mirror %s: invalid pull-from-mirror %q", mirror.Location, mirror.PullFromMirror)
+		}
+		registry.Mirrors = append(registry.Mirrors, Mirror(mirror))
+	}
+	return registry, nil
+}
+
+// entry returns the entry with the prefix, adding it if needed.
+func (c *Config) entry(prefix string) *Registry {
+	for i := range c.Registries {
+		if c.Registries[i].Prefix == prefix {
+			return &c.Registries[i]
+		}
+	}
+	c.Registries = append(c.Registries, Registry{Prefix: prefix, Location: prefix})
+	return &c.Registries[len(c.Registries)-1]
+}
+
+// Lookup returns the entry with the longest prefix matching the image (registry/repository).
+func (c *Config) Lookup(registry, repository string) (*Registry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	registry = normalizeRegistry(registry)
+	image := registry + "/" + repository
+	var best *Registry
+	bestLength := -1
+	for i := range c.Registries {
+		entry := &c.Registries[i]
+		length, ok := prefixMatch(entry.Prefix, registry, image)
+		if ok && length > bestLength {
+			best = entry
+			bestLength = length
+		}
+	}
+	return best, best != nil
+}
+
+// prefixMatch reports whether prefix matches the image and returns the length of the match.
+func prefixMatch(prefix, registry, image string) (int, bool) {
+	if suffix, ok := strings.CutPrefix(prefix, "*."); ok {
+		host, _, _ := strings.Cut(registry, ":")
+		return len(prefix), strings.HasSuffix(host, "."+suffix)
+	}
+	if image == prefix || strings.HasPrefix(image, prefix+"/") {
+		return len(prefix), true
+	}
+	return 0, false
+}
+
+// Qualify returns the candidate registries for an image name without registry.
+// It returns nil if no unqualified search registries are configured.
+func (c *Config) Qualify() []string {
+	if c == nil {
+		return nil
+	}
+	return c.UnqualifiedSearchRegistries
+}
+
+// PullSources returns where to pull the image from: the mirrors of its registry entry
+// followed by its (possibly rewritten) location.
+// byDigest selects the mirrors that serve pulls by digest or by tag.
+// Fails if the image is blocked.
+func (c *Config) PullSources(registry, repository string, byDigest bool) ([]Source, error) {
+	entry, ok := c.Lookup(registry, repository)
+	if !ok {
+		return []Source{{Registry: registry, Repository: repository}}, nil
+	}
+	if entry.Blocked {
+		return nil, fmt.Errorf("%s/%s is blocked by %s in %s", registry, repository, entry.Prefix, c.Path)
+	}
+	image := normalizeRegistry(registry) + "/" + repository
+	// A wildcard prefix matches the whole registry.
+	matched := entry.Prefix
+	if strings.HasPrefix(matched, "*.") {
+		matched = normalizeRegistry(registry)
+	}
+	var sources []Source
+	for _, mirror := range entry.Mirrors {
+		if !mirrorServes(*entry, mirror, byDigest) {
+			continue
+		}
+		sources = append(sources, rewrite(matched, mirror.Location, image, true))
+	}
+	if entry.Location == "" {
+		sources = append(sources, Source{Registry: registry, Repository: repository})
+	} else {
+		sources = append(sources, rewrite(matched, entry.Location, image, false))
+	}
+	return sources, nil
+}
+
+// CheckPush fails if pushing to the image is blocked.
+func (c *Config) CheckPush(registry, repository string) error {
+	entry, ok := c.Lookup(registry, repository)
+	if ok && entry.Blocked {
+		return fmt.Errorf("pushing to %s/%s is blocked by %s in %s", registry, repository, entry.Prefix, c.Path)
+	}
+	return nil
+}
+
+// InsecureRegistries returns the hosts of insecure registries and mirrors.
+func (c *Config) InsecureRegistries() []string {
+	if c == nil {
+		return nil
+	}
+	set := make(map[string]struct{})
+	for _, entry := range c.Registries {
+		if entry.Insecure && entry.Location != "" {
+			set[host(entry.Location)] = struct{}{}
+		}
+		for _, mirror := range entry.Mirrors {
+			if mirror.Insecure {
+				set[host(mirror.Location)] = struct{}{}
+			}
+		}
+	}
+	hosts := make([]string, 0, len(set))
+	for h := range set {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func mirrorServes(entry Registry, mirror Mirror, byDigest bool) bool {
+	if entry.MirrorByDigestOnly && !byDigest {
+		return false
+	}
+	switch mirror.PullFromMirror {
+	case "digest-only":
+		return byDigest
+	case "tag-only":
+		return !byDigest
+	}
+	return true
+}
+
+// rewrite replaces the prefix of the image with location.
+func rewrite(prefix, location, image string, mirror bool) Source {
+	rest := strings.TrimPrefix(image, prefix)
+	rewritten := strings.TrimSuffix(location, "/") + rest
+	registry, repository, _ := strings.Cut(rewritten, "/")
+	return Source{Registry: registry, Repository: repository, Mirror: mirror}
+}
+
+// normalizeRegistry maps the aliases of Docker Hub to docker.io, which registries.conf uses.
+func normalizeRegistry(registry string) string {
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return registry
+}
+
+func host(location string) string {
+	h, _, _ := strings.Cut(location, "/")
+	return h
+}
+
+// ConfigureTLS adds the insecure registries and mirrors to the TLS options:
+// their certificates are not verified and plain HTTP is used if they do not speak TLS.
+func (c *Config) ConfigureTLS(opts *reg.TLSOptions) {
+	insecure := c.InsecureRegistries()
+	opts.InsecureSkipVerify = append(opts.InsecureSkipVerify, insecure...)
+	opts.HTTPFallback = append(opts.HTTPFallback, insecure...)
+}
@@ -0,0 +1,220 @@
+package registriesconf
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		file string
+		want Config
+	}{
+		{
+			file: "fedora.conf",
+			want: Config{
+				UnqualifiedSearchRegistries: []string{"registry.fedoraproject.org", "registry.access.redhat.com", "docker.io"},
+			},
+		},
+		{
+			file: "rhel.conf",
+			want: Config{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "registry.redhat.io", "docker.io"},
+				Registries: []Registry{
+					{
+						Prefix:             "registry.redhat.io",
+						Location:           "registry.redhat.io",
+						MirrorByDigestOnly: true,
+						Mirrors:            []Mirror{{Location: "mirror.corp.example.com:5000/redhat", PullFromMirror: "digest-only"}},
+					},
+					{
+						Prefix:   "docker.io",
+						Location: "docker.io",
+						Mirrors: []Mirror{
+							{Location: "mirror.corp.example.com:5000/dockerhub"},
+							{Location: "10.0.0.5:5000/dockerhub", Insecure: true},
+						},
+					},
+					{Prefix: "*.untrusted.example.com", Blocked: true},
+					{Prefix: "localhost:5000", Location: "localhost:5000", Insecure: true},
+				},
+			},
+		},
+		{
+			file: "rhel7.conf",
+			want: Config{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io", "registry.fedoraproject.org", "quay.io", "registry.centos.org"},
+				Registries:                  []Registry{{Prefix: "registry.local:5000", Location: "registry.local:5000", Insecure: true}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join("testdata", tt.file)
+			got, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			tt.want.Path = path
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Config
+		wantErr string
+	}{
+		{
+			name: "quoted keys",
+			data: `
+"unqualified-search-registries" = ["docker.io"]
+[[registry]]
+"prefix" = "example.com/foo"
+'location' = "internal.example.net/bar"
+`,
+			want: Config{
+				UnqualifiedSearchRegistries: []string{"docker.io"},
+				Registries:                  []Registry{{Prefix: "example.com/foo", Location: "internal.example.net/bar"}},
+			},
+		},
+		{
+			name: "comment and escape characters in strings",
+			data: `
+[[registry]]
+prefix = "example.com/a#b" # a comment with "quotes"
+location = "example.com/\"quoted\"" # and a trailing ] bracket
+`,
+			want: Config{
+				Registries: []Registry{{Prefix: "example.com/a#b", Location: `example.com/"quoted"`}},
+			},
+		},
+		{
+			name: "dotted keys",
+			data: `
+registries.search.registries = ["quay.io"]
+registries.insecure.registries = ["quay.io"]
+`,
+			want: Config{
+				UnqualifiedSearchRegistries: []string{"quay.io"},
+				Registries:                  []Registry{{Prefix: "quay.io", Location: "quay.io", Insecure: true}},
+			},
+		},
+		{
+			name: "inline tables",
+			data: `registry = [{location = "docker.io", mirror = [{location = "mirror.example.com"}]}]`,
+			want: Config{
+				Registries: []Registry{{Prefix: "docker.io", Location: "docker.io", Mirrors: []Mirror{{Location: "mirror.example.com"}}}},
+			},
+		},
+		{
+			name: "multi-line array",
+			data: `
+unqualified-search-registries = [
+  "registry.fedoraproject.org", # Fedora
+  "docker.io",
+]
+`,
+			want: Config{UnqualifiedSearchRegistries: []string{"registry.fedoraproject.org", "docker.io"}},
+		},
+		{
+			name: "blocked legacy registry",
+			data: `
+[registries.block]
+registries = ['docker.io']
+`,
+			want: Config{Registries: []Registry{{Prefix: "docker.io", Location: "docker.io", Blocked: true}}},
+		},
+		{
+			name:    "wildcard with location",
+			data:    "[[registry]]\nprefix = \"*.example.com\"\nlocation = \"mirror.example.com\"\n",
+			wantErr: "cannot set a location",
+		},
+		{
+			name:    "invalid pull-from-mirror",
+			data:    "[[registry]]\nlocation = \"docker.io\"\n[[registry.mirror]]\nlocation = \"mirror.example.com\"\npull-from-mirror = \"sometimes\"\n",
+			wantErr: `invalid pull-from-mirror "sometimes"`,
+		},
+		{
+			name:    "mirror without location",
+			data:    "[[registry]]\nlocation = \"docker.io\"\n[[registry.mirror]]\ninsecure = true\n",
+			wantErr: "needs a location",
+		},
+		{
+			name:    "registry without prefix or location",
+			data:    "[[registry]]\ninsecure = true\n",
+			wantErr: "needs a prefix or location",
+		},
+		{
+			name:    "wrong type",
+			data:    "[[registry]]\nlocation = \"docker.io\"\ninsecure = \"yes\"\n",
+			wantErr: "insecure",
+		},
+		{
+			name:    "unterminated string",
+			data:    "unqualified-search-registries = [\"docker.io]\n",
+			wantErr: "strings cannot contain newlines",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPullSources(t *testing.T) {
+	config, err := Load(filepath.Join("testdata", "rhel.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		registry, repository string
+		byDigest             bool
+		want                 []string
+		wantErr              bool
+	}{
+		{registry: "registry.redhat.io", repository: "ubi9/ubi", byDigest: true, want: []string{"mirror.corp.example.com:5000/redhat/ubi9/ubi", "registry.redhat.io/ubi9/ubi"}},
+		{registry: "registry.redhat.io", repository: "ubi9/ubi", want: []string{"registry.redhat.io/ubi9/ubi"}},
+		{registry: "index.docker.io", repository: "library/alpine", want: []string{"mirror.corp.example.com:5000/dockerhub/library/alpine", "10.0.0.5:5000/dockerhub/library/alpine", "docker.io/library/alpine"}},
+		{registry: "quay.io", repository: "centos/centos", want: []string{"quay.io/centos/centos"}},
+		{registry: "cdn.untrusted.example.com", repository: "app", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.registry+"/"+tt.repository, func(t *testing.T) {
+			sources, err := config.PullSources(tt.registry, tt.repository, tt.byDigest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PullSources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, source := range sources {
+				got = append(got, source.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PullSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, want := config.InsecureRegistries(), []string{"10.0.0.5:5000", "localhost:5000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InsecureRegistries() = %v, want %v", got, want)
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	build_event_stream_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/bazel/src/main/java/com/google/devtools/build/lib/buildeventstream"
 	bes_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/build_event_service"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/serve/bes/syncer"
@@ -54,7 +55,7 @@ func New(s *syncer.Syncer, mode CommitMode) *BES {
 
 // Shutdown gracefully shuts down the BES server, waiting for background commits to complete
 func (b *BES) Shutdown(ctx context.Context) error {
-	log.Println("Shutting down BES server, waiting for background commits...")
+	slog.Info("Shutting down BES server, waiting for background commits...")
 
 	// Wait for all background commits to complete or context to be canceled
 	done := make(chan error, 1)
@@ -65,13 +66,13 @@ func (b *BES) Shutdown(ctx context.Context) error {
 	select {
 	case err := <-done:
 		if err != nil {
-			log.Printf("Background commits completed with error: %v", err)
+			slog.Error("Background commits completed with error", logging.ErrKey, err)
 			return err
 		}
-		log.Println("All background commits completed successfully")
+		slog.Info("All background commits completed successfully")
 		return nil
 	case <-ctx.Done():
-		log.Println("Shutdown timeout reached, some commits may still be running")
+		slog.Warn("Shutdown timeout reached, some commits may still be running")
 		return ctx.Err()
 	}
 }
@@ -92,9 +93,9 @@ func (b *BES) PublishBuildToolEventStream(stream bes_proto.PublishBuildEvent_Pub
 		requestErrGroup, commitCtx = errgroup.WithContext(commitCtx)
 		defer func() {
 			if err := requestErrGroup.Wait(); err != nil {
-				log.Printf("Per-stream commits completed with error: %v", err)
+				slog.Error("Per-stream commits completed with error", logging.ErrKey, err)
 			} else {
-				log.Println("All per-stream commits completed successfully")
+				slog.Info("All per-stream commits completed successfully")
 			}
 		}()
 	} else {
@@ -109,7 +110,7 @@ func (b *BES) PublishBuildToolEventStream(stream bes_proto.PublishBuildEvent_Pub
 				// Client closed the stream, this is normal
 				return nil
 			}
-			log.Printf("Error receiving from stream: %v", err)
+			slog.Error("Failed to receive from stream", logging.ErrKey, err)
 			return err
 		}
 		response := &bes_proto.PublishBuildToolEventStreamResponse{
@@ -121,7 +122,7 @@ func (b *BES) PublishBuildToolEventStream(stream bes_proto.PublishBuildEvent_Pub
 		if bazelEvent == nil {
 			// simply acknowledge non-Bazel events
 			if err := stream.Send(response); err != nil {
-				log.Printf("Error sending response: %v", err)
+				slog.Error("Failed to send response", logging.ErrKey, err)
 				return err
 			}
 			continue
@@ -133,13 +134,13 @@ func (b *BES) PublishBuildToolEventStream(stream bes_proto.PublishBuildEvent_Pub
 			return err
 		} else {
 			if err := b.processBuildEvent(&buildEvent, tracker, requestErrGroup, commitCtx); err != nil {
-				log.Printf("Error processing build event: %v", err)
+				slog.Error("Failed to process build event", logging.ErrKey, err)
 				// Continue processing other events even if one fails
 			}
 		}
 
 		if err := stream.Send(response); err != nil {
-			log.Printf("Error sending response: %v", err)
+			slog.Error("Failed to send response", logging.ErrKey, err)
 			return err
 		}
 	}
@@ -194,7 +195,7 @@ func (b *BES) processBuildEvent(event *build_event_stream_proto.BuildEvent, trac
 		}
 		// We have a matching TargetConfigured event, so we can process this TargetCompleted event.
 		if !completed.Success {
-			log.Printf("Target %s failed to complete successfully\n", idHash)
+			slog.Debug("Target failed to complete successfully", "target", idHash)
 			return nil // we only care about successful completions
 		}
 		if len(completed.OutputGroup) == 0 {
@@ -228,8 +229,12 @@ func (b *BES) processBuildEvent(event *build_event_stream_proto.BuildEvent, trac
 			length := pushJSONDescriptor.Length
 
 			comittErrGroup.Go(func() error {
-				if err := b.syncer.Commit(commitCtx, digest, length); err != nil {
-					return fmt.Errorf("failed to commit image for target %s: %w", idHash, err)
+				result, err := b.syncer.Commit(commitCtx, digest, length)
+				if err != nil {
+					for _, failed := range result.Failed {
+						slog.Error("Failed to upload blob", "digest", failed.Digest, "repository", failed.Repository, "attempts", failed.Attempts, logging.ErrKey, failed.Err)
+					}
+					return fmt.Errorf("failed to commit image for target %s (%d blobs uploaded, %d failed): %w", idHash, len(result.Succeeded), len(result.Failed), err)
 				}
 				return nil
 			})
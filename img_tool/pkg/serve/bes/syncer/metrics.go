@@ -0,0 +1,44 @@
+package syncer
+
+import "github.com/bazel-contrib/rules_img/img_tool/pkg/serve/metrics"
+
+// Metrics exported by the syncer on the /metrics endpoint of the serving binary.
+// The dedup hit rate can be derived as
+// img_syncer_blob_dedup_hits_total / img_syncer_blob_requests_total.
+var (
+	commitsTotal = metrics.Default.Counter(
+		"img_syncer_commits_total",
+		"Number of deploy manifests committed by the syncer, partitioned by result.",
+		"result")
+	blobRequestsTotal = metrics.Default.Counter(
+		"img_syncer_blob_requests_total",
+		"Number of blob uploads requested (before deduplication).")
+	blobDedupHitsTotal = metrics.Default.Counter(
+		"img_syncer_blob_dedup_hits_total",
+		"Number of blob upload requests skipped because the blob was already uploaded or in flight.",
+		"kind")
+	manifestShortCircuitsTotal = metrics.Default.Counter(
+		"img_syncer_manifest_short_circuits_total",
+		"Number of push operations whose root manifest or index already existed in the registry, so only tags were updated.")
+	blobUploadsTotal = metrics.Default.Counter(
+		"img_syncer_blob_uploads_total",
+		"Number of blob uploads performed against a registry, partitioned by result and source.",
+		"result", "source")
+	blobUploadRetriesTotal = metrics.Default.Counter(
+		"img_syncer_blob_upload_retries_total",
+		"Number of blob upload attempts that failed and were retried.")
+	bytesPushedTotal = metrics.Default.Counter(
+		"img_syncer_bytes_pushed_total",
+		"Number of blob bytes successfully pushed to registries.")
+	casFetchDuration = metrics.Default.Histogram(
+		"img_syncer_cas_fetch_duration_seconds",
+		"Latency of metadata fetches from the CAS that missed the in-memory cache.",
+		nil)
+	workQueueDepth = metrics.Default.Gauge(
+		"img_syncer_work_queue_depth",
+		"Number of blob upload jobs waiting for a worker.")
+	errorsTotal = metrics.Default.Counter(
+		"img_syncer_errors_total",
+		"Number of errors encountered by the syncer, partitioned by stage.",
+		"stage")
+)
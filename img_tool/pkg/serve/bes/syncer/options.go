@@ -0,0 +1,68 @@
+package syncer
+
+import "maps"
+
+// defaultWorkerCount is the number of upload workers used if no other value is configured.
+const defaultWorkerCount = 4
+
+type syncerOptions struct {
+	workerCount int
+	// defaultRegistryConcurrency limits the number of concurrent blob uploads per registry.
+	// Zero means no limit (other than the worker count).
+	defaultRegistryConcurrency int
+	// registryConcurrency overrides defaultRegistryConcurrency for individual registries.
+	registryConcurrency map[string]int
+	// bandwidthLimit is the maximum combined upload rate in bytes per second.
+	// Zero means unlimited.
+	bandwidthLimit int64
+	// store remembers blobs, manifests, and tags that exist in registries. Nil means an in-memory store.
+	store *Store
+}
+
+// Option configures a Syncer created with New.
+type Option func(*syncerOptions)
+
+// WithWorkers sets the number of worker goroutines uploading blobs concurrently.
+// Values <= 0 select the default of 4 workers.
+func WithWorkers(workerCount int) Option {
+	return func(opts *syncerOptions) {
+		opts.workerCount = workerCount
+	}
+}
+
+// WithDefaultRegistryConcurrency limits the number of concurrent blob uploads to any single registry.
+// A limit of 0 disables the per-registry limit.
+func WithDefaultRegistryConcurrency(limit int) Option {
+	return func(opts *syncerOptions) {
+		opts.defaultRegistryConcurrency = limit
+	}
+}
+
+// WithRegistryConcurrency limits the number of concurrent blob uploads to individual registries.
+// The map is keyed by registry (for example "harbor.example.com") and overrides the default
+// per-registry limit. A limit of 0 disables the limit for that registry.
+func WithRegistryConcurrency(limits map[string]int) Option {
+	return func(opts *syncerOptions) {
+		if opts.registryConcurrency == nil {
+			opts.registryConcurrency = make(map[string]int)
+		}
+		maps.Copy(opts.registryConcurrency, limits)
+	}
+}
+
+// WithBandwidthLimit caps the combined upload bandwidth of all workers in bytes per second.
+// A limit of 0 disables the cap.
+func WithBandwidthLimit(bytesPerSecond int64) Option {
+	return func(opts *syncerOptions) {
+		opts.bandwidthLimit = bytesPerSecond
+	}
+}
+
+// WithStore sets the store that remembers which blobs, manifests, and tags exist in registries (see OpenStore).
+// Without a store (or with a nil store), this knowledge is kept in memory (without bounds) and lost on restart.
+// The syncer closes the store on shutdown.
+func WithStore(store *Store) Option {
+	return func(opts *syncerOptions) {
+		opts.store = store
+	}
+}
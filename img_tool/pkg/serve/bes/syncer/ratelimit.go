@@ -0,0 +1,132 @@
+package syncer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	v1 "github.com/malt3/go-containerregistry/pkg/v1"
+)
+
+// throttleChunkSize is the maximum number of bytes read at once from a throttled reader.
+// Smaller chunks result in a smoother upload rate.
+const throttleChunkSize = 32 * 1024
+
+// registrySemaphores limits the number of concurrent uploads per registry.
+type registrySemaphores struct {
+	defaultLimit int
+	limits       map[string]int
+
+	mu         sync.Mutex
+	semaphores map[string]chan struct{}
+}
+
+func newRegistrySemaphores(defaultLimit int, limits map[string]int) *registrySemaphores {
+	return &registrySemaphores{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		semaphores:   make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until an upload slot for the registry is available.
+// It returns a function that releases the slot.
+func (r *registrySemaphores) acquire(ctx context.Context, registry string) (func(), error) {
+	limit, ok := r.limits[registry]
+	if !ok {
+		limit = r.defaultLimit
+	}
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	r.mu.Lock()
+	sem, ok := r.semaphores[registry]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		r.semaphores[registry] = sem
+	}
+	r.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// bandwidthLimiter is a simple limiter shared by all uploads of a syncer.
+// It spaces out reads so that the combined rate does not exceed bytesPerSecond.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+
+	mu   sync.Mutex
+	next time.Time // earliest time at which the next chunk may be sent
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until n more bytes may be sent.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.bytesPerSecond) * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledLayer wraps a v1.Layer and limits the rate at which its compressed contents are read.
+type throttledLayer struct {
+	v1.Layer
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (l *throttledLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: l.ctx, limiter: l.limiter}, nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// blocks reports whether acquiring a slot of the registry blocks.
+func blocks(t *testing.T, r *registrySemaphores, registry string) bool {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release, err := r.acquire(ctx, registry)
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("acquire(%s) error = %v", registry, err)
+		}
+		return true
+	}
+	release()
+	return false
+}
+
+func TestRegistrySemaphores(t *testing.T) {
+	r := newRegistrySemaphores(2, map[string]int{"harbor.example.com": 1, "unlimited.example.com": 0})
+	ctx := context.Background()
+
+	// the default limit applies to each registry on its own
+	var releases []func()
+	for _, registry := range []string{"ghcr.io", "ghcr.io", "quay.io", "quay.io"} {
+		release, err := r.acquire(ctx, registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if !blocks(t, r, "ghcr.io") {
+		t.Error("third upload to ghcr.io did not block with the default limit of 2")
+	}
+	releases[0]()
+	if blocks(t, r, "ghcr.io") {
+		t.Error("upload to ghcr.io blocked after a slot was released")
+	}
+
+	// overrides replace the default limit
+	release, err := r.acquire(ctx, "harbor.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blocks(t, r, "harbor.example.com") {
+		t.Error("second upload to harbor.example.com did not block with a limit of 1")
+	}
+	release()
+	for range 10 {
+		if _, err := r.acquire(ctx, "unlimited.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	if newBandwidthLimiter(0) != nil {
+		t.Error("newBandwidthLimiter(0) is not nil")
+	}
+
+	// 100 bytes per 10ms: the first chunk is sent at once, each further chunk after the previous one
+	limiter := newBandwidthLimiter(10_000)
+	start := time.Now()
+	for range 5 {
+		if err := limiter.wait(context.Background(), 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("sending 500 bytes at 10000 bytes/s took %v, want at least 40ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx, 10_000); err == nil {
+		t.Error("wait() with a cancelled context succeeded")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), throttleChunkSize/5)
+	limiter := newBandwidthLimiter(1 << 30)
+	r := &throttledReader{ReadCloser: io.NopCloser(bytes.NewReader(data)), ctx: context.Background(), limiter: limiter}
+	buf := make([]byte, 2*throttleChunkSize)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > throttleChunkSize {
+		t.Errorf("Read() returned %d bytes, want at most %d", n, throttleChunkSize)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(buf[:n], rest...), data) {
+		t.Error("throttled reader changed the data")
+	}
+}
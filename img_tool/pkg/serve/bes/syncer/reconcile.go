@@ -0,0 +1,123 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+)
+
+// reconcileConcurrency is the number of records checked against registries at the same time.
+const reconcileConcurrency = 16
+
+// Reconcile checks the records of the state store against the registries.
+// Blobs and manifests that no longer exist (for example because they were garbage collected)
+// and tags that were moved to a different digest are forgotten, so that they are pushed again
+// by the next commit.
+//
+// Records are only forgotten if the registry says so: if a registry cannot be reached,
+// its records are kept. Reconcile is meant to run once at startup, concurrently to commits.
+func (s *Syncer) Reconcile(ctx context.Context) error {
+	remoteOpts := []remote.Option{
+		remote.WithContext(ctx),
+		registry.WithAuthFromMultiKeychain(),
+	}
+	var checked, removed, failed atomic.Int64
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(reconcileConcurrency)
+	check := func(kind, key string, exists func() (bool, error)) {
+		eg.Go(func() error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			checked.Add(1)
+			ok, err := exists()
+			if err != nil {
+				failed.Add(1)
+				return nil
+			}
+			if ok {
+				return nil
+			}
+			removed.Add(1)
+			return s.state.remove(kind, key)
+		})
+	}
+
+	for key := range s.state.snapshot(kindBlob) {
+		ref, err := name.NewDigest(key)
+		if err != nil {
+			_ = s.state.remove(kindBlob, key)
+			continue
+		}
+		check(kindBlob, key, func() (bool, error) {
+			layer, err := remote.Layer(ref, remoteOpts...)
+			if err != nil {
+				return notFound(err)
+			}
+			if _, err := layer.Size(); err != nil {
+				return notFound(err)
+			}
+			return true, nil
+		})
+	}
+	for key := range s.state.snapshot(kindManifest) {
+		ref, err := name.NewDigest(key)
+		if err != nil {
+			_ = s.state.remove(kindManifest, key)
+			continue
+		}
+		check(kindManifest, key, func() (bool, error) {
+			if _, err := remote.Head(ref, remoteOpts...); err != nil {
+				return notFound(err)
+			}
+			return true, nil
+		})
+	}
+	for key, digest := range s.state.snapshot(kindTag) {
+		ref, err := name.NewTag(key)
+		if err != nil {
+			_ = s.state.remove(kindTag, key)
+			continue
+		}
+		check(kindTag, key, func() (bool, error) {
+			desc, err := remote.Head(ref, remoteOpts...)
+			if err != nil {
+				return notFound(err)
+			}
+			return desc.Digest.String() == digest, nil
+		})
+	}
+
+	err := eg.Wait()
+	slog.Info("Reconciled state with registries", "checked", checked.Load(), "forgotten", removed.Load(), "unchecked", failed.Load())
+	return err
+}
+
+// notFound converts the error of a registry request into the result of an existence check.
+// A 404 (or a NAME_UNKNOWN / MANIFEST_UNKNOWN / BLOB_UNKNOWN error) means the record does not exist,
+// any other error means that its existence is unknown.
+func notFound(err error) (bool, error) {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		if transportErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		for _, diagnostic := range transportErr.Errors {
+			if strings.HasSuffix(string(diagnostic.Code), "_UNKNOWN") {
+				return false, nil
+			}
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,396 @@
+package syncer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of records in a Store.
+const (
+	// kindBlob records a blob uploaded to (or found in) a repository. The key is registry/repository@digest.
+	kindBlob = "blob"
+	// kindManifest records a root manifest or index that exists in a repository. The key is registry/repository@digest.
+	kindManifest = "manifest"
+	// kindTag records the digest a tag was pointed to. The key is registry/repository:tag, the value is the digest.
+	kindTag = "tag"
+	// kindCommit records a deploy manifest whose commit did not complete. The key is the digest of the
+	// deploy manifest, the value is a JSON list of the blobs (registry/repository@digest) uploaded so far.
+	kindCommit = "commit"
+)
+
+// StoreOptions bound the contents of a Store.
+type StoreOptions struct {
+	// TTL is the time after which records are forgotten (for example because the registry may have
+	// garbage collected the blob). Zero keeps records forever.
+	TTL time.Duration
+	// MaxEntries is the maximum number of records. The oldest records are forgotten first.
+	// Zero means unlimited.
+	MaxEntries int
+}
+
+// Store remembers the blobs, manifests and tags that the syncer knows to exist in registries,
+// so that they are neither uploaded nor re-validated again.
+// With a state file, the store survives restarts of the syncer.
+//
+// The state file is an append-only log with one JSON record per line. It is read (and compacted)
+// when the store is opened. New records are appended and synced before put and remove return,
+// so a crash loses at most the records being written. Concurrent writers share a single fsync.
+// The log is compacted again once it holds more stale (overwritten, removed, expired or evicted)
+// records than live ones.
+type Store struct {
+	mu      sync.Mutex
+	opts    StoreOptions
+	path    string
+	file    *os.File
+	entries map[storeKey]storeEntry
+	// logged is the number of records in the state file.
+	logged int
+	// expiredAt is the last time expired records were dropped.
+	expiredAt time.Time
+	now       func() time.Time
+
+	// written counts the records appended to the state file, synced the ones known to be on disk.
+	// syncMu is held while syncing, so that writers waiting for the same records share one fsync.
+	written uint64
+	synced  uint64
+	syncMu  sync.Mutex
+}
+
+type storeKey struct {
+	kind string
+	key  string
+}
+
+type storeEntry struct {
+	value string
+	time  time.Time
+}
+
+// storeRecord is a single line of the state file.
+type storeRecord struct {
+	// Kind is one of kindBlob, kindManifest, kindTag or kindCommit. Records without a kind are manifests.
+	Kind    string    `json:"kind,omitempty"`
+	Key     string    `json:"key"`
+	Value   string    `json:"value,omitempty"`
+	Time    time.Time `json:"time"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// newMemoryStore returns a store that is not persisted.
+func newMemoryStore(opts StoreOptions) *Store {
+	return &Store{
+		opts:    opts,
+		entries: make(map[storeKey]storeEntry),
+		now:     time.Now,
+	}
+}
+
+// OpenStore opens (or creates) the state file at path and loads its records.
+// Lines that cannot be parsed (like a line torn by a crash) are skipped,
+// and expired records are dropped. An empty path returns a store that is kept in memory only.
+func OpenStore(path string, opts StoreOptions) (*Store, error) {
+	store := newMemoryStore(opts)
+	if path == "" {
+		return store, nil
+	}
+	store.path = path
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.compactLocked(); err != nil {
+		return nil, err
+	}
+	slog.Info("Loaded state file", "path", path, "records", len(store.entries))
+	return store, nil
+}
+
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening state file: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	skipped := 0
+	for scanner.Scan() {
+		var record storeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Key == "" {
+			skipped++
+			continue
+		}
+		if record.Kind == "" {
+			record.Kind = kindManifest
+		}
+		key := storeKey{kind: record.Kind, key: record.Key}
+		if record.Deleted {
+			delete(s.entries, key)
+			continue
+		}
+		s.entries[key] = storeEntry{value: record.Value, time: record.Time}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading state file: %w", err)
+	}
+	if skipped > 0 {
+		slog.Warn("Skipped unreadable records of state file", "path", s.path, "records", skipped)
+	}
+	s.expireLocked()
+	s.evictLocked()
+	return nil
+}
+
+// get returns the value of a record, or false if there is no record or it expired.
+func (s *Store) get(kind, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[storeKey{kind: kind, key: key}]
+	if !ok {
+		return "", false
+	}
+	if s.expired(entry) {
+		delete(s.entries, storeKey{kind: kind, key: key})
+		return "", false
+	}
+	return entry.value, true
+}
+
+// has reports whether there is a record that did not expire.
+func (s *Store) has(kind, key string) bool {
+	_, ok := s.get(kind, key)
+	return ok
+}
+
+// put records a value and appends it to the state file.
+// If the record cannot be persisted, it is still remembered in memory.
+func (s *Store) put(kind, key, value string) error {
+	s.mu.Lock()
+	k := storeKey{kind: kind, key: key}
+	if entry, ok := s.entries[k]; ok && entry.value == value && !s.expired(entry) {
+		s.mu.Unlock()
+		return nil
+	}
+	now := s.now().UTC()
+	s.entries[k] = storeEntry{value: value, time: now}
+	s.maybeExpireLocked()
+	s.evictLocked()
+	seq, err := s.writeLocked(storeRecord{Kind: kind, Key: key, Value: value, Time: now})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.syncTo(seq)
+}
+
+// remove forgets a record.
+func (s *Store) remove(kind, key string) error {
+	s.mu.Lock()
+	k := storeKey{kind: kind, key: key}
+	if _, ok := s.entries[k]; !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.entries, k)
+	seq, err := s.writeLocked(storeRecord{Kind: kind, Key: key, Time: s.now().UTC(), Deleted: true})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.syncTo(seq)
+}
+
+// writeLocked appends the record to the state file and compacts the file if needed.
+// It returns the sequence number to pass to syncTo.
+func (s *Store) writeLocked(record storeRecord) (uint64, error) {
+	if s.file == nil {
+		return 0, nil
+	}
+	if err := s.appendLocked(record); err != nil {
+		return 0, err
+	}
+	if err := s.maybeCompactLocked(); err != nil {
+		return 0, err
+	}
+	return s.written, nil
+}
+
+// syncTo returns once the record with the sequence number seq is on disk.
+// Records appended by other writers in the meantime are synced with the same fsync.
+func (s *Store) syncTo(seq uint64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	if s.file == nil || s.synced >= seq {
+		s.mu.Unlock()
+		return nil
+	}
+	file, target := s.file, s.written
+	s.mu.Unlock()
+
+	err := file.Sync()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced >= seq {
+		// the file was compacted or closed (and synced) concurrently
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("syncing state file: %w", err)
+	}
+	s.synced = target
+	return nil
+}
+
+// snapshot returns the keys and values of all records of a kind.
+func (s *Store) snapshot(kind string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make(map[string]string)
+	for k, entry := range s.entries {
+		if k.kind == kind && !s.expired(entry) {
+			records[k.key] = entry.value
+		}
+	}
+	return records
+}
+
+// Close closes the state file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	s.synced = s.written
+	return err
+}
+
+func (s *Store) expired(entry storeEntry) bool {
+	return s.opts.TTL > 0 && s.now().Sub(entry.time) > s.opts.TTL
+}
+
+func (s *Store) expireLocked() {
+	if s.opts.TTL <= 0 {
+		return
+	}
+	s.expiredAt = s.now()
+	for k, entry := range s.entries {
+		if s.expired(entry) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// maybeExpireLocked drops expired records if a tenth of the TTL passed since they were last dropped,
+// so that the state file is compacted even if records only expire.
+func (s *Store) maybeExpireLocked() {
+	if s.opts.TTL > 0 && s.now().Sub(s.expiredAt) > s.opts.TTL/10 {
+		s.expireLocked()
+	}
+}
+
+// evictLocked forgets the oldest records if the store holds more than MaxEntries records.
+// A tenth of the capacity is freed at once, so that eviction is not needed on every put.
+func (s *Store) evictLocked() {
+	if s.opts.MaxEntries <= 0 || len(s.entries) <= s.opts.MaxEntries {
+		return
+	}
+	s.expireLocked()
+	if len(s.entries) <= s.opts.MaxEntries {
+		return
+	}
+	keys := make([]storeKey, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.entries[keys[i]].time.Before(s.entries[keys[j]].time)
+	})
+	target := s.opts.MaxEntries - s.opts.MaxEntries/10
+	for _, k := range keys[:len(keys)-target] {
+		delete(s.entries, k)
+	}
+}
+
+func (s *Store) appendLocked(record storeRecord) error {
+	if s.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("appending to state file: %w", err)
+	}
+	s.logged++
+	s.written++
+	return nil
+}
+
+// maybeCompactLocked rewrites the state file once it holds more stale records than live ones.
+func (s *Store) maybeCompactLocked() error {
+	if s.file == nil || s.logged <= 2*len(s.entries) {
+		return nil
+	}
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the state file with the live records and reopens it for appending.
+// The new file replaces the old one atomically.
+func (s *Store) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("compacting state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for k, entry := range s.entries {
+		if err := encoder.Encode(storeRecord{Kind: k.kind, Key: k.key, Value: entry.value, Time: entry.time}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("compacting state file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compacting state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compacting state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("compacting state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("compacting state file: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		s.file = nil
+		return fmt.Errorf("reopening state file: %w", err)
+	}
+	s.logged = len(s.entries)
+	// the compacted file was synced before it replaced the log
+	s.synced = s.written
+	return nil
+}
@@ -0,0 +1,218 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for stores.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestStoreLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour)
+	lines := []string{
+		// records without a kind are manifests
+		fmt.Sprintf(`{"key":"r.example.com/app@sha256:aa","time":%q}`, now.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"blob","key":"r.example.com/app@sha256:bb","time":%q}`, now.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"blob","key":"r.example.com/app@sha256:cc","time":%q}`, now.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"blob","key":"r.example.com/app@sha256:cc","time":%q,"deleted":true}`, now.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"tag","key":"r.example.com/app:latest","value":"sha256:aa","time":%q}`, old.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"tag","key":"r.example.com/app:latest","value":"sha256:dd","time":%q}`, now.Format(time.RFC3339Nano)),
+		fmt.Sprintf(`{"kind":"tag","key":"r.example.com/app:v1","value":"sha256:aa","time":%q}`, old.Format(time.RFC3339Nano)),
+		`{"kind":"blob","key":"r.example.com/app@sha256:torn`,
+	}
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line+"\n"...)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenStore(path, StoreOptions{TTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if !store.has(kindManifest, "r.example.com/app@sha256:aa") {
+		t.Error("record without kind was not loaded as manifest")
+	}
+	if !store.has(kindBlob, "r.example.com/app@sha256:bb") {
+		t.Error("blob was not loaded")
+	}
+	if store.has(kindBlob, "r.example.com/app@sha256:cc") {
+		t.Error("deleted blob was loaded")
+	}
+	if digest, _ := store.get(kindTag, "r.example.com/app:latest"); digest != "sha256:dd" {
+		t.Errorf("tag points to %q, want the last record sha256:dd", digest)
+	}
+	if store.has(kindTag, "r.example.com/app:v1") {
+		t.Error("expired tag was loaded")
+	}
+	// the file is compacted to the live records when it is opened
+	if got := countLines(t, path); got != 3 {
+		t.Errorf("state file has %d lines after open, want 3", got)
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := OpenStore(path, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.put(kindBlob, fmt.Sprintf("r.example.com/app@sha256:%02d", i), ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := store.remove(kindBlob, "r.example.com/app@sha256:00"); err != nil {
+		t.Fatal(err)
+	}
+	// not closed, like after a crash: every record that was put must be on disk
+	reopened, err := OpenStore(path, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := len(reopened.snapshot(kindBlob)); got != 49 {
+		t.Errorf("reopened store has %d blobs, want 49", got)
+	}
+	if reopened.has(kindBlob, "r.example.com/app@sha256:00") {
+		t.Error("removed blob is still in the state file")
+	}
+	store.Close()
+}
+
+func TestStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := OpenStore(path, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// every put of a moved tag leaves a stale record behind
+	for i := range 100 {
+		if err := store.put(kindTag, "r.example.com/app:latest", fmt.Sprintf("sha256:%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if got := countLines(t, path); got > 3 {
+			t.Fatalf("state file has %d lines for a single tag after %d puts", got, i+1)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenStore(path, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if digest, _ := reopened.get(kindTag, "r.example.com/app:latest"); digest != "sha256:99" {
+		t.Errorf("tag points to %q after compaction, want sha256:99", digest)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := OpenStore(path, StoreOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	clock := &fakeClock{now: time.Now()}
+	store.now = clock.Now
+
+	for i := range 100 {
+		if err := store.put(kindBlob, fmt.Sprintf("r.example.com/app@sha256:%03d", i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(30 * time.Minute)
+	if !store.has(kindBlob, "r.example.com/app@sha256:000") {
+		t.Error("blob expired before the TTL")
+	}
+	clock.Advance(31 * time.Minute)
+	if store.has(kindBlob, "r.example.com/app@sha256:000") {
+		t.Error("blob did not expire after the TTL")
+	}
+	if err := store.put(kindBlob, "r.example.com/app@sha256:000", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !store.has(kindBlob, "r.example.com/app@sha256:000") {
+		t.Error("expired blob was not put again")
+	}
+
+	// expired records are dropped from the state file by writes, without MaxEntries
+	for i := range 3 {
+		if err := store.put(kindTag, "r.example.com/app:latest", fmt.Sprintf("sha256:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := countLines(t, path); got > 4 {
+		t.Errorf("state file has %d lines, want the expired records to be compacted away", got)
+	}
+	if got := len(store.snapshot(kindBlob)); got != 1 {
+		t.Errorf("store has %d blobs, want 1", got)
+	}
+}
+
+func TestStoreMaxEntries(t *testing.T) {
+	store := newMemoryStore(StoreOptions{MaxEntries: 100})
+	clock := &fakeClock{now: time.Now()}
+	store.now = clock.Now
+	for i := range 101 {
+		clock.Advance(time.Second)
+		if err := store.put(kindBlob, fmt.Sprintf("r.example.com/app@sha256:%03d", i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a tenth of the capacity is freed, oldest first
+	if got := len(store.snapshot(kindBlob)); got != 90 {
+		t.Errorf("store has %d blobs, want 90", got)
+	}
+	if store.has(kindBlob, "r.example.com/app@sha256:010") {
+		t.Error("old blob was not evicted")
+	}
+	if !store.has(kindBlob, "r.example.com/app@sha256:011") || !store.has(kindBlob, "r.example.com/app@sha256:100") {
+		t.Error("new blobs were evicted")
+	}
+}
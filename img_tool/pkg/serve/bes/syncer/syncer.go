@@ -11,19 +11,32 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/malt3/go-containerregistry/pkg/name"
 	v1 "github.com/malt3/go-containerregistry/pkg/v1"
 	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/malt3/go-containerregistry/pkg/v1/types"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+const (
+	// maxUploadAttempts is the number of times a single blob upload is attempted
+	// before it is reported as failed.
+	maxUploadAttempts = 3
+	// initialRetryBackoff is the delay before the first retry of a failed blob upload.
+	// It doubles with every subsequent attempt.
+	initialRetryBackoff = time.Second
 )
 
 // uploadJob represents a single blob upload task for the worker pool.
@@ -35,7 +48,98 @@ type uploadJob struct {
 	desc       api.Descriptor
 	pushOp     api.IndexedPushDeployOperation
 	remoteOpts []remote.Option
-	result     chan error
+	transfer   *transfer
+}
+
+// transfer tracks the progress of a single blob upload.
+// The done channel is closed once the upload finished (successfully or not).
+// All other fields must only be read after done is closed.
+// Multiple callers may wait on the same transfer, which is how concurrent
+// requests for the same blob are deduplicated.
+type transfer struct {
+	done     chan struct{}
+	err      error
+	attempts int
+}
+
+func newTransfer() *transfer {
+	return &transfer{done: make(chan struct{})}
+}
+
+// finish records the outcome of the transfer and wakes up all waiters.
+func (t *transfer) finish(attempts int, err error) {
+	t.attempts = attempts
+	t.err = err
+	close(t.done)
+}
+
+// BlobResult describes the outcome of uploading a single blob during a commit.
+type BlobResult struct {
+	// Repository is the destination of the blob (registry/repository).
+	Repository string
+	// Digest is the digest of the blob (sha256:...).
+	Digest string
+	// Size is the size of the blob in bytes.
+	Size int64
+	// Attempts is the number of upload attempts made for the blob.
+	// It is zero if the blob was already uploaded by an earlier commit.
+	Attempts int
+	// Err is the error of the last attempt, or nil if the upload succeeded.
+	Err error
+}
+
+// CommitResult is a structured report of a single Commit call.
+// It lists every blob that was uploaded (or found to be present already)
+// and every blob that could not be uploaded, even if the commit failed.
+type CommitResult struct {
+	// Pushed lists the references (registry/repository@digest) of all
+	// root manifests and indexes that were written to a registry.
+	Pushed []string
+	// Succeeded lists the blobs that are known to exist in the destination.
+	Succeeded []BlobResult
+	// Failed lists the blobs that could not be uploaded.
+	Failed []BlobResult
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+	// resumed holds the blobs (repository@digest) that an earlier, incomplete commit
+	// of the same deploy manifest uploaded. They are not uploaded again.
+	resumed map[string]struct{}
+}
+
+func newCommitResult() *CommitResult {
+	return &CommitResult{seen: make(map[string]struct{})}
+}
+
+func blobResultKey(repository, digest string) string {
+	return repository + "@" + digest
+}
+
+func (r *CommitResult) recordBlob(blob BlobResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := blobResultKey(blob.Repository, blob.Digest)
+	if _, ok := r.seen[key]; ok {
+		return
+	}
+	r.seen[key] = struct{}{}
+	if blob.Err != nil {
+		r.Failed = append(r.Failed, blob)
+	} else {
+		r.Succeeded = append(r.Succeeded, blob)
+	}
+}
+
+// uploadedBefore reports whether an earlier commit of the same deploy manifest uploaded the blob.
+func (r *CommitResult) uploadedBefore(repository, digest string) bool {
+	_, ok := r.resumed[blobResultKey(repository, digest)]
+	return ok
+}
+
+func (r *CommitResult) recordPushed(ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pushed = append(r.Pushed, ref)
 }
 
 // makeUploadKey creates a composite key for tracking blob uploads.
@@ -75,53 +179,58 @@ type Syncer struct {
 	cacheMutex    sync.RWMutex
 
 	// Track ongoing blob transfers to avoid duplicates
-	ongoingTransfers map[string]chan error
+	ongoingTransfers map[string]*transfer
 	transferMutex    sync.Mutex
 
-	// Track uploaded blobs to avoid duplicate uploads
-	uploadedBlobs map[string]struct{}
-	uploadMutex   sync.RWMutex
-
-	// Track uploaded tags to avoid duplicate tagging
-	// Maps registry/repository:tag to digest
-	uploadedTags map[string]string
-	tagMutex     sync.RWMutex
+	// Track blobs, root manifests, and tags known to exist in registries to avoid duplicate uploads.
+	// Blobs and manifests are keyed by registry/repository@digest; operations with a known root
+	// skip straight to tagging. Tags map registry/repository:tag to the digest they point to.
+	// The state may be persisted (see OpenStore), so that it survives restarts.
+	state *Store
 
 	// Worker pool for blob uploads
 	workQueue   chan *uploadJob
 	workerCount int
 	shutdown    chan struct{}
 	workerWg    sync.WaitGroup
-}
 
-// New creates a new Syncer instance with the default worker count of 4.
-// This is a convenience function that calls NewWithWorkers with a default
-// worker pool size suitable for most use cases.
-func New(casClient *cas.CAS) *Syncer {
-	return NewWithWorkers(casClient, 4)
+	// Limits to avoid overwhelming registries
+	registryLimits   *registrySemaphores
+	bandwidthLimiter *bandwidthLimiter
 }
 
-// NewWithWorkers creates a new Syncer instance with the specified number of workers.
-// The worker count determines how many blob uploads can occur concurrently.
-// If workerCount is <= 0, it defaults to 4.
+// New creates a new Syncer instance.
+// Without options, it uses 4 upload workers and applies no per-registry
+// concurrency limit or bandwidth cap.
 //
 // The syncer immediately starts all worker goroutines and begins processing
 // upload jobs from the work queue. The work queue is buffered to 2x the worker
 // count for better throughput.
-func NewWithWorkers(casClient *cas.CAS, workerCount int) *Syncer {
+func New(casClient *cas.CAS, opts ...Option) *Syncer {
+	syncerOpts := &syncerOptions{
+		workerCount: defaultWorkerCount,
+	}
+	for _, opt := range opts {
+		opt(syncerOpts)
+	}
+	workerCount := syncerOpts.workerCount
 	if workerCount <= 0 {
-		workerCount = 4
+		workerCount = defaultWorkerCount
 	}
 
 	s := &Syncer{
 		casClient:        casClient,
 		metadataCache:    make(map[string][]byte),
-		ongoingTransfers: make(map[string]chan error),
-		uploadedBlobs:    make(map[string]struct{}),
-		uploadedTags:     make(map[string]string),
+		ongoingTransfers: make(map[string]*transfer),
 		workQueue:        make(chan *uploadJob, workerCount*2), // Buffer for better performance
 		workerCount:      workerCount,
 		shutdown:         make(chan struct{}),
+		registryLimits:   newRegistrySemaphores(syncerOpts.defaultRegistryConcurrency, syncerOpts.registryConcurrency),
+		bandwidthLimiter: newBandwidthLimiter(syncerOpts.bandwidthLimit),
+		state:            syncerOpts.store,
+	}
+	if s.state == nil {
+		s.state = newMemoryStore(StoreOptions{})
 	}
 
 	// Start worker goroutines
@@ -130,10 +239,28 @@ func NewWithWorkers(casClient *cas.CAS, workerCount int) *Syncer {
 		go s.worker(i)
 	}
 
-	log.Printf("Started syncer with %d worker goroutines", workerCount)
+	slog.Info("Started syncer", "workers", workerCount)
+	if syncerOpts.defaultRegistryConcurrency > 0 {
+		slog.Info("Limiting concurrent blob uploads per registry", "limit", syncerOpts.defaultRegistryConcurrency)
+	}
+	for registry, limit := range syncerOpts.registryConcurrency {
+		slog.Info("Limiting concurrent blob uploads", "registry", registry, "limit", limit)
+	}
+	if syncerOpts.bandwidthLimit > 0 {
+		slog.Info("Limiting upload bandwidth", "bytes-per-second", syncerOpts.bandwidthLimit)
+	}
 	return s
 }
 
+// NewWithWorkers creates a new Syncer instance with the specified number of workers.
+// The worker count determines how many blob uploads can occur concurrently.
+// If workerCount is <= 0, it defaults to 4.
+//
+// Deprecated: Use New(casClient, WithWorkers(workerCount)).
+func NewWithWorkers(casClient *cas.CAS, workerCount int) *Syncer {
+	return New(casClient, WithWorkers(workerCount))
+}
+
 // Shutdown gracefully stops the worker pool and waits for all workers to complete.
 // It closes the shutdown channel to signal workers to stop, then waits for all
 // worker goroutines to finish their current tasks and exit.
@@ -141,10 +268,13 @@ func NewWithWorkers(casClient *cas.CAS, workerCount int) *Syncer {
 // This method blocks until all workers have stopped. Any jobs still in the queue
 // will not be processed after shutdown begins.
 func (s *Syncer) Shutdown() {
-	log.Println("Shutting down syncer worker pool...")
+	slog.Info("Shutting down syncer worker pool...")
 	close(s.shutdown)
 	s.workerWg.Wait()
-	log.Println("Syncer worker pool shutdown complete")
+	if err := s.state.Close(); err != nil {
+		slog.Error("Failed to close state file", logging.ErrKey, err)
+	}
+	slog.Info("Syncer worker pool shutdown complete")
 }
 
 // Commit uploads a container image or index to the registry.
@@ -159,43 +289,114 @@ func (s *Syncer) Shutdown() {
 //
 // The upload process uses deduplication to avoid uploading the same blob multiple times
 // and leverages the worker pool for concurrent blob uploads.
-func (s *Syncer) Commit(ctx context.Context, digest string, sizeBytes int64) error {
+//
+// Failed blob uploads are retried with exponential backoff. A failing blob does not
+// abort the uploads of other blobs: the returned CommitResult always lists every blob
+// that was uploaded and every blob that failed, alongside a joined error.
+//
+// Commit is idempotent. Calling it again for the same digest (for example because
+// a BES event was re-sent) resumes a partially pushed image: blobs that were
+// uploaded before are skipped, and only the failed blobs, manifests, and tags are retried.
+// Incomplete commits are remembered in the store (see WithStore), so they are bounded by its
+// TTL and maximum number of entries, and resume after a restart if the store is persisted.
+//
+// Commit used to return only an error. Callers that are not interested in the
+// individual blobs can ignore the CommitResult, which is never nil.
+func (s *Syncer) Commit(ctx context.Context, digest string, sizeBytes int64) (result *CommitResult, err error) {
+	result = newCommitResult()
+	defer func() {
+		if err != nil {
+			commitsTotal.With("error").Inc()
+		} else {
+			commitsTotal.With("success").Inc()
+		}
+	}()
+
 	// Parse digest and retrieve push metadata from CAS
 	digestBytes, err := hex.DecodeString(digest)
 	if err != nil {
-		return fmt.Errorf("invalid digest format: %w", err)
+		return result, fmt.Errorf("invalid digest format: %w", err)
 	}
 
 	casDigest := cas.SHA256(digestBytes, sizeBytes)
 	metadataBytes, err := s.getCachedOrFetch(ctx, casDigest)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve push metadata from CAS: %w", err)
+		return result, fmt.Errorf("failed to retrieve push metadata from CAS: %w", err)
 	}
 
 	var metadata api.DeployManifest
 	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
-		return fmt.Errorf("failed to parse push metadata: %w", err)
+		return result, fmt.Errorf("failed to parse push metadata: %w", err)
 	}
 
 	pushOps, err := metadata.PushOperations()
 	if err != nil {
-		return fmt.Errorf("failed to get push operations from metadata: %w", err)
+		return result, fmt.Errorf("failed to get push operations from metadata: %w", err)
 	}
 	if len(metadata.Operations) == 0 {
 		// don't check for len of pushOps, since this may still contain load operations
-		return errors.New("no push operations found in metadata")
+		return result, errors.New("no push operations found in metadata")
+	}
+
+	if resumed, ok := s.partialCommit(digest); ok {
+		result.resumed = resumed
+		slog.Info("Resuming partially pushed deploy manifest", "digest", digest, "uploaded", len(resumed))
 	}
 
+	// Commit every operation, even if an earlier one failed.
+	var errs []error
 	for _, op := range pushOps {
-		if err := s.commitOne(ctx, op); err != nil {
-			return fmt.Errorf("failed to commit image %s: %w", op.Root.Digest, err)
+		if err := s.commitOne(ctx, op, result); err != nil {
+			errs = append(errs, fmt.Errorf("failed to commit image %s: %w", op.Root.Digest, err))
 		}
 	}
 
-	return nil
+	if len(errs) > 0 {
+		s.recordPartialCommit(digest, result)
+		slog.Warn("Commit of deploy manifest incomplete", "digest", digest, "uploaded", len(result.Succeeded), "failed", len(result.Failed))
+		return result, errors.Join(errs...)
+	}
+	if err := s.state.remove(kindCommit, digest); err != nil {
+		slog.Warn("Failed to forget incomplete commit", "digest", digest, logging.ErrKey, err)
+	}
+	return result, nil
+}
+
+// partialCommit returns the blobs (repository@digest) that an incomplete commit of the deploy manifest uploaded.
+func (s *Syncer) partialCommit(digest string) (map[string]struct{}, bool) {
+	value, ok := s.state.get(kindCommit, digest)
+	if !ok {
+		return nil, false
+	}
+	var uploaded []string
+	if err := json.Unmarshal([]byte(value), &uploaded); err != nil {
+		slog.Warn("Ignoring unreadable record of incomplete commit", "digest", digest, logging.ErrKey, err)
+		return nil, false
+	}
+	resumed := make(map[string]struct{}, len(uploaded))
+	for _, key := range uploaded {
+		resumed[key] = struct{}{}
+	}
+	return resumed, true
 }
 
-func (s *Syncer) commitOne(ctx context.Context, pushOp api.IndexedPushDeployOperation) error {
+// recordPartialCommit remembers the blobs that an incomplete commit of the deploy manifest uploaded.
+func (s *Syncer) recordPartialCommit(digest string, result *CommitResult) {
+	uploaded := make([]string, 0, len(result.Succeeded))
+	for _, blob := range result.Succeeded {
+		uploaded = append(uploaded, blobResultKey(blob.Repository, blob.Digest))
+	}
+	sort.Strings(uploaded)
+	value, err := json.Marshal(uploaded)
+	if err != nil {
+		return
+	}
+	if err := s.state.put(kindCommit, digest, string(value)); err != nil {
+		slog.Warn("Failed to remember incomplete commit", "digest", digest, logging.ErrKey, err)
+	}
+}
+
+func (s *Syncer) commitOne(ctx context.Context, pushOp api.IndexedPushDeployOperation, result *CommitResult) error {
 	// Parse base reference without tag for digest-based push
 	baseReference := fmt.Sprintf("%s/%s",
 		pushOp.PushTarget.Registry,
@@ -214,24 +415,30 @@ func (s *Syncer) commitOne(ctx context.Context, pushOp api.IndexedPushDeployOper
 	rootBlob := pushOp.Root
 	mediaType := types.MediaType(rootBlob.MediaType)
 
-	if mediaType.IsIndex() {
-		err = s.pushIndex(ctx, ref, pushOp, remoteOpts)
-	} else if mediaType.IsImage() {
-		err = s.pushImage(ctx, ref, pushOp, remoteOpts)
+	if s.rootExists(ref, rootBlob, remoteOpts) {
+		// A manifest is only accepted by a registry if all of its blobs exist,
+		// so there is nothing to upload and only the tags may differ from an earlier build.
+		manifestShortCircuitsTotal.Inc()
+		slog.Debug("Image already exists, skipping blob uploads", "image", ref.Name()+"@"+rootBlob.Digest)
 	} else {
-		return fmt.Errorf("unsupported root media type: %s", mediaType)
-	}
-
-	if err != nil {
-		return err
+		if mediaType.IsIndex() {
+			err = s.pushIndex(ctx, ref, pushOp, remoteOpts, result)
+		} else if mediaType.IsImage() {
+			err = s.pushImage(ctx, ref, pushOp, remoteOpts, result)
+		} else {
+			return fmt.Errorf("unsupported root media type: %s", mediaType)
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.state.put(kindManifest, makeUploadKey(rootBlob.Digest, ref), ""); err != nil {
+			slog.Warn("Failed to remember image", "image", ref.Name()+"@"+rootBlob.Digest, logging.ErrKey, err)
+		}
 	}
 
 	needsTagging := false
 	for _, tag := range pushOp.PushTarget.Tags {
-		tagKey := makeTagKey(ref, tag)
-		s.tagMutex.RLock()
-		cachedDigest, exists := s.uploadedTags[tagKey]
-		s.tagMutex.RUnlock()
+		cachedDigest, exists := s.state.get(kindTag, makeTagKey(ref, tag))
 		if !exists || cachedDigest != rootBlob.Digest {
 			needsTagging = true
 			break
@@ -239,7 +446,7 @@ func (s *Syncer) commitOne(ctx context.Context, pushOp api.IndexedPushDeployOper
 	}
 
 	if !needsTagging {
-		log.Printf("All tags already point to %s@%s, skipping tagging", ref.Name(), rootBlob.Digest)
+		slog.Debug("All tags already point to image, skipping tagging", "image", ref.Name()+"@"+rootBlob.Digest)
 		return nil
 	}
 
@@ -257,31 +464,49 @@ func (s *Syncer) commitOne(ctx context.Context, pushOp api.IndexedPushDeployOper
 		tagKey := makeTagKey(ref, tag)
 
 		// Check if tag already points to the correct digest
-		s.tagMutex.RLock()
-		cachedDigest, exists := s.uploadedTags[tagKey]
-		s.tagMutex.RUnlock()
+		cachedDigest, exists := s.state.get(kindTag, tagKey)
 		if exists && cachedDigest == rootBlob.Digest {
-			log.Printf("Tag %s already points to %s@%s, skipping", tag, ref.Name(), rootBlob.Digest)
+			slog.Debug("Tag already points to image, skipping", "tag", tag, "image", ref.Name()+"@"+rootBlob.Digest)
 			continue
 		}
 
 		tagRef := ref.Tag(tag)
 
 		if err := remote.Tag(tagRef, desc, remoteOpts...); err != nil {
+			errorsTotal.With("tag").Inc()
 			return fmt.Errorf("failed to tag %s as %s: %w", rootBlob.Digest, tag, err)
 		}
 
 		// Update cache with the new digest for this tag
-		s.tagMutex.Lock()
-		s.uploadedTags[tagKey] = rootBlob.Digest
-		s.tagMutex.Unlock()
+		if err := s.state.put(kindTag, tagKey, rootBlob.Digest); err != nil {
+			slog.Warn("Failed to remember tag", "tag", tagRef.String(), logging.ErrKey, err)
+		}
 
-		log.Printf("Tagged %s as %s", rootBlob.Digest, tagRef.String())
+		slog.Info("Tagged image", "digest", rootBlob.Digest, "tag", tagRef.String())
 	}
 
 	return nil
 }
 
+// rootExists reports whether the root manifest or index of an operation already exists in the repository.
+// Roots that were pushed (or found) earlier are known without contacting the registry.
+// Otherwise, the registry is asked with a HEAD request for the digest.
+// Errors are not fatal: if the check fails, the operation is pushed as usual.
+func (s *Syncer) rootExists(ref name.Repository, root api.Descriptor, remoteOpts []remote.Option) bool {
+	key := makeUploadKey(root.Digest, ref)
+	if s.state.has(kindManifest, key) {
+		return true
+	}
+	desc, err := remote.Head(ref.Digest(root.Digest), remoteOpts...)
+	if err != nil || desc.Digest.String() != root.Digest {
+		return false
+	}
+	if err := s.state.put(kindManifest, key, ""); err != nil {
+		slog.Warn("Failed to remember image", "image", ref.Name()+"@"+root.Digest, logging.ErrKey, err)
+	}
+	return true
+}
+
 // getCachedOrFetch retrieves blob data from the in-memory cache or fetches it from CAS.
 // Small blobs (< 1MB) are automatically cached after fetching to improve performance
 // for frequently accessed metadata like manifests and configs.
@@ -300,8 +525,11 @@ func (s *Syncer) getCachedOrFetch(ctx context.Context, digest cas.Digest) ([]byt
 	s.cacheMutex.RUnlock()
 
 	// Not in cache, fetch from CAS
+	start := time.Now()
 	data, err := s.casClient.ReadBlob(ctx, digest)
+	casFetchDuration.ObserveDuration(start)
 	if err != nil {
+		errorsTotal.With("cas_fetch").Inc()
 		return nil, fmt.Errorf("failed to read blob from CAS: %w", err)
 	}
 
@@ -321,8 +549,8 @@ func (s *Syncer) getCachedOrFetch(ctx context.Context, digest cas.Digest) ([]byt
 //
 // The method uses the worker pool for concurrent layer uploads and deduplication
 // to avoid uploading the same blob multiple times.
-func (s *Syncer) pushImage(ctx context.Context, ref name.Repository, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) error {
-	log.Printf("Pushing image to %s", ref.Name())
+func (s *Syncer) pushImage(ctx context.Context, ref name.Repository, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option, result *CommitResult) error {
+	slog.Debug("Pushing image", "repository", ref.Name())
 	manifestBlob := pushOp.Root
 
 	// Get manifest from CAS
@@ -336,15 +564,9 @@ func (s *Syncer) pushImage(ctx context.Context, ref name.Repository, pushOp api.
 		return fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
-	// Upload layers first (with deduplication and concurrency)
-	if err := s.uploadLayers(ctx, ref, manifest.Layers, pushOp, remoteOpts); err != nil {
-		return fmt.Errorf("failed to upload layers: %w", err)
-	}
-
-	// Upload config blob
-	configResult := s.queueBlobUpload(ctx, ref, apiDescriptorFromV1(manifest.Config), pushOp, remoteOpts)
-	if err := <-configResult; err != nil {
-		return fmt.Errorf("failed to upload config: %w", err)
+	// Upload layers and config first (with deduplication and concurrency)
+	if err := s.uploadLayersAndConfig(ctx, ref, &manifest, pushOp, remoteOpts, result); err != nil {
+		return err
 	}
 
 	// Create and push manifest
@@ -358,10 +580,12 @@ func (s *Syncer) pushImage(ctx context.Context, ref name.Repository, pushOp api.
 	// Create a digest reference for pushing
 	digestRef := ref.Digest(manifestBlob.Digest)
 	if err := remote.Write(digestRef, img, remoteOpts...); err != nil {
+		errorsTotal.With("manifest_write").Inc()
 		return fmt.Errorf("failed to write manifest: %w", err)
 	}
+	result.recordPushed(digestRef.String())
 
-	log.Printf("Pushed image %s@%s", ref.Name(), manifestBlob.Digest)
+	slog.Info("Pushed image", "image", ref.Name()+"@"+manifestBlob.Digest)
 	return nil
 }
 
@@ -371,8 +595,10 @@ func (s *Syncer) pushImage(ctx context.Context, ref name.Repository, pushOp api.
 //
 // The method uses errgroups to upload multiple platform manifests concurrently,
 // with each manifest upload handled by uploadManifestAndLayers.
-func (s *Syncer) pushIndex(ctx context.Context, ref name.Repository, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) error {
-	log.Printf("Pushing index to %s", ref.Name())
+// A failure of one platform does not cancel the uploads of the other platforms,
+// so that a later retry only has to upload the remaining blobs.
+func (s *Syncer) pushIndex(ctx context.Context, ref name.Repository, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option, result *CommitResult) error {
+	slog.Debug("Pushing index", "repository", ref.Name())
 	indexBlob := pushOp.Root
 
 	// Get index from CAS
@@ -387,11 +613,11 @@ func (s *Syncer) pushIndex(ctx context.Context, ref name.Repository, pushOp api.
 	}
 
 	// Upload all manifests and their layers concurrently
-	eg, egCtx := errgroup.WithContext(ctx)
+	var eg errgroup.Group
 	for _, manifestDesc := range index.Manifests {
 		manifestDesc := manifestDesc // capture loop variable
 		eg.Go(func() error {
-			return s.uploadManifestAndLayers(egCtx, ref, manifestDesc, pushOp, remoteOpts)
+			return s.uploadManifestAndLayers(ctx, ref, manifestDesc, pushOp, remoteOpts, result)
 		})
 	}
 
@@ -410,10 +636,12 @@ func (s *Syncer) pushIndex(ctx context.Context, ref name.Repository, pushOp api.
 	// Create a digest reference for pushing
 	digestRef := ref.Digest(indexBlob.Digest)
 	if err := remote.WriteIndex(digestRef, idx, remoteOpts...); err != nil {
+		errorsTotal.With("index_write").Inc()
 		return fmt.Errorf("failed to write index: %w", err)
 	}
+	result.recordPushed(digestRef.String())
 
-	log.Printf("Pushed index %s@%s", ref.Name(), indexBlob.Digest)
+	slog.Info("Pushed index", "image", ref.Name()+"@"+indexBlob.Digest)
 	return nil
 }
 
@@ -422,7 +650,7 @@ func (s *Syncer) pushIndex(ctx context.Context, ref name.Repository, pushOp api.
 // manifest and layers before writing the index.
 //
 // It follows the proper upload order: layers, config, then the manifest blob itself.
-func (s *Syncer) uploadManifestAndLayers(ctx context.Context, ref name.Repository, manifestDesc v1.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) error {
+func (s *Syncer) uploadManifestAndLayers(ctx context.Context, ref name.Repository, manifestDesc v1.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option, result *CommitResult) error {
 	// Find the manifest blob in metadata
 	var manifestBlob *api.Descriptor
 	for _, manifestInfo := range pushOp.Manifests {
@@ -446,90 +674,106 @@ func (s *Syncer) uploadManifestAndLayers(ctx context.Context, ref name.Repositor
 		return fmt.Errorf("failed to parse manifest %s: %w", manifestDesc.Digest, err)
 	}
 
-	// Upload layers
-	if err := s.uploadLayers(ctx, ref, manifest.Layers, pushOp, remoteOpts); err != nil {
-		return fmt.Errorf("failed to upload layers for manifest %s: %w", manifestDesc.Digest, err)
-	}
-
-	// Upload config blob
-	configResult := s.queueBlobUpload(ctx, ref, apiDescriptorFromV1(manifest.Config), pushOp, remoteOpts)
-	if err := <-configResult; err != nil {
-		return fmt.Errorf("failed to upload config for manifest %s: %w", manifestDesc.Digest, err)
+	// Upload layers and config
+	if err := s.uploadLayersAndConfig(ctx, ref, &manifest, pushOp, remoteOpts, result); err != nil {
+		return fmt.Errorf("manifest %s: %w", manifestDesc.Digest, err)
 	}
 
 	// Upload the manifest itself
-	manifestResult := s.queueBlobUpload(ctx, ref, apiDescriptorFromV1(manifestDesc), pushOp, remoteOpts)
-	if err := <-manifestResult; err != nil {
+	if err := s.uploadBlobs(ctx, ref, []api.Descriptor{apiDescriptorFromV1(manifestDesc)}, pushOp, remoteOpts, result); err != nil {
 		return fmt.Errorf("failed to upload manifest %s: %w", manifestDesc.Digest, err)
 	}
 
 	return nil
 }
 
-// uploadLayers uploads multiple container layers concurrently using the worker pool.
-// It queues each layer for upload and waits for all uploads to complete before returning.
-// Deduplication is handled automatically by queueBlobUpload.
-//
-// If any layer fails to upload, the method returns immediately with an error.
-func (s *Syncer) uploadLayers(ctx context.Context, ref name.Repository, layers []v1.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) error {
-	if len(layers) == 0 {
-		return nil
+// uploadLayersAndConfig uploads all layers and the config blob of a manifest
+// concurrently using the worker pool.
+func (s *Syncer) uploadLayersAndConfig(ctx context.Context, ref name.Repository, manifest *v1.Manifest, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option, result *CommitResult) error {
+	blobs := make([]api.Descriptor, 0, len(manifest.Layers)+1)
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, apiDescriptorFromV1(layer))
+	}
+	blobs = append(blobs, apiDescriptorFromV1(manifest.Config))
+	if err := s.uploadBlobs(ctx, ref, blobs, pushOp, remoteOpts, result); err != nil {
+		return fmt.Errorf("failed to upload layers and config: %w", err)
 	}
+	return nil
+}
 
-	// Create result channels for each layer
-	results := make([]chan error, len(layers))
-	for i, layer := range layers {
-		results[i] = s.queueBlobUpload(ctx, ref, apiDescriptorFromV1(layer), pushOp, remoteOpts)
+// uploadBlobs uploads multiple blobs concurrently using the worker pool.
+// It queues each blob for upload and waits for all uploads to complete before returning.
+// Deduplication is handled automatically by queueBlobUpload.
+//
+// A failed upload does not stop the remaining uploads. The outcome of every blob
+// is recorded in result, and the returned error joins the errors of all failed blobs.
+func (s *Syncer) uploadBlobs(ctx context.Context, ref name.Repository, blobs []api.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option, result *CommitResult) error {
+	transfers := make([]*transfer, len(blobs))
+	for i, blob := range blobs {
+		if result.uploadedBefore(ref.Name(), blob.Digest) {
+			// uploaded by an earlier commit of the same deploy manifest
+			blobDedupHitsTotal.With("uploaded").Inc()
+			transfers[i] = newTransfer()
+			transfers[i].finish(0, nil)
+			continue
+		}
+		transfers[i] = s.queueBlobUpload(ctx, ref, blob, pushOp, remoteOpts)
 	}
 
 	// Wait for all uploads to complete
-	for i, result := range results {
-		if err := <-result; err != nil {
-			return fmt.Errorf("failed to upload layer %d: %w", i, err)
+	var errs []error
+	for i, t := range transfers {
+		<-t.done
+		result.recordBlob(BlobResult{
+			Repository: ref.Name(),
+			Digest:     blobs[i].Digest,
+			Size:       blobs[i].Size,
+			Attempts:   t.attempts,
+			Err:        t.err,
+		})
+		if t.err != nil {
+			errs = append(errs, t.err)
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-// queueBlobUpload queues a blob upload job with the worker pool and returns a result channel.
-// This method handles deduplication by checking if the blob is already uploaded or
-// currently being uploaded by another goroutine.
+// queueBlobUpload queues a blob upload job with the worker pool and returns the transfer
+// tracking it. This method handles deduplication by checking if the blob is already
+// uploaded or currently being uploaded by another goroutine.
 //
-// The returned channel will receive exactly one value: nil on success or an error.
-// Callers should read from this channel to wait for upload completion.
+// Callers should wait for the done channel of the returned transfer to be closed
+// before reading its outcome.
 //
 // Deduplication behavior:
-//   - If blob is already uploaded: returns immediately with nil
-//   - If blob upload is in progress: waits for the ongoing upload to complete
+//   - If blob is already uploaded: returns a finished transfer without error
+//   - If blob upload is in progress: returns the ongoing transfer
 //   - If blob is not uploaded: queues a new upload job
-func (s *Syncer) queueBlobUpload(ctx context.Context, ref name.Repository, desc api.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) chan error {
+func (s *Syncer) queueBlobUpload(ctx context.Context, ref name.Repository, desc api.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) *transfer {
 	digest := desc.Digest
 	uploadKey := makeUploadKey(digest, ref)
-	result := make(chan error, 1)
+	blobRequestsTotal.Inc()
 
 	// Check if already uploaded (deduplication)
-	s.uploadMutex.RLock()
-	if _, exists := s.uploadedBlobs[uploadKey]; exists {
-		s.uploadMutex.RUnlock()
-		result <- nil
-		return result
+	if s.state.has(kindBlob, uploadKey) {
+		blobDedupHitsTotal.With("uploaded").Inc()
+		t := newTransfer()
+		t.finish(0, nil)
+		return t
 	}
-	s.uploadMutex.RUnlock()
 
 	// Check if upload is in progress
 	s.transferMutex.Lock()
 	if ongoing, exists := s.ongoingTransfers[uploadKey]; exists {
 		s.transferMutex.Unlock()
-		// Wait for the ongoing transfer to complete and return its result
-		go func() {
-			result <- <-ongoing
-		}()
-		return result
+		blobDedupHitsTotal.With("in_flight").Inc()
+		return ongoing
 	}
 
 	// Mark as in progress
-	s.ongoingTransfers[uploadKey] = result
+	t := newTransfer()
+	s.ongoingTransfers[uploadKey] = t
 	s.transferMutex.Unlock()
 
 	// Queue the job
@@ -539,32 +783,39 @@ func (s *Syncer) queueBlobUpload(ctx context.Context, ref name.Repository, desc
 		desc:       desc,
 		pushOp:     pushOp,
 		remoteOpts: remoteOpts,
-		result:     result,
+		transfer:   t,
 	}
 
 	select {
 	case s.workQueue <- job:
 		// Job queued successfully
+		workQueueDepth.Set(float64(len(s.workQueue)))
 	case <-ctx.Done():
 		// Context canceled, clean up and return error
 		s.transferMutex.Lock()
 		delete(s.ongoingTransfers, uploadKey)
 		s.transferMutex.Unlock()
-		result <- ctx.Err()
+		t.finish(0, ctx.Err())
 	}
 
-	return result
+	return t
 }
 
 // uploadBlob performs the actual blob upload to the registry.
 // This method is called by worker goroutines to process queued upload jobs.
 // It creates a layer wrapper and uploads it to the registry using go-containerregistry.
-func (s *Syncer) uploadBlob(ctx context.Context, ref name.Repository, desc api.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) error {
+//
+// Retryable failures are retried up to maxUploadAttempts times with exponential backoff.
+// The number of attempts made is returned alongside the error of the last attempt.
+// Blobs that already exist in the registry are not uploaded again, which makes
+// retrying a partially failed commit cheap.
+func (s *Syncer) uploadBlob(ctx context.Context, ref name.Repository, desc api.Descriptor, pushOp api.IndexedPushDeployOperation, remoteOpts []remote.Option) (int, error) {
 	digest := desc.Digest
 	digestAsMissingBlob := strings.TrimPrefix(digest, "sha256:")
 	uploadKey := makeUploadKey(digest, ref)
 
 	var layer v1.Layer
+	source := "cas"
 
 	// Check if this is a missing blob from shallow base image pull
 	isMissing := false
@@ -582,6 +833,7 @@ func (s *Syncer) uploadBlob(ctx context.Context, ref name.Repository, desc api.D
 
 	if isMissing {
 		// Layer is from base image and not in CAS, stream from original registry
+		source = "remote"
 		layer = &remoteStreamingLayer{
 			digest:    digest,
 			diffID:    desc.DiffID,
@@ -602,17 +854,63 @@ func (s *Syncer) uploadBlob(ctx context.Context, ref name.Repository, desc api.D
 		}
 	}
 
+	if s.bandwidthLimiter != nil {
+		layer = &throttledLayer{Layer: layer, ctx: ctx, limiter: s.bandwidthLimiter}
+	}
+
+	// Respect the per-registry concurrency limit
+	release, err := s.registryLimits.acquire(ctx, ref.RegistryStr())
+	if err != nil {
+		return 0, fmt.Errorf("waiting for upload slot for %s: %w", ref.RegistryStr(), err)
+	}
+	defer release()
+
 	// Upload to registry
-	if err := remote.WriteLayer(ref, layer, remoteOpts...); err != nil {
-		return fmt.Errorf("failed to upload blob %s: %w", digest, err)
+	backoff := initialRetryBackoff
+	attempt := 1
+	for {
+		err := remote.WriteLayer(ref, layer, remoteOpts...)
+		if err == nil {
+			break
+		}
+		if attempt >= maxUploadAttempts || !isRetryable(err) {
+			blobUploadsTotal.With("error", source).Inc()
+			errorsTotal.With("blob_upload").Inc()
+			return attempt, fmt.Errorf("failed to upload blob %s after %d attempt(s): %w", digest, attempt, err)
+		}
+		slog.Warn("Blob upload failed, retrying", "digest", digest, "repository", ref.Name(), "attempt", fmt.Sprintf("%d/%d", attempt, maxUploadAttempts), "backoff", backoff, logging.ErrKey, err)
+		blobUploadRetriesTotal.Inc()
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("failed to upload blob %s: %w", digest, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		attempt++
 	}
+	blobUploadsTotal.With("success", source).Inc()
+	bytesPushedTotal.Add(float64(desc.Size))
 
 	// Mark as uploaded
-	s.uploadMutex.Lock()
-	defer s.uploadMutex.Unlock()
-	s.uploadedBlobs[uploadKey] = struct{}{}
+	if err := s.state.put(kindBlob, uploadKey, ""); err != nil {
+		slog.Warn("Failed to remember blob", "blob", uploadKey, logging.ErrKey, err)
+	}
 
-	return nil
+	return attempt, nil
+}
+
+// isRetryable reports whether a failed blob upload should be attempted again.
+// Registry errors are retried only if the registry reported them as temporary
+// (e.g. 5xx or 429), while other errors (network or CAS read failures) are always retried.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.Temporary()
+	}
+	return true
 }
 
 // worker is the main goroutine function for processing blob upload jobs.
@@ -632,35 +930,29 @@ func (s *Syncer) worker(id int) {
 		case <-s.shutdown:
 			return
 		case job := <-s.workQueue:
+			workQueueDepth.Set(float64(len(s.workQueue)))
 			func() {
 				digest := job.desc.Digest
 				uploadKey := makeUploadKey(digest, job.ref)
 
-				// Clean up ongoing transfer tracking when done
+				var attempts int
+				var err error
+
+				// Clean up ongoing transfer tracking and wake up waiters when done
 				defer func() {
 					s.transferMutex.Lock()
-					defer s.transferMutex.Unlock()
 					delete(s.ongoingTransfers, uploadKey)
+					s.transferMutex.Unlock()
+					job.transfer.finish(attempts, err)
 				}()
 
 				// Double-check if already uploaded (race condition protection)
-				s.uploadMutex.RLock()
-				alreadyUploaded := false
-				if _, exists := s.uploadedBlobs[uploadKey]; exists {
-					alreadyUploaded = true
-				}
-				s.uploadMutex.RUnlock()
-
-				if alreadyUploaded {
-					job.result <- nil
+				if s.state.has(kindBlob, uploadKey) {
 					return
 				}
 
 				// Perform the upload
-				err := s.uploadBlob(job.ctx, job.ref, job.desc, job.pushOp, job.remoteOpts)
-
-				// Send result
-				job.result <- err
+				attempts, err = s.uploadBlob(job.ctx, job.ref, job.desc, job.pushOp, job.remoteOpts)
 			}()
 		}
 	}
@@ -0,0 +1,139 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+func TestResumePartialCommit(t *testing.T) {
+	s := &Syncer{state: newMemoryStore(StoreOptions{})}
+	ref, err := name.NewRepository("registry.example.com/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const deployManifest = "0123456789abcdef"
+	uploaded := api.Descriptor{Digest: "sha256:aaaa", Size: 4}
+	failed := api.Descriptor{Digest: "sha256:bbbb", Size: 8}
+
+	if _, ok := s.partialCommit(deployManifest); ok {
+		t.Fatal("partialCommit() found a commit that was never recorded")
+	}
+
+	first := newCommitResult()
+	first.recordBlob(BlobResult{Repository: ref.Name(), Digest: uploaded.Digest, Size: uploaded.Size, Attempts: 1})
+	first.recordBlob(BlobResult{Repository: ref.Name(), Digest: failed.Digest, Size: failed.Size, Attempts: 3, Err: context.DeadlineExceeded})
+	s.recordPartialCommit(deployManifest, first)
+
+	resumed, ok := s.partialCommit(deployManifest)
+	if !ok {
+		t.Fatal("partialCommit() did not find the incomplete commit")
+	}
+	if _, ok := resumed[blobResultKey(ref.Name(), uploaded.Digest)]; !ok || len(resumed) != 1 {
+		t.Fatalf("partialCommit() = %v, want only the uploaded blob", resumed)
+	}
+
+	// The uploaded blob is skipped without a worker (there is none), even though the
+	// store does not know the blob.
+	second := newCommitResult()
+	second.resumed = resumed
+	if err := s.uploadBlobs(context.Background(), ref, []api.Descriptor{uploaded}, api.IndexedPushDeployOperation{}, nil, second); err != nil {
+		t.Fatalf("uploadBlobs() error = %v", err)
+	}
+	if len(second.Succeeded) != 1 || second.Succeeded[0].Attempts != 0 {
+		t.Errorf("uploadBlobs() recorded %+v, want the blob as succeeded without attempts", second.Succeeded)
+	}
+	if !second.uploadedBefore(ref.Name(), uploaded.Digest) || second.uploadedBefore(ref.Name(), failed.Digest) {
+		t.Error("uploadedBefore() does not match the incomplete commit")
+	}
+
+	if err := s.state.remove(kindCommit, deployManifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.partialCommit(deployManifest); ok {
+		t.Error("partialCommit() found a commit that was completed")
+	}
+}
+
+func TestPartialCommitsAreBounded(t *testing.T) {
+	s := &Syncer{state: newMemoryStore(StoreOptions{MaxEntries: 10})}
+	for i := range 100 {
+		s.recordPartialCommit(fmt.Sprintf("%064x", i), newCommitResult())
+	}
+	if got := len(s.state.snapshot(kindCommit)); got > 10 {
+		t.Errorf("store holds %d incomplete commits, want at most 10", got)
+	}
+}
+
+func TestRootExists(t *testing.T) {
+	var heads atomic.Int32
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/manifests/") {
+			heads.Add(1)
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	ref, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref.Tag("v1"), img); err != nil {
+		t.Fatal(err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := api.Descriptor{Digest: imgDigest.String()}
+	missing := api.Descriptor{Digest: "sha256:" + strings.Repeat("0", 64)}
+
+	s := &Syncer{state: newMemoryStore(StoreOptions{})}
+	if s.rootExists(ref, missing, nil) {
+		t.Error("rootExists() of a manifest the registry doesn't have = true, want false")
+	}
+	if s.state.has(kindManifest, makeUploadKey(missing.Digest, ref)) {
+		t.Error("rootExists() remembered a manifest the registry doesn't have")
+	}
+
+	heads.Store(0)
+	if !s.rootExists(ref, existing, nil) {
+		t.Fatal("rootExists() of an existing manifest = false, want true")
+	}
+	// the manifest is remembered, so the registry is asked only once
+	if !s.rootExists(ref, existing, nil) {
+		t.Error("rootExists() of a remembered manifest = false, want true")
+	}
+	if got := heads.Load(); got != 1 {
+		t.Errorf("registry received %d HEAD requests for manifests, want 1", got)
+	}
+
+	// errors of the registry are not fatal, the operation is pushed as usual
+	server.Close()
+	other, err := name.NewRepository(ref.RegistryStr() + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.rootExists(other, existing, nil) {
+		t.Error("rootExists() with an unreachable registry = true, want false")
+	}
+}
@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote/transport"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+)
+
+// emptyBlob is the digest of the empty blob, which every CAS can answer cheaply.
+var emptyBlob = func() cas.Digest {
+	hash := sha256.Sum256(nil)
+	return cas.SHA256(hash[:], 0)
+}()
+
+// CASCheck checks that the CAS can be reached and accepts the credentials of the connection.
+func CASCheck(client *cas.CAS) Check {
+	return func(ctx context.Context) error {
+		if _, err := client.FindMissingBlobs(ctx, []cas.Digest{emptyBlob}); err != nil {
+			return fmt.Errorf("querying CAS: %w", err)
+		}
+		return nil
+	}
+}
+
+// RegistryPushCheck checks that the registry credentials allow pushing to a repository
+// (like "ghcr.io/my-org/my-image"), by requesting a token with push scope.
+// Nothing is written to the repository.
+func RegistryPushCheck(repository string) (Check, error) {
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository for health check: %w", err)
+	}
+	return func(ctx context.Context) error {
+		auth, err := reg.MultiKeychain().Resolve(repo)
+		if err != nil {
+			return fmt.Errorf("resolving credentials for %s: %w", repo.Name(), err)
+		}
+		scopes := []string{repo.Scope(transport.PushScope)}
+		if _, err := transport.NewWithContext(ctx, repo.Registry, auth, reg.Transport(reg.BaseTransport()), scopes); err != nil {
+			return fmt.Errorf("authenticating to %s for push: %w", repo.Name(), err)
+		}
+		return nil
+	}, nil
+}
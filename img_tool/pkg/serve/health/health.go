@@ -0,0 +1,240 @@
+// Package health implements liveness and readiness probes for the servers of img.
+//
+// Checks (like "can the CAS be reached" or "are the registry credentials still valid")
+// run periodically in the background. The results are served on /healthz and /readyz
+// and through the standard gRPC health service, so that probes never wait for a check.
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+const (
+	// defaultInterval is the time between two runs of the checks.
+	defaultInterval = 30 * time.Second
+	// defaultTimeout bounds the duration of a single check.
+	defaultTimeout = 10 * time.Second
+	// defaultLivenessGrace is the time a check may fail before the server is reported as not alive.
+	defaultLivenessGrace = 5 * time.Minute
+)
+
+// Check returns an error if a dependency of the server is unhealthy.
+type Check func(ctx context.Context) error
+
+// Checker runs checks and reports their results.
+//
+// The server is ready once all checks passed on their latest run.
+// It is alive unless a check has been failing for longer than the liveness grace period,
+// which lets the orchestrator restart the server (and reconnect, or reload credentials)
+// without restarting it on every short outage.
+type Checker struct {
+	interval      time.Duration
+	timeout       time.Duration
+	livenessGrace time.Duration
+
+	mu     sync.Mutex
+	checks []*check
+	// checked is true once all checks ran at least once.
+	checked bool
+
+	grpcServer *health.Server
+}
+
+type check struct {
+	name string
+	fn   Check
+	// err is the result of the latest run.
+	err error
+	// failingSince is the time of the first failure in the current streak of failures.
+	failingSince time.Time
+}
+
+type checkerOption func(*Checker)
+
+// WithInterval sets the time between two runs of the checks (default 30s).
+func WithInterval(interval time.Duration) checkerOption {
+	return func(c *Checker) {
+		if interval > 0 {
+			c.interval = interval
+		}
+	}
+}
+
+// WithTimeout bounds the duration of a single check (default 10s).
+func WithTimeout(timeout time.Duration) checkerOption {
+	return func(c *Checker) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithLivenessGrace sets the time a check may fail before /healthz fails (default 5m).
+func WithLivenessGrace(grace time.Duration) checkerOption {
+	return func(c *Checker) {
+		if grace > 0 {
+			c.livenessGrace = grace
+		}
+	}
+}
+
+// New creates a Checker without checks.
+// Until checks are added and Run is called, the server is reported as alive and ready.
+func New(opts ...checkerOption) *Checker {
+	c := &Checker{
+		interval:      defaultInterval,
+		timeout:       defaultTimeout,
+		livenessGrace: defaultLivenessGrace,
+		checked:       true,
+		grpcServer:    health.NewServer(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Add registers a check. Checks should be added before Run is called.
+func (c *Checker) Add(name string, fn Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &check{name: name, fn: fn})
+	c.checked = false
+	c.grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Run runs the checks every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			c.grpcServer.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) runOnce(ctx context.Context) {
+	c.mu.Lock()
+	checks := append([]*check(nil), c.checks...)
+	c.mu.Unlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			errs[i] = chk.fn(checkCtx)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ready := true
+	for i, chk := range checks {
+		err := errs[i]
+		switch {
+		case err != nil && chk.err == nil:
+			slog.Warn("Health check failed", "check", chk.name, logging.ErrKey, err)
+			chk.failingSince = now
+		case err == nil && chk.err != nil:
+			slog.Info("Health check recovered", "check", chk.name)
+			chk.failingSince = time.Time{}
+		}
+		chk.err = err
+		if err != nil {
+			ready = false
+		}
+	}
+	c.checked = true
+	if ready {
+		c.grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	} else {
+		c.grpcServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// GRPCServer returns the gRPC health service. Its overall status ("") is SERVING while the server is ready.
+// Register it with healthpb.RegisterHealthServer.
+func (c *Checker) GRPCServer() *health.Server {
+	return c.grpcServer
+}
+
+// Register adds the /healthz (liveness) and /readyz (readiness) endpoints to mux.
+// Both answer 200 or 503 with one line per check.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.serveLiveness)
+	mux.HandleFunc("/readyz", c.serveReadiness)
+}
+
+func (c *Checker) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	c.serve(w, func(chk *check, now time.Time) bool {
+		return chk.err == nil || now.Sub(chk.failingSince) <= c.livenessGrace
+	}, true)
+}
+
+func (c *Checker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	checked := c.checked
+	c.mu.Unlock()
+	c.serve(w, func(chk *check, now time.Time) bool {
+		return chk.err == nil
+	}, checked)
+}
+
+func (c *Checker) serve(w http.ResponseWriter, healthy func(chk *check, now time.Time) bool, checked bool) {
+	now := time.Now()
+	c.mu.Lock()
+	lines := make([]string, 0, len(c.checks))
+	ok := checked
+	for _, chk := range c.checks {
+		if healthy(chk, now) {
+			lines = append(lines, fmt.Sprintf("[+] %s ok", chk.name))
+			continue
+		}
+		ok = false
+		lines = append(lines, fmt.Sprintf("[-] %s failed for %s: %v", chk.name, now.Sub(chk.failingSince).Round(time.Second), chk.err))
+	}
+	c.mu.Unlock()
+	sort.Strings(lines)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !checked {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "checks did not run yet")
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if len(lines) > 0 {
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+	if ok {
+		fmt.Fprintln(w, "ok")
+	} else {
+		fmt.Fprintln(w, "failed")
+	}
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func probe(t *testing.T, c *Checker, path string) (int, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	c.Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func grpcStatus(t *testing.T, c *Checker) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := c.GRPCServer().Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+func TestCheckerWithoutChecks(t *testing.T) {
+	c := New()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, body := probe(t, c, path); code != http.StatusOK || body != "ok\n" {
+			t.Errorf("%s = %d %q, want 200 ok", path, code, body)
+		}
+	}
+}
+
+func TestChecker(t *testing.T) {
+	var casErr error
+	c := New(WithLivenessGrace(time.Hour))
+	c.Add("cas", func(ctx context.Context) error { return casErr })
+	c.Add("registry", func(ctx context.Context) error { return nil })
+
+	// not ready before the checks ran, but alive
+	if code, body := probe(t, c, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "checks did not run yet") {
+		t.Errorf("/readyz before the first run = %d %q, want 503", code, body)
+	}
+	if code, _ := probe(t, c, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz before the first run = %d, want 200", code)
+	}
+	if got := grpcStatus(t, c); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("gRPC status before the first run = %v, want NOT_SERVING", got)
+	}
+
+	c.runOnce(context.Background())
+	if code, body := probe(t, c, "/readyz"); code != http.StatusOK || body != "[+] cas ok\n[+] registry ok\nok\n" {
+		t.Errorf("/readyz = %d %q, want 200 with one line per check", code, body)
+	}
+	if got := grpcStatus(t, c); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("gRPC status = %v, want SERVING", got)
+	}
+
+	// a failing check makes the server unready, but not dead within the grace period
+	casErr = errors.New("connection refused")
+	c.runOnce(context.Background())
+	if code, body := probe(t, c, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "[-] cas failed for 0s: connection refused") || !strings.HasSuffix(body, "failed\n") {
+		t.Errorf("/readyz with a failing check = %d %q, want 503 with the error", code, body)
+	}
+	if code, _ := probe(t, c, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz within the grace period = %d, want 200", code)
+	}
+	if got := grpcStatus(t, c); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("gRPC status with a failing check = %v, want NOT_SERVING", got)
+	}
+
+	// failing for longer than the grace period
+	c.mu.Lock()
+	c.checks[0].failingSince = time.Now().Add(-2 * time.Hour)
+	c.mu.Unlock()
+	c.runOnce(context.Background())
+	if code, body := probe(t, c, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "[-] cas failed for 2h0m0s") {
+		t.Errorf("/healthz after the grace period = %d %q, want 503", code, body)
+	}
+
+	// recovery resets the streak of failures
+	casErr = nil
+	c.runOnce(context.Background())
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, _ := probe(t, c, path); code != http.StatusOK {
+			t.Errorf("%s after recovery = %d, want 200", path, code)
+		}
+	}
+	if got := grpcStatus(t, c); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("gRPC status after recovery = %v, want SERVING", got)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := New(WithTimeout(time.Millisecond))
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c.runOnce(context.Background())
+	if code, body := probe(t, c, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "context deadline exceeded") {
+		t.Errorf("/readyz with a check that times out = %d %q, want 503", code, body)
+	}
+}
+
+func TestRegistryPushCheck(t *testing.T) {
+	if _, err := RegistryPushCheck("Invalid Repository"); err == nil {
+		t.Error("RegistryPushCheck() of an invalid repository: error = nil, want an error")
+	}
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	check, err := RegistryPushCheck(strings.TrimPrefix(server.URL, "http://") + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(context.Background()); err != nil {
+		t.Errorf("check() of a reachable registry: error = %v", err)
+	}
+	server.Close()
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "authenticating to") {
+		t.Errorf("check() of an unreachable registry: error = %v, want an error", err)
+	}
+}
@@ -0,0 +1,340 @@
+// Package metrics provides a minimal, dependency-free metrics registry
+// that is exposed in the Prometheus text exposition format.
+// It is used by the serve binaries (bes, registry) to export counters,
+// gauges and latency histograms on a /metrics endpoint.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the histogram buckets (in seconds) used for latency metrics.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry holds a set of named metrics and renders them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the process-wide registry used by the serve packages.
+var Default = NewRegistry()
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+func (r *Registry) register(name string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		return existing
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// Counter returns the monotonically increasing counter with the given name, creating it if needed.
+// The optional label names are used to partition the counter (see CounterVec.With).
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	m := r.register(name, &CounterVec{help: help, labelNames: labelNames, values: make(map[string]*Counter)})
+	vec, ok := m.(*CounterVec)
+	if !ok {
+		panic(fmt.Sprintf("metric %s registered with a different type", name))
+	}
+	return vec
+}
+
+// Gauge returns the gauge with the given name, creating it if needed.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	m := r.register(name, &Gauge{help: help})
+	g, ok := m.(*Gauge)
+	if !ok {
+		panic(fmt.Sprintf("metric %s registered with a different type", name))
+	}
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is computed on every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{help: help, fn: fn})
+}
+
+// Histogram returns the histogram with the given name, creating it if needed.
+// If buckets is nil, DefaultLatencyBuckets is used.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	m := r.register(name, &Histogram{help: help, buckets: buckets, counts: make([]uint64, len(buckets))})
+	h, ok := m.(*Histogram)
+	if !ok {
+		panic(fmt.Sprintf("metric %s registered with a different type", name))
+	}
+	return h
+}
+
+// Write renders all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]metric, len(r.metrics))
+	for name, m := range r.metrics {
+		metrics[name] = m
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		metrics[name].write(w, name)
+	}
+}
+
+// Handler returns an http.Handler serving the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by delta. Negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	for {
+		old := c.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if c.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]*Counter
+}
+
+// With returns the counter for the given label values.
+// The number of values must match the label names the vector was created with.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(v.labelNames), len(labelValues)))
+	}
+	key := formatLabels(v.labelNames, labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.values[key]
+	if !ok {
+		c = &Counter{}
+		v.values[key] = c
+	}
+	return c
+}
+
+// Inc increments the unlabeled counter by one.
+func (v *CounterVec) Inc() {
+	v.With().Inc()
+}
+
+// Add increments the unlabeled counter by delta.
+func (v *CounterVec) Add(delta float64) {
+	v.With().Add(delta)
+}
+
+func (v *CounterVec) write(w io.Writer, name string) {
+	writeHeader(w, name, v.help, "counter")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatFloat(v.values[key].Value()))
+	}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	help string
+	bits atomic.Uint64
+}
+
+// Set sets the gauge to the given value.
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+// Add adds delta (which may be negative) to the gauge.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	writeHeader(w, name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+}
+
+type gaugeFunc struct {
+	help string
+	fn   func() float64
+}
+
+func (g *gaugeFunc) write(w io.Writer, name string) {
+	writeHeader(w, name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records a single observation.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveDuration records the time elapsed since start in seconds.
+func (h *Histogram) ObserveDuration(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	writeHeader(w, name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", name, escaper.Replace(values[i]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ListenAndServe serves the /metrics endpoint of the registry on the given address.
+// It blocks until the server fails.
+func (r *Registry) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// InstrumentHandler wraps an http.Handler and counts served requests
+// by method and status code in the counter with the given name.
+func (r *Registry) InstrumentHandler(name, help string, next http.Handler) http.Handler {
+	requests := r.Counter(name, help, "method", "code")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req)
+		requests.With(req.Method, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteGolden(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("img_requests_total", "Requests by method and code.", "method", "code")
+	requests.With("GET", "200").Add(3)
+	requests.With("PUT", "201").Inc()
+	// label values are escaped, help texts only escape backslashes and newlines
+	paths := r.Counter("img_paths_total", `Paths with "quotes", a \ backslash and a`+"\nnewline.", "path")
+	paths.With(`C:\dir "quoted"` + "\nnext").Inc()
+	r.Counter("img_unlabeled_total", "A counter without labels.").Add(1.5)
+	r.Counter("img_unused_total", "A counter that was never incremented.", "kind")
+	r.Gauge("img_in_flight", "A gauge.").Set(-2)
+	r.GaugeFunc("img_entries", "A computed gauge.", func() float64 { return 42 })
+	// observations are counted in every bucket whose upper bound they don't exceed
+	latency := r.Histogram("img_latency_seconds", "A histogram.", []float64{0.1, 1, 10})
+	for _, v := range []float64{0.05, 0.1, 0.5, 20} {
+		latency.Observe(v)
+	}
+
+	var got bytes.Buffer
+	r.Write(&got)
+	want, err := os.ReadFile(filepath.Join("testdata", "exposition.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("Write() =\n%s\nwant\n%s", got.String(), want)
+	}
+}
+
+func TestRegisterExisting(t *testing.T) {
+	r := NewRegistry()
+	if r.Counter("c", "help") != r.Counter("c", "other help") {
+		t.Error("Counter() created a second counter with the same name")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Gauge() with the name of a counter did not panic")
+		}
+	}()
+	r.Gauge("c", "help")
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	r := NewRegistry()
+	handler := r.InstrumentHandler("img_http_requests_total", "Requests.", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodDelete} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP img_http_requests_total Requests.
+# TYPE img_http_requests_total counter
+img_http_requests_total{method="DELETE",code="405"} 1
+img_http_requests_total{method="GET",code="200"} 2
+`
+	if rec.Body.String() != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", rec.Body.String(), want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
@@ -6,13 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
 	"sync"
 
 	"github.com/malt3/go-containerregistry/pkg/registry"
 	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
 	"github.com/malt3/go-containerregistry/pkg/v1/types"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 )
 
 type BlobSizeCache struct {
@@ -124,9 +126,9 @@ func (b BlobSizeCacheCallback) cacheFromManifest(repo string, manifest *registry
 				// internal consistency checks
 				statSize, statErr := b.handler.Stat(context.TODO(), repo, layer.Digest)
 				if statErr != nil {
-					fmt.Fprintf(os.Stderr, "image PUT (%s) is not consistent. Missing layer blob %s: %v\n", repo, layer.Digest, statErr)
+					slog.Warn("Image PUT is not consistent: missing layer blob", "repository", repo, "digest", layer.Digest, logging.ErrKey, statErr)
 				} else if statSize != layer.Size {
-					fmt.Fprintf(os.Stderr, "image PUT (%s) is not consistent. Layer blob %s size mismatch: expected %d, got %d\n", repo, layer.Digest, layer.Size, statSize)
+					slog.Warn("Image PUT is not consistent: layer blob size mismatch", "repository", repo, "digest", layer.Digest, "expected", layer.Size, "actual", statSize)
 				}
 			}
 		}
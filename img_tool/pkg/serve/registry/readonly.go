@@ -0,0 +1,20 @@
+package registry
+
+import (
+	"net/http"
+)
+
+// ReadOnlyHandler rejects every request that could modify the registry (uploads, manifest pushes and deletes)
+// with the UNSUPPORTED error of the distribution spec, and passes pulls to next.
+func ReadOnlyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"errors":[{"code":"UNSUPPORTED","message":"registry is read-only"}]}` + "\n"))
+	})
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyHandler(t *testing.T) {
+	var served int
+	handler := ReadOnlyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodGet, path: "/v2/app/manifests/latest", want: http.StatusOK},
+		{method: http.MethodHead, path: "/v2/app/blobs/sha256:abc", want: http.StatusOK},
+		{method: http.MethodPost, path: "/v2/app/blobs/uploads/", want: http.StatusMethodNotAllowed},
+		{method: http.MethodPatch, path: "/v2/app/blobs/uploads/1", want: http.StatusMethodNotAllowed},
+		{method: http.MethodPut, path: "/v2/app/manifests/latest", want: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, path: "/v2/app/manifests/latest", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+			if recorder.Code != tt.want {
+				t.Fatalf("%s %s: status = %d, want %d", tt.method, tt.path, recorder.Code, tt.want)
+			}
+			if tt.want == http.StatusMethodNotAllowed && !strings.Contains(recorder.Body.String(), `"code":"UNSUPPORTED"`) {
+				t.Errorf("%s %s: body = %q, want UNSUPPORTED error", tt.method, tt.path, recorder.Body.String())
+			}
+		})
+	}
+	if served != 2 {
+		t.Errorf("next served %d requests, want 2", served)
+	}
+}
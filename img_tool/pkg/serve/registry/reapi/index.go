@@ -0,0 +1,177 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	remoteexecution_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// Kinds of index entries.
+const (
+	// indexKindBlob maps the digest of a blob (layer, config or manifest) to its size.
+	indexKindBlob = "blob"
+	// indexKindTag maps repository:tag to the manifest (or index) the tag points to.
+	indexKindTag = "tag"
+	// indexKindOrigin maps the digest of a blob that is not in the CAS (yet) to the registries
+	// it can be pulled through from (see Origin).
+	indexKindOrigin = "origin"
+)
+
+// indexKeyPrefix versions the derivation of action digests from index keys.
+const indexKeyPrefix = "rules_img/cas_registry/v1"
+
+// Index stores what the CAS alone cannot answer in the action cache of the remote cache:
+// the sizes of blobs (REAPI digests include the size, OCI digests don't), the targets of tags
+// and the origins of blobs that are pulled through from other registries.
+// This lets the registry serve pulls of images whose manifests were pushed to another replica
+// or before a restart.
+//
+// Every entry is an action result with a single output file, keyed by a synthetic action digest
+// that is derived from the kind and key of the entry. Since the output file is a blob of the CAS,
+// the remote cache keeps the blob alive as long as the entry (and evicts the entry if the blob is gone).
+type Index struct {
+	actionCache remoteexecution_proto.ActionCacheClient
+	cas         *cas.CAS
+}
+
+// NewIndex returns an index stored in the action cache of the remote cache behind clientConn.
+// Entries that don't point to an existing blob (like origins) are stored as small blobs in casClient.
+func NewIndex(clientConn *grpc.ClientConn, casClient *cas.CAS) *Index {
+	return &Index{
+		actionCache: remoteexecution_proto.NewActionCacheClient(clientConn),
+		cas:         casClient,
+	}
+}
+
+// Origin describes where a blob that is missing from the CAS can be pulled from,
+// like a layer of a shallow base image that only exists in the registry of the base image.
+type Origin struct {
+	// Registries are the registries (the original one and its mirrors) that serve Repository.
+	Registries []string `json:"registries"`
+	Repository string   `json:"repository"`
+	// Size is the size of the blob.
+	Size int64 `json:"size"`
+}
+
+// Origin returns the origin of a blob, or false if the index does not know the blob.
+func (i *Index) Origin(ctx context.Context, hash registryv1.Hash) (Origin, bool, error) {
+	desc, ok, err := i.lookup(ctx, indexKindOrigin, hash.String())
+	if err != nil || !ok {
+		return Origin{}, ok, err
+	}
+	digest, err := digestFromDescriptor(desc.Digest, desc.Size)
+	if err != nil {
+		return Origin{}, false, err
+	}
+	data, err := i.cas.ReadBlob(ctx, digest)
+	if err != nil {
+		return Origin{}, false, fmt.Errorf("reading origin of %s: %w", hash, err)
+	}
+	var origin Origin
+	if err := json.Unmarshal(data, &origin); err != nil {
+		return Origin{}, false, fmt.Errorf("parsing origin of %s: %w", hash, err)
+	}
+	return origin, true, nil
+}
+
+// RecordOrigin remembers where a blob can be pulled from.
+func (i *Index) RecordOrigin(ctx context.Context, hash registryv1.Hash, origin Origin) error {
+	data, err := json.Marshal(origin)
+	if err != nil {
+		return err
+	}
+	originHash := sha256.Sum256(data)
+	if err := i.cas.WriteBlob(ctx, cas.SHA256(originHash[:], int64(len(data))), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("storing origin of %s: %w", hash, err)
+	}
+	return i.record(ctx, indexKindOrigin, hash.String(), "origin", registryv1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(originHash[:])}, int64(len(data)))
+}
+
+// BlobSize returns the size of a blob, or false if the index does not know the blob.
+func (i *Index) BlobSize(ctx context.Context, hash registryv1.Hash) (int64, bool, error) {
+	desc, ok, err := i.lookup(ctx, indexKindBlob, hash.String())
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	if desc.Digest != hash {
+		return 0, false, nil
+	}
+	return desc.Size, true, nil
+}
+
+// Tag returns the digest and size of the manifest a tag points to, or false if the index does not know the tag.
+func (i *Index) Tag(ctx context.Context, repo, tag string) (registryv1.Descriptor, bool, error) {
+	return i.lookup(ctx, indexKindTag, repo+":"+tag)
+}
+
+// RecordBlob remembers the size of a blob. The blob must exist in the CAS.
+func (i *Index) RecordBlob(ctx context.Context, hash registryv1.Hash, size int64) error {
+	return i.record(ctx, indexKindBlob, hash.String(), "blob", hash, size)
+}
+
+// RecordTag remembers the manifest a tag points to. The manifest must exist in the CAS.
+func (i *Index) RecordTag(ctx context.Context, repo, tag string, hash registryv1.Hash, size int64) error {
+	return i.record(ctx, indexKindTag, repo+":"+tag, "manifest", hash, size)
+}
+
+func (i *Index) lookup(ctx context.Context, kind, key string) (registryv1.Descriptor, bool, error) {
+	result, err := i.actionCache.GetActionResult(ctx, &remoteexecution_proto.GetActionResultRequest{
+		ActionDigest:   indexActionDigest(kind, key),
+		DigestFunction: remoteexecution_proto.DigestFunction_SHA256,
+	})
+	if status.Code(err) == codes.NotFound {
+		return registryv1.Descriptor{}, false, nil
+	}
+	if err != nil {
+		return registryv1.Descriptor{}, false, fmt.Errorf("looking up %s %s in action cache: %w", kind, key, err)
+	}
+	if len(result.OutputFiles) != 1 || result.OutputFiles[0].Digest == nil {
+		return registryv1.Descriptor{}, false, nil
+	}
+	digest := result.OutputFiles[0].Digest
+	return registryv1.Descriptor{
+		Digest: registryv1.Hash{Algorithm: "sha256", Hex: digest.Hash},
+		Size:   digest.SizeBytes,
+	}, true, nil
+}
+
+func (i *Index) record(ctx context.Context, kind, key, path string, hash registryv1.Hash, size int64) error {
+	if hash.Algorithm != "sha256" {
+		// Action results can only refer to blobs of the digest function of the action.
+		return nil
+	}
+	_, err := i.actionCache.UpdateActionResult(ctx, &remoteexecution_proto.UpdateActionResultRequest{
+		ActionDigest: indexActionDigest(kind, key),
+		ActionResult: &remoteexecution_proto.ActionResult{
+			OutputFiles: []*remoteexecution_proto.OutputFile{{
+				Path:   path,
+				Digest: &remoteexecution_proto.Digest{Hash: hash.Hex, SizeBytes: size},
+			}},
+		},
+		DigestFunction: remoteexecution_proto.DigestFunction_SHA256,
+	})
+	if err != nil {
+		return fmt.Errorf("recording %s %s in action cache: %w", kind, key, err)
+	}
+	return nil
+}
+
+func indexActionDigest(kind, key string) *remoteexecution_proto.Digest {
+	data := indexKeyPrefix + "\x00" + kind + "\x00" + key
+	hash := sha256.Sum256([]byte(data))
+	return &remoteexecution_proto.Digest{
+		Hash:      hex.EncodeToString(hash[:]),
+		SizeBytes: int64(len(data)),
+	}
+}
@@ -0,0 +1,182 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/types"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// maxManifestSize is the largest manifest served from the CAS (like the limit of common registries).
+const maxManifestSize = 4 * 1024 * 1024
+
+// RecordManifest stores a pushed manifest (or index) in the CAS and records it in the index,
+// together with the sizes of the blobs it references and the tag it was pushed as (if any).
+// Afterwards, the manifest can be pulled from any replica of the registry, even after a restart.
+// It has the signature of a manifest put callback.
+func (h *REAPIBlobHandler) RecordManifest(repo, target, contentType string, blob []byte) error {
+	ctx := context.TODO()
+	hash, size, err := registryv1.SHA256(bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	digest, err := digestFromDescriptor(hash, size)
+	if err != nil {
+		return err
+	}
+	if err := h.casReader.WriteBlob(ctx, digest, bytes.NewReader(blob)); err != nil {
+		return fmt.Errorf("storing manifest %s in CAS: %w", hash, err)
+	}
+	h.blobSizeCache.Set(hash, size)
+	if err := h.index.RecordBlob(ctx, hash, size); err != nil {
+		return err
+	}
+
+	var manifest struct {
+		Config    *registryv1.Descriptor  `json:"config"`
+		Layers    []registryv1.Descriptor `json:"layers"`
+		Manifests []registryv1.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return fmt.Errorf("parsing manifest %s: %w", hash, err)
+	}
+	descriptors := append(manifest.Layers, manifest.Manifests...)
+	if manifest.Config != nil {
+		descriptors = append(descriptors, *manifest.Config)
+	}
+	for _, desc := range descriptors {
+		if desc.Size <= 0 {
+			continue
+		}
+		if err := h.index.RecordBlob(ctx, desc.Digest, desc.Size); err != nil {
+			// The blob may not be in the CAS (like foreign layers or blobs of other stores).
+			slog.Error("Failed to index blob of manifest", "digest", desc.Digest, "manifest", hash, logging.ErrKey, err)
+		}
+	}
+
+	if _, err := registryv1.NewHash(target); err == nil {
+		return nil // pushed by digest
+	}
+	return h.index.RecordTag(ctx, repo, target, hash, size)
+}
+
+// ManifestHandler serves pulls of manifests that next does not know from the CAS.
+// GET and HEAD requests of /v2/<name>/manifests/<reference> are passed to next first.
+// If next answers 404, the manifest is looked up in the index (for tags) and read from the CAS.
+// All other requests are passed to next unchanged.
+func (h *REAPIBlobHandler) ManifestHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, reference, ok := parseManifestPath(r.URL.Path)
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusNotFound {
+			copyResponse(w, recorder)
+			return
+		}
+		manifest, hash, err := h.manifestFromCAS(r.Context(), repo, reference)
+		if err != nil {
+			slog.Error("Failed to read manifest from CAS", "repository", repo, "reference", reference, logging.ErrKey, err)
+		}
+		if manifest == nil {
+			copyResponse(w, recorder)
+			return
+		}
+		w.Header().Set("Content-Type", string(manifestMediaType(manifest)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		w.Header().Set("Docker-Content-Digest", hash.String())
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+	})
+}
+
+// manifestFromCAS returns the manifest of a tag or digest, or nil if it is unknown.
+func (h *REAPIBlobHandler) manifestFromCAS(ctx context.Context, repo, reference string) ([]byte, registryv1.Hash, error) {
+	var desc registryv1.Descriptor
+	if hash, err := registryv1.NewHash(reference); err == nil {
+		size, ok, err := h.index.BlobSize(ctx, hash)
+		if err != nil || !ok {
+			return nil, hash, err
+		}
+		desc = registryv1.Descriptor{Digest: hash, Size: size}
+	} else {
+		var ok bool
+		desc, ok, err = h.index.Tag(ctx, repo, reference)
+		if err != nil || !ok {
+			return nil, registryv1.Hash{}, err
+		}
+	}
+	if desc.Size > maxManifestSize {
+		return nil, desc.Digest, fmt.Errorf("manifest is larger than %d bytes", maxManifestSize)
+	}
+	digest, err := digestFromDescriptor(desc.Digest, desc.Size)
+	if err != nil {
+		return nil, desc.Digest, err
+	}
+	missing, err := h.casReader.FindMissingBlobs(ctx, []cas.Digest{digest})
+	if err != nil || len(missing) > 0 {
+		return nil, desc.Digest, err
+	}
+	manifest, err := h.casReader.ReadBlob(ctx, digest)
+	if err != nil {
+		return nil, desc.Digest, err
+	}
+	return manifest, desc.Digest, nil
+}
+
+// parseManifestPath splits /v2/<name>/manifests/<reference> into name and reference.
+func parseManifestPath(path string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v2/")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, "/manifests/")
+	if i <= 0 {
+		return "", "", false
+	}
+	reference := rest[i+len("/manifests/"):]
+	if reference == "" || strings.Contains(reference, "/") {
+		return "", "", false
+	}
+	return rest[:i], reference, true
+}
+
+// manifestMediaType returns the media type of a manifest, which is part of every Docker
+// and most OCI manifests. Manifests without one are told apart by their fields.
+func manifestMediaType(manifest []byte) types.MediaType {
+	var fields struct {
+		MediaType types.MediaType `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(manifest, &fields); err == nil && fields.MediaType != "" {
+		return fields.MediaType
+	}
+	if fields.Manifests != nil {
+		return types.OCIImageIndex
+	}
+	return types.OCIManifestSchema1
+}
+
+func copyResponse(w http.ResponseWriter, recorder *httptest.ResponseRecorder) {
+	for key, values := range recorder.Header() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(recorder.Code)
+	w.Write(recorder.Body.Bytes())
+}
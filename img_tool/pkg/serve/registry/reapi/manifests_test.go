@@ -0,0 +1,423 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/types"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	remoteexecution_proto "github.com/bazel-contrib/rules_img/img_tool/pkg/proto/remote-apis/build/bazel/remote/execution/v2"
+	combined "github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry"
+)
+
+// fakeRemoteCache is an in-memory remote cache with a CAS and an action cache.
+type fakeRemoteCache struct {
+	remoteexecution_proto.UnimplementedCapabilitiesServer
+	remoteexecution_proto.UnimplementedContentAddressableStorageServer
+	remoteexecution_proto.UnimplementedActionCacheServer
+
+	mu            sync.Mutex
+	blobs         map[string][]byte
+	actionResults map[string]*remoteexecution_proto.ActionResult
+	// actionCacheErr is returned by every action cache request if set.
+	actionCacheErr error
+}
+
+func (f *fakeRemoteCache) GetCapabilities(context.Context, *remoteexecution_proto.GetCapabilitiesRequest) (*remoteexecution_proto.ServerCapabilities, error) {
+	return &remoteexecution_proto.ServerCapabilities{
+		CacheCapabilities: &remoteexecution_proto.CacheCapabilities{
+			DigestFunctions: []remoteexecution_proto.DigestFunction_Value{remoteexecution_proto.DigestFunction_SHA256},
+		},
+	}, nil
+}
+
+func (f *fakeRemoteCache) FindMissingBlobs(_ context.Context, req *remoteexecution_proto.FindMissingBlobsRequest) (*remoteexecution_proto.FindMissingBlobsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &remoteexecution_proto.FindMissingBlobsResponse{}
+	for _, digest := range req.BlobDigests {
+		if _, ok := f.blobs[digest.Hash]; !ok {
+			resp.MissingBlobDigests = append(resp.MissingBlobDigests, digest)
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeRemoteCache) BatchReadBlobs(_ context.Context, req *remoteexecution_proto.BatchReadBlobsRequest) (*remoteexecution_proto.BatchReadBlobsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &remoteexecution_proto.BatchReadBlobsResponse{}
+	for _, digest := range req.Digests {
+		data, ok := f.blobs[digest.Hash]
+		code := codes.OK
+		if !ok {
+			code = codes.NotFound
+		}
+		resp.Responses = append(resp.Responses, &remoteexecution_proto.BatchReadBlobsResponse_Response{
+			Digest: digest,
+			Data:   data,
+			Status: &rpcstatus.Status{Code: int32(code)},
+		})
+	}
+	return resp, nil
+}
+
+func (f *fakeRemoteCache) BatchUpdateBlobs(_ context.Context, req *remoteexecution_proto.BatchUpdateBlobsRequest) (*remoteexecution_proto.BatchUpdateBlobsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &remoteexecution_proto.BatchUpdateBlobsResponse{}
+	for _, r := range req.Requests {
+		f.blobs[r.Digest.Hash] = r.Data
+		resp.Responses = append(resp.Responses, &remoteexecution_proto.BatchUpdateBlobsResponse_Response{
+			Digest: r.Digest,
+			Status: &rpcstatus.Status{},
+		})
+	}
+	return resp, nil
+}
+
+func (f *fakeRemoteCache) GetActionResult(_ context.Context, req *remoteexecution_proto.GetActionResultRequest) (*remoteexecution_proto.ActionResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.actionCacheErr != nil {
+		return nil, f.actionCacheErr
+	}
+	result, ok := f.actionResults[req.ActionDigest.Hash]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "action result not found")
+	}
+	return result, nil
+}
+
+func (f *fakeRemoteCache) UpdateActionResult(_ context.Context, req *remoteexecution_proto.UpdateActionResultRequest) (*remoteexecution_proto.ActionResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.actionCacheErr != nil {
+		return nil, f.actionCacheErr
+	}
+	f.actionResults[req.ActionDigest.Hash] = req.ActionResult
+	return req.ActionResult, nil
+}
+
+func (f *fakeRemoteCache) put(data []byte) registryv1.Hash {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := sha256.Sum256(data)
+	f.blobs[hex.EncodeToString(sum[:])] = data
+	return registryv1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}
+}
+
+func (f *fakeRemoteCache) setActionCacheErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.actionCacheErr = err
+}
+
+// newFakeRemoteCache serves a fakeRemoteCache over gRPC and returns a connection to it.
+func newFakeRemoteCache(t *testing.T) (*fakeRemoteCache, *grpc.ClientConn) {
+	t.Helper()
+	fake := &fakeRemoteCache{
+		blobs:         make(map[string][]byte),
+		actionResults: make(map[string]*remoteexecution_proto.ActionResult),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	remoteexecution_proto.RegisterCapabilitiesServer(server, fake)
+	remoteexecution_proto.RegisterContentAddressableStorageServer(server, fake)
+	remoteexecution_proto.RegisterActionCacheServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fake, conn
+}
+
+// emptyUpstream is an upstream registry without any blobs.
+type emptyUpstream struct{}
+
+func (emptyUpstream) Stat(context.Context, string, registryv1.Hash) (int64, error) {
+	return 0, registry.ErrNotFound
+}
+
+// newReplica returns a blob handler with its own (empty) size cache, like a fresh replica of the registry.
+func newReplica(t *testing.T, conn *grpc.ClientConn) *REAPIBlobHandler {
+	t.Helper()
+	handler, err := New(emptyUpstream{}, conn, combined.NewBlobSizeCache())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return handler
+}
+
+// notFound answers every request with 404, like a registry that does not know the manifest.
+var notFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "not found", http.StatusNotFound)
+})
+
+func serve(handler http.Handler, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestManifestHandlerServesManifestsOfOtherReplicas(t *testing.T) {
+	fake, conn := newFakeRemoteCache(t)
+	layer := []byte("layer contents")
+	layerHash := fake.put(layer)
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:` + layerHash.Hex + `","size":14},` +
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","digest":"` + layerHash.String() + `","size":14}]}`)
+	manifestHash, _, err := registryv1.SHA256(bytes.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newReplica(t, conn).RecordManifest("app", "latest", string(types.OCIManifestSchema1), manifest); err != nil {
+		t.Fatalf("RecordManifest() error = %v", err)
+	}
+
+	// Another replica only knows the manifest from the remote cache.
+	replica := newReplica(t, conn)
+	handler := replica.ManifestHandler(notFound)
+	for _, reference := range []string{"latest", manifestHash.String()} {
+		t.Run("GET "+reference, func(t *testing.T) {
+			resp := serve(handler, http.MethodGet, "/v2/app/manifests/"+reference)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.Code, http.StatusOK)
+			}
+			if !bytes.Equal(resp.Body.Bytes(), manifest) {
+				t.Errorf("body = %s, want %s", resp.Body.Bytes(), manifest)
+			}
+			if got := resp.Header().Get("Docker-Content-Digest"); got != manifestHash.String() {
+				t.Errorf("Docker-Content-Digest = %q, want %q", got, manifestHash)
+			}
+			if got := resp.Header().Get("Content-Type"); got != string(types.OCIManifestSchema1) {
+				t.Errorf("Content-Type = %q, want %q", got, types.OCIManifestSchema1)
+			}
+		})
+	}
+	t.Run("HEAD", func(t *testing.T) {
+		resp := serve(handler, http.MethodHead, "/v2/app/manifests/latest")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.Code, http.StatusOK)
+		}
+		if resp.Body.Len() != 0 {
+			t.Errorf("body = %q, want empty", resp.Body.String())
+		}
+		if got, want := resp.Header().Get("Content-Length"), strconv.Itoa(len(manifest)); got != want {
+			t.Errorf("Content-Length = %q, want %q", got, want)
+		}
+	})
+	t.Run("unknown tag", func(t *testing.T) {
+		if resp := serve(handler, http.MethodGet, "/v2/app/manifests/other"); resp.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", resp.Code, http.StatusNotFound)
+		}
+	})
+	t.Run("tag of other repository", func(t *testing.T) {
+		if resp := serve(handler, http.MethodGet, "/v2/other/manifests/latest"); resp.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", resp.Code, http.StatusNotFound)
+		}
+	})
+
+	// The sizes of the referenced blobs are indexed, so the replica can serve them without the upstream.
+	t.Run("blob", func(t *testing.T) {
+		size, err := replica.Stat(context.Background(), "app", layerHash)
+		if err != nil || size != int64(len(layer)) {
+			t.Fatalf("Stat() = %d, %v, want %d", size, err, len(layer))
+		}
+		rc, err := replica.Get(context.Background(), "app", layerHash)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil || !bytes.Equal(got, layer) {
+			t.Errorf("Get() = %q, %v, want %q", got, err, layer)
+		}
+	})
+}
+
+func TestManifestHandlerPassesThrough(t *testing.T) {
+	fake, conn := newFakeRemoteCache(t)
+	replica := newReplica(t, conn)
+	if err := replica.RecordManifest("app", "latest", string(types.OCIManifestSchema1), []byte(`{"schemaVersion":2}`)); err != nil {
+		t.Fatalf("RecordManifest() error = %v", err)
+	}
+	var served []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = append(served, r.Method+" "+r.URL.Path)
+		w.Header().Set("X-Next", "yes")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	handler := replica.ManifestHandler(next)
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/v2/app/manifests/latest"}, // next knows the manifest
+		{http.MethodPut, "/v2/app/manifests/latest"},
+		{http.MethodDelete, "/v2/app/manifests/latest"},
+		{http.MethodGet, "/v2/app/blobs/sha256:abc"},
+		{http.MethodGet, "/v2/"},
+	} {
+		resp := serve(handler, tc.method, tc.path)
+		if resp.Code != http.StatusAccepted || resp.Header().Get("X-Next") != "yes" {
+			t.Errorf("%s %s: status = %d, want the response of next", tc.method, tc.path, resp.Code)
+		}
+	}
+	if len(served) != 5 {
+		t.Errorf("next served %v, want all 5 requests", served)
+	}
+
+	// Errors of the remote cache are logged, and the 404 of next is returned.
+	fake.setActionCacheErr(status.Error(codes.Unavailable, "remote cache is down"))
+	if resp := serve(replica.ManifestHandler(notFound), http.MethodGet, "/v2/app/manifests/latest"); resp.Code != http.StatusNotFound {
+		t.Errorf("status with failing remote cache = %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
+
+func TestManifestHandlerMissingFromCAS(t *testing.T) {
+	fake, conn := newFakeRemoteCache(t)
+	manifest := []byte(`{"schemaVersion":2}`)
+	if err := newReplica(t, conn).RecordManifest("app", "latest", string(types.OCIManifestSchema1), manifest); err != nil {
+		t.Fatalf("RecordManifest() error = %v", err)
+	}
+	// The remote cache evicted the manifest, but not the index entry.
+	fake.mu.Lock()
+	clear(fake.blobs)
+	fake.mu.Unlock()
+
+	if resp := serve(newReplica(t, conn).ManifestHandler(notFound), http.MethodGet, "/v2/app/manifests/latest"); resp.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
+
+func TestRecordManifestErrors(t *testing.T) {
+	fake, conn := newFakeRemoteCache(t)
+	replica := newReplica(t, conn)
+	if err := replica.RecordManifest("app", "latest", string(types.OCIManifestSchema1), []byte("not json")); err == nil {
+		t.Error("RecordManifest() of invalid manifest succeeded, want error")
+	}
+	fake.setActionCacheErr(status.Error(codes.Unavailable, "remote cache is down"))
+	if err := replica.RecordManifest("app", "latest", string(types.OCIManifestSchema1), []byte(`{"schemaVersion":2}`)); err == nil {
+		t.Error("RecordManifest() with failing action cache succeeded, want error")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	fake, conn := newFakeRemoteCache(t)
+	index := NewIndex(conn, newReplica(t, conn).casReader)
+	ctx := context.Background()
+	hash := fake.put([]byte("blob"))
+
+	if _, ok, err := index.BlobSize(ctx, hash); err != nil || ok {
+		t.Errorf("BlobSize() of unknown blob = %v, %v, want not found", ok, err)
+	}
+	if err := index.RecordBlob(ctx, hash, 4); err != nil {
+		t.Fatalf("RecordBlob() error = %v", err)
+	}
+	if size, ok, err := index.BlobSize(ctx, hash); err != nil || !ok || size != 4 {
+		t.Errorf("BlobSize() = %d, %v, %v, want 4", size, ok, err)
+	}
+
+	if err := index.RecordTag(ctx, "app", "v1", hash, 4); err != nil {
+		t.Fatalf("RecordTag() error = %v", err)
+	}
+	if desc, ok, err := index.Tag(ctx, "app", "v1"); err != nil || !ok || desc.Digest != hash || desc.Size != 4 {
+		t.Errorf("Tag() = %v, %v, %v, want %s", desc, ok, err, hash)
+	}
+	if _, ok, err := index.Tag(ctx, "app", "v2"); err != nil || ok {
+		t.Errorf("Tag() of unknown tag = %v, %v, want not found", ok, err)
+	}
+
+	origin := Origin{Registries: []string{"index.docker.io"}, Repository: "library/ubuntu", Size: 42}
+	if err := index.RecordOrigin(ctx, hash, origin); err != nil {
+		t.Fatalf("RecordOrigin() error = %v", err)
+	}
+	got, ok, err := index.Origin(ctx, hash)
+	if err != nil || !ok || got.Repository != origin.Repository || got.Size != origin.Size || len(got.Registries) != 1 {
+		t.Errorf("Origin() = %+v, %v, %v, want %+v", got, ok, err, origin)
+	}
+
+	// Only sha256 digests can be stored in action results.
+	sha512 := registryv1.Hash{Algorithm: "sha512", Hex: "00"}
+	if err := index.RecordBlob(ctx, sha512, 1); err != nil {
+		t.Errorf("RecordBlob() of sha512 blob error = %v, want ignored", err)
+	}
+
+	fake.setActionCacheErr(status.Error(codes.PermissionDenied, "no access"))
+	if _, _, err := index.BlobSize(ctx, hash); err == nil {
+		t.Error("BlobSize() with failing action cache succeeded, want error")
+	}
+	if err := index.RecordTag(ctx, "app", "v1", hash, 4); err == nil {
+		t.Error("RecordTag() with failing action cache succeeded, want error")
+	}
+}
+
+func TestParseManifestPath(t *testing.T) {
+	tests := []struct {
+		path          string
+		wantRepo      string
+		wantReference string
+		wantOK        bool
+	}{
+		{path: "/v2/app/manifests/latest", wantRepo: "app", wantReference: "latest", wantOK: true},
+		{path: "/v2/org/app/manifests/sha256:abc", wantRepo: "org/app", wantReference: "sha256:abc", wantOK: true},
+		{path: "/v2/manifests/manifests/v1", wantRepo: "manifests", wantReference: "v1", wantOK: true},
+		{path: "/v2/app/manifests/", wantOK: false},
+		{path: "/v2/app/manifests/a/b", wantOK: false},
+		{path: "/v2//manifests/latest", wantOK: false},
+		{path: "/v2/app/blobs/sha256:abc", wantOK: false},
+		{path: "/v1/app/manifests/latest", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			repo, reference, ok := parseManifestPath(tt.path)
+			if ok != tt.wantOK || repo != tt.wantRepo || reference != tt.wantReference {
+				t.Errorf("parseManifestPath(%q) = %q, %q, %v, want %q, %q, %v", tt.path, repo, reference, ok, tt.wantRepo, tt.wantReference, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestManifestMediaType(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     types.MediaType
+	}{
+		{name: "docker manifest", manifest: `{"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`, want: types.DockerManifestSchema2},
+		{name: "oci index", manifest: `{"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`, want: types.OCIImageIndex},
+		{name: "index without media type", manifest: `{"schemaVersion":2,"manifests":[]}`, want: types.OCIImageIndex},
+		{name: "manifest without media type", manifest: `{"schemaVersion":2,"layers":[]}`, want: types.OCIManifestSchema1},
+		{name: "invalid", manifest: `not json`, want: types.OCIManifestSchema1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := manifestMediaType([]byte(tt.manifest)); got != tt.want {
+				t.Errorf("manifestMediaType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package reapi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+
+	reg "github.com/bazel-contrib/rules_img/img_tool/pkg/auth/registry"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// EnablePullThrough lets clients mount blobs from the given registries (like "index.docker.io" or "gcr.io",
+// or "*" for any registry) by sending a cross-repository mount whose "from" parameter names a repository
+// of another registry (see MountHandler).
+// Mounted blobs are not copied immediately: the registry remembers their origin and copies them into the CAS
+// on the first pull, authenticating to the origin with its own credentials.
+// This makes the layers of shallow base images (which were never downloaded by Bazel) pullable
+// with the cas_registry strategy.
+func (h *REAPIBlobHandler) EnablePullThrough(registries []string) {
+	for _, registry := range registries {
+		if registry == "*" {
+			h.pullThroughRegistries = append(h.pullThroughRegistries, registry)
+			continue
+		}
+		if normalized, err := name.NewRegistry(registry); err == nil {
+			registry = normalized.RegistryStr()
+		}
+		h.pullThroughRegistries = append(h.pullThroughRegistries, registry)
+	}
+}
+
+func (h *REAPIBlobHandler) pullThroughAllowed(registry string) bool {
+	return slices.Contains(h.pullThroughRegistries, "*") || slices.Contains(h.pullThroughRegistries, registry)
+}
+
+// origin returns where a blob can be pulled through from, if pull-through is enabled.
+func (h *REAPIBlobHandler) origin(ctx context.Context, hash registryv1.Hash) (Origin, bool) {
+	if len(h.pullThroughRegistries) == 0 {
+		return Origin{}, false
+	}
+	origin, ok, err := h.index.Origin(ctx, hash)
+	if err != nil {
+		slog.Error("Failed to look up origin of blob", "digest", hash, logging.ErrKey, err)
+		return Origin{}, false
+	}
+	return origin, ok
+}
+
+// pullThrough copies a blob from its origin into the CAS, unless the CAS has it already.
+// Blobs without a known origin are left alone.
+func (h *REAPIBlobHandler) pullThrough(ctx context.Context, hash registryv1.Hash, digest cas.Digest) error {
+	if len(h.pullThroughRegistries) == 0 {
+		return nil
+	}
+	lock, _ := h.pulls.LoadOrStore(hash.String(), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	missing, err := h.casReader.FindMissingBlobs(ctx, []cas.Digest{digest})
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	origin, ok := h.origin(ctx, hash)
+	if !ok {
+		return nil
+	}
+	var errs []string
+	for _, registry := range origin.Registries {
+		ref, err := name.NewDigest(fmt.Sprintf("%s/%s@%s", registry, origin.Repository, hash))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		layer, err := remote.Layer(ref, reg.WithAuthFromMultiKeychain(), remote.WithContext(ctx))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		err = h.casReader.WriteBlob(ctx, digest, rc)
+		rc.Close()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		slog.Info("Pulled blob through", "digest", hash, "origin", ref.Context())
+		return nil
+	}
+	return fmt.Errorf("pulling blob %s through from %s: %s", hash, origin.Repository, strings.Join(errs, "; "))
+}
+
+// MountHandler accepts cross-repository mounts from other registries:
+// POST /v2/<name>/blobs/uploads/?mount=<digest>&from=<registry>/<repository> (with one "from" per mirror).
+// If the blob exists in one of the allowed origin registries, its origin is recorded
+// and the mount succeeds (201 Created) without transferring the blob.
+// All other requests (including mounts within this registry) are passed to next.
+func (h *REAPIBlobHandler) MountHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := parseUploadPath(r.URL.Path)
+		query := r.URL.Query()
+		if !ok || r.Method != http.MethodPost || query.Get("mount") == "" || len(query["from"]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hash, err := registryv1.NewHash(query.Get("mount"))
+		if err != nil || hash.Algorithm != "sha256" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin, ok := h.checkOrigin(r.Context(), hash, query["from"])
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := h.index.RecordOrigin(r.Context(), hash, origin); err != nil {
+			slog.Error("Failed to record origin of blob", "digest", hash, logging.ErrKey, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.blobSizeCache.Set(hash, origin.Size)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, hash))
+		w.Header().Set("Docker-Content-Digest", hash.String())
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// checkOrigin returns the origin of a blob made of the allowed repositories in from that have the blob.
+func (h *REAPIBlobHandler) checkOrigin(ctx context.Context, hash registryv1.Hash, from []string) (Origin, bool) {
+	var origin Origin
+	for _, source := range from {
+		registry, repository, ok := strings.Cut(source, "/")
+		if !ok || !looksLikeRegistry(registry) {
+			// a repository of this registry
+			continue
+		}
+		repo, err := name.NewRepository(source)
+		if err != nil || !h.pullThroughAllowed(repo.RegistryStr()) {
+			continue
+		}
+		if origin.Repository != "" && repo.RepositoryStr() != origin.Repository {
+			continue
+		}
+		layer, err := remote.Layer(repo.Digest(hash.String()), reg.WithAuthFromMultiKeychain(), remote.WithContext(ctx))
+		if err != nil {
+			continue
+		}
+		size, err := layer.Size()
+		if err != nil {
+			slog.Warn("Cannot pull blob through", "digest", hash, "origin", repo, logging.ErrKey, err)
+			continue
+		}
+		origin.Registries = append(origin.Registries, registry)
+		origin.Repository = repository
+		origin.Size = size
+	}
+	return origin, len(origin.Registries) > 0
+}
+
+// looksLikeRegistry reports whether the first component of a repository is a registry host,
+// following the rules of Docker image references.
+func looksLikeRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// parseUploadPath returns the name of /v2/<name>/blobs/uploads/.
+func parseUploadPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v2/")
+	if !ok {
+		return "", false
+	}
+	rest = strings.TrimSuffix(rest, "/")
+	repo, ok := strings.CutSuffix(rest, "/blobs/uploads")
+	return repo, ok && repo != ""
+}
@@ -0,0 +1,251 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/go-containerregistry/pkg/name"
+	"github.com/malt3/go-containerregistry/pkg/registry"
+	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
+	"github.com/malt3/go-containerregistry/pkg/v1/random"
+	"github.com/malt3/go-containerregistry/pkg/v1/remote"
+	"github.com/malt3/go-containerregistry/pkg/v1/types"
+	"google.golang.org/grpc"
+)
+
+// newOriginRegistry starts an in-memory registry that has one layer in library/base.
+func newOriginRegistry(t *testing.T) (*httptest.Server, string, registryv1.Layer) {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	layer, err := random.Layer(256, types.OCILayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := name.NewRepository(host + "/library/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteLayer(repo, layer); err != nil {
+		t.Fatalf("pushing layer to origin registry: %v", err)
+	}
+	return server, host, layer
+}
+
+func layerInfo(t *testing.T, layer registryv1.Layer) (registryv1.Hash, int64, []byte) {
+	t.Helper()
+	hash, err := layer.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash, int64(len(data)), data
+}
+
+func newPullThroughReplica(t *testing.T, conn *grpc.ClientConn, registries ...string) *REAPIBlobHandler {
+	t.Helper()
+	handler := newReplica(t, conn)
+	handler.EnablePullThrough(registries)
+	return handler
+}
+
+func mountPath(repo string, hash registryv1.Hash, from ...string) string {
+	path := "/v2/" + repo + "/blobs/uploads/?mount=" + hash.String()
+	for _, source := range from {
+		path += "&from=" + source
+	}
+	return path
+}
+
+func TestPullThrough(t *testing.T) {
+	origin, host, layer := newOriginRegistry(t)
+	hash, size, data := layerInfo(t, layer)
+	fake, conn := newFakeRemoteCache(t)
+
+	mounter := newPullThroughReplica(t, conn, host)
+	resp := serve(mounter.MountHandler(notFound), http.MethodPost, mountPath("app", hash, host+"/library/base"))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("mount status = %d, want %d", resp.Code, http.StatusCreated)
+	}
+	if got, want := resp.Header().Get("Location"), "/v2/app/blobs/"+hash.String(); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if got := resp.Header().Get("Docker-Content-Digest"); got != hash.String() {
+		t.Errorf("Docker-Content-Digest = %q, want %q", got, hash)
+	}
+	fake.mu.Lock()
+	_, copied := fake.blobs[hash.Hex]
+	fake.mu.Unlock()
+	if copied {
+		t.Error("mount copied the blob into the CAS, want a copy on the first pull")
+	}
+
+	// Replicas without pull-through don't serve blobs of other registries.
+	if _, err := newReplica(t, conn).Stat(context.Background(), "app", hash); !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("Stat() without pull-through error = %v, want %v", err, registry.ErrNotFound)
+	}
+
+	// Another replica knows the origin from the remote cache.
+	replica := newPullThroughReplica(t, conn, host)
+	if got, err := replica.Stat(context.Background(), "app", hash); err != nil || got != size {
+		t.Fatalf("Stat() = %d, %v, want %d", got, err, size)
+	}
+	readBlob := func(handler *REAPIBlobHandler) ([]byte, error) {
+		rc, err := handler.Get(context.Background(), "app", hash)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	got, err := readBlob(replica)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get() = %d bytes, %v, want the %d bytes of the origin", len(got), err, len(data))
+	}
+
+	// The first pull copied the blob into the CAS, so the origin is no longer needed.
+	origin.Close()
+	got, err = readBlob(newPullThroughReplica(t, conn, host))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get() after origin is gone = %d bytes, %v, want the blob from the CAS", len(got), err)
+	}
+}
+
+func TestPullThroughOriginGone(t *testing.T) {
+	origin, host, layer := newOriginRegistry(t)
+	hash, _, _ := layerInfo(t, layer)
+	_, conn := newFakeRemoteCache(t)
+	replica := newPullThroughReplica(t, conn, host)
+	if resp := serve(replica.MountHandler(notFound), http.MethodPost, mountPath("app", hash, host+"/library/base")); resp.Code != http.StatusCreated {
+		t.Fatalf("mount status = %d, want %d", resp.Code, http.StatusCreated)
+	}
+	origin.Close()
+	_, err := replica.Get(context.Background(), "app", hash)
+	if err == nil || !strings.Contains(err.Error(), "pulling blob "+hash.String()+" through from library/base") {
+		t.Errorf("Get() error = %v, want pull-through error", err)
+	}
+}
+
+func TestMountHandlerPassesThrough(t *testing.T) {
+	_, host, layer := newOriginRegistry(t)
+	hash, _, _ := layerInfo(t, layer)
+	missing := registryv1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+	_, conn := newFakeRemoteCache(t)
+
+	tests := []struct {
+		name       string
+		registries []string
+		method     string
+		path       string
+	}{
+		{name: "registry not allowed", registries: []string{"gcr.io"}, method: http.MethodPost, path: mountPath("app", hash, host+"/library/base")},
+		{name: "repository of this registry", registries: []string{"*"}, method: http.MethodPost, path: mountPath("app", hash, "library/base")},
+		{name: "blob missing in origin", registries: []string{host}, method: http.MethodPost, path: mountPath("app", missing, host+"/library/base")},
+		{name: "invalid digest", registries: []string{host}, method: http.MethodPost, path: "/v2/app/blobs/uploads/?mount=sha256:xyz&from=" + host + "/library/base"},
+		{name: "no from", registries: []string{host}, method: http.MethodPost, path: "/v2/app/blobs/uploads/?mount=" + hash.String()},
+		{name: "upload", registries: []string{host}, method: http.MethodPost, path: "/v2/app/blobs/uploads/"},
+		{name: "not an upload", registries: []string{host}, method: http.MethodGet, path: mountPath("app", hash, host+"/library/base")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+				w.WriteHeader(http.StatusAccepted)
+			})
+			handler := newPullThroughReplica(t, conn, tt.registries...).MountHandler(next)
+			if resp := serve(handler, tt.method, tt.path); !served || resp.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want the request to be passed to next", resp.Code)
+			}
+		})
+	}
+}
+
+func TestMountHandlerAnyRegistry(t *testing.T) {
+	_, host, layer := newOriginRegistry(t)
+	hash, size, _ := layerInfo(t, layer)
+	_, conn := newFakeRemoteCache(t)
+	replica := newPullThroughReplica(t, conn, "*")
+	// A "from" of this registry is ignored, the one of the origin is used.
+	if resp := serve(replica.MountHandler(notFound), http.MethodPost, mountPath("org/app", hash, "library/base", host+"/library/base")); resp.Code != http.StatusCreated {
+		t.Fatalf("mount status = %d, want %d", resp.Code, http.StatusCreated)
+	}
+	got, ok, err := replica.index.Origin(context.Background(), hash)
+	want := Origin{Registries: []string{host}, Repository: "library/base", Size: size}
+	if err != nil || !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Origin() = %+v, %v, %v, want %+v", got, ok, err, want)
+	}
+}
+
+func TestEnablePullThrough(t *testing.T) {
+	handler := &REAPIBlobHandler{}
+	handler.EnablePullThrough([]string{"docker.io", "gcr.io", "localhost:5000"})
+	want := []string{"index.docker.io", "gcr.io", "localhost:5000"}
+	if !reflect.DeepEqual(handler.pullThroughRegistries, want) {
+		t.Errorf("pullThroughRegistries = %v, want %v", handler.pullThroughRegistries, want)
+	}
+	for registry, wantAllowed := range map[string]bool{"index.docker.io": true, "gcr.io": true, "quay.io": false} {
+		if got := handler.pullThroughAllowed(registry); got != wantAllowed {
+			t.Errorf("pullThroughAllowed(%q) = %v, want %v", registry, got, wantAllowed)
+		}
+	}
+	handler.EnablePullThrough([]string{"*"})
+	if !handler.pullThroughAllowed("quay.io") {
+		t.Error(`pullThroughAllowed("quay.io") = false with "*", want true`)
+	}
+}
+
+func TestParseUploadPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{path: "/v2/app/blobs/uploads/", want: "app", wantOK: true},
+		{path: "/v2/org/app/blobs/uploads", want: "org/app", wantOK: true},
+		{path: "/v2/blobs/uploads/", wantOK: false},
+		{path: "/v2/app/blobs/uploads/123", wantOK: false},
+		{path: "/v2/app/manifests/latest", wantOK: false},
+		{path: "/app/blobs/uploads/", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := parseUploadPath(tt.path)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("parseUploadPath(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLooksLikeRegistry(t *testing.T) {
+	for component, want := range map[string]bool{
+		"gcr.io":         true,
+		"localhost":      true,
+		"localhost:5000": true,
+		"127.0.0.1:5000": true,
+		"library":        false,
+		"my-org":         false,
+	} {
+		if got := looksLikeRegistry(component); got != want {
+			t.Errorf("looksLikeRegistry(%q) = %v, want %v", component, got, want)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"sync"
 
 	registry "github.com/malt3/go-containerregistry/pkg/registry"
 	registryv1 "github.com/malt3/go-containerregistry/pkg/v1"
@@ -13,6 +15,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/cas"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
 	combined "github.com/bazel-contrib/rules_img/img_tool/pkg/serve/registry"
 )
 
@@ -20,6 +23,12 @@ type REAPIBlobHandler struct {
 	upstream      registry.BlobStatHandler
 	casReader     *cas.CAS
 	blobSizeCache *combined.BlobSizeCache
+	index         *Index
+
+	// pullThroughRegistries are the registries that blobs may be pulled through from (see EnablePullThrough).
+	pullThroughRegistries []string
+	// pulls serializes pulls of the same blob.
+	pulls sync.Map
 }
 
 func New(upstream registry.BlobStatHandler, clientConn *grpc.ClientConn, blobSizeCache *combined.BlobSizeCache) (*REAPIBlobHandler, error) {
@@ -32,21 +41,14 @@ func New(upstream registry.BlobStatHandler, clientConn *grpc.ClientConn, blobSiz
 		upstream:      upstream,
 		casReader:     casReader,
 		blobSizeCache: blobSizeCache,
+		index:         NewIndex(clientConn, casReader),
 	}, nil
 }
 
 func (h *REAPIBlobHandler) Get(ctx context.Context, repo string, hash registryv1.Hash) (io.ReadCloser, error) {
-	// since we need to know the size of the blob for any REAPI operations,
-	// we ask the cache or upstream registry to find out if the blob exists.
-	var upstreamSize int64
-	if cachedSize, ok := h.blobSizeCache.Get(hash); ok {
-		upstreamSize = cachedSize
-	} else {
-		var upstreamErr error
-		upstreamSize, upstreamErr = h.upstream.Stat(ctx, repo, hash)
-		if upstreamErr != nil {
-			return nil, upstreamErr
-		}
+	upstreamSize, err := h.size(ctx, repo, hash)
+	if err != nil {
+		return nil, err
 	}
 
 	if upstreamSize < 0 {
@@ -57,21 +59,16 @@ func (h *REAPIBlobHandler) Get(ctx context.Context, repo string, hash registryv1
 	if err != nil {
 		return nil, fmt.Errorf("unsupported digest algorithm: %s", hash.Algorithm)
 	}
+	if err := h.pullThrough(ctx, hash, digest); err != nil {
+		return nil, err
+	}
 	return h.casReader.ReaderForBlob(ctx, digest)
 }
 
 func (h *REAPIBlobHandler) Stat(ctx context.Context, repo string, hash registryv1.Hash) (int64, error) {
-	// since we need to know the size of the blob for any REAPI operations,
-	// we ask the cache or upstream registry to find out if the blob exists.
-	var upstreamSize int64
-	if cachedSize, ok := h.blobSizeCache.Get(hash); ok {
-		upstreamSize = cachedSize
-	} else {
-		var upstreamErr error
-		upstreamSize, upstreamErr = h.upstream.Stat(ctx, repo, hash)
-		if upstreamErr != nil {
-			return 0, upstreamErr
-		}
+	upstreamSize, err := h.size(ctx, repo, hash)
+	if err != nil {
+		return 0, err
 	}
 	if upstreamSize == 0 {
 		return 0, nil
@@ -88,6 +85,9 @@ func (h *REAPIBlobHandler) Stat(ctx context.Context, repo string, hash registryv
 	if len(missing) == 0 {
 		return upstreamSize, nil // Blob is present.
 	}
+	if _, ok := h.origin(ctx, hash); ok {
+		return upstreamSize, nil // Blob is pulled through on the first read.
+	}
 	return 0, registry.ErrNotFound // Blob is missing.
 }
 
@@ -95,15 +95,9 @@ func (h *REAPIBlobHandler) Put(ctx context.Context, repo string, hash v1.Hash, r
 	// since we need to know the size of the blob for any REAPI operations,
 	// we ask the cache or upstream registry to find out if the blob exists.
 	defer rc.Close() // Ensure the reader is closed after use.
-	var upstreamSize int64
-	if cachedSize, ok := h.blobSizeCache.Get(hash); ok {
-		upstreamSize = cachedSize
-	} else {
-		var upstreamErr error
-		upstreamSize, upstreamErr = h.upstream.Stat(ctx, repo, hash)
-		if upstreamErr != nil {
-			return upstreamErr
-		}
+	upstreamSize, err := h.size(ctx, repo, hash)
+	if err != nil {
+		return err
 	}
 	digest, err := digestFromDescriptor(hash, upstreamSize)
 	if err != nil {
@@ -112,6 +106,27 @@ func (h *REAPIBlobHandler) Put(ctx context.Context, repo string, hash v1.Hash, r
 	return h.casReader.WriteBlob(ctx, digest, rc)
 }
 
+// size returns the size of a blob, which is needed for any REAPI operation.
+// It asks the size cache first, then the index in the action cache (filled by earlier manifest pushes,
+// possibly to another replica), the origin of pulled through blobs, and finally the upstream registry.
+func (h *REAPIBlobHandler) size(ctx context.Context, repo string, hash registryv1.Hash) (int64, error) {
+	if cachedSize, ok := h.blobSizeCache.Get(hash); ok {
+		return cachedSize, nil
+	}
+	indexedSize, ok, err := h.index.BlobSize(ctx, hash)
+	if err != nil {
+		slog.Error("Failed to look up size of blob", "digest", hash, logging.ErrKey, err)
+	} else if ok {
+		h.blobSizeCache.Set(hash, indexedSize)
+		return indexedSize, nil
+	}
+	if origin, ok := h.origin(ctx, hash); ok {
+		h.blobSizeCache.Set(hash, origin.Size)
+		return origin.Size, nil
+	}
+	return h.upstream.Stat(ctx, repo, hash)
+}
+
 func digestFromDescriptor(hash registryv1.Hash, size int64) (cas.Digest, error) {
 	rawHash, err := hex.DecodeString(hash.Hex)
 	if err != nil {
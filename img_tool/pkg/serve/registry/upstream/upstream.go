@@ -31,7 +31,7 @@ func (h *UpstreamBlobHandler) Get(ctx context.Context, repo string, hash registr
 		return nil, fmt.Errorf("creating blob reference: %w", err)
 	}
 	transport := &redirectHandler{
-		underlying: remote.DefaultTransport,
+		underlying: reg.BaseTransport(),
 	}
 	layer, err := remote.Layer(ref, reg.WithAuthFromMultiKeychain(), remote.WithTransport(transport))
 	if err != nil {
@@ -58,7 +58,7 @@ func (h *UpstreamBlobHandler) Stat(ctx context.Context, repo string, hash regist
 	}
 	transport := &redirectHandler{
 		hash:       hash,
-		underlying: remote.DefaultTransport,
+		underlying: reg.BaseTransport(),
 	}
 	layer, err := remote.Layer(ref, reg.WithAuthFromMultiKeychain(), remote.WithTransport(transport))
 	if err != nil {
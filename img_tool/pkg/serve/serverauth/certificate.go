@@ -0,0 +1,78 @@
+package serverauth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/logging"
+)
+
+// certificateLoader serves the certificate of a key pair on disk and reloads it
+// when one of the files was modified, so that short-lived certificates
+// (like those issued by cert-manager) can be rotated without a restart.
+type certificateLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertificateLoader(certFile, keyFile string) (*certificateLoader, error) {
+	l := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	if _, err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+// If reloading fails, the previous certificate is served.
+func (l *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := l.load()
+	if err != nil {
+		slog.Error("Failed to reload TLS certificate, serving the previous one", logging.ErrKey, err)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.cert, nil
+	}
+	return cert, nil
+}
+
+func (l *certificateLoader) load() (*tls.Certificate, error) {
+	modTime, err := l.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cert != nil && !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return l.cert, nil
+}
+
+func (l *certificateLoader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,231 @@
+// Package serverauth secures the servers of img (the BES syncer and the CAS registry)
+// with TLS, optional client certificates (mTLS), and a pluggable authorizer
+// that checks every gRPC call and HTTP request.
+package serverauth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Environment variables that provide defaults for Options.
+const (
+	tlsCertEnv       = "IMG_SERVE_TLS_CERT"
+	tlsKeyEnv        = "IMG_SERVE_TLS_KEY"
+	clientCAEnv      = "IMG_SERVE_CLIENT_CA"
+	authTokenFileEnv = "IMG_SERVE_AUTH_TOKEN_FILE"
+)
+
+var (
+	// ErrUnauthenticated is returned by authorizers if a request carries no (valid) credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned by authorizers if the credentials of a request are valid
+	// but not allowed to perform the request.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Request describes a gRPC call or HTTP request to authorize.
+type Request struct {
+	// Method is the full gRPC method (like /google.devtools.build.v1.PublishBuildEvent/PublishBuildToolEventStream)
+	// or the HTTP method and path (like "GET /v2/").
+	Method string
+	// Header holds the request headers (gRPC metadata keys are lower case).
+	Header http.Header
+	// PeerCertificates are the verified client certificates if the client authenticated with mTLS.
+	PeerCertificates []*x509.Certificate
+}
+
+// Authorizer decides whether a request may be served.
+// It returns nil to allow the request, or an error wrapping ErrUnauthenticated
+// or ErrPermissionDenied to reject it.
+type Authorizer interface {
+	Authorize(ctx context.Context, req Request) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, req Request) error
+
+// Authorize calls f(ctx, req).
+func (f AuthorizerFunc) Authorize(ctx context.Context, req Request) error {
+	return f(ctx, req)
+}
+
+// AllowAll is an Authorizer that allows every request.
+var AllowAll Authorizer = AuthorizerFunc(func(context.Context, Request) error { return nil })
+
+// Options configure how a server is secured.
+type Options struct {
+	// CertFile and KeyFile are the PEM encoded certificate (chain) and private key of the server.
+	// Without them, the server accepts plain text connections.
+	CertFile string
+	KeyFile  string
+	// ClientCA is a PEM file with the certificate authorities of client certificates.
+	// If set, clients must present a certificate signed by one of them (mTLS).
+	ClientCA string
+	// TokenFile is a file with one bearer token per line. If set, requests must carry
+	// one of the tokens in the authorization header (see TokenAuthorizer).
+	TokenFile string
+}
+
+// OptionsFromEnv returns the options set by IMG_SERVE_TLS_CERT, IMG_SERVE_TLS_KEY,
+// IMG_SERVE_CLIENT_CA and IMG_SERVE_AUTH_TOKEN_FILE.
+func OptionsFromEnv() Options {
+	return Options{
+		CertFile:  os.Getenv(tlsCertEnv),
+		KeyFile:   os.Getenv(tlsKeyEnv),
+		ClientCA:  os.Getenv(clientCAEnv),
+		TokenFile: os.Getenv(authTokenFileEnv),
+	}
+}
+
+// RegisterFlags adds --tls-cert, --tls-key, --client-ca and --auth-token-file to the flag set.
+// The environment variables of OptionsFromEnv provide the defaults.
+func (o *Options) RegisterFlags(flagSet *flag.FlagSet) {
+	*o = OptionsFromEnv()
+	flagSet.StringVar(&o.CertFile, "tls-cert", o.CertFile, fmt.Sprintf("PEM file with the TLS certificate (chain) of the server (optional, plain text if empty, env: %s)", tlsCertEnv))
+	flagSet.StringVar(&o.KeyFile, "tls-key", o.KeyFile, fmt.Sprintf("PEM file with the private key of the TLS certificate (env: %s)", tlsKeyEnv))
+	flagSet.StringVar(&o.ClientCA, "client-ca", o.ClientCA, fmt.Sprintf("PEM file with the certificate authorities of client certificates; clients must authenticate with a certificate (mTLS) if set (env: %s)", clientCAEnv))
+	flagSet.StringVar(&o.TokenFile, "auth-token-file", o.TokenFile, fmt.Sprintf(`File with one bearer token per line; requests must send "authorization: Bearer <token>" if set (env: %s)`, authTokenFileEnv))
+}
+
+// TLSConfig returns the TLS configuration of the server, or nil if TLS is disabled.
+// The certificate is reloaded when its files change, so that it can be rotated without a restart.
+func (o Options) TLSConfig() (*tls.Config, error) {
+	if o.CertFile == "" && o.KeyFile == "" {
+		if o.ClientCA != "" {
+			return nil, errors.New("--client-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be set together")
+	}
+	certs, err := newCertificateLoader(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if o.ClientCA != "" {
+		pem, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s contains no PEM certificates", o.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Authorizer returns the authorizer configured by the options.
+// Without a token file, all requests are allowed (client certificates are already verified during the TLS handshake).
+func (o Options) Authorizer() (Authorizer, error) {
+	if o.TokenFile == "" {
+		return AllowAll, nil
+	}
+	return LoadTokenFile(o.TokenFile)
+}
+
+// GRPCServerOptions returns the server options that enable TLS and check every call with the authorizer.
+// Calls of the gRPC health service are not checked, so that probes work without credentials.
+func GRPCServerOptions(tlsConfig *tls.Config, authorizer Authorizer) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if authorizer != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(UnaryServerInterceptor(authorizer)),
+			grpc.ChainStreamInterceptor(StreamServerInterceptor(authorizer)),
+		)
+	}
+	return opts
+}
+
+// UnaryServerInterceptor rejects unary calls that the authorizer does not allow.
+func UnaryServerInterceptor(authorizer Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorizeGRPC(ctx, authorizer, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams that the authorizer does not allow.
+func StreamServerInterceptor(authorizer Authorizer) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorizeGRPC(stream.Context(), authorizer, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func authorizeGRPC(ctx context.Context, authorizer Authorizer, method string) error {
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	req := Request{Method: method, Header: make(http.Header)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			req.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.PeerCertificates = tlsInfo.State.PeerCertificates
+		}
+	}
+	err := authorizer.Authorize(ctx, req)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+}
+
+// Handler rejects HTTP requests that the authorizer does not allow before passing them to next.
+// Rejected requests are answered with 401 (and a basic auth challenge, so that docker login works)
+// or 403.
+func Handler(authorizer Authorizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := Request{Method: r.Method + " " + r.URL.Path, Header: r.Header}
+		if r.TLS != nil {
+			req.PeerCertificates = r.TLS.PeerCertificates
+		}
+		err := authorizer.Authorize(r.Context(), req)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrPermissionDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", `Basic realm="img"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	})
+}
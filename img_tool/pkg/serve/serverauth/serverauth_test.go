@@ -0,0 +1,198 @@
+package serverauth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestTokenFromHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		wantOK bool
+	}{
+		{header: "Bearer secret", want: "secret", wantOK: true},
+		{header: "bearer secret", want: "secret", wantOK: true},
+		{header: "Bearer   secret  ", want: "secret", wantOK: true},
+		{header: basicAuth("user", "secret"), want: "secret", wantOK: true},
+		{header: basicAuth("", "secret"), want: "secret", wantOK: true},
+		{header: basicAuth("user", "pass:with:colons"), want: "pass:with:colons", wantOK: true},
+		{header: "BASIC " + base64.StdEncoding.EncodeToString([]byte("user:secret")), want: "secret", wantOK: true},
+		{header: ""},
+		{header: "Bearer"},
+		{header: "Bearer "},
+		{header: "secret"},
+		{header: "Token secret"},
+		{header: basicAuth("user", "")},
+		{header: "Basic " + base64.StdEncoding.EncodeToString([]byte("no-colon"))},
+		{header: "Basic not-base64!"},
+	}
+	for _, tt := range tests {
+		got, ok := tokenFromHeader(tt.header)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("tokenFromHeader(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestTokenAuthorizer(t *testing.T) {
+	authorizer := NewTokenAuthorizer("first-token", "second", "a-much-longer-third-token")
+	tests := []struct {
+		header  string
+		wantErr bool
+	}{
+		{header: "Bearer first-token"},
+		{header: "Bearer second"},
+		{header: "Bearer a-much-longer-third-token"},
+		{header: basicAuth("ci", "second")},
+		// prefixes, suffixes and other near misses of a token don't match
+		{header: "Bearer first", wantErr: true},
+		{header: "Bearer first-token-", wantErr: true},
+		{header: "Bearer First-token", wantErr: true},
+		{header: "Bearer seconds", wantErr: true},
+		{header: "Bearer " + "first-tokensecond", wantErr: true},
+		{header: basicAuth("second", "wrong"), wantErr: true},
+		{header: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		req := Request{Method: "GET /v2/", Header: http.Header{}}
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		err := authorizer.Authorize(context.Background(), req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Authorize(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Authorize(%q) error = %v, want ErrUnauthenticated", tt.header, err)
+		}
+	}
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens.txt")
+	if err := os.WriteFile(path, []byte("# CI\nci-token\n\n  dev-token  \n#disabled-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authorizer, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for token, want := range map[string]bool{"ci-token": true, "dev-token": true, "disabled-token": false, "# CI": false} {
+		req := Request{Header: http.Header{"Authorization": {"Bearer " + token}}}
+		if got := authorizer.Authorize(context.Background(), req) == nil; got != want {
+			t.Errorf("token %q allowed = %v, want %v", token, got, want)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, []byte("# no tokens\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokenFile(empty); err == nil {
+		t.Error("LoadTokenFile() of a file without tokens succeeded")
+	}
+	if _, err := LoadTokenFile(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("LoadTokenFile() of a missing file succeeded")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	authorizer := AuthorizerFunc(func(_ context.Context, req Request) error {
+		switch req.Header.Get("Authorization") {
+		case "Bearer reader":
+			if req.Method != "GET /v2/" {
+				return fmt.Errorf("%w: read only", ErrPermissionDenied)
+			}
+			return nil
+		case "Bearer admin":
+			return nil
+		}
+		return ErrUnauthenticated
+	})
+	handler := Handler(authorizer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	tests := []struct {
+		method, header string
+		want           int
+	}{
+		{method: http.MethodGet, header: "Bearer admin", want: http.StatusTeapot},
+		{method: http.MethodGet, header: "Bearer reader", want: http.StatusTeapot},
+		{method: http.MethodPost, header: "Bearer reader", want: http.StatusForbidden},
+		{method: http.MethodGet, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/v2/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: status = %d, want %d", tt.method, tt.header, rec.Code, tt.want)
+		}
+		if challenge := rec.Header().Get("WWW-Authenticate"); (rec.Code == http.StatusUnauthorized) != (challenge != "") {
+			t.Errorf("%s with %q: WWW-Authenticate = %q", tt.method, tt.header, challenge)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	authorizer := AuthorizerFunc(func(_ context.Context, req Request) error {
+		switch req.Header.Get("Authorization") {
+		case "Bearer admin":
+			return nil
+		case "Bearer reader":
+			return ErrPermissionDenied
+		}
+		return ErrUnauthenticated
+	})
+	interceptor := UnaryServerInterceptor(authorizer)
+	tests := []struct {
+		method, header string
+		want           codes.Code
+	}{
+		{method: "/google.devtools.build.v1.PublishBuildEvent/PublishLifecycleEvent", header: "Bearer admin", want: codes.OK},
+		{method: "/google.devtools.build.v1.PublishBuildEvent/PublishLifecycleEvent", header: "Bearer reader", want: codes.PermissionDenied},
+		{method: "/google.devtools.build.v1.PublishBuildEvent/PublishLifecycleEvent", want: codes.Unauthenticated},
+		// health checks are served without credentials
+		{method: "/grpc.health.v1.Health/Check", want: codes.OK},
+		{method: "/grpc.health.v1.Health/Watch", want: codes.OK},
+		{method: "/grpc.health.v1.HealthCheck/Check", want: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.header != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.header))
+		}
+		called := false
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(context.Context, any) (any, error) {
+			called = true
+			return nil, nil
+		})
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("%s with %q: code = %v, want %v", tt.method, tt.header, got, tt.want)
+		}
+		if called != (tt.want == codes.OK) {
+			t.Errorf("%s with %q: handler called = %v", tt.method, tt.header, called)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package serverauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TokenAuthorizer allows requests that carry one of a set of bearer tokens.
+//
+// The token is read from the authorization header, either as "Bearer <token>"
+// (sent by Bazel with --bes_header=Authorization=Bearer <token> or --remote_header)
+// or as the password of basic auth (sent by docker login and registry clients; the user name is ignored).
+type TokenAuthorizer struct {
+	// hashes holds the SHA-256 of every token, so that tokens are compared in constant time.
+	hashes [][sha256.Size]byte
+}
+
+// NewTokenAuthorizer returns an authorizer that allows requests with one of the tokens.
+func NewTokenAuthorizer(tokens ...string) *TokenAuthorizer {
+	a := &TokenAuthorizer{}
+	for _, token := range tokens {
+		a.hashes = append(a.hashes, sha256.Sum256([]byte(token)))
+	}
+	return a
+}
+
+// LoadTokenFile reads one token per line from a file.
+// Empty lines and lines starting with # are ignored.
+func LoadTokenFile(path string) (*TokenAuthorizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening token file: %w", err)
+	}
+	defer file.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading token file: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token file %s contains no tokens", path)
+	}
+	return NewTokenAuthorizer(tokens...), nil
+}
+
+// Authorize implements Authorizer.
+func (a *TokenAuthorizer) Authorize(_ context.Context, req Request) error {
+	token, ok := tokenFromHeader(req.Header.Get("Authorization"))
+	if !ok {
+		return fmt.Errorf("%w: missing bearer token", ErrUnauthenticated)
+	}
+	hash := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, candidate := range a.hashes {
+		valid |= subtle.ConstantTimeCompare(hash[:], candidate[:])
+	}
+	if valid != 1 {
+		return fmt.Errorf("%w: invalid bearer token", ErrUnauthenticated)
+	}
+	return nil
+}
+
+func tokenFromHeader(header string) (string, bool) {
+	scheme, value, ok := strings.Cut(header, " ")
+	if !ok {
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return value, value != ""
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", false
+		}
+		_, password, ok := strings.Cut(string(decoded), ":")
+		return password, ok && password != ""
+	}
+	return "", false
+}
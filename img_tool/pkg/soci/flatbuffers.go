@@ -0,0 +1,136 @@
+package soci
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A minimal flatbuffers encoder for the ztoc schema.
+// Tables are written front to back: the root offset, then every table
+// (preceded by its vtable) followed by the strings, vectors and tables it references.
+// Offsets always point forward, as required by the format.
+
+type (
+	// fbTable is a table with one value per field (in the order of the schema). Nil fields are omitted.
+	fbTable []any
+	// fbScalar is a little endian integer of the given size in bytes.
+	fbScalar struct {
+		size  int
+		value uint64
+	}
+	// fbBytes is a vector of ubyte.
+	fbBytes []byte
+	// fbVector is a vector of strings or tables.
+	fbVector []any
+)
+
+func fbInt32(v int32) fbScalar   { return fbScalar{size: 4, value: uint64(uint32(v))} }
+func fbUint32(v uint32) fbScalar { return fbScalar{size: 4, value: uint64(v)} }
+func fbInt64(v int64) fbScalar   { return fbScalar{size: 8, value: uint64(v)} }
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// encodeFlatbuffer serializes root as the root table of a flatbuffer.
+func encodeFlatbuffer(root fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.patch(0, b.writeTable(root))
+	return b.buf
+}
+
+func (b *fbBuilder) align(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch sets the uoffset at pos to point to target.
+func (b *fbBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// writeRef writes a referenced value and returns its position.
+func (b *fbBuilder) writeRef(value any) int {
+	switch v := value.(type) {
+	case string:
+		b.align(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, v...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case fbBytes:
+		b.align(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, v...)
+		return pos
+	case fbVector:
+		b.align(4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, make([]byte, 4*len(v))...)
+		for i, element := range v {
+			b.patch(pos+4+4*i, b.writeRef(element))
+		}
+		return pos
+	case fbTable:
+		return b.writeTable(v)
+	default:
+		panic(fmt.Sprintf("unsupported flatbuffer value %T", value))
+	}
+}
+
+func (b *fbBuilder) writeTable(t fbTable) int {
+	// layout of the table: the soffset to the vtable, followed by the fields
+	fieldOffsets := make([]int, len(t))
+	size := 4
+	for i, value := range t {
+		if value == nil {
+			continue
+		}
+		fieldSize := 4
+		if scalar, ok := value.(fbScalar); ok {
+			fieldSize = scalar.size
+		}
+		for size%fieldSize != 0 {
+			size++
+		}
+		fieldOffsets[i] = size
+		size += fieldSize
+	}
+
+	b.align(2)
+	vtablePos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(t)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	for _, offset := range fieldOffsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(offset))
+	}
+
+	b.align(8)
+	tablePos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[tablePos:], uint32(tablePos-vtablePos))
+	var refs []int
+	for i, value := range t {
+		if value == nil {
+			continue
+		}
+		pos := tablePos + fieldOffsets[i]
+		if scalar, ok := value.(fbScalar); ok {
+			if scalar.size == 8 {
+				binary.LittleEndian.PutUint64(b.buf[pos:], scalar.value)
+			} else {
+				binary.LittleEndian.PutUint32(b.buf[pos:], uint32(scalar.value))
+			}
+			continue
+		}
+		refs = append(refs, i)
+	}
+	for _, i := range refs {
+		b.patch(tablePos+fieldOffsets[i], b.writeRef(t[i]))
+	}
+	return tablePos
+}
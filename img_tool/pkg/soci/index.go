@@ -0,0 +1,70 @@
+package soci
+
+import (
+	"encoding/json"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+const (
+	// IndexArtifactType is the artifact type of a SOCI index manifest (and the media type of its empty config).
+	IndexArtifactType = "application/vnd.amazon.soci.index.v1+json"
+
+	// AnnotationLayerDigest is set on a ztoc descriptor and names the layer the ztoc belongs to.
+	AnnotationLayerDigest = "com.amazon.soci.image-layer-digest"
+	// AnnotationLayerMediaType is set on a ztoc descriptor and records the media type of the layer.
+	AnnotationLayerMediaType = "com.amazon.soci.image-layer-mediatype"
+	// AnnotationBuildToolIdentifier is set on a SOCI index manifest.
+	AnnotationBuildToolIdentifier = "com.amazon.soci.build-tool-identifier"
+)
+
+// IndexConfig is the config blob of a SOCI index manifest.
+var IndexConfig = []byte("{}")
+
+// ZtocDescriptor returns the descriptor of a ztoc of the given layer, as listed in the SOCI index.
+func ZtocDescriptor(digest string, size int64, layer api.Descriptor) api.Descriptor {
+	return api.Descriptor{
+		MediaType: ZtocMediaType,
+		Digest:    digest,
+		Size:      size,
+		Annotations: map[string]string{
+			AnnotationLayerDigest:    layer.Digest,
+			AnnotationLayerMediaType: layer.MediaType,
+		},
+	}
+}
+
+type indexManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType"`
+	Config        api.Descriptor    `json:"config"`
+	Layers        []api.Descriptor  `json:"layers"`
+	Subject       api.Descriptor    `json:"subject"`
+	Annotations   map[string]string `json:"annotations"`
+}
+
+// IndexManifest returns a SOCI index manifest (v1) for the image manifest subject.
+// The index is an OCI image manifest with the ztocs as layers. Pushed to a registry,
+// it is found through the referrers API of the image manifest.
+// configDigest is the digest of IndexConfig.
+func IndexManifest(subject api.Descriptor, configDigest string, ztocs []api.Descriptor) ([]byte, error) {
+	layers := make([]api.Descriptor, len(ztocs))
+	for i, ztoc := range ztocs {
+		// only the fields of an OCI descriptor
+		layers[i] = api.Descriptor{MediaType: ztoc.MediaType, Digest: ztoc.Digest, Size: ztoc.Size, Annotations: ztoc.Annotations}
+	}
+	return json.Marshal(indexManifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		ArtifactType:  IndexArtifactType,
+		Config: api.Descriptor{
+			MediaType: IndexArtifactType,
+			Digest:    configDigest,
+			Size:      int64(len(IndexConfig)),
+		},
+		Layers:      layers,
+		Subject:     api.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+		Annotations: map[string]string{AnnotationBuildToolIdentifier: BuildToolIdentifier},
+	})
+}
@@ -0,0 +1,406 @@
+package soci
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// windowSize is the size of the history of deflate streams (and of the window stored in a checkpoint).
+const windowSize = 1 << 15
+
+const (
+	maxCodeBits = 15
+	// fastBits is the number of bits resolved by a single lookup in the fast table of a Huffman code.
+	fastBits = 9
+)
+
+var errCorrupt = errors.New("corrupt deflate stream")
+
+// bitReader reads the bits of a deflate stream (least significant bit first)
+// and keeps track of the exact number of consumed bits.
+type bitReader struct {
+	r *bufio.Reader
+	// read is the number of bytes read from r.
+	read   int64
+	bitbuf uint64
+	bitcnt uint
+}
+
+// fill reads whole bytes into the bit buffer until it holds at least n bits or the input ends.
+func (b *bitReader) fill(n uint) error {
+	for b.bitcnt < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		b.read++
+		b.bitbuf |= uint64(c) << b.bitcnt
+		b.bitcnt += 8
+	}
+	return nil
+}
+
+func (b *bitReader) bits(n uint) (uint32, error) {
+	if err := b.fill(n); err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	v := uint32(b.bitbuf & (1<<n - 1))
+	b.bitbuf >>= n
+	b.bitcnt -= n
+	return v, nil
+}
+
+// alignToByte drops the remaining bits of the current byte.
+func (b *bitReader) alignToByte() {
+	drop := b.bitcnt % 8
+	b.bitbuf >>= drop
+	b.bitcnt -= drop
+}
+
+// position returns the offset of the first byte that was not completely consumed
+// and the number of bits of the byte before it that are still unused (like zlib with Z_BLOCK).
+func (b *bitReader) position() (offset int64, unusedBits uint8) {
+	consumed := b.read*8 - int64(b.bitcnt)
+	offset = (consumed + 7) / 8
+	return offset, uint8(offset*8 - consumed)
+}
+
+// huffman is a canonical Huffman code of a deflate block.
+type huffman struct {
+	counts  [maxCodeBits + 1]uint16
+	symbols []uint16
+	// fast maps the next fastBits bits to symbol<<4 | code length (0 if the code is longer).
+	fast [1 << fastBits]uint16
+}
+
+func newHuffman(lengths []uint8) (*huffman, error) {
+	h := &huffman{symbols: make([]uint16, len(lengths))}
+	for _, l := range lengths {
+		h.counts[l]++
+	}
+	h.counts[0] = 0
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.counts[l])
+		if left < 0 {
+			return nil, fmt.Errorf("%w: over-subscribed Huffman code", errCorrupt)
+		}
+	}
+	var offsets [maxCodeBits + 2]uint16
+	for l := 1; l <= maxCodeBits; l++ {
+		offsets[l+1] = offsets[l] + h.counts[l]
+	}
+	for symbol, l := range lengths {
+		if l != 0 {
+			h.symbols[offsets[l]] = uint16(symbol)
+			offsets[l]++
+		}
+	}
+
+	// canonical codes, reversed to the bit order of the stream
+	var nextCode [maxCodeBits + 1]int
+	code := 0
+	for l := 1; l <= maxCodeBits; l++ {
+		code = (code + int(h.counts[l-1])) << 1
+		nextCode[l] = code
+	}
+	for symbol, l := range lengths {
+		if l == 0 || l > fastBits {
+			if l != 0 {
+				nextCode[l]++
+			}
+			continue
+		}
+		c := nextCode[l]
+		nextCode[l]++
+		reversed := 0
+		for i := uint8(0); i < l; i++ {
+			reversed = reversed<<1 | (c>>i)&1
+		}
+		for k := reversed; k < 1<<fastBits; k += 1 << l {
+			h.fast[k] = uint16(symbol)<<4 | uint16(l)
+		}
+	}
+	return h, nil
+}
+
+// decode reads the next symbol of the code h.
+func (b *bitReader) decode(h *huffman) (uint16, error) {
+	// a short read is fine, as long as the code is complete
+	if err := b.fill(maxCodeBits); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if entry := h.fast[b.bitbuf&(1<<fastBits-1)]; entry != 0 {
+		l := uint(entry & 0xf)
+		if l <= b.bitcnt {
+			b.bitbuf >>= l
+			b.bitcnt -= l
+			return entry >> 4, nil
+		}
+	}
+	// canonical decoding, one bit at a time
+	code, first, index := 0, 0, 0
+	bitbuf := b.bitbuf
+	for l := uint(1); l <= maxCodeBits && l <= b.bitcnt; l++ {
+		code |= int(bitbuf & 1)
+		bitbuf >>= 1
+		count := int(h.counts[l])
+		if code-count < first {
+			b.bitbuf >>= l
+			b.bitcnt -= l
+			return h.symbols[index+(code-first)], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	if b.bitcnt < maxCodeBits {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return 0, fmt.Errorf("%w: invalid Huffman code", errCorrupt)
+}
+
+var (
+	lengthBase  = [...]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [...]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [...]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [...]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	// codeLengthOrder is the order of the code lengths of the code length code of a dynamic block.
+	codeLengthOrder = [...]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+var fixedLitLen, fixedDist = func() (*huffman, *huffman) {
+	var lengths [288]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	litLen, _ := newHuffman(lengths[:])
+	var distLengths [30]uint8
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	dist, _ := newHuffman(distLengths[:])
+	return litLen, dist
+}()
+
+// inflater decompresses a raw deflate stream into a window of the most recent output.
+// The decompressed data itself is not kept.
+type inflater struct {
+	in *bitReader
+	// window is a ring buffer with the last windowSize bytes of output.
+	window [windowSize]byte
+	// out is the number of decompressed bytes.
+	out int64
+}
+
+func (f *inflater) emit(c byte) {
+	f.window[f.out%windowSize] = c
+	f.out++
+}
+
+// snapshot returns the last windowSize bytes of output (padded with zeros at the front, like zran).
+func (f *inflater) snapshot() []byte {
+	pos := f.out % windowSize
+	snapshot := make([]byte, 0, windowSize)
+	snapshot = append(snapshot, f.window[pos:]...)
+	return append(snapshot, f.window[:pos]...)
+}
+
+// inflate decompresses the deflate stream.
+// atBoundary is called before every block that is not the first block and after the last block with final set.
+func (f *inflater) inflate(atBoundary func(final bool) error) error {
+	for {
+		final, err := f.in.bits(1)
+		if err != nil {
+			return err
+		}
+		blockType, err := f.in.bits(2)
+		if err != nil {
+			return err
+		}
+		switch blockType {
+		case 0:
+			err = f.stored()
+		case 1:
+			err = f.codes(fixedLitLen, fixedDist)
+		case 2:
+			err = f.dynamic()
+		default:
+			err = fmt.Errorf("%w: invalid block type", errCorrupt)
+		}
+		if err != nil {
+			return err
+		}
+		if err := atBoundary(final == 1); err != nil {
+			return err
+		}
+		if final == 1 {
+			return nil
+		}
+	}
+}
+
+func (f *inflater) stored() error {
+	f.in.alignToByte()
+	length, err := f.in.bits(16)
+	if err != nil {
+		return err
+	}
+	complement, err := f.in.bits(16)
+	if err != nil {
+		return err
+	}
+	if length != ^complement&0xffff {
+		return fmt.Errorf("%w: invalid length of stored block", errCorrupt)
+	}
+	for ; length > 0; length-- {
+		c, err := f.in.bits(8)
+		if err != nil {
+			return err
+		}
+		f.emit(byte(c))
+	}
+	return nil
+}
+
+func (f *inflater) dynamic() error {
+	hlit, err := f.in.bits(5)
+	if err != nil {
+		return err
+	}
+	hdist, err := f.in.bits(5)
+	if err != nil {
+		return err
+	}
+	hclen, err := f.in.bits(4)
+	if err != nil {
+		return err
+	}
+	numLitLen, numDist := int(hlit)+257, int(hdist)+1
+	if numLitLen > 286 || numDist > 30 {
+		return fmt.Errorf("%w: too many codes", errCorrupt)
+	}
+	var codeLengthLengths [19]uint8
+	for i := 0; i < int(hclen)+4; i++ {
+		l, err := f.in.bits(3)
+		if err != nil {
+			return err
+		}
+		codeLengthLengths[codeLengthOrder[i]] = uint8(l)
+	}
+	codeLengthCode, err := newHuffman(codeLengthLengths[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, numLitLen+numDist)
+	for i := 0; i < len(lengths); {
+		symbol, err := f.in.decode(codeLengthCode)
+		if err != nil {
+			return err
+		}
+		if symbol < 16 {
+			lengths[i] = uint8(symbol)
+			i++
+			continue
+		}
+		var repeat uint32
+		var value uint8
+		switch symbol {
+		case 16:
+			if i == 0 {
+				return fmt.Errorf("%w: repeated code length without a previous length", errCorrupt)
+			}
+			value = lengths[i-1]
+			repeat, err = f.in.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.in.bits(3)
+			repeat += 3
+		default:
+			repeat, err = f.in.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(repeat) > len(lengths) {
+			return fmt.Errorf("%w: too many code lengths", errCorrupt)
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return fmt.Errorf("%w: missing end-of-block code", errCorrupt)
+	}
+	litLen, err := newHuffman(lengths[:numLitLen])
+	if err != nil {
+		return err
+	}
+	dist, err := newHuffman(lengths[numLitLen:])
+	if err != nil {
+		return err
+	}
+	return f.codes(litLen, dist)
+}
+
+func (f *inflater) codes(litLen, dist *huffman) error {
+	for {
+		symbol, err := f.in.decode(litLen)
+		if err != nil {
+			return err
+		}
+		switch {
+		case symbol < 256:
+			f.emit(byte(symbol))
+			continue
+		case symbol == 256:
+			return nil
+		case symbol > 285:
+			return fmt.Errorf("%w: invalid length code", errCorrupt)
+		}
+		symbol -= 257
+		extra, err := f.in.bits(uint(lengthExtra[symbol]))
+		if err != nil {
+			return err
+		}
+		length := int64(lengthBase[symbol]) + int64(extra)
+
+		symbol, err = f.in.decode(dist)
+		if err != nil {
+			return err
+		}
+		if symbol > 29 {
+			return fmt.Errorf("%w: invalid distance code", errCorrupt)
+		}
+		extra, err = f.in.bits(uint(distExtra[symbol]))
+		if err != nil {
+			return err
+		}
+		distance := int64(distBase[symbol]) + int64(extra)
+		if distance > f.out {
+			return fmt.Errorf("%w: distance too far back", errCorrupt)
+		}
+		for ; length > 0; length-- {
+			f.emit(f.window[(f.out-distance)%windowSize])
+		}
+	}
+}
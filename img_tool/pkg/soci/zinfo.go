@@ -0,0 +1,208 @@
+package soci
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpanSize is the default (minimum) number of uncompressed bytes between two checkpoints.
+// It matches the default of the soci CLI.
+const DefaultSpanSize = 4 << 20
+
+// checkpoint is a point in a gzip stream where decompression can be resumed.
+// It follows the format of zran.c, as used by the soci-snapshotter.
+type checkpoint struct {
+	// in is the offset of the first compressed byte after the checkpoint.
+	in int64
+	// out is the offset of the first uncompressed byte after the checkpoint.
+	out int64
+	// bits is the number of bits of the byte at in-1 that belong to the next block (0 to 7).
+	bits uint8
+	// window is the uncompressed data before the checkpoint.
+	window []byte
+}
+
+// zinfo is the index of checkpoints of a gzip layer.
+type zinfo struct {
+	spanSize    int64
+	checkpoints []checkpoint
+	// compressedSize and uncompressedSize are the sizes of the whole layer.
+	compressedSize   int64
+	uncompressedSize int64
+}
+
+// buildZinfo decompresses the gzip stream and records a checkpoint at the start of the data
+// and at the first block boundary after every spanSize uncompressed bytes.
+// Only single-member gzip streams are supported.
+func buildZinfo(r io.Reader, spanSize int64) (*zinfo, error) {
+	in := &bitReader{r: bufio.NewReaderSize(r, 1<<20)}
+	if err := readGzipHeader(in); err != nil {
+		return nil, err
+	}
+	info := &zinfo{spanSize: spanSize}
+	f := &inflater{in: in}
+	addCheckpoint := func() {
+		offset, bits := in.position()
+		info.checkpoints = append(info.checkpoints, checkpoint{in: offset, out: f.out, bits: bits, window: f.snapshot()})
+	}
+	// the first checkpoint is directly after the gzip header
+	addCheckpoint()
+	last := int64(0)
+	err := f.inflate(func(final bool) error {
+		if !final && f.out-last > spanSize {
+			addCheckpoint()
+			last = f.out
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decompressing layer: %w", err)
+	}
+
+	// trailer: CRC-32 and size of the uncompressed data (modulo 2^32)
+	in.alignToByte()
+	if _, err := in.bits(16); err != nil {
+		return nil, fmt.Errorf("reading gzip trailer: %w", err)
+	}
+	if _, err := in.bits(16); err != nil {
+		return nil, fmt.Errorf("reading gzip trailer: %w", err)
+	}
+	isize, err := in.bits(16)
+	if err == nil {
+		var high uint32
+		high, err = in.bits(16)
+		isize |= high << 16
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading gzip trailer: %w", err)
+	}
+	if isize != uint32(f.out) {
+		return nil, fmt.Errorf("gzip trailer records %d uncompressed bytes, but the layer has %d", isize, uint32(f.out))
+	}
+	if err := in.fill(8); err != io.EOF {
+		if err == nil {
+			return nil, errors.New("multi-member gzip streams (or trailing data) are not supported")
+		}
+		return nil, err
+	}
+	info.compressedSize, _ = in.position()
+	info.uncompressedSize = f.out
+	return info, nil
+}
+
+// readGzipHeader skips the header of a gzip member (RFC 1952).
+func readGzipHeader(in *bitReader) error {
+	var header [10]byte
+	for i := range header {
+		c, err := in.bits(8)
+		if err != nil {
+			return fmt.Errorf("reading gzip header: %w", err)
+		}
+		header[i] = byte(c)
+	}
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 {
+		return errors.New("layer is not gzip compressed")
+	}
+	const (
+		flagHCRC    = 1 << 1
+		flagExtra   = 1 << 2
+		flagName    = 1 << 3
+		flagComment = 1 << 4
+	)
+	flags := header[3]
+	if flags&flagExtra != 0 {
+		extraLen, err := in.bits(16)
+		if err != nil {
+			return fmt.Errorf("reading gzip header: %w", err)
+		}
+		for ; extraLen > 0; extraLen-- {
+			if _, err := in.bits(8); err != nil {
+				return fmt.Errorf("reading gzip header: %w", err)
+			}
+		}
+	}
+	for _, flag := range []byte{flagName, flagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		for {
+			c, err := in.bits(8)
+			if err != nil {
+				return fmt.Errorf("reading gzip header: %w", err)
+			}
+			if c == 0 {
+				break
+			}
+		}
+	}
+	if flags&flagHCRC != 0 {
+		if _, err := in.bits(16); err != nil {
+			return fmt.Errorf("reading gzip header: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxSpanID is the index of the last span.
+func (z *zinfo) maxSpanID() int {
+	return len(z.checkpoints) - 1
+}
+
+// spanStart returns the compressed offset of the first byte needed to decompress the span.
+func (z *zinfo) spanStart(span int) int64 {
+	c := z.checkpoints[span]
+	if c.bits > 0 {
+		return c.in - 1
+	}
+	return c.in
+}
+
+// spanEnd returns the compressed offset after the last byte of the span.
+func (z *zinfo) spanEnd(span int) int64 {
+	if span == z.maxSpanID() {
+		return z.compressedSize
+	}
+	return z.checkpoints[span+1].in
+}
+
+// blob serializes the checkpoints in the format of the soci-snapshotter (gzip_zinfo.c):
+// the number of checkpoints (int32) and span size (int64),
+// followed by the compressed offset (int64), uncompressed offset (int64), bits (uint8) and window of every checkpoint.
+// All integers are little endian.
+func (z *zinfo) blob() []byte {
+	const checkpointSize = 8 + 8 + 1 + windowSize
+	buf := make([]byte, 0, 4+8+len(z.checkpoints)*checkpointSize)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(z.checkpoints)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(z.spanSize))
+	for _, c := range z.checkpoints {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(c.in))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(c.out))
+		buf = append(buf, c.bits)
+		buf = append(buf, c.window...)
+	}
+	return buf
+}
+
+// spanDigests returns the sha256 digest of the compressed bytes of every span of the layer.
+func (z *zinfo) spanDigests(layerPath string) ([]string, error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	digests := make([]string, 0, len(z.checkpoints))
+	for span := range z.checkpoints {
+		start, end := z.spanStart(span), z.spanEnd(span)
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start)); err != nil {
+			return nil, err
+		}
+		digests = append(digests, fmt.Sprintf("sha256:%x", h.Sum(nil)))
+	}
+	return digests, nil
+}
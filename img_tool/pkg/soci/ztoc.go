@@ -0,0 +1,179 @@
+// Package soci builds the artifacts of Seekable OCI (SOCI) images: the ztoc of a gzip layer
+// (a table of contents plus decompression checkpoints) and the SOCI index that references the ztocs of an image.
+// With these artifacts, the soci-snapshotter (used by AWS Fargate and containerd) lazily loads images.
+// The formats follow the soci-snapshotter (ztoc version 0.9 and SOCI index manifest v1).
+package soci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	// ZtocVersion is the version of the ztoc format.
+	ZtocVersion = "0.9"
+	// ZtocMediaType is the media type of a ztoc in a SOCI index.
+	ZtocMediaType = "application/octet-stream"
+	// BuildToolIdentifier identifies rules_img as the producer of ztocs and SOCI indexes.
+	BuildToolIdentifier = "rules_img"
+)
+
+// fileMetadata is an entry of the table of contents of a ztoc.
+type fileMetadata struct {
+	header *tar.Header
+	// offset is the offset of the file content in the uncompressed layer.
+	offset int64
+}
+
+// Ztoc builds the ztoc of the gzip compressed layer at layerPath.
+// spanSize is the number of uncompressed bytes between checkpoints (DefaultSpanSize if 0).
+func Ztoc(layerPath string, spanSize int64) ([]byte, error) {
+	if spanSize <= 0 {
+		spanSize = DefaultSpanSize
+	}
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := buildZinfo(f, spanSize)
+	if err != nil {
+		return nil, err
+	}
+	spanDigests, err := info.spanDigests(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	toc, err := tableOfContents(layerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(fbVector, len(toc))
+	for i, entry := range toc {
+		metadata[i], err = entry.table()
+		if err != nil {
+			return nil, err
+		}
+	}
+	digests := make(fbVector, len(spanDigests))
+	for i, d := range spanDigests {
+		digests[i] = d
+	}
+	// field order of ztoc.fbs of the soci-snapshotter
+	compressionInfo := fbTable{
+		fbInt32(int32(info.maxSpanID())), // max_span_id
+		digests,                          // span_digests
+		fbBytes(info.blob()),             // checkpoints
+		nil,                              // compression_algorithm (default: gzip)
+	}
+	ztoc := fbTable{
+		ZtocVersion,                    // version
+		BuildToolIdentifier,            // build_tool_identifier
+		fbInt64(info.compressedSize),   // compressed_archive_size
+		fbInt64(info.uncompressedSize), // uncompressed_archive_size
+		fbTable{metadata},              // toc
+		compressionInfo,                // compression_info
+	}
+	return encodeFlatbuffer(ztoc), nil
+}
+
+// tableOfContents lists the entries of the layer with the offsets of their content.
+func tableOfContents(layerPath string) ([]fileMetadata, error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(false)
+	uncompressed := &countingReader{r: gz}
+	tr := tar.NewReader(uncompressed)
+	var toc []fileMetadata
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading layer: %w", err)
+		}
+		toc = append(toc, fileMetadata{header: header, offset: uncompressed.n})
+	}
+	// verify the checksum of the gzip stream
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("reading layer: %w", err)
+	}
+	return toc, nil
+}
+
+func (m fileMetadata) table() (fbTable, error) {
+	h := m.header
+	var fileType string
+	switch h.Typeflag {
+	case tar.TypeReg:
+		fileType = "reg"
+	case tar.TypeDir:
+		fileType = "dir"
+	case tar.TypeSymlink:
+		fileType = "symlink"
+	case tar.TypeLink:
+		fileType = "hardlink"
+	case tar.TypeChar:
+		fileType = "char"
+	case tar.TypeBlock:
+		fileType = "block"
+	case tar.TypeFifo:
+		fileType = "fifo"
+	default:
+		return nil, fmt.Errorf("unsupported tar entry type %q of %s", h.Typeflag, h.Name)
+	}
+	xattrs := fbVector{}
+	for _, key := range slices.Sorted(maps.Keys(h.PAXRecords)) {
+		if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			xattrs = append(xattrs, fbTable{name, h.PAXRecords[key]})
+		}
+	}
+	// UTC keeps the ztoc independent of the time zone of the build
+	modTime, err := h.ModTime.UTC().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	// field order of ztoc.fbs of the soci-snapshotter
+	return fbTable{
+		h.Name,                  // name
+		fileType,                // type
+		fbInt64(m.offset),       // uncompressed_offset
+		fbInt64(h.Size),         // uncompressed_size
+		h.Linkname,              // linkname
+		fbInt64(h.Mode),         // mode
+		fbUint32(uint32(h.Uid)), // uid
+		fbUint32(uint32(h.Gid)), // gid
+		h.Uname,                 // uname
+		h.Gname,                 // gname
+		string(modTime),         // mod_time
+		fbInt64(h.Devmajor),     // devmajor
+		fbInt64(h.Devminor),     // devminor
+		xattrs,                  // xattrs
+	}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
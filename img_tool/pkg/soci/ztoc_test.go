@@ -0,0 +1,424 @@
+package soci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/klauspost/pgzip"
+)
+
+// Field slots of ztoc.fbs of the soci-snapshotter (in the order of the schema).
+const (
+	ztocVersion = iota
+	ztocBuildToolIdentifier
+	ztocCompressedArchiveSize
+	ztocUncompressedArchiveSize
+	ztocTOC
+	ztocCompressionInfo
+)
+
+const (
+	compressionInfoMaxSpanID = iota
+	compressionInfoSpanDigests
+	compressionInfoCheckpoints
+)
+
+const (
+	fileMetadataName = iota
+	fileMetadataType
+	fileMetadataUncompressedOffset
+	fileMetadataUncompressedSize
+	fileMetadataLinkname
+	fileMetadataMode
+	fileMetadataUID
+	fileMetadataGID
+	fileMetadataUname
+	fileMetadataGname
+	fileMetadataModTime
+	fileMetadataDevmajor
+	fileMetadataDevminor
+	fileMetadataXattrs
+)
+
+// The helpers below read tables like the code generated by flatc from ztoc.fbs.
+
+func slot(field int) flatbuffers.VOffsetT {
+	return flatbuffers.VOffsetT(4 + 2*field)
+}
+
+func fbString(t *flatbuffers.Table, field int) string {
+	o := flatbuffers.UOffsetT(t.Offset(slot(field)))
+	if o == 0 {
+		return ""
+	}
+	return t.String(o + t.Pos)
+}
+
+func fbTableField(t *flatbuffers.Table, field int) *flatbuffers.Table {
+	o := flatbuffers.UOffsetT(t.Offset(slot(field)))
+	if o == 0 {
+		return nil
+	}
+	return &flatbuffers.Table{Bytes: t.Bytes, Pos: t.Indirect(o + t.Pos)}
+}
+
+// fbVectorElements returns the positions of the elements (offsets to strings or tables) of a vector field.
+func fbVectorElements(t *flatbuffers.Table, field int) []flatbuffers.UOffsetT {
+	o := flatbuffers.UOffsetT(t.Offset(slot(field)))
+	if o == 0 {
+		return nil
+	}
+	start := t.Vector(o)
+	elements := make([]flatbuffers.UOffsetT, t.VectorLen(o))
+	for i := range elements {
+		elements[i] = start + flatbuffers.UOffsetT(i*flatbuffers.SizeUOffsetT)
+	}
+	return elements
+}
+
+func fbBytesField(t *flatbuffers.Table, field int) []byte {
+	o := flatbuffers.UOffsetT(t.Offset(slot(field)))
+	if o == 0 {
+		return nil
+	}
+	return t.ByteVector(o + t.Pos)
+}
+
+type testFile struct {
+	header  tar.Header
+	content []byte
+}
+
+// testFiles returns files with compressible text and incompressible random data,
+// so the gzip stream has both compressed and stored blocks.
+func testFiles() []testFile {
+	rng := rand.New(rand.NewSource(1))
+	var text bytes.Buffer
+	for i := 0; text.Len() < 3<<20; i++ {
+		fmt.Fprintf(&text, "line %d: %d\n", i, rng.Intn(1000))
+	}
+	random := make([]byte, 1<<20)
+	rng.Read(random)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []testFile{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "app/", Mode: 0o755, ModTime: modTime}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "app/text.txt", Mode: 0o644, Uid: 1000, Gid: 1000, Uname: "user", Gname: "group", ModTime: modTime}, content: text.Bytes()},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "app/random.bin", Mode: 0o600, ModTime: modTime, PAXRecords: map[string]string{"SCHILY.xattr.user.key": "value"}}, content: random},
+		{header: tar.Header{Typeflag: tar.TypeSymlink, Name: "app/link", Linkname: "text.txt", Mode: 0o777, ModTime: modTime}},
+		{header: tar.Header{Typeflag: tar.TypeLink, Name: "app/hardlink", Linkname: "app/text.txt", Mode: 0o644, ModTime: modTime}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "app/empty", Mode: 0o644, ModTime: modTime}},
+	}
+}
+
+func writeTar(t *testing.T, w io.Writer, files []testFile) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		header := f.header
+		header.Size = int64(len(f.content))
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bitWriter writes a deflate bit stream (least significant bit first).
+type bitWriter struct {
+	buf []byte
+	n   uint
+}
+
+func (w *bitWriter) bits(v uint32, n uint) {
+	for i := range n {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (w.n % 8)
+		w.n++
+	}
+}
+
+// code writes a Huffman code (most significant bit first).
+func (w *bitWriter) code(v uint32, n uint) {
+	for i := n; i > 0; i-- {
+		w.bits(v>>(i-1)&1, 1)
+	}
+}
+
+// padLengths are matches of the fixed Huffman code (symbol, code, code length, length, extra bits)
+// that make a block of 3+codeLength+extraBits+5+13+7 bits. The index is the size of the block modulo 8.
+var padLengths = [8]struct {
+	code           uint32
+	codeLength     uint
+	length         int
+	extraBits      uint
+	withEmptyBlock bool
+}{
+	1: {code: 0b11000001, codeLength: 8, length: 131, extraBits: 5},                       // 281
+	2: {code: 0b11000000, codeLength: 8, length: 115, extraBits: 4, withEmptyBlock: true}, // 280
+	3: {code: 1, codeLength: 7, length: 3},                                                // 257
+	4: {code: 9, codeLength: 7, length: 11, extraBits: 1},                                 // 265
+	5: {code: 13, codeLength: 7, length: 19, extraBits: 2},                                // 269
+	6: {code: 17, codeLength: 7, length: 35, extraBits: 3},                                // 273
+	7: {code: 21, codeLength: 7, length: 67, extraBits: 4},                                // 277
+}
+
+// resumeStream returns a deflate stream and dictionary for compress/flate that continue the stream at the checkpoint,
+// and the number of bytes to discard from the output.
+// zran.c primes the unused bits of the byte before the checkpoint (inflatePrime), which compress/flate can't do.
+// Instead, a block that copies the end of the dictionary is put in front of these bits,
+// so that the following blocks keep their alignment (which matters for stored blocks).
+func resumeStream(compressed []byte, c checkpoint) (stream, dict []byte, discard int) {
+	if c.bits == 0 {
+		return compressed[c.in:], c.window, 0
+	}
+	pad := padLengths[8-c.bits]
+	w := &bitWriter{}
+	if pad.withEmptyBlock {
+		w.bits(0b010, 3) // fixed Huffman block
+		w.code(0, 7)     // end of block
+	}
+	w.bits(0b010, 3)
+	w.code(pad.code, pad.codeLength)
+	w.bits(0, pad.extraBits)
+	w.code(29, 5) // distance 24577 + extra bits
+	w.bits(32768-24577, 13)
+	w.code(0, 7)
+	w.bits(uint32(compressed[c.in-1]>>(8-c.bits)), uint(c.bits))
+	stream = append(w.buf, compressed[c.in:]...)
+
+	// the copy (of distance 32768) repeats the start of the dictionary, which is the end of the window
+	dict = append(slices.Clone(c.window[windowSize-pad.length:]), c.window[:windowSize-pad.length]...)
+	return stream, dict, pad.length
+}
+
+func TestZtoc(t *testing.T) {
+	const spanSize = 256 << 10
+
+	compressors := map[string]func(io.Writer) io.WriteCloser{
+		"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		// multiple pgzip blocks, like the layers of rules_img
+		"pgzip": func(w io.Writer) io.WriteCloser {
+			zw := pgzip.NewWriter(w)
+			if err := zw.SetConcurrency(1<<20, 4); err != nil {
+				t.Fatal(err)
+			}
+			return zw
+		},
+	}
+
+	for name, newCompressor := range compressors {
+		t.Run(name, func(t *testing.T) {
+			files := testFiles()
+			var uncompressedBuf bytes.Buffer
+			writeTar(t, &uncompressedBuf, files)
+			uncompressed := uncompressedBuf.Bytes()
+
+			var compressedBuf bytes.Buffer
+			zw := newCompressor(&compressedBuf)
+			if _, err := zw.Write(uncompressed); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			compressed := compressedBuf.Bytes()
+			layerPath := filepath.Join(t.TempDir(), "layer.tar.gz")
+			if err := os.WriteFile(layerPath, compressed, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := Ztoc(layerPath, spanSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ztoc := &flatbuffers.Table{Bytes: raw, Pos: flatbuffers.GetUOffsetT(raw)}
+
+			if got := fbString(ztoc, ztocVersion); got != ZtocVersion {
+				t.Errorf("version = %q, want %q", got, ZtocVersion)
+			}
+			if got := fbString(ztoc, ztocBuildToolIdentifier); got != BuildToolIdentifier {
+				t.Errorf("build_tool_identifier = %q, want %q", got, BuildToolIdentifier)
+			}
+			if got := ztoc.GetInt64Slot(slot(ztocCompressedArchiveSize), 0); got != int64(len(compressed)) {
+				t.Errorf("compressed_archive_size = %d, want %d", got, len(compressed))
+			}
+			if got := ztoc.GetInt64Slot(slot(ztocUncompressedArchiveSize), 0); got != int64(len(uncompressed)) {
+				t.Errorf("uncompressed_archive_size = %d, want %d", got, len(uncompressed))
+			}
+
+			t.Run("toc", func(t *testing.T) {
+				toc := fbTableField(ztoc, ztocTOC)
+				elements := fbVectorElements(toc, 0)
+				if len(elements) != len(files) {
+					t.Fatalf("toc has %d entries, want %d", len(elements), len(files))
+				}
+				wantTypes := map[byte]string{tar.TypeDir: "dir", tar.TypeReg: "reg", tar.TypeSymlink: "symlink", tar.TypeLink: "hardlink"}
+				for i, pos := range elements {
+					entry := &flatbuffers.Table{Bytes: raw, Pos: toc.Indirect(pos)}
+					want := files[i]
+					if got := fbString(entry, fileMetadataName); got != want.header.Name {
+						t.Errorf("entry %d: name = %q, want %q", i, got, want.header.Name)
+					}
+					if got := fbString(entry, fileMetadataType); got != wantTypes[want.header.Typeflag] {
+						t.Errorf("%s: type = %q, want %q", want.header.Name, got, wantTypes[want.header.Typeflag])
+					}
+					if got := fbString(entry, fileMetadataLinkname); got != want.header.Linkname {
+						t.Errorf("%s: linkname = %q, want %q", want.header.Name, got, want.header.Linkname)
+					}
+					if got := entry.GetInt64Slot(slot(fileMetadataMode), 0); got != want.header.Mode {
+						t.Errorf("%s: mode = %o, want %o", want.header.Name, got, want.header.Mode)
+					}
+					if got := entry.GetUint32Slot(slot(fileMetadataUID), 0); got != uint32(want.header.Uid) {
+						t.Errorf("%s: uid = %d, want %d", want.header.Name, got, want.header.Uid)
+					}
+					if got := fbString(entry, fileMetadataUname); got != want.header.Uname {
+						t.Errorf("%s: uname = %q, want %q", want.header.Name, got, want.header.Uname)
+					}
+					if got := fbString(entry, fileMetadataModTime); got != "2024-01-02T03:04:05Z" {
+						t.Errorf("%s: mod_time = %q", want.header.Name, got)
+					}
+					offset := entry.GetInt64Slot(slot(fileMetadataUncompressedOffset), 0)
+					size := entry.GetInt64Slot(slot(fileMetadataUncompressedSize), 0)
+					if size != int64(len(want.content)) {
+						t.Errorf("%s: uncompressed_size = %d, want %d", want.header.Name, size, len(want.content))
+					}
+					if !bytes.Equal(uncompressed[offset:offset+size], want.content) {
+						t.Errorf("%s: uncompressed_offset %d doesn't point to the content", want.header.Name, offset)
+					}
+					xattrs := fbVectorElements(entry, fileMetadataXattrs)
+					wantXattrs := 0
+					if want.header.PAXRecords != nil {
+						wantXattrs = 1
+					}
+					if len(xattrs) != wantXattrs {
+						t.Fatalf("%s: %d xattrs, want %d", want.header.Name, len(xattrs), wantXattrs)
+					}
+					for _, xattrPos := range xattrs {
+						xattr := &flatbuffers.Table{Bytes: raw, Pos: entry.Indirect(xattrPos)}
+						if key, value := fbString(xattr, 0), fbString(xattr, 1); key != "user.key" || value != "value" {
+							t.Errorf("%s: xattr %q=%q, want user.key=value", want.header.Name, key, value)
+						}
+					}
+				}
+			})
+
+			t.Run("checkpoints", func(t *testing.T) {
+				info := fbTableField(ztoc, ztocCompressionInfo)
+				maxSpanID := info.GetInt32Slot(slot(compressionInfoMaxSpanID), 0)
+				blob := fbBytesField(info, compressionInfoCheckpoints)
+
+				// gzip_zinfo.c: int32 count and int64 span size, followed by the checkpoints
+				count := int(binary.LittleEndian.Uint32(blob))
+				if got := int64(binary.LittleEndian.Uint64(blob[4:])); got != spanSize {
+					t.Errorf("span size = %d, want %d", got, spanSize)
+				}
+				if count != int(maxSpanID)+1 {
+					t.Fatalf("%d checkpoints, but max_span_id is %d", count, maxSpanID)
+				}
+				if minCount := len(uncompressed) / (2 * spanSize); count < minCount {
+					t.Fatalf("%d checkpoints for %d uncompressed bytes, want at least %d", count, len(uncompressed), minCount)
+				}
+				const checkpointSize = 8 + 8 + 1 + windowSize
+				if len(blob) != 12+count*checkpointSize {
+					t.Fatalf("checkpoints are %d bytes, want %d", len(blob), 12+count*checkpointSize)
+				}
+				checkpoints := make([]checkpoint, count)
+				for i := range checkpoints {
+					c := blob[12+i*checkpointSize:]
+					checkpoints[i] = checkpoint{
+						in:     int64(binary.LittleEndian.Uint64(c)),
+						out:    int64(binary.LittleEndian.Uint64(c[8:])),
+						bits:   c[16],
+						window: c[17:checkpointSize],
+					}
+				}
+
+				digests := fbVectorElements(info, compressionInfoSpanDigests)
+				if len(digests) != count {
+					t.Fatalf("%d span digests, want %d", len(digests), count)
+				}
+				for i, c := range checkpoints {
+					nextOut, nextIn := int64(len(uncompressed)), int64(len(compressed))
+					if i+1 < count {
+						nextOut, nextIn = checkpoints[i+1].out, checkpoints[i+1].in
+						if nextOut-c.out < spanSize && i > 0 {
+							t.Errorf("checkpoint %d: span of %d bytes is smaller than the span size", i, nextOut-c.out)
+						}
+					}
+					if c.bits > 7 {
+						t.Fatalf("checkpoint %d: %d bits", i, c.bits)
+					}
+
+					// the window is the uncompressed data before the checkpoint (padded with zeros)
+					wantWindow := make([]byte, windowSize)
+					history := uncompressed[max(0, c.out-windowSize):c.out]
+					copy(wantWindow[windowSize-len(history):], history)
+					if !bytes.Equal(c.window, wantWindow) {
+						t.Errorf("checkpoint %d: window doesn't match the data before offset %d", i, c.out)
+					}
+
+					// zlib reference: resuming the stream at the checkpoint yields the data of the span
+					stream, dict, discard := resumeStream(compressed, c)
+					r := flate.NewReaderDict(bytes.NewReader(stream), dict)
+					if _, err := io.CopyN(io.Discard, r, int64(discard)); err != nil {
+						t.Fatalf("checkpoint %d: %v", i, err)
+					}
+					span := make([]byte, nextOut-c.out)
+					if _, err := io.ReadFull(r, span); err != nil {
+						t.Fatalf("checkpoint %d: resuming at %d (+%d bits): %v", i, c.in, c.bits, err)
+					}
+					if !bytes.Equal(span, uncompressed[c.out:nextOut]) {
+						t.Errorf("checkpoint %d: resuming at %d (+%d bits) doesn't yield the data at %d", i, c.in, c.bits, c.out)
+					}
+
+					start := c.in
+					if c.bits > 0 {
+						start--
+					}
+					wantDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(compressed[start:nextIn]))
+					if got := info.String(digests[i]); got != wantDigest {
+						t.Errorf("span %d: digest = %s, want %s", i, got, wantDigest)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestZtocMultiMember(t *testing.T) {
+	var compressed bytes.Buffer
+	for range 2 {
+		zw := gzip.NewWriter(&compressed)
+		writeTar(t, zw, testFiles()[:2])
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layerPath := filepath.Join(t.TempDir(), "layer.tar.gz")
+	if err := os.WriteFile(layerPath, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Ztoc(layerPath, 0); err == nil {
+		t.Fatal("expected an error for a multi-member gzip stream")
+	}
+}
@@ -0,0 +1,39 @@
+// Package subject reads the subject of a manifest or index:
+// the image manifest or image index that an artifact, signature or attestation refers to.
+package subject
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ReadDescriptor reads a raw image manifest or image index and returns a descriptor
+// referencing it, suitable for the subject field of a manifest or index.
+func ReadDescriptor(filePath string) (*specv1.Descriptor, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading subject: %w", err)
+	}
+	var header struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("decoding subject: %w", err)
+	}
+	switch header.MediaType {
+	case specv1.MediaTypeImageManifest, specv1.MediaTypeImageIndex:
+	case "":
+		return nil, fmt.Errorf("subject %s has no mediaType", filePath)
+	default:
+		return nil, fmt.Errorf("subject %s has unsupported mediaType %s (expected image manifest or image index)", filePath, header.MediaType)
+	}
+	return &specv1.Descriptor{
+		MediaType: header.MediaType,
+		Digest:    digest.FromBytes(raw),
+		Size:      int64(len(raw)),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package subject
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReadDescriptor(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		raw       string
+		mediaType string
+		wantErr   string
+	}{
+		{raw: `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`, mediaType: specv1.MediaTypeImageManifest},
+		{raw: `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`, mediaType: specv1.MediaTypeImageIndex},
+		{raw: `{"schemaVersion":2}`, wantErr: "has no mediaType"},
+		{raw: `{"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`, wantErr: "unsupported mediaType"},
+		{raw: `not json`, wantErr: "decoding subject"},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("subject%d.json", i))
+		if err := os.WriteFile(path, []byte(tt.raw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadDescriptor(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadDescriptor(%s) error = %v, want %q", tt.raw, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReadDescriptor(%s) error = %v", tt.raw, err)
+			continue
+		}
+		want := specv1.Descriptor{MediaType: tt.mediaType, Digest: digest.FromString(tt.raw), Size: int64(len(tt.raw))}
+		if got.MediaType != want.MediaType || got.Digest != want.Digest || got.Size != want.Size {
+			t.Errorf("ReadDescriptor(%s) = %+v, want %+v", tt.raw, *got, want)
+		}
+	}
+
+	if _, err := ReadDescriptor(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadDescriptor() of a missing file succeeded")
+	}
+}
@@ -31,8 +31,37 @@ const (
 
 type options struct {
 	structure                 FileStructure
+	sortByPath                bool
 	writeHeaderCallback       WriteHeaderCallback
 	writeHeaderCallbackFilter WriteHeaderCallbackFilter
+	observers                 []observer
+}
+
+// Observe registers a callback that is notified about every entry matching filter
+// as it is written to the tar (including deferred entries, which are reported when they are written).
+// Observers can be used to produce side outputs (like file lists or search indexes)
+// in the same pass that writes the layer instead of re-reading the tar.
+// Observe can be used multiple times and is independent of the WriteHeaderCallback option.
+// Deduplicated regular files are reported as hardlinks to their CAS object below ".cas/".
+// Callbacks must not modify the header.
+func Observe(callback WriteHeaderCallback, filter WriteHeaderCallbackFilter) Option {
+	return observer{callback: callback, filter: filter}
+}
+
+// SortByPath writes the entries of the tar sorted by path instead of in the order they are added,
+// so that the layer does not depend on the order of the inputs.
+// CAS objects and the remaining entries are sorted separately (the structure is kept)
+// and hardlinks are written after their target.
+// Entries are spooled to a temporary file until Close is called.
+func SortByPath() Option {
+	return sortByPath{}
+}
+
+type sortByPath struct{}
+
+type observer struct {
+	callback WriteHeaderCallback
+	filter   WriteHeaderCallbackFilter
 }
 
 func (s FileStructure) apply(opts *options) { opts.structure = s }
@@ -40,3 +69,7 @@ func (s FileStructure) apply(opts *options) { opts.structure = s }
 func (f WriteHeaderCallback) apply(opts *options) { opts.writeHeaderCallback = f }
 
 func (f WriteHeaderCallbackFilter) apply(opts *options) { opts.writeHeaderCallbackFilter = f }
+
+func (o observer) apply(opts *options) { opts.observers = append(opts.observers, o) }
+
+func (sortByPath) apply(opts *options) { opts.sortByPath = true }
@@ -0,0 +1,134 @@
+package tarcas
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// spooledEntry is an entry that is written when the CAS is closed (see SortByPath).
+type spooledEntry struct {
+	hdr *tar.Header
+	// data is the content of regular files, stored in the spool file.
+	data *io.SectionReader
+}
+
+// spoolEntry records an entry (and its data) to be written sorted when the CAS is closed.
+func (c *CAS[HM]) spoolEntry(entries *[]spooledEntry, hdr *tar.Header, data io.Reader) error {
+	header := cloneTarHeader(hdr)
+	entry := spooledEntry{hdr: &header}
+	if hdr.Typeflag == tar.TypeReg && data != nil {
+		if c.spool == nil {
+			spool, err := os.CreateTemp("", "tarcas-spool-*")
+			if err != nil {
+				return fmt.Errorf("creating spool file for sorted tar: %w", err)
+			}
+			c.spool = spool
+		}
+		n, err := io.Copy(c.spool, io.LimitReader(data, hdr.Size))
+		if err != nil {
+			return fmt.Errorf("spooling %s: %w", hdr.Name, err)
+		}
+		if n != hdr.Size {
+			return fmt.Errorf("spooling %s: expected %d bytes, got %d", hdr.Name, hdr.Size, n)
+		}
+		entry.data = io.NewSectionReader(c.spool, c.spoolSize, n)
+		c.spoolSize += n
+	}
+	*entries = append(*entries, entry)
+	return nil
+}
+
+// closeSorted writes the spooled CAS objects and entries sorted by path.
+func (c *CAS[HM]) closeSorted() error {
+	defer c.removeSpool()
+	for _, entry := range sortEntries(c.spooledObjects) {
+		if err := c.writeHeaderAndData(entry.hdr, readerOrNil(entry.data)); err != nil {
+			return fmt.Errorf("error writing sorted CAS object: %w", err)
+		}
+	}
+	for _, entry := range sortEntries(c.spooledEntries) {
+		if err := c.writeHeaderOrDefer(entry.hdr, readerOrNil(entry.data)); err != nil {
+			return fmt.Errorf("error writing sorted header: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *CAS[HM]) removeSpool() {
+	if c.spool == nil {
+		return
+	}
+	c.spool.Close()
+	os.Remove(c.spool.Name())
+	c.spool = nil
+}
+
+// sortEntries sorts entries by path.
+// Hardlinks to other entries are moved right behind their target if the target would come later.
+func sortEntries(entries []spooledEntry) []spooledEntry {
+	slices.SortStableFunc(entries, func(a, b spooledEntry) int {
+		return strings.Compare(sortKey(a.hdr.Name), sortKey(b.hdr.Name))
+	})
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[sortKey(entry.hdr.Name)] = true
+	}
+	written := make(map[string]bool, len(entries))
+	emitted := make(map[*tar.Header]bool, len(entries))
+	waiting := make(map[string][]spooledEntry)
+	sorted := make([]spooledEntry, 0, len(entries))
+	var emit func(entry spooledEntry)
+	emit = func(entry spooledEntry) {
+		sorted = append(sorted, entry)
+		emitted[entry.hdr] = true
+		name := sortKey(entry.hdr.Name)
+		written[name] = true
+		links := waiting[name]
+		delete(waiting, name)
+		for _, link := range links {
+			emit(link)
+		}
+	}
+	for _, entry := range entries {
+		if entry.hdr.Typeflag == tar.TypeLink {
+			target := sortKey(entry.hdr.Linkname)
+			if names[target] && !written[target] {
+				waiting[target] = append(waiting[target], entry)
+				continue
+			}
+		}
+		emit(entry)
+	}
+	// hardlinks whose target never gets written (cycles) keep their relative order
+	for _, entry := range entries {
+		if !emitted[entry.hdr] {
+			sorted = append(sorted, entry)
+		}
+	}
+	return sorted
+}
+
+// sortKey normalizes a path in the tar for sorting ("./a/b/" and "a/b" are the same path).
+func sortKey(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+}
+
+// sortedHashes returns a sorted copy of hashes.
+func sortedHashes(hashes [][]byte) [][]byte {
+	sorted := slices.Clone(hashes)
+	slices.SortFunc(sorted, bytes.Compare)
+	return sorted
+}
+
+func readerOrNil(data *io.SectionReader) io.Reader {
+	if data == nil {
+		return nil
+	}
+	return data
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/fs"
 	"iter"
+	"os"
 	"path"
 	"strings"
 
@@ -29,6 +30,11 @@ type CAS[HM hashHelper] struct {
 	storedTrees   map[string]struct{}
 	closed        bool
 	digestFS      *digestfs.FileSystem
+	// spooled entries and the spool file holding their data (only used with SortByPath)
+	spooledObjects []spooledEntry
+	spooledEntries []spooledEntry
+	spool          *os.File
+	spoolSize      int64
 	options
 }
 
@@ -78,6 +84,9 @@ func NewWithDigestFS[HM hashHelper](appender api.TarAppender, digestFS *digestfs
 }
 
 func (c *CAS[HM]) writeHeaderAndData(hdr *tar.Header, data io.Reader) error {
+	if c.sortByPath && !c.closed {
+		return c.spoolEntry(&c.spooledObjects, hdr, data)
+	}
 	// Create a tar entry with header and data combined
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
@@ -121,6 +130,13 @@ func (c *CAS[HM]) Import(from api.CASStateSupplier) error {
 }
 
 func (c *CAS[HM]) Export(to api.CASStateExporter) error {
+	if c.sortByPath {
+		return to.Export(&exporterState{
+			hashOrder: sortedHashes(c.hashOrder),
+			nodeOrder: sortedHashes(c.nodeOrder),
+			treeOrder: sortedHashes(c.treeOrder),
+		})
+	}
 	return to.Export(&exporterState{
 		hashOrder: c.hashOrder,
 		nodeOrder: c.nodeOrder,
@@ -137,6 +153,9 @@ func (c *CAS[HM]) Close() error {
 	}
 
 	c.closed = true
+	if c.sortByPath {
+		return c.closeSorted()
+	}
 	for _, hdr := range c.deferredFiles {
 		if err := c.writeHeaderOrDefer(hdr, nil); err != nil {
 			return fmt.Errorf("error writing deferred header: %w", err)
@@ -408,8 +427,7 @@ func (c *CAS[HM]) StoreTree(fsys fs.FS) (linkPath string, err error) {
 func (c *CAS[HM]) StoreTreeKnownHash(fsys fs.FS, treeHash []byte) (linkPath string, err error) {
 	// Every regular file in the tree is a CAS object, so we need to store it,
 	// along with a hardlink to the CAS object.
-	// For now, we don't support any special metadata for tree artifacts and disallow empty directories,
-	// so we can get away with storing a single directory entry (for the root directory of the tree).
+	// Directories (including empty ones) and symlinks are written as entries of the tree.
 	treeBase := casPath("tree", treeHash)
 	if _, exists := c.storedTrees[string(treeHash)]; exists {
 		return treeBase, nil
@@ -429,9 +447,42 @@ func (c *CAS[HM]) StoreTreeKnownHash(fsys fs.FS, treeHash []byte) (linkPath stri
 		if err != nil {
 			return fmt.Errorf("walking directory %s: %w", p, err)
 		}
-		if !d.Type().IsRegular() {
-			// Skip non-regular files
+		if p == "." {
+			// the root directory is already written
+			return nil
+		}
+		switch {
+		case d.IsDir():
+			header := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     path.Join(treeBase, p),
+				Mode:     0o755,
+			}
+			if err := c.writeHeaderAndData(header, nil); err != nil {
+				return fmt.Errorf("writing directory %s: %w", p, err)
+			}
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			linkFS, ok := fsys.(merkle.ReadLinkFS)
+			if !ok {
+				return fmt.Errorf("reading symlink %s: filesystem doesn't support reading symlinks", p)
+			}
+			target, err := linkFS.ReadLink(p)
+			if err != nil {
+				return fmt.Errorf("reading symlink %s: %w", p, err)
+			}
+			header := &tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     path.Join(treeBase, p),
+				Linkname: target,
+				Mode:     0o777,
+			}
+			if err := c.writeHeaderAndData(header, nil); err != nil {
+				return fmt.Errorf("writing symlink %s: %w", p, err)
+			}
 			return nil
+		case !d.Type().IsRegular():
+			return fmt.Errorf("unsupported file type %v of %s", d.Type().String(), p)
 		}
 		f, err := fsys.Open(p)
 		if err != nil {
@@ -463,6 +514,10 @@ func (c *CAS[HM]) StoreTreeKnownHash(fsys fs.FS, treeHash []byte) (linkPath stri
 }
 
 func (c *CAS[HM]) writeHeaderOrDefer(hdr *tar.Header, data io.Reader) error {
+	if c.sortByPath && !c.closed {
+		// All entries are written in order when Close() is called.
+		return c.spoolEntry(&c.spooledEntries, hdr, data)
+	}
 	if hdr.Typeflag != tar.TypeReg && c.structure == CASFirst && !c.closed {
 		// Defer writing the header for non-regular files
 		// until Close() is called.
@@ -475,6 +530,14 @@ func (c *CAS[HM]) writeHeaderOrDefer(hdr *tar.Header, data io.Reader) error {
 			return fmt.Errorf("WriteHeader callback error: %w", err)
 		}
 	}
+	for _, o := range c.observers {
+		if callbackModeFromTarType(hdr)&o.filter == 0 {
+			continue
+		}
+		if err := o.callback(hdr); err != nil {
+			return fmt.Errorf("observer error for %s: %w", hdr.Name, err)
+		}
+	}
 
 	if hdr.Typeflag != tar.TypeReg && c.structure == CASOnly {
 		// Skip writing the header for non-regular files
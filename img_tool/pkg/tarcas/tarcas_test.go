@@ -0,0 +1,124 @@
+package tarcas
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+// bufferAppender collects the tar entries written by the CAS.
+type bufferAppender struct {
+	bytes.Buffer
+}
+
+func (b *bufferAppender) AppendTar(r io.Reader) error {
+	_, err := io.Copy(&b.Buffer, r)
+	return err
+}
+
+func (b *bufferAppender) Finalize() (api.AppenderState, error) {
+	return api.AppenderState{}, nil
+}
+
+// linkFS is a file system that lists symlinks as symlinks.
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (l linkFS) ReadLink(name string) (string, error) {
+	f, ok := l.MapFS[name]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(f.Data), nil
+}
+
+func TestStoreTree(t *testing.T) {
+	var appender bufferAppender
+	cas := NewSHA256CAS(&appender)
+	fsys := linkFS{fstest.MapFS{
+		"lib/libfoo.so.1": {Data: []byte("foo"), Mode: 0o644},
+		"lib/libfoo.so":   {Data: []byte("libfoo.so.1"), Mode: fs.ModeSymlink | 0o777},
+		"var/cache":       {Mode: fs.ModeDir | 0o755},
+	}}
+	treeBase, err := cas.StoreTree(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cas.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readEntries(t, &appender.Buffer)
+	tests := []struct {
+		name     string
+		typeflag byte
+		linkname string
+	}{
+		{name: treeBase, typeflag: tar.TypeDir},
+		{name: path.Join(treeBase, "lib"), typeflag: tar.TypeDir},
+		{name: path.Join(treeBase, "lib/libfoo.so"), typeflag: tar.TypeSymlink, linkname: "libfoo.so.1"},
+		{name: path.Join(treeBase, "lib/libfoo.so.1"), typeflag: tar.TypeLink},
+		{name: path.Join(treeBase, "var"), typeflag: tar.TypeDir},
+		{name: path.Join(treeBase, "var/cache"), typeflag: tar.TypeDir},
+	}
+	for _, tt := range tests {
+		hdr, ok := entries[tt.name]
+		if !ok {
+			t.Errorf("missing tar entry %s", tt.name)
+			continue
+		}
+		if hdr.Typeflag != tt.typeflag {
+			t.Errorf("type of %s = %c, want %c", tt.name, hdr.Typeflag, tt.typeflag)
+		}
+		if tt.linkname != "" && hdr.Linkname != tt.linkname {
+			t.Errorf("link target of %s = %q, want %q", tt.name, hdr.Linkname, tt.linkname)
+		}
+	}
+
+	// storing the same tree again only returns the path of the stored tree
+	size := appender.Len()
+	again, err := cas.StoreTree(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != treeBase || appender.Len() != size {
+		t.Errorf("StoreTree() of a stored tree = %s and wrote %d bytes, want %s and nothing", again, appender.Len()-size, treeBase)
+	}
+}
+
+func TestStoreTreeKnownHashSymlinkWithoutReadLink(t *testing.T) {
+	cas := NewSHA256CAS(&bufferAppender{})
+	// hides the ReadLink method of the file system
+	fsys := struct{ fs.ReadDirFS }{linkFS{fstest.MapFS{
+		"libfoo.so": {Data: []byte("libfoo.so.1"), Mode: fs.ModeSymlink | 0o777},
+	}}}
+	_, err := cas.StoreTreeKnownHash(fsys, []byte("tree"))
+	if err == nil || !strings.Contains(err.Error(), "filesystem doesn't support reading symlinks") {
+		t.Errorf("StoreTreeKnownHash() error = %v, want an error about reading symlinks", err)
+	}
+}
+
+func readEntries(t *testing.T, r io.Reader) map[string]*tar.Header {
+	t.Helper()
+	entries := make(map[string]*tar.Header)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = hdr
+	}
+}
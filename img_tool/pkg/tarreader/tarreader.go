@@ -0,0 +1,77 @@
+// Package tarreader reads the entries of (optionally compressed) tar files,
+// such as layer blobs. It is shared by the img test command and the
+// integration test framework.
+package tarreader
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+)
+
+// Entry holds information about a tar entry
+type Entry struct {
+	Header  *tar.Header
+	Content []byte
+}
+
+// Walk calls fn for every entry of the tar file at path.
+// The tar file may be uncompressed, gzip compressed or zstd compressed.
+// The content reader is only valid until fn returns.
+func Walk(path string, fn func(header *tar.Header, content io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader, err := fileopener.CompressionReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to detect compression of %s: %w", path, err)
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %w", err)
+		}
+		if err := fn(header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadEntries reads all entries from a tar file (optionally compressed), keyed by name.
+// The content of regular files is kept in memory.
+func ReadEntries(path string) (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+	err := Walk(path, func(header *tar.Header, content io.Reader) error {
+		var data []byte
+		if header.Typeflag == tar.TypeReg {
+			var err error
+			data, err = io.ReadAll(content)
+			if err != nil {
+				return fmt.Errorf("error reading file content for %s: %w", header.Name, err)
+			}
+		}
+		entries[header.Name] = &Entry{
+			Header:  header,
+			Content: data,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,71 @@
+package templating
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Configuration is the result of template expansion (or the templates themselves,
+// if no expansion was needed), as written by the deploy rules.
+type Configuration map[string]any
+
+// ReadConfiguration reads an expanded configuration file.
+func ReadConfiguration(path string) (Configuration, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading configuration file: %w", err)
+	}
+	var config Configuration
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("unmarshalling configuration file: %w", err)
+	}
+	return config, nil
+}
+
+// String returns the string value of key, or the empty string if the key is not set.
+func (c Configuration) String(key string) (string, error) {
+	value, ok := c[key]
+	if !ok || value == nil {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("configuration field %q is not a string", key)
+	}
+	return s, nil
+}
+
+// RequiredString returns the string value of key and fails if it is missing or empty.
+func (c Configuration) RequiredString(key string) (string, error) {
+	s, err := c.String(key)
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", fmt.Errorf("configuration file must contain a non-empty '%s' field", key)
+	}
+	return s, nil
+}
+
+// StringList returns the list value of key with empty values and duplicates removed.
+// A missing key results in an empty list.
+func (c Configuration) StringList(key string) ([]string, error) {
+	value, ok := c[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("configuration field %q is not a list", key)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s at index %d is not a string", key, i)
+		}
+		values[i] = s
+	}
+	return NormalizeList(values), nil
+}
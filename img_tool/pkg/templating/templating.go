@@ -0,0 +1,214 @@
+// Package templating implements the Go template expansion of rule attributes
+// (build settings and stamp variables) shared by all commands that consume
+// templated configuration, so that push, load, and docker-save behave identically.
+package templating
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// listKeysWithSetSemantics are template keys whose expanded values are sorted,
+// deduplicated and stripped of empty entries.
+var listKeysWithSetSemantics = []string{"tags"}
+
+// Request represents the input JSON for template expansion.
+type Request struct {
+	BuildSettings map[string]BuildSetting    `json:"build_settings"`
+	Templates     map[string]json.RawMessage `json:"templates"`
+}
+
+// BuildSetting represents the "value" of the Bazel skylibs' BuildSettingInfo provider.
+type BuildSetting struct {
+	value any
+}
+
+func (bs *BuildSetting) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("unmarshaling build setting: %w", err)
+	}
+
+	// upcast float to int if possible
+	if f, ok := value.(float64); ok && f == float64(int(f)) {
+		value = int(f)
+	}
+	bs.value = value
+
+	switch v := value.(type) {
+	case string, int, bool, []string:
+		// Supported types
+	default:
+		return fmt.Errorf("unsupported build setting type: %v of type %T", value, v)
+	}
+
+	return nil
+}
+
+func (bs *BuildSetting) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bs.value)
+}
+
+// Data holds the variables available to templates.
+// Stamp variables are read first, so build settings take precedence.
+type Data map[string]BuildSetting
+
+// NewData creates template data from stamp files and build settings.
+func NewData(stampFiles []string, buildSettings map[string]BuildSetting) (Data, error) {
+	data := make(Data)
+	for _, stampFile := range stampFiles {
+		if err := readStampFile(stampFile, data); err != nil {
+			return nil, fmt.Errorf("reading stamp file %s: %w", stampFile, err)
+		}
+	}
+	for k, v := range buildSettings {
+		data[k] = v
+	}
+	return data, nil
+}
+
+func (d Data) asTemplateData() map[string]any {
+	data := make(map[string]any, len(d))
+	for k, v := range d {
+		data[k] = v.value
+	}
+	return data
+}
+
+// ExpandRequest expands all templates of the request.
+// Values may be strings, lists of strings, or maps of strings.
+func ExpandRequest(request Request, stampFiles []string) (map[string]json.RawMessage, error) {
+	data, err := NewData(stampFiles, request.BuildSettings)
+	if err != nil {
+		return nil, err
+	}
+	templateData := data.asTemplateData()
+	output := make(map[string]json.RawMessage, len(request.Templates))
+
+	for key, rawValue := range request.Templates {
+		var valueStr string
+		if err := json.Unmarshal(rawValue, &valueStr); err == nil {
+			// Single string template
+			expanded, err := Expand(valueStr, templateData)
+			if err != nil {
+				return nil, fmt.Errorf("expanding template for key %q: %w", key, err)
+			}
+			output[key] = json.RawMessage(fmt.Sprintf("%q", expanded))
+			continue
+		}
+
+		var valueList []string
+		if err := json.Unmarshal(rawValue, &valueList); err == nil {
+			// List of strings template
+			expandedList := make([]string, len(valueList))
+			for i, v := range valueList {
+				expanded, err := Expand(v, templateData)
+				if err != nil {
+					return nil, fmt.Errorf("expanding template for key %q index %d: %w", key, i, err)
+				}
+				expandedList[i] = expanded
+			}
+
+			if slices.Contains(listKeysWithSetSemantics, key) {
+				expandedList = NormalizeList(expandedList)
+			}
+
+			marshaledList, err := json.Marshal(expandedList)
+			if err != nil {
+				return nil, fmt.Errorf("marshaling expanded list for key %q: %w", key, err)
+			}
+			output[key] = json.RawMessage(marshaledList)
+			continue
+		}
+
+		var valueMap map[string]string
+		if err := json.Unmarshal(rawValue, &valueMap); err == nil {
+			// Map of string to string template
+			expandedMap := make(map[string]string)
+			for k, v := range valueMap {
+				expanded, err := Expand(v, templateData)
+				if err != nil {
+					return nil, fmt.Errorf("expanding template for key %q map key %q: %w", key, k, err)
+				}
+				expandedMap[k] = expanded
+			}
+
+			marshaledMap, err := json.Marshal(expandedMap)
+			if err != nil {
+				return nil, fmt.Errorf("marshaling expanded map for key %q: %w", key, err)
+			}
+			output[key] = json.RawMessage(marshaledMap)
+			continue
+		}
+
+		return nil, fmt.Errorf("template value for key %q is neither a string, list of strings, nor map of strings", key)
+	}
+
+	return output, nil
+}
+
+// Expand expands a single Go template.
+func Expand(tmplStr string, data map[string]any) (string, error) {
+	if tmplStr == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New("expand").Parse(tmplStr)
+	if err != nil {
+		return "", fmt.Errorf("parsing template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// NormalizeList sorts the values and removes empty values and duplicates.
+// Templates commonly expand to an empty string to disable an entry (e.g. a conditional tag).
+func NormalizeList(values []string) []string {
+	normalized := slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// readStampFile reads a Bazel stamp file and adds key-value pairs to the data map
+func readStampFile(path string, data Data) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening stamp file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split on first space to get key and value
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 {
+			key := parts[0]
+			value := parts[1]
+			// always interpret as string
+			data[key] = BuildSetting{value: value}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stamp file: %w", err)
+	}
+
+	return nil
+}
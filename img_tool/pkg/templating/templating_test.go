@@ -0,0 +1,111 @@
+package templating
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandRequest(t *testing.T) {
+	stampFile := filepath.Join(t.TempDir(), "stable-status.txt")
+	if err := os.WriteFile(stampFile, []byte("# comment\nSTABLE_GIT_COMMIT abc123\nBUILD_USER ci\nregistry from-stamp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var request Request
+	if err := json.Unmarshal([]byte(`{
+		"build_settings": {"registry": "ghcr.io", "release": true},
+		"templates": {
+			"registry": "{{.registry}}",
+			"tags": ["{{.STABLE_GIT_COMMIT}}", "latest", "{{if not .release}}dev{{end}}", "latest"],
+			"labels": {"commit": "{{.STABLE_GIT_COMMIT}}", "user": "{{.BUILD_USER}}"},
+			"empty": ""
+		}
+	}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExpandRequest(request, []string{stampFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		// build settings take precedence over stamp variables
+		"registry": `"ghcr.io"`,
+		// tags are sorted, deduplicated and empty tags are dropped
+		"tags":   `["abc123","latest"]`,
+		"labels": `{"commit":"abc123","user":"ci"}`,
+		"empty":  `""`,
+	}
+	if len(got) != len(want) {
+		t.Errorf("ExpandRequest() returned %d keys, want %d", len(got), len(want))
+	}
+	for key, value := range want {
+		if string(got[key]) != value {
+			t.Errorf("ExpandRequest()[%q] = %s, want %s", key, got[key], value)
+		}
+	}
+}
+
+func TestExpandRequestErrors(t *testing.T) {
+	tests := []struct {
+		request string
+		wantErr string
+	}{
+		{`{"templates": {"tag": "{{.missing"}}`, `expanding template for key "tag"`},
+		{`{"templates": {"tag": 1}}`, "neither a string, list of strings, nor map of strings"},
+		{`{"templates": {"tags": ["{{template \"undefined\"}}"]}}`, `key "tags" index 0`},
+	}
+	for _, tt := range tests {
+		var request Request
+		if err := json.Unmarshal([]byte(tt.request), &request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExpandRequest(request, nil); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ExpandRequest(%s) error = %v, want %q", tt.request, err, tt.wantErr)
+		}
+	}
+
+	var request Request
+	if err := json.Unmarshal([]byte(`{"build_settings": {"nested": {"a": 1}}}`), &request); err == nil {
+		t.Error("unmarshaling a request with a map build setting succeeded")
+	}
+	if _, err := ExpandRequest(Request{}, []string{filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("ExpandRequest() with a missing stamp file succeeded")
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"registry": "ghcr.io", "repository": "", "tags": ["v1", "", "latest", "v1"], "daemon": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := config.RequiredString("registry"); err != nil || got != "ghcr.io" {
+		t.Errorf(`RequiredString("registry") = %q, %v`, got, err)
+	}
+	if _, err := config.RequiredString("repository"); err == nil {
+		t.Error(`RequiredString("repository") of an empty value succeeded`)
+	}
+	if got, err := config.String("missing"); err != nil || got != "" {
+		t.Errorf(`String("missing") = %q, %v, want the empty string`, got, err)
+	}
+	if _, err := config.String("daemon"); err == nil {
+		t.Error(`String("daemon") of a number succeeded`)
+	}
+	tags, err := config.StringList("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, ",") != "latest,v1" {
+		t.Errorf(`StringList("tags") = %q, want [latest v1]`, tags)
+	}
+	if _, err := config.StringList("registry"); err == nil {
+		t.Error(`StringList("registry") of a string succeeded`)
+	}
+}
@@ -0,0 +1,105 @@
+// Package filter implements content filters that transform files
+// while they are recorded into a layer.
+//
+// Filters are opt-in and allow fixing reproducibility issues
+// (like embedded timestamps) of files produced by other rules
+// without changing those rules.
+package filter
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Filter transforms entries while they are stored in a layer.
+type Filter interface {
+	// Match reports whether the filter wants to handle the entry.
+	// hdr.Name is the path of the entry in the image.
+	// Entries that are not matched are stored without being read into memory.
+	Match(hdr *tar.Header) bool
+	// Apply returns the new content of a matched entry.
+	// content is nil for entries that are not regular files.
+	// Apply may modify hdr. Returning keep == false drops the entry from the layer.
+	Apply(hdr *tar.Header, content []byte) (newContent []byte, keep bool, err error)
+}
+
+// StreamFilter is a Filter for large files, which transforms regular files
+// without holding them in memory (see Pipeline.ApplyStream).
+type StreamFilter interface {
+	Filter
+	// ApplyStream writes the new content of a matched regular file to w.
+	// content holds the size bytes of the current content.
+	// ApplyStream may modify hdr, except for hdr.Size. Returning keep == false drops the entry from the layer.
+	ApplyStream(hdr *tar.Header, content io.ReaderAt, size int64, w io.Writer) (keep bool, err error)
+}
+
+// Pipeline is an ordered list of filters.
+// The output of a filter is the input of the next matching filter.
+type Pipeline []Filter
+
+// Matches reports whether any filter of the pipeline wants to handle the entry.
+func (p Pipeline) Matches(hdr *tar.Header) bool {
+	for _, f := range p {
+		if f.Match(hdr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs the entry through all matching filters in order.
+// For regular files, hdr.Size is updated to the size of the returned content.
+func (p Pipeline) Apply(hdr *tar.Header, content []byte) ([]byte, bool, error) {
+	for _, f := range p {
+		if !f.Match(hdr) {
+			continue
+		}
+		var keep bool
+		var err error
+		content, keep, err = f.Apply(hdr, content)
+		if err != nil {
+			return nil, false, fmt.Errorf("filtering %s: %w", hdr.Name, err)
+		}
+		if !keep {
+			return nil, false, nil
+		}
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		hdr.Size = int64(len(content))
+	}
+	return content, true, nil
+}
+
+// builtins maps the names of built-in filters to their constructors.
+// The argument is the part of the filter spec after "=" (or empty).
+var builtins = map[string]func(arg string) (Filter, error){
+	"jar": newJar,
+	"pyc": newPyc,
+}
+
+// Names returns the names of all built-in filters.
+func Names() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a built-in filter from a spec of the form name[=arg].
+func New(spec string) (Filter, error) {
+	name, arg, _ := strings.Cut(spec, "=")
+	constructor, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	f, err := constructor(arg)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", name, err)
+	}
+	return f, nil
+}
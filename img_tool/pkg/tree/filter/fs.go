@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"archive/tar"
+	"errors"
+	"io/fs"
+	"path"
+)
+
+// FS returns a view of fsys with the pipeline applied.
+// This is used for directory trees that are stored as a whole.
+// prefix is the path of the tree in the image and is
+// prepended to the names passed to the filters.
+func (p Pipeline) FS(fsys fs.FS, prefix string) fs.FS {
+	if len(p) == 0 {
+		return fsys
+	}
+	return &filteredFS{fsys: fsys, pipeline: p, prefix: prefix}
+}
+
+type filteredFS struct {
+	fsys     fs.FS
+	pipeline Pipeline
+	prefix   string
+}
+
+func (f *filteredFS) Open(name string) (fs.File, error) {
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	content, info, keep, err := f.filter(name, info)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !keep {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if content != nil {
+		return &filteredFile{Content: content, info: info}, nil
+	}
+	return f.fsys.Open(name)
+}
+
+// ReadLink returns the target of a symlink if the underlying file system keeps symlinks.
+func (f *filteredFS) ReadLink(name string) (string, error) {
+	linkFS, ok := f.fsys.(interface {
+		ReadLink(name string) (string, error)
+	})
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+	}
+	return linkFS.ReadLink(name)
+}
+
+func (f *filteredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		content, newInfo, keep, err := f.filter(path.Join(name, entry.Name()), info)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		if !keep {
+			continue
+		}
+		if content != nil {
+			content.Close()
+			entry = fs.FileInfoToDirEntry(newInfo)
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered, nil
+}
+
+// filter applies the pipeline to a single entry of the tree.
+// content is only non-nil for regular files that were matched by a filter, and must be closed.
+func (f *filteredFS) filter(name string, info fs.FileInfo) (content *Content, newInfo fs.FileInfo, keep bool, err error) {
+	if name == "." {
+		return nil, info, true, nil
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(f.prefix, name),
+		Size:     info.Size(),
+		Mode:     0o755,
+	}
+	if info.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	} else if info.Mode()&fs.ModeSymlink != 0 {
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Size = 0
+	}
+	if !f.pipeline.Matches(hdr) {
+		return nil, info, true, nil
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		_, keep, err = f.pipeline.Apply(hdr, nil)
+		if err != nil || !keep {
+			return nil, nil, keep, err
+		}
+		return nil, info, true, nil
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer file.Close()
+	content, keep, err = f.pipeline.ApplyStream(hdr, file)
+	if err != nil || !keep {
+		return nil, nil, keep, err
+	}
+	return content, sizedFileInfo{FileInfo: info, size: hdr.Size}, true, nil
+}
+
+// filteredFile is a filtered regular file.
+type filteredFile struct {
+	*Content
+	info fs.FileInfo
+}
+
+func (f *filteredFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// sizedFileInfo reports the size of the filtered content.
+type sizedFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (s sizedFileInfo) Size() int64 { return s.size }
@@ -0,0 +1,184 @@
+package filter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultJarTimestamp is the modification time of normalized zip entries.
+// It matches the timestamp Bazel uses for the jars it builds.
+var defaultJarTimestamp = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Extra fields of zip entries that store timestamps or the owner of a file,
+// and the zip64 field, which the writer adds again if needed.
+const (
+	zipExtraZip64       = 0x0001
+	zipExtraNTFS        = 0x000a
+	zipExtraExtTime     = 0x5455
+	zipExtraInfoZipUnix = 0x5855
+	zipExtraUnixOwner   = 0x7875
+)
+
+// jarFilter makes zip archives (jar, war, ear) reproducible.
+//
+// Tools that create jars usually store the current time as modification
+// time of every entry, and some store entries in the order in which they
+// were produced. Both differ between builds, so the jar changes whenever
+// it is rebuilt, even if its contents are the same.
+// The filter rewrites every entry with a fixed modification time,
+// removes extra fields with timestamps or file owners and sorts the entries by name.
+// The (compressed) data of the entries is copied as-is, without recompression.
+// Jars can be large, so the filter is a StreamFilter that doesn't hold them in memory.
+type jarFilter struct {
+	timestamp time.Time
+}
+
+func newJar(arg string) (Filter, error) {
+	if arg == "" {
+		return jarFilter{timestamp: defaultJarTimestamp}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q (expected RFC 3339, like %q): %w", arg, defaultJarTimestamp.Format(time.RFC3339), err)
+	}
+	// zip stores times with a resolution of two seconds from 1980 onwards.
+	if timestamp.Year() < 1980 {
+		return nil, fmt.Errorf("timestamp %s is before 1980, which cannot be stored in zip files", arg)
+	}
+	return jarFilter{timestamp: timestamp}, nil
+}
+
+func (f jarFilter) Match(hdr *tar.Header) bool {
+	if hdr.Typeflag != tar.TypeReg {
+		return false
+	}
+	switch {
+	case strings.HasSuffix(hdr.Name, ".jar"), strings.HasSuffix(hdr.Name, ".war"), strings.HasSuffix(hdr.Name, ".ear"):
+		return true
+	}
+	return false
+}
+
+func (f jarFilter) Apply(hdr *tar.Header, content []byte) ([]byte, bool, error) {
+	var out bytes.Buffer
+	out.Grow(len(content))
+	if err := normalizeZip(bytes.NewReader(content), int64(len(content)), &out, f.timestamp); err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), true, nil
+}
+
+func (f jarFilter) ApplyStream(hdr *tar.Header, content io.ReaderAt, size int64, w io.Writer) (bool, error) {
+	return true, normalizeZip(content, size, w, f.timestamp)
+}
+
+// normalizeZip writes the zip archive in content to w with stable entry order and timestamps.
+// Content that is not a zip archive is copied unchanged.
+func normalizeZip(content io.ReaderAt, size int64, w io.Writer, timestamp time.Time) error {
+	reader, err := zip.NewReader(content, size)
+	if err != nil {
+		_, err := io.Copy(w, io.NewSectionReader(content, 0, size))
+		return err
+	}
+	entries := make([]*zip.File, len(reader.File))
+	copy(entries, reader.File)
+	sort.SliceStable(entries, func(i, j int) bool {
+		rankI, rankJ := jarEntryRank(entries[i].Name), jarEntryRank(entries[j].Name)
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	writer := zip.NewWriter(w)
+	for _, entry := range entries {
+		if err := copyZipEntry(writer, entry, timestamp); err != nil {
+			return fmt.Errorf("rewriting zip entry %s: %w", entry.Name, err)
+		}
+	}
+	if err := writer.SetComment(reader.Comment); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// jarEntryRank keeps the manifest at the front of the archive,
+// where java.util.jar.JarInputStream expects it.
+func jarEntryRank(name string) int {
+	switch name {
+	case "META-INF/":
+		return 0
+	case "META-INF/MANIFEST.MF":
+		return 1
+	}
+	return 2
+}
+
+// copyZipEntry streams the raw (compressed) data of entry into writer
+// with a normalized header.
+func copyZipEntry(writer *zip.Writer, entry *zip.File, timestamp time.Time) error {
+	raw, err := entry.OpenRaw()
+	if err != nil {
+		return err
+	}
+	// CreateRaw ignores Modified, so the MS-DOS fields are set directly.
+	modifiedDate, modifiedTime := msDosTime(timestamp)
+	header := &zip.FileHeader{
+		Name:               entry.Name,
+		Comment:            entry.Comment,
+		NonUTF8:            entry.NonUTF8,
+		CreatorVersion:     entry.CreatorVersion,
+		ReaderVersion:      entry.ReaderVersion,
+		Flags:              entry.Flags,
+		Method:             entry.Method,
+		ModifiedDate:       modifiedDate,
+		ModifiedTime:       modifiedTime,
+		CRC32:              entry.CRC32,
+		CompressedSize64:   entry.CompressedSize64,
+		UncompressedSize64: entry.UncompressedSize64,
+		Extra:              stripTimestampExtras(entry.Extra),
+		ExternalAttrs:      entry.ExternalAttrs,
+	}
+	w, err := writer.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, raw)
+	return err
+}
+
+// stripTimestampExtras removes the extra fields that store timestamps or file owners
+// and the zip64 field.
+// Other extra fields (like the jar marker 0xcafe) are kept.
+func stripTimestampExtras(extra []byte) []byte {
+	var kept []byte
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			// malformed field: keep the rest unchanged
+			break
+		}
+		switch id {
+		case zipExtraZip64, zipExtraNTFS, zipExtraExtTime, zipExtraInfoZipUnix, zipExtraUnixOwner:
+		default:
+			kept = append(kept, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return append(kept, extra...)
+}
+
+// msDosTime returns the MS-DOS date and time used in zip headers.
+func msDosTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pycMode selects how the pyc filter handles Python bytecode.
+type pycMode string
+
+const (
+	// pycNormalize rewrites the source mtime embedded in timestamp-based pyc files
+	// to the mtime of the pyc file in the layer.
+	pycNormalize pycMode = "normalize"
+	// pycDrop removes pyc files and __pycache__ directories from the layer.
+	// Python regenerates (or skips) the bytecode at runtime.
+	pycDrop pycMode = "drop"
+)
+
+// pythonPEP552Magic is the first magic number using the 16 byte pyc header
+// of PEP 552 (Python 3.7).
+const pythonPEP552Magic = 3392
+
+// pycFilter makes Python bytecode reproducible.
+//
+// Timestamp-based pyc files embed the mtime of the source file
+// at the time of compilation, which differs between builds.
+// Within a layer, files have a fixed mtime (the Unix epoch, unless
+// set via metadata), so setting the embedded mtime to that value
+// makes the pyc file reproducible while keeping it valid for the source file.
+// Hash-based pyc files (PEP 552) are already reproducible and left unchanged.
+type pycFilter struct {
+	mode pycMode
+}
+
+func newPyc(arg string) (Filter, error) {
+	switch mode := pycMode(arg); mode {
+	case "":
+		return pycFilter{mode: pycNormalize}, nil
+	case pycNormalize, pycDrop:
+		return pycFilter{mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("unknown mode %q (expected %q or %q)", arg, pycNormalize, pycDrop)
+	}
+}
+
+func (f pycFilter) Match(hdr *tar.Header) bool {
+	if f.mode == pycDrop && inPycache(hdr.Name) {
+		return true
+	}
+	return hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".pyc")
+}
+
+func (f pycFilter) Apply(hdr *tar.Header, content []byte) ([]byte, bool, error) {
+	if f.mode == pycDrop {
+		return nil, false, nil
+	}
+	return normalizePyc(content, hdr), true, nil
+}
+
+// normalizePyc returns a copy of content with the source mtime of the pyc header
+// set to the mtime of hdr. Content that does not look like a timestamp-based
+// pyc file is returned unchanged.
+func normalizePyc(content []byte, hdr *tar.Header) []byte {
+	if len(content) < 8 || content[2] != '\r' || content[3] != '\n' {
+		return content
+	}
+	var mtime uint32
+	if !hdr.ModTime.IsZero() {
+		// Python compares the lower 32 bits of the source mtime.
+		mtime = uint32(hdr.ModTime.Unix())
+	}
+
+	// Layout of the pyc header:
+	//   Python < 3.7:  magic (4) | mtime (4) [| source size (4)]
+	//   Python >= 3.7: magic (4) | flags (4) | mtime (4) | source size (4)
+	// The magic numbers of Python 2 are larger than those of Python 3.
+	mtimeOffset := 4
+	magic := binary.LittleEndian.Uint16(content[0:2])
+	if magic >= pythonPEP552Magic && magic < 20000 {
+		if len(content) < 16 {
+			return content
+		}
+		if flags := binary.LittleEndian.Uint32(content[4:8]); flags != 0 {
+			// hash-based pyc
+			return content
+		}
+		mtimeOffset = 8
+	}
+	normalized := make([]byte, len(content))
+	copy(normalized, content)
+	binary.LittleEndian.PutUint32(normalized[mtimeOffset:mtimeOffset+4], mtime)
+	return normalized
+}
+
+// inPycache reports whether name is a __pycache__ directory or inside one.
+func inPycache(name string) bool {
+	for _, component := range strings.Split(path.Clean(name), "/") {
+		if component == "__pycache__" {
+			return true
+		}
+	}
+	return false
+}
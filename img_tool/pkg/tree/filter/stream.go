@@ -0,0 +1,161 @@
+package filter
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ApplyStream runs a regular file through all matching filters in order, like Apply,
+// and returns the new content. hdr.Size is updated to the size of the returned content.
+//
+// Stream filters read and write the content through temporary files,
+// so that large files (like jars) are not held in memory. Other filters get the content in memory.
+// The caller must close the returned content, which removes its temporary file.
+func (p Pipeline) ApplyStream(hdr *tar.Header, content io.Reader) (*Content, bool, error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return nil, false, fmt.Errorf("filtering %s: content of a non-regular file", hdr.Name)
+	}
+	c := &Content{source: content, size: hdr.Size}
+	for _, f := range p {
+		if !f.Match(hdr) {
+			continue
+		}
+		keep, err := c.apply(f, hdr)
+		if err != nil {
+			c.Close()
+			return nil, false, fmt.Errorf("filtering %s: %w", hdr.Name, err)
+		}
+		if !keep {
+			c.Close()
+			return nil, false, nil
+		}
+	}
+	hdr.Size = c.size
+	switch {
+	case c.file != nil:
+		c.reader = io.NewSectionReader(c.file, 0, c.size)
+	case c.data != nil:
+		c.reader = bytes.NewReader(c.data)
+	default:
+		c.reader = c.source
+	}
+	return c, true, nil
+}
+
+// Content is the filtered content of a regular file.
+// It is held in memory or, after a stream filter, in a temporary file.
+type Content struct {
+	// source is the unfiltered content, until a filter replaced it with data or file.
+	source io.Reader
+	data   []byte
+	file   *os.File
+	size   int64
+	reader io.Reader
+}
+
+// Read reads the filtered content.
+func (c *Content) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Size returns the size of the filtered content.
+func (c *Content) Size() int64 {
+	return c.size
+}
+
+// Close removes the temporary file of the content.
+func (c *Content) Close() error {
+	return c.replace(nil, nil, 0)
+}
+
+// apply runs a single filter on the content.
+func (c *Content) apply(f Filter, hdr *tar.Header) (bool, error) {
+	streamFilter, ok := f.(StreamFilter)
+	if !ok {
+		data, err := c.bytes()
+		if err != nil {
+			return false, err
+		}
+		data, keep, err := f.Apply(hdr, data)
+		if err != nil || !keep {
+			return keep, err
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		return true, c.replace(data, nil, int64(len(data)))
+	}
+
+	src, err := c.readerAt()
+	if err != nil {
+		return false, err
+	}
+	out, err := os.CreateTemp("", "img-filter-*")
+	if err != nil {
+		return false, err
+	}
+	keep, err := streamFilter.ApplyStream(hdr, src, c.size, out)
+	if err == nil && keep {
+		var size int64
+		if size, err = out.Seek(0, io.SeekCurrent); err == nil {
+			return true, c.replace(nil, out, size)
+		}
+	}
+	out.Close()
+	os.Remove(out.Name())
+	return keep, err
+}
+
+// bytes returns the content in memory.
+func (c *Content) bytes() ([]byte, error) {
+	switch {
+	case c.data != nil:
+		return c.data, nil
+	case c.file != nil:
+		return io.ReadAll(io.NewSectionReader(c.file, 0, c.size))
+	default:
+		return io.ReadAll(c.source)
+	}
+}
+
+// readerAt returns the content for random access.
+// Unfiltered content that doesn't support random access (like a tar entry) is copied to a temporary file.
+func (c *Content) readerAt() (io.ReaderAt, error) {
+	switch {
+	case c.data != nil:
+		return bytes.NewReader(c.data), nil
+	case c.file != nil:
+		return c.file, nil
+	}
+	if file, ok := c.source.(*os.File); ok {
+		if offset, err := file.Seek(0, io.SeekCurrent); err == nil && offset == 0 {
+			return file, nil
+		}
+	}
+	spool, err := os.CreateTemp("", "img-filter-*")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(spool, c.source)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	return spool, c.replace(nil, spool, size)
+}
+
+// replace releases the current content and holds the new content instead.
+func (c *Content) replace(data []byte, file *os.File, size int64) error {
+	var err error
+	if c.file != nil && c.file != file {
+		err = errors.Join(c.file.Close(), os.Remove(c.file.Name()))
+	}
+	c.source = nil
+	c.data, c.file, c.size = data, file, size
+	return err
+}
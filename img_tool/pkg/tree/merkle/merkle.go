@@ -1 +1,11 @@
 package merkle
+
+import "io/fs"
+
+// ReadLinkFS is implemented by file systems that list symlinks as symlinks
+// (instead of following them). Trees of such file systems contain symlink nodes.
+type ReadLinkFS interface {
+	fs.FS
+	// ReadLink returns the target of the symlink name.
+	ReadLink(name string) (string, error)
+}
@@ -26,6 +26,11 @@ type treeHasher struct {
 	// relative to the tree root.
 	dirNodes map[string]DirectoryNode
 
+	// symlinkNodes is a map of symlink nodes,
+	// where the key is the path of the symlink
+	// relative to the tree root.
+	symlinkNodes map[string]SymlinkNode
+
 	// unfinishedFileNodes is a map of unfinished directory nodes.
 	// The key is the path of the directory
 	// relative to the tree root.
@@ -45,6 +50,7 @@ func NewTreeHasher(fsys fs.FS, newHash func() hash.Hash) *treeHasher {
 		newHash:              newHash,
 		fileNodes:            make(map[string]FileNode),
 		dirNodes:             make(map[string]DirectoryNode),
+		symlinkNodes:         make(map[string]SymlinkNode),
 		unfinishedDirByLevel: make(map[int]map[string][]fs.DirEntry),
 	}
 }
@@ -60,6 +66,7 @@ func (t *treeHasher) Build() ([]byte, error) {
 	// between calls (if we believe that they are not stale).
 	clear(t.fileNodes)
 	clear(t.dirNodes)
+	clear(t.symlinkNodes)
 	clear(t.unfinishedDirByLevel)
 	t.rootChildren = nil
 
@@ -75,37 +82,11 @@ func (t *treeHasher) Build() ([]byte, error) {
 	}
 	for l := maxLevel; l > 0; l-- {
 		for p, children := range t.unfinishedDirByLevel[l] {
-			var files []FileNode
-			var dirs []DirectoryNode
-			for _, child := range children {
-				if child.Type().IsRegular() {
-					fileNode := t.fileNodes[path.Join(p, child.Name())]
-					files = append(files, fileNode)
-				} else if child.Type().IsDir() {
-					dirNode := t.dirNodes[path.Join(p, child.Name())]
-					dirs = append(dirs, dirNode)
-				} else {
-					return nil, fmt.Errorf("unsupported file type %v", child.Type().String())
-				}
-			}
-			if len(files) == 0 && len(dirs) == 0 {
-				// This is an empty directory, which constitutes a possible correctness issues:
-				// Bazel doesn't track empty directories in tree artifacts correctly,
-				// but actions can still produce them in real filesystems for actions that produce tree artifacts.
-				// Including them in the artifact would be incorrect (Bazel sometimes provies them, i.e. when running locally),
-				// but ignoring them would be incorrect too (maybe the action is producing them on purpose).
-				return nil, fmt.Errorf("empty directory %s in tree artifact", p)
-			}
-			slices.SortFunc(files, func(a, b FileNode) int {
-				return strings.Compare(string(a.Name), string(b.Name))
-			})
-			slices.SortFunc(dirs, func(a, b DirectoryNode) int {
-				return strings.Compare(string(a.Name), string(b.Name))
-			})
-
-			directory := Directory{
-				Files:       files,
-				Directories: dirs,
+			// Empty directories are part of the tree (with an empty Directory),
+			// so that they are stored faithfully.
+			directory, err := t.directory(p, children)
+			if err != nil {
+				return nil, err
 			}
 			dirHash := directory.Hash(t.newHash())
 			dirNode := DirectoryNode{
@@ -118,17 +99,29 @@ func (t *treeHasher) Build() ([]byte, error) {
 	// Now we can build the root directory node.
 	// This is allowed to be empty, to match Bazel's behavior
 	// of ctx.actions.declare_directory.
+	directory, err := t.directory(".", t.rootChildren)
+	if err != nil {
+		return nil, err
+	}
+	return directory.Hash(t.newHash()), nil
+}
+
+// directory returns the directory node of the directory p from the (already collected) nodes of its children.
+func (t *treeHasher) directory(p string, children []fs.DirEntry) (Directory, error) {
 	var files []FileNode
 	var dirs []DirectoryNode
-	for _, child := range t.rootChildren {
-		if child.Type().IsRegular() {
-			fileNode := t.fileNodes[child.Name()]
-			files = append(files, fileNode)
-		} else if child.Type().IsDir() {
-			dirNode := t.dirNodes[child.Name()]
-			dirs = append(dirs, dirNode)
-		} else {
-			return nil, fmt.Errorf("unsupported file type %v", child.Type().String())
+	var symlinks []SymlinkNode
+	for _, child := range children {
+		childPath := path.Join(p, child.Name())
+		switch {
+		case child.Type().IsRegular():
+			files = append(files, t.fileNodes[childPath])
+		case child.Type().IsDir():
+			dirs = append(dirs, t.dirNodes[childPath])
+		case child.Type()&fs.ModeSymlink != 0:
+			symlinks = append(symlinks, t.symlinkNodes[childPath])
+		default:
+			return Directory{}, fmt.Errorf("unsupported file type %v", child.Type().String())
 		}
 	}
 	slices.SortFunc(files, func(a, b FileNode) int {
@@ -137,11 +130,14 @@ func (t *treeHasher) Build() ([]byte, error) {
 	slices.SortFunc(dirs, func(a, b DirectoryNode) int {
 		return strings.Compare(string(a.Name), string(b.Name))
 	})
-	directory := Directory{
+	slices.SortFunc(symlinks, func(a, b SymlinkNode) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	return Directory{
 		Files:       files,
 		Directories: dirs,
-	}
-	return directory.Hash(t.newHash()), nil
+		Symlinks:    symlinks,
+	}, nil
 }
 
 // walkDirCollector is called by fs.WalkDir to collect file and directory nodes.
@@ -178,6 +174,10 @@ func (t *treeHasher) walkdDirCollector(p string, d fs.DirEntry, err error) error
 		// If the entry is a regular file, we need to collect it.
 		return t.collectRegularFile(p, info)
 	}
+	if d.Type()&fs.ModeSymlink != 0 {
+		// Symlinks are only listed by file systems that keep them.
+		return t.collectSymlink(p)
+	}
 	if !d.Type().IsDir() {
 		return fmt.Errorf("collecting %s: unsupported file type %v", p, d.Type().String())
 	}
@@ -197,6 +197,22 @@ func (t *treeHasher) walkdDirCollector(p string, d fs.DirEntry, err error) error
 	return nil
 }
 
+func (t *treeHasher) collectSymlink(p string) error {
+	linkFS, ok := t.fs.(ReadLinkFS)
+	if !ok {
+		return fmt.Errorf("collecting %s: filesystem doesn't support reading symlinks", p)
+	}
+	target, err := linkFS.ReadLink(p)
+	if err != nil {
+		return fmt.Errorf("collecting symlink %s: %w", p, err)
+	}
+	t.symlinkNodes[p] = SymlinkNode{
+		Name:   metadataString(path.Base(p)),
+		Target: metadataString(target),
+	}
+	return nil
+}
+
 func (t *treeHasher) collectRegularFile(p string, i fs.FileInfo) error {
 	if path.Base(p) != i.Name() {
 		// This indicates a symlink which we didn't intend to follow
@@ -0,0 +1,106 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// linkFS is a file system that lists symlinks as symlinks.
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (l linkFS) ReadLink(name string) (string, error) {
+	f, ok := l.MapFS[name]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(f.Data), nil
+}
+
+func TestTreeHasher(t *testing.T) {
+	base := func() fstest.MapFS {
+		return fstest.MapFS{
+			"bin/app":          {Data: []byte("app"), Mode: 0o755},
+			"lib/libfoo.so.1":  {Data: []byte("foo"), Mode: 0o644},
+			"lib/libfoo.so":    {Data: []byte("libfoo.so.1"), Mode: fs.ModeSymlink | 0o777},
+			"share/doc/README": {Data: []byte("readme"), Mode: 0o644},
+		}
+	}
+	baseHash := buildTree(t, linkFS{base()})
+	if again := buildTree(t, linkFS{base()}); !bytes.Equal(baseHash, again) {
+		t.Errorf("hash of the same tree differs: %x != %x", baseHash, again)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(fstest.MapFS)
+	}{
+		{
+			name: "empty directory",
+			modify: func(m fstest.MapFS) {
+				m["var/cache"] = &fstest.MapFile{Mode: fs.ModeDir | 0o755}
+			},
+		},
+		{
+			name: "symlink target",
+			modify: func(m fstest.MapFS) {
+				m["lib/libfoo.so"] = &fstest.MapFile{Data: []byte("./libfoo.so.1"), Mode: fs.ModeSymlink | 0o777}
+			},
+		},
+		{
+			name: "symlink name",
+			modify: func(m fstest.MapFS) {
+				m["lib/libbar.so"] = m["lib/libfoo.so"]
+				delete(m, "lib/libfoo.so")
+			},
+		},
+		{
+			name: "symlink replaced by a file",
+			modify: func(m fstest.MapFS) {
+				m["lib/libfoo.so"] = &fstest.MapFile{Data: []byte("libfoo.so.1"), Mode: 0o644}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base()
+			tt.modify(m)
+			if got := buildTree(t, linkFS{m}); bytes.Equal(got, baseHash) {
+				t.Errorf("hash didn't change: %x", got)
+			}
+		})
+	}
+}
+
+func TestTreeHasherEmptyRoot(t *testing.T) {
+	got := buildTree(t, linkFS{fstest.MapFS{}})
+	want := Directory{}.Hash(sha256.New())
+	if !bytes.Equal(got, want) {
+		t.Errorf("hash of an empty tree = %x, want %x", got, want)
+	}
+}
+
+func TestTreeHasherSymlinkWithoutReadLink(t *testing.T) {
+	// hides the ReadLink method of the file system
+	fsys := struct{ fs.ReadDirFS }{linkFS{fstest.MapFS{
+		"lib/libfoo.so": {Data: []byte("libfoo.so.1"), Mode: fs.ModeSymlink | 0o777},
+	}}}
+	_, err := NewTreeHasher(fsys, sha256.New).Build()
+	if err == nil || !strings.Contains(err.Error(), "filesystem doesn't support reading symlinks") {
+		t.Errorf("Build() error = %v, want an error about reading symlinks", err)
+	}
+}
+
+func buildTree(t *testing.T, fsys fs.FS) []byte {
+	t.Helper()
+	hash, err := NewTreeHasher(fsys, sha256.New).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -59,15 +59,30 @@ func (n DirectoryNode) Fingerprint(h hash.Hash) {
 	n.Hash.Fingerprint(h)
 }
 
+// SymlinkNode is a symlink inside of a tree.
+// The target is stored as is (it is never resolved).
+type SymlinkNode struct {
+	Name   metadataString
+	Target metadataString
+}
+
+func (n SymlinkNode) Fingerprint(h hash.Hash) {
+	h.Write([]byte{SymlinkNodeUUID})
+	n.Name.Fingerprint(h)
+	n.Target.Fingerprint(h)
+}
+
 type Directory struct {
 	Files       metadataSlice[FileNode]
 	Directories metadataSlice[DirectoryNode]
+	Symlinks    metadataSlice[SymlinkNode]
 }
 
 func (n Directory) Hash(h hash.Hash) []byte {
 	h.Write([]byte{DirectryUUID})
 	n.Files.Fingerprint(h)
 	n.Directories.Fingerprint(h)
+	n.Symlinks.Fingerprint(h)
 	return h.Sum(nil)
 }
 
@@ -122,7 +137,7 @@ type fingerprintable interface {
 const (
 	FileNodeUUID      = 0x01
 	DirectoryNodeUUID = 0x02
-	SymlinkNodeUUID   = 0x03 // unused, but reserved for future use
+	SymlinkNodeUUID   = 0x03
 
 	// DirectoryUUID is used to identify an (unnamed) directory
 	// together with the hashes of its children.
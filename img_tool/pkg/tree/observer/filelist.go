@@ -0,0 +1,55 @@
+package observer
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileList writes one line per entry of the layer.
+// Each line contains the type of the entry ("f" for regular files, "d" for directories,
+// "l" for symlinks, "h" for hardlinks and "o" for anything else), a tab and the path of the entry.
+// Regular files that were deduplicated into the CAS of the layer are listed as regular files.
+type fileList struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newFileList(outputPath string) (Observer, error) {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	return &fileList{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (l *fileList) Observe(hdr *tar.Header) error {
+	var kind string
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		kind = "f"
+	case tar.TypeDir:
+		kind = "d"
+	case tar.TypeSymlink:
+		kind = "l"
+	case tar.TypeLink:
+		kind = "h"
+		if strings.HasPrefix(hdr.Linkname, ".cas/") {
+			kind = "f"
+		}
+	default:
+		kind = "o"
+	}
+	_, err := fmt.Fprintf(l.w, "%s\t%s\n", kind, strings.TrimSuffix(hdr.Name, "/"))
+	return err
+}
+
+func (l *fileList) Close() error {
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
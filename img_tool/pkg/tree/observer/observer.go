@@ -0,0 +1,78 @@
+// Package observer implements observers that see every entry of a layer
+// while it is written.
+//
+// Observers produce side outputs (like file lists, search indexes or SBOM inputs)
+// in the same pass that builds the layer, so the produced tar never has to be read again.
+// Tools embedding img can add their own observers using Register.
+package observer
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Observer is notified about the entries of a layer.
+type Observer interface {
+	// Observe is called for every entry in the order the entries are written to the layer.
+	// Deduplicated regular files are reported as hardlinks to their CAS object below ".cas/".
+	// Observe must not modify hdr.
+	Observe(hdr *tar.Header) error
+	// Close is called after the last entry was written.
+	// It finishes the side output.
+	Close() error
+}
+
+// Constructor creates an observer that writes its side output to outputPath.
+type Constructor func(outputPath string) (Observer, error)
+
+var (
+	registryMux sync.Mutex
+	registry    = map[string]Constructor{
+		"filelist": newFileList,
+	}
+)
+
+// Register makes an observer available under name.
+// It panics if an observer with the same name is already registered.
+func Register(name string, constructor Constructor) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("observer %q registered twice", name))
+	}
+	registry[name] = constructor
+}
+
+// Names returns the names of all registered observers.
+func Names() []string {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a registered observer from a spec of the form name=output.
+func New(spec string) (Observer, error) {
+	name, outputPath, ok := strings.Cut(spec, "=")
+	if !ok || outputPath == "" {
+		return nil, fmt.Errorf("invalid observer %q: expected name=output", spec)
+	}
+	registryMux.Lock()
+	constructor, ok := registry[name]
+	registryMux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown observer %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	o, err := constructor(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("observer %s: %w", name, err)
+	}
+	return o, nil
+}
@@ -0,0 +1,107 @@
+package observer
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type countingObserver struct {
+	outputPath string
+	entries    int
+}
+
+func (o *countingObserver) Observe(*tar.Header) error {
+	o.entries++
+	return nil
+}
+
+func (o *countingObserver) Close() error {
+	return os.WriteFile(o.outputPath, []byte{byte('0' + o.entries)}, 0o644)
+}
+
+func TestRegister(t *testing.T) {
+	Register("counting", func(outputPath string) (Observer, error) {
+		return &countingObserver{outputPath: outputPath}, nil
+	})
+	t.Cleanup(func() {
+		registryMux.Lock()
+		defer registryMux.Unlock()
+		delete(registry, "counting")
+	})
+	if names := Names(); !slices.Equal(names, []string{"counting", "filelist"}) {
+		t.Errorf("Names() = %q", names)
+	}
+
+	output := filepath.Join(t.TempDir(), "count")
+	o, err := New("counting=" + output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := o.Observe(&tar.Header{Name: "file"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "3" {
+		t.Errorf("output of registered observer = %q, want 3", data)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering an observer twice did not panic")
+		}
+	}()
+	Register("filelist", newFileList)
+}
+
+func TestNewErrors(t *testing.T) {
+	for spec, want := range map[string]string{
+		"filelist":         `invalid observer "filelist": expected name=output`,
+		"filelist=":        `invalid observer "filelist=": expected name=output`,
+		"unknown=out.txt":  `unknown observer "unknown" (available: `,
+		"filelist=/nodir/": "observer filelist: ",
+	} {
+		if _, err := New(spec); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("New(%q) error = %v, want prefix %q", spec, err, want)
+		}
+	}
+}
+
+func TestFileList(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "files.txt")
+	o, err := New("filelist=" + output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hdr := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir},
+		{Name: ".cas/sha256/abc", Typeflag: tar.TypeReg},
+		{Name: "etc/a.txt", Typeflag: tar.TypeLink, Linkname: ".cas/sha256/abc"},
+		{Name: "etc/b.txt", Typeflag: tar.TypeReg},
+		{Name: "etc/c.txt", Typeflag: tar.TypeLink, Linkname: "etc/b.txt"},
+		{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"},
+		{Name: "dev/null", Typeflag: tar.TypeChar},
+	} {
+		if err := o.Observe(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "d\tetc\nf\t.cas/sha256/abc\nf\tetc/a.txt\nf\tetc/b.txt\nh\tetc/c.txt\nl\tetc/link\no\tdev/null\n"
+	if string(data) != want {
+		t.Errorf("file list = %q, want %q", data, want)
+	}
+}
@@ -13,14 +13,18 @@ import (
 
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/fileopener"
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/filter"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/runfiles"
 	"github.com/bazel-contrib/rules_img/img_tool/pkg/tree/treeartifact"
 )
 
 type Recorder struct {
-	tf          api.TarCAS
-	deduplicate bool
-	metadata    MetadataProvider
+	tf                api.TarCAS
+	deduplicate       bool
+	preserveHardlinks bool
+	metadata          MetadataProvider
+	filters           filter.Pipeline
+	runfilesLayout    RunfilesLayout
 }
 
 // MetadataProvider is an interface for applying metadata to tar headers
@@ -42,7 +46,40 @@ func (r Recorder) WithMetadata(metadata MetadataProvider) Recorder {
 	return r
 }
 
+// WithFilters returns a new Recorder that applies the given content filters
+// to all entries before they are stored.
+func (r Recorder) WithFilters(filters filter.Pipeline) Recorder {
+	r.filters = filters
+	return r
+}
+
+// WithPreservedHardlinks returns a new Recorder that keeps the hardlinks of imported tar files.
+// Regular files that are the target of a hardlink in the tar are written in place instead of
+// being deduplicated into the CAS, so hardlinks keep pointing to their original targets
+// and files that share an inode are never merged with other files of the same content.
+// All other regular files are still deduplicated.
+func (r Recorder) WithPreservedHardlinks() Recorder {
+	r.preserveHardlinks = true
+	return r
+}
+
+// WithRunfilesLayout returns a new Recorder that writes runfiles trees in the given layout.
+// The default is RunfilesClassic.
+func (r Recorder) WithRunfilesLayout(layout RunfilesLayout) Recorder {
+	r.runfilesLayout = layout
+	return r
+}
+
 func (r Recorder) ImportTar(tarFile string) error {
+	var linkTargets map[string]bool
+	if r.preserveHardlinks {
+		var err error
+		linkTargets, err = hardlinkTargets(tarFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	file, err := os.Open(tarFile)
 	if err != nil {
 		return err
@@ -64,14 +101,21 @@ func (r Recorder) ImportTar(tarFile string) error {
 			return err
 		}
 
-		if hdr.Typeflag == tar.TypeReg {
-			var err error
-			if r.deduplicate {
-				err = r.tf.WriteRegularDeduplicated(hdr, tr)
-			} else {
-				err = r.tf.WriteRegular(hdr, tr)
+		entryRecorder := r
+		if hdr.Typeflag == tar.TypeReg && linkTargets[path.Clean(hdr.Name)] {
+			// keep the inode of the hardlink target out of the CAS
+			entryRecorder.deduplicate = false
+		}
+
+		if r.filters.Matches(hdr) {
+			if err := entryRecorder.filteredEntry(hdr, tr); err != nil {
+				return err
 			}
-			if err != nil {
+			continue
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			if err := entryRecorder.writeRegular(hdr, tr); err != nil {
 				return fmt.Errorf("failed to write regular file %s: %w", hdr.Name, err)
 			}
 		} else {
@@ -83,6 +127,35 @@ func (r Recorder) ImportTar(tarFile string) error {
 	return nil
 }
 
+// hardlinkTargets returns the (cleaned) paths of all entries of a tar file that are the target of a hardlink.
+func hardlinkTargets(tarFile string) (map[string]bool, error) {
+	file, err := os.Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	input, err := fileopener.CompressionReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]bool)
+	tr := tar.NewReader(input)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			targets[path.Clean(hdr.Linkname)] = true
+		}
+	}
+}
+
 func (r Recorder) RegularFileFromPath(filePath, target string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -118,6 +191,10 @@ func (r Recorder) RegularFileFromPath(filePath, target string) error {
 		}
 	}
 
+	if r.filters.Matches(hdr) {
+		return r.filteredEntry(hdr, file)
+	}
+
 	// Use optimized path-based methods
 	if r.deduplicate {
 		return r.tf.WriteRegularFromPathDeduplicated(hdr, filePath)
@@ -149,17 +226,39 @@ func (r Recorder) RegularFile(f io.Reader, info fs.FileInfo, target string) erro
 			return fmt.Errorf("applying metadata: %w", err)
 		}
 	}
-	if r.deduplicate {
-		err = r.tf.WriteRegularDeduplicated(hdr, f)
-	} else {
-		err = r.tf.WriteRegular(hdr, f)
+	if r.filters.Matches(hdr) {
+		return r.filteredEntry(hdr, f)
 	}
-	if err != nil {
+	return r.writeRegular(hdr, f)
+}
+
+// filteredEntry runs an entry through the content filters and stores the result.
+func (r Recorder) filteredEntry(hdr *tar.Header, content io.Reader) error {
+	if hdr.Typeflag != tar.TypeReg {
+		_, keep, err := r.filters.Apply(hdr, nil)
+		if err != nil || !keep {
+			return err
+		}
+		return r.tf.WriteHeader(hdr)
+	}
+	filtered, keep, err := r.filters.ApplyStream(hdr, content)
+	if err != nil || !keep {
 		return err
 	}
+	defer filtered.Close()
+	if err := r.writeRegular(hdr, filtered); err != nil {
+		return fmt.Errorf("failed to write regular file %s: %w", hdr.Name, err)
+	}
 	return nil
 }
 
+func (r Recorder) writeRegular(hdr *tar.Header, f io.Reader) error {
+	if r.deduplicate {
+		return r.tf.WriteRegularDeduplicated(hdr, f)
+	}
+	return r.tf.WriteRegular(hdr, f)
+}
+
 func (r Recorder) TreeFromPath(dirPath, target string) error {
 	fsys := treeartifact.TreeArtifactFS(dirPath)
 	return r.Tree(fsys, target)
@@ -168,7 +267,7 @@ func (r Recorder) TreeFromPath(dirPath, target string) error {
 // Tree records a directory tree (including all files and subdirectories).
 // It creates a symlink in the tar file that points to the root of the tree.
 func (r Recorder) Tree(fsys fs.FS, target string) error {
-	linkPath, err := r.tf.StoreTree(fsys)
+	linkPath, err := r.tf.StoreTree(r.filters.FS(fsys, target))
 	if err != nil {
 		return err
 	}
@@ -186,7 +285,15 @@ func (r Recorder) Executable(binaryPath, target string, accessor runfilesSupplie
 	if err := r.RegularFileFromPath(binaryPath, target); err != nil {
 		return err
 	}
-	// Next, record the root directory of the runfiles tree.
+	if err := r.Runfiles(target, accessor); err != nil {
+		return err
+	}
+	return r.solibSymlinks(binaryPath, target, accessor)
+}
+
+// Runfiles records the runfiles tree of the executable at target, without the executable itself.
+func (r Recorder) Runfiles(target string, accessor runfilesSupplier) error {
+	// First, record the root directory of the runfiles tree.
 	runfilesHdr := &tar.Header{
 		Typeflag: tar.TypeDir,
 		Name:     target + ".runfiles/",
@@ -206,8 +313,15 @@ func (r Recorder) Executable(binaryPath, target string, accessor runfilesSupplie
 
 	// Finally, record the contents of the runfiles tree.
 	for p, node := range accessor.Items() {
+		p = r.runfilesLayout.Path(p)
 		switch node.Type() {
 		case api.RegularFile:
+			if r.runfilesLayout == RunfilesSymlinks {
+				if err := r.symlinkedRunfile(node, path.Join(target+".runfiles", p)); err != nil {
+					return err
+				}
+				continue
+			}
 			// Try to use optimized path-based method if available
 			if pathNode, ok := node.(runfiles.PathNode); ok {
 				if err := r.RegularFileFromPath(pathNode.Path(), path.Join(target+".runfiles", p)); err != nil {
@@ -246,6 +360,41 @@ func (r Recorder) Executable(binaryPath, target string, accessor runfilesSupplie
 	return nil
 }
 
+// symlinkedRunfile stores the content of a runfile in the CAS and writes a symlink to it at target.
+// Content filters still apply, but the metadata of the file is not recorded (the symlink has none).
+func (r Recorder) symlinkedRunfile(node runfiles.Node, target string) error {
+	f, err := node.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var content io.Reader = f
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     target,
+		Size:     info.Size(),
+		Mode:     0o755,
+	}
+	if r.filters.Matches(hdr) {
+		filtered, keep, err := r.filters.ApplyStream(hdr, f)
+		if err != nil || !keep {
+			return err
+		}
+		defer filtered.Close()
+		content = filtered
+	}
+	linkPath, _, _, err := r.tf.Store(content)
+	if err != nil {
+		return fmt.Errorf("storing %s: %w", target, err)
+	}
+	return r.Symlink(relativeSymlinkTarget(linkPath, target), target)
+}
+
 func (r Recorder) Symlink(target, linkName string) error {
 	hdr := &tar.Header{
 		Typeflag: tar.TypeSymlink,
@@ -0,0 +1,44 @@
+package tree
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RunfilesLayout is the layout of the runfiles tree of an executable in the image.
+type RunfilesLayout string
+
+const (
+	// RunfilesClassic stores every runfile at <executable>.runfiles/<repo>/<path>.
+	RunfilesClassic RunfilesLayout = "classic"
+	// RunfilesMerged stores the runfiles of all repositories below the main repository
+	// (at <executable>.runfiles/_main/external/<repo>/<path>), for tools that only know the main repository.
+	RunfilesMerged RunfilesLayout = "merged"
+	// RunfilesSymlinks stores every runfile as a symlink to a single copy of its content,
+	// like the runfiles trees that Bazel creates on Linux (--enable_runfiles).
+	RunfilesSymlinks RunfilesLayout = "symlinks"
+)
+
+// ParseRunfilesLayout parses the name of a runfiles layout.
+func ParseRunfilesLayout(name string) (RunfilesLayout, error) {
+	switch layout := RunfilesLayout(name); layout {
+	case RunfilesClassic, RunfilesMerged, RunfilesSymlinks:
+		return layout, nil
+	}
+	return "", fmt.Errorf("unknown runfiles layout %q, supported layouts are %q, %q and %q", name, RunfilesClassic, RunfilesMerged, RunfilesSymlinks)
+}
+
+// Path returns the path of a runfile in the layout.
+// runfilesPath is relative to the root of the runfiles tree and starts with the name of the repository.
+func (l RunfilesLayout) Path(runfilesPath string) string {
+	if l != RunfilesMerged {
+		return runfilesPath
+	}
+	repo, rest, found := strings.Cut(runfilesPath, "/")
+	if !found || repo == "_main" {
+		// files at the root of the runfiles tree (like _repo_mapping) stay where they are
+		return runfilesPath
+	}
+	return path.Join("_main", "external", repo, rest)
+}
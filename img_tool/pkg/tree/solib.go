@@ -0,0 +1,93 @@
+package tree
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// solibSymlinks makes the shared libraries of a dynamically linked executable (like a cc_binary with dynamic deps)
+// resolvable in the image.
+//
+// Bazel stores these libraries in _solib_<cpu> directories of the runfiles tree
+// and adds them to the RUNPATH of the executable relative to its runfiles
+// (like $ORIGIN/server.runfiles/_main/_solib_k8/_U_S_Slib_Cfoo___Ulib).
+// These entries break if the executable has a different name in the image,
+// so a symlink from the expected _solib_<cpu> directory to the one of the runfiles tree is added.
+// Entries that are relative to the output directory of Bazel (instead of the runfiles tree) are ignored.
+func (r Recorder) solibSymlinks(binaryPath, target string, accessor runfilesSupplier) error {
+	solibRoots := make(map[string]bool)
+	for p := range accessor.Items() {
+		if root, _, found := strings.Cut(strings.TrimPrefix(p, "_main/"), "/"); found && strings.HasPrefix(root, "_solib_") {
+			solibRoots[root] = true
+		}
+	}
+	if len(solibRoots) == 0 {
+		return nil
+	}
+
+	searchPaths, err := elfSearchPaths(binaryPath)
+	if err != nil {
+		return fmt.Errorf("reading search paths of shared libraries of %s: %w", binaryPath, err)
+	}
+	written := make(map[string]bool)
+	for _, searchPath := range searchPaths {
+		relative, ok := strings.CutPrefix(searchPath, "$ORIGIN/")
+		if !ok {
+			relative, ok = strings.CutPrefix(searchPath, "${ORIGIN}/")
+		}
+		if !ok {
+			continue
+		}
+		runfilesDir, inRunfiles, found := strings.Cut(relative, ".runfiles/")
+		if !found {
+			continue
+		}
+		repo, solibPath, _ := strings.Cut(inRunfiles, "/")
+		solibRoot, _, _ := strings.Cut(solibPath, "/")
+		if !solibRoots[solibRoot] {
+			continue
+		}
+		linkName := path.Join(path.Dir(target), runfilesDir+".runfiles", repo, solibRoot)
+		linkTarget := path.Join(target+".runfiles", r.runfilesLayout.Path(path.Join("_main", solibRoot)))
+		if linkName == linkTarget || written[linkName] {
+			continue
+		}
+		if err := r.Symlink(relativeSymlinkTarget(linkTarget, linkName), linkName); err != nil {
+			return err
+		}
+		written[linkName] = true
+	}
+	return nil
+}
+
+// elfSearchPaths returns the RUNPATH and RPATH entries of an ELF file.
+// Other files (like scripts or Windows executables) have none.
+func elfSearchPaths(binaryPath string) ([]string, error) {
+	f, err := elf.Open(binaryPath)
+	var formatErr *elf.FormatError
+	if errors.As(err, &formatErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// files that are shorter than an ELF header (like small scripts) fail with io.EOF or io.ErrUnexpectedEOF
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var searchPaths []string
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := f.DynString(tag)
+		if err != nil {
+			// files without a dynamic section (like static binaries)
+			return nil, nil
+		}
+		for _, value := range values {
+			searchPaths = append(searchPaths, strings.Split(value, ":")...)
+		}
+	}
+	return searchPaths, nil
+}
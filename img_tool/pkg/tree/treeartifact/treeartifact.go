@@ -3,12 +3,17 @@ package treeartifact
 import (
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type treeartifactFS string
 
+// TreeArtifactFS returns a file system of the tree artifact (directory) at path.
+// Relative symlinks that point to a path inside of the tree are listed as symlinks (see ReadLink),
+// all other symlinks (like the symlinks of sandboxes) are followed.
 func TreeArtifactFS(path string) treeartifactFS {
 	return treeartifactFS(path)
 }
@@ -50,7 +55,11 @@ func (t treeartifactFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	}
 	for i, entry := range dirents {
 		if entry.Type()&fs.ModeSymlink != 0 {
-			// If the entry is a symlink, we need to
+			if target, err := os.Readlink(filepath.Join(realpath, entry.Name())); err == nil && isInternalSymlink(path.Join(name, entry.Name()), target) {
+				// The symlink is part of the tree artifact and is kept as is.
+				continue
+			}
+			// Otherwise, we need to
 			// resolve it to the real path.
 			realpath, err := filepath.EvalSymlinks(filepath.Join(realpath, entry.Name()))
 			if err != nil {
@@ -69,6 +78,25 @@ func (t treeartifactFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return dirents, nil
 }
 
+// ReadLink returns the target of a symlink inside of the tree artifact.
+func (t treeartifactFS) ReadLink(name string) (string, error) {
+	target, err := os.Readlink(t.join(name))
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// isInternalSymlink reports whether the symlink name (relative to the root of the tree)
+// is relative and points to a path inside of the tree.
+func isInternalSymlink(name, target string) bool {
+	if path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+	resolved := path.Join(path.Dir(name), filepath.ToSlash(target))
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
 func (t treeartifactFS) join(name string) string {
 	return filepath.Join(string(t), name)
 }
@@ -133,3 +161,15 @@ type treeArtifactDirEntry struct {
 func (d *treeArtifactDirEntry) Name() string {
 	return d.name
 }
+
+// Info returns the info of the resolved symlink, with the name of the symlink.
+func (d *treeArtifactDirEntry) Info() (fs.FileInfo, error) {
+	realStat, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return &treeartifactFileInfo{
+		name:     d.name,
+		realStat: realStat,
+	}, nil
+}
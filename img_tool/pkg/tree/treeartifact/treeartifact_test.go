@@ -0,0 +1,123 @@
+package treeartifact
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsInternalSymlink(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{name: "lib/libfoo.so", target: "libfoo.so.1", want: true},
+		{name: "lib/libfoo.so", target: "../bin/app", want: true},
+		{name: "lib/current", target: ".", want: true},
+		{name: "root", target: "..", want: false},
+		{name: "lib/libfoo.so", target: "../../libfoo.so.1", want: false},
+		{name: "lib/libfoo.so", target: "/usr/lib/libfoo.so.1", want: false},
+		{name: "lib/libfoo.so", target: "sub/../../../libfoo.so.1", want: false},
+	}
+	for _, tt := range tests {
+		if got := isInternalSymlink(tt.name, tt.target); got != tt.want {
+			t.Errorf("isInternalSymlink(%q, %q) = %v, want %v", tt.name, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	writeFile(t, outside, "outside")
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "lib", "libfoo.so.1"), "foo")
+	symlink(t, "libfoo.so.1", filepath.Join(root, "lib", "libfoo.so"))
+	symlink(t, outside, filepath.Join(root, "lib", "external.txt"))
+	if err := os.MkdirAll(filepath.Join(root, "var", "cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fsys := TreeArtifactFS(root)
+
+	entries, err := fsys.ReadDir("lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]fs.FileMode)
+	for _, entry := range entries {
+		got[entry.Name()] = entry.Type()
+	}
+	want := map[string]fs.FileMode{
+		// symlinks that leave the tree are followed
+		"external.txt": 0,
+		"libfoo.so":    fs.ModeSymlink,
+		"libfoo.so.1":  0,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadDir(lib) = %v, want %v", got, want)
+	}
+	for name, mode := range want {
+		if got[name] != mode {
+			t.Errorf("type of %s = %v, want %v", name, got[name], mode)
+		}
+	}
+	for _, entry := range entries {
+		if entry.Name() != "external.txt" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "external.txt" || info.Size() != int64(len("outside")) {
+			t.Errorf("Info() of a followed symlink = %s (%d bytes), want external.txt (%d bytes)", info.Name(), info.Size(), len("outside"))
+		}
+	}
+
+	target, err := fsys.ReadLink("lib/libfoo.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "libfoo.so.1" {
+		t.Errorf("ReadLink(lib/libfoo.so) = %q, want %q", target, "libfoo.so.1")
+	}
+	if _, err := fsys.ReadLink("lib/libfoo.so.1"); err == nil {
+		t.Error("ReadLink() of a regular file succeeded, want error")
+	}
+
+	// empty directories are listed
+	entries, err = fsys.ReadDir("var/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ReadDir(var/cache) = %v, want no entries", entries)
+	}
+}
+
+func TestReadDirDanglingSymlink(t *testing.T) {
+	root := t.TempDir()
+	symlink(t, filepath.Join(t.TempDir(), "missing"), filepath.Join(root, "dangling"))
+	if _, err := TreeArtifactFS(root).ReadDir("."); err == nil {
+		t.Error("ReadDir() with a dangling external symlink succeeded, want error")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.Symlink(target, name); err != nil {
+		t.Fatal(err)
+	}
+}
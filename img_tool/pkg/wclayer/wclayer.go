@@ -0,0 +1,158 @@
+// Package wclayer converts layer tar streams to the layout of Windows container layers.
+//
+// Windows container layers store the filesystem below "Files/"
+// and registry hives below "Hives/". Both directories are expected
+// at the root of every layer by the Windows layer importer (hcsshim).
+package wclayer
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/api"
+)
+
+const (
+	// FilesDir is the directory holding the filesystem of the container.
+	FilesDir = "Files"
+	// HivesDir is the directory holding the registry hives of the container.
+	HivesDir = "Hives"
+
+	// fileAttrRecord is the PAX record holding the Windows file attributes.
+	fileAttrRecord = "MSWINDOWS.fileattr"
+	// fileAttributeDirectory is FILE_ATTRIBUTE_DIRECTORY.
+	fileAttributeDirectory = "16"
+	// fileAttributeArchive is FILE_ATTRIBUTE_ARCHIVE, which Docker sets for regular files.
+	fileAttributeArchive = "32"
+)
+
+// Appender rewrites the entries appended to a layer to the Windows layer layout.
+type Appender struct {
+	inner   api.TarAppender
+	started bool
+}
+
+// NewAppender returns a TarAppender that moves every entry below "Files/"
+// before appending it to inner.
+func NewAppender(inner api.TarAppender) *Appender {
+	return &Appender{inner: inner}
+}
+
+// AppendTar rewrites all entries of r and appends them to the inner appender.
+func (a *Appender) AppendTar(r io.Reader) error {
+	if err := a.start(); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar entry: %w", err)
+		}
+		if !rewriteHeader(hdr) {
+			continue
+		}
+		if err := a.appendEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Finalize finalizes the inner appender.
+// Empty layers still contain the "Files" and "Hives" directories.
+func (a *Appender) Finalize() (api.AppenderState, error) {
+	if err := a.start(); err != nil {
+		return api.AppenderState{}, err
+	}
+	return a.inner.Finalize()
+}
+
+// start writes the root directories of the layer once.
+func (a *Appender) start() error {
+	if a.started {
+		return nil
+	}
+	a.started = true
+	for _, dir := range []string{FilesDir, HivesDir} {
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeDir,
+			Name:       dir + "/",
+			Mode:       0o755,
+			PAXRecords: map[string]string{fileAttrRecord: fileAttributeDirectory},
+		}
+		if err := a.appendEntry(hdr, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Appender) appendEntry(hdr *tar.Header, data io.Reader) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing header for %s: %w", hdr.Name, err)
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return a.inner.AppendTar(&buf)
+	}
+	var padding []byte
+	if remainder := hdr.Size % 512; remainder != 0 {
+		padding = make([]byte, 512-remainder)
+	}
+	return a.inner.AppendTar(io.MultiReader(&buf, io.LimitReader(data, hdr.Size), bytes.NewReader(padding)))
+}
+
+// rewriteHeader moves the entry below "Files/" and sets the Windows file attributes.
+// It returns false for the root directory, which is replaced by "Files/" itself.
+func rewriteHeader(hdr *tar.Header) bool {
+	name, ok := filesPath(hdr.Name)
+	if !ok {
+		return false
+	}
+	hdr.Name = name
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		// hardlinks refer to other entries of the archive
+		if linkname, ok := filesPath(hdr.Linkname); ok {
+			hdr.Linkname = linkname
+		}
+	}
+
+	if _, ok := hdr.PAXRecords[fileAttrRecord]; !ok {
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			setPAXRecord(hdr, fileAttrRecord, fileAttributeDirectory)
+		case tar.TypeReg, tar.TypeLink:
+			setPAXRecord(hdr, fileAttrRecord, fileAttributeArchive)
+		}
+	}
+	// Windows has no concept of Unix owners, and PAX records force the PAX format.
+	hdr.Format = tar.FormatPAX
+	return true
+}
+
+// filesPath returns the path of name below "Files/".
+func filesPath(name string) (string, bool) {
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" {
+		return "", false
+	}
+	return FilesDir + cleaned, true
+}
+
+func setPAXRecord(hdr *tar.Header, key, value string) {
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string)
+	}
+	hdr.PAXRecords[key] = value
+}
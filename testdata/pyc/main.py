@@ -0,0 +1,2 @@
+print('hello from rules_img')
+print('hello from rules_img')
@@ -0,0 +1,128 @@
+"""Analysis tests for the compression_level attribute of layer rules."""
+
+load("@bazel_skylib//lib:unittest.bzl", "analysistest", "asserts")
+load("@bazel_skylib//rules:write_file.bzl", "write_file")
+load("@rules_img//img:layer.bzl", "image_layer", "layer_from_tar")
+
+def _compression_level_args_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    actions = [a for a in analysistest.target_actions(env) if a.mnemonic == ctx.attr.mnemonic]
+    asserts.equals(env, 1, len(actions), "number of {} actions".format(ctx.attr.mnemonic))
+    if len(actions) != 1:
+        return analysistest.end(env)
+
+    argv = actions[0].argv
+    level = None
+    for i, arg in enumerate(argv[:-1]):
+        if arg == "--compression-level":
+            level = argv[i + 1]
+    asserts.equals(env, ctx.attr.expected_level or None, level, "--compression-level of the {} action".format(ctx.attr.mnemonic))
+    return analysistest.end(env)
+
+_COMPRESSION_LEVEL_ATTRS = {
+    "expected_level": attr.string(doc = "Expected value of --compression-level, or empty if the flag must not be set."),
+    "mnemonic": attr.string(default = "LayerTar"),
+}
+
+# Analyzes the layer in opt, which defaults to the best compression level of the algorithm.
+compression_level_args_test = analysistest.make(
+    _compression_level_args_test_impl,
+    attrs = _COMPRESSION_LEVEL_ATTRS,
+    config_settings = {
+        "//command_line_option:compilation_mode": "opt",
+    },
+)
+
+# Analyzes the layer with a global compression level setting.
+compression_level_global_args_test = analysistest.make(
+    _compression_level_args_test_impl,
+    attrs = _COMPRESSION_LEVEL_ATTRS,
+    config_settings = {
+        "@rules_img//img/settings:compression_level": "5",
+    },
+)
+
+def _invalid_compression_level_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    asserts.expect_failure(env, "Invalid compression level 9 for zstd")
+    return analysistest.end(env)
+
+invalid_compression_level_test = analysistest.make(
+    _invalid_compression_level_test_impl,
+    expect_failure = True,
+)
+
+def _layer_test(name, suffix, test_rule, expected_level, mnemonic = "LayerTar", from_tar = False, **kwargs):
+    target = "{}_{}".format(name, suffix)
+    if from_tar:
+        layer_from_tar(
+            name = target + "_layer",
+            src = ":" + name + "_tar",
+            tags = ["manual"],
+            **kwargs
+        )
+    else:
+        image_layer(
+            name = target + "_layer",
+            srcs = {"/file.txt": ":" + name + "_file"},
+            tags = ["manual"],
+            **kwargs
+        )
+    test_rule(
+        name = target,
+        target_under_test = ":" + target + "_layer",
+        expected_level = expected_level,
+        mnemonic = mnemonic,
+    )
+    return ":" + target
+
+def compression_level_test_suite(name):
+    """Creates layers with different compression levels and tests the arguments of their actions.
+
+    Args:
+        name: Name of the test suite.
+    """
+    write_file(
+        name = name + "_file",
+        out = name + "_file.txt",
+        content = ["compressed"],
+    )
+
+    # only analyzed, so the content doesn't need to be a valid archive
+    write_file(
+        name = name + "_tar",
+        out = name + ".tar.gz",
+        content = [],
+    )
+
+    tests = [
+        # without the attribute, opt uses the best level of the algorithm
+        _layer_test(name, "gzip_auto", compression_level_args_test, "9", compress = "gzip"),
+        _layer_test(name, "zstd_auto", compression_level_args_test, "4", compress = "zstd"),
+        _layer_test(name, "global_setting", compression_level_global_args_test, "5", compress = "gzip"),
+        _layer_test(name, "gzip_fastest", compression_level_args_test, "1", compress = "gzip", compression_level = "fastest"),
+        _layer_test(name, "zstd_fastest", compression_level_args_test, "1", compress = "zstd", compression_level = "fastest"),
+        _layer_test(name, "gzip_best", compression_level_global_args_test, "9", compress = "gzip", compression_level = "best"),
+        _layer_test(name, "zstd_best", compression_level_global_args_test, "4", compress = "zstd", compression_level = "best"),
+        _layer_test(name, "gzip_level", compression_level_global_args_test, "3", compress = "gzip", compression_level = "3"),
+        _layer_test(name, "gzip_default", compression_level_args_test, "", compress = "gzip", compression_level = "-1"),
+        _layer_test(name, "from_tar", compression_level_args_test, "1", mnemonic = "LayerCompress", from_tar = True, compress = "zstd", compression_level = "fastest"),
+    ]
+
+    image_layer(
+        name = name + "_invalid_layer",
+        srcs = {"/file.txt": ":" + name + "_file"},
+        compress = "zstd",
+        compression_level = "9",
+        tags = ["manual"],
+    )
+    invalid_compression_level_test(
+        name = name + "_invalid",
+        target_under_test = ":" + name + "_invalid_layer",
+    )
+    tests.append(":" + name + "_invalid")
+
+    native.test_suite(
+        name = name,
+        tests = tests,
+    )
@@ -3,13 +3,13 @@ package img_toolchain
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,6 +19,8 @@ import (
 	"testing"
 
 	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"github.com/bazel-contrib/rules_img/img_tool/pkg/tarreader"
 )
 
 type TestCase struct {
@@ -32,6 +34,7 @@ type TestCase struct {
 type SetupSpec struct {
 	Files         map[string]string
 	TestdataFiles map[string]string // Maps destination path -> testdata source path
+	Generated     map[string]int64  // Maps destination path -> size of generated content
 }
 
 type CommandSpec struct {
@@ -112,6 +115,7 @@ func (tf *TestFramework) LoadTestCase(filename string) (*TestCase, error) {
 		Setup: SetupSpec{
 			Files:         make(map[string]string),
 			TestdataFiles: make(map[string]string),
+			Generated:     make(map[string]int64),
 		},
 	}
 
@@ -189,6 +193,17 @@ func (tf *TestFramework) LoadTestCase(filename string) (*TestCase, error) {
 					testCase.Setup.TestdataFiles[destPath] = srcPath
 				}
 			}
+		case "generate":
+			key, value := parseKeyValue(line)
+			if key == "file" {
+				// Format: file = dest_path=size_in_bytes
+				parts := strings.SplitN(value, "=", 2)
+				if len(parts) == 2 {
+					var size int64
+					fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &size)
+					testCase.Setup.Generated[strings.TrimSpace(parts[0])] = size
+				}
+			}
 		case "assert":
 			assertion := parseAssertion(line)
 			if assertion != nil {
@@ -320,6 +335,14 @@ func parseAssertion(line string) *AssertionSpec {
 			assertion.TarEntry = strings.TrimSpace(parts[1])
 			assertion.Mode = strings.TrimSpace(parts[2])
 		}
+	case "tar_entry_linkname":
+		// Format: tar_entry_linkname = tarfile.tar.gz, /path/in/tar, target
+		parts := strings.SplitN(value, ",", 3)
+		if len(parts) == 3 {
+			assertion.Path = strings.TrimSpace(parts[0])
+			assertion.TarEntry = strings.TrimSpace(parts[1])
+			assertion.Content = strings.TrimSpace(parts[2])
+		}
 	case "tar_entry_pax":
 		// Format: tar_entry_pax = tarfile.tar.gz, /path/in/tar, key, "expected_value"
 		parts := strings.SplitN(value, ",", 4)
@@ -369,9 +392,35 @@ func (tf *TestFramework) SetupFiles(setup SetupSpec) error {
 		}
 	}
 
+	// Setup generated files
+	for destPath, size := range setup.Generated {
+		fullPath := filepath.Join(tf.tempDir, destPath)
+		dir := filepath.Dir(fullPath)
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+
+		if err := os.WriteFile(fullPath, generateContent(size), 0644); err != nil {
+			return fmt.Errorf("failed to write generated file %s: %w", fullPath, err)
+		}
+	}
+
 	return nil
 }
 
+// generateContent returns size bytes of numbered lines.
+// The content is the same for every run, but doesn't compress to almost nothing,
+// so it can be used for files that span multiple compression blocks.
+func generateContent(size int64) []byte {
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	for i := 0; int64(buf.Len()) < size; i++ {
+		fmt.Fprintf(&buf, "line %d: %x\n", i, uint32(i)*2654435761)
+	}
+	return buf.Bytes()[:size]
+}
+
 func (tf *TestFramework) RunCommand(ctx context.Context, cmd CommandSpec) (*CommandResult, error) {
 	args := append([]string{cmd.Subcommand}, cmd.Args...)
 	execCmd := exec.CommandContext(ctx, tf.imgBinaryPath, args...)
@@ -463,64 +512,11 @@ func (tf *TestFramework) CheckAssertions(assertions []AssertionSpec, result *Com
 }
 
 // TarEntryInfo holds information about a tar entry
-type TarEntryInfo struct {
-	Header  *tar.Header
-	Content []byte
-}
+type TarEntryInfo = tarreader.Entry
 
-// readTarEntries reads all entries from a tar file (optionally gzipped)
+// readTarEntries reads all entries from a tar file (optionally compressed)
 func (tf *TestFramework) readTarEntries(tarPath string) (map[string]*TarEntryInfo, error) {
-	fullPath := filepath.Join(tf.tempDir, tarPath)
-	file, err := os.Open(fullPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open tar file %s: %w", tarPath, err)
-	}
-	defer file.Close()
-
-	var reader io.Reader = file
-
-	// Try to detect if it's gzipped
-	file.Seek(0, 0)
-	gzHeader := make([]byte, 2)
-	file.Read(gzHeader)
-	file.Seek(0, 0)
-
-	if gzHeader[0] == 0x1f && gzHeader[1] == 0x8b {
-		gzReader, err := gzip.NewReader(file)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
-	}
-
-	tarReader := tar.NewReader(reader)
-	entries := make(map[string]*TarEntryInfo)
-
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading tar: %w", err)
-		}
-
-		var content []byte
-		if header.Typeflag == tar.TypeReg {
-			content, err = io.ReadAll(tarReader)
-			if err != nil {
-				return nil, fmt.Errorf("error reading file content for %s: %w", header.Name, err)
-			}
-		}
-
-		entries[header.Name] = &TarEntryInfo{
-			Header:  header,
-			Content: content,
-		}
-	}
-
-	return entries, nil
+	return tarreader.ReadEntries(filepath.Join(tf.tempDir, tarPath))
 }
 
 func (tf *TestFramework) checkAssertion(assertion AssertionSpec, result *CommandResult) error {
@@ -804,6 +800,18 @@ func (tf *TestFramework) checkAssertion(assertion AssertionSpec, result *Command
 		if actualMode != expectedMode {
 			return fmt.Errorf("tar entry %s mode mismatch: expected %o, got %o", assertion.TarEntry, expectedMode, actualMode)
 		}
+	case "tar_entry_linkname":
+		entries, err := tf.readTarEntries(assertion.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read tar file %s: %w", assertion.Path, err)
+		}
+		entry, exists := entries[assertion.TarEntry]
+		if !exists {
+			return fmt.Errorf("tar entry %s does not exist in %s", assertion.TarEntry, assertion.Path)
+		}
+		if entry.Header.Linkname != assertion.Content {
+			return fmt.Errorf("tar entry %s link target mismatch: expected %s, got %s", assertion.TarEntry, assertion.Content, entry.Header.Linkname)
+		}
 	case "tar_entry_pax":
 		entries, err := tf.readTarEntries(assertion.Path)
 		if err != nil {
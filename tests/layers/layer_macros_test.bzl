@@ -0,0 +1,169 @@
+"""Analysis tests for the macros that split binaries of a language into several layers."""
+
+load("@bazel_skylib//lib:unittest.bzl", "analysistest", "asserts")
+load("@bazel_skylib//rules:write_file.bzl", "write_file")
+load("@rules_img//img:java.bzl", "java_image_layer")
+load("@rules_img//img:layer.bzl", "image_layer")
+load("@rules_img//img:python.bzl", "py_image_layer")
+
+def _flag_values(argv, flag):
+    return [argv[i + 1] for i, arg in enumerate(argv[:-1]) if arg == flag]
+
+def _layer_groups_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    actions = [a for a in analysistest.target_actions(env) if a.mnemonic == "LayerTar"]
+    asserts.equals(env, 1, len(actions), "number of LayerTar actions")
+    if len(actions) != 1:
+        return analysistest.end(env)
+
+    argv = actions[0].argv
+    asserts.equals(env, ctx.attr.expected_groups, _flag_values(argv, "--layer-group"), "--layer-group of the LayerTar action")
+    asserts.equals(env, ctx.attr.expected_excludes, _flag_values(argv, "--layer-group-exclude"), "--layer-group-exclude of the LayerTar action")
+    executables = [arg.removeprefix("--executable=").split("=")[0] for arg in argv if arg.startswith("--executable=")]
+    asserts.equals(env, [ctx.attr.expected_path] if ctx.attr.expected_path else [], executables, "paths of the executables")
+
+    outputs = sorted([f.basename for f in analysistest.target_under_test(env)[DefaultInfo].files.to_list()])
+    for output in ctx.attr.expected_outputs:
+        asserts.true(env, output in outputs, "{} is not an output of the layer: {}".format(output, outputs))
+    return analysistest.end(env)
+
+layer_groups_test = analysistest.make(
+    _layer_groups_test_impl,
+    attrs = {
+        "expected_excludes": attr.string_list(doc = "Expected values of --layer-group-exclude, in order."),
+        "expected_groups": attr.string_list(doc = "Expected values of --layer-group, in order."),
+        "expected_outputs": attr.string_list(doc = "Basenames of files that are (some of the) outputs of the layer."),
+        "expected_path": attr.string(doc = "Expected path of the executable in the image (without the leading slash), or empty if there is no executable."),
+    },
+)
+
+def _invalid_layer_group_excludes_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    asserts.expect_failure(env, "layer_group_excludes refers to \"app\", which is not a group of layer_groups")
+    return analysistest.end(env)
+
+invalid_layer_group_excludes_test = analysistest.make(
+    _invalid_layer_group_excludes_test_impl,
+    expect_failure = True,
+)
+
+def layer_macros_test_suite(name):
+    """Creates layers with the language macros and tests the layer groups of their actions.
+
+    Args:
+        name: Name of the test suite.
+    """
+
+    # only analyzed, so the binary doesn't need to be a real py_binary
+    write_file(
+        name = name + "_binary",
+        out = name + "_binary.py",
+        content = ["print('hello')"],
+        is_executable = True,
+    )
+
+    py_image_layer(
+        name = name + "_py_layer",
+        binary = ":" + name + "_binary",
+        path = "/app/bin/app",
+        tags = ["manual"],
+    )
+    layer_groups_test(
+        name = name + "_py",
+        target_under_test = ":" + name + "_py_layer",
+        expected_groups = [
+            "interpreter=^/app/bin/app\\.runfiles/(rules_python[+~]+python[+~][^/]*)/",
+            "packages=^/app/bin/app\\.runfiles/([^_/][^/]*)/",
+        ],
+        expected_outputs = [
+            name + "_py_layer_interpreter.tgz",
+            name + "_py_layer_packages.tgz",
+        ],
+        expected_path = "app/bin/app",
+    )
+
+    # custom repositories and a relative path
+    py_image_layer(
+        name = name + "_py_repos_layer",
+        binary = ":" + name + "_binary",
+        path = "opt/app.py",
+        interpreter_repos = ["python_3_12"],
+        package_repos = ["pypi_[^/]*", "vendored"],
+        tags = ["manual"],
+    )
+    layer_groups_test(
+        name = name + "_py_repos",
+        target_under_test = ":" + name + "_py_repos_layer",
+        expected_groups = [
+            "interpreter=^/opt/app\\.py\\.runfiles/(python_3_12)/",
+            "packages=^/opt/app\\.py\\.runfiles/(pypi_[^/]*)/",
+            "packages=^/opt/app\\.py\\.runfiles/(vendored)/",
+        ],
+        expected_path = "opt/app.py",
+    )
+
+    java_image_layer(
+        name = name + "_java_layer",
+        binary = ":" + name + "_binary",
+        path = "/app/bin/app",
+        tags = ["manual"],
+    )
+    layer_groups_test(
+        name = name + "_java",
+        target_under_test = ":" + name + "_java_layer",
+        expected_groups = [
+            "jdk=^/app/bin/app\\.runfiles/(rules_java[+~]+toolchains[+~]remotejdk[^/]*)/",
+            "deps=^/app/bin/app\\.runfiles/.*\\.jar$",
+        ],
+        # the jar of the binary itself stays in the main layer
+        expected_excludes = [
+            "deps=^/app/bin/app\\.runfiles/_main/{}/{}_binary\\.jar$".format(native.package_name(), name),
+        ],
+        expected_outputs = [
+            name + "_java_layer_deps.tgz",
+            name + "_java_layer_jdk.tgz",
+        ],
+        expected_path = "app/bin/app",
+    )
+
+    # with the deploy jar, the layer isn't split
+    write_file(
+        name = name + "_binary_deploy",
+        out = name + "_binary_deploy.jar",
+        content = ["deploy jar"],
+    )
+    java_image_layer(
+        name = name + "_java_deploy_jar_layer",
+        binary = ":" + name + "_binary",
+        path = "app.jar",
+        deploy_jar = True,
+        tags = ["manual"],
+    )
+    layer_groups_test(
+        name = name + "_java_deploy_jar",
+        target_under_test = ":" + name + "_java_deploy_jar_layer",
+        expected_outputs = [name + "_java_deploy_jar_layer.tgz"],
+    )
+
+    image_layer(
+        name = name + "_invalid_excludes_layer",
+        srcs = {"/app/bin/app": ":" + name + "_binary"},
+        layer_groups = {"^/app/": "deps"},
+        layer_group_excludes = {"^/app/bin/": "app"},
+        tags = ["manual"],
+    )
+    invalid_layer_group_excludes_test(
+        name = name + "_invalid_excludes",
+        target_under_test = ":" + name + "_invalid_excludes_layer",
+    )
+
+    native.test_suite(
+        name = name,
+        tests = [
+            ":" + name + "_py",
+            ":" + name + "_py_repos",
+            ":" + name + "_java",
+            ":" + name + "_java_deploy_jar",
+            ":" + name + "_invalid_excludes",
+        ],
+    )
@@ -0,0 +1,95 @@
+"""Analysis tests for the default_labels setting of image_manifest."""
+
+load("@bazel_skylib//lib:unittest.bzl", "analysistest", "asserts")
+load("@rules_img//img:image.bzl", "image_empty_base", "image_manifest")
+
+def _default_labels_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    actions = [a for a in analysistest.target_actions(env) if a.mnemonic == "ImageManifest"]
+    asserts.equals(env, 1, len(actions), "number of ImageManifest actions")
+    if len(actions) != 1:
+        return analysistest.end(env)
+
+    argv = actions[0].argv
+    values = [argv[i + 1] for i, arg in enumerate(argv[:-1]) if arg == "--default-labels-file"]
+    if not ctx.attr.expected_file:
+        asserts.equals(env, [], values, "--default-labels-file of the ImageManifest action")
+        return analysistest.end(env)
+    asserts.equals(env, 1, len(values), "number of --default-labels-file flags")
+    if len(values) != 1:
+        return analysistest.end(env)
+    asserts.true(env, values[0].endswith("/" + ctx.attr.expected_file), "--default-labels-file is {}, want {}".format(values[0], ctx.attr.expected_file))
+    inputs = [f.path for f in actions[0].inputs.to_list()]
+    asserts.true(env, values[0] in inputs, "{} is not an input of the ImageManifest action".format(values[0]))
+    return analysistest.end(env)
+
+_DEFAULT_LABELS_ATTRS = {
+    "expected_file": attr.string(doc = "Basename of the expected --default-labels-file, or empty if the flag must not be set."),
+}
+
+# Analyzes the manifest without the setting.
+default_labels_unset_test = analysistest.make(
+    _default_labels_test_impl,
+    attrs = _DEFAULT_LABELS_ATTRS,
+)
+
+# Analyzes the manifest with a default labels file.
+default_labels_test = analysistest.make(
+    _default_labels_test_impl,
+    attrs = _DEFAULT_LABELS_ATTRS,
+    config_settings = {
+        "@rules_img//img/settings:default_labels": str(Label(":default_labels.json")),
+    },
+)
+
+def _too_many_default_labels_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    asserts.expect_failure(env, "default_labels must be a single JSON file, got 2")
+    return analysistest.end(env)
+
+too_many_default_labels_test = analysistest.make(
+    _too_many_default_labels_test_impl,
+    expect_failure = True,
+    config_settings = {
+        "@rules_img//img/settings:default_labels": str(Label(":two_default_labels")),
+    },
+)
+
+def default_labels_test_suite(name):
+    """Creates a manifest and tests the arguments of its action with different default labels.
+
+    Args:
+        name: Name of the test suite.
+    """
+    image_empty_base(
+        name = name + "_base",
+        tags = ["manual"],
+    )
+    image_manifest(
+        name = name + "_manifest",
+        base = ":" + name + "_base",
+        tags = ["manual"],
+    )
+
+    default_labels_unset_test(
+        name = name + "_unset",
+        target_under_test = ":" + name + "_manifest",
+    )
+    default_labels_test(
+        name = name + "_file",
+        target_under_test = ":" + name + "_manifest",
+        expected_file = "default_labels.json",
+    )
+    too_many_default_labels_test(
+        name = name + "_too_many",
+        target_under_test = ":" + name + "_manifest",
+    )
+
+    native.test_suite(
+        name = name,
+        tests = [
+            ":" + name + "_unset",
+            ":" + name + "_file",
+            ":" + name + "_too_many",
+        ],
+    )
@@ -0,0 +1,112 @@
+"""Analysis tests for the variant attribute of image_manifest."""
+
+load("@bazel_skylib//lib:unittest.bzl", "analysistest", "asserts")
+load("@rules_img//img:image.bzl", "image_empty_base", "image_index", "image_manifest")
+load("@rules_img//img:providers.bzl", "ImageManifestInfo")
+
+def _flag_value(argv, flag):
+    values = [argv[i + 1] for i, arg in enumerate(argv[:-1]) if arg == flag]
+    return values[0] if values else None
+
+def _variant_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    manifest = analysistest.target_under_test(env)[ImageManifestInfo]
+    asserts.equals(env, ctx.attr.expected_variant or None, manifest.structured_config.get("variant"), "variant of the structured config")
+
+    actions = [a for a in analysistest.target_actions(env) if a.mnemonic == "ImageManifest"]
+    asserts.equals(env, 1, len(actions), "number of ImageManifest actions")
+    if len(actions) != 1:
+        return analysistest.end(env)
+    argv = actions[0].argv
+    asserts.equals(env, ctx.attr.expected_variant_flag or None, _flag_value(argv, "--variant"), "--variant of the ImageManifest action")
+    base_config = _flag_value(argv, "--base-config") or ""
+    asserts.true(env, base_config.endswith("/" + ctx.attr.expected_base_config), "--base-config is {}, want {}".format(base_config, ctx.attr.expected_base_config))
+    return analysistest.end(env)
+
+variant_test = analysistest.make(
+    _variant_test_impl,
+    attrs = {
+        "expected_base_config": attr.string(doc = "Basename of the config of the selected base image."),
+        "expected_variant": attr.string(doc = "Expected variant of the image, or empty if the image has no variant."),
+        "expected_variant_flag": attr.string(doc = "Expected value of --variant, or empty if the flag must not be set."),
+    },
+)
+
+def _missing_variant_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    asserts.expect_failure(env, "variant v3 and os")
+    return analysistest.end(env)
+
+missing_variant_test = analysistest.make(
+    _missing_variant_test_impl,
+    expect_failure = True,
+)
+
+def variant_test_suite(name):
+    """Creates manifests on bases with different variants and tests the selected base and the variant.
+
+    Args:
+        name: Name of the test suite.
+    """
+    for variant in ["v1", "v2"]:
+        image_empty_base(
+            name = "{}_base_{}".format(name, variant),
+            variant = variant,
+            tags = ["manual"],
+        )
+    image_index(
+        name = name + "_base_index",
+        manifests = [
+            ":{}_base_v1".format(name),
+            ":{}_base_v2".format(name),
+        ],
+        tags = ["manual"],
+    )
+
+    # the manifest with the variant is selected from the index
+    image_manifest(
+        name = name + "_select_manifest",
+        base = ":" + name + "_base_index",
+        variant = "v2",
+        tags = ["manual"],
+    )
+    variant_test(
+        name = name + "_select",
+        target_under_test = ":" + name + "_select_manifest",
+        expected_base_config = name + "_base_v2_config.json",
+        expected_variant = "v2",
+        expected_variant_flag = "v2",
+    )
+
+    # without the attribute, the variant of the base is inherited
+    image_manifest(
+        name = name + "_inherit_manifest",
+        base = ":" + name + "_base_v1",
+        tags = ["manual"],
+    )
+    variant_test(
+        name = name + "_inherit",
+        target_under_test = ":" + name + "_inherit_manifest",
+        expected_base_config = name + "_base_v1_config.json",
+        expected_variant = "v1",
+    )
+
+    image_manifest(
+        name = name + "_missing_manifest",
+        base = ":" + name + "_base_index",
+        variant = "v3",
+        tags = ["manual"],
+    )
+    missing_variant_test(
+        name = name + "_missing",
+        target_under_test = ":" + name + "_missing_manifest",
+    )
+
+    native.test_suite(
+        name = name,
+        tests = [
+            ":" + name + "_select",
+            ":" + name + "_inherit",
+            ":" + name + "_missing",
+        ],
+    )